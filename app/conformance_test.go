@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const conformanceCases = "testdata/conformance/cases.tsv"
+
+type conformanceCase struct {
+	line     int
+	database string
+	mode     string
+	commands []string
+}
+
+func loadConformanceCases(t *testing.T) []conformanceCase {
+	t.Helper()
+
+	file, err := os.Open(conformanceCases)
+	if err != nil {
+		t.Fatalf("opening conformance cases: %v", err)
+	}
+	defer file.Close()
+
+	var cases []conformanceCase
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(text, "\t", 3)
+		if len(fields) != 3 {
+			t.Fatalf("%s:%d: want 3 tab-separated fields, got %d", conformanceCases, line, len(fields))
+		}
+		if name, ok := strings.CutPrefix(fields[0], generatedPrefix); ok && conformanceFixtures[name] == nil {
+			t.Fatalf("%s:%d: no generated database %q", conformanceCases, line, name)
+		}
+		cases = append(cases, conformanceCase{line: line, database: fields[0], mode: fields[1], commands: strings.Split(fields[2], "\t")})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("reading conformance cases: %v", err)
+	}
+
+	return cases
+}
+
+func buildProgram(t *testing.T) string {
+	t.Helper()
+
+	binary := filepath.Join(t.TempDir(), "sqlite-go")
+	build := exec.Command("go", "build", "-o", binary, ".")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building program: %v\n%s", err, output)
+	}
+	return binary
+}
+
+func runCommand(t *testing.T, name string, args ...string) string {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// Not every sqlite3 build ships optional commands such as .dbinfo
+		if strings.Contains(stderr.String(), "unknown command") {
+			t.Skipf("%s does not support %q", filepath.Base(name), args)
+		}
+		t.Fatalf("running %s %q: %v\n%s", filepath.Base(name), args, err, stderr.String())
+	}
+	return strings.TrimRight(stdout.String(), "\n")
+}
+
+// TestConformanceWithSQLite3 diffs our output against the system sqlite3 for
+// every case in the corpus. It is skipped when sqlite3 is not installed.
+func TestConformanceWithSQLite3(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found in PATH")
+	}
+
+	cases := loadConformanceCases(t)
+	program := buildProgram(t)
+
+	for _, tc := range cases {
+		name := strings.Join(tc.commands, " ")
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join("..", tc.database)
+			if name, ok := strings.CutPrefix(tc.database, generatedPrefix); ok {
+				path = filepath.Join(t.TempDir(), name+".db")
+				conformanceFixtures[name](t, path)
+			}
+			args := append([]string{path}, tc.commands...)
+			got := runCommand(t, program, args...)
+			want := runCommand(t, sqlite3, args...)
+
+			if !outputsMatch(tc.mode, got, want) {
+				t.Errorf("%s:%d: %s output mismatch (mode %s)\n--- ours\n%s\n--- sqlite3\n%s", conformanceCases, tc.line, name, tc.mode, got, want)
+			}
+		})
+	}
+}
+
+func outputsMatch(mode, got, want string) bool {
+	switch mode {
+	case "exact":
+		return got == want
+	case "words":
+		gotWords, wantWords := strings.Fields(got), strings.Fields(want)
+		slices.Sort(gotWords)
+		slices.Sort(wantWords)
+		return slices.Equal(gotWords, wantWords)
+	case "keys":
+		gotValues, wantValues := keyValues(got), keyValues(want)
+		if len(gotValues) == 0 {
+			return false
+		}
+		for key, value := range gotValues {
+			if wantValues[key] != value {
+				return false
+			}
+		}
+		for key := range wantValues {
+			if _, ok := gotValues[key]; !ok && !unprintedKeys[key] {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// unprintedKeys are the keys of sqlite3's .dbinfo that .dbinfo does not
+// print yet, which keys mode lets our output leave out.
+var unprintedKeys = map[string]bool{
+	"write format":        true,
+	"read format":         true,
+	"reserved bytes":      true,
+	"file change counter": true,
+	"schema cookie":       true,
+	"schema format":       true,
+	"default cache size":  true,
+	"autovacuum top root": true,
+	"incremental vacuum":  true,
+	"text encoding":       true,
+	"user version":        true,
+	"application id":      true,
+	"software version":    true,
+	"number of indexes":   true,
+	"number of triggers":  true,
+	"number of views":     true,
+	"schema size":         true,
+	"data version":        true,
+}
+
+// keyValues parses "key: value" lines, ignoring the alignment padding sqlite3
+// puts between the two.
+func keyValues(output string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+func TestOutputsMatchModes(t *testing.T) {
+	dbinfo := "database page size:  4096\nwrite format:        1\ndatabase page count: 2\nfreelist page count: 0\nnumber of tables:    3"
+
+	tests := []struct {
+		mode, got, want string
+		match           bool
+	}{
+		{"exact", "4", "4", true},
+		{"exact", "4", "4.0", false},
+		{"words", "apples\noranges", "oranges  apples", true},
+		{"words", "apples", "apples oranges", false},
+		{"keys", "database page size: 4096\ndatabase page count: 2\nfreelist page count: 0\nnumber of tables: 3", dbinfo, true},
+		{"keys", "database page size: 4096\nnumber of tables: 3", dbinfo, false},
+		{"keys", "number of tables: 2", dbinfo, false},
+		{"keys", "", dbinfo, false},
+		{"keys", "database page size: 4096\nnumber of tables: 3", "database page size: 4096\nschema size: 120\nnumber of tables: 3", true},
+		{"bogus", "", "", false},
+	}
+
+	for _, tt := range tests {
+		if match := outputsMatch(tt.mode, tt.got, tt.want); match != tt.match {
+			t.Errorf("outputsMatch(%q, %q, %q) = %v, want %v", tt.mode, tt.got, tt.want, match, tt.match)
+		}
+	}
+}
@@ -1,33 +1,204 @@
 package main
 
 import (
-	"log"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/codecrafters-io/sqlite-starter-go/internal/cli"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
 )
 
-// Usage: your_program.sh sample.db <command>
+// Usage: your_program.sh [options] [sample.db] [<command>...]
+//
+// Options are those of sqlite3 where it has them, such as -cmd, -batch and
+// -version, and may come before or after the database, with one dash or
+// two. Commands run in order, so settings such as ".nullvalue NULL" apply
+// to the queries that follow them, and one may hold several statements
+// separated by semicolons. Before them the defaults set by SQLITE_MODE,
+// SQLITE_NULLVALUE, SQLITE_HEADERS and SQLITE_TIMER apply, then the
+// commands of the init file, of which those that fail are reported and
+// skipped, then those given with -cmd. With no commands they are read from
+// standard input: a line at a time with a prompt when it is a terminal, or
+// -interactive is given, and as a script otherwise. With no database an
+// empty one is opened in memory.
+//
+// Usage: your_program.sh serve [flags] sample.db
+//
+// serves the database over HTTP instead; see serve.
 func main() {
-	if len(os.Args) < 3 {
-		log.Fatalf("usage: %s <database> <command>", os.Args[0])
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
 	}
+	busyTimeout := flag.Int("busy-timeout", 0, "milliseconds to wait for other processes' locks before failing")
+	verify := flag.Bool("verify", false, "check each SELECT against SQLite (builds with -tags verify)")
+	maxRows := flag.Int64("max-rows", 0, "fail a query that returns more rows than this (0 for no limit)")
+	maxResultBytes := flag.Int64("max-result-bytes", 0, "fail a query whose values total more bytes than this (0 for no limit)")
+	readOnlySQL := flag.Bool("readonly-sql", false, "reject any statement other than SELECT, EXPLAIN and PRAGMAs that do not write")
+	permissive := flag.Bool("permissive", false, "skip rows whose cells cannot be decoded, logging a warning with the page and cell of each, instead of failing the query")
+	passphrase := flag.String("passphrase", "", "read the database as an SQLCipher one encrypted with this passphrase")
+	cipherCompatibility := flag.Int("cipher-compatibility", 4, "SQLCipher major version, 3 or 4, whose default settings encrypted the database")
+	verifyChecksums := flag.Bool("verify-checksums", false, "check write-ahead log frames and hot journal records against their checksums as they are read, failing on a mismatch")
+	logLevel := flag.String("log-level", "info", "lowest level of records logged to stderr: debug, info, warn or error")
+	initFile := flag.String("init", "", "read commands from this file before the others, in place of $SQLITERC or ~/.sqliterc")
+	errorFormat := flag.String("error-format", "text", "how failures are reported on stderr: text, or json for a {code, message, context} object")
+	var preCommands []string
+	flag.Func("cmd", "run this command after the init file and before the others; may be given more than once", func(command string) error {
+		preCommands = append(preCommands, command)
+		return nil
+	})
+	unicode := flag.Bool("unicode", false, "have upper() and lower() map the case of letters in every script, and LIKE match them in either case a character at a time, as SQLite built with ICU does, rather than ASCII letters only")
+	var seed *int64
+	flag.Func("seed", "seed random() and randomblob() with this integer, so that they return the same values on every run", func(text string) error {
+		value, err := strconv.ParseInt(text, 10, 64)
+		seed = &value
+		return err
+	})
+	batch := flag.Bool("batch", false, "read standard input as a script even when it is a terminal")
+	interactive := flag.Bool("interactive", false, "read standard input a line at a time, with prompts, even when it is not a terminal")
+	version := flag.Bool("version", false, "print the SQLite version whose file format is written, and exit")
+	args := parseArgs(flag.CommandLine, os.Args[1:])
 
-	databaseFilePath := os.Args[1]
-	command := os.Args[2]
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fatal(slog.Default(), "invalid --log-level", "error", err)
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if *errorFormat != "text" && *errorFormat != "json" {
+		fatal(logger, "invalid --error-format", "format", *errorFormat)
+	}
+	jsonErrors := *errorFormat == "json"
+	if *version {
+		fmt.Println(db.LibraryVersion())
+		return
+	}
+	if *batch && *interactive {
+		fatal(logger, "-batch and -interactive cannot be combined")
+	}
 
-	var err error
+	path, commands := ":memory:", []string(nil)
+	if len(args) > 0 {
+		path, commands = args[0], args[1:]
+	}
+	session := cli.NewSession(path)
+	session.BusyTimeout = time.Duration(*busyTimeout) * time.Millisecond
+	session.Verify = *verify
+	session.Limits = engine.Limits{MaxRows: *maxRows, MaxResultBytes: *maxResultBytes}
+	session.ReadOnlySQL = *readOnlySQL
+	session.Permissive = *permissive
+	session.VerifyChecksums = *verifyChecksums
+	session.Passphrase = *passphrase
+	session.CipherCompatibility = *cipherCompatibility
+	session.Logger = logger
+	session.Unicode = *unicode
+	session.RandomSeed = seed
+	if err := session.ApplyEnvironment(os.Getenv); err != nil {
+		fail(logger, jsonErrors, err)
+	}
+	initPath, required := *initFile, *initFile != ""
+	if !required {
+		initPath = cli.DefaultInitFile(os.Getenv)
+	}
+	if err := session.RunInitFile(initPath, required, func(err error) { report(logger, jsonErrors, err) }); err != nil {
+		finish(session, logger, jsonErrors, err)
+	}
+	for _, command := range append(preCommands, commands...) {
+		if err := session.RunArgument(command); err != nil {
+			finish(session, logger, jsonErrors, err, "command", command)
+		}
+	}
 
-	switch command {
-	case ".dbinfo":
-		err = cli.HandleDBInfo(databaseFilePath)
-	case ".tables":
-		err = cli.HandleTables(databaseFilePath)
+	var err error
+	switch {
+	case len(commands) > 0:
+	case *interactive || !*batch && isTerminal(os.Stdin):
+		err = session.RunInteractive(os.Stdin, os.Stdout, func(err error) { report(logger, jsonErrors, err) })
 	default:
-		err = cli.HandleQuery(databaseFilePath, command)
+		err = session.RunBatch(os.Stdin)
 	}
+	finish(session, logger, jsonErrors, err)
+}
 
+// parseArgs parses the flags in arguments wherever they are among the
+// others, as sqlite3 takes options after the database as well as before,
+// and returns the others in order. Everything after "--" is one of the
+// others.
+func parseArgs(flags *flag.FlagSet, arguments []string) []string {
+	var others []string
+	for {
+		// Parse exits on an error, as the flag set is made to
+		flags.Parse(arguments)
+		rest := flags.Args()
+		if consumed := arguments[:len(arguments)-len(rest)]; len(consumed) > 0 && consumed[len(consumed)-1] == "--" {
+			return append(others, rest...)
+		}
+		if len(rest) == 0 {
+			return others
+		}
+		others, arguments = append(others, rest[0]), rest[1:]
+	}
+}
+
+// isTerminal reports whether file is a terminal rather than a pipe or a
+// regular file.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// finish closes the session and exits: with status 0 when err is nil or
+// .quit's, and after reporting err with status 1 otherwise.
+func finish(session *cli.Session, logger *slog.Logger, jsonErrors bool, err error, args ...any) {
+	closeErr := session.Close()
+	if err == nil || errors.Is(err, cli.ErrQuit) {
+		err = closeErr
+	}
 	if err != nil {
-		log.Fatal(err)
+		fail(logger, jsonErrors, err, args...)
 	}
+	os.Exit(0)
+}
+
+// fail reports err and exits with status 1: as a JSON error report when
+// jsonErrors is set, with the key-value pairs in args added to its context,
+// and as a log record otherwise. A message of several lines, such as a
+// syntax error's with the statement marked, is logged by its first line,
+// and the rest is written after the record as it is.
+func fail(logger *slog.Logger, jsonErrors bool, err error, args ...any) {
+	report(logger, jsonErrors, err, args...)
+	os.Exit(1)
+}
+
+// report writes err to stderr as fail does, without exiting, for the
+// interactive shell to carry on past it.
+func report(logger *slog.Logger, jsonErrors bool, err error, args ...any) {
+	if !jsonErrors {
+		message, detail, _ := strings.Cut(err.Error(), "\n")
+		logger.Error(message, args...)
+		if detail != "" {
+			fmt.Fprintln(os.Stderr, detail)
+		}
+		return
+	}
+	errorReport := cli.NewErrorReport(err)
+	for i := 0; i+1 < len(args); i += 2 {
+		errorReport.Context[args[i].(string)] = args[i+1]
+	}
+	if json.NewEncoder(os.Stderr).Encode(errorReport) != nil {
+		logger.Error(err.Error(), args...)
+	}
+}
+
+// fatal logs an error and exits with status 1.
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseArgsTakesFlagsAnywhere(t *testing.T) {
+	tests := []struct {
+		arguments []string
+		batch     bool
+		commands  []string
+		others    []string
+	}{
+		{[]string{"sample.db", ".tables"}, false, nil, []string{"sample.db", ".tables"}},
+		{[]string{"-batch", "-cmd", ".mode csv", "sample.db"}, true, []string{".mode csv"}, []string{"sample.db"}},
+		{[]string{"sample.db", "--cmd", ".mode csv", "SELECT 1", "-batch", "-cmd=.nullvalue X"}, true, []string{".mode csv", ".nullvalue X"}, []string{"sample.db", "SELECT 1"}},
+		{[]string{"-batch", "--", "sample.db", "-cmd"}, true, nil, []string{"sample.db", "-cmd"}},
+		{nil, false, nil, nil},
+	}
+	for _, tt := range tests {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		batch := flags.Bool("batch", false, "")
+		var commands []string
+		flags.Func("cmd", "", func(command string) error {
+			commands = append(commands, command)
+			return nil
+		})
+		others := parseArgs(flags, tt.arguments)
+		if *batch != tt.batch || !reflect.DeepEqual(commands, tt.commands) || !reflect.DeepEqual(others, tt.others) {
+			t.Errorf("parseArgs(%q): batch %v, -cmd %q, others %q", tt.arguments, *batch, commands, others)
+		}
+	}
+}
+
+func TestInitFileAndSettings(t *testing.T) {
+	program := buildProgram(t)
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, ".sqliterc"), []byte(".headers on\n.bogus\n.mode csv\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run := func(stdin string, args ...string) (string, string) {
+		t.Helper()
+		cmd := exec.Command(program, args...)
+		cmd.Env = append(os.Environ(), "HOME="+home, "SQLITERC=")
+		cmd.Stdin = strings.NewReader(stdin)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("%q: %v\n%s", args, err, stderr.String())
+		}
+		return stdout.String(), stderr.String()
+	}
+
+	// The line the shell does not know is reported, and the rest of the
+	// init file and the command still run
+	stdout, stderr := run("", filepath.Join("..", "sample.db"), "SELECT id, name FROM apples WHERE id = 1")
+	if stdout != "id,name\r\n1,\"Granny Smith\"\r\n" {
+		t.Errorf("stdout %q", stdout)
+	}
+	if !strings.Contains(stderr, "near line 2: unknown command: .bogus") {
+		t.Errorf("stderr %q", stderr)
+	}
+
+	// Statements read from input are timed, dot-commands are not
+	stdout, _ = run(".headers off\n.timer on\nSELECT 1;\n.mode list\n")
+	if !regexp.MustCompile(`^1\r\nRun Time: real \d+\.\d{3} user \d+\.\d{6} sys \d+\.\d{6}\n$`).MatchString(stdout) {
+		t.Errorf("timed stdout %q", stdout)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/server"
+)
+
+// serve runs the serve subcommand, answering POST /query on the database
+// named in args until it is stopped:
+//
+//	your_program.sh serve [--listen addr] [--max-connections n] [--max-rows n] [--max-result-bytes n] [--timeout ms] [--busy-timeout ms] [--log-level level] sample.db
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := flags.String("listen", "localhost:8080", "address to serve HTTP on")
+	maxConnections := flags.Int("max-connections", 0, "most handles on the database open at once, and so queries running at once (0 for one per CPU)")
+	maxRows := flags.Int64("max-rows", 10000, "fail a query that returns more rows than this (0 for no limit)")
+	maxResultBytes := flags.Int64("max-result-bytes", 64<<20, "fail a query whose values total more bytes than this (0 for no limit)")
+	timeout := flags.Int("timeout", 5000, "milliseconds a query may run, waiting for its turn included, before it fails (0 for no limit)")
+	busyTimeout := flags.Int("busy-timeout", 0, "milliseconds to wait for other processes' locks before failing")
+	logLevel := flags.String("log-level", "info", "lowest level of records logged to stderr: debug, info, warn or error")
+	flags.Parse(args)
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fatal(slog.Default(), "invalid --log-level", "error", err)
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	if flags.NArg() != 1 {
+		fatal(logger, "usage: "+os.Args[0]+" serve [--listen addr] [--max-connections n] [--max-rows n] [--max-result-bytes n] [--timeout ms] [--busy-timeout ms] [--log-level level] <database>")
+	}
+
+	pool, err := engine.NewPool(*maxConnections, func() (*engine.Database, error) {
+		database, err := engine.OpenReadOnly(flags.Arg(0))
+		if err != nil {
+			return nil, err
+		}
+		database.SetLogger(logger)
+		database.SetBusyTimeout(time.Duration(*busyTimeout) * time.Millisecond)
+		return database, nil
+	})
+	if err != nil {
+		fatal(logger, err.Error(), "database", flags.Arg(0))
+	}
+	defer pool.Close()
+
+	handler := server.New(pool, server.Options{
+		Limits:  engine.Limits{MaxRows: *maxRows, MaxResultBytes: *maxResultBytes},
+		Timeout: time.Duration(*timeout) * time.Millisecond,
+		Logger:  logger,
+	})
+	logger.Info("serving", "database", flags.Arg(0), "address", *listen)
+	httpServer := &http.Server{Addr: *listen, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		pool.Close()
+		fatal(logger, err.Error(), "address", *listen)
+	}
+}
@@ -0,0 +1,170 @@
+package arrow
+
+import "encoding/binary"
+
+// The few flatbuffers Arrow's messages need are written by hand, front to
+// back: each table's vtable comes just before it, and the strings, vectors
+// and tables it refers to after it, as offsets must point forward.
+
+// fbObject is anything a table field can refer to by offset.
+type fbObject interface {
+	// write appends the object at an aligned position and returns it
+	write(b *fbBuilder) int
+}
+
+// fbField is one field of a table: little-endian scalar bytes stored
+// inline, or an object. The zero fbField is absent, so takes its default.
+type fbField struct {
+	scalar []byte
+	object fbObject
+}
+
+func (field fbField) width() int {
+	if field.object != nil {
+		return 4
+	}
+	return len(field.scalar)
+}
+
+func fbBool(v bool) fbField {
+	if v {
+		return fbField{scalar: []byte{1}}
+	}
+	return fbField{scalar: []byte{0}}
+}
+
+func fbUint8(v uint8) fbField {
+	return fbField{scalar: []byte{v}}
+}
+
+func fbInt16(v int16) fbField {
+	return fbField{scalar: binary.LittleEndian.AppendUint16(nil, uint16(v))}
+}
+
+func fbInt32(v int32) fbField {
+	return fbField{scalar: binary.LittleEndian.AppendUint32(nil, uint32(v))}
+}
+
+func fbInt64(v int64) fbField {
+	return fbField{scalar: binary.LittleEndian.AppendUint64(nil, uint64(v))}
+}
+
+func fbRef(object fbObject) fbField {
+	return fbField{object: object}
+}
+
+// fbTable is a table, its fields indexed by their ids.
+type fbTable []fbField
+
+// fbString is a string.
+type fbString string
+
+// fbTables is a vector of tables.
+type fbTables []fbTable
+
+// fbStructs is a vector of structs of 8-byte-aligned fields, such as
+// Arrow's FieldNode and Buffer, already encoded.
+type fbStructs struct {
+	count int
+	data  []byte
+}
+
+type fbBuilder struct {
+	buf []byte
+}
+
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) putOffset(at, target int) {
+	binary.LittleEndian.PutUint32(b.buf[at:], uint32(target-at))
+}
+
+func (table fbTable) write(b *fbBuilder) int {
+	// The inline fields follow the offset to the vtable, widest first so
+	// that each is aligned once the table is aligned to 8
+	offsets := make([]int, len(table))
+	size := 4
+	for _, width := range []int{8, 4, 2, 1} {
+		for i, field := range table {
+			if field.width() == width {
+				size = (size + width - 1) / width * width
+				offsets[i] = size
+				size += width
+			}
+		}
+	}
+
+	b.pad(2)
+	vtable := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(4+2*len(table)))
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(size))
+	for _, offset := range offsets {
+		b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(offset))
+	}
+	b.pad(8)
+	start := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[start:], uint32(int32(start-vtable)))
+	for i, field := range table {
+		if field.scalar != nil {
+			copy(b.buf[start+offsets[i]:], field.scalar)
+		}
+	}
+	for i, field := range table {
+		if field.object != nil {
+			b.putOffset(start+offsets[i], field.object.write(b))
+		}
+	}
+	return start
+}
+
+func (s fbString) write(b *fbBuilder) int {
+	b.pad(4)
+	start := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(s)))
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, 0)
+	return start
+}
+
+func (tables fbTables) write(b *fbBuilder) int {
+	b.pad(4)
+	start := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(tables)))
+	b.buf = append(b.buf, make([]byte, 4*len(tables))...)
+	for i, table := range tables {
+		b.putOffset(start+4+4*i, table.write(b))
+	}
+	return start
+}
+
+func (structs fbStructs) write(b *fbBuilder) int {
+	// The length comes just before the first element, which is aligned
+	for (len(b.buf)+4)%8 != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	start := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(structs.count))
+	b.buf = append(b.buf, structs.data...)
+	return start
+}
+
+// finish returns the flatbuffer whose root is table, padded to 8 bytes.
+func finish(root fbTable) []byte {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	b.putOffset(0, root.write(b))
+	b.pad(8)
+	return b.buf
+}
+
+// appendLongs appends 64-bit integers as a struct vector's data takes them.
+func appendLongs(data []byte, values ...int64) []byte {
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint64(data, uint64(v))
+	}
+	return data
+}
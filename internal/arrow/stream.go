@@ -0,0 +1,189 @@
+// Package arrow writes results in the Arrow IPC streaming format, column by
+// column, for clients such as pyarrow, polars and DuckDB that read record
+// batches without parsing rows of JSON. Only what results need is
+// written: one schema of nullable columns, each 64-bit integers, doubles,
+// UTF-8 strings, binary or nulls, and uncompressed record batches.
+package arrow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// MediaType is the media type of an Arrow IPC stream.
+const MediaType = "application/vnd.apache.arrow.stream"
+
+// maxBatchRows is how many rows WriteResults puts in each record batch.
+const maxBatchRows = 64 * 1024
+
+// The numbers Arrow's Message.fbs and Schema.fbs give the metadata version,
+// message headers, types and precisions written here.
+const (
+	metadataV5 = 4
+
+	headerSchema      = 1
+	headerRecordBatch = 3
+
+	typeNull          = 1
+	typeInt           = 2
+	typeFloatingPoint = 3
+	typeBinary        = 4
+	typeUtf8          = 5
+
+	precisionDouble = 2
+)
+
+// continuation marks the start of each message in a stream.
+const continuation = 0xFFFFFFFF
+
+// StreamWriter writes a stream of record batches sharing one schema.
+type StreamWriter struct {
+	w     io.Writer
+	types []db.ValueType
+}
+
+// NewStreamWriter starts a stream on w with the schema of columns named
+// names, holding values of types: INTEGER as int64, REAL as double, TEXT
+// as utf8, BLOB as binary, and a column of NULLs alone as null. A column
+// of mixed values has no one Arrow type, so must be converted first.
+func NewStreamWriter(w io.Writer, names []string, types []db.ValueType) (*StreamWriter, error) {
+	fields := make(fbTables, len(names))
+	for i, name := range names {
+		if types[i] > db.BlobValues {
+			return nil, fmt.Errorf("column %s: no Arrow type holds %s values", name, types[i])
+		}
+		typeID, typeTable := arrowType(types[i])
+		fields[i] = fbTable{fbRef(fbString(name)), fbBool(true), fbUint8(typeID), fbRef(typeTable), {}, fbRef(fbTables{})}
+	}
+	writer := &StreamWriter{w: w, types: types}
+	if err := writer.message(headerSchema, fbTable{{}, fbRef(fields)}, nil); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// arrowType returns the Type union member a column of valueType is.
+func arrowType(valueType db.ValueType) (uint8, fbTable) {
+	switch valueType {
+	case db.IntegerValues:
+		return typeInt, fbTable{fbInt32(64), fbBool(true)}
+	case db.RealValues:
+		return typeFloatingPoint, fbTable{fbInt16(precisionDouble)}
+	case db.TextValues:
+		return typeUtf8, fbTable{}
+	case db.BlobValues:
+		return typeBinary, fbTable{}
+	}
+	return typeNull, fbTable{}
+}
+
+// Write writes batch as a record batch. Its columns must be of the types
+// the stream was started with.
+func (writer *StreamWriter) Write(batch *db.Batch) error {
+	if len(batch.Columns) != len(writer.types) {
+		return fmt.Errorf("arrow: batch of %d columns in a stream of %d", len(batch.Columns), len(writer.types))
+	}
+	var body, nodes, buffers []byte
+	buffer := func(data []byte) {
+		buffers = appendLongs(buffers, int64(len(body)), int64(len(data)))
+		body = append(body, data...)
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+	}
+	for i, vector := range batch.Columns {
+		if vector.Type != writer.types[i] {
+			return fmt.Errorf("arrow: column %d holds %v in a stream of %v", i, vector.Type, writer.types[i])
+		}
+		// A null column has no buffers, and every row NULL
+		nulls := vector.NullCount()
+		nodes = appendLongs(nodes, int64(vector.Len), int64(nulls))
+		if vector.Type == db.NullValues {
+			continue
+		}
+		if nulls == 0 {
+			buffer(nil)
+		} else {
+			buffer(vector.Valid)
+		}
+
+		switch vector.Type {
+		case db.IntegerValues:
+			data := make([]byte, 0, 8*vector.Len)
+			for _, v := range vector.Integers {
+				data = binary.LittleEndian.AppendUint64(data, uint64(v))
+			}
+			buffer(data)
+		case db.RealValues:
+			data := make([]byte, 0, 8*vector.Len)
+			for _, v := range vector.Reals {
+				data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+			}
+			buffer(data)
+		case db.TextValues, db.BlobValues:
+			offsets := make([]byte, 4, 4*(vector.Len+1))
+			var data []byte
+			for row := range vector.Len {
+				if vector.Type == db.TextValues {
+					data = append(data, vector.Texts[row]...)
+				} else {
+					data = append(data, vector.Blobs[row]...)
+				}
+				if len(data) > math.MaxInt32 {
+					return fmt.Errorf("arrow: column %d holds more than 2 GiB in one batch", i)
+				}
+				offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(data)))
+			}
+			buffer(offsets)
+			buffer(data)
+		}
+	}
+
+	recordBatch := fbTable{
+		fbInt64(int64(batch.Len)),
+		fbRef(fbStructs{count: len(nodes) / 16, data: nodes}),
+		fbRef(fbStructs{count: len(buffers) / 16, data: buffers}),
+	}
+	return writer.message(headerRecordBatch, recordBatch, body)
+}
+
+// Close ends the stream, without closing the writer under it.
+func (writer *StreamWriter) Close() error {
+	_, err := writer.w.Write(binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, continuation), 0))
+	return err
+}
+
+// message writes an encapsulated message: the continuation marker, the
+// length of the metadata, the Message flatbuffer, padded to 8 bytes, and
+// the body.
+func (writer *StreamWriter) message(headerType uint8, header fbTable, body []byte) error {
+	metadata := finish(fbTable{fbInt16(metadataV5), fbUint8(headerType), fbRef(header), fbInt64(int64(len(body)))})
+	prefix := binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, continuation), uint32(len(metadata)))
+	for _, part := range [][]byte{prefix, metadata, body} {
+		if _, err := writer.w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteResults writes rows, of columns named names, as a stream: each
+// column's type is the one ValueTypes picks for all of its values, and the
+// rows go in record batches of up to 65536.
+func WriteResults(w io.Writer, names []string, rows [][]any) error {
+	types := db.ValueTypes(len(names), rows)
+	writer, err := NewStreamWriter(w, names, types)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(rows); start += maxBatchRows {
+		if err := writer.Write(db.NewBatch(types, rows[start:min(start+maxBatchRows, len(rows))])); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
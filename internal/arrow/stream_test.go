@@ -0,0 +1,278 @@
+package arrow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// fbReader reads the fields of a flatbuffer table, as Arrow's generated
+// readers do, to check the messages without depending on them.
+type fbReader struct {
+	t   *testing.T
+	buf []byte
+	pos int
+}
+
+func (r fbReader) u32(at int) int {
+	return int(binary.LittleEndian.Uint32(r.buf[at:]))
+}
+
+// field returns where field id is stored, or false when it is absent.
+func (r fbReader) field(id int) (int, bool) {
+	if r.pos%4 != 0 {
+		r.t.Errorf("table at %d is not aligned", r.pos)
+	}
+	vtable := r.pos - int(int32(binary.LittleEndian.Uint32(r.buf[r.pos:])))
+	if 4+2*id >= int(binary.LittleEndian.Uint16(r.buf[vtable:])) {
+		return 0, false
+	}
+	offset := int(binary.LittleEndian.Uint16(r.buf[vtable+4+2*id:]))
+	return r.pos + offset, offset != 0
+}
+
+func (r fbReader) scalar(id int, size int) uint64 {
+	at, ok := r.field(id)
+	if !ok {
+		return 0
+	}
+	if at%size != 0 {
+		r.t.Errorf("field %d at %d is not aligned to %d", id, at, size)
+	}
+	var v [8]byte
+	copy(v[:], r.buf[at:at+size])
+	return binary.LittleEndian.Uint64(v[:])
+}
+
+func (r fbReader) target(id int) int {
+	at, ok := r.field(id)
+	if !ok {
+		r.t.Fatalf("field %d absent", id)
+	}
+	return at + r.u32(at)
+}
+
+func (r fbReader) table(id int) fbReader {
+	return fbReader{r.t, r.buf, r.target(id)}
+}
+
+func (r fbReader) string(id int) string {
+	at := r.target(id)
+	return string(r.buf[at+4 : at+4+r.u32(at)])
+}
+
+// tables returns the tables of a vector field.
+func (r fbReader) tables(id int) []fbReader {
+	at := r.target(id)
+	tables := make([]fbReader, r.u32(at))
+	for i := range tables {
+		slot := at + 4 + 4*i
+		tables[i] = fbReader{r.t, r.buf, slot + r.u32(slot)}
+	}
+	return tables
+}
+
+// longs returns the 64-bit integers of a vector of structs.
+func (r fbReader) longs(id int, perStruct int) []int64 {
+	at := r.target(id)
+	if (at+4)%8 != 0 {
+		r.t.Errorf("struct vector %d is not aligned", id)
+	}
+	longs := make([]int64, r.u32(at)*perStruct)
+	for i := range longs {
+		longs[i] = int64(binary.LittleEndian.Uint64(r.buf[at+4+8*i:]))
+	}
+	return longs
+}
+
+type field struct {
+	name     string
+	typeID   uint8
+	nullable bool
+}
+
+// readStream decodes a stream's schema and the rows of its batches.
+func readStream(t *testing.T, stream []byte) ([]field, [][][]any) {
+	t.Helper()
+	var fields []field
+	var batches [][][]any
+	for {
+		if marker := binary.LittleEndian.Uint32(stream); marker != continuation {
+			t.Fatalf("message starts with %#x", marker)
+		}
+		length := int(binary.LittleEndian.Uint32(stream[4:]))
+		if length == 0 {
+			if len(stream) != 8 {
+				t.Errorf("%d bytes after the end of the stream", len(stream)-8)
+			}
+			return fields, batches
+		}
+		if length%8 != 0 {
+			t.Errorf("metadata of %d bytes", length)
+		}
+		metadata := stream[8 : 8+length]
+		message := fbReader{t, metadata, int(binary.LittleEndian.Uint32(metadata))}
+		if version := message.scalar(0, 2); version != metadataV5 {
+			t.Errorf("metadata version %d", version)
+		}
+		bodyLength := int(message.scalar(3, 8))
+		body := stream[8+length : 8+length+bodyLength]
+		stream = stream[8+length+bodyLength:]
+
+		header := message.table(2)
+		switch message.scalar(1, 1) {
+		case headerSchema:
+			for _, f := range header.tables(1) {
+				fields = append(fields, field{f.string(0), uint8(f.scalar(2, 1)), f.scalar(1, 1) == 1})
+				if len(f.tables(5)) != 0 {
+					t.Errorf("field %s has children", f.string(0))
+				}
+				switch f.scalar(2, 1) {
+				case typeInt:
+					if typ := f.table(3); typ.scalar(0, 4) != 64 || typ.scalar(1, 1) != 1 {
+						t.Errorf("field %s is not int64", f.string(0))
+					}
+				case typeFloatingPoint:
+					if f.table(3).scalar(0, 2) != precisionDouble {
+						t.Errorf("field %s is not a double", f.string(0))
+					}
+				}
+			}
+		case headerRecordBatch:
+			batches = append(batches, readBatch(t, fields, header, body))
+		default:
+			t.Fatalf("message header %d", message.scalar(1, 1))
+		}
+	}
+}
+
+func readBatch(t *testing.T, fields []field, batch fbReader, body []byte) [][]any {
+	t.Helper()
+	length := int(batch.scalar(0, 8))
+	nodes, buffers := batch.longs(1, 2), batch.longs(2, 2)
+	buffer := func() []byte {
+		offset, size := buffers[0], buffers[1]
+		buffers = buffers[2:]
+		if offset%8 != 0 {
+			t.Errorf("buffer at %d is not aligned", offset)
+		}
+		return body[offset : offset+size]
+	}
+	rows := make([][]any, length)
+	for i := range rows {
+		rows[i] = make([]any, len(fields))
+	}
+	for column, f := range fields {
+		if nodes[2*column] != int64(length) {
+			t.Errorf("column %s has %d rows, not %d", f.name, nodes[2*column], length)
+		}
+		if f.typeID == typeNull {
+			continue
+		}
+		valid := buffer()
+		null := func(row int) bool { return len(valid) > 0 && valid[row/8]&(1<<(row%8)) == 0 }
+		var offsets, data []byte
+		data = buffer()
+		if f.typeID == typeUtf8 || f.typeID == typeBinary {
+			offsets, data = data, buffer()
+		}
+		nulls := 0
+		for row := range length {
+			if null(row) {
+				nulls++
+				continue
+			}
+			switch f.typeID {
+			case typeInt:
+				rows[row][column] = int64(binary.LittleEndian.Uint64(data[8*row:]))
+			case typeFloatingPoint:
+				rows[row][column] = math.Float64frombits(binary.LittleEndian.Uint64(data[8*row:]))
+			case typeUtf8, typeBinary:
+				value := data[binary.LittleEndian.Uint32(offsets[4*row:]):binary.LittleEndian.Uint32(offsets[4*row+4:])]
+				if f.typeID == typeUtf8 {
+					rows[row][column] = string(value)
+				} else {
+					rows[row][column] = bytes.Clone(value)
+				}
+			}
+		}
+		if int64(nulls) != nodes[2*column+1] {
+			t.Errorf("column %s has %d NULLs, its node says %d", f.name, nulls, nodes[2*column+1])
+		}
+	}
+	return rows
+}
+
+func TestWriteResults(t *testing.T) {
+	names := []string{"id", "price", "label", "data", "nothing"}
+	rows := [][]any{
+		{int64(1), 2.5, "one", []byte{0, 1}, nil},
+		{nil, int64(3), int64(2), "text", nil},
+		{int64(-1 << 62), nil, 0.5, nil, nil},
+	}
+	var stream bytes.Buffer
+	if err := WriteResults(&stream, names, rows); err != nil {
+		t.Fatal(err)
+	}
+	fields, batches := readStream(t, stream.Bytes())
+
+	wantFields := []field{
+		{"id", typeInt, true}, {"price", typeFloatingPoint, true}, {"label", typeUtf8, true},
+		{"data", typeBinary, true}, {"nothing", typeNull, true},
+	}
+	if !reflect.DeepEqual(fields, wantFields) {
+		t.Errorf("fields %v, want %v", fields, wantFields)
+	}
+	// Values convert to their column's type as SQLite converts them
+	want := [][][]any{{
+		{int64(1), 2.5, "one", []byte{0, 1}, nil},
+		{nil, 3.0, "2", []byte("text"), nil},
+		{int64(-1 << 62), nil, "0.5", nil, nil},
+	}}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches %v, want %v", batches, want)
+	}
+}
+
+func TestStreamWriterBatches(t *testing.T) {
+	types := []db.ValueType{db.IntegerValues, db.TextValues}
+	var stream bytes.Buffer
+	writer, err := NewStreamWriter(&stream, []string{"n", "s"}, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want [][][]any
+	for start := int64(0); start < 30; start += 10 {
+		var rows [][]any
+		for n := start; n < start+10; n++ {
+			rows = append(rows, []any{n, string(rune('a' + n))})
+		}
+		if err := writer.Write(db.NewBatch(types, rows)); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, rows)
+	}
+	if err := writer.Write(db.NewBatch([]db.ValueType{db.RealValues, db.TextValues}, nil)); err == nil {
+		t.Error("wrote a batch of other types")
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, batches := readStream(t, stream.Bytes())
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches %v, want %v", batches, want)
+	}
+	// A stream of no rows is its schema alone
+	stream.Reset()
+	if err := WriteResults(&stream, []string{"x"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fields, batches := readStream(t, stream.Bytes()); len(fields) != 1 || len(batches) != 0 {
+		t.Errorf("empty results: %v %v", fields, batches)
+	}
+}
@@ -1,51 +1,408 @@
 package cli
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
 	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
 )
 
-func HandleDBInfo(path string) error {
-	dbHeader, schemaPage, err := db.LoadPage(path, 1)
+func HandleDBInfo(database *engine.Database) error {
+	objects, err := database.SchemaObjects()
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("database page size: %d\n", dbHeader.PageSize)
-	fmt.Printf("number of tables: %d", schemaPage.CellCount)
+	header := database.Header()
+	fmt.Printf("database page size: %d\n", header.PageSize)
+	fmt.Printf("database page count: %d\n", header.PageCount)
+	fmt.Printf("freelist page count: %d\n", header.FreelistCount)
+	tables := 0
+	for _, object := range objects {
+		if object.Type == "table" {
+			tables++
+		}
+	}
+	fmt.Printf("number of tables: %d", tables)
 	return nil
 }
 
-func HandleTables(path string) error {
-	_, schemaPage, err := db.LoadPage(path, 1)
+func HandleTables(database *engine.Database) error {
+	objects, err := database.SchemaObjects()
 	if err != nil {
 		return err
 	}
 
-	names, err := db.ExtractTableNames(schemaPage)
+	for _, name := range db.ExtractTableNames(objects) {
+		fmt.Println(name)
+	}
+	// Temporary tables are listed after, qualified as sqlite3 lists them
+	temp, err := database.TempSchemaObjects()
 	if err != nil {
 		return err
 	}
-
-	for _, name := range names {
-		fmt.Println(name)
+	for _, name := range db.ExtractTableNames(temp) {
+		fmt.Println("temp." + name)
 	}
 	return nil
 }
 
-func HandleQuery(path, query string) error {
-	tableName, err := engine.TableNameFromQuery(query)
+// HandleDatabases lists each database of the connection with its schema
+// name, file and whether it can be written, as sqlite3's .databases does.
+func HandleDatabases(database *engine.Database) error {
+	resultSet, err := database.Query("PRAGMA database_list")
+	if err != nil {
+		return err
+	}
+	rows, err := resultSet.All()
 	if err != nil {
 		return err
 	}
 
-	count, err := engine.RowCount(path, tableName)
+	access := "r/w"
+	if database.ReadOnly() {
+		access = "r/o"
+	}
+	for _, row := range rows {
+		// The temp schema is in memory, and writable whatever the file is
+		if row[1] == "temp" {
+			fmt.Printf("%s: %s r/w\n", row[1], row[2])
+			continue
+		}
+		fmt.Printf("%s: %s %s\n", row[1], row[2], access)
+	}
+	return nil
+}
+
+func HandleDBStat(database *engine.Database) error {
+	stats, err := database.DBStat()
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(count)
+	for _, tree := range stats {
+		fmt.Printf("%s|%d|%d|%d\n", tree.Name, tree.Pages, tree.Cells, tree.FreeBytes)
+	}
 	return nil
 }
+
+// HandleRawPage writes the bytes of one page, as a hex dump or, with
+// --binary, as they are in the file, for piping to other tools.
+func HandleRawPage(database *engine.Database, argument string) error {
+	var number string
+	binary := false
+	for _, field := range strings.Fields(argument) {
+		switch {
+		case field == "--binary":
+			binary = true
+		case strings.HasPrefix(field, "--"):
+			return fmt.Errorf("unknown option: %s", field)
+		case number != "":
+			return fmt.Errorf("extra argument: %s", field)
+		default:
+			number = field
+		}
+	}
+	pageNumber, err := strconv.ParseUint(number, 10, 32)
+	if err != nil {
+		return fmt.Errorf("usage: .rawpage ?--binary? PAGE")
+	}
+
+	data, err := database.RawPage(uint32(pageNumber))
+	if err != nil {
+		return err
+	}
+	if binary {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	dumper := hex.Dumper(os.Stdout)
+	if _, err := dumper.Write(data); err != nil {
+		return err
+	}
+	return dumper.Close()
+}
+
+// HandleCopy copies the rows of the tables named after FILE, or of every
+// table, from the database into the one in FILE, which is created when it
+// does not exist, creating each table there that it lacks. Progress is
+// logged to logger, when set, after each batch of rows.
+func HandleCopy(database *engine.Database, argument string, logger *slog.Logger) error {
+	fields := strings.Fields(argument)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "--") {
+		return fmt.Errorf("usage: .copy FILE ?TABLE ...?")
+	}
+	path := unquoteArgument(fields[0])
+	tables := make([]string, 0, len(fields)-1)
+	for _, field := range fields[1:] {
+		tables = append(tables, unquoteArgument(field))
+	}
+
+	destination, err := engine.Create(path)
+	if errors.Is(err, fs.ErrExist) {
+		destination, err = engine.Open(path)
+	}
+	if err != nil {
+		return err
+	}
+	var progress func(table string, rows int64)
+	if logger != nil {
+		progress = func(table string, rows int64) {
+			logger.Info("copied rows", "table", table, "rows", rows, "to", path)
+		}
+	}
+	if err := database.CopyTo(destination, tables, progress); err != nil {
+		destination.Close()
+		return err
+	}
+	return destination.Close()
+}
+
+// HandleSchemaGraph writes the tables of the database as a Graphviz graph:
+// a record node listing each table's columns, and an edge from each column
+// of a foreign key to the one it references, parsed from the CREATE TABLE
+// statements. Only the DOT format, --dot, is written.
+func HandleSchemaGraph(out io.Writer, database *engine.Database, argument string) error {
+	if argument = strings.TrimSpace(argument); argument != "" && argument != "--dot" {
+		return fmt.Errorf("usage: .schemagraph ?--dot?")
+	}
+	objects, err := database.SchemaObjects()
+	if err != nil {
+		return err
+	}
+	var tables []*engine.TableSchema
+	for _, object := range objects {
+		if object.Type != "table" || strings.HasPrefix(object.Name, "sqlite_") {
+			continue
+		}
+		table, err := database.TableSchema(object.Name)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, table)
+	}
+	return writeSchemaGraph(out, tables)
+}
+
+// writeSchemaGraph writes tables as a DOT digraph. Ports are named after
+// column positions, c0 onwards, since a record port cannot hold every
+// character a column name can.
+func writeSchemaGraph(out io.Writer, tables []*engine.TableSchema) error {
+	var graph strings.Builder
+	graph.WriteString("digraph schema {\n\trankdir=LR;\n\tnode [shape=record];\n")
+	byName := make(map[string]*engine.TableSchema, len(tables))
+	for _, table := range tables {
+		byName[strings.ToLower(table.Name)] = table
+		fields := []string{recordText(table.Name)}
+		for i, column := range table.Columns {
+			field := column.Name
+			if column.DeclaredType != "" {
+				field += " " + column.DeclaredType
+			}
+			if column.PrimaryKey {
+				field += " PK"
+			}
+			fields = append(fields, fmt.Sprintf("<c%d> %s\\l", i, recordText(field)))
+		}
+		fmt.Fprintf(&graph, "\t%s [label=\"{%s}\"];\n", dotID(table.Name), strings.Join(fields, "|"))
+	}
+
+	for _, table := range tables {
+		for _, key := range table.ForeignKeys {
+			parent := byName[strings.ToLower(key.ParentTable)]
+			referenced := key.ParentColumns
+			if len(referenced) == 0 && parent != nil {
+				referenced = parent.PrimaryKey
+			}
+			for i, name := range key.Columns {
+				child, ok := table.ColumnIndex(name)
+				if !ok {
+					continue
+				}
+				// A parent missing from the schema, or a column missing
+				// from the parent, gets an edge to the table's node alone
+				target := dotID(key.ParentTable)
+				if parent != nil && i < len(referenced) {
+					if position, ok := parent.ColumnIndex(referenced[i]); ok {
+						target = fmt.Sprintf("%s:c%d", dotID(parent.Name), position)
+					}
+				}
+				fmt.Fprintf(&graph, "\t%s:c%d -> %s;\n", dotID(table.Name), child, target)
+			}
+		}
+	}
+	graph.WriteString("}\n")
+	_, err := io.WriteString(out, graph.String())
+	return err
+}
+
+// dotID quotes a table name as a DOT identifier.
+func dotID(name string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+}
+
+// recordText escapes the characters that structure a Graphviz record label,
+// and the quotes around it.
+func recordText(text string) string {
+	var escaped strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune(`{}|<>"\`, r) {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// HandleFingerprint shows the shape of a statement, as NormalizeSQL gives
+// it, and the fingerprint statements of that shape share, without running
+// it.
+func HandleFingerprint(out io.Writer, sql string) error {
+	if strings.TrimSpace(sql) == "" {
+		return fmt.Errorf("usage: .fingerprint SQL")
+	}
+	_, err := fmt.Fprintf(out, "normalized: %s\nfingerprint: %s\n", engine.NormalizeSQL(sql), engine.Fingerprint(sql))
+	return err
+}
+
+// HandleWatch polls the database every --interval milliseconds, 1000 by
+// default, and writes each row change committed since .watch began as a
+// line of JSON, such as
+//
+//	{"op":"update","table":"t","rowid":3,"row":{"id":3,"name":"x"}}
+//
+// with a null row for a delete. It runs until --count changes have been
+// written or --timeout milliseconds have passed, when either is given, and
+// otherwise until it is interrupted.
+func HandleWatch(out io.Writer, database *engine.Database, argument string) error {
+	const usage = "usage: .watch ?--interval MS? ?--count N? ?--timeout MS?"
+	interval, count, timeout := int64(1000), int64(0), int64(0)
+	fields := strings.Fields(argument)
+	for i := 0; i < len(fields); i++ {
+		var target *int64
+		switch fields[i] {
+		case "--interval":
+			target = &interval
+		case "--count":
+			target = &count
+		case "--timeout":
+			target = &timeout
+		default:
+			if strings.HasPrefix(fields[i], "--") {
+				return fmt.Errorf("unknown option: %s", fields[i])
+			}
+			return fmt.Errorf("extra argument: %s", fields[i])
+		}
+		i++
+		if i == len(fields) {
+			return fmt.Errorf(usage)
+		}
+		value, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || value < 0 || target == &interval && value == 0 {
+			return fmt.Errorf(usage)
+		}
+		*target = value
+	}
+
+	watcher, err := database.Watch()
+	if err != nil {
+		return err
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	}
+	for written := int64(0); ; {
+		events, err := watcher.Poll()
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			row := "null"
+			if event.Row != nil {
+				row = jsonObject(event.Columns, event.Row)
+			}
+			_, err := fmt.Fprintf(out, "{\"op\":%s,\"table\":%s,\"rowid\":%d,\"row\":%s}\n",
+				jsonString(strings.ToLower(event.Op.String())), jsonString(event.Table), event.RowID, row)
+			if err != nil {
+				return err
+			}
+			if written++; count > 0 && written == count {
+				return nil
+			}
+		}
+
+		wait := time.Duration(interval) * time.Millisecond
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil
+			}
+			wait = min(wait, remaining)
+		}
+		time.Sleep(wait)
+	}
+}
+
+func HandleQuery(out io.Writer, database *engine.Database, query string, formatter Formatter) error {
+	resultSet, err := database.Query(query)
+	if err != nil {
+		return err
+	}
+	return writeResults(out, resultSet, formatter)
+}
+
+// HandleSample shows N rows of a table, 10 unless N is given, picked at
+// random without scanning it.
+func HandleSample(out io.Writer, database *engine.Database, argument string, formatter Formatter) error {
+	fields := strings.Fields(argument)
+	n := 10
+	if len(fields) == 2 {
+		var err error
+		if n, err = strconv.Atoi(fields[1]); err != nil || n < 0 {
+			fields = nil
+		}
+	}
+	if len(fields) != 1 && len(fields) != 2 {
+		return fmt.Errorf("usage: .sample TABLE ?N?")
+	}
+	resultSet, err := database.Sample(unquoteArgument(fields[0]), n)
+	if err != nil {
+		return err
+	}
+	return writeResults(out, resultSet, formatter)
+}
+
+// writeResults writes the rows of a result set to out with formatter, then
+// closes it.
+func writeResults(out io.Writer, resultSet *engine.ResultSet, formatter Formatter) error {
+	defer resultSet.Close()
+
+	columns := make([]string, len(resultSet.Columns))
+	for i, column := range resultSet.Columns {
+		columns[i] = column.Name
+	}
+
+	writer := &rowWriter{out: out, formatter: formatter, columns: columns}
+	for resultSet.Next() {
+		if err := writer.write(resultSet.Row()); err != nil {
+			return err
+		}
+	}
+	if err := resultSet.Err(); err != nil {
+		return err
+	}
+	if err := writer.finish(); err != nil {
+		return err
+	}
+	return resultSet.Close()
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Environment variables that set a session's defaults before its init file
+// and commands run.
+const (
+	// ModeEnv names the default output mode, as .mode takes it
+	ModeEnv = "SQLITE_MODE"
+	// NullValueEnv is the default NULL marker, as .nullvalue takes it
+	NullValueEnv = "SQLITE_NULLVALUE"
+	// HeadersEnv turns column headers on or off, as .headers takes it
+	HeadersEnv = "SQLITE_HEADERS"
+	// TimerEnv turns statement timing on or off, as .timer takes it
+	TimerEnv = "SQLITE_TIMER"
+	// InitFileEnv names the init file read in place of ~/.sqliterc
+	InitFileEnv = "SQLITERC"
+)
+
+// ApplyEnvironment sets the session's defaults from the environment
+// variables getenv reports, leaving those that are unset or empty alone.
+func (s *Session) ApplyEnvironment(getenv func(string) string) error {
+	if name := getenv(ModeEnv); name != "" {
+		mode, err := ParseOutputMode(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ModeEnv, err)
+		}
+		s.Formatter.Mode = mode
+	}
+	if marker := getenv(NullValueEnv); marker != "" {
+		s.Formatter.NullValue = marker
+	}
+	if value := getenv(HeadersEnv); value != "" {
+		on, err := parseSwitch(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", HeadersEnv, err)
+		}
+		s.Formatter.Header = on
+	}
+	if value := getenv(TimerEnv); value != "" {
+		on, err := parseSwitch(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", TimerEnv, err)
+		}
+		s.Timer = on
+	}
+	return nil
+}
+
+// DefaultInitFile returns the init file sqlite3 reads at startup:
+// $SQLITERC when set and ~/.sqliterc otherwise, or an empty path when
+// there is no home directory to look in.
+func DefaultInitFile(getenv func(string) string) string {
+	if path := getenv(InitFileEnv); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".sqliterc")
+}
+
+// RunInitFile runs the commands in the init file at path in order. As in
+// sqlite3, a command that fails is passed to report and the rest still
+// run, so that a line this shell does not understand cannot stop it
+// starting. A missing file is skipped unless required is set, since most
+// users have no ~/.sqliterc. .quit stops the file and returns ErrQuit.
+func (s *Session) RunInitFile(path string, required bool, report func(error)) error {
+	if path == "" {
+		return nil
+	}
+	script, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("init file %s: cannot open %q", path, path)
+	}
+	for _, command := range splitScript(string(script)) {
+		if err := s.Execute(command.text); errors.Is(err, ErrQuit) {
+			return err
+		} else if err != nil {
+			report(fmt.Errorf("init file %s: near line %d: %w", path, command.line, err))
+		}
+	}
+	return nil
+}
+
+// parseSwitch reads the argument of a setting that is on or off, such as
+// .headers takes, in any of the forms sqlite3 accepts.
+func parseSwitch(argument string) (bool, error) {
+	switch strings.ToLower(unquoteArgument(argument)) {
+	case "on", "yes", "true", "1":
+		return true, nil
+	case "off", "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("not a boolean value: %s", argument)
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyEnvironment(t *testing.T) {
+	environment := map[string]string{ModeEnv: "CSV", NullValueEnv: "NIL", HeadersEnv: "on", TimerEnv: "yes"}
+	session := NewSession("unused.db")
+	if err := session.ApplyEnvironment(func(key string) string { return environment[key] }); err != nil {
+		t.Fatal(err)
+	}
+	if session.Formatter.Mode != ModeCSV || session.Formatter.NullValue != "NIL" || !session.Formatter.Header || !session.Timer {
+		t.Fatalf("session after environment: %+v, timer %v", session.Formatter, session.Timer)
+	}
+
+	environment[HeadersEnv] = "maybe"
+	if err := session.ApplyEnvironment(func(key string) string { return environment[key] }); err == nil {
+		t.Fatal("expected an error for a headers setting that is neither on nor off")
+	}
+	environment[HeadersEnv] = "off"
+
+	environment[ModeEnv] = "bogus"
+	if err := session.ApplyEnvironment(func(key string) string { return environment[key] }); err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+}
+
+func TestRunInitFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sqliterc")
+	if err := os.WriteFile(path, []byte(".mode json\n.bogus\n.nullvalue '-'\n.headers on\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := DefaultInitFile(func(key string) string { return map[string]string{InitFileEnv: path}[key] }); got != path {
+		t.Fatalf("DefaultInitFile with %s set = %q", InitFileEnv, got)
+	}
+
+	// A line that fails is reported, and the lines after it still run
+	session := NewSession(":memory:")
+	defer session.Close()
+	var reported []error
+	report := func(err error) { reported = append(reported, err) }
+	if err := session.RunInitFile(path, true, report); err != nil {
+		t.Fatal(err)
+	}
+	if session.Formatter.Mode != ModeJSON || session.Formatter.NullValue != "-" || !session.Formatter.Header {
+		t.Fatalf("formatter after init file: %+v", session.Formatter)
+	}
+	if want := "init file " + path + ": near line 2: unknown command: .bogus"; len(reported) != 1 || reported[0].Error() != want {
+		t.Fatalf("reported %v, want %q", reported, want)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	if err := session.RunInitFile(missing, false, report); err != nil {
+		t.Fatalf("missing default init file: %v", err)
+	}
+	if err := session.RunInitFile(missing, true, report); err == nil {
+		t.Fatal("expected an error for a missing init file that was asked for")
+	}
+}
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
+)
+
+// ErrorReport is a failure in the shape --error-format json prints it, so
+// callers can tell kinds of failure apart without matching message text.
+type ErrorReport struct {
+	// Code names the kind of failure, such as TABLE_NOT_FOUND, or is
+	// ERROR when the failure has no more specific code
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Context map[string]any `json:"context"`
+}
+
+// NewErrorReport classifies err. The context holds what the error itself
+// records, such as the missing table's name or the corrupt page, and
+// callers may add to it.
+func NewErrorReport(err error) ErrorReport {
+	report := ErrorReport{Code: "ERROR", Message: err.Error(), Context: map[string]any{}}
+
+	var corruption *db.CorruptionError
+	var constraint *engine.ConstraintError
+	var syntax *engine.SyntaxError
+	switch {
+	case errors.As(err, &syntax):
+		report.Code = "SYNTAX"
+		report.Context["line"], report.Context["column"] = syntax.Position()
+		if syntax.Near != "" {
+			report.Context["near"] = syntax.Near
+		}
+	case errors.Is(err, engine.ErrNoSuchTable):
+		report.Code = "TABLE_NOT_FOUND"
+		report.nameAfter(engine.ErrNoSuchTable, "table")
+	case errors.Is(err, engine.ErrNoSuchColumn):
+		report.Code = "COLUMN_NOT_FOUND"
+		report.nameAfter(engine.ErrNoSuchColumn, "column")
+	case errors.As(err, &constraint):
+		report.Code = "CONSTRAINT"
+		report.Context["kind"] = constraint.Kind
+		if constraint.Detail != "" {
+			report.Context["detail"] = constraint.Detail
+		}
+	case errors.As(err, &corruption):
+		report.Code = "CORRUPT"
+		report.Context["page"] = corruption.Page
+		if corruption.Cell >= 0 {
+			report.Context["cell"] = corruption.Cell
+		}
+	case errors.Is(err, db.ErrBusy):
+		report.Code = "BUSY"
+	case errors.Is(err, engine.ErrResultTooLarge):
+		report.Code = "RESULT_TOO_LARGE"
+	case errors.Is(err, engine.ErrStatementNotAllowed):
+		report.Code = "STATEMENT_NOT_ALLOWED"
+	case errors.Is(err, engine.ErrReadOnly):
+		report.Code = "READ_ONLY"
+	case errors.Is(err, engine.ErrInterrupted):
+		report.Code = "INTERRUPTED"
+	case errors.Is(err, fs.ErrNotExist):
+		report.Code = "FILE_NOT_FOUND"
+	}
+	return report
+}
+
+// nameAfter records under key the name that follows sentinel in the
+// message, as in "no such table: t".
+func (report *ErrorReport) nameAfter(sentinel error, key string) {
+	if _, name, ok := strings.Cut(report.Message, sentinel.Error()+": "); ok {
+		report.Context[key] = strings.Trim(name, `"`)
+	}
+}
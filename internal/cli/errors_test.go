@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
+)
+
+func TestNewErrorReport(t *testing.T) {
+	tests := []struct {
+		err     error
+		code    string
+		context map[string]any
+	}{
+		{fmt.Errorf("%w: apples", engine.ErrNoSuchTable), "TABLE_NOT_FOUND", map[string]any{"table": "apples"}},
+		{fmt.Errorf("%w: %q", engine.ErrNoSuchColumn, "color"), "COLUMN_NOT_FOUND", map[string]any{"column": "color"}},
+		{fmt.Errorf("insert: %w", &engine.ConstraintError{Kind: "NOT NULL", Detail: "t.a"}), "CONSTRAINT", map[string]any{"kind": "NOT NULL", "detail": "t.a"}},
+		{fmt.Errorf("read schema: %w", &db.CorruptionError{Page: 3, Cell: 1, Reason: "bad"}), "CORRUPT", map[string]any{"page": uint32(3), "cell": 1}},
+		{&db.CorruptionError{Page: 3, Cell: -1, Reason: "bad"}, "CORRUPT", map[string]any{"page": uint32(3)}},
+		{db.ErrBusy, "BUSY", map[string]any{}},
+		{fmt.Errorf("%w: more than 5 rows", engine.ErrResultTooLarge), "RESULT_TOO_LARGE", map[string]any{}},
+		{fmt.Errorf("%w: DELETE", engine.ErrStatementNotAllowed), "STATEMENT_NOT_ALLOWED", map[string]any{}},
+		{engine.ErrReadOnly, "READ_ONLY", map[string]any{}},
+		{engine.ErrInterrupted, "INTERRUPTED", map[string]any{}},
+		{fmt.Errorf("parse query: %w", &engine.SyntaxError{SQL: "SELECT a,\nFROM t", Offset: 10, Near: "FROM"}), "SYNTAX", map[string]any{"line": 2, "column": 1, "near": "FROM"}},
+		{&engine.SyntaxError{SQL: "SELECT a FROM", Offset: 13}, "SYNTAX", map[string]any{"line": 1, "column": 14}},
+		{fmt.Errorf("unknown command: .bogus"), "ERROR", map[string]any{}},
+	}
+
+	for _, tt := range tests {
+		report := NewErrorReport(tt.err)
+		if report.Code != tt.code || report.Message != tt.err.Error() || !reflect.DeepEqual(report.Context, tt.context) {
+			t.Errorf("NewErrorReport(%q) = %+v, want code %s and context %v", tt.err, report, tt.code, tt.context)
+		}
+	}
+}
+
+func TestErrorReportForMissingDatabase(t *testing.T) {
+	session := NewSession(filepath.Join(t.TempDir(), "missing.db"))
+	err := session.Execute("SELECT 1")
+	if err == nil {
+		t.Fatal("expected an error opening a missing database")
+	}
+	if report := NewErrorReport(err); report.Code != "FILE_NOT_FOUND" {
+		t.Fatalf("code = %s for %v, want FILE_NOT_FOUND", report.Code, err)
+	}
+}
@@ -0,0 +1,274 @@
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// OutputMode selects how query results are rendered, mirroring sqlite3's
+// .mode command.
+type OutputMode int
+
+const (
+	ModeList OutputMode = iota
+	ModeCSV
+	ModeQuote
+	ModeJSON
+)
+
+var outputModes = map[string]OutputMode{
+	"list":  ModeList,
+	"csv":   ModeCSV,
+	"quote": ModeQuote,
+	"json":  ModeJSON,
+}
+
+// ParseOutputMode resolves a .mode argument.
+func ParseOutputMode(name string) (OutputMode, error) {
+	mode, ok := outputModes[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported output mode: %s", name)
+	}
+	return mode, nil
+}
+
+// Formatter renders result rows for one output mode.
+type Formatter struct {
+	Mode      OutputMode
+	NullValue string
+	// Header writes a row of the column names before the first row, in
+	// every mode but json, as .headers on asks and .once -x does for
+	// spreadsheets
+	Header bool
+}
+
+// RowSeparator is written after every row; csv mode ends rows with CRLF as
+// RFC 4180 asks.
+func (f Formatter) RowSeparator() string {
+	if f.Mode == ModeCSV {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// FormatRow renders a row as a single output line, without the separator.
+// columns names the row's values, which only json mode uses.
+func (f Formatter) FormatRow(columns []string, row []any) string {
+	if f.Mode == ModeJSON {
+		return jsonObject(columns, row)
+	}
+
+	separator := "|"
+	if f.Mode != ModeList {
+		separator = ","
+	}
+
+	fields := make([]string, len(row))
+	for i, value := range row {
+		fields[i] = f.FormatValue(value)
+	}
+	return strings.Join(fields, separator)
+}
+
+// FormatValue renders a single column value.
+func (f Formatter) FormatValue(value any) string {
+	switch f.Mode {
+	case ModeQuote:
+		return quoteValue(value)
+	case ModeJSON:
+		return jsonValue(value)
+	}
+
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return f.NullValue
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return formatReal(v)
+	case string:
+		text = v
+	case []byte:
+		// sqlite3 writes blob bytes through unchanged in list and csv modes
+		text = string(v)
+	default:
+		text = fmt.Sprint(v)
+	}
+
+	if f.Mode == ModeCSV {
+		return csvQuote(text)
+	}
+	return text
+}
+
+// formatReal renders a REAL the way sqlite3 does in list and csv modes,
+// with "%!.15g": fifteen significant digits and a ".0" on values that would
+// otherwise look integral.
+func formatReal(v float64) string {
+	return db.FormatReal(v, 15)
+}
+
+// quoteValue renders a value as an SQL literal, as sqlite3's quote mode does.
+func quoteValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if math.IsInf(v, 0) {
+			// A literal that overflows back to the same infinity
+			if v > 0 {
+				return "1e999"
+			}
+			return "-1e999"
+		}
+		// Twenty significant digits, as sqlite3 writes them, which read back
+		// as exactly the stored value
+		return db.FormatReal(v, 20)
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case []byte:
+		return "X'" + hex.EncodeToString(v) + "'"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// csvQuote wraps a field in double quotes when sqlite3 would: if it is empty
+// or contains whitespace, control characters, quotes, commas, or non-ASCII
+// bytes.
+func csvQuote(field string) string {
+	needsQuote := field == ""
+	for i := 0; i < len(field) && !needsQuote; i++ {
+		c := field[i]
+		needsQuote = c <= ' ' || c >= 0x7f || c == '"' || c == '\'' || c == ','
+	}
+	if !needsQuote {
+		return field
+	}
+	return `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+}
+
+// jsonObject renders a row as one JSON object keyed by column name.
+func jsonObject(columns []string, row []any) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, value := range row {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(jsonString(columns[i]))
+		b.WriteByte(':')
+		b.WriteString(jsonValue(value))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// jsonValue renders a value in json mode. Blobs become base64 strings, since
+// their raw bytes (which sqlite3 writes as-is) are not necessarily valid
+// UTF-8 and cannot be recovered from a JSON string.
+func jsonValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if math.IsInf(v, 0) {
+			// The out-of-range literal sqlite3 uses for infinities
+			if v > 0 {
+				return "9.0e+999"
+			}
+			return "-9.0e+999"
+		}
+		return db.FormatReal(v, 20)
+	case string:
+		return jsonString(v)
+	case []byte:
+		return jsonString(base64.StdEncoding.EncodeToString(v))
+	default:
+		return jsonString(fmt.Sprint(v))
+	}
+}
+
+// jsonString quotes text as a JSON string, escaping only what JSON requires.
+func jsonString(text string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < ' ' {
+				fmt.Fprintf(&b, `\u%04x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// rowWriter writes formatted rows along with the framing a mode needs
+// around them, such as json mode's enclosing array.
+type rowWriter struct {
+	out       io.Writer
+	formatter Formatter
+	columns   []string
+	rows      int
+}
+
+func (w *rowWriter) write(row []any) error {
+	var prefix, suffix string
+	if w.formatter.Mode == ModeJSON {
+		prefix = ",\n"
+		if w.rows == 0 {
+			prefix = "["
+		}
+	} else {
+		suffix = w.formatter.RowSeparator()
+		if w.rows == 0 && w.formatter.Header {
+			names := make([]any, len(w.columns))
+			for i, name := range w.columns {
+				names[i] = name
+			}
+			prefix = w.formatter.FormatRow(w.columns, names) + suffix
+		}
+	}
+	w.rows++
+
+	_, err := fmt.Fprint(w.out, prefix, w.formatter.FormatRow(w.columns, row), suffix)
+	return err
+}
+
+// finish closes any framing opened by write. Empty results print nothing.
+func (w *rowWriter) finish() error {
+	if w.formatter.Mode == ModeJSON && w.rows > 0 {
+		_, err := fmt.Fprint(w.out, "]\n")
+		return err
+	}
+	return nil
+}
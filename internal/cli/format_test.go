@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestFormatValue(t *testing.T) {
+	list := Formatter{Mode: ModeList, NullValue: "NULL"}
+	csv := Formatter{Mode: ModeCSV}
+	quote := Formatter{Mode: ModeQuote}
+	json := Formatter{Mode: ModeJSON}
+	// Variables, so the sum is computed in float64 rather than as a constant
+	tenth, fifth := 0.1, 0.2
+
+	tests := []struct {
+		formatter Formatter
+		value     any
+		want      string
+	}{
+		{list, nil, "NULL"},
+		{list, int64(-7), "-7"},
+		{list, 3.0, "3.0"},
+		{list, tenth + fifth, "0.3"},
+		{list, 1.0 / 3, "0.333333333333333"},
+		{list, 1e20, "1.0e+20"},
+		{list, 1e-7, "1.0e-07"},
+		{list, 1.5e-7, "1.5e-07"},
+		{list, 123456789012345678.0, "1.23456789012346e+17"},
+		{list, -0.0, "0.0"},
+		// SQLite's rounding of its own digits, not the correctly rounded ones
+		{list, 5.713806411863575e+125, "5.71380641186358e+125"},
+		{list, "text", "text"},
+		{list, []byte("hi"), "hi"},
+		{csv, nil, ""},
+		{csv, "plain", "plain"},
+		{csv, "", `""`},
+		{csv, "a, \"b\"", `"a, ""b"""`},
+		{csv, "é", `"é"`},
+		{quote, nil, "NULL"},
+		{quote, 3.0, "3.0"},
+		{quote, tenth + fifth, "0.3000000000000000445"},
+		{quote, 1e15, "1000000000000000.0"},
+		{quote, 1e20, "1.0e+20"},
+		{quote, "it's", "'it''s'"},
+		{quote, []byte{0x41, 0xff, 0x00}, "X'41ff00'"},
+		{json, nil, "null"},
+		{json, 1e20, "1.0e+20"},
+		{json, tenth, "0.1000000000000000055"},
+		{json, "say \"hi\"\t\x01", `"say \"hi\"\t\u0001"`},
+		{json, []byte{0x41, 0xff, 0x00}, `"Qf8A"`},
+	}
+
+	for _, tt := range tests {
+		if got := tt.formatter.FormatValue(tt.value); got != tt.want {
+			t.Errorf("mode %d: FormatValue(%#v) = %q, want %q", tt.formatter.Mode, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSessionAppliesSettingsToLaterCommands(t *testing.T) {
+	session := NewSession("unused.db")
+	for _, command := range []string{".mode csv", ".nullvalue ''"} {
+		if err := session.Execute(command); err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+	}
+	if session.Formatter.Mode != ModeCSV || session.Formatter.NullValue != "" {
+		t.Fatalf("unexpected formatter: %+v", session.Formatter)
+	}
+
+	if err := session.Execute(".nullvalue (null)"); err != nil {
+		t.Fatal(err)
+	}
+	if session.Formatter.NullValue != "(null)" {
+		t.Fatalf("unexpected null marker: %q", session.Formatter.NullValue)
+	}
+	if err := session.Execute(".mode bogus"); err == nil {
+		t.Fatal("expected an error for an unknown mode")
+	}
+}
+
+func TestRowWriterFramesJSONArray(t *testing.T) {
+	var out strings.Builder
+	writer := &rowWriter{out: &out, formatter: Formatter{Mode: ModeJSON}, columns: []string{"id", "raw"}}
+	for _, row := range [][]any{{int64(1), []byte("hi")}, {int64(2), nil}} {
+		if err := writer.write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[{\"id\":1,\"raw\":\"aGk=\"},\n{\"id\":2,\"raw\":null}]\n"
+	if out.String() != want {
+		t.Fatalf("unexpected output:\n%q\nwant\n%q", out.String(), want)
+	}
+}
+
+func TestOpenSwitchesDatabaseKeepingSettings(t *testing.T) {
+	first := testgen.New(testgen.Options{})
+	first.CreateTable("apples", "CREATE TABLE apples (id integer primary key, name text)")
+	second := testgen.New(testgen.Options{})
+	second.CreateTable("cherries", "CREATE TABLE cherries (id integer primary key, name text)")
+	secondPath := second.WriteTemp(t)
+
+	session := NewSession(first.WriteTemp(t))
+	defer session.Close()
+	for _, command := range []string{".mode csv", "SELECT count(*) FROM apples", ".open " + secondPath, "INSERT INTO cherries VALUES (1, 'bing')"} {
+		if err := session.Execute(command); err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+	}
+	if session.Formatter.Mode != ModeCSV {
+		t.Fatalf("mode after .open: %d", session.Formatter.Mode)
+	}
+	if err := session.Execute("SELECT count(*) FROM apples"); !errors.Is(err, engine.ErrNoSuchTable) {
+		t.Fatalf("query of the first database after .open: %v", err)
+	}
+
+	if err := session.Execute(".open --readonly " + secondPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Execute("INSERT INTO cherries VALUES (2, 'rainier')"); !errors.Is(err, engine.ErrReadOnly) {
+		t.Fatalf("insert after .open --readonly: %v", err)
+	}
+
+	created := filepath.Join(t.TempDir(), "created.db")
+	if err := session.Execute(".open " + created); err == nil {
+		t.Fatal("expected .open of a missing file without --create to fail")
+	}
+	if err := session.Execute(".open --create " + created); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Execute(".open --create " + created); err != nil {
+		t.Fatalf(".open --create of an existing database: %v", err)
+	}
+	if err := session.Execute(".open --bogus " + created); err == nil {
+		t.Fatal("expected an error for an unknown option")
+	}
+}
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// dotCommand describes a dot-command for .help and completion.
+type dotCommand struct {
+	name        string
+	arguments   string
+	description string
+}
+
+// dotCommands lists every dot-command Execute understands, in the order
+// .help shows them.
+var dotCommands = []dotCommand{
+	{".copy", "FILE ?TABLE ...?", "Copy the rows of TABLEs, or of every table, into the database in FILE"},
+	{".databases", "", "List names and files of attached databases"},
+	{".dbinfo", "", "Show status information about the database"},
+	{".dbstat", "", "Show the pages, cells and free bytes of each b-tree"},
+	{".excel", "", "Open the next command's output in a spreadsheet, as .once -x does"},
+	{".fingerprint", "SQL", "Show the normalized form and fingerprint of SQL"},
+	{".headers", "on|off", "Turn display of column headers on or off"},
+	{".help", "", "Show this message"},
+	{".mode", "MODE", "Set the output mode"},
+	{".nullvalue", "STRING", "Use STRING in place of NULL values"},
+	{".once", "-x|FILE", "Write the next command's output to FILE, or with -x open it as CSV in a spreadsheet"},
+	{".open", "?OPTIONS? FILE", "Close this database and open FILE, with --readonly or --create"},
+	{".quit", "", "Stop reading input and exit"},
+	{".rawpage", "?--binary? PAGE", "Dump the bytes of page PAGE, in hex or as they are"},
+	{".read", "FILE", "Read input from FILE"},
+	{".sample", "TABLE ?N?", "Show N rows of TABLE, 10 by default, picked at random"},
+	{".schemagraph", "?--dot?", "Write the tables, their columns and foreign keys as a Graphviz graph"},
+	{".tables", "", "List names of tables"},
+	{".timer", "on|off", "Turn the timing of statements on or off"},
+	{".wal-checkpoint", "?MODE?", "Checkpoint the write-ahead log, as PRAGMA wal_checkpoint does"},
+	{".watch", "?OPTIONS?", "Write each row change committed from now on as a line of JSON"},
+}
+
+// HandleHelp lists the dot-commands and output modes, as sqlite3's .help
+// does.
+func HandleHelp(out io.Writer) error {
+	for _, command := range dotCommands {
+		usage := strings.TrimSpace(command.name + " " + command.arguments)
+		if _, err := fmt.Fprintf(out, "%-24s%s\n", usage, command.description); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(out, "\nMODE is one of: %s\n", strings.Join(slices.Sorted(maps.Keys(outputModes)), ", "))
+	return err
+}
+
+// Complete returns the completions of the last word of a partly typed
+// command, for a line editor to offer on tab: dot-command names, output
+// modes after .mode, and in SQL the names of tables and their columns,
+// read through the database's schema cache. A word written table.prefix
+// completes to that table's columns.
+func (s *Session) Complete(line string) ([]string, error) {
+	name, argument, spaced := strings.Cut(strings.TrimLeft(line, " "), " ")
+	if strings.HasPrefix(name, ".") {
+		switch {
+		case !spaced:
+			var names []string
+			for _, command := range dotCommands {
+				names = append(names, command.name)
+			}
+			return withPrefix(names, name), nil
+		case name == ".mode":
+			return withPrefix(slices.Sorted(maps.Keys(outputModes)), strings.TrimSpace(argument)), nil
+		}
+		return nil, nil
+	}
+
+	word := line[strings.LastIndexAny(line, " \t\n,()=<>")+1:]
+	database, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	objects, err := database.SchemaObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	if qualifier, prefix, ok := strings.Cut(word, "."); ok {
+		table, err := database.TableSchema(qualifier)
+		if err != nil {
+			return nil, nil
+		}
+		var columns []string
+		for _, column := range table.Columns {
+			columns = append(columns, qualifier+"."+column.Name)
+		}
+		return withPrefix(columns, qualifier+"."+prefix), nil
+	}
+
+	var names []string
+	for _, tableName := range db.ExtractTableNames(objects) {
+		names = append(names, tableName)
+		// Tables whose schema cannot be parsed, such as virtual tables,
+		// offer only their names
+		if table, err := database.TableSchema(tableName); err == nil {
+			for _, column := range table.Columns {
+				names = append(names, column.Name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return withPrefix(slices.Compact(names), word), nil
+}
+
+// withPrefix keeps the candidates that start with prefix, ignoring case as
+// SQL names do.
+func withPrefix(candidates []string, prefix string) []string {
+	var matches []string
+	for _, candidate := range candidates {
+		if len(candidate) >= len(prefix) && strings.EqualFold(candidate[:len(prefix)], prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
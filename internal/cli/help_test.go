@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestHelpListsEveryDotCommand(t *testing.T) {
+	var out strings.Builder
+	if err := HandleHelp(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range dotCommands {
+		if !strings.Contains(out.String(), "\n"+command.name) && !strings.HasPrefix(out.String(), command.name) {
+			t.Errorf("help does not list %s:\n%s", command.name, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "csv, json, list, quote") {
+		t.Errorf("help does not list the output modes:\n%s", out.String())
+	}
+}
+
+func TestComplete(t *testing.T) {
+	generated := testgen.New(testgen.Options{})
+	generated.CreateTable("apples", "CREATE TABLE apples (id integer primary key, name text, color text)")
+	generated.CreateTable("cherries", "CREATE TABLE cherries (id integer primary key, colour text)")
+	session := NewSession(generated.WriteTemp(t))
+	defer session.Close()
+
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{".ta", []string{".tables"}},
+		{".t", []string{".tables", ".timer"}},
+		{".da", []string{".databases"}},
+		{".", []string{".copy", ".databases", ".dbinfo", ".dbstat", ".excel", ".fingerprint", ".headers", ".help", ".mode", ".nullvalue", ".once", ".open", ".quit", ".rawpage", ".read", ".sample", ".schemagraph", ".tables", ".timer", ".wal-checkpoint", ".watch"}},
+		{".mode j", []string{"json"}},
+		{".read x", nil},
+		{"SELECT * FROM a", []string{"apples"}},
+		{"SELECT co", []string{"color", "colour"}},
+		{"SELECT id FROM apples WHERE NA", []string{"name"}},
+		{"SELECT apples.c", []string{"apples.color"}},
+		{"SELECT pears.c", nil},
+	}
+	for _, tt := range tests {
+		got, err := session.Complete(tt.line)
+		if err != nil {
+			t.Fatalf("Complete(%q): %v", tt.line, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Complete(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// onceOutput is where .once sends the next command's output: a file, or
+// with spreadsheet set a temporary CSV file opened once it is written.
+type onceOutput struct {
+	path        string
+	spreadsheet bool
+}
+
+// setOnce handles .once's argument: -x for a spreadsheet, or a file.
+func (s *Session) setOnce(argument string) error {
+	fields := strings.Fields(argument)
+	switch {
+	case len(fields) == 1 && fields[0] == "-x":
+		s.once = &onceOutput{spreadsheet: true}
+	case len(fields) == 1 && !strings.HasPrefix(fields[0], "-"):
+		s.once = &onceOutput{path: unquoteArgument(fields[0])}
+	case len(fields) == 1:
+		return fmt.Errorf("unknown option: %s", fields[0])
+	default:
+		return fmt.Errorf("usage: .once -x|FILE")
+	}
+	return nil
+}
+
+// executeOnce runs a command with its output sent where .once asked, which
+// it is for this command alone. For a spreadsheet the results are written
+// as CSV with a header row, whatever the mode, to a temporary file left
+// for the spreadsheet to read.
+func (s *Session) executeOnce(command, name, argument string) error {
+	once := s.once
+	s.once = nil
+
+	var file *os.File
+	var err error
+	if once.spreadsheet {
+		file, err = os.CreateTemp("", "sqlite-*.csv")
+	} else {
+		file, err = os.Create(once.path)
+	}
+	if err != nil {
+		return err
+	}
+	if once.spreadsheet {
+		formatter := s.Formatter
+		s.Formatter = Formatter{Mode: ModeCSV, NullValue: formatter.NullValue, Header: true}
+		defer func() { s.Formatter = formatter }()
+	}
+	err = s.execute(file, command, name, argument)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || !once.spreadsheet {
+		return err
+	}
+
+	open := s.OpenFile
+	if open == nil {
+		open = openWithSystem
+	}
+	if err := open(file.Name()); err != nil {
+		return fmt.Errorf("cannot open %s: %w", file.Name(), err)
+	}
+	return nil
+}
+
+// openWithSystem opens a file in the program the desktop associates with
+// its type, without waiting for it to exit.
+func openWithSystem(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestOnceSendsNextOutputToFile(t *testing.T) {
+	generated := testgen.New(testgen.Options{})
+	fruits := generated.CreateTable("fruits", "CREATE TABLE fruits (id integer primary key, name text, note text)")
+	fruits.Insert(1, nil, "apple", nil)
+	fruits.Insert(2, nil, "two words", "ripe")
+	session := NewSession(generated.WriteTemp(t))
+	defer session.Close()
+	session.Formatter.NullValue = "-"
+
+	path := filepath.Join(t.TempDir(), "out.txt")
+	for _, command := range []string{".once " + path, "SELECT name, note FROM fruits", "SELECT name FROM fruits WHERE id = 3"} {
+		if err := session.Execute(command); err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != "apple|-\ntwo words|ripe\n" {
+		t.Fatalf("file holds %q, %v", got, err)
+	}
+
+	var opened string
+	session.OpenFile = func(path string) error {
+		opened = path
+		return nil
+	}
+	for _, command := range []string{".excel", "SELECT * FROM fruits"} {
+		if err := session.Execute(command); err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+	}
+	if filepath.Ext(opened) != ".csv" {
+		t.Fatalf("opened %q", opened)
+	}
+	defer os.Remove(opened)
+	want := "id,name,note\r\n1,apple,-\r\n2,\"two words\",ripe\r\n"
+	if got, err := os.ReadFile(opened); err != nil || string(got) != want {
+		t.Errorf("spreadsheet holds %q, %v; want %q", got, err, want)
+	}
+	if session.Formatter.Mode != ModeList || session.Formatter.Header {
+		t.Errorf("formatter after .excel: %+v", session.Formatter)
+	}
+
+	for _, argument := range []string{"", "-e", "a b"} {
+		if err := session.Execute(".once " + argument); err == nil {
+			t.Errorf(".once %s: expected an error", argument)
+		}
+	}
+}
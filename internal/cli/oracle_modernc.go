@@ -0,0 +1,54 @@
+//go:build verify
+
+package cli
+
+import (
+	"database/sql"
+	"net/url"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteOracle is the pure-Go SQLite from modernc.org, built in with the
+// verify tag.
+type sqliteOracle struct {
+	database *sql.DB
+}
+
+func openOracle(path string) (oracle, error) {
+	database, err := sql.Open("sqlite", "file:"+(&url.URL{Path: path}).EscapedPath()+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteOracle{database: database}, nil
+}
+
+func (o *sqliteOracle) query(query string) ([]string, [][]any, error) {
+	rows, err := o.database.Query(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var results [][]any
+	for rows.Next() {
+		row := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range row {
+			pointers[i] = &row[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, nil, err
+		}
+		results = append(results, row)
+	}
+	return columns, results, rows.Err()
+}
+
+func (o *sqliteOracle) Close() error {
+	return o.database.Close()
+}
@@ -0,0 +1,24 @@
+//go:build verify
+
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyAgainstSQLite(t *testing.T) {
+	session := NewSession(filepath.Join("..", "..", "sample.db"))
+	session.Verify = true
+	defer session.Close()
+
+	for _, query := range []string{
+		"SELECT count(*) FROM apples",
+		"SELECT name, color FROM apples",
+		"SELECT id FROM oranges WHERE name = 'Mandarin'",
+	} {
+		if err := session.Execute(query); err != nil {
+			t.Errorf("%s: %v", query, err)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !verify
+
+package cli
+
+import "errors"
+
+func openOracle(path string) (oracle, error) {
+	return nil, errors.New("--verify needs a build with -tags verify")
+}
@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// scriptCommand is one command of a script read by .read: a dot-command or
+// an SQL statement, with the line it starts on.
+type scriptCommand struct {
+	text string
+	line int
+}
+
+// splitScript splits a script into its commands. Statements end at a
+// semicolon outside quotes and comments, and may span lines; a line that
+// starts with a dot outside a statement is a dot-command.
+func splitScript(script string) []scriptCommand {
+	var commands []scriptCommand
+	var current strings.Builder
+	start, line := 0, 1
+
+	flush := func() {
+		if text := strings.TrimSpace(current.String()); text != "" {
+			commands = append(commands, scriptCommand{text: text, line: start})
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		if strings.TrimSpace(current.String()) == "" {
+			if c == '\n' {
+				line++
+				current.Reset()
+				continue
+			}
+			start = line
+			if c == '.' && lineStart(script, i) {
+				end := strings.IndexByte(script[i:], '\n')
+				if end < 0 {
+					end = len(script) - i
+				}
+				commands = append(commands, scriptCommand{text: strings.TrimSpace(script[i : i+end]), line: line})
+				current.Reset()
+				i += end - 1
+				continue
+			}
+		}
+
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closer := c
+			if c == '[' {
+				closer = ']'
+			}
+			end := strings.IndexByte(script[i+1:], closer)
+			if end < 0 {
+				end = len(script) - i - 1
+			}
+			quoted := script[i:min(i+end+2, len(script))]
+			line += strings.Count(quoted, "\n")
+			current.WriteString(quoted)
+			i += len(quoted) - 1
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			i += end - 1
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			comment := script[i:]
+			if end >= 0 {
+				comment = script[i : i+end+4]
+			}
+			line += strings.Count(comment, "\n")
+			current.WriteByte(' ')
+			i += len(comment) - 1
+		case c == ';':
+			flush()
+		default:
+			if c == '\n' {
+				line++
+			}
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return commands
+}
+
+// lineStart reports whether only blanks precede position i on its line.
+func lineStart(script string, i int) bool {
+	begin := strings.LastIndexByte(script[:i], '\n') + 1
+	return strings.TrimSpace(script[begin:i]) == ""
+}
+
+// read runs the commands of a script file in order, stopping at the first
+// that fails. Settings such as .mode carry over to the rest of the session.
+func (s *Session) read(path string) error {
+	script, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot open %q", path)
+	}
+	return s.runScript(string(script))
+}
+
+// runScript runs the commands of a script in order, stopping at the first
+// that fails, whose error names the line it starts on.
+func (s *Session) runScript(script string) error {
+	for _, command := range splitScript(script) {
+		if err := s.Execute(command.text); err != nil {
+			return fmt.Errorf("near line %d: %w", command.line, err)
+		}
+	}
+	return nil
+}
+
+// RunArgument runs a command given on the command line, which may hold
+// several statements separated by semicolons, as sqlite3 takes them. They
+// run in order, stopping at the first that fails.
+func (s *Session) RunArgument(command string) error {
+	for _, command := range splitScript(command) {
+		if err := s.Execute(command.text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitScript(t *testing.T) {
+	script := ".mode csv\n" +
+		"SAVEPOINT a;\n" +
+		"INSERT INTO t VALUES ('x;y', \"q\"\"; r\"); -- trailing; comment\n" +
+		"  .tables\n" +
+		"SELECT /* a; b\n */ 1\n" +
+		"  FROM t;\n" +
+		"SELECT '.not a command'\n" +
+		".also not a command;\n" +
+		"\n" +
+		"RELEASE a"
+
+	got := splitScript(script)
+	want := []scriptCommand{
+		{".mode csv", 1},
+		{"SAVEPOINT a", 2},
+		{"INSERT INTO t VALUES ('x;y', \"q\"\"; r\")", 3},
+		{".tables", 4},
+		{"SELECT   1\n  FROM t", 5},
+		{"SELECT '.not a command'\n.also not a command", 8},
+		{"RELEASE a", 11},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("splitScript\n got %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,273 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
+)
+
+// Session holds the settings that dot-commands change and later commands in
+// the same invocation observe, such as the output mode and NULL marker. The
+// database is opened on first use and shared by every command.
+type Session struct {
+	Path      string
+	Formatter Formatter
+	// BusyTimeout is how long statements wait for other processes'
+	// locks, until PRAGMA busy_timeout changes it
+	BusyTimeout time.Duration
+	// Verify reruns each SELECT through a reference SQLite and fails when
+	// the results differ, in builds with the verify tag
+	Verify bool
+	// Limits caps the results of each query
+	Limits engine.Limits
+	// ReadOnlySQL rejects every statement that could write
+	ReadOnlySQL bool
+	// ReadOnly opens the database file for reading only
+	ReadOnly bool
+	// Permissive skips rows that cannot be decoded, logging a warning for
+	// each, instead of failing the query
+	Permissive bool
+	// VerifyChecksums fails reads of write-ahead log frames and hot
+	// journals whose checksums do not match
+	VerifyChecksums bool
+	// Passphrase, when set, opens the database as an SQLCipher one
+	// encrypted with it, for reading only, with the defaults of SQLCipher
+	// major version CipherCompatibility, 4 when zero
+	Passphrase          string
+	CipherCompatibility int
+	// RandomSeed, when set, seeds random() and randomblob() each time
+	// the database is opened, so that they return the same values
+	RandomSeed *int64
+	// Unicode has upper(), lower() and LIKE treat the case of letters in
+	// every script, rather than ASCII letters only
+	Unicode bool
+	// Timer writes the time each statement read from input took after
+	// its output, as .timer on does
+	Timer bool
+	// Logger receives the database's debug records, when set
+	Logger *slog.Logger
+	// OpenFile opens a file .once -x wrote in the program the system
+	// opens it with, such as a spreadsheet for .csv; when nil, a
+	// platform's opener command is run
+	OpenFile func(path string) error
+
+	database *engine.Database
+	oracle   oracle
+	// once is where .once sends the next command's output, when set
+	once *onceOutput
+}
+
+func NewSession(path string) *Session {
+	return &Session{Path: path, Formatter: Formatter{Mode: ModeList}}
+}
+
+// Close closes the session's database, if a command opened it.
+func (s *Session) Close() error {
+	if s.oracle != nil {
+		s.oracle.Close()
+		s.oracle = nil
+	}
+	if s.database == nil {
+		return nil
+	}
+	database := s.database
+	s.database = nil
+	return database.Close()
+}
+
+func (s *Session) open() (*engine.Database, error) {
+	if s.database == nil {
+		open := engine.Open
+		if s.ReadOnly {
+			open = engine.OpenReadOnly
+		}
+		// Opening replays a hot journal, so it must be checked first
+		if s.VerifyChecksums && !s.ReadOnly {
+			if err := db.VerifyJournal(s.Path); err != nil {
+				return nil, err
+			}
+		}
+		if s.Passphrase != "" {
+			codec, err := db.NewSQLCipherCodec(s.Passphrase, s.CipherCompatibility)
+			if err != nil {
+				return nil, err
+			}
+			open = func(path string) (*engine.Database, error) { return engine.OpenEncrypted(path, codec) }
+		}
+		database, err := open(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		database.SetBusyTimeout(s.BusyTimeout)
+		database.SetLimits(s.Limits)
+		database.SetReadOnlySQL(s.ReadOnlySQL)
+		database.SetPermissive(s.Permissive)
+		database.SetVerifyChecksums(s.VerifyChecksums)
+		database.SetLogger(s.Logger)
+		database.SetUnicode(s.Unicode)
+		if s.RandomSeed != nil {
+			database.SetRandomSeed(*s.RandomSeed)
+		}
+		if s.Verify {
+			if s.oracle, err = openOracle(s.Path); err != nil {
+				database.Close()
+				return nil, err
+			}
+		}
+		s.database = database
+	}
+	return s.database, nil
+}
+
+// Execute runs a single dot-command or SQL statement, writing its output to
+// standard output or where .once sent it.
+func (s *Session) Execute(command string) error {
+	name, argument, _ := strings.Cut(strings.TrimSpace(command), " ")
+	argument = strings.TrimSpace(argument)
+	switch name {
+	case ".once":
+		return s.setOnce(argument)
+	case ".excel":
+		return s.setOnce("-x")
+	}
+	if s.once == nil {
+		return s.execute(os.Stdout, command, name, argument)
+	}
+	return s.executeOnce(command, name, argument)
+}
+
+func (s *Session) execute(out io.Writer, command, name, argument string) error {
+	switch name {
+	case ".nullvalue":
+		s.Formatter.NullValue = unquoteArgument(argument)
+		return nil
+	case ".mode":
+		mode, err := ParseOutputMode(argument)
+		if err != nil {
+			return err
+		}
+		s.Formatter.Mode = mode
+		return nil
+	case ".headers":
+		on, err := parseSwitch(argument)
+		if err != nil {
+			return err
+		}
+		s.Formatter.Header = on
+		return nil
+	case ".timer":
+		on, err := parseSwitch(argument)
+		if err != nil {
+			return err
+		}
+		s.Timer = on
+		return nil
+	case ".read":
+		return s.read(unquoteArgument(argument))
+	case ".help":
+		return HandleHelp(out)
+	case ".fingerprint":
+		return HandleFingerprint(out, argument)
+	case ".open":
+		return s.reopen(argument)
+	case ".quit":
+		return ErrQuit
+	}
+
+	database, err := s.open()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(command, ".") {
+		if err := HandleQuery(out, database, command, s.Formatter); err != nil || s.oracle == nil {
+			return err
+		}
+		return s.verifyQuery(database, command)
+	}
+
+	switch name {
+	case ".copy":
+		return HandleCopy(database, argument, s.Logger)
+	case ".dbinfo":
+		return HandleDBInfo(database)
+	case ".schemagraph":
+		return HandleSchemaGraph(out, database, argument)
+	case ".tables":
+		return HandleTables(database)
+	case ".dbstat":
+		return HandleDBStat(database)
+	case ".databases":
+		return HandleDatabases(database)
+	case ".rawpage":
+		return HandleRawPage(database, argument)
+	case ".sample":
+		return HandleSample(out, database, argument, s.Formatter)
+	case ".watch":
+		return HandleWatch(out, database, argument)
+	case ".wal-checkpoint":
+		// An optional mode, such as TRUNCATE, as PRAGMA wal_checkpoint takes
+		return HandleQuery(out, database, "PRAGMA wal_checkpoint("+argument+")", s.Formatter)
+	default:
+		return fmt.Errorf("unknown command: %s", name)
+	}
+}
+
+// reopen closes the session's database and opens the one .open names,
+// keeping every other setting. --readonly opens it for reading only, and
+// --create writes a new, empty database first when the file is missing.
+func (s *Session) reopen(argument string) error {
+	var path string
+	readOnly, create := false, false
+	for _, field := range strings.Fields(argument) {
+		switch {
+		case field == "--readonly":
+			readOnly = true
+		case field == "--create":
+			create = true
+		case strings.HasPrefix(field, "--"):
+			return fmt.Errorf("unknown option: %s", field)
+		case path != "":
+			return fmt.Errorf("extra argument: %s", field)
+		default:
+			path = unquoteArgument(field)
+		}
+	}
+	if path == "" {
+		return fmt.Errorf("usage: .open ?--readonly? ?--create? FILE")
+	}
+
+	if err := s.Close(); err != nil {
+		return err
+	}
+	s.Path, s.ReadOnly = path, readOnly
+	if create {
+		database, err := engine.Create(path)
+		if err == nil {
+			database.Close()
+		} else if !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	_, err := s.open()
+	return err
+}
+
+// unquoteArgument strips one layer of matching single or double quotes, so
+// `.nullvalue ”` sets an empty marker as it does in sqlite3.
+func unquoteArgument(argument string) string {
+	if len(argument) >= 2 {
+		first, last := argument[0], argument[len(argument)-1]
+		if (first == '\'' || first == '"') && first == last {
+			return argument[1 : len(argument)-1]
+		}
+	}
+	return argument
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrQuit is returned by Execute for .quit, which stops reading commands.
+var ErrQuit = errors.New("quit")
+
+// Prompts shown when reading commands from a terminal: before a command,
+// and before each further line of a statement not yet ended.
+const (
+	MainPrompt     = "sqlite> "
+	ContinuePrompt = "   ...> "
+)
+
+// RunBatch runs the commands of a script read from r, as sqlite3 does with
+// its standard input when that is not a terminal: in order, stopping at the
+// first that fails. Each runs as soon as it has been read, so that the
+// script is never held in memory whole. .quit stops it without error.
+func (s *Session) RunBatch(r io.Reader) error {
+	run := func(commands []scriptCommand) error {
+		for _, command := range commands {
+			if err := s.executeInput(command.text); err != nil {
+				return fmt.Errorf("near line %d: %w", command.line, err)
+			}
+		}
+		return nil
+	}
+	// A last statement may end without its semicolon
+	rest, err := readCommands(r, func(bool) {}, run)
+	if err == nil {
+		err = run(rest.split())
+	}
+	if !errors.Is(err, ErrQuit) {
+		return err
+	}
+	return nil
+}
+
+// RunInteractive reads commands from r a line at a time, writing a prompt
+// to w before each, and runs each as soon as it is complete: a dot-command
+// at the end of its line and a statement at its semicolon. A command that
+// fails is passed to report and reading carries on, as sqlite3's shell
+// does. It returns at the end of r or at .quit.
+func (s *Session) RunInteractive(r io.Reader, w io.Writer, report func(error)) error {
+	fmt.Fprintln(w, `Enter ".help" for usage hints.`)
+	prompt := func(continuing bool) {
+		if continuing {
+			fmt.Fprint(w, ContinuePrompt)
+			return
+		}
+		fmt.Fprint(w, MainPrompt)
+	}
+	rest, err := readCommands(r, prompt, func(commands []scriptCommand) error {
+		for _, command := range commands {
+			if err := s.executeInput(command.text); errors.Is(err, ErrQuit) {
+				return err
+			} else if err != nil {
+				report(err)
+				break
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, ErrQuit) {
+		return nil
+	}
+	fmt.Fprintln(w)
+	if err != nil {
+		return err
+	}
+	if text := strings.TrimSpace(rest.text); text != "" {
+		report(fmt.Errorf("incomplete input: %s", text))
+	}
+	return nil
+}
+
+// executeInput runs a command read from input, followed for a statement by
+// the time it took when Timer is set, as sqlite3 times the statements it
+// reads but not those given as arguments.
+func (s *Session) executeInput(command string) error {
+	if !s.Timer || strings.HasPrefix(command, ".") {
+		return s.Execute(command)
+	}
+	start, user, system := time.Now(), userTime(), systemTime()
+	err := s.Execute(command)
+	fmt.Printf("Run Time: real %.3f user %f sys %f\n", time.Since(start).Seconds(), (userTime() - user).Seconds(), (systemTime() - system).Seconds())
+	return err
+}
+
+// readCommands reads commands from r a line at a time, calling prompt
+// before each line with whether a statement is pending, and passes to run
+// each dot-command at the end of its line and the statements a line ends
+// at its semicolon, each with the line it starts on. It stops at the end
+// of r, returning the text left without a semicolon, or at the first error
+// run returns.
+func readCommands(r io.Reader, prompt func(continuing bool), run func([]scriptCommand) error) (scriptCommand, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<30)
+	var pending strings.Builder
+	// first is the line pending starts on
+	first := 1
+	for line := 1; ; line++ {
+		prompt(pending.Len() > 0)
+		if !scanner.Scan() {
+			break
+		}
+		text := scanner.Text()
+		if pending.Len() == 0 && strings.HasPrefix(strings.TrimSpace(text), ".") {
+			if err := run([]scriptCommand{{text: strings.TrimSpace(text), line: line}}); err != nil {
+				return scriptCommand{}, err
+			}
+			continue
+		}
+		if pending.Len() == 0 {
+			// Blank lines between commands start no statement
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			first = line
+		}
+		pending.WriteString(text)
+		pending.WriteByte('\n')
+		if !statementComplete(pending.String()) {
+			continue
+		}
+		if err := run(scriptCommand{text: pending.String(), line: first}.split()); err != nil {
+			return scriptCommand{}, err
+		}
+		pending.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return scriptCommand{}, err
+	}
+	return scriptCommand{text: pending.String(), line: first}, nil
+}
+
+// split splits text read from the line command starts on into its
+// commands, numbered from that line.
+func (command scriptCommand) split() []scriptCommand {
+	commands := splitScript(command.text)
+	for i := range commands {
+		commands[i].line += command.line - 1
+	}
+	return commands
+}
+
+// statementComplete reports whether SQL ends with a semicolon outside
+// quotes and comments, so that it can be run, as sqlite3_complete does.
+func statementComplete(sql string) bool {
+	complete := false
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closer := c
+			if c == '[' {
+				closer = ']'
+			}
+			end := strings.IndexByte(sql[i+1:], closer)
+			if end < 0 {
+				return false
+			}
+			i += end + 1
+			complete = false
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				return complete
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return false
+			}
+			i += end + 3
+		case c == ';':
+			complete = true
+		case c != ' ' && c != '\t' && c != '\n' && c != '\r':
+			complete = false
+		}
+	}
+	return complete
+}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestStatementComplete(t *testing.T) {
+	for sql, want := range map[string]bool{
+		"SELECT 1;":                     true,
+		"SELECT 1":                      false,
+		"SELECT 1;  \n":                 true,
+		"SELECT ';'":                    false,
+		"SELECT 'it''s';":               true,
+		"SELECT \"a;\nb\"":              false,
+		"SELECT 1; -- done":             true,
+		"SELECT 1 -- not yet;":          false,
+		"SELECT 1 /* ; */":              false,
+		"SELECT 1 /* unterminated ;":    false,
+		"SELECT 1; SELECT 2":            false,
+		"SELECT [a;b] FROM t;":          true,
+		"INSERT INTO t VALUES (1);\n\n": true,
+	} {
+		if got := statementComplete(sql); got != want {
+			t.Errorf("statementComplete(%q) = %v, want %v", sql, got, want)
+		}
+	}
+}
+
+func TestRunInteractive(t *testing.T) {
+	session := NewSession("missing.db")
+	input := ".mode csv\n.mode bogus\nSELECT\n  1\n;\n.nullvalue X\n.quit\n.mode json\n"
+	var prompts strings.Builder
+	var reported []error
+	if err := session.RunInteractive(strings.NewReader(input), &prompts, func(err error) { reported = append(reported, err) }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failures are reported and reading carries on, until .quit
+	if len(reported) != 2 || !strings.Contains(reported[0].Error(), "bogus") {
+		t.Fatalf("reported %v", reported)
+	}
+	if session.Formatter.Mode != ModeCSV || session.Formatter.NullValue != "X" {
+		t.Fatalf("formatter after input: %+v", session.Formatter)
+	}
+	want := strings.Repeat(MainPrompt, 3) + strings.Repeat(ContinuePrompt, 2) + strings.Repeat(MainPrompt, 2)
+	if got := strings.TrimPrefix(prompts.String(), "Enter \".help\" for usage hints.\n"); got != want {
+		t.Errorf("prompts %q, want %q", got, want)
+	}
+
+	reported = nil
+	if err := session.RunInteractive(strings.NewReader("SELECT 1"), &prompts, func(err error) { reported = append(reported, err) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), "incomplete input") {
+		t.Errorf("unterminated statement reported %v", reported)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	session := NewSession("missing.db")
+	if err := session.RunBatch(strings.NewReader(".mode csv\n.quit\n.mode json\n")); err != nil {
+		t.Fatal(err)
+	}
+	if session.Formatter.Mode != ModeCSV {
+		t.Errorf("mode %v after .quit, want csv", session.Formatter.Mode)
+	}
+
+	// The first command that fails stops the script
+	err := session.RunBatch(strings.NewReader(".nullvalue X\n.mode bogus\n.nullvalue Y\n"))
+	if err == nil || !strings.Contains(err.Error(), "near line 2") || errors.Is(err, ErrQuit) {
+		t.Fatalf("RunBatch: %v", err)
+	}
+	if session.Formatter.NullValue != "X" {
+		t.Errorf("NULL marker %q, want the one set before the failure", session.Formatter.NullValue)
+	}
+
+	// A statement's line is the one it starts on, past blank lines
+	err = session.RunBatch(strings.NewReader("\n.nullvalue Y\n\nSELECT\n  1;\n"))
+	if err == nil || !strings.Contains(err.Error(), "near line 4") {
+		t.Errorf("RunBatch: %v", err)
+	}
+
+	// Commands run as they are read, before the rest of the script is
+	failing := io.MultiReader(strings.NewReader(".nullvalue Z\n"), iotest.ErrReader(errors.New("read failed")))
+	if err := session.RunBatch(failing); err == nil || err.Error() != "read failed" {
+		t.Errorf("RunBatch: %v", err)
+	}
+	if session.Formatter.NullValue != "Z" {
+		t.Errorf("NULL marker %q, want the one set before the read failed", session.Formatter.NullValue)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package cli
+
+import "time"
+
+// userTime and systemTime are zero where the process's CPU time cannot be
+// read, leaving .timer the real time alone.
+func userTime() time.Duration { return 0 }
+
+func systemTime() time.Duration { return 0 }
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
+)
+
+// oracle runs queries through a reference SQLite implementation, opened
+// read-only on the same database, for --verify to compare results with.
+type oracle interface {
+	query(query string) (columns []string, rows [][]any, err error)
+	Close() error
+}
+
+// verifyQuery reruns a SELECT through both the engine and the oracle and
+// reports a mismatch on stderr, returning an error so scripts notice. Only
+// SELECTs are checked, since running a write twice would apply it twice.
+func (s *Session) verifyQuery(database *engine.Database, query string) error {
+	if !isSelect(query) {
+		return nil
+	}
+
+	resultSet, err := database.Query(query)
+	if err != nil {
+		return err
+	}
+	columns := make([]string, len(resultSet.Columns))
+	for i, column := range resultSet.Columns {
+		columns[i] = column.Name
+	}
+	ours, err := resultSet.All()
+	if err != nil {
+		return err
+	}
+	theirColumns, theirs, err := s.oracle.query(query)
+	if err != nil {
+		return fmt.Errorf("verify: sqlite: %w", err)
+	}
+
+	ordered := strings.Contains(strings.ToUpper(query), "ORDER BY")
+	if difference := diffResults(columns, ours, theirColumns, theirs, ordered); difference != "" {
+		fmt.Fprintf(os.Stderr, "verify: %s\n  query: %s\n", difference, query)
+		return fmt.Errorf("verify: results differ from SQLite")
+	}
+	return nil
+}
+
+// isSelect reports whether a statement is a SELECT.
+func isSelect(query string) bool {
+	keyword, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	return strings.EqualFold(keyword, "SELECT")
+}
+
+// diffResults describes the first difference between two results, or
+// returns "" when they match. Values are compared as SQL literals, so 1,
+// 1.0 and '1' all differ. Unless the query orders its rows, they may come
+// in any order.
+func diffResults(columns []string, ours [][]any, theirColumns []string, theirs [][]any, ordered bool) string {
+	if !slices.Equal(columns, theirColumns) {
+		return fmt.Sprintf("columns %v, sqlite has %v", columns, theirColumns)
+	}
+
+	literals := func(rows [][]any) []string {
+		formatter := Formatter{Mode: ModeQuote}
+		lines := make([]string, len(rows))
+		for i, row := range rows {
+			values := make([]string, len(row))
+			for j, value := range row {
+				values[j] = formatter.FormatValue(value)
+			}
+			lines[i] = strings.Join(values, ",")
+		}
+		if !ordered {
+			slices.Sort(lines)
+		}
+		return lines
+	}
+	ourLines, theirLines := literals(ours), literals(theirs)
+	for i := range min(len(ourLines), len(theirLines)) {
+		if ourLines[i] != theirLines[i] {
+			return fmt.Sprintf("row %d is %s, sqlite has %s", i+1, ourLines[i], theirLines[i])
+		}
+	}
+	if len(ourLines) != len(theirLines) {
+		return fmt.Sprintf("%d rows, sqlite has %d", len(ourLines), len(theirLines))
+	}
+	return ""
+}
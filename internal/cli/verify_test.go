@@ -0,0 +1,26 @@
+package cli
+
+import "testing"
+
+func TestDiffResults(t *testing.T) {
+	columns := []string{"id", "name"}
+	rows := [][]any{{int64(1), "one"}, {int64(2), nil}}
+
+	tests := []struct {
+		theirColumns []string
+		theirs       [][]any
+		ordered      bool
+		want         string
+	}{
+		{columns, [][]any{{int64(2), nil}, {int64(1), "one"}}, false, ""},
+		{columns, [][]any{{int64(2), nil}, {int64(1), "one"}}, true, "row 1 is 1,'one', sqlite has 2,NULL"},
+		{columns, [][]any{{int64(1), "one"}, {2.0, nil}}, true, "row 2 is 2,NULL, sqlite has 2.0,NULL"},
+		{columns, [][]any{{int64(1), "one"}, {int64(2), nil}, {int64(3), "three"}}, true, "2 rows, sqlite has 3"},
+		{[]string{"id", "label"}, rows, true, "columns [id name], sqlite has [id label]"},
+	}
+	for _, test := range tests {
+		if got := diffResults(columns, rows, test.theirColumns, test.theirs, test.ordered); got != test.want {
+			t.Errorf("diffResults(%v, ordered %v) = %q, want %q", test.theirs, test.ordered, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+const benchmarkRows = 100_000
+
+// labeled runs fn under a pprof label, so CPU profiles taken with
+// -cpuprofile attribute samples to the hot path being measured.
+func labeled(name string, fn func()) {
+	pprof.Do(context.Background(), pprof.Labels("hotpath", name), func(context.Context) { fn() })
+}
+
+func BenchmarkFullTableScan(b *testing.B) {
+	dbFile, header, rootPage := generatedTable(b, testgen.Options{}, benchmarkRows)
+
+	labeled("scan", func() {
+		for b.Loop() {
+			rows := 0
+			err := dbFile.WalkBTree(header, rootPage, func(_ uint32, page *Page) error {
+				if page.PageType != LeafTable {
+					return nil
+				}
+				leafRows, err := ReadAllRows(page)
+				rows += len(leafRows)
+				return err
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if rows != benchmarkRows {
+				b.Fatalf("scanned %d rows, want %d", rows, benchmarkRows)
+			}
+		}
+	})
+
+	b.ReportMetric(float64(benchmarkRows*b.N)/b.Elapsed().Seconds(), "rows/s")
+}
+
+func BenchmarkScanColumns(b *testing.B) {
+	dbFile, header, rootPage := generatedTable(b, testgen.Options{}, benchmarkRows)
+
+	labeled("columns", func() {
+		for b.Loop() {
+			rows := 0
+			err := dbFile.ScanColumns(header, rootPage, []int{1, 2}, func(batch *Batch) error {
+				rows += batch.Len
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if rows != benchmarkRows {
+				b.Fatalf("scanned %d rows, want %d", rows, benchmarkRows)
+			}
+		}
+	})
+
+	b.ReportMetric(float64(benchmarkRows*b.N)/b.Elapsed().Seconds(), "rows/s")
+}
+
+func BenchmarkCountRows(b *testing.B) {
+	dbFile, header, rootPage := generatedTable(b, testgen.Options{}, benchmarkRows)
+
+	labeled("count", func() {
+		for b.Loop() {
+			count, err := dbFile.CountRows(header, rootPage)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if count != benchmarkRows {
+				b.Fatalf("counted %d rows, want %d", count, benchmarkRows)
+			}
+		}
+	})
+}
+
+func BenchmarkRowIDLookup(b *testing.B) {
+	dbFile, header, rootPage := generatedTable(b, testgen.Options{}, benchmarkRows)
+
+	labeled("rowid-lookup", func() {
+		rowID := int64(1)
+		for b.Loop() {
+			row, err := dbFile.SeekRowID(header, rootPage, rowID)
+			if err != nil || row == nil {
+				b.Fatalf("seeking rowid %d: %v", rowID, err)
+			}
+			rowID = rowID*7919%benchmarkRows + 1
+		}
+	})
+}
+
+func BenchmarkReadVarint(b *testing.B) {
+	var encoded []byte
+	for _, value := range []uint64{1, 300, 1 << 20, 1 << 35, 1 << 62} {
+		encoded = appendVarint(encoded, value)
+	}
+	reader := bytes.NewReader(encoded)
+
+	labeled("varint", func() {
+		for b.Loop() {
+			reader.Reset(encoded)
+			for range 5 {
+				if _, _, err := ReadVarint(reader); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkDecodeRecord(b *testing.B) {
+	record, err := testgen.EncodeRecord(nil, "Granny Smith", "Light Green", int64(42), 3.25, []byte{0xde, 0xad})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(record)))
+
+	labeled("record-decode", func() {
+		for b.Loop() {
+			if _, _, _, err := decodeRecord(record, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
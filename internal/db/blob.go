@@ -0,0 +1,195 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoSuchRow is returned by OpenBlob when the table has no row with the
+// requested rowid.
+var ErrNoSuchRow = errors.New("no such rowid")
+
+// OpenBlob returns a reader over one text or blob value of the row with the
+// given rowid in the table b-tree rooted at rootPage. Only the row's local
+// cell is read up front; the value's bytes are fetched from the page and its
+// overflow chain as they are read, so large values are never held in memory.
+// The reader is valid while databaseFile stays open.
+func (databaseFile *DatabaseFile) OpenBlob(databaseHeader *DatabaseHeader, rootPage uint32, rowID int64, column int) (*io.SectionReader, error) {
+	page, cellIndex, err := databaseFile.seekRowIDCell(databaseHeader, rootPage, rowID)
+	if err != nil {
+		return nil, err
+	}
+	if page == nil {
+		return nil, fmt.Errorf("%w: %d", ErrNoSuchRow, rowID)
+	}
+
+	payload, err := databaseFile.openPayload(databaseHeader, page, cellIndex)
+	if err != nil {
+		return nil, fmt.Errorf("page %d: %w", page.PageNumber, err)
+	}
+
+	offset, length, serialType, err := locateColumn(payload, column)
+	if err != nil {
+		return nil, fmt.Errorf("rowid %d: %w", rowID, err)
+	}
+	if serialType < 12 {
+		return nil, fmt.Errorf("rowid %d: column %d is not text or a blob (serial type %d)", rowID, column, serialType)
+	}
+
+	return io.NewSectionReader(payload, offset, length), nil
+}
+
+// locateColumn reads just enough of a record's header to find where a
+// column's value lies within the payload.
+func locateColumn(payload *payloadReader, column int) (offset, length int64, serialType uint64, err error) {
+	var prefix [9]byte
+	n, err := payload.ReadAt(prefix[:], 0)
+	if err != nil && err != io.EOF {
+		return 0, 0, 0, err
+	}
+	headerSize, headerBytes := varintAt(prefix[:n])
+	if headerBytes == 0 || headerSize < uint64(headerBytes) || int64(headerSize) > payload.size {
+		return 0, 0, 0, fmt.Errorf("malformed record header")
+	}
+
+	header := make([]byte, headerSize)
+	if _, err := payload.ReadAt(header, 0); err != nil {
+		return 0, 0, 0, fmt.Errorf("read record header: %w", err)
+	}
+	header = header[headerBytes:]
+
+	offset = int64(headerSize)
+	for i := 0; len(header) > 0; i++ {
+		serialType, n := varintAt(header)
+		if n == 0 {
+			return 0, 0, 0, fmt.Errorf("read serial type: %w", io.ErrUnexpectedEOF)
+		}
+		header = header[n:]
+
+		size, err := columnRawValueLength(serialType)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("column %d: %w", i, err)
+		}
+		if offset+int64(size) > payload.size {
+			return 0, 0, 0, fmt.Errorf("column %d: value extends past %d byte record", i, payload.size)
+		}
+		if i == column {
+			return offset, int64(size), serialType, nil
+		}
+		offset += int64(size)
+	}
+
+	return 0, 0, 0, fmt.Errorf("record has no column %d", column)
+}
+
+// payloadReader gives random access to a cell's payload, reading overflow
+// pages on demand. Overflow page numbers are learned by following the chain,
+// and remembered so that seeking backwards does not walk it again.
+type payloadReader struct {
+	file         *DatabaseFile
+	header       *DatabaseHeader
+	size         int64
+	local        []byte
+	overflow     []uint32
+	overflowSize int64
+}
+
+func (databaseFile *DatabaseFile) openPayload(databaseHeader *DatabaseHeader, page *Page, cellIndex int) (*payloadReader, error) {
+	if page.PageType != LeafTable {
+		return nil, fmt.Errorf("page type %d is not a table leaf", page.PageType)
+	}
+
+	cellData, err := CellData(page, cellIndex)
+	if err != nil {
+		return nil, err
+	}
+	payloadSize, sizeBytes := varintAt(cellData)
+	_, rowIDBytes := varintAt(cellData[sizeBytes:])
+	if sizeBytes == 0 || rowIDBytes == 0 {
+		return nil, corruptCell(page.PageNumber, cellIndex, "truncated cell header")
+	}
+	if payloadSize > maxPayloadSize {
+		return nil, corruptCell(page.PageNumber, cellIndex, "payload of %d bytes is too large", payloadSize)
+	}
+	cellData = cellData[sizeBytes+rowIDBytes:]
+
+	usableSize := databaseHeader.UsableSize()
+	localSize := localPayloadSize(page.PageType, usableSize, payloadSize)
+	reader := &payloadReader{file: databaseFile, header: databaseHeader, size: int64(payloadSize), overflowSize: int64(usableSize - 4)}
+	if uint64(localSize) == payloadSize {
+		if len(cellData) < localSize {
+			return nil, corruptCell(page.PageNumber, cellIndex, "payload of %d bytes extends past usable page area", payloadSize)
+		}
+		reader.local = cellData[:localSize]
+		return reader, nil
+	}
+
+	if len(cellData) < localSize+4 {
+		return nil, corruptCell(page.PageNumber, cellIndex, "local payload of %d bytes extends past usable page area", localSize)
+	}
+	reader.local = cellData[:localSize]
+	reader.overflow = []uint32{binary.BigEndian.Uint32(cellData[localSize : localSize+4])}
+	return reader, nil
+}
+
+// ReadAt implements io.ReaderAt over the full payload.
+func (reader *payloadReader) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("negative payload offset %d", offset)
+	}
+
+	read := 0
+	for read < len(p) {
+		position := offset + int64(read)
+		if position >= reader.size {
+			return read, io.EOF
+		}
+
+		if position < int64(len(reader.local)) {
+			read += copy(p[read:], reader.local[position:])
+			continue
+		}
+
+		index := int((position - int64(len(reader.local))) / reader.overflowSize)
+		pageNumber, err := reader.overflowPage(index)
+		if err != nil {
+			return read, err
+		}
+
+		within := (position - int64(len(reader.local))) % reader.overflowSize
+		chunk := min(int64(len(p)-read), reader.overflowSize-within, reader.size-position)
+		start := int64(pageNumber-1)*int64(reader.header.PageSize) + 4 + within
+		if _, err := reader.file.ReadAt(p[read:read+int(chunk)], start); err != nil {
+			return read, fmt.Errorf("overflow page %d: %w", pageNumber, err)
+		}
+		read += int(chunk)
+	}
+	return read, nil
+}
+
+// overflowPage returns the number of the index'th overflow page, following
+// the chain's next pointers as far as needed.
+func (reader *payloadReader) overflowPage(index int) (uint32, error) {
+	for len(reader.overflow) <= index {
+		last, err := reader.validOverflowPage(len(reader.overflow) - 1)
+		if err != nil {
+			return 0, err
+		}
+		var next [4]byte
+		if _, err := reader.file.ReadAt(next[:], int64(last-1)*int64(reader.header.PageSize)); err != nil {
+			return 0, fmt.Errorf("overflow page %d: %w", last, err)
+		}
+		reader.overflow = append(reader.overflow, binary.BigEndian.Uint32(next[:]))
+	}
+	return reader.validOverflowPage(index)
+}
+
+func (reader *payloadReader) validOverflowPage(index int) (uint32, error) {
+	pageNumber := reader.overflow[index]
+	if pageNumber == 0 || (reader.header.PageCount != 0 && pageNumber > reader.header.PageCount) {
+		return 0, fmt.Errorf("overflow chain broken at link %d (page %d)", index, pageNumber)
+	}
+	return pageNumber, nil
+}
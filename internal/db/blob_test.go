@@ -0,0 +1,80 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestOpenBlobStreamsAcrossOverflowPages(t *testing.T) {
+	content := make([]byte, 5000)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+
+	database := testgen.New(testgen.Options{PageSize: 512})
+	files := database.CreateTable("files", "CREATE TABLE files (id integer primary key, name text, content blob)")
+	files.Insert(1, nil, "small", []byte("tiny"))
+	files.Insert(2, nil, "large", content)
+
+	dbFile, header, err := OpenDatabaseFile(database.WriteTemp(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbFile.Close()
+	objects, err := dbFile.ReadSchema(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPage, err := RootPageLookup("files", objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := dbFile.OpenBlob(header, rootPage, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Size() != int64(len(content)) {
+		t.Fatalf("blob size %d, want %d", blob.Size(), len(content))
+	}
+
+	// Small reads cross every page boundary along the chain
+	var streamed bytes.Buffer
+	if _, err := io.CopyBuffer(&streamed, io.LimitReader(blob, blob.Size()), make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(streamed.Bytes(), content) {
+		t.Fatal("streamed blob differs from the inserted content")
+	}
+
+	// Seeking back reuses the chain already followed
+	if _, err := blob.Seek(1234, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	chunk := make([]byte, 2000)
+	if _, err := io.ReadFull(blob, chunk); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chunk, content[1234:3234]) {
+		t.Fatal("read after seek differs from the inserted content")
+	}
+
+	name, err := dbFile.OpenBlob(header, rootPage, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, _ := io.ReadAll(name); string(text) != "small" {
+		t.Fatalf("unexpected text column: %q", text)
+	}
+
+	if _, err := dbFile.OpenBlob(header, rootPage, 3, 2); !errors.Is(err, ErrNoSuchRow) {
+		t.Fatalf("missing rowid: got %v, want ErrNoSuchRow", err)
+	}
+	if _, err := dbFile.OpenBlob(header, rootPage, 1, 0); err == nil {
+		t.Fatal("expected an error opening a NULL column as a blob")
+	}
+}
@@ -0,0 +1,249 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// maxBTreeDepth bounds descents so a corrupt file with a cyclic tree fails
+// rather than looping. Real trees rarely exceed a handful of levels.
+const maxBTreeDepth = 64
+
+// ChildPages returns the child page numbers of an interior page in key order,
+// ending with the right-most pointer. Leaf pages have no children.
+func ChildPages(page *Page) ([]uint32, error) {
+	if page.PageType != InteriorTable && page.PageType != InteriorIndex {
+		return nil, nil
+	}
+
+	children := make([]uint32, 0, int(page.CellCount)+1)
+	for i := 0; i < int(page.CellCount); i++ {
+		cellData, err := CellData(page, i)
+		if err != nil {
+			return nil, err
+		}
+		if len(cellData) < 4 {
+			return nil, fmt.Errorf("cell %d: left child pointer truncated", i)
+		}
+		children = append(children, binary.BigEndian.Uint32(cellData[0:4]))
+	}
+
+	return append(children, page.RightPointer), nil
+}
+
+// WalkBTree visits every page of the b-tree rooted at rootPage in depth-first
+// key order, parents before their children.
+func (databaseFile *DatabaseFile) WalkBTree(databaseHeader *DatabaseHeader, rootPage uint32, visit func(pageNumber uint32, page *Page) error) error {
+	visited := make(map[uint32]bool)
+
+	var walk func(pageNumber uint32) error
+	walk = func(pageNumber uint32) error {
+		if visited[pageNumber] {
+			return fmt.Errorf("page %d: b-tree rooted at %d contains a cycle", pageNumber, rootPage)
+		}
+		visited[pageNumber] = true
+
+		page, err := databaseFile.NewPage(databaseHeader, pageNumber)
+		if err != nil {
+			return err
+		}
+
+		if err := visit(pageNumber, page); err != nil {
+			return err
+		}
+
+		children, err := ChildPages(page)
+		if err != nil {
+			return fmt.Errorf("page %d: %w", pageNumber, err)
+		}
+		for _, child := range children {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walk(rootPage)
+}
+
+// CellRowID reads the integer key of a table b-tree cell without decoding
+// its record.
+func CellRowID(page *Page, cellIndex int) (int64, error) {
+	cellData, err := CellData(page, cellIndex)
+	if err != nil {
+		return 0, err
+	}
+
+	reader := bytes.NewReader(cellData)
+	switch page.PageType {
+	case InteriorTable:
+		if _, err := reader.Seek(4, io.SeekStart); err != nil {
+			return 0, err
+		}
+	case LeafTable:
+		if _, _, err := ReadVarint(reader); err != nil {
+			return 0, fmt.Errorf("cell %d: read record size: %w", cellIndex, err)
+		}
+	default:
+		return 0, fmt.Errorf("page type %d is not a table page", page.PageType)
+	}
+
+	rowID, _, err := ReadVarint(reader)
+	if err != nil {
+		return 0, fmt.Errorf("cell %d: read row ID: %w", cellIndex, err)
+	}
+	return int64(rowID), nil
+}
+
+// SeekRowID descends the table b-tree rooted at rootPage to the row with the
+// given rowid. It returns a nil row when no such row exists.
+func (databaseFile *DatabaseFile) SeekRowID(databaseHeader *DatabaseHeader, rootPage uint32, rowID int64) (*Row, error) {
+	page, cellIndex, err := databaseFile.seekRowIDCell(databaseHeader, rootPage, rowID)
+	if err != nil || page == nil {
+		return nil, err
+	}
+
+	row, err := readRow(databaseFile, databaseHeader, page, cellIndex, nil)
+	if err != nil {
+		return nil, fmt.Errorf("page %d: %w", page.PageNumber, err)
+	}
+	return row, nil
+}
+
+// seekRowIDCell finds the leaf page and cell holding rowID, returning a nil
+// page when no such row exists.
+func (databaseFile *DatabaseFile) seekRowIDCell(databaseHeader *DatabaseHeader, rootPage uint32, rowID int64) (*Page, int, error) {
+	pageNumber := rootPage
+	for depth := 0; ; depth++ {
+		if depth > maxBTreeDepth {
+			return nil, 0, fmt.Errorf("b-tree rooted at %d deeper than %d levels", rootPage, maxBTreeDepth)
+		}
+
+		page, err := databaseFile.NewPage(databaseHeader, pageNumber)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// Find the first cell whose key is at least rowID
+		var searchErr error
+		index := sort.Search(int(page.CellCount), func(i int) bool {
+			key, err := CellRowID(page, i)
+			if err != nil && searchErr == nil {
+				searchErr = err
+			}
+			return key >= rowID
+		})
+		if searchErr != nil {
+			return nil, 0, fmt.Errorf("page %d: %w", pageNumber, searchErr)
+		}
+
+		switch page.PageType {
+		case LeafTable:
+			if index == int(page.CellCount) {
+				return nil, 0, nil
+			}
+			key, err := CellRowID(page, index)
+			if err != nil {
+				return nil, 0, fmt.Errorf("page %d: %w", pageNumber, err)
+			}
+			if key != rowID {
+				return nil, 0, nil
+			}
+			return page, index, nil
+		case InteriorTable:
+			children, err := ChildPages(page)
+			if err != nil {
+				return nil, 0, fmt.Errorf("page %d: %w", pageNumber, err)
+			}
+			pageNumber = children[index]
+		default:
+			return nil, 0, fmt.Errorf("page %d: type %d is not a table page", pageNumber, page.PageType)
+		}
+	}
+}
+
+// CountRows counts the rows of the table b-tree rooted at rootPage. Interior
+// pages are read in full for their child pointers, but of leaves only the
+// header is read for their cell count, so no record is ever decoded.
+func (databaseFile *DatabaseFile) CountRows(databaseHeader *DatabaseHeader, rootPage uint32) (int64, error) {
+	page, err := databaseFile.NewPage(databaseHeader, rootPage)
+	if err != nil {
+		return 0, err
+	}
+	switch page.PageType {
+	case LeafTable:
+		return int64(page.CellCount), nil
+	case InteriorTable:
+		return databaseFile.countRows(databaseHeader, page, 0)
+	}
+	return 0, fmt.Errorf("page %d: type %d is not a table page", rootPage, page.PageType)
+}
+
+// countRows sums the rows under an interior page. Every child of a page
+// sits at the same depth, so once the first child turns out to be interior
+// the rest are read in full straight away, and every page is read once
+// apart from the first child at each such level.
+func (databaseFile *DatabaseFile) countRows(databaseHeader *DatabaseHeader, page *Page, depth int) (int64, error) {
+	if depth > maxBTreeDepth {
+		return 0, fmt.Errorf("page %d: b-tree deeper than %d levels", page.PageNumber, maxBTreeDepth)
+	}
+	children, err := ChildPages(page)
+	if err != nil {
+		return 0, fmt.Errorf("page %d: %w", page.PageNumber, err)
+	}
+
+	var total int64
+	interior := false
+	for _, child := range children {
+		if !interior {
+			childType, childCells, err := databaseFile.readPageHeader(databaseHeader, child)
+			if err != nil {
+				return 0, err
+			}
+			switch childType {
+			case LeafTable:
+				total += int64(childCells)
+				continue
+			case InteriorTable:
+				interior = true
+			default:
+				return 0, fmt.Errorf("page %d: type %d is not a table page", child, childType)
+			}
+		}
+
+		childPage, err := databaseFile.NewPage(databaseHeader, child)
+		if err != nil {
+			return 0, err
+		}
+		if childPage.PageType != InteriorTable {
+			return 0, fmt.Errorf("page %d: type %d where an interior table page was expected", child, childPage.PageType)
+		}
+		count, err := databaseFile.countRows(databaseHeader, childPage, depth+1)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// readPageHeader reads just the type and cell count of a b-tree page.
+func (databaseFile *DatabaseFile) readPageHeader(databaseHeader *DatabaseHeader, pageNumber uint32) (BTreePageType, uint16, error) {
+	if err := databaseFile.progress(); err != nil {
+		return 0, 0, err
+	}
+	start, _, contentOffset, err := pageBounds(databaseHeader, pageNumber)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var header [5]byte
+	if _, err := databaseFile.ReadAt(header[:], start+int64(contentOffset)); err != nil {
+		return 0, 0, fmt.Errorf("page %d: read header: %w", pageNumber, err)
+	}
+	return BTreePageType(header[0]), binary.BigEndian.Uint16(header[3:5]), nil
+}
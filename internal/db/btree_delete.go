@@ -0,0 +1,194 @@
+package db
+
+// DeleteRow removes the row with the given rowid from the table b-tree
+// rooted at rootPage, freeing its overflow pages. It does nothing when
+// there is no such row.
+func (pager *Pager) DeleteRow(rootPage uint32, rowID int64) error {
+	path, leaf, err := pager.descendTable(rootPage, rowID)
+	if err != nil {
+		return err
+	}
+	for i, cell := range leaf.cells {
+		if tableCellKey(LeafTable, cell) == rowID {
+			if err := pager.freeOverflow(LeafTable, cell); err != nil {
+				return err
+			}
+			leaf.cells = append(leaf.cells[:i], leaf.cells[i+1:]...)
+			return pager.shrink(path, leaf)
+		}
+	}
+	return nil
+}
+
+// seekIndexEntry descends the index b-tree rooted at rootPage, ordered by
+// collations, to the cell holding key, on a leaf or an interior page, and
+// reports false when the index has no such entry.
+func (pager *Pager) seekIndexEntry(rootPage uint32, key IndexKey, collations []Collation) ([]pathStep, *btreeNode, int, bool, error) {
+	var path []pathStep
+	for pageNumber, depth := rootPage, 0; ; depth++ {
+		if depth > maxBTreeDepth {
+			return nil, nil, 0, false, corruptPage(rootPage, "b-tree deeper than %d levels", maxBTreeDepth)
+		}
+		node, err := pager.loadNode(pageNumber)
+		if err != nil {
+			return nil, nil, 0, false, err
+		}
+		if node.pageType != LeafIndex && node.pageType != InteriorIndex {
+			return nil, nil, 0, false, corruptPage(pageNumber, "page type %d in an index b-tree", node.pageType)
+		}
+
+		position := len(node.cells)
+		for i, cell := range node.cells {
+			payload, err := pager.fullPayload(node.pageType, cell)
+			if err != nil {
+				return nil, nil, 0, false, err
+			}
+			existing, err := DecodeIndexKey(payload)
+			if err != nil {
+				return nil, nil, 0, false, corruptCell(pageNumber, i, "%v", err)
+			}
+			c := CompareIndexKeys(key, existing, collations)
+			if c == 0 {
+				return path, node, i, true, nil
+			}
+			if c < 0 {
+				position = i
+				break
+			}
+		}
+		if node.pageType == LeafIndex {
+			return nil, nil, 0, false, nil
+		}
+		path = append(path, pathStep{node: node, child: position})
+		pageNumber = childPointer(node, position)
+	}
+}
+
+// DeleteIndexEntry removes key from the index b-tree rooted at rootPage,
+// whose columns are ordered by collations. It does nothing when the index
+// has no such entry.
+//
+// An entry on an interior page is a divider, so it is replaced by the
+// entry just before it, which is the last one on a leaf: that entry is
+// deleted from its leaf first, and the divider then overwritten with it.
+func (pager *Pager) DeleteIndexEntry(rootPage uint32, key IndexKey, collations []Collation) error {
+	path, node, position, found, err := pager.seekIndexEntry(rootPage, key, collations)
+	if err != nil || !found {
+		return err
+	}
+	if node.pageType == LeafIndex {
+		if err := pager.freeOverflow(LeafIndex, node.cells[position]); err != nil {
+			return err
+		}
+		node.cells = append(node.cells[:position], node.cells[position+1:]...)
+		return pager.shrink(path, node)
+	}
+
+	leaf, err := pager.loadNode(childPointer(node, position))
+	for depth := 0; err == nil && leaf.pageType == InteriorIndex; depth++ {
+		if depth > maxBTreeDepth {
+			return corruptPage(rootPage, "b-tree deeper than %d levels", maxBTreeDepth)
+		}
+		leaf, err = pager.loadNode(leaf.rightPointer)
+	}
+	if err != nil {
+		return err
+	}
+	if len(leaf.cells) == 0 {
+		return corruptPage(leaf.pageNumber, "empty index leaf")
+	}
+	payload, err := pager.fullPayload(LeafIndex, leaf.cells[len(leaf.cells)-1])
+	if err != nil {
+		return err
+	}
+	predecessor, err := DecodeIndexKey(payload)
+	if err != nil {
+		return corruptPage(leaf.pageNumber, "%v", err)
+	}
+	if err := pager.DeleteIndexEntry(rootPage, predecessor, collations); err != nil {
+		return err
+	}
+
+	// Removing the predecessor may have merged pages, moving the entry
+	path, node, position, found, err = pager.seekIndexEntry(rootPage, key, collations)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return corruptPage(rootPage, "index entry for rowid %d lost while deleting", key.RowID)
+	}
+	if err := pager.freeOverflow(node.pageType, node.cells[position]); err != nil {
+		return err
+	}
+	cell, err := pager.payloadCell(LeafIndex, appendVarint(nil, uint64(len(payload))), payload)
+	if err != nil {
+		return err
+	}
+	if node.pageType == InteriorIndex {
+		cell = interiorCell(childPointer(node, position), cell)
+	}
+	node.cells[position] = cell
+	return pager.balance(path, node)
+}
+
+// shrink stores a node that has lost a cell. A page left without cells is
+// merged with a sibling, together with the parent's divider between them,
+// and the parent shrinks in turn; a root left with only a child pointer
+// takes in the child's content, so the tree loses a level.
+func (pager *Pager) shrink(path []pathStep, node *btreeNode) error {
+	if len(node.cells) > 0 || !isInteriorType(node.pageType) && len(path) == 0 {
+		return pager.balance(path, node)
+	}
+
+	if len(path) == 0 {
+		child, err := pager.loadNode(node.rightPointer)
+		if err != nil {
+			return err
+		}
+		if err := pager.Free(child.pageNumber); err != nil {
+			return err
+		}
+		node.pageType, node.rightPointer, node.cells = child.pageType, child.rightPointer, child.cells
+		return pager.balance(nil, node)
+	}
+
+	step := path[len(path)-1]
+	parent := step.node
+	if len(parent.cells) == 0 {
+		return corruptPage(parent.pageNumber, "interior page without cells")
+	}
+	// Merge with the right sibling, or with the left one for the last child
+	left := min(step.child, len(parent.cells)-1)
+	leftNode, rightNode := node, node
+	var err error
+	if left == step.child {
+		rightNode, err = pager.loadNode(childPointer(parent, left+1))
+	} else {
+		leftNode, err = pager.loadNode(childPointer(parent, left))
+	}
+	if err != nil {
+		return err
+	}
+
+	divider := parent.cells[left][4:]
+	merged := &btreeNode{pageNumber: rightNode.pageNumber, pageType: node.pageType, rightPointer: rightNode.rightPointer}
+	merged.cells = append(merged.cells, leftNode.cells...)
+	switch node.pageType {
+	case LeafIndex:
+		merged.cells = append(merged.cells, divider)
+	case InteriorIndex, InteriorTable:
+		merged.cells = append(merged.cells, interiorCell(leftNode.rightPointer, divider))
+	}
+	merged.cells = append(merged.cells, rightNode.cells...)
+	if err := pager.Free(leftNode.pageNumber); err != nil {
+		return err
+	}
+
+	// The parent's pointer to the right sibling takes the divider's place
+	parent.cells = append(parent.cells[:left], parent.cells[left+1:]...)
+	parentPath := path[:len(path)-1]
+	if err := pager.balance(append(parentPath, pathStep{node: parent, child: left}), merged); err != nil {
+		return err
+	}
+	return pager.shrink(parentPath, parent)
+}
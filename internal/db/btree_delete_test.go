@@ -0,0 +1,95 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"testing"
+)
+
+func TestDeletesMergePagesSQLiteAccepts(t *testing.T) {
+	path, dbFile, header, tableRoot, indexRoot := emptyTablesDatabase(t)
+
+	pager := NewPager(dbFile, header)
+	for rowID := int64(1); rowID <= 2000; rowID++ {
+		body := bytes.Repeat([]byte{byte(rowID)}, int(rowID%7)*150)
+		insertItem(t, pager, tableRoot, indexRoot, rowID, body)
+	}
+	grown := header.PageCount
+
+	// Deleting in shuffled order empties pages throughout both trees, and
+	// removes index entries that are dividers on interior pages
+	kept := 0
+	for _, i := range rand.New(rand.NewSource(2)).Perm(2000) {
+		rowID := int64(i) + 1
+		if rowID%10 == 0 {
+			kept++
+			continue
+		}
+		if err := pager.DeleteRow(tableRoot, rowID); err != nil {
+			t.Fatalf("delete row %d: %v", rowID, err)
+		}
+		if err := pager.DeleteIndexEntry(indexRoot, IndexKey{Values: []Value{itemName(rowID)}, RowID: rowID}, nil); err != nil {
+			t.Fatalf("delete index entry %d: %v", rowID, err)
+		}
+	}
+	// Deleting what is not there does nothing
+	if err := pager.DeleteRow(tableRoot, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.DeleteIndexEntry(indexRoot, IndexKey{Values: []Value{itemName(5)}, RowID: 5}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := dbFile.CountRows(header, tableRoot); err != nil || count != int64(kept) {
+		t.Fatalf("CountRows = %d, %v; want %d", count, err, kept)
+	}
+	if header.PageCount != grown || header.FreelistCount < grown/2 {
+		t.Fatalf("page count %d (was %d), freelist %d", header.PageCount, grown, header.FreelistCount)
+	}
+	if err := dbFile.VerifyIndexOrder(header, indexRoot, nil); err != nil {
+		t.Fatal(err)
+	}
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; SELECT count(*), sum(id) FROM items INDEXED BY idx_items_name WHERE name > ''")
+		if want := fmt.Sprintf("ok\n%d|%d", kept, 10*kept*(kept+1)/2); got != want {
+			t.Fatalf("sqlite3 after deletes: %q, want %q", got, want)
+		}
+	}
+}
+
+func TestDeletingEveryRowEmptiesTheRoot(t *testing.T) {
+	path, dbFile, header, tableRoot, indexRoot := emptyTablesDatabase(t)
+
+	pager := NewPager(dbFile, header)
+	for rowID := int64(1); rowID <= 300; rowID++ {
+		insertItem(t, pager, tableRoot, indexRoot, rowID, nil)
+	}
+	for rowID := int64(300); rowID >= 1; rowID-- {
+		if err := pager.DeleteRow(tableRoot, rowID); err != nil {
+			t.Fatalf("delete row %d: %v", rowID, err)
+		}
+		if err := pager.DeleteIndexEntry(indexRoot, IndexKey{Values: []Value{itemName(rowID)}, RowID: rowID}, nil); err != nil {
+			t.Fatalf("delete index entry %d: %v", rowID, err)
+		}
+	}
+	if err := pager.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, root := range []uint32{tableRoot, indexRoot} {
+		page, err := dbFile.NewPage(header, root)
+		if err != nil || len(page.CellAddresses) != 0 || isInteriorType(page.PageType) {
+			t.Fatalf("root %d after deleting everything: type %d, %d cells, %v", root, page.PageType, len(page.CellAddresses), err)
+		}
+	}
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		if got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; SELECT count(*) FROM items"); got != "ok\n0" {
+			t.Fatalf("sqlite3 after deleting every row: %q", got)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+// generatedTable writes a database with one table of rows sequential rowids
+// and returns it opened along with the table's root page.
+func generatedTable(tb testing.TB, options testgen.Options, rows int) (*DatabaseFile, *DatabaseHeader, uint32) {
+	tb.Helper()
+
+	database := testgen.New(options)
+	table := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text, qty integer)")
+	for i := 1; i <= rows; i++ {
+		table.Insert(int64(i), nil, fmt.Sprintf("item-%d", i), int64(i%97))
+	}
+
+	dbFile, header, err := OpenDatabaseFile(database.WriteTemp(tb))
+	if err != nil {
+		tb.Fatalf("opening generated database: %v", err)
+	}
+	tb.Cleanup(func() { dbFile.Close() })
+
+	objects, err := dbFile.ReadSchema(header)
+	if err != nil {
+		tb.Fatalf("reading schema: %v", err)
+	}
+	rootPage, err := RootPageLookup("items", objects)
+	if err != nil {
+		tb.Fatalf("looking up root page: %v", err)
+	}
+
+	return dbFile, header, rootPage
+}
+
+func TestSeekRowIDAcrossLevels(t *testing.T) {
+	const rows = 5000
+	dbFile, header, rootPage := generatedTable(t, testgen.Options{PageSize: 512}, rows)
+
+	root, err := dbFile.NewPage(header, rootPage)
+	if err != nil {
+		t.Fatalf("reading root page: %v", err)
+	}
+	if root.PageType != InteriorTable {
+		t.Fatalf("expected a multi-level tree, root is type %d", root.PageType)
+	}
+
+	for _, rowID := range []int64{1, 2, 777, 2500, rows - 1, rows} {
+		row, err := dbFile.SeekRowID(header, rootPage, rowID)
+		if err != nil {
+			t.Fatalf("seeking rowid %d: %v", rowID, err)
+		}
+		if row == nil || row.RowID != rowID {
+			t.Fatalf("seeking rowid %d: got %+v", rowID, row)
+		}
+		if name := row.Columns[1].DecodedValue.(string); name != fmt.Sprintf("item-%d", rowID) {
+			t.Fatalf("rowid %d: unexpected name %q", rowID, name)
+		}
+	}
+
+	for _, rowID := range []int64{0, -1, rows + 1} {
+		row, err := dbFile.SeekRowID(header, rootPage, rowID)
+		if err != nil {
+			t.Fatalf("seeking missing rowid %d: %v", rowID, err)
+		}
+		if row != nil {
+			t.Fatalf("seeking missing rowid %d: got row %d", rowID, row.RowID)
+		}
+	}
+}
+
+func TestSeekRowIDFollowsOverflow(t *testing.T) {
+	database := testgen.New(testgen.Options{PageSize: 512})
+	table := database.CreateTable("notes", "CREATE TABLE notes (id integer primary key, body text)")
+	long := strings.Repeat("overflowing ", 400)
+	table.Insert(1, nil, long)
+	table.Insert(2, nil, "short")
+
+	dbFile, header, err := OpenDatabaseFile(database.WriteTemp(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbFile.Close()
+	objects, err := dbFile.ReadSchema(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPage, err := RootPageLookup("notes", objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := dbFile.SeekRowID(header, rootPage, 1)
+	if err != nil {
+		t.Fatalf("seeking overflowing row: %v", err)
+	}
+	if row == nil || row.Columns[1].DecodedValue != long {
+		t.Fatalf("overflowing row read back wrong: %+v", row)
+	}
+
+	cursor := dbFile.NewCursor(header, rootPage)
+	if err := cursor.First(); err != nil {
+		t.Fatal(err)
+	}
+	if row, err := cursor.Row(); err != nil || row.Columns[1].DecodedValue != long {
+		t.Fatalf("cursor read overflowing row %v, %v", row != nil, err)
+	}
+}
+
+func TestCorruptRecordOnDisk(t *testing.T) {
+	database := testgen.New(testgen.Options{PageSize: 512})
+	table := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text)")
+	for i := int64(1); i <= 3; i++ {
+		table.Insert(i, nil, fmt.Sprintf("item-%d", i))
+	}
+	path := database.WriteTemp(t)
+
+	dbFile, header, err := OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sqlite_schema is page 1, so the table's only page is page 2
+	page, err := dbFile.NewPage(header, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbFile.Close()
+
+	// Each cell is its record size, rowid, header size and the alias's
+	// NULL before name's serial type, which now claims 57 bytes of text
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(header.PageSize) + int64(page.CellAddresses[1]) + 4
+	if _, err := file.WriteAt([]byte{127}, offset); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	dbFile, header, err = OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbFile.Close()
+
+	var corruptionErr *CorruptionError
+	if _, err := dbFile.SeekRowID(header, 2, 2); !errors.As(err, &corruptionErr) || corruptionErr.Page != 2 || corruptionErr.Cell != 1 {
+		t.Fatalf("seeking the corrupt row: %v", err)
+	}
+	cursor := dbFile.NewCursor(header, 2)
+	err = cursor.First()
+	for ; err == nil && cursor.Valid(); err = cursor.Next() {
+		if _, err = cursor.Row(); err != nil {
+			break
+		}
+	}
+	if !errors.As(err, &corruptionErr) {
+		t.Fatalf("scanning past the corrupt row: %v", err)
+	}
+	if row, err := dbFile.SeekRowID(header, 2, 3); err != nil || row == nil {
+		t.Fatalf("seeking an intact row: %v", err)
+	}
+}
+
+func TestCountRowsSumsLeafCells(t *testing.T) {
+	for _, rows := range []int{0, 10, 5000} {
+		dbFile, header, rootPage := generatedTable(t, testgen.Options{PageSize: 512}, rows)
+
+		count, err := dbFile.CountRows(header, rootPage)
+		if err != nil {
+			t.Fatalf("%d rows: counting: %v", rows, err)
+		}
+		if count != int64(rows) {
+			t.Fatalf("unexpected count: got %d, want %d", count, rows)
+		}
+	}
+}
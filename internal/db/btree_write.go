@@ -0,0 +1,596 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// btreeNode is a b-tree page being modified: its cells as raw bytes, in key
+// order. storeNode lays them out afresh, so a stored page has no freeblocks
+// or fragmented bytes; a cell inserted into a leaf with room for it is
+// placed by placeCell instead, into the page's free space as it is.
+type btreeNode struct {
+	pageNumber   uint32
+	pageType     BTreePageType
+	rightPointer uint32
+	cells        [][]byte
+}
+
+// pathStep records the interior node a descent passed through and which of
+// its children it took: a cell index, or len(cells) for the right pointer.
+type pathStep struct {
+	node  *btreeNode
+	child int
+}
+
+func isInteriorType(pageType BTreePageType) bool {
+	return pageType == InteriorTable || pageType == InteriorIndex
+}
+
+// cellSize returns the number of bytes the cell at address occupies on the
+// page, including its overflow page pointer.
+func cellSize(page *Page, address int) (int, error) {
+	data := page.Data[:min(page.UsableSize, len(page.Data))]
+	offset := address
+	if page.PageType == InteriorTable || page.PageType == InteriorIndex {
+		offset += 4
+	}
+	if offset >= len(data) {
+		return 0, fmt.Errorf("cell at %d extends past usable page area", address)
+	}
+
+	first, n := varintAt(data[offset:])
+	if n == 0 {
+		return 0, fmt.Errorf("cell at %d: truncated varint", address)
+	}
+	offset += n
+	if page.PageType == InteriorTable {
+		return offset - address, nil
+	}
+
+	payloadSize := first
+	if page.PageType == LeafTable {
+		if _, n = varintAt(data[offset:]); n == 0 {
+			return 0, fmt.Errorf("cell at %d: truncated rowid", address)
+		}
+		offset += n
+	}
+	local := localPayloadSize(page.PageType, page.UsableSize, payloadSize)
+	offset += local
+	if uint64(local) < payloadSize {
+		offset += 4
+	}
+	if offset > len(data) {
+		return 0, fmt.Errorf("cell at %d extends past usable page area", address)
+	}
+	return offset - address, nil
+}
+
+func (pager *Pager) loadNode(pageNumber uint32) (*btreeNode, error) {
+	page, err := pager.file.NewPage(pager.header, pageNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &btreeNode{pageNumber: pageNumber, pageType: page.PageType, rightPointer: page.RightPointer}
+	for i, address := range page.CellAddresses {
+		size, err := cellSize(page, int(address))
+		if err != nil {
+			return nil, corruptCell(pageNumber, i, "%v", err)
+		}
+		node.cells = append(node.cells, append([]byte(nil), page.Data[address:int(address)+size]...))
+	}
+	return node, nil
+}
+
+// nodeHeaderOffset is where the b-tree page header starts: after the
+// database header on page 1.
+func nodeHeaderOffset(pageNumber uint32) int {
+	if pageNumber == 1 {
+		return databaseHeaderBytes
+	}
+	return 0
+}
+
+func (pager *Pager) fits(node *btreeNode) bool {
+	used := nodeHeaderOffset(node.pageNumber) + 8
+	if isInteriorType(node.pageType) {
+		used += 4
+	}
+	for _, cell := range node.cells {
+		used += 2 + len(cell)
+	}
+	return used <= pager.header.UsableSize()
+}
+
+// storeNode writes a node that fits on its page, packing its cells at the
+// end of the usable area. The database header on page 1 and any reserved
+// bytes at the end of the page are preserved.
+func (pager *Pager) storeNode(node *btreeNode) error {
+	current, err := pager.Read(node.pageNumber)
+	if err != nil {
+		return err
+	}
+	usable := pager.header.UsableSize()
+	data := make([]byte, pager.header.PageSize)
+	headerOffset := nodeHeaderOffset(node.pageNumber)
+	copy(data[:headerOffset], current)
+	copy(data[usable:], current[usable:])
+
+	header := data[headerOffset:]
+	header[0] = byte(node.pageType)
+	binary.BigEndian.PutUint16(header[3:5], uint16(len(node.cells)))
+	pointers := 8
+	if isInteriorType(node.pageType) {
+		binary.BigEndian.PutUint32(header[8:12], node.rightPointer)
+		pointers = 12
+	}
+
+	content := usable
+	for i, cell := range node.cells {
+		content -= len(cell)
+		copy(data[content:], cell)
+		binary.BigEndian.PutUint16(header[pointers+2*i:], uint16(content))
+	}
+	// A content area starting at 65536 is stored as 0
+	binary.BigEndian.PutUint16(header[5:7], uint16(content))
+	return pager.Write(node.pageNumber, data)
+}
+
+// placeCell adds cell to the leaf page at pageNumber as its position'th
+// cell, leaving the other cells where they are. Its space is allocated as
+// SQLite allocates it: from the first freeblock large enough, whose rest
+// stays free, as a smaller freeblock or as fragmented bytes when fewer than
+// four are left, or else from the gap between the cell pointers and the
+// cell content area. It reports false, writing nothing, when neither has
+// room, and the page must then be stored afresh by storeNode, which
+// defragments it.
+func (pager *Pager) placeCell(pageNumber uint32, position int, cell []byte) (bool, error) {
+	page, err := pager.file.NewPage(pager.header, pageNumber)
+	if err != nil {
+		return false, err
+	}
+	gap, top := page.CellPointersEnd, page.CellContentStart
+	if gap+2 > top {
+		return false, nil
+	}
+	data := append([]byte(nil), page.Data...)
+	header := data[page.ContentOffset:]
+
+	address := -1
+	// link is where the pointer to the freeblock being considered is kept
+	link := page.ContentOffset + 1
+	for _, freeblock := range page.Freeblocks {
+		offset, size := int(freeblock.Offset), int(freeblock.Size)
+		if size < len(cell) {
+			link = offset
+			continue
+		}
+		rest := size - len(cell)
+		if rest >= 4 {
+			// The cell takes the end of the freeblock, which shrinks
+			binary.BigEndian.PutUint16(data[offset+2:], uint16(rest))
+			address = offset + rest
+		} else if page.FragmentedBytes <= 57 {
+			// The freeblock is used up, and what is left is fragmented
+			copy(data[link:link+2], data[offset:offset+2])
+			header[7] += byte(rest)
+			address = offset
+		}
+		// Otherwise the page is fragmented enough that SQLite would rather
+		// take the gap, or defragment
+		break
+	}
+	if address < 0 {
+		if gap+2+len(cell) > top {
+			return false, nil
+		}
+		address = top - len(cell)
+		binary.BigEndian.PutUint16(header[5:7], uint16(address))
+	}
+	copy(data[address:], cell)
+
+	pointers := gap - 2*int(page.CellCount)
+	copy(data[pointers+2*position+2:gap+2], data[pointers+2*position:gap])
+	binary.BigEndian.PutUint16(data[pointers+2*position:], uint16(address))
+	binary.BigEndian.PutUint16(header[3:5], page.CellCount+1)
+	return true, pager.Write(pageNumber, data)
+}
+
+// payloadCell builds a cell from its varint prefix and payload, spilling the
+// part of the payload that does not fit locally onto new overflow pages.
+func (pager *Pager) payloadCell(pageType BTreePageType, prefix, payload []byte) ([]byte, error) {
+	usable := pager.header.UsableSize()
+	local := localPayloadSize(pageType, usable, uint64(len(payload)))
+	cell := append(prefix, payload[:local]...)
+	if local == len(payload) {
+		return cell, nil
+	}
+
+	rest := payload[local:]
+	pages := make([]uint32, (len(rest)+usable-5)/(usable-4))
+	for i := range pages {
+		pageNumber, err := pager.Allocate()
+		if err != nil {
+			return nil, err
+		}
+		pages[i] = pageNumber
+	}
+	for i, pageNumber := range pages {
+		data := make([]byte, pager.header.PageSize)
+		if i+1 < len(pages) {
+			binary.BigEndian.PutUint32(data[0:4], pages[i+1])
+		}
+		rest = rest[copy(data[4:usable], rest):]
+		if err := pager.Write(pageNumber, data); err != nil {
+			return nil, err
+		}
+	}
+	return binary.BigEndian.AppendUint32(cell, pages[0]), nil
+}
+
+// cellPayload splits a leaf or interior index cell, or a table leaf cell,
+// into its payload size, local payload, and first overflow page (0 when
+// the payload is entirely local).
+func (pager *Pager) cellPayload(pageType BTreePageType, cell []byte) (uint64, []byte, uint32) {
+	if pageType == InteriorIndex {
+		cell = cell[4:]
+	}
+	payloadSize, n := varintAt(cell)
+	cell = cell[n:]
+	if pageType == LeafTable {
+		_, n = varintAt(cell)
+		cell = cell[n:]
+	}
+	local := localPayloadSize(pageType, pager.header.UsableSize(), payloadSize)
+	if uint64(local) == payloadSize {
+		return payloadSize, cell[:local], 0
+	}
+	return payloadSize, cell[:local], binary.BigEndian.Uint32(cell[local : local+4])
+}
+
+// freeOverflow returns a cell's overflow pages, if any, to the freelist.
+func (pager *Pager) freeOverflow(pageType BTreePageType, cell []byte) error {
+	payloadSize, local, next := pager.cellPayload(pageType, cell)
+	remaining := int(payloadSize) - len(local)
+	for next != 0 && remaining > 0 {
+		data, err := pager.Read(next)
+		if err != nil {
+			return fmt.Errorf("overflow: %w", err)
+		}
+		following := binary.BigEndian.Uint32(data[0:4])
+		if err := pager.Free(next); err != nil {
+			return err
+		}
+		next = following
+		remaining -= pager.header.UsableSize() - 4
+	}
+	return nil
+}
+
+// fullPayload reassembles a cell's payload, reading its overflow chain.
+func (pager *Pager) fullPayload(pageType BTreePageType, cell []byte) ([]byte, error) {
+	payloadSize, local, next := pager.cellPayload(pageType, cell)
+	if next == 0 {
+		return local, nil
+	}
+	overflow, err := pager.file.ReadOverflow(pager.header, next, int(payloadSize)-len(local))
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), local...), overflow...), nil
+}
+
+// tableCellKey returns the rowid of a table leaf cell or the key of a table
+// interior cell.
+func tableCellKey(pageType BTreePageType, cell []byte) int64 {
+	if pageType == InteriorTable {
+		key, _ := varintAt(cell[4:])
+		return int64(key)
+	}
+	_, n := varintAt(cell)
+	rowID, _ := varintAt(cell[n:])
+	return int64(rowID)
+}
+
+func childPointer(node *btreeNode, index int) uint32 {
+	if index == len(node.cells) {
+		return node.rightPointer
+	}
+	return binary.BigEndian.Uint32(node.cells[index][0:4])
+}
+
+// interiorCell builds an interior cell pointing at child from a divider,
+// which is a table key varint or an index leaf cell.
+func interiorCell(child uint32, divider []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, child), divider...)
+}
+
+// descendTable follows rowID from the root to the leaf that holds, or
+// would hold, it.
+func (pager *Pager) descendTable(rootPage uint32, rowID int64) ([]pathStep, *btreeNode, error) {
+	var path []pathStep
+	for pageNumber, depth := rootPage, 0; ; depth++ {
+		if depth > maxBTreeDepth {
+			return nil, nil, corruptPage(rootPage, "b-tree deeper than %d levels", maxBTreeDepth)
+		}
+		node, err := pager.loadNode(pageNumber)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch node.pageType {
+		case LeafTable:
+			return path, node, nil
+		case InteriorTable:
+		default:
+			return nil, nil, corruptPage(pageNumber, "page type %d in a table b-tree", node.pageType)
+		}
+
+		child := len(node.cells)
+		for i, cell := range node.cells {
+			if rowID <= tableCellKey(InteriorTable, cell) {
+				child = i
+				break
+			}
+		}
+		path = append(path, pathStep{node: node, child: child})
+		pageNumber = childPointer(node, child)
+	}
+}
+
+// CreateBTree allocates the root page of a new, empty table or index
+// b-tree, whose page type is LeafTable or LeafIndex.
+func (pager *Pager) CreateBTree(pageType BTreePageType) (uint32, error) {
+	pageNumber, err := pager.Allocate()
+	if err != nil {
+		return 0, err
+	}
+	if err := pager.storeNode(&btreeNode{pageNumber: pageNumber, pageType: pageType}); err != nil {
+		return 0, err
+	}
+	return pageNumber, nil
+}
+
+// InsertRow stores a row in the table b-tree rooted at rootPage, replacing
+// the row with the same rowid if there is one. Pages that overflow are
+// split, up to the root, which keeps its page number.
+func (pager *Pager) InsertRow(rootPage uint32, rowID int64, record []byte) error {
+	if pager.header.legacyFormat() {
+		var err error
+		if record, err = legacyRecord(record); err != nil {
+			return err
+		}
+	}
+	path, leaf, err := pager.descendTable(rootPage, rowID)
+	if err != nil {
+		return err
+	}
+
+	prefix := appendVarint(appendVarint(nil, uint64(len(record))), uint64(rowID))
+	cell, err := pager.payloadCell(LeafTable, prefix, record)
+	if err != nil {
+		return err
+	}
+
+	position := len(leaf.cells)
+	for i, existing := range leaf.cells {
+		if key := tableCellKey(LeafTable, existing); key >= rowID {
+			position = i
+			if key == rowID {
+				if err := pager.freeOverflow(LeafTable, existing); err != nil {
+					return err
+				}
+				leaf.cells[i] = cell
+				return pager.balance(path, leaf)
+			}
+			break
+		}
+	}
+	if placed, err := pager.placeCell(leaf.pageNumber, position, cell); err != nil || placed {
+		return err
+	}
+	leaf.cells = append(leaf.cells[:position], append([][]byte{cell}, leaf.cells[position:]...)...)
+	return pager.balance(path, leaf)
+}
+
+// MaxRowID returns the largest rowid in the table b-tree rooted at
+// rootPage, or 0 when the table is empty.
+func (pager *Pager) MaxRowID(rootPage uint32) (int64, error) {
+	for pageNumber, depth := rootPage, 0; depth <= maxBTreeDepth; depth++ {
+		node, err := pager.loadNode(pageNumber)
+		if err != nil {
+			return 0, err
+		}
+		if node.pageType == InteriorTable {
+			pageNumber = node.rightPointer
+			continue
+		}
+		if len(node.cells) == 0 {
+			return 0, nil
+		}
+		return tableCellKey(LeafTable, node.cells[len(node.cells)-1]), nil
+	}
+	return 0, corruptPage(rootPage, "b-tree deeper than %d levels", maxBTreeDepth)
+}
+
+// InsertIndexEntry adds key to the index b-tree rooted at rootPage, whose
+// columns are ordered by collations.
+func (pager *Pager) InsertIndexEntry(rootPage uint32, key IndexKey, collations []Collation) error {
+	record := EncodeIndexKey(key)
+	if pager.header.legacyFormat() {
+		record = encodeRecord(append(append([]Value(nil), key.Values...), key.RowID), false)
+	}
+	var path []pathStep
+	for pageNumber, depth := rootPage, 0; ; depth++ {
+		if depth > maxBTreeDepth {
+			return corruptPage(rootPage, "b-tree deeper than %d levels", maxBTreeDepth)
+		}
+		node, err := pager.loadNode(pageNumber)
+		if err != nil {
+			return err
+		}
+		if node.pageType != LeafIndex && node.pageType != InteriorIndex {
+			return corruptPage(pageNumber, "page type %d in an index b-tree", node.pageType)
+		}
+
+		// Entries are unique, so the new key goes before the first larger one
+		position := len(node.cells)
+		for i, cell := range node.cells {
+			payload, err := pager.fullPayload(node.pageType, cell)
+			if err != nil {
+				return err
+			}
+			existing, err := DecodeIndexKey(payload)
+			if err != nil {
+				return corruptCell(pageNumber, i, "%v", err)
+			}
+			c := CompareIndexKeys(key, existing, collations)
+			if c == 0 {
+				return fmt.Errorf("index rooted at %d already has an entry for rowid %d", rootPage, key.RowID)
+			}
+			if c < 0 {
+				position = i
+				break
+			}
+		}
+
+		if node.pageType == LeafIndex {
+			cell, err := pager.payloadCell(LeafIndex, appendVarint(nil, uint64(len(record))), record)
+			if err != nil {
+				return err
+			}
+			if placed, err := pager.placeCell(node.pageNumber, position, cell); err != nil || placed {
+				return err
+			}
+			node.cells = append(node.cells[:position], append([][]byte{cell}, node.cells[position:]...)...)
+			return pager.balance(path, node)
+		}
+		path = append(path, pathStep{node: node, child: position})
+		pageNumber = childPointer(node, position)
+	}
+}
+
+// balance stores a modified node, splitting it if it no longer fits. A
+// root that overflows moves its cells to a new child and becomes an
+// interior page above it, so the tree grows a level.
+func (pager *Pager) balance(path []pathStep, node *btreeNode) error {
+	if pager.fits(node) {
+		return pager.storeNode(node)
+	}
+
+	if len(path) == 0 {
+		childNumber, err := pager.Allocate()
+		if err != nil {
+			return err
+		}
+		child := &btreeNode{pageNumber: childNumber, pageType: node.pageType, rightPointer: node.rightPointer, cells: node.cells}
+		node.cells, node.rightPointer = nil, childNumber
+		if node.pageType == LeafTable {
+			node.pageType = InteriorTable
+		} else if node.pageType == LeafIndex {
+			node.pageType = InteriorIndex
+		}
+		return pager.split([]pathStep{{node: node, child: 0}}, child)
+	}
+	return pager.split(path, node)
+}
+
+// nodeGroup is the content of one page produced by a split.
+type nodeGroup struct {
+	cells        [][]byte
+	rightPointer uint32
+}
+
+// split divides an overflowing node's cells among pages, and inserts a
+// divider into the parent for each page but the last, which keeps the
+// node's page number so the parent's existing pointer to it stays right.
+func (pager *Pager) split(path []pathStep, node *btreeNode) error {
+	groups, dividers := pager.partition(node)
+
+	var parentCells [][]byte
+	for i, group := range groups[:len(groups)-1] {
+		pageNumber, err := pager.Allocate()
+		if err != nil {
+			return err
+		}
+		sibling := &btreeNode{pageNumber: pageNumber, pageType: node.pageType, rightPointer: group.rightPointer, cells: group.cells}
+		if err := pager.storeNode(sibling); err != nil {
+			return err
+		}
+		parentCells = append(parentCells, interiorCell(pageNumber, dividers[i]))
+	}
+	last := groups[len(groups)-1]
+	node.cells, node.rightPointer = last.cells, last.rightPointer
+	if err := pager.storeNode(node); err != nil {
+		return err
+	}
+
+	step := path[len(path)-1]
+	parent := step.node
+	parent.cells = append(parent.cells[:step.child], append(parentCells, parent.cells[step.child:]...)...)
+	return pager.balance(path[:len(path)-1], parent)
+}
+
+// partition splits a node's cells into at least two pages' worth.
+//
+// Table leaves are packed greedily from the left, with the last rowid of
+// each page as its divider; rows appended in rowid order then fill pages
+// completely. Other pages split in two by size around a middle cell that
+// moves up as the divider: its key for table interior pages, and the whole
+// entry for index pages, whose interior cells are entries themselves.
+func (pager *Pager) partition(node *btreeNode) ([]nodeGroup, [][]byte) {
+	capacity := pager.header.UsableSize() - 8
+	if node.pageType == LeafTable {
+		var groups []nodeGroup
+		var dividers [][]byte
+		current, used := nodeGroup{}, 0
+		for _, cell := range node.cells {
+			if used+2+len(cell) > capacity && len(current.cells) > 0 {
+				groups = append(groups, current)
+				current, used = nodeGroup{}, 0
+			}
+			current.cells = append(current.cells, cell)
+			used += 2 + len(cell)
+		}
+		groups = append(groups, current)
+		// A root's cells that fit one child page are still split, since an
+		// interior page must have a cell
+		if len(groups) == 1 {
+			half := len(node.cells) / 2
+			groups = []nodeGroup{{cells: node.cells[:half]}, {cells: node.cells[half:]}}
+		}
+		for _, group := range groups[:len(groups)-1] {
+			key := tableCellKey(LeafTable, group.cells[len(group.cells)-1])
+			dividers = append(dividers, appendVarint(nil, uint64(key)))
+		}
+		return groups, dividers
+	}
+
+	total := 0
+	for _, cell := range node.cells {
+		total += 2 + len(cell)
+	}
+	middle, used := 1, 0
+	for i, cell := range node.cells[:len(node.cells)-1] {
+		if i > 0 && used+2+len(cell) > total/2 {
+			middle = i
+			break
+		}
+		used += 2 + len(cell)
+		middle = i + 1
+	}
+	middle = max(1, min(middle, len(node.cells)-2))
+
+	divider := node.cells[middle]
+	left := nodeGroup{cells: node.cells[:middle]}
+	right := nodeGroup{cells: node.cells[middle+1:], rightPointer: node.rightPointer}
+	if isInteriorType(node.pageType) {
+		left.rightPointer = binary.BigEndian.Uint32(divider[0:4])
+		divider = divider[4:]
+	}
+	if node.pageType == InteriorTable {
+		// Only the key moves up; the left page keeps the cell's child
+		divider = appendVarint(nil, uint64(tableCellKey(InteriorTable, node.cells[middle])))
+	}
+	return []nodeGroup{left, right}, [][]byte{divider}
+}
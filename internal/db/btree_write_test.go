@@ -0,0 +1,376 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+// emptyTablesDatabase generates a file with an empty table and an empty
+// index on its name column, returning their root pages.
+func emptyTablesDatabase(t *testing.T) (string, *DatabaseFile, *DatabaseHeader, uint32, uint32) {
+	t.Helper()
+
+	database := testgen.New(testgen.Options{PageSize: 512})
+	items := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text, body blob)")
+	database.CreateIndex("idx_items_name", items, "CREATE INDEX idx_items_name ON items (name)", 1)
+	path := database.WriteTemp(t)
+
+	dbFile, header, err := OpenWritableDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbFile.Close() })
+
+	objects, err := dbFile.ReadSchema(header)
+	if err != nil || len(objects) != 2 {
+		t.Fatalf("schema objects %+v, %v", objects, err)
+	}
+	return path, dbFile, header, objects[0].RootPage, objects[1].RootPage
+}
+
+func itemName(rowID int64) string {
+	return fmt.Sprintf("item %05d", (rowID*7919)%3001)
+}
+
+func insertItem(t *testing.T, pager *Pager, tableRoot, indexRoot uint32, rowID int64, body []byte) {
+	t.Helper()
+
+	if err := pager.InsertRow(tableRoot, rowID, EncodeRecord([]Value{nil, itemName(rowID), body})); err != nil {
+		t.Fatalf("insert row %d: %v", rowID, err)
+	}
+	if err := pager.InsertIndexEntry(indexRoot, IndexKey{Values: []Value{itemName(rowID)}, RowID: rowID}, nil); err != nil {
+		t.Fatalf("insert index entry %d: %v", rowID, err)
+	}
+}
+
+func TestInsertsSplitPagesSQLiteAccepts(t *testing.T) {
+	path, dbFile, header, tableRoot, indexRoot := emptyTablesDatabase(t)
+
+	// Shuffled rowids split pages in the middle as well as at the end, and
+	// large bodies spill onto overflow pages
+	rowIDs := rand.New(rand.NewSource(1)).Perm(1500)
+	pager := NewPager(dbFile, header)
+	for _, i := range rowIDs {
+		rowID := int64(i) + 1
+		body := bytes.Repeat([]byte{byte(rowID)}, int(rowID%7)*150)
+		insertItem(t, pager, tableRoot, indexRoot, rowID, body)
+	}
+	// Rows appended past the end fill pages in rowid order
+	for rowID := int64(1501); rowID <= 2000; rowID++ {
+		insertItem(t, pager, tableRoot, indexRoot, rowID, nil)
+	}
+	if err := pager.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := dbFile.CountRows(header, tableRoot); err != nil || count != 2000 {
+		t.Fatalf("CountRows = %d, %v; want 2000", count, err)
+	}
+	if maxRowID, err := pager.MaxRowID(tableRoot); err != nil || maxRowID != 2000 {
+		t.Fatalf("MaxRowID = %d, %v; want 2000", maxRowID, err)
+	}
+	row, err := dbFile.SeekRowID(header, tableRoot, 1001)
+	if err != nil || row == nil {
+		t.Fatalf("SeekRowID(1001) = %v, %v", row, err)
+	}
+	if got := row.Columns[1].DecodedValue; got != itemName(1001) {
+		t.Fatalf("row 1001 name = %v, want %q", got, itemName(1001))
+	}
+	if err := dbFile.VerifyIndexOrder(header, indexRoot, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; SELECT count(*), sum(length(body)) FROM items")
+		if want := "ok\n2000|" + fmt.Sprint(bodyTotal(rowIDs)); got != want {
+			t.Fatalf("sqlite3 after inserts: %q, want %q", got, want)
+		}
+	}
+}
+
+func bodyTotal(rowIDs []int) int {
+	total := 0
+	for _, i := range rowIDs {
+		total += (i + 1) % 7 * 150
+	}
+	return total
+}
+
+func TestInsertRowReplacesExistingRowid(t *testing.T) {
+	path, dbFile, header, tableRoot, indexRoot := emptyTablesDatabase(t)
+
+	pager := NewPager(dbFile, header)
+	large := bytes.Repeat([]byte("x"), 2000)
+	for rowID := int64(1); rowID <= 3; rowID++ {
+		if err := pager.InsertRow(tableRoot, rowID, EncodeRecord([]Value{nil, "old", large})); err != nil {
+			t.Fatal(err)
+		}
+		name := "old"
+		if rowID == 2 {
+			name = "new"
+		}
+		if err := pager.InsertIndexEntry(indexRoot, IndexKey{Values: []Value{name}, RowID: rowID}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	overflowing := header.PageCount
+	if err := pager.InsertRow(tableRoot, 2, EncodeRecord([]Value{nil, "new", nil})); err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The replaced row's overflow chain goes back on the freelist
+	if header.PageCount != overflowing || header.FreelistCount == 0 {
+		t.Fatalf("page count %d (was %d), freelist %d", header.PageCount, overflowing, header.FreelistCount)
+	}
+	row, err := dbFile.SeekRowID(header, tableRoot, 2)
+	if err != nil || row == nil || row.Columns[1].DecodedValue != "new" {
+		t.Fatalf("SeekRowID(2) = %+v, %v", row, err)
+	}
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		if got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; SELECT name FROM items WHERE id = 2"); got != "ok\nnew" {
+			t.Fatalf("sqlite3 after replacing a row: %q", got)
+		}
+	}
+}
+
+func TestInsertRowFillsFreeblocks(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found in PATH")
+	}
+
+	// Deleting every third row leaves a freeblock of 13 bytes in its place
+	path := filepath.Join(t.TempDir(), "fragmented.db")
+	sqlite3Output(t, sqlite3, path, `PRAGMA secure_delete = off;
+		CREATE TABLE t (id integer primary key, v text);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 30)
+		INSERT INTO t SELECT i, printf('%08d', i) FROM n;
+		DELETE FROM t WHERE id % 3 = 0;`)
+
+	dbFile, header, err := OpenWritableDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbFile.Close()
+	objects, err := dbFile.ReadSchema(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPage := objects[0].RootPage
+	before, err := dbFile.NewPage(header, rootPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before.Freeblocks) == 0 {
+		t.Fatalf("fixture leaf has no freeblocks")
+	}
+
+	// A row of the same size takes the first freeblock whole, and a shorter
+	// one the end of the next, which keeps the 4 bytes left over
+	pager := NewPager(dbFile, header)
+	for _, row := range []struct {
+		rowID int64
+		value string
+	}{{3, "new-0003"}, {6, "new6"}} {
+		if err := pager.InsertRow(rootPage, row.rowID, EncodeRecord([]Value{nil, row.value})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after, err := dbFile.NewPage(header, rootPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.CellContentStart != before.CellContentStart {
+		t.Errorf("cell content area moved from %d to %d", before.CellContentStart, after.CellContentStart)
+	}
+	if len(after.Freeblocks) != len(before.Freeblocks)-1 || after.FreeBytes() != before.FreeBytes()-2*2-13-9 {
+		t.Errorf("freeblocks %v (%d bytes free), were %v (%d bytes free)", after.Freeblocks, after.FreeBytes(), before.Freeblocks, before.FreeBytes())
+	}
+	// The surviving cells stay where they were
+	for i, address := range before.CellAddresses[:2] {
+		if after.CellAddresses[i] != address {
+			t.Errorf("cell %d moved from %d to %d", i, address, after.CellAddresses[i])
+		}
+	}
+	if err := pager.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; SELECT v FROM t WHERE id IN (2, 3, 4, 6, 7) ORDER BY id"); got != "ok\n00000002\nnew-0003\n00000004\nnew6\n00000007" {
+		t.Fatalf("sqlite3 after filling freeblocks: %q", got)
+	}
+}
+
+// TestPagesPastFourGiB writes a table whose pages all lie beyond the first
+// 4 GiB of a sparse file and reads it back, so no page offset is computed in
+// 32 bits. It is skipped in short mode, since a file system without sparse
+// files would write the whole gap.
+func TestPagesPastFourGiB(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a 4 GiB sparse file")
+	}
+
+	const pageSize = 4096
+	path := filepath.Join(t.TempDir(), "large.db")
+	if err := CreateDatabaseFile(path, pageSize); err != nil {
+		t.Fatal(err)
+	}
+	dbFile, header, err := OpenWritableDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages up to the gap are left unwritten, holes in the file
+	gap := uint32(1<<32/pageSize + 8)
+	body := bytes.Repeat([]byte("large "), 3000)
+	pager := NewPager(dbFile, header)
+	pager.Header().PageCount = gap
+	root, err := pager.CreateBTree(LeafTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rowID := int64(1); rowID <= 50; rowID++ {
+		if err := pager.InsertRow(root, rowID, EncodeRecord([]Value{fmt.Sprintf("row-%d", rowID), body})); err != nil {
+			t.Fatalf("insert row %d: %v", rowID, err)
+		}
+	}
+	object := TableMetadata{RowID: 1, Type: "table", Name: "items", TableName: "items", RootPage: root, SQL: "CREATE TABLE items (name text, body blob)"}
+	if err := pager.InsertRow(1, object.RowID, EncodeSchemaRecord(object)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	dbFile.Close()
+
+	dbFile, header, err = OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbFile.Close()
+	if header.PageCount <= gap {
+		t.Fatalf("page count %d after writing past page %d", header.PageCount, gap)
+	}
+	objects, err := dbFile.ReadSchema(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rootPage, err := RootPageLookup("items", objects); err != nil || rootPage != root {
+		t.Fatalf("root page %d, %v; want %d", rootPage, err, root)
+	}
+
+	seen := int64(0)
+	cursor := dbFile.NewCursor(header, root)
+	for err := cursor.First(); cursor.Valid(); err = cursor.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen++
+		if rowID, err := cursor.RowID(); err != nil || rowID != seen {
+			t.Fatalf("rowid %d, %v; want %d", rowID, err, seen)
+		}
+	}
+	if seen != 50 {
+		t.Fatalf("scanned %d rows, want 50", seen)
+	}
+
+	blob, err := dbFile.OpenBlob(header, root, 50, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(blob)
+	if err != nil || !bytes.Equal(got, body) {
+		t.Fatalf("overflowing blob past 4 GiB: %d bytes, %v; want %d", len(got), err, len(body))
+	}
+}
+
+// TestSixtyFourKiBPages writes and reads back a database of the largest page
+// size, whose header field holds 1 and whose empty pages' content areas
+// start at offset 0.
+func TestSixtyFourKiBPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wide.db")
+	if err := CreateDatabaseFile(path, 65536); err != nil {
+		t.Fatal(err)
+	}
+	dbFile, header, err := OpenWritableDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.PageSize != 65536 {
+		t.Fatalf("page size %d, want 65536", header.PageSize)
+	}
+
+	// Enough rows to split the root leaf several times
+	body := bytes.Repeat([]byte("wide "), 2000)
+	pager := NewPager(dbFile, header)
+	root, err := pager.CreateBTree(LeafTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rowID := int64(1); rowID <= 200; rowID++ {
+		if err := pager.InsertRow(root, rowID, EncodeRecord([]Value{fmt.Sprintf("row-%d", rowID), body})); err != nil {
+			t.Fatalf("insert row %d: %v", rowID, err)
+		}
+	}
+	object := TableMetadata{RowID: 1, Type: "table", Name: "items", TableName: "items", RootPage: root, SQL: "CREATE TABLE items (name text, body blob)"}
+	if err := pager.InsertRow(1, object.RowID, EncodeSchemaRecord(object)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	dbFile.Close()
+
+	dbFile, header, err = OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbFile.Close()
+	if header.PageSize != 65536 || header.PageCount < 3 {
+		t.Fatalf("page size %d, %d pages; want 65536 and a split tree", header.PageSize, header.PageCount)
+	}
+	if count, err := dbFile.CountRows(header, root); err != nil || count != 200 {
+		t.Fatalf("CountRows = %d, %v; want 200", count, err)
+	}
+	row, err := dbFile.SeekRowID(header, root, 150)
+	if err != nil || row == nil || row.Columns[0].DecodedValue != "row-150" {
+		t.Fatalf("SeekRowID(150) = %v, %v", row, err)
+	}
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; PRAGMA page_size; SELECT count(*), max(name) FROM items")
+		if want := "ok\n65536\n200|row-99"; got != want {
+			t.Fatalf("sqlite3 on 64 KiB pages: %q, want %q", got, want)
+		}
+	}
+}
+
+// TestVetsFor32BitTargets vets the module for 386, where int is 32 bits,
+// so that a constant or conversion overflowing int fails here rather than
+// only in a 32-bit build.
+func TestVetsFor32BitTargets(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not in PATH")
+	}
+	if testing.Short() {
+		t.Skip("cross-compiling is slow")
+	}
+	command := exec.Command(goTool, "vet", "./...")
+	command.Dir = filepath.Join("..", "..")
+	command.Env = append(os.Environ(), "GOARCH=386")
+	if output, err := command.CombinedOutput(); err != nil {
+		t.Errorf("GOARCH=386 go vet: %v\n%s", err, output)
+	}
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Codec decodes the pages of an encrypted database as they are read from
+// the file, as the codecs of SQLite's SEE and of SQLCipher do. The file is
+// read-only through a codec: nothing encodes pages again to write them.
+type Codec interface {
+	// PageSize is the size of each page in the file, which an encrypted
+	// header cannot be read for
+	PageSize() int
+	// Decode decrypts page pageNumber, read as the file stores it, in
+	// place. Page 1 comes first of all, and decodes to a page starting
+	// with the database header.
+	Decode(pageNumber uint32, page []byte) error
+}
+
+// ErrEncrypted is returned for a write to a database read through a
+// Codec.
+var ErrEncrypted = errors.New("cannot write an encrypted database")
+
+// codecStorage is the storage of a database read through a codec: reads
+// decode every page they touch, and writes fail. Nothing decoded is kept,
+// so reads see what other processes commit.
+type codecStorage struct {
+	*os.File
+	codec    Codec
+	pageSize int64
+}
+
+func (s *codecStorage) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	for read < len(p) {
+		position := off + int64(read)
+		pageNumber := uint32(position/s.pageSize) + 1
+		page, err := s.page(pageNumber)
+		if err != nil {
+			return read, err
+		}
+		read += copy(p[read:], page[position%s.pageSize:])
+	}
+	return read, nil
+}
+
+// page returns the decoded contents of a page.
+func (s *codecStorage) page(pageNumber uint32) ([]byte, error) {
+	page := make([]byte, s.pageSize)
+	if n, err := s.File.ReadAt(page, int64(pageNumber-1)*s.pageSize); err != nil {
+		if err == io.EOF && n == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("page %d: read bytes: %w", pageNumber, err)
+	}
+	if err := s.codec.Decode(pageNumber, page); err != nil {
+		return nil, fmt.Errorf("page %d: %w", pageNumber, err)
+	}
+	return page, nil
+}
+
+func (s *codecStorage) WriteAt(p []byte, off int64) (int, error) {
+	return 0, ErrEncrypted
+}
+
+func (s *codecStorage) Truncate(size int64) error {
+	return ErrEncrypted
+}
+
+// OpenEncryptedDatabaseFile opens the encrypted database at path for
+// reading, decoding its pages with codec. The write-ahead log of a database
+// in WAL mode is not read, so checkpoint it before opening it here, and a
+// hot journal is not replayed.
+func OpenEncryptedDatabaseFile(path string, codec Codec) (*DatabaseFile, *DatabaseHeader, error) {
+	pageSize := codec.PageSize()
+	if pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return nil, nil, fmt.Errorf("invalid page size %d", pageSize)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open database: %w", err)
+	}
+
+	dbFile := &DatabaseFile{storage: &codecStorage{File: file, codec: codec, pageSize: int64(pageSize)}}
+	header, err := dbFile.NewDatabaseHeader()
+	if err != nil {
+		dbFile.Close()
+		return nil, nil, fmt.Errorf("read database header: %w", err)
+	}
+	if int(header.PageSize) != pageSize {
+		dbFile.Close()
+		return nil, nil, fmt.Errorf("database page size is %d, not %d", header.PageSize, pageSize)
+	}
+	return dbFile, header, nil
+}
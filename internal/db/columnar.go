@@ -0,0 +1,353 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+// ValueType is the storage class a ColumnVector holds its values as. The
+// types up to BlobValues are ordered so that each holds the values of
+// those before it, converted as SQLite converts them.
+type ValueType uint8
+
+const (
+	// NullValues is the type of a column of NULLs alone
+	NullValues ValueType = iota
+	IntegerValues
+	RealValues
+	TextValues
+	BlobValues
+	// MixedValues is the type of a column ReadColumns decoded whose values
+	// are of several storage classes, which it holds boxed and unconverted
+	MixedValues
+)
+
+func (valueType ValueType) String() string {
+	switch valueType {
+	case NullValues:
+		return "NULL"
+	case IntegerValues:
+		return "INTEGER"
+	case RealValues:
+		return "REAL"
+	case TextValues:
+		return "TEXT"
+	case BlobValues:
+		return "BLOB"
+	case MixedValues:
+		return "MIXED"
+	}
+	return "ValueType(" + strconv.Itoa(int(valueType)) + ")"
+}
+
+// ColumnVector holds one column of a batch of rows unboxed, in the slice
+// of its type, for code that works through a column at a time. A NULL row
+// holds the zero value there and is cleared in Valid.
+type ColumnVector struct {
+	Type ValueType
+	Len  int
+	// Valid has bit i set, least significant first, when row i is not
+	// NULL, as Arrow lays out validity bitmaps
+	Valid    []byte
+	Integers []int64
+	Reals    []float64
+	Texts    []string
+	Blobs    [][]byte
+	Values   []any
+}
+
+// Null reports whether row i is NULL.
+func (vector *ColumnVector) Null(i int) bool {
+	return vector.Valid[i/8]&(1<<(i%8)) == 0
+}
+
+// NullCount counts the NULL rows.
+func (vector *ColumnVector) NullCount() int {
+	valid := 0
+	for _, b := range vector.Valid {
+		valid += bits.OnesCount8(b)
+	}
+	return vector.Len - valid
+}
+
+// Value returns row i's value boxed, as a Row's columns hold it.
+func (vector *ColumnVector) Value(i int) any {
+	if vector.Null(i) {
+		return nil
+	}
+	switch vector.Type {
+	case IntegerValues:
+		return vector.Integers[i]
+	case RealValues:
+		return vector.Reals[i]
+	case TextValues:
+		return vector.Texts[i]
+	case BlobValues:
+		return vector.Blobs[i]
+	case MixedValues:
+		return vector.Values[i]
+	}
+	return nil
+}
+
+// Batch is a run of rows held column by column.
+type Batch struct {
+	Len     int
+	Columns []*ColumnVector
+	// RowIDs are the rows' rowids, when they were read from a table
+	RowIDs []int64
+}
+
+// ValueTypes returns the type of each of width columns that holds every
+// value rows have in it: INTEGER for integers alone, REAL once there is a
+// real, TEXT once there is text and BLOB once there is a blob.
+func ValueTypes(width int, rows [][]any) []ValueType {
+	types := make([]ValueType, width)
+	for _, row := range rows {
+		for i, value := range row {
+			valueType := NullValues
+			switch value.(type) {
+			case int64:
+				valueType = IntegerValues
+			case float64:
+				valueType = RealValues
+			case string:
+				valueType = TextValues
+			case []byte:
+				valueType = BlobValues
+			}
+			types[i] = max(types[i], valueType)
+		}
+	}
+	return types
+}
+
+// NewBatch transposes rows into columns of the given types, which must
+// hold their values, as ValueTypes picks them. A value of an earlier type
+// is converted: an integer to a real, a number to the text SQLite renders
+// it as, and text to its bytes.
+func NewBatch(types []ValueType, rows [][]any) *Batch {
+	batch := &Batch{Len: len(rows), Columns: make([]*ColumnVector, len(types))}
+	for column, valueType := range types {
+		vector := &ColumnVector{Type: valueType, Len: len(rows), Valid: make([]byte, (len(rows)+7)/8)}
+		switch valueType {
+		case IntegerValues:
+			vector.Integers = make([]int64, len(rows))
+		case RealValues:
+			vector.Reals = make([]float64, len(rows))
+		case TextValues:
+			vector.Texts = make([]string, len(rows))
+		case BlobValues:
+			vector.Blobs = make([][]byte, len(rows))
+		}
+		for i, row := range rows {
+			value := row[column]
+			if value == nil {
+				continue
+			}
+			vector.Valid[i/8] |= 1 << (i % 8)
+			switch valueType {
+			case IntegerValues:
+				vector.Integers[i] = value.(int64)
+			case RealValues:
+				if integer, ok := value.(int64); ok {
+					vector.Reals[i] = float64(integer)
+				} else {
+					vector.Reals[i] = value.(float64)
+				}
+			case TextValues:
+				vector.Texts[i] = valueText(value)
+			case BlobValues:
+				if blob, ok := value.([]byte); ok {
+					vector.Blobs[i] = blob
+				} else {
+					vector.Blobs[i] = []byte(valueText(value))
+				}
+			}
+		}
+		batch.Columns[column] = vector
+	}
+	return batch
+}
+
+// valueText renders a value as the text SQLite converts it to.
+func valueText(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		return FormatReal(value, 15)
+	}
+	return ""
+}
+
+// serialTypeClass is the storage class of the values of a serial type.
+func serialTypeClass(serialType uint64) ValueType {
+	switch {
+	case serialType == 0:
+		return NullValues
+	case serialType == 7:
+		return RealValues
+	case serialType < 12:
+		return IntegerValues
+	case serialType%2 == 0:
+		return BlobValues
+	}
+	return TextValues
+}
+
+// ReadColumns decodes the rows of a table leaf page, in rowid order, into a
+// batch of the record columns at positions, for scans that work through a
+// column at a time. Where the values a column has on the page are of one
+// storage class they are decoded straight into its slice, unboxed, and
+// where they are of several they are kept boxed as MixedValues, so nothing
+// is converted. A record shorter than a position, written before the
+// column was added, has NULL there. Records continuing on overflow pages
+// are read through them.
+func (databaseFile *DatabaseFile) ReadColumns(databaseHeader *DatabaseHeader, page *Page, positions []int) (*Batch, error) {
+	if page.PageType != LeafTable {
+		return nil, fmt.Errorf("page %d is not a table leaf", page.PageNumber)
+	}
+	order, err := rowIDOrder(page)
+	if err != nil {
+		return nil, err
+	}
+	rows, width := int(page.CellCount), len(positions)
+	batch := &Batch{Len: rows, Columns: make([]*ColumnVector, width), RowIDs: make([]int64, rows)}
+	last := -1
+	for _, position := range positions {
+		last = max(last, position)
+	}
+
+	// The first pass reads the serial types of the columns wanted, and where
+	// their values are in each record, which settles each column's type
+	records := make([][]byte, rows)
+	serialTypes := make([]uint64, rows*width)
+	starts := make([]int, rows*width)
+	classes := make([]ValueType, width)
+	mixed := make([]bool, width)
+	for row := range rows {
+		cell := row
+		if order != nil {
+			cell = order[row]
+		}
+		rowID, record, err := databaseFile.tableRecord(databaseHeader, page, cell)
+		if err != nil {
+			return nil, err
+		}
+		batch.RowIDs[row], records[row] = rowID, record
+
+		headerSize, headerBytes := varintAt(record)
+		if headerBytes == 0 || headerSize < uint64(headerBytes) || headerSize > uint64(len(record)) {
+			return nil, corruptCell(page.PageNumber, cell, "invalid record header size %d", headerSize)
+		}
+		header, offset := record[headerBytes:headerSize], int(headerSize)
+		for column := 0; column <= last && len(header) > 0; column++ {
+			serialType, n := varintAt(header)
+			if n == 0 {
+				return nil, corruptCell(page.PageNumber, cell, "read serial type: %v", io.ErrUnexpectedEOF)
+			}
+			header = header[n:]
+			length, err := columnRawValueLength(serialType)
+			if err != nil {
+				return nil, corruptCell(page.PageNumber, cell, "column %d: %v", column, err)
+			}
+			if offset+length > len(record) {
+				return nil, corruptCell(page.PageNumber, cell, "column %d needs %d bytes but the %d byte record has %d left", column, length, len(record), len(record)-offset)
+			}
+			for i, position := range positions {
+				if position == column {
+					serialTypes[row*width+i], starts[row*width+i] = serialType, offset
+				}
+			}
+			offset += length
+		}
+		for i := range positions {
+			class := serialTypeClass(serialTypes[row*width+i])
+			switch {
+			case class == NullValues:
+			case classes[i] == NullValues:
+				classes[i] = class
+			case classes[i] != class:
+				mixed[i] = true
+			}
+		}
+	}
+
+	// The second pass decodes each column into the slice of its type
+	for i := range positions {
+		vector := &ColumnVector{Type: classes[i], Len: rows, Valid: make([]byte, (rows+7)/8)}
+		if mixed[i] {
+			vector.Type = MixedValues
+		}
+		switch vector.Type {
+		case IntegerValues:
+			vector.Integers = make([]int64, rows)
+		case RealValues:
+			vector.Reals = make([]float64, rows)
+		case TextValues:
+			vector.Texts = make([]string, rows)
+		case BlobValues:
+			vector.Blobs = make([][]byte, rows)
+		case MixedValues:
+			vector.Values = make([]any, rows)
+		}
+		for row := range rows {
+			serialType := serialTypes[row*width+i]
+			if serialType == 0 {
+				continue
+			}
+			vector.Valid[row/8] |= 1 << (row % 8)
+			length, _ := columnRawValueLength(serialType)
+			raw := records[row][starts[row*width+i]:][:length]
+			switch vector.Type {
+			case IntegerValues:
+				switch serialType {
+				case 8:
+				case 9:
+					vector.Integers[row] = 1
+				default:
+					vector.Integers[row] = decodeSignedInteger(raw)
+				}
+			case RealValues:
+				vector.Reals[row] = math.Float64frombits(binary.BigEndian.Uint64(raw))
+			case TextValues:
+				vector.Texts[row] = string(raw)
+			case BlobValues:
+				vector.Blobs[row] = append([]byte(nil), raw...)
+			case MixedValues:
+				vector.Values[row], _ = decodeColumnValue(serialType, raw)
+			}
+		}
+		batch.Columns[i] = vector
+	}
+	return batch, nil
+}
+
+// ScanColumns reads the record columns at positions of every row of the
+// table b-tree rooted at rootPage, a leaf page at a time in rowid order, as
+// ReadColumns decodes them, and passes each page's batch to visit. An error
+// from visit stops the scan and is returned.
+func (databaseFile *DatabaseFile) ScanColumns(databaseHeader *DatabaseHeader, rootPage uint32, positions []int, visit func(*Batch) error) error {
+	return databaseFile.WalkBTree(databaseHeader, rootPage, func(pageNumber uint32, page *Page) error {
+		switch page.PageType {
+		case InteriorTable:
+			return nil
+		case LeafTable:
+			batch, err := databaseFile.ReadColumns(databaseHeader, page, positions)
+			if err != nil {
+				return err
+			}
+			return visit(batch)
+		}
+		return fmt.Errorf("page %d: type %d in a table b-tree", pageNumber, page.PageType)
+	})
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestNewBatch(t *testing.T) {
+	rows := [][]any{
+		{int64(1), int64(2), "a", nil},
+		{nil, 2.5, int64(7), nil},
+		{int64(3), nil, []byte("b"), nil},
+	}
+	types := ValueTypes(4, rows)
+	if want := []ValueType{IntegerValues, RealValues, BlobValues, NullValues}; !reflect.DeepEqual(types, want) {
+		t.Fatalf("types %v, want %v", types, want)
+	}
+	batch := NewBatch(types, rows)
+	if batch.Len != 3 || len(batch.Columns) != 4 {
+		t.Fatalf("batch of %d rows and %d columns", batch.Len, len(batch.Columns))
+	}
+	if ids := batch.Columns[0]; !reflect.DeepEqual(ids.Integers, []int64{1, 0, 3}) || ids.NullCount() != 1 || !ids.Null(1) {
+		t.Errorf("integer column %+v", ids)
+	}
+	if reals := batch.Columns[1]; !reflect.DeepEqual(reals.Reals, []float64{2, 2.5, 0}) {
+		t.Errorf("real column %+v", reals)
+	}
+	if nulls := batch.Columns[3]; nulls.NullCount() != 3 {
+		t.Errorf("NULL column has %d NULLs", nulls.NullCount())
+	}
+
+	// Boxed again, numbers in a blob column are the text they convert to
+	var got [][]any
+	for i := range batch.Len {
+		row := make([]any, len(batch.Columns))
+		for j, column := range batch.Columns {
+			row[j] = column.Value(i)
+		}
+		got = append(got, row)
+	}
+	want := [][]any{
+		{int64(1), 2.0, []byte("a"), nil},
+		{nil, 2.5, []byte("7"), nil},
+		{int64(3), nil, []byte("b"), nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("values %v, want %v", got, want)
+	}
+}
+
+func TestScanColumnsMatchesRows(t *testing.T) {
+	database := testgen.New(testgen.Options{PageSize: 512})
+	table := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text, qty integer, note, added)")
+	long := strings.Repeat("overflowing ", 100)
+	for i := int64(1); i <= 300; i++ {
+		var note any = i
+		switch i % 4 {
+		case 1:
+			note = fmt.Sprintf("note-%d", i)
+		case 2:
+			note = nil
+		case 3:
+			note = float64(i) / 2
+		}
+		if i%50 == 0 {
+			note = long
+		}
+		if i%7 == 0 {
+			// Written before the last column was added
+			table.Insert(i, nil, fmt.Sprintf("item-%d", i), i%3, note)
+			continue
+		}
+		table.Insert(i, nil, fmt.Sprintf("item-%d", i), i%3, note, []byte{byte(i)})
+	}
+	dbFile, header, err := OpenDatabaseFile(database.WriteTemp(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbFile.Close()
+	objects, err := dbFile.ReadSchema(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPage, err := RootPageLookup("items", objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	positions := []int{4, 1, 2, 3, 0}
+	var rowIDs []int64
+	var got [][]any
+	types := map[ValueType]bool{}
+	err = dbFile.ScanColumns(header, rootPage, positions, func(batch *Batch) error {
+		for i := range batch.Len {
+			row := make([]any, len(positions))
+			for column, vector := range batch.Columns {
+				row[column] = vector.Value(i)
+				types[vector.Type] = true
+			}
+			got = append(got, row)
+		}
+		rowIDs = append(rowIDs, batch.RowIDs...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cursor := dbFile.NewCursor(header, rootPage)
+	if err := cursor.First(); err != nil {
+		t.Fatal(err)
+	}
+	var want [][]any
+	var wantRowIDs []int64
+	for cursor.Valid() {
+		row, err := cursor.Row()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := make([]any, len(positions))
+		for column, position := range positions {
+			if position < len(row.Columns) {
+				values[column] = row.Columns[position].DecodedValue
+			}
+		}
+		want = append(want, values)
+		wantRowIDs = append(wantRowIDs, row.RowID)
+		if err := cursor.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(rowIDs, wantRowIDs) {
+		t.Errorf("rowids %v, want %v", rowIDs, wantRowIDs)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("columns read differ from rows")
+	}
+	// Pages whose notes are of several classes keep them boxed, and the
+	// other columns are decoded into their slices
+	for _, valueType := range []ValueType{NullValues, IntegerValues, TextValues, BlobValues, MixedValues} {
+		if !types[valueType] {
+			t.Errorf("no %s column read", valueType)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// storageClassRank orders values the way SQLite sorts them: NULL, then
+// numbers, then text, then blobs.
+func storageClassRank(value Value) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// Collation is a collating sequence, ordering text values. The zero value
+// is BINARY.
+type Collation int
+
+const (
+	// Binary compares text byte by byte
+	Binary Collation = iota
+	// NoCase compares text with ASCII letters folded to lower case
+	NoCase
+	// RTrim compares text byte by byte, ignoring trailing spaces
+	RTrim
+)
+
+// Descending, combined with a collation, orders a key column the other way
+// round, as the DESC columns of an index in schema format 4 are stored.
+const Descending Collation = 1 << 4
+
+var collationNames = []string{Binary: "BINARY", NoCase: "NOCASE", RTrim: "RTRIM"}
+
+// ParseCollation returns the built-in collating sequence with a name,
+// matched case-insensitively, reporting false for any other.
+func ParseCollation(name string) (Collation, bool) {
+	for collation, known := range collationNames {
+		if strings.EqualFold(name, known) {
+			return Collation(collation), true
+		}
+	}
+	return Binary, false
+}
+
+// Ascending returns the collation without Descending.
+func (collation Collation) Ascending() Collation {
+	return collation &^ Descending
+}
+
+func (collation Collation) String() string {
+	if collation&Descending != 0 {
+		return collation.Ascending().String() + " DESC"
+	}
+	if int(collation) < len(collationNames) {
+		return collationNames[collation]
+	}
+	return fmt.Sprintf("Collation(%d)", int(collation))
+}
+
+// collationAt returns the collation of the ith column of a key, BINARY for
+// columns past the end of collations.
+func collationAt(collations []Collation, i int) Collation {
+	if i < len(collations) {
+		return collations[i]
+	}
+	return Binary
+}
+
+// CompareValues orders two values as SQLite sorts them across storage
+// classes: NULL, then numbers compared exactly by value, then text ordered
+// by collation, then blobs compared as memcmp does. It returns a negative
+// number when a sorts first, positive when b does, and zero when equal.
+//
+// It is the one ordering of values: WHERE comparisons, sorts, joins and
+// index keys all call it rather than switching on the types themselves.
+// NULL compares equal to NULL here; callers with SQL's three-valued
+// comparisons check for NULL first. A Descending collation reverses the
+// order.
+func CompareValues(a, b Value, collation Collation) int {
+	if collation&Descending != 0 {
+		return -CompareValues(a, b, collation.Ascending())
+	}
+	if rankA, rankB := storageClassRank(a), storageClassRank(b); rankA != rankB {
+		return rankA - rankB
+	}
+
+	switch a := a.(type) {
+	case nil:
+		return 0
+	case string:
+		return collation.CompareText(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case int64:
+		if b, ok := b.(int64); ok {
+			return compareOrdered(a, b)
+		}
+		return compareIntegerReal(a, b.(float64))
+	case float64:
+		if b, ok := b.(int64); ok {
+			return -compareIntegerReal(b, a)
+		}
+		return compareOrdered(a, b.(float64))
+	}
+	return 0
+}
+
+// CompareText orders two strings by the collation, as CompareValues does.
+func (collation Collation) CompareText(a, b string) int {
+	switch collation {
+	case NoCase:
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if x, y := lowerASCII(a[i]), lowerASCII(b[i]); x != y {
+				return int(x) - int(y)
+			}
+		}
+		return len(a) - len(b)
+	case RTrim:
+		return strings.Compare(strings.TrimRight(a, " "), strings.TrimRight(b, " "))
+	}
+	return strings.Compare(a, b)
+}
+
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// compareIntegerReal compares an integer with a real exactly. Converting the
+// integer to float64 would round values beyond 2^53, so that 2^53+1 would
+// compare equal to 2^53.
+func compareIntegerReal(i int64, f float64) int {
+	switch {
+	case math.IsNaN(f):
+		// SQLite stores NaN as NULL, which sorts before every number
+		return 1
+	case f < -0x1p63:
+		return 1
+	case f >= 0x1p63:
+		return -1
+	}
+
+	truncated := int64(f)
+	if c := compareOrdered(i, truncated); c != 0 {
+		return c
+	}
+	// Equal integer parts: the real's fraction decides
+	return compareOrdered(0, f-float64(truncated))
+}
+
+func compareOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
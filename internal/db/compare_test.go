@@ -0,0 +1,140 @@
+package db
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareValuesAcrossTypes(t *testing.T) {
+	// In BINARY order each value sorts strictly before the next
+	ordered := []any{
+		nil,
+		-1e300,
+		int64(-3),
+		-2.5,
+		int64(0),
+		0.5,
+		int64(1 << 53),
+		int64(1<<53 + 1),
+		1e19,
+		"",
+		" ",
+		"A",
+		"A ",
+		"B",
+		"a",
+		"a ",
+		"b",
+		"é",
+		[]byte{},
+		[]byte{0},
+		[]byte{0, 0},
+		[]byte{'A'},
+		[]byte{'a'},
+		[]byte{0xff},
+	}
+	tests := []struct {
+		collation Collation
+		sorted    []any
+		// ties are the indexes of values equal to the one before them
+		ties map[int]bool
+	}{
+		{Binary, ordered, nil},
+		// NOCASE folds only ASCII letters, so 'a' sits with 'A' and before 'B'
+		{NoCase, []any{nil, -1e300, int64(-3), -2.5, int64(0), 0.5, int64(1 << 53), int64(1<<53 + 1), 1e19,
+			"", " ", "A", "a", "A ", "a ", "B", "b", "é",
+			[]byte{}, []byte{0}, []byte{0, 0}, []byte{'A'}, []byte{'a'}, []byte{0xff}}, map[int]bool{12: true, 14: true, 16: true}},
+		// RTRIM ignores trailing spaces in text but not in blobs
+		{RTrim, ordered, map[int]bool{10: true, 12: true, 15: true}},
+	}
+
+	for _, test := range tests {
+		for i, a := range test.sorted {
+			for j, b := range test.sorted {
+				want := 0
+				switch {
+				case i < j && !tiedRange(test.ties, i, j):
+					want = -1
+				case i > j && !tiedRange(test.ties, j, i):
+					want = 1
+				}
+				got := CompareValues(a, b, test.collation)
+				if sign(got) != want {
+					t.Errorf("%s: CompareValues(%#v, %#v) = %d, want sign %d", test.collation, a, b, got, want)
+				}
+			}
+		}
+	}
+}
+
+// tiedRange reports whether every value after i up to j ties with the one
+// before it.
+func tiedRange(ties map[int]bool, i, j int) bool {
+	for k := i + 1; k <= j; k++ {
+		if !ties[k] {
+			return false
+		}
+	}
+	return true
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func TestParseCollation(t *testing.T) {
+	for name, want := range map[string]Collation{"binary": Binary, "NOCASE": NoCase, "RTrim": RTrim} {
+		if got, ok := ParseCollation(name); !ok || got != want {
+			t.Errorf("ParseCollation(%q) = %v, %v, want %v", name, got, ok, want)
+		}
+	}
+	if _, ok := ParseCollation("unicode"); ok {
+		t.Error("ParseCollation accepted an unknown collation")
+	}
+}
+
+// TestCollatedIndexOrderMatchesSQLite has sqlite3 build an index on the
+// same mixed values under each collation and checks its order is ours.
+func TestCollatedIndexOrderMatchesSQLite(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found in PATH")
+	}
+
+	values := "(NULL), (1), (-2.5), ('a'), ('A'), ('a '), ('A  '), ('b'), ('B'), (''), (' '), ('é'), ('É'), (x''), (x'41'), (x'61'), (x'6120')"
+	for _, collation := range []Collation{Binary, NoCase, RTrim} {
+		script := fmt.Sprintf(`CREATE TABLE t (v);
+INSERT INTO t VALUES %s;
+CREATE INDEX t_v ON t (v COLLATE %s);`, values, collation)
+		path := filepath.Join(t.TempDir(), "collated.db")
+		cmd := exec.Command(sqlite3, path)
+		cmd.Stdin = strings.NewReader(script)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("sqlite3: %v\n%s", err, output)
+		}
+
+		dbFile, header, err := OpenDatabaseFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dbFile.VerifyIndexOrder(header, 3, []Collation{collation}); err != nil {
+			t.Errorf("%s: sqlite3's index order disagrees with ours: %v", collation, err)
+		}
+		// Under BINARY, NOCASE's order is out of order, and the reverse
+		if collation == NoCase {
+			if err := dbFile.VerifyIndexOrder(header, 3, nil); err == nil {
+				t.Error("a NOCASE index verified in BINARY order")
+			}
+		}
+		dbFile.Close()
+	}
+}
@@ -0,0 +1,399 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Cursor walks the entries of a table or index b-tree in key order. For
+// table trees every entry is a leaf cell. Index trees also store entries in
+// interior cells, which the cursor visits between their left child and the
+// next child.
+type Cursor struct {
+	file   *DatabaseFile
+	header *DatabaseHeader
+	root   uint32
+	stack  []cursorFrame
+}
+
+// cursorFrame is one level of the cursor's path down the tree. On interior
+// pages index is the child being traversed; when the interior frame is on top
+// of the stack the cursor is positioned on cell index itself.
+type cursorFrame struct {
+	page  *Page
+	index int
+	// order lists a table leaf's cells by rowid when its cell pointers are
+	// out of order, and is nil when they are not
+	order []int
+}
+
+// cell is the index in the page's cell pointer array of the entry the frame
+// is positioned on.
+func (frame *cursorFrame) cell() int {
+	if frame.order != nil && frame.index < len(frame.order) {
+		return frame.order[frame.index]
+	}
+	return frame.index
+}
+
+// rowIDOrder returns the cells of a table leaf sorted by rowid, or nil if
+// the cell pointer array already is. SQLite keeps it sorted, but other
+// writers need not, and a scan must still visit rows in rowid order.
+func rowIDOrder(page *Page) ([]int, error) {
+	rowIDs := make([]int64, page.CellCount)
+	sorted := true
+	for i := range rowIDs {
+		rowID, err := CellRowID(page, i)
+		if err != nil {
+			return nil, err
+		}
+		rowIDs[i] = rowID
+		sorted = sorted && (i == 0 || rowIDs[i-1] <= rowID)
+	}
+	if sorted {
+		return nil, nil
+	}
+
+	order := make([]int, len(rowIDs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return rowIDs[order[a]] < rowIDs[order[b]] })
+	return order, nil
+}
+
+func (databaseFile *DatabaseFile) NewCursor(databaseHeader *DatabaseHeader, rootPage uint32) *Cursor {
+	return &Cursor{file: databaseFile, header: databaseHeader, root: rootPage}
+}
+
+// Valid reports whether the cursor is positioned on an entry.
+func (cursor *Cursor) Valid() bool {
+	return len(cursor.stack) > 0
+}
+
+func (cursor *Cursor) top() *cursorFrame {
+	return &cursor.stack[len(cursor.stack)-1]
+}
+
+func isInterior(page *Page) bool {
+	return page.PageType == InteriorTable || page.PageType == InteriorIndex
+}
+
+func (cursor *Cursor) push(pageNumber uint32, index int) (*Page, error) {
+	if len(cursor.stack) > maxBTreeDepth {
+		return nil, fmt.Errorf("b-tree rooted at %d deeper than %d levels", cursor.root, maxBTreeDepth)
+	}
+
+	page, err := cursor.file.NewPage(cursor.header, pageNumber)
+	if err != nil {
+		return nil, err
+	}
+	frame := cursorFrame{page: page, index: index}
+	if page.PageType == LeafTable {
+		if frame.order, err = rowIDOrder(page); err != nil {
+			return nil, fmt.Errorf("page %d: %w", pageNumber, err)
+		}
+	}
+	cursor.stack = append(cursor.stack, frame)
+	return page, nil
+}
+
+func childPage(page *Page, index int) (uint32, error) {
+	children, err := ChildPages(page)
+	if err != nil {
+		return 0, fmt.Errorf("page %d: %w", page.PageNumber, err)
+	}
+	if index < 0 || index >= len(children) {
+		return 0, fmt.Errorf("page %d: child %d out of range", page.PageNumber, index)
+	}
+	return children[index], nil
+}
+
+// descendFirst pushes the path from pageNumber down to its smallest entry.
+func (cursor *Cursor) descendFirst(pageNumber uint32) error {
+	for {
+		page, err := cursor.push(pageNumber, 0)
+		if err != nil {
+			return err
+		}
+		if !isInterior(page) {
+			return nil
+		}
+		if pageNumber, err = childPage(page, 0); err != nil {
+			return err
+		}
+	}
+}
+
+// descendLast pushes the path from pageNumber down to its largest entry.
+func (cursor *Cursor) descendLast(pageNumber uint32) error {
+	for {
+		page, err := cursor.push(pageNumber, 0)
+		if err != nil {
+			return err
+		}
+		if !isInterior(page) {
+			cursor.top().index = int(page.CellCount) - 1
+			return nil
+		}
+		cursor.top().index = int(page.CellCount)
+		if pageNumber, err = childPage(page, int(page.CellCount)); err != nil {
+			return err
+		}
+	}
+}
+
+// First positions the cursor on the smallest entry of the tree.
+func (cursor *Cursor) First() error {
+	cursor.stack = cursor.stack[:0]
+	if err := cursor.descendFirst(cursor.root); err != nil {
+		return err
+	}
+	return cursor.settle()
+}
+
+// settle moves a cursor that has run off the end of a leaf to the next entry
+// up the tree, leaving it invalid once the whole tree is exhausted.
+func (cursor *Cursor) settle() error {
+	for len(cursor.stack) > 0 {
+		top := cursor.top()
+		if top.index < int(top.page.CellCount) {
+			return nil
+		}
+
+		cursor.stack = cursor.stack[:len(cursor.stack)-1]
+		if len(cursor.stack) == 0 {
+			return nil
+		}
+
+		parent := cursor.top()
+		if parent.page.PageType == InteriorIndex && parent.index < int(parent.page.CellCount) {
+			// Positioned on the interior cell that follows the finished child
+			return nil
+		}
+
+		parent.index++
+		if parent.index > int(parent.page.CellCount) {
+			// Every child finished; make the parent itself look exhausted
+			continue
+		}
+		if err := cursor.descendChild(parent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Last positions the cursor on the largest entry of the tree.
+func (cursor *Cursor) Last() error {
+	cursor.stack = cursor.stack[:0]
+	if err := cursor.descendLast(cursor.root); err != nil {
+		return err
+	}
+	return cursor.settleBack()
+}
+
+// settleBack moves a cursor that has run off the start of a leaf to the
+// previous entry up the tree, leaving it invalid once the whole tree is
+// exhausted.
+func (cursor *Cursor) settleBack() error {
+	for len(cursor.stack) > 0 {
+		if cursor.top().index >= 0 {
+			return nil
+		}
+
+		cursor.stack = cursor.stack[:len(cursor.stack)-1]
+		if len(cursor.stack) == 0 {
+			return nil
+		}
+
+		parent := cursor.top()
+		parent.index--
+		if parent.index < 0 {
+			// Every child finished; the parent is exhausted too
+			continue
+		}
+		if parent.page.PageType == InteriorIndex {
+			// Positioned on the interior cell that precedes the finished child
+			return nil
+		}
+		if err := cursor.descendLastChild(parent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cursor *Cursor) descendLastChild(frame *cursorFrame) error {
+	child, err := childPage(frame.page, frame.index)
+	if err != nil {
+		return err
+	}
+	return cursor.descendLast(child)
+}
+
+func (cursor *Cursor) descendChild(frame *cursorFrame) error {
+	child, err := childPage(frame.page, frame.index)
+	if err != nil {
+		return err
+	}
+	return cursor.descendFirst(child)
+}
+
+// Next advances the cursor to the following entry.
+func (cursor *Cursor) Next() error {
+	if !cursor.Valid() {
+		return fmt.Errorf("cursor is not positioned on an entry")
+	}
+
+	top := cursor.top()
+	if isInterior(top.page) {
+		// Leaving an interior index cell: continue with the child to its right
+		top.index++
+		if err := cursor.descendChild(top); err != nil {
+			return err
+		}
+		return cursor.settle()
+	}
+
+	top.index++
+	return cursor.settle()
+}
+
+// Prev moves the cursor back to the preceding entry.
+func (cursor *Cursor) Prev() error {
+	if !cursor.Valid() {
+		return fmt.Errorf("cursor is not positioned on an entry")
+	}
+
+	top := cursor.top()
+	if isInterior(top.page) {
+		// Leaving an interior index cell: continue with its left child
+		if err := cursor.descendLastChild(top); err != nil {
+			return err
+		}
+		return cursor.settleBack()
+	}
+
+	top.index--
+	return cursor.settleBack()
+}
+
+// Row decodes the table row under the cursor.
+func (cursor *Cursor) Row() (*Row, error) {
+	return cursor.RowIf(nil)
+}
+
+// RowIf decodes the table row under the cursor, returning a nil row as soon
+// as a decoded column fails predicate.
+func (cursor *Cursor) RowIf(predicate ColumnPredicate) (*Row, error) {
+	if !cursor.Valid() {
+		return nil, fmt.Errorf("cursor is not positioned on an entry")
+	}
+
+	top := cursor.top()
+	row, err := readRow(cursor.file, cursor.header, top.page, top.cell(), predicate)
+	if err != nil {
+		return nil, fmt.Errorf("page %d: %w", top.page.PageNumber, err)
+	}
+	return row, nil
+}
+
+// RowID reads the key of the table row under the cursor without decoding
+// its record.
+func (cursor *Cursor) RowID() (int64, error) {
+	if !cursor.Valid() {
+		return 0, fmt.Errorf("cursor is not positioned on an entry")
+	}
+
+	top := cursor.top()
+	return CellRowID(top.page, top.cell())
+}
+
+// IndexCell decodes the index entry under the cursor.
+func (cursor *Cursor) IndexCell() (*IndexCell, error) {
+	if !cursor.Valid() {
+		return nil, fmt.Errorf("cursor is not positioned on an entry")
+	}
+
+	top := cursor.top()
+	return cursor.file.ReadIndexCell(cursor.header, top.page, top.index)
+}
+
+// SeekIndex positions an index cursor on the first entry whose key is at
+// least key, comparing only as many leading columns as key holds, each by
+// the index's collation for it in collations.
+func (cursor *Cursor) SeekIndex(key []any, collations []Collation) error {
+	cursor.stack = cursor.stack[:0]
+
+	pageNumber := cursor.root
+	for {
+		page, err := cursor.push(pageNumber, 0)
+		if err != nil {
+			return err
+		}
+
+		var searchErr error
+		index := sort.Search(int(page.CellCount), func(i int) bool {
+			cell, err := cursor.file.ReadIndexCell(cursor.header, page, i)
+			if err != nil {
+				if searchErr == nil {
+					searchErr = err
+				}
+				return true
+			}
+			return compareKeyPrefix(cell.Columns, key, collations) >= 0
+		})
+		if searchErr != nil {
+			return searchErr
+		}
+		cursor.top().index = index
+
+		if !isInterior(page) {
+			return cursor.settle()
+		}
+		if pageNumber, err = childPage(page, index); err != nil {
+			return err
+		}
+	}
+}
+
+// SeekRowID positions a table cursor on the row with the smallest rowid at
+// least rowID, leaving it invalid when every row's is smaller.
+func (cursor *Cursor) SeekRowID(rowID int64) error {
+	cursor.stack = cursor.stack[:0]
+
+	pageNumber := cursor.root
+	for {
+		page, err := cursor.push(pageNumber, 0)
+		if err != nil {
+			return err
+		}
+		frame := cursor.top()
+
+		var searchErr error
+		index := sort.Search(int(page.CellCount), func(i int) bool {
+			frame.index = i
+			key, err := CellRowID(page, frame.cell())
+			if err != nil && searchErr == nil {
+				searchErr = err
+			}
+			return key >= rowID
+		})
+		if searchErr != nil {
+			return fmt.Errorf("page %d: %w", pageNumber, searchErr)
+		}
+		frame.index = index
+
+		switch page.PageType {
+		case LeafTable:
+			return cursor.settle()
+		case InteriorTable:
+			if pageNumber, err = childPage(page, index); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("page %d: type %d is not a table page", pageNumber, page.PageType)
+		}
+	}
+}
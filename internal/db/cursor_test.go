@@ -0,0 +1,351 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+// generatedIndex writes a table whose indexed name column sorts in the
+// reverse order of rowid and returns the index root page.
+func generatedIndex(t *testing.T, rows int) (*DatabaseFile, *DatabaseHeader, uint32) {
+	t.Helper()
+
+	database := testgen.New(testgen.Options{PageSize: 512})
+	table := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text)")
+	for i := 1; i <= rows; i++ {
+		table.Insert(int64(i), nil, fmt.Sprintf("name-%05d", rows-i))
+	}
+	database.CreateIndex("idx_items_name", table, "CREATE INDEX idx_items_name ON items (name)", 1)
+
+	dbFile, header, err := OpenDatabaseFile(database.WriteTemp(t))
+	if err != nil {
+		t.Fatalf("opening generated database: %v", err)
+	}
+	t.Cleanup(func() { dbFile.Close() })
+
+	objects, err := dbFile.ReadSchema(header)
+	if err != nil {
+		t.Fatalf("reading schema: %v", err)
+	}
+	for _, object := range objects {
+		if object.Name == "idx_items_name" {
+			return dbFile, header, object.RootPage
+		}
+	}
+	t.Fatalf("index missing from schema")
+	return nil, nil, 0
+}
+
+func TestCursorScansTableInRowIDOrder(t *testing.T) {
+	for _, rows := range []int{0, 1, 5000} {
+		dbFile, header, rootPage := generatedTable(t, testgen.Options{PageSize: 512}, rows)
+
+		cursor := dbFile.NewCursor(header, rootPage)
+		if err := cursor.First(); err != nil {
+			t.Fatalf("positioning cursor: %v", err)
+		}
+
+		seen := int64(0)
+		for ; cursor.Valid(); seen++ {
+			row, err := cursor.Row()
+			if err != nil {
+				t.Fatalf("reading row: %v", err)
+			}
+			if row.RowID != seen+1 {
+				t.Fatalf("unexpected rowid: got %d, want %d", row.RowID, seen+1)
+			}
+			if err := cursor.Next(); err != nil {
+				t.Fatalf("advancing cursor: %v", err)
+			}
+		}
+
+		if seen != int64(rows) {
+			t.Fatalf("scanned %d rows, want %d", seen, rows)
+		}
+	}
+}
+
+func TestCursorScansUnsortedLeafInRowIDOrder(t *testing.T) {
+	const rows = 6
+	database := testgen.New(testgen.Options{PageSize: 512})
+	table := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text)")
+	for i := 1; i <= rows; i++ {
+		table.Insert(int64(i), nil, fmt.Sprintf("item-%d", i))
+	}
+	path := database.WriteTemp(t)
+
+	// Reverse the root leaf's cell pointer array, which is valid b-tree
+	// content in every respect but its order.
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading generated database: %v", err)
+	}
+	pointers := contents[512+8 : 512+8+2*rows]
+	for i, j := 0, rows-1; i < j; i, j = i+1, j-1 {
+		pointers[2*i], pointers[2*i+1], pointers[2*j], pointers[2*j+1] = pointers[2*j], pointers[2*j+1], pointers[2*i], pointers[2*i+1]
+	}
+	if err := os.WriteFile(path, contents, 0o644); err != nil {
+		t.Fatalf("writing shuffled database: %v", err)
+	}
+
+	dbFile, header, err := OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatalf("opening shuffled database: %v", err)
+	}
+	defer dbFile.Close()
+	page, err := dbFile.NewPage(header, 2)
+	if err != nil {
+		t.Fatalf("reading root page: %v", err)
+	}
+	if first, err := CellRowID(page, 0); err != nil || first != rows {
+		t.Fatalf("first cell pointer has rowid %d (%v), want %d", first, err, rows)
+	}
+
+	cursor := dbFile.NewCursor(header, 2)
+	if err := cursor.First(); err != nil {
+		t.Fatalf("positioning cursor: %v", err)
+	}
+	for want := int64(1); want <= rows; want++ {
+		if !cursor.Valid() {
+			t.Fatalf("scan ended before rowid %d", want)
+		}
+		row, err := cursor.Row()
+		if err != nil {
+			t.Fatalf("reading row: %v", err)
+		}
+		if row.RowID != want {
+			t.Fatalf("unexpected rowid: got %d, want %d", row.RowID, want)
+		}
+		if name := row.Columns[1].DecodedValue; name != fmt.Sprintf("item-%d", want) {
+			t.Fatalf("rowid %d: unexpected name %v", want, name)
+		}
+		if err := cursor.Next(); err != nil {
+			t.Fatalf("advancing cursor: %v", err)
+		}
+	}
+	if cursor.Valid() {
+		t.Fatalf("scan continued past rowid %d", rows)
+	}
+}
+
+func TestCursorVisitsInteriorIndexEntries(t *testing.T) {
+	const rows = 3000
+	dbFile, header, rootPage := generatedIndex(t, rows)
+
+	cursor := dbFile.NewCursor(header, rootPage)
+	if err := cursor.First(); err != nil {
+		t.Fatalf("positioning cursor: %v", err)
+	}
+
+	seen := 0
+	for ; cursor.Valid(); seen++ {
+		cell, err := cursor.IndexCell()
+		if err != nil {
+			t.Fatalf("reading index cell: %v", err)
+		}
+		if name := cell.Columns[0].DecodedValue.(string); name != fmt.Sprintf("name-%05d", seen) {
+			t.Fatalf("entry %d: unexpected key %q", seen, name)
+		}
+		if rowID := cell.Columns[1].DecodedValue.(int64); rowID != int64(rows-seen) {
+			t.Fatalf("entry %d: unexpected rowid %d", seen, rowID)
+		}
+		if err := cursor.Next(); err != nil {
+			t.Fatalf("advancing cursor: %v", err)
+		}
+	}
+
+	if seen != rows {
+		t.Fatalf("visited %d index entries, want %d", seen, rows)
+	}
+}
+
+func TestCursorScansTableBackward(t *testing.T) {
+	for _, rows := range []int{0, 1, 5000} {
+		dbFile, header, rootPage := generatedTable(t, testgen.Options{PageSize: 512}, rows)
+
+		cursor := dbFile.NewCursor(header, rootPage)
+		if err := cursor.Last(); err != nil {
+			t.Fatalf("positioning cursor: %v", err)
+		}
+
+		seen := 0
+		for ; cursor.Valid(); seen++ {
+			rowID, err := cursor.RowID()
+			if err != nil {
+				t.Fatalf("reading rowid: %v", err)
+			}
+			if want := int64(rows - seen); rowID != want {
+				t.Fatalf("unexpected rowid: got %d, want %d", rowID, want)
+			}
+			if err := cursor.Prev(); err != nil {
+				t.Fatalf("moving cursor back: %v", err)
+			}
+		}
+
+		if seen != rows {
+			t.Fatalf("scanned %d rows, want %d", seen, rows)
+		}
+	}
+}
+
+func TestCursorVisitsIndexEntriesBackward(t *testing.T) {
+	const rows = 3000
+	dbFile, header, rootPage := generatedIndex(t, rows)
+
+	cursor := dbFile.NewCursor(header, rootPage)
+	if err := cursor.Last(); err != nil {
+		t.Fatalf("positioning cursor: %v", err)
+	}
+
+	seen := 0
+	for ; cursor.Valid(); seen++ {
+		cell, err := cursor.IndexCell()
+		if err != nil {
+			t.Fatalf("reading index cell: %v", err)
+		}
+		if name := cell.Columns[0].DecodedValue.(string); name != fmt.Sprintf("name-%05d", rows-1-seen) {
+			t.Fatalf("entry %d from the end: unexpected key %q", seen, name)
+		}
+		if err := cursor.Prev(); err != nil {
+			t.Fatalf("moving cursor back: %v", err)
+		}
+	}
+
+	if seen != rows {
+		t.Fatalf("visited %d index entries, want %d", seen, rows)
+	}
+}
+
+func TestCursorChangesDirection(t *testing.T) {
+	const rows = 3000
+	dbFile, header, rootPage := generatedIndex(t, rows)
+	cursor := dbFile.NewCursor(header, rootPage)
+
+	// Step forward and back across every entry, interior ones included
+	if err := cursor.First(); err != nil {
+		t.Fatalf("positioning cursor: %v", err)
+	}
+	for want := 0; want < rows-1; want++ {
+		if err := cursor.Next(); err != nil {
+			t.Fatalf("advancing cursor: %v", err)
+		}
+		if err := cursor.Prev(); err != nil {
+			t.Fatalf("moving cursor back: %v", err)
+		}
+		cell, err := cursor.IndexCell()
+		if err != nil {
+			t.Fatalf("reading index cell: %v", err)
+		}
+		if name := cell.Columns[0].DecodedValue.(string); name != fmt.Sprintf("name-%05d", want) {
+			t.Fatalf("entry %d: came back to %q", want, name)
+		}
+		if err := cursor.Next(); err != nil {
+			t.Fatalf("advancing cursor: %v", err)
+		}
+	}
+
+	if err := cursor.Next(); err != nil {
+		t.Fatalf("advancing cursor: %v", err)
+	}
+	if cursor.Valid() {
+		t.Fatal("cursor still valid after the last entry")
+	}
+}
+
+func TestCursorSeekIndex(t *testing.T) {
+	const rows = 3000
+	dbFile, header, rootPage := generatedIndex(t, rows)
+	cursor := dbFile.NewCursor(header, rootPage)
+
+	for _, target := range []int{0, 1, 1234, 2998, 2999} {
+		key := fmt.Sprintf("name-%05d", target)
+		if err := cursor.SeekIndex([]any{key}, nil); err != nil {
+			t.Fatalf("seeking %q: %v", key, err)
+		}
+		if !cursor.Valid() {
+			t.Fatalf("seeking %q: cursor not positioned", key)
+		}
+
+		cell, err := cursor.IndexCell()
+		if err != nil {
+			t.Fatalf("reading index cell: %v", err)
+		}
+		if name := cell.Columns[0].DecodedValue.(string); name != key {
+			t.Fatalf("seeking %q: landed on %q", key, name)
+		}
+	}
+
+	// Keys between entries land on the next larger one
+	if err := cursor.SeekIndex([]any{"name-01234x"}, nil); err != nil {
+		t.Fatalf("seeking between keys: %v", err)
+	}
+	cell, err := cursor.IndexCell()
+	if err != nil {
+		t.Fatalf("reading index cell: %v", err)
+	}
+	if name := cell.Columns[0].DecodedValue.(string); name != "name-01235" {
+		t.Fatalf("seeking between keys: landed on %q", name)
+	}
+
+	if err := cursor.SeekIndex([]any{"zzz"}, nil); err != nil {
+		t.Fatalf("seeking past the end: %v", err)
+	}
+	if cursor.Valid() {
+		t.Fatalf("seeking past the end: cursor still positioned")
+	}
+}
+
+func TestCursorSeekRowID(t *testing.T) {
+	database := testgen.New(testgen.Options{PageSize: 512})
+	table := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text)")
+	for i := 1; i <= 3000; i++ {
+		table.Insert(int64(2*i), nil, fmt.Sprintf("name-%05d", i))
+	}
+	dbFile, header, err := OpenDatabaseFile(database.WriteTemp(t))
+	if err != nil {
+		t.Fatalf("opening generated database: %v", err)
+	}
+	t.Cleanup(func() { dbFile.Close() })
+	objects, err := dbFile.ReadSchema(header)
+	if err != nil {
+		t.Fatalf("reading schema: %v", err)
+	}
+	cursor := dbFile.NewCursor(header, objects[0].RootPage)
+
+	// Rowids between rows land on the next larger one, and the cursor
+	// carries on from there
+	for _, target := range []int64{-5, 2, 3, 1234, 2471, 5999, 6000} {
+		if err := cursor.SeekRowID(target); err != nil {
+			t.Fatalf("seeking %d: %v", target, err)
+		}
+		want := max(2, target+target%2)
+		for _, next := range []int64{want, want + 2} {
+			if next > 6000 {
+				break
+			}
+			if !cursor.Valid() {
+				t.Fatalf("seeking %d: cursor not positioned on %d", target, next)
+			}
+			rowID, err := cursor.RowID()
+			if err != nil {
+				t.Fatalf("reading rowid: %v", err)
+			}
+			if rowID != next {
+				t.Fatalf("seeking %d: on %d, want %d", target, rowID, next)
+			}
+			if err := cursor.Next(); err != nil {
+				t.Fatalf("advancing: %v", err)
+			}
+		}
+	}
+
+	if err := cursor.SeekRowID(6001); err != nil {
+		t.Fatalf("seeking past the end: %v", err)
+	}
+	if cursor.Valid() {
+		t.Fatalf("seeking past the end: cursor still positioned")
+	}
+}
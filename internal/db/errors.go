@@ -0,0 +1,26 @@
+package db
+
+import "fmt"
+
+// CorruptionError reports on-disk structures that are inconsistent with the
+// file format. Cell is -1 when the problem is not specific to one cell.
+type CorruptionError struct {
+	Page   uint32
+	Cell   int
+	Reason string
+}
+
+func (corruptionError *CorruptionError) Error() string {
+	if corruptionError.Cell < 0 {
+		return fmt.Sprintf("corrupt page %d: %s", corruptionError.Page, corruptionError.Reason)
+	}
+	return fmt.Sprintf("corrupt page %d, cell %d: %s", corruptionError.Page, corruptionError.Cell, corruptionError.Reason)
+}
+
+func corruptPage(pageNumber uint32, format string, args ...any) error {
+	return &CorruptionError{Page: pageNumber, Cell: -1, Reason: fmt.Sprintf(format, args...)}
+}
+
+func corruptCell(pageNumber uint32, cellIndex int, format string, args ...any) error {
+	return &CorruptionError{Page: pageNumber, Cell: cellIndex, Reason: fmt.Sprintf(format, args...)}
+}
@@ -1,57 +1,269 @@
 package db
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"os"
+	"sync/atomic"
+	"time"
 )
 
 const databaseHeaderBytes = 100
 
 type DatabaseFile struct {
-	*os.File
+	storage
+	// wal is the write-ahead log of a database in WAL journal mode, and nil
+	// otherwise
+	wal *wal
+	// pin is set while the handle is pinned to a snapshot, which Refresh
+	// does not move
+	pin *pin
+	// pending is the pager whose uncommitted writes reads include
+	pending *Pager
+	// BusyTimeout is how long to wait for other processes' locks before
+	// failing with ErrBusy; zero fails at once
+	BusyTimeout time.Duration
+	// VerifyChecksums checks each page read from the write-ahead log
+	// against its frame's checksum, and a hot journal's records against
+	// theirs before replaying it, failing on a mismatch rather than
+	// reading a page that was silently corrupted
+	VerifyChecksums bool
+	// Progress, when set, is called before each b-tree page is read, and an
+	// error it returns fails the read
+	Progress func() error
+	// Logger, when set, receives debug records of page reads and of
+	// recoveries such as replaying a hot journal
+	Logger *slog.Logger
+
+	writable bool
+	// pagesRead counts the b-tree pages read through the handle
+	pagesRead atomic.Int64
+	// level is the lock this handle holds, and readers counts the
+	// LockShared calls not yet released
+	level   lockLevel
+	readers int
+}
+
+// debug logs a debug record to Logger, if there is one.
+func (databaseFile *DatabaseFile) debug(msg string, args ...any) {
+	if databaseFile.Logger != nil && databaseFile.Logger.Enabled(context.Background(), slog.LevelDebug) {
+		databaseFile.Logger.Debug(msg, args...)
+	}
 }
 
 type DatabaseHeader struct {
-	PageSize  uint16
-	PageCount uint32
+	PageSize      uint32
+	ReservedBytes uint8
+	// ChangeCounter is incremented by every transaction that modifies the
+	// file
+	ChangeCounter uint32
+	PageCount     uint32
+	// FreelistTrunk is the first freelist trunk page, or 0 when no pages
+	// are free
+	FreelistTrunk uint32
+	FreelistCount uint32
+	// SchemaCookie is incremented by every change to the schema, so that
+	// connections know to reparse it
+	SchemaCookie uint32
+	// SchemaFormat is the schema format number, 1 to 4. Only format 4 keeps
+	// the DESC columns of an index in descending order and stores 0 and 1
+	// as serial types 8 and 9; before it DESC is ignored
+	SchemaFormat uint32
+	// TextEncoding is 1 for UTF-8, 2 for UTF-16le and 3 for UTF-16be
+	TextEncoding uint32
+	// UserVersion is free for applications to use, via PRAGMA user_version
+	UserVersion uint32
 }
 
-func (databaseFile *DatabaseFile) NewDatabaseHeader() (*DatabaseHeader, error) {
-	if _, err := databaseFile.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("seek database start: %w", err)
-	}
+// legacyFormat reports whether the schema format predates format 4, whose
+// serial types the file must then be written without.
+func (databaseHeader *DatabaseHeader) legacyFormat() bool {
+	return databaseHeader.SchemaFormat != 0 && databaseHeader.SchemaFormat < 4
+}
 
+// UsableSize is the number of bytes on each page available to b-tree content.
+func (databaseHeader *DatabaseHeader) UsableSize() int {
+	return int(databaseHeader.PageSize) - int(databaseHeader.ReservedBytes)
+}
+
+func (databaseFile *DatabaseFile) NewDatabaseHeader() (*DatabaseHeader, error) {
 	header := make([]byte, databaseHeaderBytes)
 	var databaseHeader DatabaseHeader
 
-	if n, err := databaseFile.Read(header); err != nil || n != databaseHeaderBytes {
+	if n, err := databaseFile.ReadAt(header, 0); err != nil || n != databaseHeaderBytes {
 		return nil, fmt.Errorf("read database header (%d bytes): %w", n, err)
 	}
 
-	databaseHeader.PageSize = binary.BigEndian.Uint16(header[16:18])
+	// A page size of 65536 does not fit in the field, which holds 1 for it
+	databaseHeader.PageSize = uint32(binary.BigEndian.Uint16(header[16:18]))
+	if databaseHeader.PageSize == 1 {
+		databaseHeader.PageSize = 65536
+	}
+	databaseHeader.ReservedBytes = header[20]
+	databaseHeader.ChangeCounter = binary.BigEndian.Uint32(header[24:28])
+	databaseHeader.PageCount = binary.BigEndian.Uint32(header[28:32])
+	// The stored page count is only trusted when the version-valid-for
+	// number matches the change counter; older writers left it stale
+	if versionValidFor := binary.BigEndian.Uint32(header[92:96]); versionValidFor != databaseHeader.ChangeCounter || databaseHeader.PageCount == 0 {
+		info, err := databaseFile.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("stat database: %w", err)
+		}
+		if databaseHeader.PageSize > 0 {
+			databaseHeader.PageCount = uint32(info.Size() / int64(databaseHeader.PageSize))
+		}
+	}
+	// In WAL mode the last commit frame records the size of the database
+	if databaseFile.wal != nil && databaseFile.wal.pageCount != 0 {
+		databaseHeader.PageCount = databaseFile.wal.pageCount
+	}
+	databaseHeader.FreelistTrunk = binary.BigEndian.Uint32(header[32:36])
+	databaseHeader.FreelistCount = binary.BigEndian.Uint32(header[36:40])
+	databaseHeader.SchemaCookie = binary.BigEndian.Uint32(header[40:44])
+	// A database with no schema yet may have a format of 0
+	databaseHeader.SchemaFormat = binary.BigEndian.Uint32(header[44:48])
+	if databaseHeader.SchemaFormat > 4 {
+		return nil, fmt.Errorf("unsupported schema format %d", databaseHeader.SchemaFormat)
+	}
+	databaseHeader.TextEncoding = binary.BigEndian.Uint32(header[56:60])
+	databaseHeader.UserVersion = binary.BigEndian.Uint32(header[60:64])
 	return &databaseHeader, nil
 }
 
-func LoadPage(path string, pageNum uint32) (*DatabaseHeader, *Page, error) {
-	if pageNum == 0 {
-		return nil, nil, errors.New("page numbers start at 1")
+// Writable reports whether the file was opened for writing.
+func (databaseFile *DatabaseFile) Writable() bool {
+	return databaseFile.writable
+}
+
+// PagesRead returns the number of b-tree pages read through the handle
+// since it was opened. It is safe to call while another goroutine reads.
+func (databaseFile *DatabaseFile) PagesRead() int64 {
+	return databaseFile.pagesRead.Load()
+}
+
+// Close closes the database file and its write-ahead log, if open. Closing
+// an in-memory database discards it.
+func (databaseFile *DatabaseFile) Close() error {
+	if databaseFile.wal != nil && databaseFile.wal.file != nil {
+		databaseFile.wal.file.Close()
 	}
+	return databaseFile.storage.Close()
+}
+
+// OpenDatabaseFile opens the database at path and reads its header. The
+// caller is responsible for closing the returned file.
+func OpenDatabaseFile(path string) (*DatabaseFile, *DatabaseHeader, error) {
+	return openDatabaseFile(path, os.O_RDONLY)
+}
 
-	file, err := os.Open(path)
+// OpenWritableDatabaseFile opens the database at path for reading and
+// writing, for use with a Pager. A rollback journal left behind by an
+// interrupted transaction is replayed first, so the header read reflects the
+// last committed state; while another process is writing, that is left to
+// the first LockShared.
+func OpenWritableDatabaseFile(path string) (*DatabaseFile, *DatabaseHeader, error) {
+	return openDatabaseFile(path, os.O_RDWR)
+}
+
+// CreateDatabaseFile writes a new, empty database at path, one page of the
+// given size holding an empty schema table, as SQLite leaves a database
+// before its first table. It fails if path already exists.
+func CreateDatabaseFile(path string, pageSize int) error {
+	contents, err := emptyDatabase(pageSize)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("create database: %w", err)
+	}
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return fmt.Errorf("create database: %w", err)
+	}
+	return file.Close()
+}
+
+// emptyDatabase returns the one page of a new database of the given page
+// size.
+func emptyDatabase(pageSize int) ([]byte, error) {
+	if pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid page size %d", pageSize)
+	}
+
+	contents := make([]byte, pageSize)
+	copy(contents, "SQLite format 3\x00")
+	if pageSize == 65536 {
+		binary.BigEndian.PutUint16(contents[16:18], 1)
+	} else {
+		binary.BigEndian.PutUint16(contents[16:18], uint16(pageSize))
+	}
+	// File format versions, then the payload fractions SQLite requires
+	contents[18], contents[19] = 1, 1
+	contents[21], contents[22], contents[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(contents[24:28], 1)
+	binary.BigEndian.PutUint32(contents[28:32], 1)
+	binary.BigEndian.PutUint32(contents[44:48], 4)
+	binary.BigEndian.PutUint32(contents[56:60], 1)
+	binary.BigEndian.PutUint32(contents[92:96], 1)
+	binary.BigEndian.PutUint32(contents[96:100], writeLibraryVersion)
+
+	// Page 1 is an empty table leaf whose content area starts at the end,
+	// which for a 65536-byte page wraps round to the 0 SQLite reads it as
+	contents[databaseHeaderBytes] = byte(LeafTable)
+	binary.BigEndian.PutUint16(contents[databaseHeaderBytes+5:databaseHeaderBytes+7], uint16(pageSize))
+	return contents, nil
+}
+
+func openDatabaseFile(path string, flag int) (*DatabaseFile, *DatabaseHeader, error) {
+	file, err := os.OpenFile(path, flag, 0)
 	if err != nil {
 		return nil, nil, fmt.Errorf("open database: %w", err)
 	}
-	defer file.Close()
 
-	dbFile := &DatabaseFile{File: file}
+	dbFile := &DatabaseFile{storage: file, writable: flag&os.O_RDWR != 0}
+	// The file format versions at offsets 18 and 19 never change through
+	// the log, so the database file itself says whether there is one
+	var versions [20]byte
+	if _, err := file.ReadAt(versions[:], 0); err == nil && isWALMode(versions[:]) {
+		if dbFile.wal, err = openWAL(path, flag); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+
+	if dbFile.writable && dbFile.wal == nil {
+		if err := dbFile.LockShared(); err == nil {
+			dbFile.UnlockShared()
+		} else if !errors.Is(err, ErrBusy) {
+			dbFile.Close()
+			return nil, nil, err
+		}
+	}
+
 	header, err := dbFile.NewDatabaseHeader()
 	if err != nil {
+		dbFile.Close()
 		return nil, nil, fmt.Errorf("read database header: %w", err)
 	}
 
+	return dbFile, header, nil
+}
+
+func LoadPage(path string, pageNum uint32) (*DatabaseHeader, *Page, error) {
+	if pageNum == 0 {
+		return nil, nil, errors.New("page numbers start at 1")
+	}
+
+	dbFile, header, err := OpenDatabaseFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer dbFile.Close()
+
 	page, err := dbFile.NewPage(header, pageNum)
 	if err != nil {
 		return nil, nil, fmt.Errorf("read schema page: %w", err)
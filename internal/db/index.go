@@ -0,0 +1,75 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type IndexCell struct {
+	LeftChild        uint32
+	PayloadSize      uint64
+	RecordHeaderSize uint64
+	Columns          []Column
+}
+
+// ReadIndexCell decodes the key record of a leaf or interior index cell,
+// following overflow pages when the key does not fit on the page. Interior
+// cells also carry the page number of their left child.
+func (databaseFile *DatabaseFile) ReadIndexCell(databaseHeader *DatabaseHeader, page *Page, cellIndex int) (*IndexCell, error) {
+	if page == nil {
+		return nil, fmt.Errorf("page is nil")
+	}
+
+	if page.PageType != InteriorIndex && page.PageType != LeafIndex {
+		return nil, fmt.Errorf("page type %d is not an index page", page.PageType)
+	}
+
+	cellData, err := CellData(page, cellIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	cell := &IndexCell{}
+	if page.PageType == InteriorIndex {
+		if len(cellData) < 4 {
+			return nil, fmt.Errorf("cell %d: left child pointer truncated", cellIndex)
+		}
+		cell.LeftChild = binary.BigEndian.Uint32(cellData[0:4])
+		cellData = cellData[4:]
+	}
+
+	payloadSize, n, err := ReadVarint(bytes.NewReader(cellData))
+	if err != nil {
+		return nil, fmt.Errorf("cell %d: read payload size: %w", cellIndex, err)
+	}
+	cell.PayloadSize = payloadSize
+
+	payload, err := databaseFile.cellPayload(databaseHeader, page, cellIndex, cellData[n:], payloadSize)
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize, columns, _, err := decodeRecord(payload, nil)
+	if err != nil {
+		return nil, corruptCell(page.PageNumber, cellIndex, "%v", err)
+	}
+	cell.RecordHeaderSize = headerSize
+	cell.Columns = columns
+
+	return cell, nil
+}
+
+func (databaseFile *DatabaseFile) ReadAllIndexCells(databaseHeader *DatabaseHeader, page *Page) ([]*IndexCell, error) {
+	cells := make([]*IndexCell, 0, int(page.CellCount))
+
+	for i := 0; i < int(page.CellCount); i++ {
+		cell, err := databaseFile.ReadIndexCell(databaseHeader, page, i)
+		if err != nil {
+			return nil, err
+		}
+		cells = append(cells, cell)
+	}
+
+	return cells, nil
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestReadIndexCellReassemblesOverflowingInteriorKey(t *testing.T) {
+	const pageSize = 512
+	longKey := strings.Repeat("composite-key/", 80)
+	record := testRecord(t, longKey, int64(7), int64(1<<40))
+
+	localSize := localPayloadSize(InteriorIndex, pageSize, uint64(len(record)))
+	if localSize >= len(record) {
+		t.Fatalf("test record of %d bytes does not overflow", len(record))
+	}
+
+	// Page 2: interior index page with a single cell spilling onto pages 3 and up
+	cell := binary.BigEndian.AppendUint32(nil, 9)
+	cell = appendVarint(cell, uint64(len(record)))
+	cell = append(cell, record[:localSize]...)
+	cell = binary.BigEndian.AppendUint32(cell, 3)
+
+	interior := make([]byte, pageSize)
+	contentStart := pageSize - len(cell)
+	interior[0] = byte(InteriorIndex)
+	binary.BigEndian.PutUint16(interior[3:5], 1)
+	binary.BigEndian.PutUint16(interior[5:7], uint16(contentStart))
+	binary.BigEndian.PutUint32(interior[8:12], 10)
+	binary.BigEndian.PutUint16(interior[12:14], uint16(contentStart))
+	copy(interior[contentStart:], cell)
+
+	pages := [][]byte{interior}
+	for rest := record[localSize:]; len(rest) > 0; {
+		overflow := make([]byte, pageSize)
+		n := copy(overflow[4:], rest)
+		rest = rest[n:]
+		if len(rest) > 0 {
+			binary.BigEndian.PutUint32(overflow[0:4], uint32(len(pages)+3))
+		}
+		pages = append(pages, overflow)
+	}
+	if len(pages) < 3 {
+		t.Fatalf("test record spans %d overflow pages, want a chain", len(pages)-1)
+	}
+
+	dbFile, header := writeTestDatabase(t, pageSize, pages...)
+
+	page, err := dbFile.NewPage(header, 2)
+	if err != nil {
+		t.Fatalf("reading page: %v", err)
+	}
+	if page.RightPointer != 10 {
+		t.Fatalf("unexpected right pointer: got %d, want 10", page.RightPointer)
+	}
+
+	indexCell, err := dbFile.ReadIndexCell(header, page, 0)
+	if err != nil {
+		t.Fatalf("reading index cell: %v", err)
+	}
+
+	if indexCell.LeftChild != 9 {
+		t.Fatalf("unexpected left child: got %d, want 9", indexCell.LeftChild)
+	}
+	if len(indexCell.Columns) != 3 {
+		t.Fatalf("unexpected column count: got %d, want 3", len(indexCell.Columns))
+	}
+	if key := indexCell.Columns[0].DecodedValue.(string); key != longKey {
+		t.Fatalf("unexpected reassembled key of %d bytes, want %d bytes", len(key), len(longKey))
+	}
+	if value := indexCell.Columns[1].DecodedValue.(int64); value != 7 {
+		t.Fatalf("unexpected second key column: got %d, want 7", value)
+	}
+	if rowID := indexCell.Columns[2].DecodedValue.(int64); rowID != 1<<40 {
+		t.Fatalf("unexpected rowid suffix: got %d, want %d", rowID, int64(1<<40))
+	}
+}
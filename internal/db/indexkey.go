@@ -0,0 +1,119 @@
+package db
+
+import (
+	"fmt"
+)
+
+// IndexKey is the key of an index entry: the indexed column values in index
+// order, followed by the rowid of the table row they were taken from.
+type IndexKey struct {
+	Values []Value
+	RowID  int64
+}
+
+// EncodeIndexKey encodes key as the record an index b-tree cell stores, with
+// the rowid as its final column.
+func EncodeIndexKey(key IndexKey) []byte {
+	values := make([]Value, 0, len(key.Values)+1)
+	values = append(values, key.Values...)
+	return EncodeRecord(append(values, key.RowID))
+}
+
+// DecodeIndexKey decodes an index record into its column values and rowid.
+func DecodeIndexKey(record []byte) (IndexKey, error) {
+	_, columns, _, err := decodeRecord(record, nil)
+	if err != nil {
+		return IndexKey{}, err
+	}
+	return indexKeyFromColumns(columns)
+}
+
+// Key returns the cell's decoded index key.
+func (cell *IndexCell) Key() (IndexKey, error) {
+	return indexKeyFromColumns(cell.Columns)
+}
+
+func indexKeyFromColumns(columns []Column) (IndexKey, error) {
+	if len(columns) == 0 {
+		return IndexKey{}, fmt.Errorf("index record has no rowid")
+	}
+	rowID, ok := columns[len(columns)-1].DecodedValue.(int64)
+	if !ok {
+		return IndexKey{}, fmt.Errorf("index record ends in %T, not an integer rowid", columns[len(columns)-1].DecodedValue)
+	}
+
+	values := make([]any, len(columns)-1)
+	for i := range values {
+		values[i] = columns[i].DecodedValue
+	}
+	return IndexKey{Values: values, RowID: rowID}, nil
+}
+
+// CompareIndexKeys orders keys the way an index b-tree stores them: column
+// by column using SQLite's cross-type ordering, text in each column by its
+// collation, then by rowid. Columns past the end of collations are BINARY.
+// It returns a negative number when a sorts first, positive when b does,
+// and zero when they are the same entry.
+func CompareIndexKeys(a, b IndexKey, collations []Collation) int {
+	if c := compareKeyValues(a.Values, b.Values, collations); c != 0 {
+		return c
+	}
+	return compareOrdered(a.RowID, b.RowID)
+}
+
+// compareKeyValues compares two lists of column values pairwise. When one
+// is a prefix of the other, the shorter sorts first.
+func compareKeyValues(a, b []any, collations []Collation) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := CompareValues(a[i], b[i], collationAt(collations, i)); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// compareKeyPrefix compares the leading len(key) columns of an index record
+// against key.
+func compareKeyPrefix(columns []Column, key []any, collations []Collation) int {
+	for i, value := range key {
+		if i >= len(columns) {
+			return -1
+		}
+		if c := CompareValues(columns[i].DecodedValue, value, collationAt(collations, i)); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// VerifyIndexOrder walks the index b-tree rooted at rootPage in key order
+// and reports the first entry that does not sort strictly after the one
+// before it, with its columns collated by collations, as integrity_check
+// does.
+func (databaseFile *DatabaseFile) VerifyIndexOrder(databaseHeader *DatabaseHeader, rootPage uint32, collations []Collation) error {
+	cursor := databaseFile.NewCursor(databaseHeader, rootPage)
+	if err := cursor.First(); err != nil {
+		return err
+	}
+
+	var previous IndexKey
+	for entry := 0; cursor.Valid(); entry++ {
+		cell, err := cursor.IndexCell()
+		if err != nil {
+			return err
+		}
+		key, err := cell.Key()
+		if err != nil {
+			return fmt.Errorf("index rooted at %d, entry %d: %w", rootPage, entry, err)
+		}
+		if entry > 0 && CompareIndexKeys(previous, key, collations) >= 0 {
+			return fmt.Errorf("index rooted at %d, entry %d: key for rowid %d out of order", rootPage, entry, key.RowID)
+		}
+		previous = key
+
+		if err := cursor.Next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"encoding/binary"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIndexKeyRoundTrip(t *testing.T) {
+	keys := []IndexKey{
+		{Values: []any{"eritrea"}, RowID: 7},
+		{Values: []any{nil, int64(-1), 2.5, []byte{0, 1}}, RowID: -9},
+		{Values: []any{}, RowID: 1 << 40},
+	}
+
+	for _, key := range keys {
+		record := EncodeIndexKey(key)
+		decoded, err := DecodeIndexKey(record)
+		if err != nil {
+			t.Fatalf("decoding %+v: %v", key, err)
+		}
+		if !reflect.DeepEqual(decoded, key) {
+			t.Errorf("round trip mismatch:\n got %#v\nwant %#v", decoded, key)
+		}
+	}
+
+	if _, err := DecodeIndexKey(testRecord(t, "no rowid")); err == nil {
+		t.Fatal("expected an error for a record without a rowid")
+	}
+}
+
+func TestCompareIndexKeysAcrossTypes(t *testing.T) {
+	// Each key sorts strictly before the next
+	ordered := []IndexKey{
+		{Values: []any{nil}, RowID: 5},
+		{Values: []any{nil}, RowID: 6},
+		{Values: []any{-1e300}, RowID: 1},
+		{Values: []any{int64(-3)}, RowID: 1},
+		{Values: []any{-2.5}, RowID: 1},
+		{Values: []any{int64(1)}, RowID: 1},
+		{Values: []any{1.0}, RowID: 2},
+		{Values: []any{float64(1 << 53)}, RowID: 1},
+		// Rounding to float64 would make these two equal
+		{Values: []any{int64(1<<53 + 1)}, RowID: 1},
+		{Values: []any{1e19}, RowID: 1},
+		{Values: []any{""}, RowID: 1},
+		{Values: []any{"A"}, RowID: 1},
+		{Values: []any{"a"}, RowID: 1},
+		{Values: []any{"a", int64(1)}, RowID: 1},
+		{Values: []any{[]byte{}}, RowID: 1},
+		{Values: []any{[]byte{0}}, RowID: 1},
+	}
+
+	for i := range ordered {
+		for j := range ordered {
+			got := CompareIndexKeys(ordered[i], ordered[j], nil)
+			if (i < j && got >= 0) || (i > j && got <= 0) || (i == j && got != 0) {
+				t.Errorf("CompareIndexKeys(%v, %v) = %d", ordered[i], ordered[j], got)
+			}
+		}
+	}
+}
+
+func TestVerifyIndexOrderMatchesSQLite(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found in PATH")
+	}
+
+	script := `CREATE TABLE t (v);
+INSERT INTO t VALUES (NULL), (1), (1.0), (9007199254740993), (9007199254740992.0), (-0.5), (-7),
+	('a'), ('A'), (''), (x''), (x'00'), (1e300), (-1e300), (NULL), ('a');
+CREATE INDEX t_v ON t (v);`
+	path := filepath.Join(t.TempDir(), "mixed.db")
+	cmd := exec.Command(sqlite3, path)
+	cmd.Stdin = strings.NewReader(script)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("sqlite3: %v\n%s", err, output)
+	}
+
+	dbFile, header, err := OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbFile.Close()
+	// sqlite3 puts the table on page 2 and the index on page 3
+	if err := dbFile.VerifyIndexOrder(header, 3, nil); err != nil {
+		t.Fatalf("sqlite3's index order disagrees with ours: %v", err)
+	}
+}
+
+func TestVerifyIndexOrderReportsMisorderedKeys(t *testing.T) {
+	const pageSize = 512
+	keys := []IndexKey{{Values: []any{"b"}, RowID: 1}, {Values: []any{"a"}, RowID: 2}}
+
+	page := make([]byte, pageSize)
+	page[0] = byte(LeafIndex)
+	binary.BigEndian.PutUint16(page[3:5], uint16(len(keys)))
+	contentStart := pageSize
+	for i, key := range keys {
+		record := EncodeIndexKey(key)
+		cell := append(appendVarint(nil, uint64(len(record))), record...)
+		contentStart -= len(cell)
+		copy(page[contentStart:], cell)
+		binary.BigEndian.PutUint16(page[8+2*i:], uint16(contentStart))
+	}
+	binary.BigEndian.PutUint16(page[5:7], uint16(contentStart))
+
+	dbFile, header := writeTestDatabase(t, pageSize, page)
+	err := dbFile.VerifyIndexOrder(header, 2, nil)
+	if err == nil || !strings.Contains(err.Error(), "rowid 2 out of order") {
+		t.Fatalf("expected an ordering error for rowid 2, got %v", err)
+	}
+}
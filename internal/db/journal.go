@@ -0,0 +1,210 @@
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// journalMagic opens every rollback journal header.
+var journalMagic = []byte{0xd9, 0xd5, 0x05, 0xf9, 0x20, 0xa1, 0x63, 0xd7}
+
+// journalSectorSize is the size the journal header is padded to. SQLite
+// records the sector size it used, so any power of two of at least 512 works.
+const journalSectorSize = 512
+
+// journalPath is where the rollback journal for a database lives, alongside
+// it, so SQLite itself finds and replays it after a crash.
+func journalPath(databasePath string) string {
+	return databasePath + "-journal"
+}
+
+// journalChecksum is SQLite's rollback journal page checksum: the nonce plus
+// every 200th byte of the page, counting down from 200 bytes before its end.
+func journalChecksum(nonce uint32, data []byte) uint32 {
+	sum := nonce
+	for i := len(data) - 200; i > 0; i -= 200 {
+		sum += uint32(data[i])
+	}
+	return sum
+}
+
+// writeJournal saves the original contents of pages, along with the
+// database's size before the transaction, so the transaction can be undone
+// by replaying the journal. It is synced before returning.
+func writeJournal(path string, pageSize uint32, originalPageCount uint32, originals map[uint32][]byte) error {
+	var nonceBytes [4]byte
+	if _, err := rand.Read(nonceBytes[:]); err != nil {
+		return fmt.Errorf("journal nonce: %w", err)
+	}
+	nonce := binary.BigEndian.Uint32(nonceBytes[:])
+
+	header := make([]byte, journalSectorSize)
+	copy(header, journalMagic)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(originals)))
+	binary.BigEndian.PutUint32(header[12:16], nonce)
+	binary.BigEndian.PutUint32(header[16:20], originalPageCount)
+	binary.BigEndian.PutUint32(header[20:24], journalSectorSize)
+	binary.BigEndian.PutUint32(header[24:28], pageSize)
+
+	journal := bytes.NewBuffer(header)
+	pageNumbers := make([]uint32, 0, len(originals))
+	for pageNumber := range originals {
+		pageNumbers = append(pageNumbers, pageNumber)
+	}
+	slices.Sort(pageNumbers)
+	for _, pageNumber := range pageNumbers {
+		data := originals[pageNumber]
+		journal.Write(binary.BigEndian.AppendUint32(nil, pageNumber))
+		journal.Write(data)
+		journal.Write(binary.BigEndian.AppendUint32(nil, journalChecksum(nonce, data)))
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("create journal: %w", err)
+	}
+	if _, err := file.Write(journal.Bytes()); err != nil {
+		file.Close()
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	return file.Close()
+}
+
+// journalHeader is what a rollback journal's header records.
+type journalHeader struct {
+	nonce             uint32
+	originalPageCount uint32
+	pageSize          uint32
+}
+
+// journalRecords parses a leftover rollback journal: its header and the
+// page records after it, each a page number, the page's original contents
+// and their checksum. A count of all ones in the header means "as many
+// records as the file holds", and is returned as -1.
+func journalRecords(path string, journal []byte) (header journalHeader, records [][]byte, count int, err error) {
+	header = journalHeader{
+		nonce:             binary.BigEndian.Uint32(journal[12:16]),
+		originalPageCount: binary.BigEndian.Uint32(journal[16:20]),
+		pageSize:          binary.BigEndian.Uint32(journal[24:28]),
+	}
+	sectorSize := binary.BigEndian.Uint32(journal[20:24])
+	if sectorSize < 28 || header.pageSize < 512 || header.pageSize > 65536 || int64(sectorSize) > int64(len(journal)) {
+		return journalHeader{}, nil, 0, fmt.Errorf("journal %s: invalid header", path)
+	}
+
+	// The record count is read as a uint32, since 0xffffffff, which
+	// counts the records to the end of the file, does not fit an int on
+	// 32-bit targets
+	count = -1
+	if field := binary.BigEndian.Uint32(journal[8:12]); field != 0xffffffff {
+		count = int(field)
+	}
+	recordSize := int(header.pageSize) + 8
+	for rest := journal[sectorSize:]; len(rest) >= recordSize && (count < 0 || len(records) < count); rest = rest[recordSize:] {
+		records = append(records, rest[:recordSize])
+	}
+	return header, records, count, nil
+}
+
+// checkJournal fails unless every record a journal promises is there and
+// matches its checksum. Without that check, replay treats a bad record as
+// the end of the journal, as SQLite does, since one that was never fully
+// written looks the same; but once the header promises a count the
+// records were synced, so a bad one among them is corruption.
+func checkJournal(path string, header journalHeader, records [][]byte, count int) error {
+	for i, record := range records {
+		data := record[4 : 4+header.pageSize]
+		if journalChecksum(header.nonce, data) != binary.BigEndian.Uint32(record[4+header.pageSize:]) && count >= 0 {
+			return fmt.Errorf("journal %s: record %d, page %d: checksum mismatch", path, i+1, binary.BigEndian.Uint32(record[0:4]))
+		}
+	}
+	if count > len(records) {
+		return fmt.Errorf("journal %s: %d of %d records missing", path, count-len(records), count)
+	}
+	return nil
+}
+
+// VerifyJournal checks a leftover rollback journal of the database at
+// databasePath, as RecoverJournal does with VerifyChecksums set, without
+// replaying it, so a journal can be checked before opening the database
+// replays it. It does nothing when there is no journal to replay.
+func VerifyJournal(databasePath string) error {
+	path := journalPath(databasePath)
+	journal, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read journal: %w", err)
+	}
+	if len(journal) < 28 || !bytes.Equal(journal[:8], journalMagic) {
+		return nil
+	}
+	header, records, count, err := journalRecords(path, journal)
+	if err != nil {
+		return err
+	}
+	return checkJournal(path, header, records, count)
+}
+
+// RecoverJournal rolls back an interrupted transaction by copying the pages
+// saved in a leftover rollback journal back into the database and
+// truncating it to its original size, then deletes the journal. It does
+// nothing when there is no journal, or when the journal was never completed
+// (in which case the database itself was not yet modified). With
+// VerifyChecksums set, a journal whose records fail their checksums is
+// left as it is, and nothing is replayed.
+func (databaseFile *DatabaseFile) RecoverJournal() error {
+	path := journalPath(databaseFile.Name())
+	journal, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read journal: %w", err)
+	}
+
+	if len(journal) < 28 || !bytes.Equal(journal[:8], journalMagic) {
+		// A zeroed or truncated header means the transaction committed
+		return os.Remove(path)
+	}
+	header, records, count, err := journalRecords(path, journal)
+	if err != nil {
+		return err
+	}
+	if databaseFile.VerifyChecksums {
+		if err := checkJournal(path, header, records, count); err != nil {
+			return err
+		}
+	}
+
+	pageSize := header.pageSize
+	for _, record := range records {
+		pageNumber := binary.BigEndian.Uint32(record[0:4])
+		data := record[4 : 4+pageSize]
+		// A bad checksum marks a record that was never fully written
+		if journalChecksum(header.nonce, data) != binary.BigEndian.Uint32(record[4+pageSize:]) {
+			break
+		}
+		if _, err := databaseFile.WriteAt(data, int64(pageNumber-1)*int64(pageSize)); err != nil {
+			return fmt.Errorf("restore page %d: %w", pageNumber, err)
+		}
+	}
+
+	if err := databaseFile.Truncate(int64(header.originalPageCount) * int64(pageSize)); err != nil {
+		return fmt.Errorf("truncate database: %w", err)
+	}
+	if err := databaseFile.Sync(); err != nil {
+		return fmt.Errorf("sync database: %w", err)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func writableTestDatabase(t *testing.T) (string, *DatabaseFile, *DatabaseHeader) {
+	t.Helper()
+
+	database := testgen.New(testgen.Options{PageSize: 1024})
+	items := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text)")
+	for i := int64(1); i <= 100; i++ {
+		items.Insert(i, nil, "item")
+	}
+	path := database.WriteTemp(t)
+
+	dbFile, header, err := OpenWritableDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbFile.Close() })
+	return path, dbFile, header
+}
+
+func TestCommitUpdatesHeaderAndRemovesJournal(t *testing.T) {
+	path, dbFile, header := writableTestDatabase(t)
+	pageCount, changeCounter := header.PageCount, header.ChangeCounter
+
+	pager := NewPager(dbFile, header)
+	pageNumber, err := pager.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.Free(pageNumber); err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	first := make([]byte, databaseHeaderBytes)
+	if _, err := dbFile.ReadAt(first, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.BigEndian.Uint32(first[24:28]); got != changeCounter+1 {
+		t.Errorf("change counter %d, want %d", got, changeCounter+1)
+	}
+	if got := binary.BigEndian.Uint32(first[92:96]); got != changeCounter+1 {
+		t.Errorf("version-valid-for %d, want %d", got, changeCounter+1)
+	}
+	if got := binary.BigEndian.Uint32(first[28:32]); got != pageCount+1 {
+		t.Errorf("page count %d, want %d", got, pageCount+1)
+	}
+	if got := binary.BigEndian.Uint32(first[96:100]); got != writeLibraryVersion {
+		t.Errorf("write library version %d, want %d", got, writeLibraryVersion)
+	}
+	if _, err := os.Stat(journalPath(path)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("journal left behind after commit: %v", err)
+	}
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		if got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; PRAGMA freelist_count"); got != "ok\n1" {
+			t.Fatalf("sqlite3 after commit: %q", got)
+		}
+	}
+}
+
+func TestRollbackRestoresHeader(t *testing.T) {
+	_, dbFile, header := writableTestDatabase(t)
+	before := *header
+
+	pager := NewPager(dbFile, header)
+	for range 3 {
+		if _, err := pager.Allocate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pager.Rollback()
+
+	if *header != before {
+		t.Fatalf("header after rollback: %+v, want %+v", *header, before)
+	}
+	if err := pager.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := dbFile.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(before.PageCount)*int64(before.PageSize) {
+		t.Fatalf("rolled back pages reached the file: size %d", info.Size())
+	}
+}
+
+// interruptedCommit journals page 2 and the file's size, then scribbles over
+// page 2 and grows the file, as a commit that crashed partway would.
+func interruptedCommit(t *testing.T, dbFile *DatabaseFile, header *DatabaseHeader) []byte {
+	t.Helper()
+
+	original, err := dbFile.readRawPage(header, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJournal(journalPath(dbFile.Name()), header.PageSize, header.PageCount, map[uint32][]byte{2: original}); err != nil {
+		t.Fatal(err)
+	}
+
+	garbage := bytes.Repeat([]byte{0xee}, int(header.PageSize))
+	if _, err := dbFile.WriteAt(garbage, int64(header.PageSize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbFile.WriteAt(garbage, int64(header.PageCount)*int64(header.PageSize)); err != nil {
+		t.Fatal(err)
+	}
+	return original
+}
+
+func TestOpenWritableReplaysHotJournal(t *testing.T) {
+	path, dbFile, header := writableTestDatabase(t)
+	original := interruptedCommit(t, dbFile, header)
+	dbFile.Close()
+
+	reopened, reread, err := OpenWritableDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	restored, err := reopened.readRawPage(reread, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, original) {
+		t.Fatal("page 2 not restored from the journal")
+	}
+	if reread.PageCount != header.PageCount {
+		t.Fatalf("page count %d after recovery, want %d", reread.PageCount, header.PageCount)
+	}
+	if _, err := os.Stat(journalPath(path)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("journal not deleted after recovery: %v", err)
+	}
+}
+
+// TestSQLite3ReplaysOurJournal checks the journal format by letting sqlite3
+// itself roll back an interrupted commit.
+func TestSQLite3ReplaysOurJournal(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found in PATH")
+	}
+
+	path, dbFile, header := writableTestDatabase(t)
+	interruptedCommit(t, dbFile, header)
+
+	if got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; SELECT count(*) FROM items"); got != "ok\n100" {
+		t.Fatalf("sqlite3 after replaying our journal: %q", got)
+	}
+	if _, err := os.Stat(journalPath(path)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("sqlite3 did not consume the journal: %v", err)
+	}
+}
+
+func TestVerifyChecksumsRejectsCorruptJournal(t *testing.T) {
+	path, dbFile, header := writableTestDatabase(t)
+	interruptedCommit(t, dbFile, header)
+	if err := VerifyJournal(path); err != nil {
+		t.Fatalf("VerifyJournal of a sound journal: %v", err)
+	}
+
+	// Flip a bit of the one record's checksum, after its page number and page
+	journal, err := os.ReadFile(journalPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal[journalSectorSize+4+int(header.PageSize)] ^= 1
+	if err := os.WriteFile(journalPath(path), journal, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "journal " + journalPath(path) + ": record 1, page 2: checksum mismatch"
+	if err := VerifyJournal(path); err == nil || err.Error() != want {
+		t.Errorf("VerifyJournal: %v, want %s", err, want)
+	}
+	dbFile.VerifyChecksums = true
+	if err := dbFile.RecoverJournal(); err == nil || err.Error() != want {
+		t.Errorf("RecoverJournal: %v, want %s", err, want)
+	}
+	page, err := dbFile.readRawPage(header, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page[0] != 0xee {
+		t.Error("page 2 replayed from a corrupt journal")
+	}
+	if _, err := os.Stat(journalPath(path)); err != nil {
+		t.Errorf("corrupt journal not left in place: %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrBusy is returned when another process holds a lock that conflicts
+// with the one needed, and waiting for it is not possible or ran past the
+// busy timeout.
+var ErrBusy = errors.New("database is locked")
+
+// SQLite's locks are POSIX advisory locks on bytes of the database file
+// starting at the 1 GiB offset, which is why that page is never used. A
+// reader holds a read lock somewhere in the shared range; a writer takes
+// the reserved byte while it prepares its changes, then the pending byte,
+// which keeps new readers out, and finally the whole shared range.
+const (
+	pendingByte  = 0x40000000
+	reservedByte = pendingByte + 1
+	sharedFirst  = pendingByte + 2
+	sharedSize   = 510
+)
+
+// lockLevel is how far this handle has locked the database file.
+type lockLevel int
+
+const (
+	unlocked lockLevel = iota
+	sharedLock
+	reservedLock
+	exclusiveLock
+)
+
+// busyDelays is SQLite's default busy handler schedule, the wait before
+// each retry; later retries repeat the last delay.
+var busyDelays = []time.Duration{1, 2, 5, 10, 15, 20, 25, 25, 25, 50, 50, 100}
+
+// BusyWait is the busy handler: it sleeps before the retry-th retry of an
+// operation that failed with ErrBusy and reports true, or reports false
+// without sleeping once the total wait would pass BusyTimeout.
+func (databaseFile *DatabaseFile) BusyWait(retry int) bool {
+	var waited time.Duration
+	for i := range retry {
+		waited += busyDelays[min(i, len(busyDelays)-1)] * time.Millisecond
+	}
+	delay := busyDelays[min(retry, len(busyDelays)-1)] * time.Millisecond
+	if remaining := databaseFile.BusyTimeout - waited; delay > remaining {
+		if remaining <= 0 {
+			return false
+		}
+		delay = remaining
+	}
+	time.Sleep(delay)
+	return true
+}
+
+// LockShared takes a shared lock for reading, unless this handle already
+// holds one; each call must be matched by UnlockShared. It fails with
+// ErrBusy, without waiting, while another process is committing.
+//
+// A rollback journal with no writer holding the reserved lock was left by
+// a crash, and is replayed first when the file is writable, as SQLite does
+// before reading a database with a hot journal.
+func (databaseFile *DatabaseFile) LockShared() error {
+	if databaseFile.readers > 0 {
+		databaseFile.readers++
+		return nil
+	}
+	if err := databaseFile.lockShared(); err != nil {
+		return err
+	}
+	databaseFile.readers++
+
+	if !databaseFile.writable || databaseFile.wal != nil || databaseFile.InMemory() {
+		return nil
+	}
+	if _, err := os.Stat(journalPath(databaseFile.Name())); err != nil {
+		return nil
+	}
+	if held, err := databaseFile.lockHeldElsewhere(reservedByte, 1); err != nil || held {
+		return err
+	}
+	if err := databaseFile.lockExclusive(); err != nil {
+		databaseFile.UnlockShared()
+		return err
+	}
+	databaseFile.debug("replaying hot journal", "journal", journalPath(databaseFile.Name()))
+	err := databaseFile.RecoverJournal()
+	databaseFile.downgrade()
+	if err != nil {
+		databaseFile.UnlockShared()
+	}
+	return err
+}
+
+// UnlockShared releases a shared lock taken by LockShared, unlocking the
+// file when the last one is released.
+func (databaseFile *DatabaseFile) UnlockShared() {
+	if databaseFile.readers == 0 {
+		return
+	}
+	databaseFile.readers--
+	if databaseFile.readers == 0 && databaseFile.level == sharedLock {
+		databaseFile.unlock()
+	}
+}
+
+// lockable returns the file the database's locks are taken on, which an
+// in-memory database does not have: no other process can reach it.
+func (databaseFile *DatabaseFile) lockable() (*os.File, bool) {
+	switch storage := databaseFile.storage.(type) {
+	case *os.File:
+		return storage, true
+	case *codecStorage:
+		return storage.File, true
+	}
+	return nil, false
+}
+
+// setLock locks or unlocks bytes of the database file.
+func (databaseFile *DatabaseFile) setLock(kind int16, start, length int64) error {
+	file, ok := databaseFile.lockable()
+	if !ok {
+		return nil
+	}
+	return setLock(file, kind, start, length)
+}
+
+// lockHeldElsewhere reports whether another process holds a lock on any of
+// the bytes.
+func (databaseFile *DatabaseFile) lockHeldElsewhere(start, length int64) (bool, error) {
+	file, ok := databaseFile.lockable()
+	if !ok {
+		return false, nil
+	}
+	return lockHeldElsewhere(file, start, length)
+}
+
+// lockShared takes a read lock on the shared range, first passing through
+// the pending byte so a writer waiting for readers to finish is not kept
+// waiting by new ones.
+func (databaseFile *DatabaseFile) lockShared() error {
+	if databaseFile.level >= sharedLock {
+		return nil
+	}
+	if err := databaseFile.setLock(readLock, pendingByte, 1); err != nil {
+		return err
+	}
+	err := databaseFile.setLock(readLock, sharedFirst, sharedSize)
+	databaseFile.setLock(unlockLock, pendingByte, 1)
+	if err != nil {
+		return err
+	}
+	databaseFile.level = sharedLock
+	return nil
+}
+
+// lockReserved takes the reserved lock, which only one process can hold.
+// It fails at once if another has it: that process may be waiting for this
+// one's shared lock to go, so waiting here could deadlock.
+func (databaseFile *DatabaseFile) lockReserved() error {
+	if databaseFile.level >= reservedLock {
+		return nil
+	}
+	if err := databaseFile.setLock(writeLock, reservedByte, 1); err != nil {
+		return err
+	}
+	databaseFile.level = reservedLock
+	return nil
+}
+
+// lockExclusive takes the reserved and pending locks, then waits for the
+// other readers to finish, for up to the busy timeout, before locking the
+// shared range for writing. The caller must hold a shared lock.
+func (databaseFile *DatabaseFile) lockExclusive() error {
+	if err := databaseFile.lockReserved(); err != nil {
+		return err
+	}
+	for retry := 0; ; retry++ {
+		err := databaseFile.setLock(writeLock, pendingByte, 1)
+		if err == nil {
+			err = databaseFile.setLock(writeLock, sharedFirst, sharedSize)
+		}
+		if err == nil {
+			databaseFile.level = exclusiveLock
+			return nil
+		}
+		if !errors.Is(err, ErrBusy) || !databaseFile.BusyWait(retry) {
+			databaseFile.downgrade()
+			return err
+		}
+	}
+}
+
+// downgrade returns from a write lock to a shared one.
+func (databaseFile *DatabaseFile) downgrade() {
+	if databaseFile.level <= sharedLock {
+		return
+	}
+	databaseFile.setLock(readLock, sharedFirst, sharedSize)
+	databaseFile.setLock(unlockLock, pendingByte, 2)
+	databaseFile.level = sharedLock
+}
+
+// unlock releases every lock this handle holds.
+func (databaseFile *DatabaseFile) unlock() {
+	if databaseFile.level == unlocked {
+		return
+	}
+	databaseFile.setLock(unlockLock, pendingByte, sharedFirst+sharedSize-pendingByte)
+	databaseFile.level = unlocked
+}
+
+// lockForCommit takes the exclusive lock a commit to the database file
+// needs, taking a shared lock first when the caller holds none. The
+// returned function returns to the previous level.
+func (databaseFile *DatabaseFile) lockForCommit() (func(), error) {
+	if err := databaseFile.LockShared(); err != nil {
+		return nil, err
+	}
+	if err := databaseFile.lockExclusive(); err != nil {
+		databaseFile.UnlockShared()
+		return nil, err
+	}
+	return func() {
+		databaseFile.downgrade()
+		databaseFile.UnlockShared()
+	}, nil
+}
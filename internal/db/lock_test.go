@@ -0,0 +1,19 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusyWaitStopsAtTimeout(t *testing.T) {
+	databaseFile := &DatabaseFile{BusyTimeout: 3 * time.Millisecond}
+	// The first two retries wait 1ms and 2ms, which uses up the timeout
+	for retry, want := range []bool{true, true, false} {
+		if got := databaseFile.BusyWait(retry); got != want {
+			t.Fatalf("BusyWait(%d) = %v, want %v", retry, got, want)
+		}
+	}
+	if (&DatabaseFile{}).BusyWait(0) {
+		t.Fatal("BusyWait waited without a timeout")
+	}
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package db
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+const (
+	readLock   = syscall.F_RDLCK
+	writeLock  = syscall.F_WRLCK
+	unlockLock = syscall.F_UNLCK
+)
+
+// setLock changes this process's advisory lock on a byte range of file,
+// failing with ErrBusy if another process holds a conflicting one.
+func setLock(file *os.File, kind int16, start, length int64) error {
+	lock := syscall.Flock_t{Type: kind, Whence: io.SeekStart, Start: start, Len: length}
+	err := syscall.FcntlFlock(file.Fd(), syscall.F_SETLK, &lock)
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES) {
+		return ErrBusy
+	}
+	return err
+}
+
+// lockHeldElsewhere reports whether another process holds a write lock on
+// any of a byte range of file.
+func lockHeldElsewhere(file *os.File, start, length int64) (bool, error) {
+	lock := syscall.Flock_t{Type: writeLock, Whence: io.SeekStart, Start: start, Len: length}
+	if err := syscall.FcntlFlock(file.Fd(), syscall.F_GETLK, &lock); err != nil {
+		return false, err
+	}
+	return lock.Type != unlockLock, nil
+}
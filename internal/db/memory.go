@@ -0,0 +1,154 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// MemoryPath is the path that names a database held in memory rather than
+// in a file, as in SQLite.
+const MemoryPath = ":memory:"
+
+// storage is what a DatabaseFile reads and writes: an *os.File, or the
+// pages of an in-memory database.
+type storage interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+	Sync() error
+	Stat() (fs.FileInfo, error)
+	Name() string
+	Close() error
+}
+
+// memoryFile holds a database's bytes in memory. It has no locks, journal or
+// write-ahead log: only the handle that created it can reach it, and its
+// contents go when it is closed.
+type memoryFile struct {
+	data []byte
+}
+
+func (file *memoryFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, file.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (file *memoryFile) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(file.data)) {
+		file.data = append(file.data, make([]byte, end-int64(len(file.data)))...)
+	}
+	return copy(file.data[off:], p), nil
+}
+
+func (file *memoryFile) Truncate(size int64) error {
+	if size < 0 {
+		return fmt.Errorf("truncate: negative size %d", size)
+	}
+	if size <= int64(len(file.data)) {
+		file.data = file.data[:size]
+		return nil
+	}
+	file.data = append(file.data, make([]byte, size-int64(len(file.data)))...)
+	return nil
+}
+
+func (file *memoryFile) Sync() error {
+	return nil
+}
+
+func (file *memoryFile) Stat() (fs.FileInfo, error) {
+	return memoryFileInfo{size: int64(len(file.data))}, nil
+}
+
+func (file *memoryFile) Name() string {
+	return MemoryPath
+}
+
+func (file *memoryFile) Close() error {
+	file.data = nil
+	return nil
+}
+
+// memoryFileInfo describes a memoryFile, for the size Stat reports.
+type memoryFileInfo struct {
+	size int64
+}
+
+func (info memoryFileInfo) Name() string       { return MemoryPath }
+func (info memoryFileInfo) Size() int64        { return info.size }
+func (info memoryFileInfo) Mode() fs.FileMode  { return 0o600 }
+func (info memoryFileInfo) ModTime() time.Time { return time.Time{} }
+func (info memoryFileInfo) IsDir() bool        { return false }
+func (info memoryFileInfo) Sys() any           { return nil }
+
+// CreateMemoryDatabase opens a new, empty database held in memory, with
+// pages of the given size, for reading and writing.
+func CreateMemoryDatabase(pageSize int) (*DatabaseFile, *DatabaseHeader, error) {
+	contents, err := emptyDatabase(pageSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return OpenMemoryDatabase(contents)
+}
+
+// OpenMemoryDatabase opens a copy of the database image contents in memory,
+// for reading and writing; the image itself is never changed. Commits write
+// pages directly, since there is no file for a crash to leave half written.
+func OpenMemoryDatabase(contents []byte) (*DatabaseFile, *DatabaseHeader, error) {
+	dbFile := &DatabaseFile{storage: &memoryFile{data: append([]byte(nil), contents...)}, writable: true}
+	if isWALMode(contents) {
+		return nil, nil, fmt.Errorf("open database: in-memory databases cannot be in WAL mode")
+	}
+	header, err := dbFile.NewDatabaseHeader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read database header: %w", err)
+	}
+	return dbFile, header, nil
+}
+
+// Serialize returns a copy of the database's pages as of the last commit.
+// The copy's file format versions say it has no log, so that it opens on
+// its own.
+func (databaseFile *DatabaseFile) Serialize(header *DatabaseHeader) ([]byte, error) {
+	contents, err := databaseFile.ReadPages(header, 1, header.PageCount)
+	if err != nil {
+		return nil, fmt.Errorf("serialize: %w", err)
+	}
+	DetachLog(contents)
+	return contents, nil
+}
+
+// ReadPages returns the bytes of count pages from first on as of the last
+// commit, read through the write-ahead log in WAL mode.
+func (databaseFile *DatabaseFile) ReadPages(header *DatabaseHeader, first, count uint32) ([]byte, error) {
+	if first == 0 || count == 0 || first+count-1 > header.PageCount {
+		return nil, fmt.Errorf("pages %d to %d out of range: database has %d", first, first+count-1, header.PageCount)
+	}
+	pageSize := int64(header.PageSize)
+	contents := make([]byte, int64(count)*pageSize)
+	if _, err := databaseFile.readCommitted(contents, int64(first-1)*pageSize); err != nil {
+		return nil, fmt.Errorf("pages %d to %d: %w", first, first+count-1, err)
+	}
+	return contents, nil
+}
+
+// DetachLog sets the file format versions in a copy of page 1 to those of
+// rollback journal mode, since a copy has no write-ahead log of its own.
+func DetachLog(page []byte) {
+	page[18], page[19] = 1, 1
+}
+
+// InMemory reports whether the database is held in memory rather than in a
+// file.
+func (databaseFile *DatabaseFile) InMemory() bool {
+	_, ok := databaseFile.storage.(*memoryFile)
+	return ok
+}
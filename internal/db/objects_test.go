@@ -1,8 +1,15 @@
 package db
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -27,7 +34,7 @@ func openSampleDatabase(t *testing.T) (*DatabaseFile, *DatabaseHeader) {
 		}
 	})
 
-	dbFile := &DatabaseFile{File: file}
+	dbFile := &DatabaseFile{storage: file}
 	header, err := dbFile.NewDatabaseHeader()
 	if err != nil {
 		t.Fatalf("reading database header: %v", err)
@@ -100,7 +107,7 @@ func TestReadAllRowsFromSampleApples(t *testing.T) {
 	expectedColors := []string{"Light Green", "Red", "Blush Red", "Yellow"}
 
 	for i, row := range rows {
-		if row.RowID != uint64(i+1) {
+		if row.RowID != int64(i+1) {
 			t.Fatalf("row %d unexpected rowid: got %d, want %d", i, row.RowID, i+1)
 		}
 
@@ -113,3 +120,423 @@ func TestReadAllRowsFromSampleApples(t *testing.T) {
 		}
 	}
 }
+
+// leafTablePage builds a single leaf table page holding one-column integer
+// records keyed by the given rowids.
+func leafTablePage(t *testing.T, pageSize int, rowIDs ...int64) *Page {
+	t.Helper()
+
+	data := make([]byte, pageSize)
+	data[0] = byte(LeafTable)
+	binary.BigEndian.PutUint16(data[3:5], uint16(len(rowIDs)))
+
+	addresses := make([]uint16, 0, len(rowIDs))
+	contentStart := pageSize
+	for i, rowID := range rowIDs {
+		record := []byte{2, 1, byte(i)}
+		cell := appendVarint(nil, uint64(len(record)))
+		cell = appendVarint(cell, uint64(rowID))
+		cell = append(cell, record...)
+
+		contentStart -= len(cell)
+		if contentStart < 8+2*len(rowIDs) {
+			t.Fatalf("cells do not fit in %d byte page", pageSize)
+		}
+		copy(data[contentStart:], cell)
+		binary.BigEndian.PutUint16(data[8+2*i:], uint16(contentStart))
+		addresses = append(addresses, uint16(contentStart))
+	}
+	binary.BigEndian.PutUint16(data[5:7], uint16(contentStart))
+
+	return &Page{
+		PageType:         LeafTable,
+		CellCount:        uint16(len(rowIDs)),
+		CellContentStart: contentStart,
+		CellAddresses:    addresses,
+		CellPointersEnd:  8 + 2*len(rowIDs),
+		UsableSize:       pageSize,
+		Data:             data,
+	}
+}
+
+func TestReadVarintRoundTrip(t *testing.T) {
+	values := []uint64{0, 1, 0x7f, 0x80, 0x3fff, 0x4000, 1 << 32, 1<<56 - 1, 1 << 56, math.MaxUint64}
+
+	for _, value := range values {
+		encoded := appendVarint(nil, value)
+		got, n, err := ReadVarint(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatalf("value %d: reading varint: %v", value, err)
+		}
+		if got != value {
+			t.Fatalf("value %d: unexpected decoded value %d", value, got)
+		}
+		if n != len(encoded) {
+			t.Fatalf("value %d: unexpected byte count: got %d, want %d", value, n, len(encoded))
+		}
+	}
+}
+
+func TestReadRowSignedRowIDs(t *testing.T) {
+	rowIDs := []int64{1 << 32, math.MaxInt64, -1, math.MinInt64}
+	page := leafTablePage(t, 512, rowIDs...)
+
+	rows, err := ReadAllRows(page)
+	if err != nil {
+		t.Fatalf("reading rows: %v", err)
+	}
+
+	for i, row := range rows {
+		if row.RowID != rowIDs[i] {
+			t.Fatalf("row %d unexpected rowid: got %d, want %d", i, row.RowID, rowIDs[i])
+		}
+		if value := row.Columns[0].DecodedValue.(int64); value != int64(i) {
+			t.Fatalf("row %d unexpected value: got %d, want %d", i, value, i)
+		}
+	}
+}
+
+// testRecord encodes int64 and string values as a record payload.
+func testRecord(t *testing.T, values ...any) []byte {
+	t.Helper()
+
+	var header, body []byte
+	for _, value := range values {
+		switch value := value.(type) {
+		case int64:
+			header = appendVarint(header, 6)
+			body = binary.BigEndian.AppendUint64(body, uint64(value))
+		case string:
+			header = appendVarint(header, uint64(len(value))*2+13)
+			body = append(body, value...)
+		default:
+			t.Fatalf("unsupported test record value %T", value)
+		}
+	}
+
+	// Header sizes in tests stay well below 127 bytes, so the size varint is one byte
+	record := append([]byte{byte(len(header) + 1)}, header...)
+	return append(record, body...)
+}
+
+// writeTestDatabase writes pages (numbered from 2) after a minimal page 1
+// header into a temporary file and opens it.
+func writeTestDatabase(t *testing.T, pageSize int, pages ...[]byte) (*DatabaseFile, *DatabaseHeader) {
+	t.Helper()
+
+	first := make([]byte, pageSize)
+	copy(first, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(first[16:18], uint16(pageSize))
+	binary.BigEndian.PutUint32(first[28:32], uint32(len(pages)+1))
+	first[databaseHeaderBytes] = byte(LeafTable)
+
+	contents := first
+	for _, page := range pages {
+		contents = append(contents, page...)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	if err := os.WriteFile(path, contents, 0o644); err != nil {
+		t.Fatalf("writing test database: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	dbFile := &DatabaseFile{storage: file}
+	header, err := dbFile.NewDatabaseHeader()
+	if err != nil {
+		t.Fatalf("reading database header: %v", err)
+	}
+	return dbFile, header
+}
+
+func TestNewPageParsesFreeblocks(t *testing.T) {
+	const pageSize = 512
+
+	page := make([]byte, pageSize)
+	page[0] = byte(LeafTable)
+	binary.BigEndian.PutUint16(page[1:3], 400)
+	binary.BigEndian.PutUint16(page[3:5], 1)
+	binary.BigEndian.PutUint16(page[5:7], 300)
+	page[7] = 3
+	binary.BigEndian.PutUint16(page[8:10], 500)
+	copy(page[500:], []byte{3, 1, 2, 1, 5})
+
+	// Freeblock chain: 400 (20 bytes) -> 450 (6 bytes)
+	binary.BigEndian.PutUint16(page[400:402], 450)
+	binary.BigEndian.PutUint16(page[402:404], 20)
+	binary.BigEndian.PutUint16(page[452:454], 6)
+
+	dbFile, header := writeTestDatabase(t, pageSize, page)
+	parsed, err := dbFile.NewPage(header, 2)
+	if err != nil {
+		t.Fatalf("reading page: %v", err)
+	}
+
+	expected := []Freeblock{{Offset: 400, Size: 20}, {Offset: 450, Size: 6}}
+	if len(parsed.Freeblocks) != len(expected) {
+		t.Fatalf("unexpected freeblocks: got %v, want %v", parsed.Freeblocks, expected)
+	}
+	for i := range expected {
+		if parsed.Freeblocks[i] != expected[i] {
+			t.Fatalf("unexpected freeblock %d: got %v, want %v", i, parsed.Freeblocks[i], expected[i])
+		}
+	}
+
+	// Unallocated gap (300 - 10) plus freeblocks plus fragmented bytes
+	const expectedFree = 290 + 26 + 3
+	if free := parsed.FreeBytes(); free != expectedFree {
+		t.Fatalf("unexpected free bytes: got %d, want %d", free, expectedFree)
+	}
+}
+
+func TestNewPageRejectsUnsortedFreeblocks(t *testing.T) {
+	const pageSize = 512
+
+	page := make([]byte, pageSize)
+	page[0] = byte(LeafTable)
+	binary.BigEndian.PutUint16(page[1:3], 400)
+	binary.BigEndian.PutUint16(page[5:7], 300)
+	binary.BigEndian.PutUint16(page[400:402], 350)
+	binary.BigEndian.PutUint16(page[402:404], 8)
+
+	dbFile, header := writeTestDatabase(t, pageSize, page)
+	if _, err := dbFile.NewPage(header, 2); err == nil {
+		t.Fatalf("expected error for freeblock chain pointing backwards")
+	}
+}
+
+func TestNewPageRejectsCellPointerOutsideContentArea(t *testing.T) {
+	const pageSize = 512
+
+	page := make([]byte, pageSize)
+	page[0] = byte(LeafTable)
+	binary.BigEndian.PutUint16(page[3:5], 1)
+	binary.BigEndian.PutUint16(page[5:7], 400)
+	binary.BigEndian.PutUint16(page[8:10], 20)
+
+	dbFile, header := writeTestDatabase(t, pageSize, page)
+	_, err := dbFile.NewPage(header, 2)
+
+	var corruptionErr *CorruptionError
+	if !errors.As(err, &corruptionErr) {
+		t.Fatalf("expected CorruptionError, got %v", err)
+	}
+	if corruptionErr.Page != 2 || corruptionErr.Cell != 0 {
+		t.Fatalf("unexpected corruption context: page %d, cell %d", corruptionErr.Page, corruptionErr.Cell)
+	}
+}
+
+func TestReadRowRejectsHeaderLongerThanRecord(t *testing.T) {
+	page := leafTablePage(t, 512, 1, 2)
+
+	// Give the second cell, stored just before the first, an 8-byte
+	// integer where its record holds one byte, so that reading it would run
+	// into the first cell
+	page.Data[page.CellAddresses[1]+3] = 6
+
+	_, err := ReadRow(page, 1)
+
+	var corruptionErr *CorruptionError
+	if !errors.As(err, &corruptionErr) {
+		t.Fatalf("expected CorruptionError, got %v", err)
+	}
+	if corruptionErr.Cell != 1 || !strings.Contains(corruptionErr.Reason, "column 0 needs 8 bytes") {
+		t.Fatalf("unexpected corruption: %v", err)
+	}
+	if row, err := ReadRow(page, 0); err != nil || row.RowID != 1 {
+		t.Fatalf("reading the intact cell: %+v, %v", row, err)
+	}
+}
+
+func TestReadRowRejectsHeaderLargerThanRecord(t *testing.T) {
+	page := leafTablePage(t, 512, 1)
+
+	// The header claims 3 bytes of a 3 byte record, leaving nothing for
+	// the integer its serial type describes
+	page.Data[page.CellAddresses[0]+2] = 3
+
+	var corruptionErr *CorruptionError
+	if _, err := ReadRow(page, 0); !errors.As(err, &corruptionErr) {
+		t.Fatalf("expected CorruptionError, got %v", err)
+	}
+}
+
+func TestReadRowRejectsRecordPastPageEnd(t *testing.T) {
+	page := leafTablePage(t, 512, 1, 2)
+
+	// Claim the last cell's record runs past the end of the page
+	page.Data[page.CellAddresses[0]] = 100
+
+	_, err := ReadRow(page, 0)
+
+	var corruptionErr *CorruptionError
+	if !errors.As(err, &corruptionErr) {
+		t.Fatalf("expected CorruptionError, got %v", err)
+	}
+	if corruptionErr.Cell != 0 {
+		t.Fatalf("unexpected corruption cell: got %d, want 0", corruptionErr.Cell)
+	}
+}
+
+func TestCellDataStopsAtCellEndOnFragmentedPage(t *testing.T) {
+	const pageSize = 512
+
+	// Two cells stored out of order, the first pointer to the last cell on
+	// the page, with a fragment and a freeblock of garbage between them
+	textCell := func(rowID byte, text string) []byte {
+		return append([]byte{byte(2 + len(text)), rowID, 2, byte(13 + 2*len(text))}, text...)
+	}
+	page := make([]byte, pageSize)
+	page[0] = byte(LeafTable)
+	binary.BigEndian.PutUint16(page[1:3], 211)
+	binary.BigEndian.PutUint16(page[3:5], 2)
+	binary.BigEndian.PutUint16(page[5:7], 200)
+	page[7] = 2
+	binary.BigEndian.PutUint16(page[8:10], 503)
+	binary.BigEndian.PutUint16(page[10:12], 200)
+	copy(page[503:], textCell(1, "alpha"))
+	copy(page[200:], textCell(2, "bravo"))
+	for i := 209; i < 503; i++ {
+		page[i] = 0xff
+	}
+	binary.BigEndian.PutUint16(page[211:213], 0)
+	binary.BigEndian.PutUint16(page[213:215], 503-211)
+
+	dbFile, header := writeTestDatabase(t, pageSize, page)
+	parsed, err := dbFile.NewPage(header, 2)
+	if err != nil {
+		t.Fatalf("reading fragmented page: %v", err)
+	}
+	if len(parsed.Freeblocks) != 1 || parsed.FragmentedBytes != 2 {
+		t.Fatalf("unexpected free space: freeblocks %v, %d fragmented bytes", parsed.Freeblocks, parsed.FragmentedBytes)
+	}
+
+	for i, want := range []string{"alpha", "bravo"} {
+		cellData, err := CellData(parsed, i)
+		if err != nil {
+			t.Fatalf("cell %d: %v", i, err)
+		}
+		if len(cellData) != 9 {
+			t.Fatalf("cell %d: got %d bytes, want 9", i, len(cellData))
+		}
+		row, err := ReadRow(parsed, i)
+		if err != nil {
+			t.Fatalf("cell %d: reading row: %v", i, err)
+		}
+		if row.RowID != int64(i+1) || row.Columns[0].DecodedValue != want {
+			t.Fatalf("cell %d: got rowid %d value %v, want %d %q", i, row.RowID, row.Columns[0].DecodedValue, i+1, want)
+		}
+	}
+}
+
+func TestCellDataOnSQLiteFragmentedPage(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found in PATH")
+	}
+
+	// Deleting rows leaves freeblocks between the survivors, and growing
+	// some of them moves their cells into the gap at the top of the page
+	path := filepath.Join(t.TempDir(), "fragmented.db")
+	sqlite3Output(t, sqlite3, path, `PRAGMA secure_delete = off;
+		CREATE TABLE t (id integer primary key, v text);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 40)
+		INSERT INTO t SELECT i, printf('%0*d', i % 7 + 1, i) FROM n;
+		DELETE FROM t WHERE id % 3 = 0;
+		UPDATE t SET v = v || '-grown-row' WHERE id % 5 = 0;`)
+	want := sqlite3Output(t, sqlite3, path, "SELECT id || '|' || v FROM t ORDER BY id")
+
+	dbFile, header, err := OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatalf("opening fixture: %v", err)
+	}
+	defer dbFile.Close()
+	objects, err := dbFile.ReadSchema(header)
+	if err != nil {
+		t.Fatalf("reading schema: %v", err)
+	}
+	rootPage, err := RootPageLookup("t", objects)
+	if err != nil {
+		t.Fatalf("looking up root page: %v", err)
+	}
+	page, err := dbFile.NewPage(header, rootPage)
+	if err != nil {
+		t.Fatalf("reading leaf: %v", err)
+	}
+	if page.PageType != LeafTable || len(page.Freeblocks) == 0 {
+		t.Fatalf("fixture is not a fragmented leaf: type %d, freeblocks %v", page.PageType, page.Freeblocks)
+	}
+
+	for i := 0; i < int(page.CellCount); i++ {
+		cellData, err := CellData(page, i)
+		if err != nil {
+			t.Fatalf("cell %d: %v", i, err)
+		}
+		start := int(page.CellAddresses[i])
+		end := start + len(cellData)
+		for _, freeblock := range page.Freeblocks {
+			if start < int(freeblock.Offset)+int(freeblock.Size) && int(freeblock.Offset) < end {
+				t.Fatalf("cell %d at [%d, %d) overlaps freeblock %+v", i, start, end, freeblock)
+			}
+		}
+	}
+
+	var lines []string
+	cursor := dbFile.NewCursor(header, rootPage)
+	if err := cursor.First(); err != nil {
+		t.Fatalf("positioning cursor: %v", err)
+	}
+	for cursor.Valid() {
+		row, err := cursor.Row()
+		if err != nil {
+			t.Fatalf("reading row: %v", err)
+		}
+		lines = append(lines, fmt.Sprintf("%d|%v", row.RowID, row.Columns[1].DecodedValue))
+		if err := cursor.Next(); err != nil {
+			t.Fatalf("advancing cursor: %v", err)
+		}
+	}
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Fatalf("rows differ from sqlite3:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestReadRowIfStopsAtFailingColumn(t *testing.T) {
+	dbFile, header := openSampleDatabase(t)
+
+	page, err := dbFile.NewPage(header, 2)
+	if err != nil {
+		t.Fatalf("reading page: %v", err)
+	}
+
+	var decoded []int
+	row, err := ReadRowIf(page, 0, func(column int, value any) bool {
+		decoded = append(decoded, column)
+		return column != 1 || value == "Fuji"
+	})
+	if err != nil {
+		t.Fatalf("reading row: %v", err)
+	}
+
+	if row != nil {
+		t.Fatalf("expected Granny Smith to be rejected, got row %d", row.RowID)
+	}
+	if len(decoded) != 2 {
+		t.Fatalf("expected decoding to stop after column 1, decoded columns %v", decoded)
+	}
+
+	row, err = ReadRowIf(page, 1, func(column int, value any) bool {
+		return column != 1 || value == "Fuji"
+	})
+	if err != nil {
+		t.Fatalf("reading row: %v", err)
+	}
+	if row == nil || len(row.Columns) != 3 {
+		t.Fatalf("expected the full Fuji row, got %+v", row)
+	}
+}
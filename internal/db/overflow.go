@@ -0,0 +1,107 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// maxPayloadSize bounds the payloads this package reads. SQLite never
+// writes a larger one, since its length limit is below 2^31, and sizes past
+// it would overflow an int on 32-bit builds.
+const maxPayloadSize = math.MaxInt32
+
+// localPayloadSize returns how many bytes of a payload are stored on a b-tree
+// page of the given type before the remainder spills onto overflow pages.
+func localPayloadSize(pageType BTreePageType, usableSize int, payloadSize uint64) int {
+	maxLocal := (usableSize-12)*64/255 - 23
+	if pageType == LeafTable {
+		maxLocal = usableSize - 35
+	}
+	if payloadSize <= uint64(maxLocal) {
+		return int(payloadSize)
+	}
+
+	minLocal := (usableSize-12)*32/255 - 23
+	local := minLocal + int((payloadSize-uint64(minLocal))%uint64(usableSize-4))
+	if local > maxLocal {
+		return minLocal
+	}
+	return local
+}
+
+func (databaseFile *DatabaseFile) readRawPage(databaseHeader *DatabaseHeader, pageNumber uint32) ([]byte, error) {
+	if pageNumber == 0 {
+		return nil, fmt.Errorf("page number must be greater than 0")
+	}
+
+	data := make([]byte, databaseHeader.PageSize)
+	start := int64(pageNumber-1) * int64(databaseHeader.PageSize)
+	sectionReader := io.NewSectionReader(databaseFile, start, int64(len(data)))
+	if _, err := io.ReadFull(sectionReader, data); err != nil {
+		return nil, fmt.Errorf("page %d: read bytes: %w", pageNumber, err)
+	}
+	return data, nil
+}
+
+// ReadOverflow follows the overflow chain starting at firstPage and returns
+// the next remaining bytes of payload stored on it.
+func (databaseFile *DatabaseFile) ReadOverflow(databaseHeader *DatabaseHeader, firstPage uint32, remaining int) ([]byte, error) {
+	usableSize := databaseHeader.UsableSize()
+	if usableSize <= 4 {
+		return nil, fmt.Errorf("usable page size %d too small for overflow pages", usableSize)
+	}
+
+	payload := make([]byte, 0, remaining)
+	maxPages := remaining/(usableSize-4) + 1
+
+	for pageNumber, visited := firstPage, 0; len(payload) < remaining; visited++ {
+		if pageNumber == 0 {
+			return nil, fmt.Errorf("overflow chain ended with %d bytes unread", remaining-len(payload))
+		}
+		if visited >= maxPages {
+			return nil, fmt.Errorf("overflow chain from page %d longer than payload", firstPage)
+		}
+
+		data, err := databaseFile.readRawPage(databaseHeader, pageNumber)
+		if err != nil {
+			return nil, fmt.Errorf("overflow: %w", err)
+		}
+
+		chunk := min(remaining-len(payload), usableSize-4)
+		payload = append(payload, data[4:4+chunk]...)
+		pageNumber = binary.BigEndian.Uint32(data[0:4])
+	}
+
+	return payload, nil
+}
+
+// cellPayload reassembles a cell's payload from the bytes stored on the page
+// and, when it does not fit locally, the overflow chain that follows them.
+func (databaseFile *DatabaseFile) cellPayload(databaseHeader *DatabaseHeader, page *Page, cellIndex int, local []byte, payloadSize uint64) ([]byte, error) {
+	if payloadSize > maxPayloadSize {
+		return nil, corruptCell(page.PageNumber, cellIndex, "payload of %d bytes is too large", payloadSize)
+	}
+	localSize := localPayloadSize(page.PageType, databaseHeader.UsableSize(), payloadSize)
+	if uint64(localSize) == payloadSize {
+		if len(local) < localSize {
+			return nil, corruptCell(page.PageNumber, cellIndex, "payload of %d bytes extends past usable page area", payloadSize)
+		}
+		return local[:localSize], nil
+	}
+
+	if len(local) < localSize+4 {
+		return nil, corruptCell(page.PageNumber, cellIndex, "local payload of %d bytes extends past usable page area", localSize)
+	}
+
+	overflowPage := binary.BigEndian.Uint32(local[localSize : localSize+4])
+	overflow, err := databaseFile.ReadOverflow(databaseHeader, overflowPage, int(payloadSize)-localSize)
+	if err != nil {
+		return nil, fmt.Errorf("cell %d: %w", cellIndex, err)
+	}
+
+	payload := make([]byte, 0, payloadSize)
+	payload = append(payload, local[:localSize]...)
+	return append(payload, overflow...), nil
+}
@@ -2,8 +2,10 @@ package db
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
 type BTreePageType uint8
@@ -16,21 +18,102 @@ const (
 )
 
 type Page struct {
-	PageType      BTreePageType
-	PageStart     int64
-	ContentOffset int
-	CellCount     uint16
-	CellAddresses []uint16
-	Data          []byte
+	PageNumber       uint32
+	PageType         BTreePageType
+	PageStart        int64
+	ContentOffset    int
+	CellCount        uint16
+	CellContentStart int
+	FragmentedBytes  uint8
+	RightPointer     uint32
+	CellAddresses    []uint16
+	CellPointersEnd  int
+	UsableSize       int
+	Freeblocks       []Freeblock
+	Data             []byte
 }
 
+// progress counts a page read and reports it to the Progress hook.
+func (databaseFile *DatabaseFile) progress() error {
+	databaseFile.pagesRead.Add(1)
+	if databaseFile.Progress == nil {
+		return nil
+	}
+	return databaseFile.Progress()
+}
+
+// Freeblock is an unused region inside a page's cell content area.
+type Freeblock struct {
+	Offset uint16
+	Size   uint16
+}
+
+// FreeBytes counts the bytes on the page not used by its header, cell
+// pointers, or cells: the gap before the cell content area, every freeblock,
+// and fragmented bytes.
+func (page *Page) FreeBytes() int {
+	free := page.CellContentStart - page.CellPointersEnd + int(page.FragmentedBytes)
+	for _, freeblock := range page.Freeblocks {
+		free += int(freeblock.Size)
+	}
+	return free
+}
+
+// errUnknownPageType is the error for a page whose type byte is not one
+// of the b-tree page types.
+var errUnknownPageType = errors.New("unknown type")
+
+// maxTornPageRetries bounds how often a page read that raced a writer is
+// retried before it is reported as corrupt.
+const maxTornPageRetries = 3
+
+// NewPage reads and parses a b-tree page. A page whose type byte is
+// invalid while the file's change counter has moved on from the header's
+// was most likely read as another process rewrote it, one that writes
+// without taking SQLite's locks, and is read again, a few times, before
+// it is reported as corrupt.
 func (databaseFile *DatabaseFile) NewPage(databaseHeader *DatabaseHeader, pageNumber uint32) (*Page, error) {
+	if err := databaseFile.progress(); err != nil {
+		return nil, err
+	}
+	page, err := databaseFile.readPage(databaseHeader, pageNumber)
+	if !errors.Is(err, errUnknownPageType) || !databaseFile.changedSince(databaseHeader) {
+		return page, err
+	}
+	for retry := range maxTornPageRetries {
+		databaseFile.debug("page read raced a writer, retrying", "page", pageNumber, "retry", retry+1)
+		time.Sleep(busyDelays[retry] * time.Millisecond)
+		if page, err = databaseFile.readPage(databaseHeader, pageNumber); !errors.Is(err, errUnknownPageType) {
+			return page, err
+		}
+	}
+	return nil, fmt.Errorf("%w (the database changed while it was read)", err)
+}
+
+// changedSince reports whether another process has committed to the file
+// since databaseHeader was read, by its change counter. Only a database in
+// rollback journal mode is rewritten in place, and pages this handle has
+// written itself are read from its pager.
+func (databaseFile *DatabaseFile) changedSince(databaseHeader *DatabaseHeader) bool {
+	if databaseFile.wal != nil || databaseFile.InMemory() || (databaseFile.pending != nil && len(databaseFile.pending.dirty) > 0) {
+		return false
+	}
+	var counter [4]byte
+	if _, err := databaseFile.storage.ReadAt(counter[:], 24); err != nil {
+		return false
+	}
+	return binary.BigEndian.Uint32(counter[:]) != databaseHeader.ChangeCounter
+}
+
+// readPage reads page pageNumber and parses its b-tree page header and
+// cell pointers.
+func (databaseFile *DatabaseFile) readPage(databaseHeader *DatabaseHeader, pageNumber uint32) (*Page, error) {
 	start, pageSize, contentOffset, err := pageBounds(databaseHeader, pageNumber)
 	if err != nil {
 		return nil, err
 	}
 
-	page := &Page{PageStart: start, ContentOffset: contentOffset}
+	page := &Page{PageNumber: pageNumber, PageStart: start, ContentOffset: contentOffset, UsableSize: databaseHeader.UsableSize()}
 	page.Data = make([]byte, pageSize)
 
 	sectionReader := io.NewSectionReader(databaseFile, page.PageStart, int64(pageSize))
@@ -65,7 +148,7 @@ func (databaseFile *DatabaseFile) NewPage(databaseHeader *DatabaseHeader, pageNu
 		page.PageType = LeafTable
 		headerLen = 7
 	default:
-		return nil, fmt.Errorf("page %d: unknown type %d", pageNumber, typeFlag)
+		return nil, fmt.Errorf("page %d: %w %d", pageNumber, errUnknownPageType, typeFlag)
 	}
 
 	if len(page.Data) < offset+headerLen {
@@ -74,7 +157,16 @@ func (databaseFile *DatabaseFile) NewPage(databaseHeader *DatabaseHeader, pageNu
 	header := page.Data[offset : offset+headerLen]
 	offset += headerLen
 
+	firstFreeblock := binary.BigEndian.Uint16(header[0:2])
 	page.CellCount = binary.BigEndian.Uint16(header[2:4])
+	page.CellContentStart = int(binary.BigEndian.Uint16(header[4:6]))
+	if page.CellContentStart == 0 {
+		page.CellContentStart = 65536
+	}
+	page.FragmentedBytes = header[6]
+	if headerLen == 11 {
+		page.RightPointer = binary.BigEndian.Uint32(header[7:11])
+	}
 	pointerBytes := int(page.CellCount) * 2
 	if len(page.Data) < offset+pointerBytes {
 		return nil, fmt.Errorf("page %d: cell pointer array truncated", pageNumber)
@@ -84,11 +176,53 @@ func (databaseFile *DatabaseFile) NewPage(databaseHeader *DatabaseHeader, pageNu
 	for i := 0; i < pointerBytes; i += 2 {
 		page.CellAddresses = append(page.CellAddresses, binary.BigEndian.Uint16(page.Data[offset+i:offset+i+2]))
 	}
+	page.CellPointersEnd = offset + pointerBytes
+
+	if page.CellContentStart < page.CellPointersEnd || page.CellContentStart > page.UsableSize {
+		return nil, corruptPage(pageNumber, "cell content area starts at %d", page.CellContentStart)
+	}
+
+	for i, address := range page.CellAddresses {
+		if int(address) < page.CellContentStart || int(address) >= page.UsableSize {
+			return nil, corruptCell(pageNumber, i, "pointer %d outside cell content area [%d, %d)", address, page.CellContentStart, page.UsableSize)
+		}
+	}
+
+	page.Freeblocks, err = readFreeblocks(page.Data, firstFreeblock, page.CellContentStart, page.UsableSize)
+	if err != nil {
+		return nil, corruptPage(pageNumber, "%v", err)
+	}
 
+	databaseFile.debug("read page", "page", pageNumber, "type", page.PageType, "cells", page.CellCount)
 	return page, nil
 }
 
-func pageBounds(databaseHeader *DatabaseHeader, pageNumber uint32) (start int64, size uint16, contentOffset int, err error) {
+// readFreeblocks walks the freeblock chain, which must stay inside the cell
+// content area and be sorted by offset without overlaps.
+func readFreeblocks(data []byte, first uint16, contentStart, usableSize int) ([]Freeblock, error) {
+	var freeblocks []Freeblock
+	lowest := contentStart
+
+	for offset := int(first); offset != 0; {
+		if offset < lowest || offset+4 > usableSize || offset+4 > len(data) {
+			return nil, fmt.Errorf("freeblock at %d outside cell content area", offset)
+		}
+
+		next := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+		size := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		if size < 4 || offset+size > usableSize {
+			return nil, fmt.Errorf("freeblock at %d has invalid size %d", offset, size)
+		}
+
+		freeblocks = append(freeblocks, Freeblock{Offset: uint16(offset), Size: uint16(size)})
+		lowest = offset + size
+		offset = next
+	}
+
+	return freeblocks, nil
+}
+
+func pageBounds(databaseHeader *DatabaseHeader, pageNumber uint32) (start int64, size uint32, contentOffset int, err error) {
 	if databaseHeader == nil {
 		return 0, 0, 0, fmt.Errorf("database header is nil")
 	}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// tornStorage returns page 2 with a garbage type byte for its first torn
+// reads, as a read racing a writer that ignores locks might see it.
+type tornStorage struct {
+	storage
+	pageSize int64
+	torn     int
+	reads    int
+}
+
+func (s *tornStorage) ReadAt(p []byte, off int64) (int, error) {
+	n, err := s.storage.ReadAt(p, off)
+	if off == s.pageSize && len(p) == int(s.pageSize) {
+		s.reads++
+		if s.torn > 0 {
+			s.torn--
+			p[0] = 0xee
+		}
+	}
+	return n, err
+}
+
+func TestNewPageRetriesReadsThatRacedAWriter(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		torn         int
+		counterMoved bool
+		reads        int
+		err          string
+	}{
+		{"torn while a writer committed", 2, true, 3, ""},
+		{"still torn after the retries", 100, true, 1 + maxTornPageRetries, "page 2: unknown type 238 (the database changed while it was read)"},
+		{"garbage with no writer", 1, false, 1, "page 2: unknown type 238"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, dbFile, header := writableTestDatabase(t)
+			if tt.counterMoved {
+				var counter [4]byte
+				binary.BigEndian.PutUint32(counter[:], header.ChangeCounter+1)
+				if _, err := dbFile.storage.WriteAt(counter[:], 24); err != nil {
+					t.Fatal(err)
+				}
+			}
+			storage := &tornStorage{storage: dbFile.storage, pageSize: int64(header.PageSize), torn: tt.torn}
+			dbFile.storage = storage
+
+			page, err := dbFile.NewPage(header, 2)
+			switch {
+			case tt.err == "" && (err != nil || page.PageType != LeafTable):
+				t.Errorf("NewPage: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("NewPage: %v, want %s", err, tt.err)
+			}
+			if storage.reads != tt.reads {
+				t.Errorf("page read %d times, want %d", storage.reads, tt.reads)
+			}
+		})
+	}
+}
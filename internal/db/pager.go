@@ -0,0 +1,352 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// pendingBytePage is the page holding byte offset 2^30, which SQLite reserves
+// for file locks and never uses for content.
+func pendingBytePage(pageSize uint32) uint32 {
+	return uint32(0x40000000/int64(pageSize)) + 1
+}
+
+// writeLibraryVersion is stored in the header of files this package writes:
+// the SQLite release whose file format it produces.
+const writeLibraryVersion = 3046000
+
+// LibraryVersion returns the SQLite release whose file format this package
+// writes, as SQLite numbers its releases: 3.46.0.
+func LibraryVersion() string {
+	return fmt.Sprintf("%d.%d.%d", writeLibraryVersion/1000000, writeLibraryVersion/1000%1000, writeLibraryVersion%1000)
+}
+
+// Pager buffers a transaction's page writes against a database file.
+// Modified pages are held in memory until Commit writes them atomically,
+// together with the header fields that describe the new state of the file.
+type Pager struct {
+	file   *DatabaseFile
+	header *DatabaseHeader
+	// committed is the header as of the last commit, restored by Rollback
+	committed DatabaseHeader
+	dirty     map[uint32][]byte
+}
+
+// NewPager starts buffering writes to databaseFile. Until they are committed
+// or rolled back, reads through the file see them too, so that b-tree code
+// reading the file observes the transaction's own changes.
+func NewPager(databaseFile *DatabaseFile, databaseHeader *DatabaseHeader) *Pager {
+	pager := &Pager{file: databaseFile, header: databaseHeader, committed: *databaseHeader, dirty: make(map[uint32][]byte)}
+	databaseFile.pending = pager
+	return pager
+}
+
+// ReadAt reads the database including the pending pager's uncommitted
+// pages, falling back to the last committed contents.
+func (databaseFile *DatabaseFile) ReadAt(p []byte, off int64) (int, error) {
+	pager := databaseFile.pending
+	if pager == nil || len(pager.dirty) == 0 {
+		return databaseFile.readCommitted(p, off)
+	}
+
+	pageSize := int64(pager.header.PageSize)
+	read := 0
+	for read < len(p) {
+		position := off + int64(read)
+		within := position % pageSize
+		chunk := p[read : read+int(min(int64(len(p)-read), pageSize-within))]
+
+		if data, ok := pager.dirty[uint32(position/pageSize)+1]; ok {
+			read += copy(chunk, data[within:])
+			continue
+		}
+		n, err := databaseFile.readCommitted(chunk, position)
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+// readCommittedPage reads a page as of the last commit, ignoring any
+// pending writes.
+func (databaseFile *DatabaseFile) readCommittedPage(pageSize uint32, pageNumber uint32) ([]byte, error) {
+	data := make([]byte, pageSize)
+	if _, err := databaseFile.readCommitted(data, int64(pageNumber-1)*int64(pageSize)); err != nil {
+		return nil, fmt.Errorf("page %d: read bytes: %w", pageNumber, err)
+	}
+	return data, nil
+}
+
+// Header returns the header as it will be written by the next Commit.
+func (pager *Pager) Header() *DatabaseHeader {
+	return pager.header
+}
+
+// Read returns the current contents of a page, including writes not yet
+// flushed. The returned slice must not be modified; use Write.
+func (pager *Pager) Read(pageNumber uint32) ([]byte, error) {
+	if pageNumber == 0 || pageNumber > pager.header.PageCount {
+		return nil, fmt.Errorf("page %d out of range (page count %d)", pageNumber, pager.header.PageCount)
+	}
+	if data, ok := pager.dirty[pageNumber]; ok {
+		return data, nil
+	}
+	return pager.file.readRawPage(pager.header, pageNumber)
+}
+
+// Write replaces the contents of a page.
+func (pager *Pager) Write(pageNumber uint32, data []byte) error {
+	if pageNumber == 0 || pageNumber > pager.header.PageCount {
+		return fmt.Errorf("page %d out of range (page count %d)", pageNumber, pager.header.PageCount)
+	}
+	if len(data) != int(pager.header.PageSize) {
+		return fmt.Errorf("page %d: write of %d bytes, page size is %d", pageNumber, len(data), pager.header.PageSize)
+	}
+	pager.dirty[pageNumber] = append([]byte(nil), data...)
+	return nil
+}
+
+// freelistLeafCapacity is how many leaf page numbers fit on a trunk page
+// after its next-trunk pointer and leaf count. SQLite leaves the last six
+// slots unused for compatibility with old readers.
+func (pager *Pager) freelistLeafCapacity() int {
+	return pager.header.UsableSize()/4 - 8
+}
+
+// Allocate returns a zeroed page for new content. Pages are reused from the
+// freelist before the file grows: a trunk's leaves first, then the trunk
+// itself once it has none left.
+func (pager *Pager) Allocate() (uint32, error) {
+	blank := make([]byte, pager.header.PageSize)
+
+	if trunkNumber := pager.header.FreelistTrunk; trunkNumber != 0 {
+		trunk, err := pager.Read(trunkNumber)
+		if err != nil {
+			return 0, fmt.Errorf("freelist trunk: %w", err)
+		}
+		trunk = append([]byte(nil), trunk...)
+
+		// Readers accept up to two slots short of a full page, as SQLite does
+		leafCount := binary.BigEndian.Uint32(trunk[4:8])
+		if int(leafCount) > pager.header.UsableSize()/4-2 {
+			return 0, corruptPage(trunkNumber, "freelist trunk claims %d leaves", leafCount)
+		}
+
+		pageNumber := trunkNumber
+		if leafCount > 0 {
+			pageNumber = binary.BigEndian.Uint32(trunk[8+4*(leafCount-1):])
+			if pageNumber < 2 || pageNumber > pager.header.PageCount {
+				return 0, corruptPage(trunkNumber, "freelist leaf %d out of range", pageNumber)
+			}
+			binary.BigEndian.PutUint32(trunk[4:8], leafCount-1)
+			if err := pager.Write(trunkNumber, trunk); err != nil {
+				return 0, err
+			}
+		} else {
+			pager.header.FreelistTrunk = binary.BigEndian.Uint32(trunk[0:4])
+		}
+
+		if pager.header.FreelistCount > 0 {
+			pager.header.FreelistCount--
+		}
+		return pageNumber, pager.Write(pageNumber, blank)
+	}
+
+	pager.header.PageCount++
+	if pager.header.PageCount == pendingBytePage(pager.header.PageSize) {
+		pager.header.PageCount++
+	}
+	pageNumber := pager.header.PageCount
+	return pageNumber, pager.Write(pageNumber, blank)
+}
+
+// Free returns a page to the freelist, as a leaf of the first trunk when it
+// has room and as a new first trunk otherwise.
+func (pager *Pager) Free(pageNumber uint32) error {
+	if pageNumber < 2 || pageNumber > pager.header.PageCount {
+		return fmt.Errorf("cannot free page %d (page count %d)", pageNumber, pager.header.PageCount)
+	}
+
+	if trunkNumber := pager.header.FreelistTrunk; trunkNumber != 0 {
+		trunk, err := pager.Read(trunkNumber)
+		if err != nil {
+			return fmt.Errorf("freelist trunk: %w", err)
+		}
+		leafCount := binary.BigEndian.Uint32(trunk[4:8])
+		if int(leafCount) < pager.freelistLeafCapacity() {
+			trunk = append([]byte(nil), trunk...)
+			binary.BigEndian.PutUint32(trunk[8+4*leafCount:], pageNumber)
+			binary.BigEndian.PutUint32(trunk[4:8], leafCount+1)
+			pager.header.FreelistCount++
+			return pager.Write(trunkNumber, trunk)
+		}
+	}
+
+	trunk := make([]byte, pager.header.PageSize)
+	binary.BigEndian.PutUint32(trunk[0:4], pager.header.FreelistTrunk)
+	pager.header.FreelistTrunk = pageNumber
+	pager.header.FreelistCount++
+	return pager.Write(pageNumber, trunk)
+}
+
+// Commit makes the transaction's writes durable in one atomic step, using a
+// rollback journal in SQLite's format: the original contents of every page
+// about to change are journaled and synced first, then the pages are
+// written, and deleting the journal is the commit point. A crash before that
+// leaves a hot journal, which the next writer (or SQLite itself) replays.
+//
+// In WAL journal mode the pages are instead appended to the write-ahead log
+// as one transaction, and the commit frame reaching the disk is the commit
+// point; the database file itself only changes at a Checkpoint.
+//
+// Page 1 is always rewritten, with the change counter incremented and the
+// version-valid-for number matching it, so that readers trust the page
+// count, along with the freelist fields, the schema cookie, the user
+// version and the write library version.
+func (pager *Pager) Commit() error {
+	if len(pager.dirty) == 0 && *pager.header == pager.committed {
+		return nil
+	}
+
+	first, err := pager.Read(1)
+	if err != nil {
+		return err
+	}
+	first = append([]byte(nil), first...)
+	pager.header.ChangeCounter = pager.committed.ChangeCounter + 1
+	binary.BigEndian.PutUint32(first[24:28], pager.header.ChangeCounter)
+	binary.BigEndian.PutUint32(first[28:32], pager.header.PageCount)
+	binary.BigEndian.PutUint32(first[32:36], pager.header.FreelistTrunk)
+	binary.BigEndian.PutUint32(first[36:40], pager.header.FreelistCount)
+	binary.BigEndian.PutUint32(first[40:44], pager.header.SchemaCookie)
+	binary.BigEndian.PutUint32(first[60:64], pager.header.UserVersion)
+	binary.BigEndian.PutUint32(first[92:96], pager.header.ChangeCounter)
+	binary.BigEndian.PutUint32(first[96:100], writeLibraryVersion)
+	pager.dirty[1] = first
+
+	if log := pager.file.wal; log != nil {
+		// A pinned snapshot may only be written while it is the newest
+		// commit, and its pin then moves to the transaction's
+		if pin := pager.file.pin; pin != nil {
+			if changed, err := log.changed(); err != nil {
+				return err
+			} else if changed {
+				return ErrBusySnapshot
+			}
+		}
+		if err := log.append(pager.header.PageSize, pager.dirty, pager.header.PageCount); err != nil {
+			return err
+		}
+		if pin := pager.file.pin; pin != nil {
+			pin.salt, pin.checkpointSequence = log.salt, log.checkpointSequence
+		}
+		pager.committed = *pager.header
+		clear(pager.dirty)
+		return nil
+	}
+	// An in-memory database has no other readers and nothing to recover
+	// after a crash, so its pages are written without a journal
+	if pager.file.InMemory() {
+		if err := pager.writeDirty(); err != nil {
+			return err
+		}
+		pager.committed = *pager.header
+		clear(pager.dirty)
+		return nil
+	}
+
+	// No other process may read the file while its pages are rewritten
+	unlock, err := pager.file.lockForCommit()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Pages past the original end need no journal entry: rolling back
+	// truncates the file to its original size
+	originals := make(map[uint32][]byte)
+	for pageNumber := range pager.dirty {
+		if pageNumber > pager.committed.PageCount {
+			continue
+		}
+		original, err := pager.file.readCommittedPage(pager.header.PageSize, pageNumber)
+		if err != nil {
+			return err
+		}
+		originals[pageNumber] = original
+	}
+
+	journal := journalPath(pager.file.Name())
+	if err := writeJournal(journal, pager.header.PageSize, pager.committed.PageCount, originals); err != nil {
+		return err
+	}
+
+	if err := pager.writeDirty(); err != nil {
+		return err
+	}
+	if err := os.Remove(journal); err != nil {
+		return fmt.Errorf("delete journal: %w", err)
+	}
+
+	pager.committed = *pager.header
+	clear(pager.dirty)
+	return nil
+}
+
+// writeDirty writes the pending pages to the database file, truncates it to
+// the page count and syncs it.
+func (pager *Pager) writeDirty() error {
+	for pageNumber, data := range pager.dirty {
+		offset := int64(pageNumber-1) * int64(pager.header.PageSize)
+		if _, err := pager.file.WriteAt(data, offset); err != nil {
+			return fmt.Errorf("write page %d: %w", pageNumber, err)
+		}
+	}
+	// Freeing pages never shrinks the file, but a journal replay may have
+	// left it longer than the page count
+	if err := pager.file.Truncate(int64(pager.header.PageCount) * int64(pager.header.PageSize)); err != nil {
+		return fmt.Errorf("truncate database: %w", err)
+	}
+	if err := pager.file.Sync(); err != nil {
+		return fmt.Errorf("sync database: %w", err)
+	}
+	return nil
+}
+
+// Snapshot is the state of a transaction at a point it can be rolled back
+// to without abandoning the transaction: its pending pages and header.
+type Snapshot struct {
+	dirty  map[uint32][]byte
+	header DatabaseHeader
+}
+
+// Snapshot records the transaction's pending writes, so that Restore can
+// undo everything written after it. Writes replace a page's buffer rather
+// than modify it, so the pages themselves need not be copied.
+func (pager *Pager) Snapshot() Snapshot {
+	dirty := make(map[uint32][]byte, len(pager.dirty))
+	for pageNumber, data := range pager.dirty {
+		dirty[pageNumber] = data
+	}
+	return Snapshot{dirty: dirty, header: *pager.header}
+}
+
+// Restore discards the writes made since snapshot was taken. A snapshot
+// taken before the last Commit must not be restored.
+func (pager *Pager) Restore(snapshot Snapshot) {
+	clear(pager.dirty)
+	for pageNumber, data := range snapshot.dirty {
+		pager.dirty[pageNumber] = data
+	}
+	*pager.header = snapshot.header
+}
+
+// Rollback discards the transaction's writes, restoring the header to its
+// last committed state.
+func (pager *Pager) Rollback() {
+	*pager.header = pager.committed
+	clear(pager.dirty)
+}
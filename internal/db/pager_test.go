@@ -0,0 +1,174 @@
+package db
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func sqlite3Output(t *testing.T, sqlite3, path, sql string) string {
+	t.Helper()
+
+	output, err := exec.Command(sqlite3, path, sql).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 %q: %v\n%s", sql, err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestPagerReusesFreelistPages(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found in PATH")
+	}
+
+	// Dropping a table leaves its pages on the freelist
+	path := filepath.Join(t.TempDir(), "freelist.db")
+	sqlite3Output(t, sqlite3, path, `CREATE TABLE kept (x); INSERT INTO kept VALUES (1);
+CREATE TABLE dropped (x); WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 300)
+INSERT INTO dropped SELECT randomblob(500) FROM n; DROP TABLE dropped;`)
+
+	dbFile, header, err := OpenWritableDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbFile.Close()
+	free, pageCount := header.FreelistCount, header.PageCount
+	if free < 10 || header.FreelistTrunk == 0 {
+		t.Fatalf("expected a populated freelist, got %d pages from trunk %d", free, header.FreelistTrunk)
+	}
+
+	pager := NewPager(dbFile, header)
+	var allocated []uint32
+	for range free {
+		pageNumber, err := pager.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		allocated = append(allocated, pageNumber)
+	}
+	if header.FreelistCount != 0 || header.FreelistTrunk != 0 || header.PageCount != pageCount {
+		t.Fatalf("freelist not drained in place: count %d, trunk %d, pages %d", header.FreelistCount, header.FreelistTrunk, header.PageCount)
+	}
+	if slices.Sort(allocated); len(slices.Compact(allocated)) != int(free) {
+		t.Fatal("freelist handed out the same page twice")
+	}
+
+	// With the freelist empty the file grows
+	grown, err := pager.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grown != pageCount+1 {
+		t.Fatalf("grew to page %d, want %d", grown, pageCount+1)
+	}
+
+	for _, pageNumber := range append(allocated, grown) {
+		if err := pager.Free(pageNumber); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pager.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check"); got != "ok" {
+		t.Fatalf("integrity_check: %s", got)
+	}
+	want := fmt.Sprintf("%d\n%d", free+1, pageCount+1)
+	if got := sqlite3Output(t, sqlite3, path, "PRAGMA freelist_count; PRAGMA page_count"); got != want {
+		t.Fatalf("freelist and page counts: got %q, want %q", got, want)
+	}
+}
+
+func TestPagerFreeChainsTrunks(t *testing.T) {
+	database := testgen.New(testgen.Options{PageSize: 512})
+	database.CreateTable("t", "CREATE TABLE t (x)")
+	dbFile, header, err := OpenWritableDatabaseFile(database.WriteTemp(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbFile.Close()
+
+	// More pages than one 512 byte trunk can list
+	const pages = 300
+	pager := NewPager(dbFile, header)
+	start := header.PageCount
+	for range pages {
+		if _, err := pager.Allocate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for pageNumber := start + 1; pageNumber <= start+pages; pageNumber++ {
+		if err := pager.Free(pageNumber); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pager.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	reread, err := dbFile.NewDatabaseHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reread.FreelistCount != pages || reread.PageCount != start+pages {
+		t.Fatalf("header after flush: %+v", reread)
+	}
+
+	pager = NewPager(dbFile, reread)
+	seen := make(map[uint32]bool)
+	for range pages {
+		pageNumber, err := pager.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pageNumber <= start || pageNumber > start+pages || seen[pageNumber] {
+			t.Fatalf("unexpected page %d from the freelist", pageNumber)
+		}
+		seen[pageNumber] = true
+	}
+	if reread.FreelistTrunk != 0 || reread.PageCount != start+pages {
+		t.Fatalf("freelist not drained without growing: %+v", reread)
+	}
+}
+
+func TestPendingBytePage(t *testing.T) {
+	if page := pendingBytePage(4096); page != 262145 {
+		t.Fatalf("pending byte page for 4096 byte pages: %d", page)
+	}
+}
+
+func TestCreateDatabaseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "new.db")
+	if err := CreateDatabaseFile(path, 1024); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateDatabaseFile(path, 1024); err == nil {
+		t.Fatal("expected an error creating over an existing file")
+	}
+
+	dbFile, header, err := OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbFile.Close()
+	if header.PageSize != 1024 || header.PageCount != 1 {
+		t.Fatalf("header: page size %d, %d pages", header.PageSize, header.PageCount)
+	}
+	if objects, err := dbFile.ReadSchema(header); err != nil || len(objects) != 0 {
+		t.Fatalf("schema of a new database: %v, %v", objects, err)
+	}
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; CREATE TABLE t (a); INSERT INTO t VALUES (1); SELECT count(*) FROM t")
+		if got != "ok\n1" {
+			t.Fatalf("sqlite3 on a created database: %q", got)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// readerFile is the storage of a database read from an io.ReaderAt rather
+// than a file, such as the bytes of a file a browser was given, where
+// there is no filesystem to open: it is read-only, and has no locks,
+// journal or write-ahead log.
+type readerFile struct {
+	reader io.ReaderAt
+	name   string
+	size   int64
+}
+
+// errReaderReadOnly is returned for a write to a database opened from a
+// reader, which the engine refuses before it reaches the storage.
+var errReaderReadOnly = errors.New("a database opened from a reader cannot be written")
+
+func (file *readerFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= file.size {
+		return 0, io.EOF
+	}
+	if remaining := file.size - off; int64(len(p)) > remaining {
+		n, err := file.reader.ReadAt(p[:remaining], off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return file.reader.ReadAt(p, off)
+}
+
+func (file *readerFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, errReaderReadOnly
+}
+
+func (file *readerFile) Truncate(size int64) error {
+	return errReaderReadOnly
+}
+
+func (file *readerFile) Sync() error {
+	return nil
+}
+
+func (file *readerFile) Stat() (fs.FileInfo, error) {
+	return memoryFileInfo{size: file.size}, nil
+}
+
+func (file *readerFile) Name() string {
+	return file.name
+}
+
+// Close closes the reader, when it is an io.Closer.
+func (file *readerFile) Close() error {
+	if closer, ok := file.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// OpenReader opens the database whose size bytes reader holds, for reading
+// only, under name, which only names it: nothing is opened by it. A
+// database in WAL mode is read without its log, so only what was
+// checkpointed into the file before it was copied is seen.
+func OpenReader(reader io.ReaderAt, size int64, name string) (*DatabaseFile, *DatabaseHeader, error) {
+	dbFile := &DatabaseFile{storage: &readerFile{reader: reader, name: name, size: size}}
+	header, err := dbFile.NewDatabaseHeader()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read database header: %w", err)
+	}
+	return dbFile, header, nil
+}
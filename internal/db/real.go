@@ -0,0 +1,182 @@
+package db
+
+import (
+	"math"
+	"strconv"
+)
+
+// FormatReal renders a real as SQLite's printf does with "%!.Ng", for N
+// significant digits: trailing zeros are dropped but a ".0" is kept on
+// values that would otherwise look integral, and the exponent has at least
+// two digits, so 3 prints as 3.0 and 1e20 as 1.0e+20. SQLite converts reals
+// to text with 15 digits, and sqlite3 prints them in quote and json modes
+// with 20.
+//
+// The digits are SQLite's own rather than the correctly rounded ones Go's
+// strconv produces: SQLite scales the value into a 64-bit integer with
+// double-double arithmetic and rounds that integer's digits, so the two
+// differ in the last places, and here sqlite3's output is what counts.
+func FormatReal(value float64, significant int) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	if significant < 1 {
+		significant = 1
+	}
+
+	var out []byte
+	if value < 0 {
+		out = append(out, '-')
+		value = -value
+	}
+	digits, point := decodeReal(value, significant, 26)
+
+	// Exponential notation when the exponent is below -4 or would need
+	// more digits than there are significant ones
+	exponent := point - 1
+	scientific := exponent < -4 || exponent > significant-1
+	precision, leading := significant-1, 0
+	if !scientific {
+		precision -= exponent
+		leading = exponent
+	}
+
+	next := 0
+	digit := func() byte {
+		if next < len(digits) {
+			next++
+			return digits[next-1]
+		}
+		return '0'
+	}
+	if leading < 0 {
+		out = append(out, '0')
+	} else {
+		for ; leading >= 0; leading-- {
+			out = append(out, digit())
+		}
+	}
+	out = append(out, '.')
+	for leading++; leading < 0 && precision > 0; precision, leading = precision-1, leading+1 {
+		out = append(out, '0')
+	}
+	for ; precision > 0; precision-- {
+		out = append(out, digit())
+	}
+	for out[len(out)-1] == '0' {
+		out = out[:len(out)-1]
+	}
+	if out[len(out)-1] == '.' {
+		out = append(out, '0')
+	}
+
+	if scientific {
+		out = append(out, 'e')
+		if exponent < 0 {
+			out = append(out, '-')
+			exponent = -exponent
+		} else {
+			out = append(out, '+')
+		}
+		if exponent < 10 {
+			out = append(out, '0')
+		}
+		out = strconv.AppendInt(out, int64(exponent), 10)
+	}
+	return string(out)
+}
+
+// decodeReal returns the significant digits of a positive finite real,
+// without trailing zeros, and the position of the decimal point relative
+// to the first of them, as sqlite3FpDecode does. The value is multiplied
+// by powers of ten until it lies between about 9.2e17 and 9.2e18, keeping
+// the rounding error of each step, and the digits of that integer are
+// rounded to round of them, or to maxRound when there are more.
+func decodeReal(value float64, round, maxRound int) ([]byte, int) {
+	if value == 0 {
+		return []byte{'0'}, 1
+	}
+
+	exponent := 0
+	scaled := [2]float64{value, 0}
+	if scaled[0] > 9.223372036854774784e+18 {
+		for scaled[0] > 9.223372036854774784e+118 {
+			exponent += 100
+			dekkerMultiply(&scaled, 1.0e-100, -1.99918998026028836196e-117)
+		}
+		for scaled[0] > 9.223372036854774784e+28 {
+			exponent += 10
+			dekkerMultiply(&scaled, 1.0e-10, -3.6432197315497741579e-27)
+		}
+		for scaled[0] > 9.223372036854774784e+18 {
+			exponent++
+			dekkerMultiply(&scaled, 1.0e-01, -5.5511151231257827021e-18)
+		}
+	} else {
+		for scaled[0] < 9.223372036854774784e-83 {
+			exponent -= 100
+			dekkerMultiply(&scaled, 1.0e+100, -1.5902891109759918046e+83)
+		}
+		for scaled[0] < 9.223372036854774784e+07 {
+			exponent -= 10
+			dekkerMultiply(&scaled, 1.0e+10, 0)
+		}
+		for scaled[0] < 9.22337203685477478e+17 {
+			exponent--
+			dekkerMultiply(&scaled, 1.0e+01, 0)
+		}
+	}
+	var integer uint64
+	if scaled[1] < 0 {
+		integer = uint64(scaled[0]) - uint64(-scaled[1])
+	} else {
+		integer = uint64(scaled[0]) + uint64(scaled[1])
+	}
+
+	digits := strconv.AppendUint(nil, integer, 10)
+	point := len(digits) + exponent
+	if round > 0 && (round < len(digits) || len(digits) > maxRound) {
+		round = min(round, maxRound)
+		carry := digits[round] >= '5'
+		digits = digits[:round]
+		for i := round - 1; carry && i >= 0; i-- {
+			digits[i]++
+			carry = digits[i] > '9'
+			if carry {
+				digits[i] = '0'
+			}
+		}
+		if carry {
+			digits = append([]byte{'1'}, digits...)
+			point++
+		}
+	}
+	for len(digits) > 1 && digits[len(digits)-1] == '0' {
+		digits = digits[:len(digits)-1]
+	}
+	return digits, point
+}
+
+// dekkerMultiply multiplies the double-double x by y, where yy is the error
+// of y as a float64, splitting each factor into halves whose products are
+// exact. The products are converted explicitly so that none is fused into
+// a multiply-add, which would change the rounding SQLite's C code has.
+func dekkerMultiply(x *[2]float64, y, yy float64) {
+	hx := math.Float64frombits(math.Float64bits(x[0]) & 0xfffffffffc000000)
+	tx := x[0] - hx
+	hy := math.Float64frombits(math.Float64bits(y) & 0xfffffffffc000000)
+	ty := y - hy
+	p := float64(hx * hy)
+	q := float64(hx*ty) + float64(tx*hy)
+	c := p + q
+	cc := p - c + q + float64(tx*ty)
+	cc = float64(x[0]*yy) + float64(x[1]*y) + cc
+	x[0] = c + cc
+	x[1] = c - x[0]
+	x[1] += cc
+}
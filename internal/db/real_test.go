@@ -0,0 +1,95 @@
+package db
+
+import (
+	"math"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestFormatReal(t *testing.T) {
+	tenth := 0.1
+	tests := []struct {
+		value       float64
+		significant int
+		want        string
+	}{
+		{3, 15, "3.0"},
+		{-0.5, 15, "-0.5"},
+		{math.Copysign(0, -1), 15, "0.0"},
+		{1.0 / 3, 15, "0.333333333333333"},
+		{100000000000000, 15, "100000000000000.0"},
+		{1e15, 15, "1.0e+15"},
+		{1e-5, 15, "1.0e-05"},
+		{0.0001, 15, "0.0001"},
+		{1e100, 15, "1.0e+100"},
+		{math.MaxFloat64, 15, "1.79769313486232e+308"},
+		{5e-324, 15, "4.94065645841247e-324"},
+		{0.9999999999999999, 15, "1.0"},
+		{math.Inf(-1), 15, "-Inf"},
+		{math.NaN(), 15, "NaN"},
+		{tenth, 20, "0.1000000000000000055"},
+		{1e15, 20, "1000000000000000.0"},
+		{9.3e18, 20, "9300000000000000000.0"},
+		{1e20, 20, "1.0e+20"},
+		{1e-7, 20, "9.99999999999999955e-08"},
+		{math.Inf(1), 20, "Inf"},
+	}
+	for _, tt := range tests {
+		if got := FormatReal(tt.value, tt.significant); got != tt.want {
+			t.Errorf("FormatReal(%v, %d) = %q, want %q", tt.value, tt.significant, got, tt.want)
+		}
+	}
+}
+
+// TestFormatRealMatchesSQLite3 stores random reals, bit for bit, and checks
+// they print as sqlite3 prints them in list and quote modes.
+func TestFormatRealMatchesSQLite3(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found in PATH")
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	database := testgen.New(testgen.Options{})
+	table := database.CreateTable("reals", "CREATE TABLE reals (id integer primary key, value real)")
+	values := make([]float64, 2000)
+	for i := range values {
+		switch i % 3 {
+		case 0:
+			values[i] = math.Float64frombits(rng.Uint64())
+		case 1:
+			values[i] = rng.Float64() * math.Pow(10, float64(rng.Intn(40)-20))
+		case 2:
+			values[i] = float64(rng.Intn(1000000)) / 100
+		}
+		// Integral reals are stored as integers, and NaN as NULL
+		if math.IsNaN(values[i]) {
+			values[i] = 0
+		}
+		if values[i] == math.Trunc(values[i]) {
+			values[i] += 0.5
+		}
+		table.Insert(int64(i+1), nil, values[i])
+	}
+	path := database.WriteTemp(t)
+
+	for mode, significant := range map[string]int{"-list": 15, "-quote": 20} {
+		output, err := exec.Command(sqlite3, mode, path, "SELECT value FROM reals ORDER BY id").Output()
+		if err != nil {
+			t.Fatalf("sqlite3 %s: %v", mode, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		if len(lines) != len(values) {
+			t.Fatalf("sqlite3 %s printed %d values, want %d", mode, len(lines), len(values))
+		}
+		for i, value := range values {
+			if got := FormatReal(value, significant); got != lines[i] {
+				t.Errorf("%s: FormatReal(%v, %d) = %q, sqlite3 printed %q", mode, value, significant, got, lines[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// appendVarint appends value in SQLite's big-endian varint encoding, where
+// the ninth byte, if present, contributes all 8 bits.
+func appendVarint(buf []byte, value uint64) []byte {
+	if value > 0x00ffffffffffffff {
+		var encoded [9]byte
+		encoded[8] = byte(value)
+		value >>= 8
+		for i := 7; i >= 0; i-- {
+			encoded[i] = byte(value&0x7f) | 0x80
+			value >>= 7
+		}
+		return append(buf, encoded[:]...)
+	}
+
+	var encoded [8]byte
+	n := 0
+	for {
+		encoded[n] = byte(value & 0x7f)
+		n++
+		value >>= 7
+		if value == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		b := encoded[i]
+		if i > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+	}
+	return buf
+}
+
+func varintLen(value uint64) int {
+	n := 1
+	for value > 0x7f && n < 9 {
+		value >>= 7
+		n++
+	}
+	return n
+}
+
+// Value is a column value as records store it: nil for NULL, int64, float64,
+// string for text, or []byte for blobs. Decoded columns hold the same types.
+type Value = any
+
+// serialTypeOf picks the smallest serial type able to hold value, as SQLite
+// does when it writes a record.
+func serialTypeOf(value Value, constants bool) (uint64, error) {
+	switch value := value.(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return serialTypeOf(int64(value), constants)
+	case int64:
+		switch {
+		case constants && value == 0:
+			return 8, nil
+		case constants && value == 1:
+			return 9, nil
+		case value >= math.MinInt8 && value <= math.MaxInt8:
+			return 1, nil
+		case value >= math.MinInt16 && value <= math.MaxInt16:
+			return 2, nil
+		case value >= -1<<23 && value < 1<<23:
+			return 3, nil
+		case value >= math.MinInt32 && value <= math.MaxInt32:
+			return 4, nil
+		case value >= -1<<47 && value < 1<<47:
+			return 5, nil
+		default:
+			return 6, nil
+		}
+	case float64:
+		return 7, nil
+	case string:
+		return uint64(len(value))*2 + 13, nil
+	case []byte:
+		return uint64(len(value))*2 + 12, nil
+	default:
+		return 0, fmt.Errorf("cannot encode %T in a record", value)
+	}
+}
+
+// appendColumnValue appends value's record body bytes for serialType.
+func appendColumnValue(body []byte, serialType uint64, value Value) []byte {
+	switch value := value.(type) {
+	case int:
+		return appendColumnValue(body, serialType, int64(value))
+	case int64:
+		length, _ := columnRawValueLength(serialType)
+		var raw [8]byte
+		binary.BigEndian.PutUint64(raw[:], uint64(value))
+		return append(body, raw[8-length:]...)
+	case float64:
+		return binary.BigEndian.AppendUint64(body, math.Float64bits(value))
+	case string:
+		return append(body, value...)
+	case []byte:
+		return append(body, value...)
+	}
+	return body
+}
+
+// EncodeRecord encodes values in the SQLite record format, giving each the
+// smallest serial type that holds it: the constant types for 0 and 1, the
+// narrowest integer width otherwise, and length-carrying types for text and
+// blobs. Values may also be plain ints. EncodeRecord panics on any other
+// type, which is a programming error rather than bad input.
+func EncodeRecord(values []Value) []byte {
+	return encodeRecord(values, true)
+}
+
+// encodeRecord encodes values as EncodeRecord does, giving 0 and 1 a byte
+// each like any other small integer unless constants is set.
+func encodeRecord(values []Value, constants bool) []byte {
+	types := make([]uint64, len(values))
+	headerBodySize, bodySize := 0, 0
+	for i, value := range values {
+		serialType, err := serialTypeOf(value, constants)
+		if err != nil {
+			panic(fmt.Sprintf("EncodeRecord: column %d: %v", i, err))
+		}
+		types[i] = serialType
+		headerBodySize += varintLen(serialType)
+		length, _ := columnRawValueLength(serialType)
+		bodySize += length
+	}
+
+	// The header size counts its own varint, which may lengthen it
+	headerSize := headerBodySize + 1
+	for varintLen(uint64(headerSize)) != headerSize-headerBodySize {
+		headerSize = headerBodySize + varintLen(uint64(headerSize))
+	}
+
+	record := appendVarint(make([]byte, 0, headerSize+bodySize), uint64(headerSize))
+	for _, serialType := range types {
+		record = appendVarint(record, serialType)
+	}
+	for i, value := range values {
+		record = appendColumnValue(record, types[i], value)
+	}
+	return record
+}
+
+// legacyRecord re-encodes a record for a schema format before 4, which has
+// no serial types 8 and 9, returning a record holding neither as it is.
+func legacyRecord(record []byte) ([]byte, error) {
+	headerSize, offset := varintAt(record)
+	constants := false
+	for offset > 0 && offset < int(headerSize) && offset < len(record) {
+		serialType, n := varintAt(record[offset:])
+		if n == 0 {
+			break
+		}
+		constants = constants || serialType == 8 || serialType == 9
+		offset += n
+	}
+	if !constants {
+		return record, nil
+	}
+
+	_, columns, _, err := decodeRecord(record, nil)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]Value, len(columns))
+	for i, column := range columns {
+		values[i] = column.DecodedValue
+	}
+	return encodeRecord(values, false), nil
+}
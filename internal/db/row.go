@@ -1,7 +1,6 @@
 package db
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
@@ -11,7 +10,7 @@ import (
 
 type Row struct {
 	RecordSize       uint64
-	RowID            uint64
+	RowID            int64
 	RecordHeaderSize uint64
 	Columns          []Column
 }
@@ -41,92 +40,111 @@ func CellOffset(page *Page, cellIndex int) (int, error) {
 	return int(page.CellAddresses[cellIndex]), nil
 }
 
+// CellData returns the bytes of a cell, ending with its overflow page
+// pointer if it has one. Cells need not be stored in order and may be
+// followed by freeblocks or fragments, so the end is found from the sizes in
+// the cell's header rather than from where the next cell starts.
 func CellData(page *Page, cellIndex int) ([]byte, error) {
 	offset, err := CellOffset(page, cellIndex)
 	if err != nil {
 		return nil, err
 	}
 
-	if offset >= len(page.Data) {
-		return nil, fmt.Errorf("cell offset %d exceeds page data", offset)
+	end := min(page.UsableSize, len(page.Data))
+	if offset >= end {
+		return nil, corruptCell(page.PageNumber, cellIndex, "cell offset %d exceeds usable page area", offset)
 	}
 
-	return page.Data[offset:], nil
+	size, err := cellSize(page, offset)
+	if err != nil {
+		return nil, corruptCell(page.PageNumber, cellIndex, "%v", err)
+	}
+	return page.Data[offset : offset+size], nil
 }
 
 func ReadRow(page *Page, cellIndex int) (*Row, error) {
-	if page == nil {
-		return nil, fmt.Errorf("page is nil")
-	}
+	return ReadRowIf(page, cellIndex, nil)
+}
 
-	cellData, err := CellData(page, cellIndex)
+// ReadRowIf reads a row like ReadRow, but checks predicate against each
+// column as it is decoded and returns a nil row as soon as it fails. It
+// reads only the page, so a record continuing on overflow pages is an
+// error; a DatabaseFile's cursors read those.
+func ReadRowIf(page *Page, cellIndex int, predicate ColumnPredicate) (*Row, error) {
+	return readRow(nil, nil, page, cellIndex, predicate)
+}
+
+// readRow decodes a table leaf cell, following the overflow chain through
+// databaseFile when the record does not fit on the page.
+func readRow(databaseFile *DatabaseFile, databaseHeader *DatabaseHeader, page *Page, cellIndex int, predicate ColumnPredicate) (*Row, error) {
+	rowID, record, err := databaseFile.tableRecord(databaseHeader, page, cellIndex)
 	if err != nil {
 		return nil, err
 	}
+	row := &Row{RowID: rowID, RecordSize: uint64(len(record))}
 
-	row := &Row{}
-
-	// Read row metadata
-	cellReader := bufio.NewReader(bytes.NewReader(cellData))
-	recordSize, _, err := ReadVarint(cellReader)
+	// A header describing more column bytes than the record holds is
+	// corrupt: the columns would be read from the next cell
+	headerSize, columns, ok, err := decodeRecord(record, predicate)
 	if err != nil {
-		return nil, fmt.Errorf("cell %d: read record size: %w", cellIndex, err)
+		return nil, corruptCell(page.PageNumber, cellIndex, "%v", err)
 	}
-	row.RecordSize = recordSize
+	if !ok {
+		return nil, nil
+	}
+	row.RecordHeaderSize = headerSize
+	row.Columns = columns
 
-	rowID, _, err := ReadVarint(cellReader)
-	if err != nil {
-		return nil, fmt.Errorf("cell %d: read row ID: %w", cellIndex, err)
+	return row, nil
+}
+
+// tableRecord returns the rowid and the record of a table leaf cell,
+// reassembled from its overflow pages through databaseFile when it does
+// not fit on the page; a nil databaseFile reads the page alone.
+func (databaseFile *DatabaseFile) tableRecord(databaseHeader *DatabaseHeader, page *Page, cellIndex int) (int64, []byte, error) {
+	if page == nil {
+		return 0, nil, fmt.Errorf("page is nil")
 	}
-	row.RowID = rowID
 
-	headerSize, headerBytes, err := ReadVarint(cellReader)
+	cellData, err := CellData(page, cellIndex)
 	if err != nil {
-		return nil, fmt.Errorf("cell %d: read header size: %w", cellIndex, err)
+		return 0, nil, err
 	}
-	row.RecordHeaderSize = headerSize
 
-	remainingHeaderBytes := int64(row.RecordHeaderSize) - int64(headerBytes)
-	if remainingHeaderBytes < 0 {
-		return nil, fmt.Errorf("cell %d: negative header size (size=%d, bytes=%d)", cellIndex, row.RecordHeaderSize, headerBytes)
+	// Read row metadata
+	cellReader := bytes.NewReader(cellData)
+	recordSize, recordSizeBytes, err := ReadVarint(cellReader)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cell %d: read record size: %w", cellIndex, err)
 	}
-
-	// Read serial types into each column
-	serialReader := bufio.NewReader(io.LimitReader(cellReader, remainingHeaderBytes))
-	for {
-		serialType, _, err := ReadVarint(serialReader)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("cell %d: read serial type: %w", cellIndex, err)
-		}
-		row.Columns = append(row.Columns, Column{SerialType: serialType})
+	rowID, rowIDBytes, err := ReadVarint(cellReader)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cell %d: read row ID: %w", cellIndex, err)
 	}
 
-	// Read column values into each column
-	for i := range row.Columns {
-		length, err := columnRawValueLength(row.Columns[i].SerialType)
-		if err != nil {
-			return nil, fmt.Errorf("cell %d: column %d: %w", cellIndex, i, err)
-		}
+	// The record must end inside the usable area, not in the next cell or the reserved bytes
+	cellStart := int(page.CellAddresses[cellIndex])
+	recordStart := cellStart + recordSizeBytes + rowIDBytes
+	localSize := localPayloadSize(page.PageType, page.UsableSize, recordSize)
+	cellEnd := recordStart + localSize
+	if uint64(localSize) < recordSize {
+		cellEnd += 4
+	}
+	if cellEnd > page.UsableSize {
+		return 0, nil, corruptCell(page.PageNumber, cellIndex, "record of %d bytes extends past usable page area", recordSize)
+	}
 
-		var payload []byte
-		if length > 0 {
-			payload = make([]byte, length)
-			if _, err := io.ReadFull(cellReader, payload); err != nil {
-				return nil, fmt.Errorf("cell %d: read column %d payload: %w", cellIndex, i, err)
-			}
+	record := page.Data[recordStart : recordStart+localSize]
+	if uint64(localSize) < recordSize {
+		if databaseFile == nil {
+			return 0, nil, fmt.Errorf("cell %d: record of %d bytes continues on overflow pages", cellIndex, recordSize)
 		}
-
-		value, err := decodeColumnValue(row.Columns[i].SerialType, payload)
-		if err != nil {
-			return nil, fmt.Errorf("cell %d: column %d: %w", cellIndex, i, err)
+		if record, err = databaseFile.cellPayload(databaseHeader, page, cellIndex, page.Data[recordStart:cellEnd], recordSize); err != nil {
+			return 0, nil, err
 		}
-		row.Columns[i].DecodedValue = value
 	}
-
-	return row, nil
+	// Rowids are signed 64-bit integers stored as two's complement varints
+	return int64(rowID), record, nil
 }
 
 func ReadAllRows(page *Page) ([]*Row, error) {
@@ -143,6 +161,59 @@ func ReadAllRows(page *Page) ([]*Row, error) {
 	return rows, nil
 }
 
+// ColumnPredicate is consulted as each column of a record is decoded, left
+// to right. Returning false abandons the record without decoding, or even
+// reading the serial types of, the columns after it.
+type ColumnPredicate func(column int, value any) bool
+
+// decodeRecord decodes a record's header and the column values it describes.
+// It reports false, with no columns, when predicate rejects the record.
+func decodeRecord(record []byte, predicate ColumnPredicate) (uint64, []Column, bool, error) {
+	headerSize, headerBytes := varintAt(record)
+	if headerBytes == 0 {
+		return 0, nil, false, fmt.Errorf("read header size: %w", io.ErrUnexpectedEOF)
+	}
+	if headerSize < uint64(headerBytes) {
+		return 0, nil, false, fmt.Errorf("negative header size (size=%d, bytes=%d)", headerSize, headerBytes)
+	}
+	if headerSize > uint64(len(record)) {
+		return 0, nil, false, fmt.Errorf("header size %d exceeds %d byte record", headerSize, len(record))
+	}
+
+	header := record[headerBytes:headerSize]
+	body := record[headerSize:]
+	columns := make([]Column, 0, len(header))
+
+	for i := 0; len(header) > 0; i++ {
+		serialType, n := varintAt(header)
+		if n == 0 {
+			return 0, nil, false, fmt.Errorf("read serial type: %w", io.ErrUnexpectedEOF)
+		}
+		header = header[n:]
+
+		length, err := columnRawValueLength(serialType)
+		if err != nil {
+			return 0, nil, false, fmt.Errorf("column %d: %w", i, err)
+		}
+		if length > len(body) {
+			return 0, nil, false, fmt.Errorf("column %d needs %d bytes but the %d byte record has %d left", i, length, len(record), len(body))
+		}
+
+		value, err := decodeColumnValue(serialType, body[:length])
+		if err != nil {
+			return 0, nil, false, fmt.Errorf("column %d: %w", i, err)
+		}
+		body = body[length:]
+
+		if predicate != nil && !predicate(i, value) {
+			return headerSize, nil, false, nil
+		}
+		columns = append(columns, Column{SerialType: serialType, DecodedValue: value})
+	}
+
+	return headerSize, columns, true, nil
+}
+
 func columnRawValueLength(serialType uint64) (int, error) {
 	switch serialType {
 	case 0, 8, 9:
@@ -164,10 +235,11 @@ func columnRawValueLength(serialType uint64) (int, error) {
 	}
 
 	if serialType >= 12 {
-		if serialType%2 == 0 {
-			return int((serialType - 12) / 2), nil
+		length := (serialType - 12) / 2
+		if length > maxPayloadSize {
+			return 0, fmt.Errorf("serial type %d is too long", serialType)
 		}
-		return int((serialType - 13) / 2), nil
+		return int(length), nil
 	}
 
 	return 0, fmt.Errorf("unsupported serial type %d", serialType)
@@ -211,5 +283,6 @@ func decodeSignedInteger(raw []byte) int64 {
 		value = (value << 8) | int64(b)
 	}
 	shift := (8 - len(raw)) * 8
-	return int64(uint64(value<<shift) >> shift)
+	// Arithmetic shift right sign-extends the top byte
+	return (value << shift) >> shift
 }
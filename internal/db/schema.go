@@ -1,8 +1,9 @@
 package db
 
 import (
-	"errors"
 	"fmt"
+	"math"
+	"strings"
 )
 
 func SqliteSchemaCol(name string) int {
@@ -22,57 +23,121 @@ func SqliteSchemaCol(name string) int {
 	}
 }
 
-func ExtractTableNames(schemaPage *Page) ([]string, error) {
-	rows, err := ReadAllRows(schemaPage)
-	if err != nil {
-		return nil, fmt.Errorf("read schema rows: %w", err)
+// ExtractTableNames lists the tables and views in the schema, leaving out
+// internal sqlite_ objects the same way the sqlite3 shell does.
+func ExtractTableNames(objects []TableMetadata) []string {
+	names := make([]string, 0, len(objects))
+	for _, object := range objects {
+		if object.Type != "table" && object.Type != "view" {
+			continue
+		}
+		if strings.HasPrefix(object.Name, "sqlite_") {
+			continue
+		}
+		names = append(names, object.Name)
 	}
 
-	tblNameIdx := SqliteSchemaCol("tbl_name")
-	names := make([]string, 0, len(rows))
+	return names
+}
 
-	for _, row := range rows {
-		if tblNameIdx >= len(row.Columns) {
-			return nil, errors.New("tbl_name column missing in schema row")
-		}
+type TableMetadata struct {
+	RowID     int64
+	Type      string
+	Name      string
+	TableName string
+	RootPage  uint32
+	SQL       string
+}
 
-		name, ok := row.Columns[tblNameIdx].DecodedValue.(string)
-		if !ok {
-			return nil, fmt.Errorf("rowid %d: tbl_name is not text", row.RowID)
+// ReadSchema reads every object in the schema table. Its root is page 1,
+// which becomes an interior page once the schema outgrows it, so the whole
+// tree is walked rather than just the cells on page 1.
+func (databaseFile *DatabaseFile) ReadSchema(databaseHeader *DatabaseHeader) ([]TableMetadata, error) {
+	objects := []TableMetadata{}
+	cursor := databaseFile.NewCursor(databaseHeader, 1)
+	err := cursor.First()
+	for ; err == nil && cursor.Valid(); err = cursor.Next() {
+		row, err := cursor.Row()
+		if err != nil {
+			return nil, fmt.Errorf("read schema rows: %w", err)
+		}
+		object, err := tableMetadataFromRow(row)
+		if err != nil {
+			return nil, err
 		}
-		names = append(names, name)
+		objects = append(objects, object)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read schema rows: %w", err)
 	}
 
-	return names, nil
+	return objects, nil
 }
 
-func RootPageLookup(tableName string, schemaPage *Page) (uint32, error) {
-	rows, err := ReadAllRows(schemaPage)
-	if err != nil {
-		return 0, fmt.Errorf("read schema rows: %w", err)
+func tableMetadataFromRow(row *Row) (TableMetadata, error) {
+	if len(row.Columns) <= SqliteSchemaCol("sql") {
+		return TableMetadata{}, fmt.Errorf("rowid %d: schema row has %d columns", row.RowID, len(row.Columns))
 	}
 
-	tblNameIdx := SqliteSchemaCol("tbl_name")
-	rootPageIdx := SqliteSchemaCol("rootpage")
-
-	for _, row := range rows {
-		if tblNameIdx >= len(row.Columns) {
-			return 0, errors.New("tbl_name column missing in schema row")
+	object := TableMetadata{RowID: row.RowID}
+	for _, field := range []struct {
+		column string
+		dest   *string
+	}{
+		{"type", &object.Type},
+		{"name", &object.Name},
+		{"tbl_name", &object.TableName},
+	} {
+		value, ok := row.Columns[SqliteSchemaCol(field.column)].DecodedValue.(string)
+		if !ok {
+			return TableMetadata{}, fmt.Errorf("rowid %d: %s is not text", row.RowID, field.column)
 		}
+		*field.dest = value
+	}
 
-		name, ok := row.Columns[tblNameIdx].DecodedValue.(string)
-		if !ok {
-			return 0, fmt.Errorf("rowid %d: tbl_name is not text", row.RowID)
+	// Views and triggers have no b-tree, so their rootpage is zero or NULL
+	switch rootPage := row.Columns[SqliteSchemaCol("rootpage")].DecodedValue.(type) {
+	case nil:
+	case int64:
+		if rootPage < 0 || rootPage > math.MaxUint32 {
+			return TableMetadata{}, fmt.Errorf("rowid %d: rootpage %d out of range", row.RowID, rootPage)
 		}
+		object.RootPage = uint32(rootPage)
+	default:
+		return TableMetadata{}, fmt.Errorf("rowid %d: rootpage is not int64", row.RowID)
+	}
 
-		if name == tableName {
-			rootPage, ok := row.Columns[rootPageIdx].DecodedValue.(int64)
-			if !ok {
-				return 0, fmt.Errorf("rowid %d: rootpage is not int64", row.RowID)
+	// Automatic indexes have NULL sql
+	switch sql := row.Columns[SqliteSchemaCol("sql")].DecodedValue.(type) {
+	case nil:
+	case string:
+		object.SQL = sql
+	default:
+		return TableMetadata{}, fmt.Errorf("rowid %d: sql is not text", row.RowID)
+	}
+
+	return object, nil
+}
+
+func RootPageLookup(tableName string, objects []TableMetadata) (uint32, error) {
+	for _, object := range objects {
+		if object.Type == "table" && object.Name == tableName {
+			if object.RootPage == 0 {
+				return 0, fmt.Errorf("rowid %d: rootpage %d out of range", object.RowID, object.RootPage)
 			}
-			return uint32(rootPage), nil
+			return object.RootPage, nil
 		}
 	}
 
 	return 0, fmt.Errorf("table %s not found in schema", tableName)
 }
+
+// EncodeSchemaRecord encodes object as its sqlite_schema record. An empty
+// SQL is stored as NULL, as it is for automatic indexes.
+func EncodeSchemaRecord(object TableMetadata) []byte {
+	var sql Value
+	if object.SQL != "" {
+		sql = object.SQL
+	}
+	return EncodeRecord([]Value{object.Type, object.Name, object.TableName, int64(object.RootPage), sql})
+}
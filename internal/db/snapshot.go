@@ -0,0 +1,113 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrSnapshotStale is returned by reads of a pinned snapshot once a
+// checkpoint has overwritten pages it reads, as SQLITE_ERROR_SNAPSHOT is.
+var ErrSnapshotStale = errors.New("database snapshot is no longer available")
+
+// ErrBusySnapshot is returned by a commit on a pinned snapshot that another
+// process has committed after, as SQLITE_BUSY_SNAPSHOT is: the changes were
+// made against an old version of the database.
+var ErrBusySnapshot = errors.New("database snapshot is not the latest")
+
+// WALSnapshot is the commit a pinned handle reads: the database as of the
+// first Frames frames of the write-ahead log, SQLite's mxFrame.
+type WALSnapshot struct {
+	Frames    int
+	PageCount uint32
+}
+
+// pin records what a pinned snapshot depends on: the database file, which
+// only a checkpoint writes in WAL mode, and the log it read the frames of,
+// which a checkpoint starts over.
+type pin struct {
+	size     int64
+	modified time.Time
+	// salt and checkpointSequence identify the log, when it had a header
+	salt               [2]uint32
+	checkpointSequence uint32
+}
+
+// Pin keeps the handle reading the database as of the last commit it has
+// read, however many transactions other processes commit after it, until
+// Unpin: Refresh leaves the log's index as it is. It fails outside WAL
+// mode, where every read goes to the file.
+func (databaseFile *DatabaseFile) Pin() (WALSnapshot, error) {
+	log := databaseFile.wal
+	if log == nil {
+		return WALSnapshot{}, errors.New("snapshots need WAL journal mode")
+	}
+	info, err := databaseFile.storage.Stat()
+	if err != nil {
+		return WALSnapshot{}, fmt.Errorf("stat database: %w", err)
+	}
+	databaseFile.pin = &pin{size: info.Size(), modified: info.ModTime(), salt: log.salt, checkpointSequence: log.checkpointSequence}
+	return databaseFile.WALSnapshot(), nil
+}
+
+// Unpin lets Refresh pick up new commits again.
+func (databaseFile *DatabaseFile) Unpin() {
+	databaseFile.pin = nil
+}
+
+// Pinned reports whether the handle is pinned to a snapshot.
+func (databaseFile *DatabaseFile) Pinned() bool {
+	return databaseFile.pin != nil
+}
+
+// WALSnapshot returns the commit the handle reads, which outside WAL mode is
+// the zero snapshot.
+func (databaseFile *DatabaseFile) WALSnapshot() WALSnapshot {
+	log := databaseFile.wal
+	if log == nil {
+		return WALSnapshot{}
+	}
+	return WALSnapshot{Frames: log.frameCount, PageCount: log.pageCount}
+}
+
+// checkPin fails with ErrSnapshotStale once the pinned snapshot can no
+// longer be read: a checkpoint has copied newer pages into the database
+// file, or has started the log over so that new frames overwrite the ones
+// the snapshot reads.
+func (databaseFile *DatabaseFile) checkPin() error {
+	info, err := databaseFile.storage.Stat()
+	if err != nil {
+		return fmt.Errorf("stat database: %w", err)
+	}
+	if info.Size() != databaseFile.pin.size || !info.ModTime().Equal(databaseFile.pin.modified) {
+		return ErrSnapshotStale
+	}
+
+	log := databaseFile.wal
+	if log.file == nil || log.pageSize == 0 {
+		return nil
+	}
+	current, err := os.Stat(log.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("stat wal: %w", err)
+	}
+	opened, err := log.file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat wal: %w", err)
+	}
+	if current == nil || !os.SameFile(current, opened) || opened.Size() < log.end {
+		return ErrSnapshotStale
+	}
+	var header [walHeaderBytes]byte
+	if _, err := log.file.ReadAt(header[:], 0); err != nil {
+		return fmt.Errorf("read wal header: %w", err)
+	}
+	if binary.BigEndian.Uint32(header[12:16]) != databaseFile.pin.checkpointSequence ||
+		binary.BigEndian.Uint32(header[16:20]) != databaseFile.pin.salt[0] ||
+		binary.BigEndian.Uint32(header[20:24]) != databaseFile.pin.salt[1] {
+		return ErrSnapshotStale
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+)
+
+// ErrWrongKey is returned when a page fails its SQLCipher HMAC check: the
+// passphrase is wrong, the file is not an SQLCipher database, or the page is
+// corrupt. SQLCipher reports it as "file is not a database".
+var ErrWrongKey = errors.New("file is not a database or the passphrase is wrong")
+
+// sqlCipherSettings are the defaults of an SQLCipher major version that
+// decide how its files are encrypted.
+type sqlCipherSettings struct {
+	pageSize int
+	// iterations is the number of PBKDF2 rounds deriving the key from the
+	// passphrase, with hash, which also signs each page
+	iterations int
+	hash       func() hash.Hash
+}
+
+var sqlCipherVersions = map[int]sqlCipherSettings{
+	3: {pageSize: 1024, iterations: 64000, hash: sha1.New},
+	4: {pageSize: 4096, iterations: 256000, hash: sha512.New},
+}
+
+// sqlCipherSaltBytes is the size of the salt at the start of the file, in
+// place of the first bytes of the header.
+const sqlCipherSaltBytes = 16
+
+// sqlCipher is the Codec of a database SQLCipher encrypted with a
+// passphrase. Each page is encrypted with AES-256 in CBC mode, but for
+// its reserved bytes: a random IV, then an HMAC of the encrypted bytes, the
+// IV and the page number. The keys are derived on decoding page 1, whose
+// first bytes are the salt.
+type sqlCipher struct {
+	passphrase string
+	settings   sqlCipherSettings
+	// derive derives key and hmacKey, or sets deriveErr, once, since pages
+	// may be decoded concurrently
+	derive       sync.Once
+	key, hmacKey []byte
+	deriveErr    error
+}
+
+// NewSQLCipherCodec returns a Codec reading databases SQLCipher encrypted
+// with passphrase, and with the defaults of its major version 3 or 4, as
+// PRAGMA cipher_compatibility selects them; 0 means 4. Raw keys and
+// plaintext headers are not supported.
+func NewSQLCipherCodec(passphrase string, version int) (Codec, error) {
+	if version == 0 {
+		version = 4
+	}
+	settings, ok := sqlCipherVersions[version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported SQLCipher version %d", version)
+	}
+	return &sqlCipher{passphrase: passphrase, settings: settings}, nil
+}
+
+func (c *sqlCipher) PageSize() int {
+	return c.settings.pageSize
+}
+
+// reserve is the number of bytes at the end of each page holding its IV
+// and HMAC, rounded up to a whole AES block.
+func (c *sqlCipher) reserve() int {
+	size := aes.BlockSize + c.settings.hash().Size()
+	return (size + aes.BlockSize - 1) / aes.BlockSize * aes.BlockSize
+}
+
+// deriveKeys derives the encryption key from the passphrase and salt, and
+// the HMAC key from that, as SQLCipher does.
+func (c *sqlCipher) deriveKeys(salt []byte) error {
+	key, err := pbkdf2.Key(c.settings.hash, c.passphrase, salt, c.settings.iterations, 32)
+	if err != nil {
+		return err
+	}
+	hmacSalt := make([]byte, len(salt))
+	for i, b := range salt {
+		hmacSalt[i] = b ^ 0x3a
+	}
+	hmacKey, err := pbkdf2.Key(c.settings.hash, string(key), hmacSalt, 2, 32)
+	if err != nil {
+		return err
+	}
+	c.key, c.hmacKey = key, hmacKey
+	return nil
+}
+
+func (c *sqlCipher) Decode(pageNumber uint32, page []byte) error {
+	if len(page) != c.settings.pageSize {
+		return fmt.Errorf("page of %d bytes, want %d", len(page), c.settings.pageSize)
+	}
+	offset := 0
+	if pageNumber == 1 {
+		offset = sqlCipherSaltBytes
+	}
+	c.derive.Do(func() {
+		if pageNumber != 1 {
+			c.deriveErr = errors.New("page 1 must be decoded before any other")
+			return
+		}
+		c.deriveErr = c.deriveKeys(page[:sqlCipherSaltBytes])
+	})
+	if c.deriveErr != nil {
+		return c.deriveErr
+	}
+
+	end := len(page) - c.reserve()
+	iv := page[end : end+aes.BlockSize]
+	mac := hmac.New(c.settings.hash, c.hmacKey)
+	mac.Write(page[offset : end+aes.BlockSize])
+	var number [4]byte
+	binary.LittleEndian.PutUint32(number[:], pageNumber)
+	mac.Write(number[:])
+	if !hmac.Equal(mac.Sum(nil), page[end+aes.BlockSize:end+aes.BlockSize+mac.Size()]) {
+		return ErrWrongKey
+	}
+
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return err
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(page[offset:end], page[offset:end])
+	if pageNumber == 1 {
+		copy(page, "SQLite format 3\x00")
+	}
+	return nil
+}
@@ -4,21 +4,40 @@ import "io"
 
 func ReadVarint(stream io.ByteReader) (uint64, int, error) {
 	var result uint64
-	var err error
 	var raw byte
-	var read int
+	var err error
 
-	for range 9 {
-		// Make room for "data" bits
-		result <<= 7
+	for read := 1; read <= 9; read++ {
 		raw, err = stream.ReadByte()
-		read += 1
-		// Take 7 "data" bits
-		result |= uint64(raw & 0x7f)
+		if err != nil {
+			return result, read, err
+		}
+		// The ninth byte contributes all 8 bits
+		if read == 9 {
+			return (result << 8) | uint64(raw), read, nil
+		}
+		// Make room for and take 7 "data" bits
+		result = (result << 7) | uint64(raw&0x7f)
 		// Check "continuation" bit
 		if (raw & 0x80) == 0 {
-			break
+			return result, read, nil
+		}
+	}
+	return result, 9, nil
+}
+
+// varintAt decodes the varint at the start of data, returning the number of
+// bytes it occupies, or zero if data ends before the varint does.
+func varintAt(data []byte) (uint64, int) {
+	var result uint64
+	for i := 0; i < len(data) && i < 9; i++ {
+		if i == 8 {
+			return (result << 8) | uint64(data[i]), 9
+		}
+		result = (result << 7) | uint64(data[i]&0x7f)
+		if data[i]&0x80 == 0 {
+			return result, i + 1
 		}
 	}
-	return result, read, err
+	return 0, 0
 }
@@ -0,0 +1,607 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+const (
+	walHeaderBytes      = 32
+	walFrameHeaderBytes = 24
+	// The low bit of the magic number selects the byte order of checksums
+	walMagicLittleEndian = 0x377f0682
+	walMagicBigEndian    = 0x377f0683
+	walFormatVersion     = 3007000
+)
+
+// walPath is where the write-ahead log for a database lives.
+func walPath(databasePath string) string {
+	return databasePath + "-wal"
+}
+
+// isWALMode reports whether a database header selects WAL journal mode:
+// file format read and write versions of 2.
+func isWALMode(header []byte) bool {
+	return len(header) >= 20 && header[18] == 2 && header[19] == 2
+}
+
+// WALMode reports whether the database is in WAL journal mode.
+func (databaseFile *DatabaseFile) WALMode() bool {
+	return databaseFile.wal != nil
+}
+
+// SetWALMode switches the database between WAL and rollback journal mode
+// by committing new file format versions to the header, and must be called
+// outside a transaction. Leaving WAL mode checkpoints and deletes the log
+// first, so the file is complete without it.
+func (pager *Pager) SetWALMode(enabled bool) error {
+	databaseFile := pager.file
+	if enabled == databaseFile.WALMode() {
+		return nil
+	}
+	if len(pager.dirty) > 0 || *pager.header != pager.committed {
+		return errors.New("cannot change journal mode within a transaction")
+	}
+
+	if !enabled {
+		if _, err := databaseFile.Checkpoint(); err != nil {
+			return err
+		}
+		log := databaseFile.wal
+		if log.file != nil {
+			log.file.Close()
+		}
+		if err := os.Remove(log.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("delete wal: %w", err)
+		}
+		databaseFile.wal = nil
+	}
+
+	first, err := pager.Read(1)
+	if err != nil {
+		return err
+	}
+	first = append([]byte(nil), first...)
+	version := byte(1)
+	if enabled {
+		version = 2
+	}
+	first[18], first[19] = version, version
+	if err := pager.Write(1, first); err != nil {
+		return err
+	}
+	if err := pager.Commit(); err != nil {
+		return err
+	}
+
+	if enabled {
+		log, err := openWAL(databaseFile.Name(), os.O_RDWR)
+		if err != nil {
+			return err
+		}
+		databaseFile.wal = log
+	}
+	return nil
+}
+
+// walChecksum continues SQLite's WAL checksum over data, which must be a
+// multiple of 8 bytes long.
+func walChecksum(bigEndian bool, seed [2]uint32, data []byte) [2]uint32 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	s0, s1 := seed[0], seed[1]
+	for i := 0; i+8 <= len(data); i += 8 {
+		s0 += order.Uint32(data[i:]) + s1
+		s1 += order.Uint32(data[i+4:]) + s0
+	}
+	return [2]uint32{s0, s1}
+}
+
+// wal is the write-ahead log of a database in WAL journal mode. Only frames
+// up to the last valid commit frame count; anything after it belongs to a
+// transaction that never committed.
+//
+// This package does not maintain the -shm wal-index, so it must be the only
+// connection to the database while it writes (single-process mode). That is
+// enough for SQLite to read the result: the first connection to open a
+// database rebuilds the wal-index from the log.
+type wal struct {
+	path string
+	// file is nil until the log is first written, if it did not exist
+	file               *os.File
+	pageSize           uint32
+	checkpointSequence uint32
+	salt               [2]uint32
+	bigEndian          bool
+	// checksum is the running checksum as of the last committed frame, which
+	// the next frame continues
+	checksum [2]uint32
+	// frames maps each page to the offset in the log of its newest committed
+	// contents
+	frames     map[uint32]int64
+	frameCount int
+	// end is the offset just past the last committed frame, where the next
+	// transaction is appended
+	end int64
+	// pageCount is the database size recorded by the last commit frame
+	pageCount uint32
+	// flag is the mode the log is opened with, and size its length as of
+	// the last time this handle read or wrote it
+	flag int
+	size int64
+}
+
+// openWAL opens the write-ahead log of the database at databasePath and
+// indexes its committed frames. A missing log is not an error: it is
+// created by the first append.
+func openWAL(databasePath string, flag int) (*wal, error) {
+	log := &wal{path: walPath(databasePath), frames: make(map[uint32]int64), flag: flag}
+	file, err := os.OpenFile(log.path, flag, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return log, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open wal: %w", err)
+	}
+	log.file = file
+	if err := log.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return log, nil
+}
+
+// load reads the log header and indexes every frame up to the last valid
+// commit. A log with an invalid header holds no frames.
+func (log *wal) load() error {
+	info, err := log.file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat wal: %w", err)
+	}
+	log.size = info.Size()
+
+	header := make([]byte, walHeaderBytes)
+	if _, err := log.file.ReadAt(header, 0); errors.Is(err, io.EOF) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read wal header: %w", err)
+	}
+
+	magic := binary.BigEndian.Uint32(header[0:4])
+	if magic != walMagicLittleEndian && magic != walMagicBigEndian {
+		return nil
+	}
+	bigEndian := magic == walMagicBigEndian
+	checksum := walChecksum(bigEndian, [2]uint32{}, header[:24])
+	pageSize := binary.BigEndian.Uint32(header[8:12])
+	if binary.BigEndian.Uint32(header[4:8]) != walFormatVersion ||
+		checksum != [2]uint32{binary.BigEndian.Uint32(header[24:28]), binary.BigEndian.Uint32(header[28:32])} ||
+		pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return nil
+	}
+
+	log.pageSize, log.bigEndian = pageSize, bigEndian
+	log.checkpointSequence = binary.BigEndian.Uint32(header[12:16])
+	log.salt = [2]uint32{binary.BigEndian.Uint32(header[16:20]), binary.BigEndian.Uint32(header[20:24])}
+	log.checksum, log.end = checksum, walHeaderBytes
+
+	frame := make([]byte, walFrameHeaderBytes+int(pageSize))
+	pending := make(map[uint32]int64)
+	for offset, count := int64(walHeaderBytes), 1; ; offset, count = offset+int64(len(frame)), count+1 {
+		if _, err := log.file.ReadAt(frame, offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("read wal frame %d: %w", count, err)
+		}
+
+		// A frame belongs to this log only if it carries the header's salts
+		// and continues its checksum chain
+		if binary.BigEndian.Uint32(frame[8:12]) != log.salt[0] || binary.BigEndian.Uint32(frame[12:16]) != log.salt[1] {
+			return nil
+		}
+		checksum = walChecksum(bigEndian, checksum, frame[:8])
+		checksum = walChecksum(bigEndian, checksum, frame[walFrameHeaderBytes:])
+		if checksum != [2]uint32{binary.BigEndian.Uint32(frame[16:20]), binary.BigEndian.Uint32(frame[20:24])} {
+			return nil
+		}
+
+		pageNumber := binary.BigEndian.Uint32(frame[0:4])
+		if pageNumber == 0 {
+			return nil
+		}
+		pending[pageNumber] = offset + walFrameHeaderBytes
+		if commitSize := binary.BigEndian.Uint32(frame[4:8]); commitSize != 0 {
+			for pageNumber, dataOffset := range pending {
+				log.frames[pageNumber] = dataOffset
+			}
+			clear(pending)
+			log.frameCount, log.pageCount = count, commitSize
+			log.checksum, log.end = checksum, offset+int64(len(frame))
+		}
+	}
+}
+
+// restart starts the log over with a fresh header, so the frames already
+// in it no longer count. The salts change so stale frames left in the file
+// cannot be mistaken for new ones.
+func (log *wal) restart(pageSize uint32) error {
+	if log.file == nil {
+		file, err := os.OpenFile(log.path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return fmt.Errorf("create wal: %w", err)
+		}
+		log.file = file
+	}
+
+	var salt [8]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return fmt.Errorf("wal salt: %w", err)
+	}
+	// A restarted log increments the first salt and checkpoint sequence; a
+	// new one starts from random
+	sequence := uint32(0)
+	if log.pageSize != 0 {
+		sequence = log.checkpointSequence + 1
+		binary.BigEndian.PutUint32(salt[0:4], log.salt[0]+1)
+	}
+
+	header := make([]byte, walHeaderBytes)
+	binary.BigEndian.PutUint32(header[0:4], walMagicLittleEndian)
+	binary.BigEndian.PutUint32(header[4:8], walFormatVersion)
+	binary.BigEndian.PutUint32(header[8:12], pageSize)
+	binary.BigEndian.PutUint32(header[12:16], sequence)
+	copy(header[16:24], salt[:])
+	checksum := walChecksum(false, [2]uint32{}, header[:24])
+	binary.BigEndian.PutUint32(header[24:28], checksum[0])
+	binary.BigEndian.PutUint32(header[28:32], checksum[1])
+
+	if _, err := log.file.WriteAt(header, 0); err != nil {
+		return fmt.Errorf("write wal header: %w", err)
+	}
+	if err := log.file.Truncate(walHeaderBytes); err != nil {
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	if err := log.file.Sync(); err != nil {
+		return fmt.Errorf("sync wal: %w", err)
+	}
+
+	log.pageSize, log.bigEndian, log.checkpointSequence = pageSize, false, sequence
+	log.salt = [2]uint32{binary.BigEndian.Uint32(header[16:20]), binary.BigEndian.Uint32(header[20:24])}
+	log.checksum, log.end, log.size = checksum, walHeaderBytes, walHeaderBytes
+	clear(log.frames)
+	log.frameCount, log.pageCount = 0, 0
+	return nil
+}
+
+// append writes pages to the log as one transaction, in page order, with
+// the database's new size in the final, commit frame. It is synced before
+// returning, which makes the transaction durable.
+func (log *wal) append(pageSize uint32, pages map[uint32][]byte, pageCount uint32) error {
+	if log.file == nil || log.pageSize == 0 {
+		if err := log.restart(pageSize); err != nil {
+			return err
+		}
+	}
+	if log.pageSize != pageSize {
+		return fmt.Errorf("wal page size %d does not match database page size %d", log.pageSize, pageSize)
+	}
+
+	pageNumbers := make([]uint32, 0, len(pages))
+	for pageNumber := range pages {
+		pageNumbers = append(pageNumbers, pageNumber)
+	}
+	slices.Sort(pageNumbers)
+
+	frameSize := walFrameHeaderBytes + int(pageSize)
+	frames := make([]byte, len(pageNumbers)*frameSize)
+	checksum := log.checksum
+	for i, pageNumber := range pageNumbers {
+		frame := frames[i*frameSize : (i+1)*frameSize]
+		binary.BigEndian.PutUint32(frame[0:4], pageNumber)
+		if i == len(pageNumbers)-1 {
+			binary.BigEndian.PutUint32(frame[4:8], pageCount)
+		}
+		binary.BigEndian.PutUint32(frame[8:12], log.salt[0])
+		binary.BigEndian.PutUint32(frame[12:16], log.salt[1])
+		copy(frame[walFrameHeaderBytes:], pages[pageNumber])
+		checksum = walChecksum(log.bigEndian, checksum, frame[:8])
+		checksum = walChecksum(log.bigEndian, checksum, frame[walFrameHeaderBytes:])
+		binary.BigEndian.PutUint32(frame[16:20], checksum[0])
+		binary.BigEndian.PutUint32(frame[20:24], checksum[1])
+	}
+
+	if _, err := log.file.WriteAt(frames, log.end); err != nil {
+		return fmt.Errorf("write wal frames: %w", err)
+	}
+	// Drop any frames of an uncommitted transaction that followed
+	if err := log.file.Truncate(log.end + int64(len(frames))); err != nil {
+		return fmt.Errorf("truncate wal: %w", err)
+	}
+	if err := log.file.Sync(); err != nil {
+		return fmt.Errorf("sync wal: %w", err)
+	}
+
+	for i, pageNumber := range pageNumbers {
+		log.frames[pageNumber] = log.end + int64(i*frameSize) + walFrameHeaderBytes
+	}
+	log.checksum, log.end = checksum, log.end+int64(len(frames))
+	log.size = log.end
+	log.frameCount += len(pageNumbers)
+	log.pageCount = pageCount
+	return nil
+}
+
+// verify re-reads the committed frames and checks that each still carries
+// the log's salts and continues its checksum chain, so a log changed since
+// it was loaded is not copied into the database.
+func (log *wal) verify() error {
+	header := make([]byte, walHeaderBytes)
+	if _, err := log.file.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("read wal header: %w", err)
+	}
+	checksum := walChecksum(log.bigEndian, [2]uint32{}, header[:24])
+	if checksum != [2]uint32{binary.BigEndian.Uint32(header[24:28]), binary.BigEndian.Uint32(header[28:32])} {
+		return errors.New("wal header checksum mismatch")
+	}
+
+	frame := make([]byte, walFrameHeaderBytes+int(log.pageSize))
+	for offset, count := int64(walHeaderBytes), 1; offset < log.end; offset, count = offset+int64(len(frame)), count+1 {
+		if _, err := log.file.ReadAt(frame, offset); err != nil {
+			return fmt.Errorf("read wal frame %d: %w", count, err)
+		}
+		if binary.BigEndian.Uint32(frame[8:12]) != log.salt[0] || binary.BigEndian.Uint32(frame[12:16]) != log.salt[1] {
+			return fmt.Errorf("wal frame %d: salt mismatch", count)
+		}
+		checksum = walChecksum(log.bigEndian, checksum, frame[:8])
+		checksum = walChecksum(log.bigEndian, checksum, frame[walFrameHeaderBytes:])
+		if checksum != [2]uint32{binary.BigEndian.Uint32(frame[16:20]), binary.BigEndian.Uint32(frame[20:24])} {
+			return fmt.Errorf("wal frame %d: checksum mismatch", count)
+		}
+	}
+	return nil
+}
+
+// verifyFrame checks the committed frame whose page starts at offset in
+// the log against its checksum, which continues that of the frame before
+// it, or of the log header for the first frame.
+func (log *wal) verifyFrame(offset int64) error {
+	frameSize := int64(walFrameHeaderBytes) + int64(log.pageSize)
+	start := offset - walFrameHeaderBytes
+	number := (start-walHeaderBytes)/frameSize + 1
+	var previous [8]byte
+	if number == 1 {
+		if _, err := log.file.ReadAt(previous[:], 24); err != nil {
+			return fmt.Errorf("read wal header: %w", err)
+		}
+	} else if _, err := log.file.ReadAt(previous[:], start-frameSize+16); err != nil {
+		return fmt.Errorf("read wal frame %d: %w", number-1, err)
+	}
+
+	frame := make([]byte, frameSize)
+	if _, err := log.file.ReadAt(frame, start); err != nil {
+		return fmt.Errorf("read wal frame %d: %w", number, err)
+	}
+	pageNumber := binary.BigEndian.Uint32(frame[0:4])
+	checksum := [2]uint32{binary.BigEndian.Uint32(previous[0:4]), binary.BigEndian.Uint32(previous[4:8])}
+	checksum = walChecksum(log.bigEndian, checksum, frame[:8])
+	checksum = walChecksum(log.bigEndian, checksum, frame[walFrameHeaderBytes:])
+	if binary.BigEndian.Uint32(frame[8:12]) != log.salt[0] || binary.BigEndian.Uint32(frame[12:16]) != log.salt[1] ||
+		checksum != [2]uint32{binary.BigEndian.Uint32(frame[16:20]), binary.BigEndian.Uint32(frame[20:24])} {
+		return corruptPage(pageNumber, "wal frame %d: checksum mismatch", number)
+	}
+	return nil
+}
+
+// Checkpoint copies the newest committed version of every page in the
+// write-ahead log back into the database file, then restarts the log. The
+// frames are verified first, and nothing is copied if any fails. It
+// returns the number of frames the log held, and does nothing for a
+// database that is not in WAL mode. A handle pinned to a snapshot cannot
+// checkpoint, since its index may not hold the newest frames.
+func (databaseFile *DatabaseFile) Checkpoint() (int, error) {
+	if databaseFile.pin != nil {
+		return 0, errors.New("cannot checkpoint while pinned to a snapshot")
+	}
+	log := databaseFile.wal
+	if log == nil || log.frameCount == 0 {
+		return 0, nil
+	}
+	if err := log.verify(); err != nil {
+		return 0, err
+	}
+
+	pageNumbers := make([]uint32, 0, len(log.frames))
+	for pageNumber := range log.frames {
+		pageNumbers = append(pageNumbers, pageNumber)
+	}
+	slices.Sort(pageNumbers)
+
+	data := make([]byte, log.pageSize)
+	for _, pageNumber := range pageNumbers {
+		if _, err := log.file.ReadAt(data, log.frames[pageNumber]); err != nil {
+			return 0, fmt.Errorf("read wal page %d: %w", pageNumber, err)
+		}
+		if _, err := databaseFile.storage.WriteAt(data, int64(pageNumber-1)*int64(log.pageSize)); err != nil {
+			return 0, fmt.Errorf("checkpoint page %d: %w", pageNumber, err)
+		}
+	}
+	if err := databaseFile.storage.Truncate(int64(log.pageCount) * int64(log.pageSize)); err != nil {
+		return 0, fmt.Errorf("truncate database: %w", err)
+	}
+	// The database must be durable before the frames are discarded
+	if err := databaseFile.storage.Sync(); err != nil {
+		return 0, fmt.Errorf("sync database: %w", err)
+	}
+
+	frames := log.frameCount
+	return frames, log.restart(log.pageSize)
+}
+
+// CheckpointTruncate checkpoints the write-ahead log like Checkpoint, then
+// truncates it to zero bytes, as PRAGMA wal_checkpoint(TRUNCATE) does. The
+// next transaction writes a new log header with fresh salts.
+func (databaseFile *DatabaseFile) CheckpointTruncate() (int, error) {
+	frames, err := databaseFile.Checkpoint()
+	if err != nil {
+		return 0, err
+	}
+	log := databaseFile.wal
+	if log == nil || log.file == nil {
+		return frames, nil
+	}
+	if err := log.file.Truncate(0); err != nil {
+		return 0, fmt.Errorf("truncate wal: %w", err)
+	}
+	if err := log.file.Sync(); err != nil {
+		return 0, fmt.Errorf("sync wal: %w", err)
+	}
+	log.pageSize, log.checkpointSequence, log.end, log.size = 0, 0, 0, 0
+	return frames, nil
+}
+
+// changed reports whether another process has written the log since this
+// handle last did: the file was replaced, deleted or created, has a
+// different length, or starts over with new salts.
+func (log *wal) changed() (bool, error) {
+	info, err := os.Stat(log.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return log.file != nil, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("stat wal: %w", err)
+	}
+	if log.file == nil {
+		return true, nil
+	}
+	current, err := log.file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("stat wal: %w", err)
+	}
+	if !os.SameFile(info, current) || info.Size() != log.size {
+		return true, nil
+	}
+	if log.pageSize == 0 {
+		return false, nil
+	}
+
+	var header [walHeaderBytes]byte
+	if _, err := log.file.ReadAt(header[:], 0); err != nil {
+		return false, fmt.Errorf("read wal header: %w", err)
+	}
+	return binary.BigEndian.Uint32(header[12:16]) != log.checkpointSequence ||
+		binary.BigEndian.Uint32(header[16:20]) != log.salt[0] ||
+		binary.BigEndian.Uint32(header[20:24]) != log.salt[1], nil
+}
+
+// Refresh picks up transactions that other processes have committed to the
+// write-ahead log, or checkpointed out of it, since this handle last read
+// it, so a long-lived handle never reads stale pages. The log is reindexed
+// from scratch when it changed. It does nothing outside WAL mode, where
+// every read goes to the file, or while this handle has a transaction or
+// more than one read in progress, which keep the snapshot they started
+// with. A handle pinned to a snapshot keeps it too, and fails with
+// ErrSnapshotStale once it can no longer be read.
+func (databaseFile *DatabaseFile) Refresh() error {
+	if databaseFile.pin != nil {
+		return databaseFile.checkPin()
+	}
+	log := databaseFile.wal
+	if log == nil || databaseFile.readers > 1 || (databaseFile.pending != nil && len(databaseFile.pending.dirty) > 0) {
+		return nil
+	}
+	changed, err := log.changed()
+	if err != nil || !changed {
+		return err
+	}
+
+	databaseFile.debug("write-ahead log changed, reindexing", "wal", log.path)
+	fresh, err := openWAL(databaseFile.Name(), log.flag)
+	if err != nil {
+		return err
+	}
+	if log.file != nil {
+		log.file.Close()
+	}
+	databaseFile.wal = fresh
+	return nil
+}
+
+// WALPosition marks a point in a write-ahead log: how many of its frames
+// had committed, in the log its salts identify.
+type WALPosition struct {
+	salt   [2]uint32
+	frames int
+}
+
+// WALPosition returns the log's position as of the last commit this handle
+// has read, or the zero position outside WAL mode.
+func (databaseFile *DatabaseFile) WALPosition() WALPosition {
+	log := databaseFile.wal
+	if log == nil {
+		return WALPosition{}
+	}
+	return WALPosition{salt: log.salt, frames: log.frameCount}
+}
+
+// PagesWrittenSince returns the pages that transactions committed to the
+// write-ahead log after position wrote, in page order. ok is false when the
+// log no longer tells: outside WAL mode, or once the log has started over
+// since position, after a checkpoint, so that its frames were replaced.
+func (databaseFile *DatabaseFile) PagesWrittenSince(position WALPosition) (pages []uint32, ok bool) {
+	log := databaseFile.wal
+	if log == nil || log.salt != position.salt || log.frameCount < position.frames {
+		return nil, false
+	}
+	after := walHeaderBytes + int64(position.frames)*int64(walFrameHeaderBytes+log.pageSize)
+	for pageNumber, offset := range log.frames {
+		if offset > after {
+			pages = append(pages, pageNumber)
+		}
+	}
+	slices.Sort(pages)
+	return pages, true
+}
+
+// readCommitted reads the database as of its last commit: pages with newer
+// contents in the write-ahead log are read from the log, the rest from the
+// database file.
+func (databaseFile *DatabaseFile) readCommitted(p []byte, off int64) (int, error) {
+	log := databaseFile.wal
+	if log == nil || len(log.frames) == 0 {
+		return databaseFile.storage.ReadAt(p, off)
+	}
+
+	pageSize := int64(log.pageSize)
+	read := 0
+	for read < len(p) {
+		position := off + int64(read)
+		within := position % pageSize
+		chunk := p[read : read+int(min(int64(len(p)-read), pageSize-within))]
+
+		var n int
+		var err error
+		if frame, ok := log.frames[uint32(position/pageSize)+1]; ok {
+			if databaseFile.VerifyChecksums {
+				if err := log.verifyFrame(frame); err != nil {
+					return read, err
+				}
+			}
+			n, err = log.file.ReadAt(chunk, frame+within)
+		} else {
+			n, err = databaseFile.storage.ReadAt(chunk, position)
+		}
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
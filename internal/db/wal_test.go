@@ -0,0 +1,426 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func walTestDatabase(t *testing.T) (string, *DatabaseFile, *DatabaseHeader) {
+	t.Helper()
+
+	database := testgen.New(testgen.Options{PageSize: 1024, WAL: true})
+	items := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text)")
+	for i := int64(1); i <= 100; i++ {
+		items.Insert(i, nil, "item")
+	}
+	path := database.WriteTemp(t)
+
+	dbFile, header, err := OpenWritableDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbFile.Close() })
+	return path, dbFile, header
+}
+
+// setUserVersion commits a transaction that changes the user version in the
+// header and leaves one page on the freelist, reusing the one left by an
+// earlier call.
+func setUserVersion(t *testing.T, pager *Pager, version uint32) {
+	t.Helper()
+
+	pager.Header().UserVersion = version
+	pageNumber, err := pager.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.Free(pageNumber); err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func userVersion(t *testing.T, dbFile *DatabaseFile) uint32 {
+	t.Helper()
+
+	var version [4]byte
+	if _, err := dbFile.ReadAt(version[:], 60); err != nil {
+		t.Fatal(err)
+	}
+	return binary.BigEndian.Uint32(version[:])
+}
+
+func TestWALCommitLeavesDatabaseFileUntouched(t *testing.T) {
+	path, dbFile, header := walTestDatabase(t)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageCount := header.PageCount
+
+	setUserVersion(t, NewPager(dbFile, header), 7)
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("WAL commit modified the database file")
+	}
+	// Page 1 and the new freelist trunk, in one transaction
+	info, err := os.Stat(walPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(walHeaderBytes + 2*(walFrameHeaderBytes+1024)); info.Size() != want {
+		t.Fatalf("wal size %d, want %d", info.Size(), want)
+	}
+
+	reopened, reread, err := OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := userVersion(t, reopened); got != 7 {
+		t.Fatalf("user version %d through the log, want 7", got)
+	}
+	if reread.PageCount != pageCount+1 || reread.FreelistCount != 1 {
+		t.Fatalf("header through the log: %+v", *reread)
+	}
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		if got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; PRAGMA user_version; PRAGMA freelist_count"); got != "ok\n7\n1" {
+			t.Fatalf("sqlite3 reading our log: %q", got)
+		}
+	}
+}
+
+func TestWALChecksumChainSpansTransactions(t *testing.T) {
+	path, dbFile, header := walTestDatabase(t)
+	pager := NewPager(dbFile, header)
+	for version := uint32(1); version <= 3; version++ {
+		setUserVersion(t, pager, version)
+	}
+
+	reopened, reread, err := OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.wal.frameCount != 6 {
+		t.Fatalf("%d committed frames, want 6", reopened.wal.frameCount)
+	}
+	if got := userVersion(t, reopened); got != 3 {
+		t.Fatalf("user version %d, want the last commit's 3", got)
+	}
+	if reread.FreelistCount != 1 {
+		t.Fatalf("freelist count %d, want 1", reread.FreelistCount)
+	}
+}
+
+func TestWALIgnoresUncommittedFrames(t *testing.T) {
+	path, dbFile, header := walTestDatabase(t)
+	setUserVersion(t, NewPager(dbFile, header), 1)
+	committed, err := os.ReadFile(walPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A first frame without a commit size, as if the writer crashed before
+	// reaching the commit frame
+	log := dbFile.wal
+	page := make([]byte, 1024)
+	if _, err := dbFile.ReadAt(page, 0); err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint32(page[60:64], 99)
+	frame := make([]byte, walFrameHeaderBytes, walFrameHeaderBytes+len(page))
+	binary.BigEndian.PutUint32(frame[0:4], 1)
+	binary.BigEndian.PutUint32(frame[8:12], log.salt[0])
+	binary.BigEndian.PutUint32(frame[12:16], log.salt[1])
+	checksum := walChecksum(log.bigEndian, walChecksum(log.bigEndian, log.checksum, frame[:8]), page)
+	binary.BigEndian.PutUint32(frame[16:20], checksum[0])
+	binary.BigEndian.PutUint32(frame[20:24], checksum[1])
+	if err := os.WriteFile(walPath(path), append(committed, append(frame, page...)...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, reread, err := OpenWritableDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := userVersion(t, reopened); got != 1 {
+		t.Fatalf("user version %d, want the committed 1", got)
+	}
+
+	// The next transaction overwrites the uncommitted frame
+	setUserVersion(t, NewPager(reopened, reread), 2)
+	if info, err := os.Stat(walPath(path)); err != nil || info.Size() != int64(len(committed))+2*int64(walFrameHeaderBytes+1024) {
+		t.Fatalf("wal after overwriting the uncommitted frame: %v %v", info.Size(), err)
+	}
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		if got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; PRAGMA user_version"); got != "ok\n2" {
+			t.Fatalf("sqlite3 after overwriting the uncommitted frame: %q", got)
+		}
+	}
+}
+
+func TestCheckpointCopiesFramesAndRestartsLog(t *testing.T) {
+	path, dbFile, header := walTestDatabase(t)
+	pager := NewPager(dbFile, header)
+	setUserVersion(t, pager, 1)
+	setUserVersion(t, pager, 2)
+	salt := dbFile.wal.salt
+
+	frames, err := dbFile.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != 4 {
+		t.Fatalf("checkpointed %d frames, want 4", frames)
+	}
+	if info, err := os.Stat(walPath(path)); err != nil || info.Size() != walHeaderBytes {
+		t.Fatalf("log not restarted: %v", err)
+	}
+	if dbFile.wal.salt[0] != salt[0]+1 || dbFile.wal.checkpointSequence != 1 {
+		t.Fatalf("restarted log salt %x sequence %d", dbFile.wal.salt, dbFile.wal.checkpointSequence)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.BigEndian.Uint32(contents[60:64]); got != 2 {
+		t.Fatalf("user version %d in the database file, want 2", got)
+	}
+	if len(contents) != int(header.PageCount)*1024 {
+		t.Fatalf("database file is %d bytes, want %d pages", len(contents), header.PageCount)
+	}
+
+	// Transactions after the checkpoint continue in the restarted log
+	setUserVersion(t, pager, 3)
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		if got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; PRAGMA user_version; PRAGMA freelist_count"); got != "ok\n3\n1" {
+			t.Fatalf("sqlite3 after checkpoint: %q", got)
+		}
+	}
+}
+
+func TestReadsFramesWrittenBySQLite3(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found in PATH")
+	}
+
+	// sqlite3 checkpoints and deletes the log when it closes, so copy the
+	// files while it still has them open
+	dir := t.TempDir()
+	source, path := filepath.Join(dir, "source.db"), filepath.Join(dir, "copy.db")
+	output, err := exec.Command(sqlite3, source, "PRAGMA journal_mode=WAL", "PRAGMA wal_autocheckpoint=0",
+		"CREATE TABLE t (x)", "INSERT INTO t VALUES (1), (2), (3)", "INSERT INTO t VALUES (4)",
+		".shell cp "+source+" "+path+" && cp "+walPath(source)+" "+walPath(path)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3: %v\n%s", err, output)
+	}
+
+	dbFile, header, err := OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbFile.Close()
+	if header.PageCount != 2 {
+		t.Fatalf("page count %d, want 2", header.PageCount)
+	}
+	count, err := dbFile.CountRows(header, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Fatalf("%d rows through sqlite3's log, want 4", count)
+	}
+}
+
+func TestCheckpointTruncateEmptiesLog(t *testing.T) {
+	path, dbFile, header := walTestDatabase(t)
+	pager := NewPager(dbFile, header)
+	setUserVersion(t, pager, 1)
+
+	frames, err := dbFile.CheckpointTruncate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != 2 {
+		t.Fatalf("checkpointed %d frames, want 2", frames)
+	}
+	if info, err := os.Stat(walPath(path)); err != nil || info.Size() != 0 {
+		t.Fatalf("log not truncated: %v", err)
+	}
+
+	// The next transaction starts the log over with a new header
+	setUserVersion(t, pager, 2)
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		if got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; PRAGMA user_version"); got != "ok\n2" {
+			t.Fatalf("sqlite3 after truncating checkpoint: %q", got)
+		}
+	}
+}
+
+func TestCheckpointRejectsCorruptFrames(t *testing.T) {
+	path, dbFile, header := walTestDatabase(t)
+	pager := NewPager(dbFile, header)
+	setUserVersion(t, pager, 1)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a byte in the second frame's page after the log was loaded
+	log, err := os.OpenFile(walPath(path), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := int64(walHeaderBytes + (walFrameHeaderBytes + 1024) + walFrameHeaderBytes + 100)
+	var b [1]byte
+	if _, err := log.ReadAt(b[:], offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err := log.WriteAt(b[:], offset); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+
+	if _, err := dbFile.Checkpoint(); err == nil || err.Error() != "wal frame 2: checksum mismatch" {
+		t.Fatalf("checkpoint of a corrupt log: %v", err)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("database file changed by a rejected checkpoint")
+	}
+}
+
+func TestRefreshSeesOtherWriters(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found in PATH")
+	}
+	path, dbFile, header := walTestDatabase(t)
+	count := func() int64 {
+		t.Helper()
+		if err := dbFile.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		fresh, err := dbFile.NewDatabaseHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		*header = *fresh
+		objects, err := dbFile.ReadSchema(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rootPage, err := RootPageLookup("items", objects)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := dbFile.CountRows(header, rootPage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+	if rows := count(); rows != 100 {
+		t.Fatalf("%d rows before sqlite3 writes, want 100", rows)
+	}
+
+	// sqlite3 keeps its frames in the log while it stays open
+	cmd := exec.Command(sqlite3, path, "PRAGMA wal_autocheckpoint=0", "INSERT INTO items VALUES (101, 'new')", ".shell sleep 1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// Seen while sqlite3 is still running, the rows can only have come
+	// from the log
+	seen := false
+	for range 50 {
+		if count() == 101 {
+			_, err := os.Stat(walPath(path))
+			seen = err == nil
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !seen {
+		t.Fatal("rows committed to the log by sqlite3 not seen")
+	}
+
+	// Closing, sqlite3 checkpointed and deleted the log
+	if _, err := os.Stat(walPath(path)); !os.IsNotExist(err) {
+		t.Fatalf("log still present after sqlite3 closed: %v", err)
+	}
+	if rows := count(); rows != 101 {
+		t.Fatalf("%d rows after sqlite3's checkpoint, want 101", rows)
+	}
+
+	// This handle's next transaction starts a new log that sqlite3 reads
+	pager := NewPager(dbFile, header)
+	setUserVersion(t, pager, 4)
+	if got := sqlite3Output(t, sqlite3, path, "PRAGMA integrity_check; PRAGMA user_version; SELECT count(*) FROM items"); got != "ok\n4\n101" {
+		t.Fatalf("sqlite3 after writing the new log: %q", got)
+	}
+}
+
+func TestVerifyChecksumsRejectsCorruptFrames(t *testing.T) {
+	path, dbFile, header := walTestDatabase(t)
+	setUserVersion(t, NewPager(dbFile, header), 1)
+
+	// Flip a byte in the second frame's page after the log was loaded
+	log, err := os.OpenFile(walPath(path), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := int64(walHeaderBytes + walFrameHeaderBytes + 1024)
+	var pageNumber [4]byte
+	if _, err := log.ReadAt(pageNumber[:], frame); err != nil {
+		t.Fatal(err)
+	}
+	var b [1]byte
+	if _, err := log.ReadAt(b[:], frame+walFrameHeaderBytes+100); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err := log.WriteAt(b[:], frame+walFrameHeaderBytes+100); err != nil {
+		t.Fatal(err)
+	}
+	log.Close()
+
+	page := make([]byte, 1024)
+	offset := int64(binary.BigEndian.Uint32(pageNumber[:])-1) * 1024
+	if _, err := dbFile.ReadAt(page, offset); err != nil {
+		t.Fatalf("read without verification: %v", err)
+	}
+	dbFile.VerifyChecksums = true
+	var corruption *CorruptionError
+	if _, err := dbFile.ReadAt(page, offset); !errors.As(err, &corruption) || corruption.Reason != "wal frame 2: checksum mismatch" {
+		t.Errorf("read of a corrupt frame: %v", err)
+	}
+	if got := userVersion(t, dbFile); got != 1 {
+		t.Errorf("user version %d read from the first frame, want 1", got)
+	}
+}
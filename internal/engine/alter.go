@@ -0,0 +1,433 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/xwb1989/sqlparser"
+)
+
+// alterStatement is an ALTER TABLE, which the SQL parser does not
+// understand: a rename of the table or of one of its columns, or an added
+// column.
+type alterStatement struct {
+	table string
+	// newName is the table's new name, for RENAME TO
+	newName string
+	// column becomes newColumn, for RENAME COLUMN
+	column    string
+	newColumn string
+	// definition is the added column's definition as written, for ADD
+	// COLUMN
+	definition string
+}
+
+// parseAlter recognises an ALTER TABLE statement, reporting false for
+// anything else.
+func parseAlter(query string) (*alterStatement, bool, error) {
+	text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	tokens := definitionTokens(text)
+	if len(tokens) == 0 || !strings.EqualFold(tokens[0], "ALTER") {
+		return nil, false, nil
+	}
+	malformed := fmt.Errorf("parse query: unsupported ALTER TABLE statement %q", query)
+	if len(tokens) < 5 || !strings.EqualFold(tokens[1], "TABLE") {
+		return nil, true, malformed
+	}
+
+	statement := &alterStatement{table: unqualifiedName(unquoteIdentifier(tokens[2]))}
+	rest := tokens[4:]
+	switch strings.ToUpper(tokens[3]) {
+	case "RENAME":
+		if len(rest) == 2 && strings.EqualFold(rest[0], "TO") {
+			statement.newName = unquoteIdentifier(rest[1])
+			return statement, true, nil
+		}
+		if strings.EqualFold(rest[0], "COLUMN") {
+			rest = rest[1:]
+		}
+		if len(rest) == 3 && strings.EqualFold(rest[1], "TO") {
+			statement.column, statement.newColumn = unquoteIdentifier(rest[0]), unquoteIdentifier(rest[2])
+			return statement, true, nil
+		}
+	case "ADD":
+		skip := 4
+		if strings.EqualFold(rest[0], "COLUMN") {
+			skip++
+		}
+		// The definition is kept as written, since it is spliced into the
+		// table's CREATE TABLE statement
+		offset := 0
+		for _, token := range tokens[:skip] {
+			offset += strings.Index(text[offset:], token) + len(token)
+		}
+		if statement.definition = strings.TrimSpace(text[offset:]); statement.definition != "" {
+			return statement, true, nil
+		}
+	}
+	return nil, true, malformed
+}
+
+// alter executes an ALTER TABLE by rewriting the statements stored in
+// sqlite_schema; no table data changes. The schema cookie is incremented
+// so other connections reparse the schema.
+func (database *Database) alter(statement *alterStatement) error {
+	if isSchemaTable(statement.table) {
+		return fmt.Errorf("table %s may not be altered", statement.table)
+	}
+	table, err := database.TableSchema(statement.table)
+	if err != nil {
+		return err
+	}
+	objects, err := database.SchemaObjects()
+	if err != nil {
+		return err
+	}
+
+	var changed []db.TableMetadata
+	switch {
+	case statement.definition != "":
+		changed, err = database.addColumn(table, objects, statement.definition)
+	case statement.newName != "":
+		changed, err = renameTable(table, objects, statement.newName)
+	default:
+		changed, err = renameColumn(table, objects, statement.column, statement.newColumn)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = database.write(func(pager *db.Pager) error {
+		for _, object := range changed {
+			if err := pager.InsertRow(1, object.RowID, db.EncodeSchemaRecord(object)); err != nil {
+				return fmt.Errorf("sqlite_schema: %w", err)
+			}
+		}
+		pager.Header().SchemaCookie++
+		return nil
+	})
+	database.forgetSchemas()
+	return err
+}
+
+// tableObject returns the schema row defining a table.
+func tableObject(objects []db.TableMetadata, table *TableSchema) (db.TableMetadata, error) {
+	for _, object := range objects {
+		if object.Type == "table" && strings.EqualFold(object.Name, table.Name) {
+			return object, nil
+		}
+	}
+	return db.TableMetadata{}, fmt.Errorf("%w: %s", ErrNoSuchTable, table.Name)
+}
+
+// addColumn appends a column definition to a table's CREATE TABLE
+// statement. Existing rows read the column's default, so it is refused
+// when it could not hold for them as SQLite would: a key column, a NOT
+// NULL column defaulting to NULL, or a default that is not a constant.
+func (database *Database) addColumn(table *TableSchema, objects []db.TableMetadata, definition string) ([]db.TableMetadata, error) {
+	name, rest := splitIdentifier(definition)
+	column, foreignKeys, _ := parseColumnDefinition(name, rest)
+	if _, exists := table.ColumnIndex(column.Name); exists {
+		return nil, fmt.Errorf("duplicate column name: %s", column.Name)
+	}
+	if column.PrimaryKey {
+		return nil, errors.New("Cannot add a PRIMARY KEY column")
+	}
+	if column.Unique {
+		return nil, errors.New("Cannot add a UNIQUE column")
+	}
+
+	var value any
+	if column.Default != "" {
+		expr, err := parseExpression(column.Default)
+		if err == nil && !isConstantExpression(expr) {
+			err = errors.New("not constant")
+		}
+		if err == nil {
+			value, err = evaluate(expr, scope{column: noColumns})
+		}
+		if err != nil {
+			return nil, errors.New("Cannot add a column with non-constant default")
+		}
+	}
+	if column.NotNull && value == nil {
+		return nil, errors.New("Cannot add a NOT NULL column with default value NULL")
+	}
+	if len(foreignKeys) > 0 && value != nil && database.foreignKeys {
+		return nil, errors.New("Cannot add a REFERENCES column with non-NULL default value")
+	}
+
+	object, err := tableObject(objects, table)
+	if err != nil {
+		return nil, err
+	}
+	end := strings.LastIndex(object.SQL, ")")
+	if end < 0 {
+		return nil, fmt.Errorf("table %s: malformed CREATE TABLE statement", table.Name)
+	}
+	object.SQL = object.SQL[:end] + ", " + definition + object.SQL[end:]
+	return []db.TableMetadata{object}, nil
+}
+
+// isConstantExpression reports whether a DEFAULT is a literal, optionally
+// signed, which is all ALTER TABLE ADD COLUMN accepts.
+func isConstantExpression(expr sqlparser.Expr) bool {
+	switch expr := expr.(type) {
+	case *sqlparser.SQLVal, *sqlparser.NullVal, sqlparser.BoolVal:
+		return true
+	case *sqlparser.UnaryExpr:
+		_, literal := expr.Expr.(*sqlparser.SQLVal)
+		return literal && (expr.Operator == sqlparser.UMinusStr || expr.Operator == sqlparser.UPlusStr)
+	}
+	return false
+}
+
+// renameTable renames a table along with its indexes and every foreign key
+// referring to it. Like SQLite, the new name is written quoted.
+func renameTable(table *TableSchema, objects []db.TableMetadata, newName string) ([]db.TableMetadata, error) {
+	if strings.HasPrefix(strings.ToLower(newName), "sqlite_") {
+		return nil, fmt.Errorf("object name reserved for internal use: %s", newName)
+	}
+	for _, object := range objects {
+		if strings.EqualFold(object.Name, newName) && !strings.EqualFold(object.Name, table.Name) {
+			return nil, fmt.Errorf("there is already another table or index with this name: %s", newName)
+		}
+	}
+
+	oldAutoindex := "sqlite_autoindex_" + strings.ToLower(table.Name) + "_"
+	var changed []db.TableMetadata
+	for _, object := range objects {
+		own := strings.EqualFold(object.TableName, table.Name)
+		sql := renameTableReferences(object.SQL, object.Type, table.Name, newName)
+		if !own && sql == object.SQL {
+			continue
+		}
+		object.SQL = sql
+		if own {
+			object.TableName = newName
+			if object.Type == "table" {
+				object.Name = newName
+			} else if suffix, ok := strings.CutPrefix(strings.ToLower(object.Name), oldAutoindex); ok {
+				object.Name = "sqlite_autoindex_" + newName + "_" + suffix
+			}
+		}
+		changed = append(changed, object)
+	}
+	return changed, nil
+}
+
+// renameColumn renames a column wherever the schema refers to it: in its
+// table's definition, in the table's indexes, and in foreign keys that
+// refer to it from other tables.
+func renameColumn(table *TableSchema, objects []db.TableMetadata, column, newColumn string) ([]db.TableMetadata, error) {
+	position, ok := table.ColumnIndex(column)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrNoSuchColumn, column)
+	}
+	if other, exists := table.ColumnIndex(newColumn); exists && other != position {
+		return nil, fmt.Errorf("error in table %s after rename: duplicate column name: %s", table.Name, newColumn)
+	}
+
+	var changed []db.TableMetadata
+	for _, object := range objects {
+		own := strings.EqualFold(object.TableName, table.Name)
+		sql := renameColumnReferences(object.SQL, object.Type, own, table.Name, table.Columns[position].Name, newColumn)
+		if sql != object.SQL {
+			object.SQL = sql
+			changed = append(changed, object)
+		}
+	}
+	return changed, nil
+}
+
+// sqlToken is a token of a schema statement, located by byte offsets so
+// that renames can rewrite identifiers in place.
+type sqlToken struct {
+	start, end int
+	// name is the identifier a word or quoted identifier spells, and empty
+	// for strings, numbers and punctuation
+	name   string
+	quoted bool
+}
+
+func (token sqlToken) keyword(word string) bool {
+	return !token.quoted && strings.EqualFold(token.name, word)
+}
+
+func isIdentifierByte(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80 || !first && (c >= '0' && c <= '9' || c == '$')
+}
+
+// scanSQL splits a statement into tokens, skipping whitespace and comments.
+func scanSQL(sql string) []sqlToken {
+	var tokens []sqlToken
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		token := sqlToken{start: i}
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closer := c
+			if c == '[' {
+				closer = ']'
+			}
+			var name strings.Builder
+			for i++; i < len(sql); i++ {
+				if sql[i] == closer {
+					// A doubled quote is an escaped quote, not the end
+					if closer != ']' && i+1 < len(sql) && sql[i+1] == closer {
+						name.WriteByte(closer)
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				name.WriteByte(sql[i])
+			}
+			if c != '\'' {
+				token.name, token.quoted = name.String(), true
+			}
+		case isIdentifierByte(c, true):
+			for i < len(sql) && isIdentifierByte(sql[i], false) {
+				i++
+			}
+			token.name = sql[token.start:i]
+		case c >= '0' && c <= '9':
+			for i < len(sql) && (isIdentifierByte(sql[i], false) || sql[i] == '.') {
+				i++
+			}
+		default:
+			i++
+		}
+		token.end = i
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// objectNameToken returns the position of the name in a CREATE TABLE or
+// CREATE INDEX statement's tokens, or -1.
+func objectNameToken(tokens []sqlToken) int {
+	for i, token := range tokens {
+		if token.keyword("TABLE") || token.keyword("INDEX") {
+			if i+3 < len(tokens) && tokens[i+1].keyword("IF") && tokens[i+2].keyword("NOT") && tokens[i+3].keyword("EXISTS") {
+				i += 3
+			}
+			if i+1 < len(tokens) {
+				return i + 1
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
+// rewriteTokens replaces the tokens at the given positions.
+func rewriteTokens(sql string, tokens []sqlToken, replacements map[int]string) string {
+	var b strings.Builder
+	last := 0
+	for i, token := range tokens {
+		if replacement, ok := replacements[i]; ok {
+			b.WriteString(sql[last:token.start])
+			b.WriteString(replacement)
+			last = token.end
+		}
+	}
+	b.WriteString(sql[last:])
+	return b.String()
+}
+
+// quoteIdentifier quotes a name as an SQL identifier.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// renameTableReferences rewrites a schema statement's references to a
+// table: the name a CREATE TABLE defines, the table a CREATE INDEX is on,
+// and REFERENCES clauses.
+func renameTableReferences(sql, objectType, oldName, newName string) string {
+	tokens := scanSQL(sql)
+	nameToken := objectNameToken(tokens)
+	replacements := make(map[int]string)
+	for i, token := range tokens {
+		if token.name == "" || !strings.EqualFold(token.name, oldName) || i == 0 {
+			continue
+		}
+		previous := tokens[i-1]
+		switch {
+		case objectType == "table" && i == nameToken,
+			objectType == "index" && previous.keyword("ON"),
+			previous.keyword("REFERENCES"):
+			replacements[i] = quoteIdentifier(newName)
+		}
+	}
+	return rewriteTokens(sql, tokens, replacements)
+}
+
+// renameColumnReferences rewrites a schema statement's references to a
+// column of table. In the table's own CREATE TABLE that is every mention
+// of the column outside foreign keys to other tables; in its indexes, the
+// indexed columns; and elsewhere, the parent columns of REFERENCES clauses
+// naming the table.
+func renameColumnReferences(sql, objectType string, own bool, table, oldName, newName string) string {
+	tokens := scanSQL(sql)
+	nameToken := objectNameToken(tokens)
+	replacements := make(map[int]string)
+	rename := func(i int) {
+		if tokens[i].name == "" || !strings.EqualFold(tokens[i].name, oldName) {
+			return
+		}
+		replacement := newName
+		if tokens[i].quoted || strings.IndexFunc(newName, func(r rune) bool { return r < 0x80 && !isIdentifierByte(byte(r), false) }) >= 0 || newName == "" || !isIdentifierByte(newName[0], true) {
+			replacement = quoteIdentifier(newName)
+		}
+		replacements[i] = replacement
+	}
+
+	inIndexColumns := false
+	for i := nameToken + 1; nameToken >= 0 && i < len(tokens); i++ {
+		token := tokens[i]
+		if objectType == "index" {
+			if token.keyword("ON") {
+				// The indexed table's name follows
+				inIndexColumns = own
+				i++
+			} else if inIndexColumns {
+				rename(i)
+			}
+			continue
+		}
+		if objectType != "table" {
+			continue
+		}
+
+		if token.keyword("REFERENCES") && i+1 < len(tokens) {
+			parent := strings.EqualFold(tokens[i+1].name, table)
+			i += 2
+			if i < len(tokens) && sql[tokens[i].start] == '(' {
+				for ; i < len(tokens) && sql[tokens[i].start] != ')'; i++ {
+					if parent {
+						rename(i)
+					}
+				}
+			} else {
+				i--
+			}
+			continue
+		}
+		if own {
+			rename(i)
+		}
+	}
+	return rewriteTokens(sql, tokens, replacements)
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+// alterableDatabase generates a table with an autoindex and an explicit
+// index, and a second table whose foreign keys refer to it.
+func alterableDatabase(t *testing.T) string {
+	t.Helper()
+
+	database := testgen.New(testgen.Options{})
+	table := database.CreateTable("t", "CREATE TABLE t (id integer primary key, a text unique, b)")
+	table.Insert(1, nil, "one", int64(10))
+	database.CreateIndex("sqlite_autoindex_t_1", table, "", 1)
+	database.CreateIndex("ib", table, "CREATE INDEX ib on t(b)", 2)
+	database.CreateTable("c", "CREATE TABLE c (x references t(id), y REFERENCES t, b)")
+	return database.WriteTemp(t)
+}
+
+func TestAlterTableRewritesSchema(t *testing.T) {
+	path := alterableDatabase(t)
+	database := openDatabase(t, path)
+
+	for _, query := range []string{
+		"ALTER TABLE t ADD COLUMN d int default 7",
+		"ALTER TABLE t ADD e text not null default  'x'",
+		`ALTER TABLE t ADD COLUMN "f g"`,
+		"ALTER TABLE t RENAME TO u",
+		"ALTER TABLE u RENAME COLUMN b TO bb",
+		"ALTER TABLE c RENAME b TO cb",
+	} {
+		if err := execute(t, database, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	got := queryRows(t, database, "SELECT type, name, tbl_name, sql FROM sqlite_schema")
+	want := [][]any{
+		{"table", "u", "u", `CREATE TABLE "u" (id integer primary key, a text unique, bb, d int default 7, e text not null default  'x', "f g")`},
+		{"table", "c", "c", `CREATE TABLE c (x references "u"(id), y REFERENCES "u", cb)`},
+		{"index", "sqlite_autoindex_u_1", "u", nil},
+		{"index", "ib", "u", `CREATE INDEX ib on "u"(bb)`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("schema after ALTER TABLE\n got %v\nwant %v", got, want)
+	}
+
+	// The existing row reads the added columns' defaults
+	if rows := queryRows(t, database, "SELECT id, a, bb, d, e FROM u WHERE bb = 10"); !reflect.DeepEqual(rows, [][]any{{int64(1), "one", int64(10), int64(7), "x"}}) {
+		t.Fatalf("row after ALTER TABLE = %v", rows)
+	}
+	if rows := queryRows(t, database, "PRAGMA schema_version"); rows[0][0] != int64(7) {
+		t.Fatalf("schema_version %v after six changes, want 7", rows[0][0])
+	}
+	if err := execute(t, database, "INSERT INTO u (a, bb) VALUES ('two', 20)"); err != nil {
+		t.Fatalf("insert after ALTER TABLE: %v", err)
+	}
+	database.Close()
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		output, err := exec.Command(sqlite3, path, "PRAGMA integrity_check", "SELECT a, d, e FROM u WHERE bb = 20").Output()
+		if err != nil || string(output) != "ok\ntwo|7|x\n" {
+			t.Fatalf("sqlite3 after ALTER TABLE: %q %v", output, err)
+		}
+	}
+}
+
+func TestAlterTableErrors(t *testing.T) {
+	database := openDatabase(t, alterableDatabase(t))
+
+	tests := []struct {
+		query, err string
+	}{
+		{"ALTER TABLE t ADD COLUMN d unique", "Cannot add a UNIQUE column"},
+		{"ALTER TABLE t ADD COLUMN d integer primary key", "Cannot add a PRIMARY KEY column"},
+		{"ALTER TABLE t ADD COLUMN d not null", "Cannot add a NOT NULL column with default value NULL"},
+		{"ALTER TABLE t ADD COLUMN d default (1+1)", "Cannot add a column with non-constant default"},
+		{"ALTER TABLE t ADD COLUMN d default current_time", "Cannot add a column with non-constant default"},
+		{"ALTER TABLE t ADD COLUMN A", "duplicate column name: A"},
+		{"ALTER TABLE t RENAME TO c", "there is already another table or index with this name: c"},
+		{"ALTER TABLE t RENAME TO ib", "there is already another table or index with this name: ib"},
+		{"ALTER TABLE t RENAME zz TO z", `no such column: "zz"`},
+		{"ALTER TABLE t RENAME COLUMN a TO b", "error in table t after rename: duplicate column name: b"},
+		{"ALTER TABLE nosuch RENAME TO x", "no such table: nosuch"},
+		{"ALTER TABLE sqlite_master RENAME TO x", "table sqlite_master may not be altered"},
+	}
+	for _, test := range tests {
+		err := execute(t, database, test.query)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: error %v, want %q", test.query, err, test.err)
+		}
+	}
+	if rows := queryRows(t, database, "PRAGMA schema_version"); rows[0][0] != int64(1) {
+		t.Fatalf("schema_version %v after failed changes, want 1", rows[0][0])
+	}
+}
@@ -0,0 +1,289 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// statTableSQL is the statement SQLite creates sqlite_stat1 with.
+const statTableSQL = "CREATE TABLE sqlite_stat1(tbl,idx,stat)"
+
+// analyzeStatement is an ANALYZE, which the SQL parser does not
+// understand, of every table or of the named table or index.
+type analyzeStatement struct {
+	target string
+}
+
+// parseAnalyze recognises an ANALYZE statement, reporting false for
+// anything else.
+func parseAnalyze(query string) (*analyzeStatement, bool, error) {
+	text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	tokens := definitionTokens(text)
+	if len(tokens) == 0 || !strings.EqualFold(tokens[0], "ANALYZE") {
+		return nil, false, nil
+	}
+	switch len(tokens) {
+	case 1:
+		return &analyzeStatement{}, true, nil
+	case 2:
+		name := unquoteIdentifier(tokens[1])
+		// The main schema is the only one, so naming it analyzes everything
+		if strings.EqualFold(name, "main") {
+			name = ""
+		}
+		return &analyzeStatement{target: unqualifiedName(name)}, true, nil
+	}
+	return nil, true, fmt.Errorf("parse query: unsupported ANALYZE statement %q", query)
+}
+
+// statRow is a row of sqlite_stat1. Index is empty for the row counting a
+// table without indexes.
+type statRow struct {
+	table, index, stat string
+}
+
+// analyze gathers statistics on the chosen tables and indexes and stores
+// them in sqlite_stat1, creating it on first use, in place of any earlier
+// rows for the same tables and indexes. Each index's row holds its number
+// of entries, then the average number of entries sharing each prefix of
+// its key, rounded up; a table without indexes gets its row count.
+func (database *Database) analyze(statement *analyzeStatement) error {
+	objects, err := database.SchemaObjects()
+	if err != nil {
+		return err
+	}
+
+	var stat *db.TableMetadata
+	var tables []string
+	indexTarget := ""
+	for i, object := range objects {
+		switch {
+		case object.Type == "table" && strings.EqualFold(object.Name, "sqlite_stat1"):
+			stat = &objects[i]
+		case strings.HasPrefix(strings.ToLower(object.Name), "sqlite_") && object.Type == "table":
+		case statement.target == "" && object.Type == "table",
+			strings.EqualFold(object.Name, statement.target) && object.Type == "table":
+			tables = append(tables, object.Name)
+		case strings.EqualFold(object.Name, statement.target) && object.Type == "index":
+			tables, indexTarget = append(tables, object.TableName), object.Name
+		}
+	}
+	if statement.target != "" && len(tables) == 0 {
+		return fmt.Errorf("%w: %s", ErrNoSuchTable, statement.target)
+	}
+
+	var rows []statRow
+	for _, name := range tables {
+		table, err := database.TableSchema(name)
+		if err != nil {
+			return err
+		}
+		gathered, err := database.tableStatistics(table, indexTarget)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, gathered...)
+	}
+
+	err = database.write(func(pager *db.Pager) error {
+		if stat == nil {
+			root, err := pager.CreateBTree(db.LeafTable)
+			if err != nil {
+				return err
+			}
+			stat = &db.TableMetadata{Type: "table", Name: "sqlite_stat1", TableName: "sqlite_stat1", RootPage: root, SQL: statTableSQL}
+			if stat.RowID, err = pager.MaxRowID(1); err != nil {
+				return err
+			}
+			stat.RowID++
+			if err := pager.InsertRow(1, stat.RowID, db.EncodeSchemaRecord(*stat)); err != nil {
+				return fmt.Errorf("sqlite_schema: %w", err)
+			}
+			pager.Header().SchemaCookie++
+		}
+
+		// Earlier rows for what is being analyzed are replaced
+		var stale []int64
+		err := database.scanStatistics(stat.RootPage, func(rowID int64, row statRow) {
+			for _, name := range tables {
+				if strings.EqualFold(row.table, name) && (indexTarget == "" || strings.EqualFold(row.index, indexTarget)) {
+					stale = append(stale, rowID)
+					return
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		for _, rowID := range stale {
+			if err := pager.DeleteRow(stat.RootPage, rowID); err != nil {
+				return err
+			}
+		}
+
+		for _, row := range rows {
+			rowID, err := pager.MaxRowID(stat.RootPage)
+			if err != nil {
+				return err
+			}
+			var index db.Value
+			if row.index != "" {
+				index = row.index
+			}
+			if err := pager.InsertRow(stat.RootPage, rowID+1, db.EncodeRecord([]db.Value{row.table, index, row.stat})); err != nil {
+				return fmt.Errorf("sqlite_stat1: %w", err)
+			}
+		}
+		return nil
+	})
+	database.forgetSchemas()
+	return err
+}
+
+// tableStatistics computes the sqlite_stat1 rows for a table's indexes, or
+// just the one named by only. An empty table has none.
+func (database *Database) tableStatistics(table *TableSchema, only string) ([]statRow, error) {
+	if len(table.Indexes) == 0 {
+		count, err := database.file.CountRows(database.header, table.RootPage)
+		if err != nil || count == 0 {
+			return nil, err
+		}
+		return []statRow{{table: table.Name, stat: strconv.FormatInt(count, 10)}}, nil
+	}
+
+	var rows []statRow
+	for _, index := range table.Indexes {
+		if only != "" && !strings.EqualFold(index.Name, only) {
+			continue
+		}
+		stats, err := database.indexStatistics(index)
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", index.Name, err)
+		}
+		if stats == nil {
+			continue
+		}
+		fields := make([]string, len(stats))
+		for i, stat := range stats {
+			fields[i] = strconv.FormatInt(stat, 10)
+		}
+		rows = append(rows, statRow{table: table.Name, index: index.Name, stat: strings.Join(fields, " ")})
+	}
+	return rows, nil
+}
+
+// indexStatistics walks an index in key order, counting its entries and
+// the distinct values of each prefix of its key, with NULLs equal to each
+// other as SQLite counts them. It returns nil for an empty index.
+func (database *Database) indexStatistics(index IndexSchema) ([]int64, error) {
+	cursor := database.file.NewCursor(database.header, index.RootPage)
+	if err := cursor.First(); err != nil {
+		return nil, err
+	}
+
+	var entries int64
+	distinct := make([]int64, len(index.Columns))
+	var previous []db.Value
+	for cursor.Valid() {
+		cell, err := cursor.IndexCell()
+		if err != nil {
+			return nil, err
+		}
+		key, err := cell.Key()
+		if err != nil {
+			return nil, err
+		}
+		if len(key.Values) < len(index.Columns) {
+			return nil, fmt.Errorf("entry for rowid %d has %d columns", key.RowID, len(key.Values))
+		}
+
+		// A change in one column starts a new value of every longer prefix
+		changed := entries == 0
+		for i := range distinct {
+			if !changed && db.CompareValues(previous[i], key.Values[i], index.Collations[i]) != 0 {
+				changed = true
+			}
+			if changed {
+				distinct[i]++
+			}
+		}
+		previous = key.Values
+		entries++
+
+		if err := cursor.Next(); err != nil {
+			return nil, err
+		}
+	}
+	if entries == 0 {
+		return nil, nil
+	}
+
+	stats := []int64{entries}
+	for _, count := range distinct {
+		stats = append(stats, (entries+count-1)/count)
+	}
+	return stats, nil
+}
+
+// scanStatistics calls visit for each row of the sqlite_stat1 table rooted
+// at rootPage.
+func (database *Database) scanStatistics(rootPage uint32, visit func(rowID int64, row statRow)) error {
+	cursor := database.file.NewCursor(database.header, rootPage)
+	if err := cursor.First(); err != nil {
+		return err
+	}
+	for cursor.Valid() {
+		row, err := cursor.Row()
+		if err != nil {
+			return fmt.Errorf("sqlite_stat1: %w", err)
+		}
+		var values [3]string
+		for i := range min(len(row.Columns), len(values)) {
+			if text, ok := row.Columns[i].DecodedValue.(string); ok {
+				values[i] = text
+			}
+		}
+		visit(row.RowID, statRow{table: values[0], index: values[1], stat: values[2]})
+		if err := cursor.Next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadStatistics attaches the sqlite_stat1 entries for a table's indexes,
+// when ANALYZE has been run. Words after the numbers, which newer SQLite
+// versions add, are ignored.
+func (database *Database) loadStatistics(objects []db.TableMetadata, table *TableSchema) error {
+	if len(table.Indexes) == 0 {
+		return nil
+	}
+	for _, object := range objects {
+		if object.Type != "table" || !strings.EqualFold(object.Name, "sqlite_stat1") {
+			continue
+		}
+		return database.scanStatistics(object.RootPage, func(rowID int64, row statRow) {
+			if !strings.EqualFold(row.table, table.Name) {
+				return
+			}
+			for i := range table.Indexes {
+				if !strings.EqualFold(table.Indexes[i].Name, row.index) {
+					continue
+				}
+				var stats []int64
+				for _, field := range strings.Fields(row.stat) {
+					stat, err := strconv.ParseInt(field, 10, 64)
+					if err != nil {
+						break
+					}
+					stats = append(stats, stat)
+				}
+				table.Indexes[i].Stats = stats
+			}
+		})
+	}
+	return nil
+}
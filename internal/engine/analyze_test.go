@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"fmt"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+// statisticsDatabase generates a table with a composite and a single-column
+// index, a table with an automatic index, one without indexes and one that
+// is empty.
+func statisticsDatabase(t *testing.T) string {
+	t.Helper()
+
+	database := testgen.New(testgen.Options{PageSize: 512})
+	items := database.CreateTable("t", "CREATE TABLE t (id integer primary key, a, b)")
+	for i := int64(1); i <= 300; i++ {
+		var a any = i % 4
+		if i%25 == 0 {
+			a = nil
+		}
+		items.Insert(i, nil, a, fmt.Sprintf("item %d", i))
+	}
+	database.CreateIndex("iab", items, "CREATE INDEX iab on t(a, b)", 1, 2)
+	database.CreateIndex("ib", items, "CREATE INDEX ib on t(b)", 2)
+	plain := database.CreateTable("n", "CREATE TABLE n (y)")
+	for i := int64(1); i <= 3; i++ {
+		plain.Insert(i, i)
+	}
+	database.CreateTable("e", "CREATE TABLE e (x)")
+	keyed := database.CreateTable("u", "CREATE TABLE u (p text primary key, q)")
+	keyed.Insert(1, "a", int64(1))
+	database.CreateIndex("sqlite_autoindex_u_1", keyed, "", 0)
+	return database.WriteTemp(t)
+}
+
+func TestAnalyzeMatchesSQLite(t *testing.T) {
+	path := statisticsDatabase(t)
+	database := openDatabase(t, path)
+
+	// Without statistics, the first filter's index is used
+	lookup := func() string {
+		parsed, err := parseSelect("SELECT id FROM t WHERE a = 1 AND b = 'item 5'")
+		if err != nil {
+			t.Fatal(err)
+		}
+		table, err := database.TableSchema("t")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return planSelect(parsed, table).index.Name
+	}
+	if index := lookup(); index != "iab" {
+		t.Fatalf("index %s chosen without statistics, want iab", index)
+	}
+
+	for _, query := range []string{"ANALYZE", "ANALYZE t", "ANALYZE main.iab"} {
+		if err := execute(t, database, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	if err := execute(t, database, "ANALYZE nosuch"); err == nil || err.Error() != "no such table: nosuch" {
+		t.Fatalf("ANALYZE of a missing table: %v", err)
+	}
+	if rows := queryRows(t, database, "PRAGMA schema_version"); rows[0][0] != int64(2) {
+		t.Fatalf("schema_version %v, want 2 after creating sqlite_stat1", rows[0][0])
+	}
+	got := queryRows(t, database, "SELECT idx, stat FROM sqlite_stat1 WHERE tbl = 't'")
+	if want := [][]any{{"ib", "300 1"}, {"iab", "300 60 1"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("statistics for t\n got %v\nwant %v", got, want)
+	}
+	// ib matches one row per value to iab's 60
+	if index := lookup(); index != "ib" {
+		t.Fatalf("index %s chosen with statistics, want ib", index)
+	}
+	database.Close()
+
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return
+	}
+	query := "SELECT tbl, idx, stat FROM sqlite_stat1 ORDER BY tbl, idx"
+	ours, err := exec.Command(sqlite3, path, "PRAGMA integrity_check", query).Output()
+	if err != nil {
+		t.Fatalf("sqlite3 after ANALYZE: %q %v", ours, err)
+	}
+	theirs, err := exec.Command(sqlite3, path, "ANALYZE", query).Output()
+	if err != nil || "ok\n"+string(theirs) != string(ours) {
+		t.Fatalf("sqlite_stat1 after ANALYZE\n ours %q\nsqlite %q (%v)", ours, theirs, err)
+	}
+}
@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// collatedDatabase has sqlite3 build a database whose columns and indexes
+// collate text NOCASE and RTRIM, since testgen sorts every index BINARY.
+func collatedDatabase(t *testing.T, sqlite3 string) string {
+	t.Helper()
+
+	var script strings.Builder
+	script.WriteString(`CREATE TABLE people (id integer primary key, name text COLLATE NOCASE, code text, tag text COLLATE RTRIM);
+CREATE INDEX people_name ON people (name);
+CREATE INDEX people_code ON people (code COLLATE NOCASE);
+CREATE TABLE teams (id integer primary key, lead text COLLATE NOCASE);
+CREATE INDEX teams_lead ON teams (lead);
+`)
+	names := []string{"alice", "Alice", "ALICE", "bob", "Bob", "carol", "Zed", "zed", "émile", "Émile"}
+	for i := 1; i <= 60; i++ {
+		name := names[i%len(names)]
+		fmt.Fprintf(&script, "INSERT INTO people VALUES (%d, '%s', '%s', '%s');\n", i, name, strings.ToUpper(name[:2])+strings.Repeat("x", i%3), strings.Repeat(" ", i%2)+"t"+strings.Repeat(" ", i%3))
+	}
+	for i, lead := range []string{"BOB", "zed", "Carol", "dave"} {
+		fmt.Fprintf(&script, "INSERT INTO teams VALUES (%d, '%s');\n", i+1, lead)
+	}
+
+	path := filepath.Join(t.TempDir(), "collated.db")
+	cmd := exec.Command(sqlite3, path)
+	cmd.Stdin = strings.NewReader(script.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("sqlite3: %v\n%s", err, output)
+	}
+	return path
+}
+
+func TestCollationsMatchSQLite(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	path := collatedDatabase(t, sqlite3)
+
+	for _, query := range []string{
+		// The NOCASE index answers a NOCASE column's equality
+		"SELECT id, name FROM people WHERE name = 'ALICE'",
+		"SELECT count(*) FROM people WHERE name IN ('bob', 'ZED')",
+		"SELECT id FROM people WHERE name = 'Bob  ' COLLATE rtrim",
+		// A BINARY column cannot use its NOCASE index
+		"SELECT id, code FROM people WHERE code = 'AL'",
+		"SELECT id FROM people WHERE code = 'al' COLLATE nocase",
+		"SELECT id FROM people WHERE 'BOB' = name",
+		"SELECT id FROM people WHERE tag = ' t'",
+		"SELECT min(name) FROM people",
+		"SELECT max(name) FROM people",
+		"SELECT min(code) FROM people",
+		"SELECT max(tag) FROM people",
+		"SELECT id, rank() OVER (PARTITION BY name ORDER BY tag) FROM people",
+	} {
+		rows, err := runQuery(openDatabase(t, path), query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		var got strings.Builder
+		for _, row := range rows {
+			fields := make([]string, len(row))
+			for i, value := range row {
+				fields[i] = fmt.Sprint(value)
+			}
+			fmt.Fprintln(&got, strings.Join(fields, "|"))
+		}
+		want, err := exec.Command(sqlite3, path, query).Output()
+		if err != nil {
+			t.Fatalf("sqlite3 %s: %v", query, err)
+		}
+		if got.String() != string(want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", query, got.String(), want)
+		}
+	}
+}
+
+func TestJoinComparesByCollation(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	path := collatedDatabase(t, sqlite3)
+
+	query := "SELECT people.id, teams.id FROM people JOIN teams ON people.name = teams.lead"
+	rows, err := runQuery(openDatabase(t, path), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command(sqlite3, path, query).Output()
+	if err != nil {
+		t.Fatalf("sqlite3: %v", err)
+	}
+	want := strings.Split(strings.TrimSpace(string(output)), "\n")
+	slices.Sort(want)
+	if got := sortedLines(rows); !slices.Equal(got, want) || len(got) == 0 {
+		t.Errorf("joined %v, want %v", got, want)
+	}
+
+	if _, err := runQuery(openDatabase(t, path), "SELECT people.id FROM people JOIN teams ON people.code = teams.lead"); err == nil || err.Error() != "unsupported join: people.code and teams.lead collate differently" {
+		t.Errorf("joining columns collated differently: error %v", err)
+	}
+}
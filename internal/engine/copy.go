@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// copyBatchRows is how many rows CopyTo writes to the destination in each
+// transaction.
+var copyBatchRows = 1000
+
+// CopyTo copies the rows of the named tables, or of every table when none
+// are named, into destination, each with its rowid, as INSERT would write
+// them there: through destination's write path, so its constraints, update
+// hook and indexes all apply. A table destination does not have is created
+// first from the CREATE TABLE statement that made it here; its indexes are
+// not, as the engine cannot build one, so create them with sqlite3 after.
+// Rows are streamed from this database under one read lock per table and
+// written in transactions of copyBatchRows rows, so neither database holds
+// a whole table in memory; progress, when set, is called after each with
+// the table and how many of its rows have been copied. A row that breaks a
+// constraint of destination's, such as a rowid it already holds, fails the
+// copy, leaving the transactions before it committed.
+func (database *Database) CopyTo(destination *Database, tables []string, progress func(table string, rows int64)) error {
+	if destination == database || database.Path() != "" && database.Path() == destination.Path() {
+		return errors.New("cannot copy a database into itself")
+	}
+	if database.transaction != nil || destination.transaction != nil {
+		return errors.New("cannot copy within a transaction")
+	}
+	objects, err := database.copiedObjects(tables)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if err := database.copyTable(destination, object, progress); err != nil {
+			return fmt.Errorf("copy %s: %w", object.Name, err)
+		}
+	}
+	return nil
+}
+
+// copiedObjects returns the schema objects of the tables CopyTo copies,
+// in schema order when none are named and in the order named otherwise.
+func (database *Database) copiedObjects(tables []string) ([]db.TableMetadata, error) {
+	if err := database.file.LockShared(); err != nil {
+		return nil, err
+	}
+	defer database.file.UnlockShared()
+	if err := database.verifySchema(); err != nil {
+		return nil, err
+	}
+	objects, err := database.SchemaObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tables) == 0 {
+		var copied []db.TableMetadata
+		for _, object := range objects {
+			if isRowIDTable(object) {
+				copied = append(copied, object)
+			}
+		}
+		return copied, nil
+	}
+	copied := make([]db.TableMetadata, 0, len(tables))
+	for _, name := range tables {
+		i := -1
+		for j, object := range objects {
+			if object.Type == "table" && strings.EqualFold(object.Name, name) {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNoSuchTable, name)
+		}
+		if !isRowIDTable(objects[i]) {
+			return nil, fmt.Errorf("table %s cannot be copied", objects[i].Name)
+		}
+		copied = append(copied, objects[i])
+	}
+	return copied, nil
+}
+
+// copyTable copies one table's rows into destination, creating it there
+// first when it is missing.
+func (database *Database) copyTable(destination *Database, object db.TableMetadata, progress func(table string, rows int64)) error {
+	if err := database.file.LockShared(); err != nil {
+		return err
+	}
+	defer database.file.UnlockShared()
+	if err := database.verifySchema(); err != nil {
+		return err
+	}
+	table, err := database.TableSchema(object.Name)
+	if err != nil {
+		return err
+	}
+	if err := database.createCopy(destination, object); err != nil {
+		return err
+	}
+
+	var batch [][]any
+	var copied int64
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := destination.insertCopies(table, batch); err != nil {
+			return err
+		}
+		copied += int64(len(batch))
+		batch = batch[:0]
+		if progress != nil {
+			progress(table.Name, copied)
+		}
+		return nil
+	}
+
+	cursor := database.file.NewCursor(database.header, table.RootPage)
+	if err := cursor.First(); err != nil {
+		return err
+	}
+	for cursor.Valid() {
+		row, err := cursor.Row()
+		if err != nil {
+			return err
+		}
+		// The rowid leads, then every column's value
+		values := make([]any, 1+len(table.Columns))
+		values[0] = row.RowID
+		for i := range table.Columns {
+			values[1+i] = columnValue(row, table, i)
+		}
+		if batch = append(batch, values); len(batch) == copyBatchRows {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if err := cursor.Next(); err != nil {
+			return err
+		}
+	}
+	return flush()
+}
+
+// createCopy creates a table in destination from the statement that created
+// it here, unless destination already has a table of that name.
+func (database *Database) createCopy(destination *Database, object db.TableMetadata) error {
+	if err := destination.file.LockShared(); err != nil {
+		return err
+	}
+	err := destination.verifySchema()
+	if err == nil {
+		_, _, err = destination.lookupTable("", object.Name)
+	}
+	destination.file.UnlockShared()
+	if !errors.Is(err, ErrNoSuchTable) {
+		return err
+	}
+
+	resultSet, err := destination.Query(object.SQL)
+	if err != nil {
+		return err
+	}
+	return resultSet.Close()
+}
+
+// insertCopies writes rows copied from source, each its rowid and then the
+// values of source's columns, into the table of the same name in one
+// transaction, matching columns by name.
+func (database *Database) insertCopies(source *TableSchema, rows [][]any) error {
+	if err := database.file.LockShared(); err != nil {
+		return err
+	}
+	defer database.file.UnlockShared()
+	if err := database.verifySchema(); err != nil {
+		return err
+	}
+	owner, table, err := database.lookupTable("", source.Name)
+	if err != nil {
+		return err
+	}
+	targets := []int{-1}
+	for _, column := range source.Columns {
+		position, ok := table.ColumnIndex(column.Name)
+		if !ok {
+			return fmt.Errorf("table %s has no column named %s", table.Name, column.Name)
+		}
+		targets = append(targets, position)
+	}
+
+	statement := &insertStatement{resolution: "abort"}
+	return owner.write(func(pager *db.Pager) error {
+		var pending pendingKeys
+		for _, values := range rows {
+			if err := owner.insertRow(pager, &pending, statement, table, targets, values); err != nil {
+				return err
+			}
+		}
+		return pending.check(owner)
+	})
+}
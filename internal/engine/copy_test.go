@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCopyTo(t *testing.T) {
+	source := openDatabase(t, ordersDatabase(t))
+	path := filepath.Join(t.TempDir(), "copy.db")
+	destination, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { destination.Close() })
+
+	defer func(rows int) { copyBatchRows = rows }(copyBatchRows)
+	copyBatchRows = 150
+	var reports []string
+	progress := func(table string, rows int64) {
+		reports = append(reports, table+":"+strings.Repeat("|", int(rows/50)))
+	}
+	if err := source.CopyTo(destination, nil, progress); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"customers:|", "orders:|||", "orders:||||||", "orders:||||||||"}
+	if !reflect.DeepEqual(reports, want) {
+		t.Errorf("progress %v, want %v", reports, want)
+	}
+
+	// The copy holds the same rows
+	for _, query := range []string{
+		"SELECT count(*) FROM orders",
+		"SELECT customer_id, amount, tag FROM orders WHERE id = 233",
+		"SELECT name, tag FROM customers WHERE id = 17",
+		"SELECT count(*) FROM orders WHERE tag = 'tag-3'",
+	} {
+		if got, want := queryRows(t, destination, query), queryRows(t, source, query); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: copy has %v, source %v", query, got, want)
+		}
+	}
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		if lines := sqlite3Lines(t, sqlite3, path, "PRAGMA integrity_check"); !reflect.DeepEqual(lines, []string{"ok"}) {
+			t.Errorf("integrity_check: %v", lines)
+		}
+	}
+
+	// Copying the rows again breaks the rowids' uniqueness
+	err = source.CopyTo(destination, []string{"Customers"}, nil)
+	if err == nil || err.Error() != "copy customers: UNIQUE constraint failed: customers.id" {
+		t.Errorf("copy into a table holding the rows: %v", err)
+	}
+	if err := source.CopyTo(destination, []string{"missing"}, nil); !errors.Is(err, ErrNoSuchTable) {
+		t.Errorf("copy of a missing table: %v", err)
+	}
+	if err := source.CopyTo(source, nil, nil); err == nil || err.Error() != "cannot copy a database into itself" {
+		t.Errorf("copy into itself: %v", err)
+	}
+}
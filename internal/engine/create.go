@@ -0,0 +1,225 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// createTableStatement is a CREATE TABLE statement.
+type createTableStatement struct {
+	name string
+	// temp is set for a TEMP table, or one named in the temp schema
+	temp        bool
+	ifNotExists bool
+	// sql is the statement as sqlite_schema stores it, CREATE TABLE
+	// followed by the text from the unqualified name on, as in SQLite
+	sql string
+}
+
+// parseCreateTable recognises a CREATE TABLE statement, reporting false for
+// anything else. Tables made from a SELECT and WITHOUT ROWID tables are not
+// supported.
+func parseCreateTable(query string) (*createTableStatement, bool, error) {
+	text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	tokens := scanSQL(text)
+	if len(tokens) < 2 || !tokens[0].keyword("CREATE") {
+		return nil, false, nil
+	}
+	statement := &createTableStatement{}
+	rest := tokens[1:]
+	if rest[0].keyword("TEMP") || rest[0].keyword("TEMPORARY") {
+		statement.temp, rest = true, rest[1:]
+	}
+	if len(rest) == 0 || !rest[0].keyword("TABLE") {
+		return nil, false, nil
+	}
+	rest = rest[1:]
+	if len(rest) >= 3 && rest[0].keyword("IF") && rest[1].keyword("NOT") && rest[2].keyword("EXISTS") {
+		statement.ifNotExists, rest = true, rest[3:]
+	}
+
+	malformed := fmt.Errorf("parse query: unsupported CREATE TABLE statement %q", query)
+	if len(rest) == 0 || rest[0].name == "" {
+		return nil, true, malformed
+	}
+	name := rest[0]
+	if len(rest) >= 3 && text[rest[1].start] == '.' && rest[2].name != "" {
+		schema := rest[0].name
+		switch {
+		case statement.temp && strings.EqualFold(schema, "temp"):
+		case statement.temp:
+			return nil, true, fmt.Errorf("temporary table name must be unqualified")
+		case strings.EqualFold(schema, "temp"):
+			statement.temp = true
+		case !mainSchema(schema):
+			return nil, true, fmt.Errorf("unknown database %s", schema)
+		}
+		name, rest = rest[2], rest[2:]
+	}
+	statement.name = name.name
+
+	if len(rest) < 2 || text[rest[1].start] != '(' {
+		if len(rest) >= 2 && rest[1].keyword("AS") {
+			return nil, true, fmt.Errorf("CREATE TABLE ... AS SELECT is not supported")
+		}
+		return nil, true, malformed
+	}
+	depth := 0
+	for i, token := range rest[1:] {
+		switch text[token.start] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth > 0 {
+			continue
+		}
+		if trailing := rest[i+2:]; len(trailing) > 0 {
+			if trailing[0].keyword("WITHOUT") {
+				return nil, true, ErrWithoutRowID
+			}
+			return nil, true, malformed
+		}
+		break
+	}
+	if depth != 0 {
+		return nil, true, malformed
+	}
+	statement.sql = "CREATE TABLE " + text[name.start:]
+	return statement, true, nil
+}
+
+// createTable executes a CREATE TABLE, in the temp schema for a temporary
+// table and otherwise in the database itself.
+func (database *Database) createTable(statement *createTableStatement) error {
+	target := database
+	if statement.temp {
+		temp, err := database.tempSchema()
+		if err != nil {
+			return err
+		}
+		target = temp
+	}
+	return target.addTable(statement)
+}
+
+// addTable writes a new table's schema row and its empty b-tree, along with
+// an automatic index for each unique key it declares, as SQLite creates
+// them, so that INSERT can enforce the key and SQLite reads the table
+// unchanged.
+func (database *Database) addTable(statement *createTableStatement) error {
+	if strings.HasPrefix(strings.ToLower(statement.name), "sqlite_") {
+		return fmt.Errorf("object name reserved for internal use: %s", statement.name)
+	}
+	objects, err := database.SchemaObjects()
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		if !strings.EqualFold(object.Name, statement.name) {
+			continue
+		}
+		if statement.ifNotExists {
+			return nil
+		}
+		if object.Type == "index" {
+			return fmt.Errorf("there is already an index named %s", object.Name)
+		}
+		return fmt.Errorf("%s %s already exists", object.Type, object.Name)
+	}
+
+	table, err := parseTableSchema(db.TableMetadata{Type: "table", Name: statement.name, TableName: statement.name, SQL: statement.sql})
+	if err != nil {
+		return err
+	}
+
+	err = database.write(func(pager *db.Pager) error {
+		rowID, err := pager.MaxRowID(1)
+		if err != nil {
+			return err
+		}
+		add := func(object db.TableMetadata, pageType db.BTreePageType) error {
+			if object.RootPage, err = pager.CreateBTree(pageType); err != nil {
+				return err
+			}
+			rowID++
+			if err := pager.InsertRow(1, rowID, db.EncodeSchemaRecord(object)); err != nil {
+				return fmt.Errorf("sqlite_schema: %w", err)
+			}
+			return nil
+		}
+		if err := add(db.TableMetadata{Type: "table", Name: table.Name, TableName: table.Name, SQL: statement.sql}, db.LeafTable); err != nil {
+			return err
+		}
+		for i := range table.autoindexKeys() {
+			name := fmt.Sprintf("sqlite_autoindex_%s_%d", table.Name, i+1)
+			if err := add(db.TableMetadata{Type: "index", Name: name, TableName: table.Name}, db.LeafIndex); err != nil {
+				return err
+			}
+		}
+		pager.Header().SchemaCookie++
+		return nil
+	})
+	database.forgetSchemas()
+	return err
+}
+
+// tempSchema returns the database holding the temp schema, creating it in
+// memory on first use. Its tables last until the database is closed and
+// never appear in the database file's own sqlite_schema. Writes to them are
+// committed as they are made, outside any transaction open on the database
+// itself.
+func (database *Database) tempSchema() (*Database, error) {
+	if database.temp == nil {
+		dbFile, header, err := db.CreateMemoryDatabase(4096)
+		if err != nil {
+			return nil, fmt.Errorf("create temp schema: %w", err)
+		}
+		database.temp = newDatabase(dbFile, header)
+		database.temp.logger, database.temp.parent = database.logger, database
+	}
+	return database.temp, nil
+}
+
+// isTempSchemaTable reports whether a table name is one of the names of the
+// temp schema's own schema table.
+func isTempSchemaTable(tableName string) bool {
+	return strings.EqualFold(tableName, "sqlite_temp_schema") || strings.EqualFold(tableName, "sqlite_temp_master")
+}
+
+// lookupTable finds the table a statement names, in the schema it was
+// qualified with or else, as in SQLite, in the temp schema before the
+// database's own, and returns the database holding it with its schema.
+func (database *Database) lookupTable(schema, tableName string) (*Database, *TableSchema, error) {
+	if database.parent != nil {
+		return database.parent.lookupTable(schema, tableName)
+	}
+	owner := database
+	switch {
+	case schema == "temp" || schema == "" && isTempSchemaTable(tableName):
+		if isTempSchemaTable(tableName) {
+			tableName = "sqlite_schema"
+		}
+		temp, err := database.tempSchema()
+		if err != nil {
+			return nil, nil, err
+		}
+		owner = temp
+	case schema == "" && database.temp != nil && !isSchemaTable(tableName):
+		if table, err := database.temp.TableSchema(tableName); err == nil {
+			return database.temp, table, nil
+		}
+	}
+	table, err := owner.TableSchema(tableName)
+	if errors.Is(err, ErrNoSuchTable) && schema != "" {
+		return nil, nil, fmt.Errorf("%w: %s.%s", ErrNoSuchTable, schema, tableName)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return owner, table, nil
+}
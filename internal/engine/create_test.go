@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestCreateTableMatchesSQLite creates the same tables here and in sqlite3
+// and checks the schema rows agree, automatic indexes included, and that
+// sqlite3 reads the rows inserted after.
+func TestCreateTableMatchesSQLite(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+
+	statements := []string{
+		"CREATE TABLE people (id integer primary key, email text not null unique, name text, unique (name, email))",
+		"create table if not exists tags (name text primary key, note)",
+		"CREATE TABLE IF NOT EXISTS people (x)",
+		"CREATE TABLE main.events (at integer, kind text collate nocase)",
+	}
+	want := filepath.Join(t.TempDir(), "want.db")
+	output, err := exec.Command(sqlite3, want, strings.Join(statements, ";\n")).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3: %v\n%s", err, output)
+	}
+
+	path := filepath.Join(t.TempDir(), "created.db")
+	database, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range append(statements,
+		"INSERT INTO people (email, name) VALUES ('a@example.com', 'A'), ('b@example.com', 'B')",
+		"INSERT INTO tags VALUES ('red', NULL)") {
+		if err := execute(t, database, statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	if err := execute(t, database, "INSERT INTO tags VALUES ('red', 1)"); err == nil || err.Error() != "UNIQUE constraint failed: tags.name" {
+		t.Errorf("duplicate primary key: error %v", err)
+	}
+	database.Close()
+
+	schema := "SELECT type, name, tbl_name, rootpage, sql FROM sqlite_schema"
+	if got, want := sqlite3Lines(t, sqlite3, path, schema), sqlite3Lines(t, sqlite3, want, schema); !slices.Equal(got, want) {
+		t.Errorf("schema\n got %q\nwant %q", got, want)
+	}
+	if got := sqlite3Lines(t, sqlite3, path, "PRAGMA integrity_check; SELECT * FROM people; SELECT count(*) FROM tags"); !slices.Equal(got, []string{"ok", "1|a@example.com|A", "2|b@example.com|B", "1"}) {
+		t.Errorf("sqlite3 read back %q", got)
+	}
+}
+
+func sqlite3Lines(t *testing.T, sqlite3, path, sql string) []string {
+	t.Helper()
+
+	output, err := exec.Command(sqlite3, path, sql).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 %q: %v\n%s", sql, err, output)
+	}
+	return strings.Split(strings.TrimSpace(string(output)), "\n")
+}
+
+func TestCreateTableErrors(t *testing.T) {
+	database, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	if err := execute(t, database, "CREATE TABLE t (x)"); err != nil {
+		t.Fatal(err)
+	}
+
+	for statement, want := range map[string]string{
+		"CREATE TABLE t (y)":                   "table t already exists",
+		"CREATE TABLE sqlite_things (x)":       "object name reserved for internal use: sqlite_things",
+		"CREATE TEMP TABLE main.u (x)":         "temporary table name must be unqualified",
+		"CREATE TABLE aux.u (x)":               "unknown database aux",
+		"CREATE TABLE u (x) WITHOUT ROWID":     "WITHOUT ROWID tables are not supported",
+		"CREATE TABLE u AS SELECT * FROM t":    "CREATE TABLE ... AS SELECT is not supported",
+		"CREATE TABLE u (x) STRICT":            `parse query: unsupported CREATE TABLE statement "CREATE TABLE u (x) STRICT"`,
+		"CREATE TABLE u (x integer primary ke": `parse query: unsupported CREATE TABLE statement "CREATE TABLE u (x integer primary ke"`,
+	} {
+		if err := execute(t, database, statement); err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %q", statement, err, want)
+		}
+	}
+}
+
+func TestTemporaryTables(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	path := ordersDatabase(t)
+	database := openDatabase(t, path)
+
+	for _, statement := range []string{
+		"CREATE TEMP TABLE picks (id integer primary key, customer_id integer unique, note text)",
+		"INSERT INTO picks (customer_id, note) VALUES (3, 'third'), (8, 'eighth'), (70, 'missing')",
+		"INSERT INTO temp.picks (customer_id, note) SELECT id, name FROM customers WHERE tag = 'tag-1'",
+		// A temporary table hides the database's table of the same name
+		"CREATE TEMPORARY TABLE orders (id integer primary key, amount integer)",
+		"INSERT INTO orders VALUES (1, -1)",
+	} {
+		if err := execute(t, database, statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	for query, want := range map[string][][]any{
+		"SELECT note FROM picks WHERE customer_id = 8": {{"eighth"}},
+		"SELECT count(*) FROM temp.picks":              {{int64(16)}},
+		"SELECT amount FROM orders WHERE id = 1":       {{int64(-1)}},
+		"SELECT amount FROM main.orders WHERE id = 1":  {{int64(10)}},
+		"SELECT name FROM sqlite_temp_master":          {{"picks"}, {"sqlite_autoindex_picks_1"}, {"orders"}},
+		"SELECT name FROM temp.sqlite_schema LIMIT 1":  {{"picks"}},
+		"SELECT c.name, p.note FROM customers c JOIN picks p ON c.id = p.customer_id WHERE p.customer_id IN (3, 8)": {{"customer 3", "third"}, {"customer 8", "eighth"}},
+		"SELECT name FROM sqlite_schema WHERE type = 'table'":                                                       {{"customers"}, {"orders"}},
+		"PRAGMA database_list": {{int64(0), "main", path}, {int64(1), "temp", ""}},
+	} {
+		if rows := queryRows(t, database, query); !reflect.DeepEqual(rows, want) {
+			t.Errorf("%s = %v, want %v", query, rows, want)
+		}
+	}
+	if err := execute(t, database, "INSERT INTO picks (customer_id) VALUES (3)"); err == nil || err.Error() != "UNIQUE constraint failed: picks.customer_id" {
+		t.Errorf("duplicate key in a temporary table: error %v", err)
+	}
+	if err := execute(t, database, "CREATE TEMP TABLE picks (x)"); err == nil || err.Error() != "table picks already exists" {
+		t.Errorf("recreating a temporary table: error %v", err)
+	}
+	database.Close()
+
+	// Nothing reaches the file, and the tables go with the handle
+	if got := sqlite3Lines(t, sqlite3, path, "SELECT count(*) FROM sqlite_schema WHERE name IN ('picks', 'sqlite_autoindex_picks_1'); PRAGMA integrity_check"); !slices.Equal(got, []string{"0", "ok"}) {
+		t.Errorf("sqlite3 after closing: %q", got)
+	}
+	reopened := openDatabase(t, path)
+	if _, err := runQuery(reopened, "SELECT * FROM picks"); !errors.Is(err, ErrNoSuchTable) {
+		t.Errorf("temporary table outlived its handle: error %v", err)
+	}
+}
@@ -0,0 +1,409 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// Database is an open database file. Its header is read when opened and
+// checked again before each statement, its schema on first use, and
+// both are shared by every query run against it. It must be closed when no longer needed, which also
+// invalidates any result sets and blob readers opened on it.
+type Database struct {
+	file   *db.DatabaseFile
+	header *db.DatabaseHeader
+	// schemaObjects caches the rows of the schema table
+	schemaObjects []db.TableMetadata
+	// schemas caches parsed table schemas by lowercased name
+	schemas map[string]*TableSchema
+	// plans caches compiled SELECTs by planKey, for as long as the
+	// schemas they were compiled against
+	plans map[string]compiledQuery
+	// foreignKeys is the foreign_keys setting, off by default as in SQLite
+	foreignKeys bool
+	// transaction is the open write transaction, or nil outside one, when
+	// each statement commits on its own
+	transaction *transaction
+	// limits caps each query's results
+	limits Limits
+	// readOnlySQL rejects every statement but SELECT, EXPLAIN and PRAGMAs
+	// that do not write
+	readOnlySQL bool
+	// permissive skips rows that cannot be decoded rather than failing the
+	// query
+	permissive bool
+	// updateHook is told of each row a statement changes
+	updateHook func(op ChangeOp, table string, rowID int64)
+	// logger receives debug records of plans, page reads and recoveries
+	logger *slog.Logger
+	// temp holds the temp schema's tables, in memory, once one is created
+	temp *Database
+	// parent is the database whose temp schema this is, which resolves
+	// the tables its statements name
+	parent *Database
+	// counters total the work statements have done
+	counters counters
+	// policies hold the row filters and column masks queries of each
+	// table, by lowercased name, are read through
+	policies map[string]*tablePolicy
+	// functions holds the state random() and randomblob() draw from
+	functions functions
+}
+
+// Open opens the database at path, for writing when the file allows it and
+// read-only otherwise, as sqlite3 does. The path ":memory:" opens a new,
+// empty database held in memory, which is gone once it is closed.
+func Open(path string) (*Database, error) {
+	if path == db.MemoryPath {
+		dbFile, header, err := db.CreateMemoryDatabase(4096)
+		if err != nil {
+			return nil, err
+		}
+		return newDatabase(dbFile, header), nil
+	}
+	dbFile, header, err := db.OpenWritableDatabaseFile(path)
+	if errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS) {
+		dbFile, header, err = db.OpenDatabaseFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newDatabase(dbFile, header), nil
+}
+
+// ErrReadOnly is returned for a write to a database opened read-only.
+var ErrReadOnly = errors.New("attempt to write a readonly database")
+
+// OpenReadOnly opens the database at path for reading only, even when the
+// file could be written.
+func OpenReadOnly(path string) (*Database, error) {
+	dbFile, header, err := db.OpenDatabaseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newDatabase(dbFile, header), nil
+}
+
+// OpenReader opens the database whose size bytes reader holds, for reading
+// only, where there is no file to open, as in a browser; name is the path
+// Path reports.
+func OpenReader(reader io.ReaderAt, size int64, name string) (*Database, error) {
+	dbFile, header, err := db.OpenReader(reader, size, name)
+	if err != nil {
+		return nil, err
+	}
+	return newDatabase(dbFile, header), nil
+}
+
+// OpenEncrypted opens the encrypted database at path for reading only,
+// decoding its pages with codec, such as db.NewSQLCipherCodec returns.
+func OpenEncrypted(path string, codec db.Codec) (*Database, error) {
+	dbFile, header, err := db.OpenEncryptedDatabaseFile(path, codec)
+	if err != nil {
+		return nil, err
+	}
+	return newDatabase(dbFile, header), nil
+}
+
+// Create writes a new, empty database at path with SQLite's default page
+// size and opens it. It fails if path already exists. Creating ":memory:"
+// opens a new in-memory database, as Open does.
+func Create(path string) (*Database, error) {
+	if path == db.MemoryPath {
+		return Open(path)
+	}
+	if err := db.CreateDatabaseFile(path, 4096); err != nil {
+		return nil, err
+	}
+	return Open(path)
+}
+
+func newDatabase(dbFile *db.DatabaseFile, header *db.DatabaseHeader) *Database {
+	return &Database{file: dbFile, header: header, logger: slog.New(slog.DiscardHandler)}
+}
+
+// SetLogger sends the database's log records to logger. Everything it logs
+// is at debug level, the plan chosen for each query, each page read, and
+// anomalies it recovers from, such as a hot journal or a write-ahead log
+// changed by another process, except the cells permissive queries skip,
+// which are warnings. A nil logger turns logging off.
+func (database *Database) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	database.logger = logger
+	database.file.Logger = logger
+}
+
+// Close closes the database file, rolling back any open transaction, and
+// discards the temp schema.
+func (database *Database) Close() error {
+	if database.transaction != nil {
+		database.rollbackTransaction()
+	}
+	if database.temp != nil {
+		database.temp.Close()
+		database.temp = nil
+	}
+	return database.file.Close()
+}
+
+// Path returns the absolute path of the database file, or "" for an
+// in-memory database, as SQLite reports it.
+func (database *Database) Path() string {
+	if database.file.InMemory() {
+		return ""
+	}
+	if path, err := filepath.Abs(database.file.Name()); err == nil {
+		return path
+	}
+	return database.file.Name()
+}
+
+// ReadOnly reports whether the database was opened for reading only, so
+// that every write fails with ErrReadOnly.
+func (database *Database) ReadOnly() bool {
+	return !database.file.Writable()
+}
+
+// Header returns the database header as of the last statement run.
+func (database *Database) Header() *db.DatabaseHeader {
+	return database.header
+}
+
+// SchemaObjects returns the rows of the schema table: every table, index,
+// view and trigger in the database.
+func (database *Database) SchemaObjects() ([]db.TableMetadata, error) {
+	if database.schemaObjects == nil {
+		objects, err := database.file.ReadSchema(database.header)
+		if err != nil {
+			return nil, fmt.Errorf("read schema: %w", err)
+		}
+		database.schemaObjects = objects
+	}
+	return database.schemaObjects, nil
+}
+
+// TempSchemaObjects returns the rows of the temp schema's schema table, the
+// temporary tables and their indexes, or none before a temporary table is
+// created.
+func (database *Database) TempSchemaObjects() ([]db.TableMetadata, error) {
+	if database.temp == nil {
+		return nil, nil
+	}
+	return database.temp.SchemaObjects()
+}
+
+// SetLimits caps the results of the queries run from now on. A query that
+// would return more fails with ErrResultTooLarge once it passes the limit.
+func (database *Database) SetLimits(limits Limits) {
+	database.limits = limits
+}
+
+// SetReadOnlySQL turns on or off the read-only SQL mode. While on, any
+// statement other than a SELECT, an EXPLAIN or a PRAGMA that only reads or
+// changes a connection setting fails with ErrStatementNotAllowed before it
+// runs, and so does any write that is reached regardless.
+func (database *Database) SetReadOnlySQL(on bool) {
+	database.readOnlySQL = on
+}
+
+// SetPermissive turns on or off permissive reading, for salvaging what
+// can be read from a damaged file. While on, a query that meets a row or
+// index entry whose cell cannot be decoded skips it and carries on, rather
+// than failing, and logs a warning naming its page and cell; the result
+// set's Warnings lists them. Damage to a page as a whole still fails the
+// query, since what the page held cannot be known, and statements that
+// write are never permissive.
+func (database *Database) SetPermissive(on bool) {
+	database.permissive = on
+}
+
+// SetVerifyChecksums turns on or off checksum verification. While on, each
+// page read through the write-ahead log is checked against its frame's
+// checksum, and a hot journal's records against theirs before it is
+// replayed, so that silent corruption fails the statement rather than
+// being decoded as a page. A journal replayed when the database was
+// opened has already been, and db.VerifyJournal checks it beforehand.
+func (database *Database) SetVerifyChecksums(on bool) {
+	database.file.VerifyChecksums = on
+}
+
+// ErrInterrupted is returned when a progress handler stops a statement.
+var ErrInterrupted = errors.New("interrupted")
+
+// SetProgressHandler arranges for handler to be called after every n b-tree
+// pages statements read, as sqlite3_progress_handler does with virtual
+// machine steps, so that callers can report progress or give a statement a
+// deadline. A handler that returns true stops the statement with
+// ErrInterrupted. A nil handler or n below 1 removes it.
+func (database *Database) SetProgressHandler(n int, handler func() bool) {
+	if handler == nil || n < 1 {
+		database.file.Progress = nil
+		return
+	}
+	pages := 0
+	database.file.Progress = func() error {
+		if pages++; pages%n == 0 && handler() {
+			return ErrInterrupted
+		}
+		return nil
+	}
+}
+
+// ChangeOp is the kind of row change an update hook or a Watcher reports.
+type ChangeOp int
+
+// The kinds of row change, as SQLite numbers them apart. INSERT reports
+// OpInsert to the update hook, UPDATE and an upsert's DO UPDATE OpUpdate,
+// and DELETE OpDelete, as do the rows a foreign key's actions change; a
+// Watcher reports the same kinds for rows another process changed.
+const (
+	OpInsert ChangeOp = iota + 1
+	OpUpdate
+	OpDelete
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case OpInsert:
+		return "INSERT"
+	case OpUpdate:
+		return "UPDATE"
+	case OpDelete:
+		return "DELETE"
+	}
+	return fmt.Sprintf("ChangeOp(%d)", int(op))
+}
+
+// SetUpdateHook arranges for hook to be called for each row a statement
+// inserts, updates or deletes in a table, temporary tables included, with
+// the table's name and the row's rowid, as sqlite3_update_hook does, so
+// that callers can invalidate caches or capture changes. As in SQLite it is
+// called as each row is written, before the change commits, so a statement
+// or transaction that fails or rolls back afterwards has reported rows it
+// did not keep; it is not called for the schema and statistics tables, nor
+// for rows that OR REPLACE deletes to make way for a new one. The hook must
+// not use the database. A nil hook removes it.
+func (database *Database) SetUpdateHook(hook func(op ChangeOp, table string, rowID int64)) {
+	database.updateHook = hook
+}
+
+// rowChanged tells the update hook of a row change, which in the temp
+// schema is the hook of the database it belongs to.
+func (database *Database) rowChanged(op ChangeOp, table string, rowID int64) {
+	if database.parent != nil {
+		database = database.parent
+	}
+	if database.updateHook != nil {
+		database.updateHook(op, table, rowID)
+	}
+}
+
+// SetBusyTimeout sets how long a statement waits for other processes to
+// release their locks on the database, retrying as they do, before failing
+// with "database is locked". The default of zero fails at once.
+func (database *Database) SetBusyTimeout(timeout time.Duration) {
+	database.file.BusyTimeout = timeout
+}
+
+// verifySchema rereads the header, and in WAL mode any new frames in the
+// log, before a statement runs, so changes another connection committed
+// since the last one are seen. A moved change counter drops the cached
+// schema objects. When the schema cookie has moved the cached schemas are
+// dropped too and re-parsed on use, as SQLite re-prepares a statement on
+// SQLITE_SCHEMA, rather than mapping columns through a stale definition.
+// Inside a transaction the cached state is the transaction's own and is
+// kept.
+func (database *Database) verifySchema() error {
+	if database.transaction != nil {
+		return nil
+	}
+	if err := database.file.Refresh(); err != nil {
+		return err
+	}
+	header, err := database.file.NewDatabaseHeader()
+	if err != nil {
+		return err
+	}
+	if header.ChangeCounter == database.header.ChangeCounter && header.SchemaCookie == database.header.SchemaCookie {
+		return nil
+	}
+	if header.SchemaCookie != database.header.SchemaCookie {
+		database.logger.Debug("schema changed by another connection", "cookie", header.SchemaCookie)
+		database.forgetSchemas()
+	}
+	// Updated in place, since pagers and result sets share the header
+	*database.header = *header
+	database.schemaObjects = nil
+	return nil
+}
+
+// write runs change against a pager on the database and commits it.
+// Cached pages are dropped, since the change may have rewritten them.
+// Inside an open transaction the change joins it instead, and a change
+// that fails undoes only its own writes.
+func (database *Database) write(change func(*db.Pager) error) error {
+	if database.readOnlySQL {
+		return fmt.Errorf("%w: write", ErrStatementNotAllowed)
+	}
+	if !database.file.Writable() {
+		return ErrReadOnly
+	}
+	if tx := database.transaction; tx != nil {
+		snapshot := tx.pager.Snapshot()
+		err := change(tx.pager)
+		if err != nil {
+			tx.pager.Restore(snapshot)
+		}
+		database.schemaObjects = nil
+		return err
+	}
+
+	pager := db.NewPager(database.file, database.header)
+	if err := change(pager); err != nil {
+		pager.Rollback()
+		return err
+	}
+	if err := pager.Commit(); err != nil {
+		pager.Rollback()
+		if errors.Is(err, db.ErrBusy) {
+			return err
+		}
+		return fmt.Errorf("commit: %w", err)
+	}
+	database.schemaObjects = nil
+	return nil
+}
+
+// OpenBlob returns a reader over the text or blob stored in one column of the
+// row with the given rowid, like sqlite3_blob_open. The value is read from
+// its page and overflow chain as the reader advances, rather than loaded
+// whole.
+func (database *Database) OpenBlob(tableName, columnName string, rowID int64) (io.ReadSeeker, error) {
+	if err := database.verifySchema(); err != nil {
+		return nil, err
+	}
+	table, err := database.TableSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	position, ok := table.ColumnIndex(columnName)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchColumn, columnName)
+	}
+	if position == table.RowIDAlias {
+		return nil, fmt.Errorf("cannot open value of type integer")
+	}
+
+	return database.file.OpenBlob(database.header, table.RootPage, rowID, position)
+}
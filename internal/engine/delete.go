@@ -0,0 +1,162 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/xwb1989/sqlparser"
+)
+
+// parseDelete recognises a DELETE statement, reporting false for anything
+// else.
+func parseDelete(query string) (*sqlparser.Delete, bool, error) {
+	text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	tokens := scanSQL(text)
+	if len(tokens) == 0 || !tokens[0].keyword("DELETE") {
+		return nil, false, nil
+	}
+	stmt, err := parseSQL(text)
+	if err != nil {
+		return nil, true, fmt.Errorf("parse query: %w", err)
+	}
+	statement, ok := stmt.(*sqlparser.Delete)
+	if !ok {
+		return nil, true, fmt.Errorf("unsupported query type: %T", stmt)
+	}
+	if err := resolveSchemas(statement); err != nil {
+		return nil, true, err
+	}
+	if len(statement.Targets) > 0 || len(statement.Partitions) > 0 || len(statement.OrderBy) > 0 || statement.Limit != nil {
+		return nil, true, fmt.Errorf("unsupported delete clause in %q", query)
+	}
+	return statement, true, nil
+}
+
+// targetTable returns the one table a DELETE or UPDATE changes.
+func targetTable(exprs sqlparser.TableExprs) (sqlparser.TableName, error) {
+	if len(exprs) == 1 {
+		if aliased, ok := exprs[0].(*sqlparser.AliasedTableExpr); ok && aliased.As.IsEmpty() {
+			if name, ok := aliased.Expr.(sqlparser.TableName); ok {
+				return name, nil
+			}
+		}
+	}
+	return sqlparser.TableName{}, fmt.Errorf("unsupported table expression: %s", sqlparser.String(exprs))
+}
+
+// delete executes a DELETE of the rows its WHERE clause holds for, or of
+// every row without one. With foreign keys enabled, the actions of the keys
+// referring to each deleted row are carried out as it is deleted, and the
+// statement fails if rows are left referring to a NO ACTION key it removed.
+func (database *Database) delete(statement *sqlparser.Delete) error {
+	name, err := targetTable(statement.TableExprs)
+	if err != nil {
+		return err
+	}
+	if isSchemaTable(name.Name.String()) {
+		return fmt.Errorf("table %s may not be modified", name.Name.String())
+	}
+	owner, table, err := database.lookupTable(name.Qualifier.String(), name.Name.String())
+	if err != nil {
+		return err
+	}
+	if owner != database {
+		statement.TableExprs = sqlparser.TableExprs{&sqlparser.AliasedTableExpr{Expr: sqlparser.TableName{Name: name.Name}}}
+		return owner.delete(statement)
+	}
+
+	if statement.Where != nil {
+		if err := resolveColumns(statement.Where.Expr, table); err != nil {
+			return err
+		}
+	}
+
+	rowIDs, err := database.matchingRows(table, statement.Where)
+	if err != nil {
+		return err
+	}
+	return database.write(func(pager *db.Pager) error {
+		var pending pendingKeys
+		for _, rowID := range rowIDs {
+			// A row an earlier row's cascade deleted is already gone
+			row, err := database.file.SeekRowID(database.header, table.RootPage, rowID)
+			if err != nil {
+				return err
+			}
+			if row == nil {
+				continue
+			}
+			if err := database.removeRow(pager, &pending, table, rowID); err != nil {
+				return err
+			}
+			database.rowChanged(OpDelete, table.Name, rowID)
+		}
+		return pending.check(database)
+	})
+}
+
+// resolveColumns checks that every column an expression names is one of
+// table's, so that a statement naming a missing column fails even when it
+// reads no row. Subqueries name columns of their own tables and are left
+// to be resolved as they run.
+func resolveColumns(expr sqlparser.Expr, table *TableSchema) error {
+	resolve := rowColumns(table, 0, make([]any, len(table.Columns)))
+	return sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.Subquery:
+			return false, nil
+		case *sqlparser.ColName:
+			name := node.Name.String()
+			if !node.Qualifier.IsEmpty() {
+				name = node.Qualifier.Name.String() + "." + name
+			}
+			_, err := resolve(name)
+			return false, err
+		}
+		return true, nil
+	}, expr)
+}
+
+// matchingRows returns the rowids of the rows of table that a WHERE clause
+// holds for, or of every row without one. They are all found before any row
+// is changed, so that the changes cannot affect which rows match.
+func (database *Database) matchingRows(table *TableSchema, where *sqlparser.Where) ([]int64, error) {
+	var rowIDs []int64
+	var failed error
+	err := tableScan(database.file, database.header, table, nil, false, &scanStats{}, func(row *db.Row) bool {
+		if where != nil {
+			values := make([]any, len(table.Columns))
+			for i := range values {
+				values[i] = columnValue(row, table, i)
+			}
+			holds, err := evaluateTruth(where.Expr, database.scope(rowColumns(table, row.RowID, values)))
+			if err != nil {
+				failed = err
+				return false
+			}
+			if holds != truthy {
+				return true
+			}
+		}
+		rowIDs = append(rowIDs, row.RowID)
+		return true
+	})
+	if err == nil {
+		err = failed
+	}
+	return rowIDs, err
+}
+
+// removeRow deletes a row, as DELETE does, and then carries out the
+// actions of the foreign keys referring to it.
+func (database *Database) removeRow(pager *db.Pager, pending *pendingKeys, table *TableSchema, rowID int64) error {
+	row, err := database.readRow(table, rowID)
+	if err != nil {
+		return err
+	}
+	if err := database.deleteRow(pager, table, rowID); err != nil {
+		return err
+	}
+	return database.parentChanged(pager, pending, table, row, nil)
+}
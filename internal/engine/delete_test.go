@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// runAgainstSQLite runs the same statements here and in sqlite3, each
+// stopping at the first that fails, and checks they fail at the same one
+// with the same message and leave the tables with the same rows.
+func runAgainstSQLite(t *testing.T, sqlite3 string, statements []string, tables ...string) {
+	t.Helper()
+
+	want := filepath.Join(t.TempDir(), "want.db")
+	output, _ := exec.Command(sqlite3, want, strings.Join(statements, ";\n")).CombinedOutput()
+	wantErr := strings.TrimSpace(string(output))
+
+	path := filepath.Join(t.TempDir(), "got.db")
+	database, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotErr := ""
+	for _, statement := range statements {
+		if err := execute(t, database, statement); err != nil {
+			gotErr = err.Error()
+			break
+		}
+	}
+	database.Close()
+	// sqlite3 reports an error as "Error: stepping, <message> (<code>)"
+	if gotErr == "" && wantErr != "" || gotErr != "" && !strings.Contains(wantErr, " "+gotErr+" (") {
+		t.Errorf("%s\nerror %q, sqlite3 %q", strings.Join(statements, ";\n"), gotErr, wantErr)
+	}
+
+	for _, table := range tables {
+		query := "SELECT rowid, * FROM " + table
+		if got, want := sqlite3Lines(t, sqlite3, path, query), sqlite3Lines(t, sqlite3, want, query); !slices.Equal(got, want) {
+			t.Errorf("%s\n%s\n got %q\nwant %q", strings.Join(statements, ";\n"), query, got, want)
+		}
+	}
+	if got := sqlite3Lines(t, sqlite3, path, "PRAGMA integrity_check"); !slices.Equal(got, []string{"ok"}) {
+		t.Errorf("%s\nintegrity_check: %q", strings.Join(statements, ";\n"), got)
+	}
+}
+
+func TestDeleteAndUpdateMatchSQLite(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+
+	schema := []string{
+		"CREATE TABLE t (id integer primary key, a text unique, b int not null, c check (c <> 0))",
+		"INSERT INTO t VALUES (1, 'x', 10, 1), (2, 'y', 20, 2), (3, 'z', 30, 3), (4, NULL, 40, NULL)",
+	}
+	for _, statements := range [][]string{
+		{"DELETE FROM t WHERE b >= 20 AND a <> 'z'"},
+		{"DELETE FROM t WHERE a IS NULL OR id = 1", "DELETE FROM t WHERE rowid = 3"},
+		{"DELETE FROM main.t"},
+		{"UPDATE t SET b = b * 2, a = upper(a) WHERE id <> 3"},
+		// Assignments all read the row as it was
+		{"UPDATE t SET b = c, c = b WHERE c IS NOT NULL"},
+		{"UPDATE t SET id = id + 10 WHERE b > 20", "INSERT INTO t (a, b) VALUES ('w', 1)"},
+		{"UPDATE t SET b = '7' WHERE id = 1"},
+		{"UPDATE t SET a = 'x' WHERE id = 2"},
+		{"UPDATE t SET b = NULL WHERE id = 2"},
+		{"UPDATE t SET c = 0"},
+		{"UPDATE t SET id = 1 WHERE id = 2"},
+	} {
+		runAgainstSQLite(t, sqlite3, append(slices.Clone(schema), statements...), "t")
+	}
+}
+
+func TestDeleteAndUpdateErrors(t *testing.T) {
+	database, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	if err := execute(t, database, "CREATE TABLE t (x)"); err != nil {
+		t.Fatal(err)
+	}
+
+	for statement, want := range map[string]string{
+		"DELETE FROM u":                  "no such table: u",
+		"DELETE FROM sqlite_schema":      "table sqlite_schema may not be modified",
+		"DELETE FROM t WHERE y = 1":      "no such column: y",
+		"UPDATE t SET y = 1":             "no such column: y",
+		"UPDATE t SET x = 1 WHERE z = 2": "no such column: z",
+	} {
+		if err := execute(t, database, statement); err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %q", statement, err, want)
+		}
+	}
+}
@@ -0,0 +1,841 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/xwb1989/sqlparser"
+)
+
+// columnResolver looks up a column referenced by an expression, returning
+// its value for the current row along with its affinity and collation. A
+// qualified reference is passed as "table.column".
+type columnResolver func(name string) (operand, error)
+
+// scope is what an expression is evaluated in: the columns it may
+// reference and the state of the connection's functions, which is nil
+// where no connection is in reach.
+type scope struct {
+	column    columnResolver
+	functions *functions
+}
+
+// parseExpression parses a standalone expression, such as a CHECK
+// constraint or a DEFAULT, with the same parser as queries.
+func parseExpression(text string) (sqlparser.Expr, error) {
+	stmt, err := parseSQLAfter("SELECT ", text)
+	if err != nil {
+		return nil, fmt.Errorf("parse expression %q: %w", text, err)
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok || len(sel.SelectExprs) != 1 {
+		return nil, fmt.Errorf("parse expression %q: not a single expression", text)
+	}
+	aliased, ok := sel.SelectExprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return nil, fmt.Errorf("parse expression %q: not a single expression", text)
+	}
+	return aliased.Expr, nil
+}
+
+// operand is an evaluated subexpression together with the affinity it
+// carries into a comparison: a column's affinity, or none (blob) for
+// anything computed. A column or an explicit COLLATE also carries the
+// collation that orders its text there.
+type operand struct {
+	value     any
+	affinity  Affinity
+	collation db.Collation
+	collated  collationSource
+}
+
+// collationSource says where an operand's collation comes from. A
+// comparison uses an explicit COLLATE on either side first, and then a
+// column's own collation, the left side's before the right's.
+type collationSource int
+
+const (
+	noCollation collationSource = iota
+	columnCollation
+	explicitCollation
+)
+
+// columnOperand returns a column's value as an operand of a comparison.
+func columnOperand(value any, column ColumnSchema) operand {
+	return operand{value: value, affinity: column.Affinity, collation: column.collation(), collated: columnCollation}
+}
+
+// evaluate computes an expression's value with SQLite's semantics for the
+// supported operators and functions: NULL propagates through arithmetic and
+// comparisons, and AND, OR and NOT use three-valued logic.
+func evaluate(expr sqlparser.Expr, in scope) (any, error) {
+	result, err := evaluateOperand(expr, in)
+	return result.value, err
+}
+
+func evaluateOperand(expr sqlparser.Expr, in scope) (operand, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		name := expr.Name.String()
+		if !expr.Qualifier.IsEmpty() {
+			name = expr.Qualifier.Name.String() + "." + name
+		}
+		return in.column(name)
+	case *sqlparser.ParenExpr:
+		return evaluateOperand(expr.Expr, in)
+	case *sqlparser.CollateExpr:
+		result, err := evaluateOperand(expr.Expr, in)
+		if err != nil {
+			return operand{}, err
+		}
+		collation, ok := db.ParseCollation(expr.Charset)
+		if !ok {
+			return operand{}, fmt.Errorf("no such collation sequence: %s", expr.Charset)
+		}
+		result.collation, result.collated = collation, explicitCollation
+		return result, nil
+	case sqlparser.BoolVal:
+		if expr {
+			return operand{value: int64(1)}, nil
+		}
+		return operand{value: int64(0)}, nil
+	case *sqlparser.NullVal, *sqlparser.SQLVal:
+		value, err := literalValue(expr)
+		return operand{value: value}, err
+	}
+
+	value, err := evaluateValue(expr, in)
+	return operand{value: value}, err
+}
+
+func evaluateValue(expr sqlparser.Expr, in scope) (any, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		left, err := evaluateTruth(expr.Left, in)
+		if err != nil {
+			return nil, err
+		}
+		right, err := evaluateTruth(expr.Right, in)
+		if err != nil {
+			return nil, err
+		}
+		if left == falsy || right == falsy {
+			return int64(0), nil
+		}
+		if left == unknown || right == unknown {
+			return nil, nil
+		}
+		return int64(1), nil
+	case *sqlparser.OrExpr:
+		left, err := evaluateTruth(expr.Left, in)
+		if err != nil {
+			return nil, err
+		}
+		right, err := evaluateTruth(expr.Right, in)
+		if err != nil {
+			return nil, err
+		}
+		if left == truthy || right == truthy {
+			return int64(1), nil
+		}
+		if left == unknown || right == unknown {
+			return nil, nil
+		}
+		return int64(0), nil
+	case *sqlparser.NotExpr:
+		value, err := evaluateTruth(expr.Expr, in)
+		if err != nil {
+			return nil, err
+		}
+		return value.not().value(), nil
+	case *sqlparser.ComparisonExpr:
+		return evaluateComparison(expr, in)
+	case *sqlparser.RangeCond:
+		left, err := evaluateOperand(expr.Left, in)
+		if err != nil {
+			return nil, err
+		}
+		from, err := evaluateOperand(expr.From, in)
+		if err != nil {
+			return nil, err
+		}
+		to, err := evaluateOperand(expr.To, in)
+		if err != nil {
+			return nil, err
+		}
+		low := compareOperands(left, from, func(c int) bool { return c >= 0 })
+		high := compareOperands(left, to, func(c int) bool { return c <= 0 })
+		within := truthOf(low).and(truthOf(high))
+		if expr.Operator == sqlparser.NotBetweenStr {
+			within = within.not()
+		}
+		return within.value(), nil
+	case *sqlparser.IsExpr:
+		value, err := evaluate(expr.Expr, in)
+		if err != nil {
+			return nil, err
+		}
+		switch expr.Operator {
+		case sqlparser.IsNullStr:
+			return boolValue(value == nil), nil
+		case sqlparser.IsNotNullStr:
+			return boolValue(value != nil), nil
+		case sqlparser.IsTrueStr:
+			return boolValue(truthOf(value) == truthy), nil
+		case sqlparser.IsNotTrueStr:
+			return boolValue(truthOf(value) != truthy), nil
+		case sqlparser.IsFalseStr:
+			return boolValue(truthOf(value) == falsy), nil
+		case sqlparser.IsNotFalseStr:
+			return boolValue(truthOf(value) != falsy), nil
+		}
+	case *sqlparser.UnaryExpr:
+		value, err := evaluate(expr.Expr, in)
+		if err != nil || value == nil {
+			return nil, err
+		}
+		switch expr.Operator {
+		case sqlparser.UPlusStr:
+			return value, nil
+		case sqlparser.UMinusStr:
+			if isLiteral(expr.Expr, "9223372036854775808") {
+				// The smallest integer written out, as SQLite reads it
+				return int64(math.MinInt64), nil
+			}
+			return negate(value), nil
+		case sqlparser.TildaStr:
+			return ^integerValue(value), nil
+		case sqlparser.BangStr:
+			return truthOf(value).not().value(), nil
+		}
+	case *sqlparser.BinaryExpr:
+		left, err := evaluate(expr.Left, in)
+		if err != nil {
+			return nil, err
+		}
+		right, err := evaluate(expr.Right, in)
+		if err != nil {
+			return nil, err
+		}
+		if left == nil || right == nil {
+			return nil, nil
+		}
+		return arithmetic(expr.Operator, left, right)
+	case *sqlparser.CaseExpr:
+		return evaluateCase(expr, in)
+	case *sqlparser.FuncExpr:
+		return evaluateFunction(expr, in)
+	}
+	return nil, fmt.Errorf("unsupported expression: %s", sqlparser.String(expr))
+}
+
+// truth is the three-valued result of a condition.
+type truth int
+
+const (
+	unknown truth = iota
+	falsy
+	truthy
+)
+
+// truthOf interprets a value as a condition: NULL is unknown, and anything
+// else is true unless it is numerically zero, text reading as its numeric
+// prefix.
+func truthOf(value any) truth {
+	if value == nil {
+		return unknown
+	}
+	switch number := numericValue(value).(type) {
+	case int64:
+		if number != 0 {
+			return truthy
+		}
+	case float64:
+		if number != 0 {
+			return truthy
+		}
+	}
+	return falsy
+}
+
+func (t truth) not() truth {
+	switch t {
+	case truthy:
+		return falsy
+	case falsy:
+		return truthy
+	}
+	return unknown
+}
+
+func (t truth) and(other truth) truth {
+	if t == falsy || other == falsy {
+		return falsy
+	}
+	if t == unknown || other == unknown {
+		return unknown
+	}
+	return truthy
+}
+
+func (t truth) value() any {
+	switch t {
+	case truthy:
+		return int64(1)
+	case falsy:
+		return int64(0)
+	}
+	return nil
+}
+
+func boolValue(b bool) any {
+	if b {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func evaluateTruth(expr sqlparser.Expr, in scope) (truth, error) {
+	value, err := evaluate(expr, in)
+	return truthOf(value), err
+}
+
+// comparisonAffinity converts the operands of a comparison as SQLite does:
+// when one side has numeric affinity the other gets numeric affinity too,
+// and otherwise text affinity on one side applies to a side with none.
+func comparisonAffinity(left, right operand) (any, any) {
+	isNumeric := func(affinity Affinity) bool {
+		return affinity == AffinityInteger || affinity == AffinityReal || affinity == AffinityNumeric
+	}
+	switch {
+	case isNumeric(left.affinity) && !isNumeric(right.affinity):
+		return left.value, applyAffinity(right.value, AffinityNumeric)
+	case isNumeric(right.affinity) && !isNumeric(left.affinity):
+		return applyAffinity(left.value, AffinityNumeric), right.value
+	case left.affinity == AffinityText && right.affinity == AffinityBlob:
+		return left.value, applyAffinity(right.value, AffinityText)
+	case right.affinity == AffinityText && left.affinity == AffinityBlob:
+		return applyAffinity(left.value, AffinityText), right.value
+	}
+	return left.value, right.value
+}
+
+// comparisonCollation returns the collation a comparison of two operands
+// orders text by.
+func comparisonCollation(left, right operand) db.Collation {
+	if right.collated > left.collated {
+		return right.collation
+	}
+	return left.collation
+}
+
+// compareOperands applies test to the ordering of two operands, or returns
+// NULL when either is NULL.
+func compareOperands(left, right operand, test func(int) bool) any {
+	a, b := comparisonAffinity(left, right)
+	if a == nil || b == nil {
+		return nil
+	}
+	return boolValue(test(db.CompareValues(a, b, comparisonCollation(left, right))))
+}
+
+func evaluateComparison(expr *sqlparser.ComparisonExpr, in scope) (any, error) {
+	left, err := evaluateOperand(expr.Left, in)
+	if err != nil {
+		return nil, err
+	}
+
+	switch expr.Operator {
+	case sqlparser.InStr, sqlparser.NotInStr:
+		tuple, ok := expr.Right.(sqlparser.ValTuple)
+		if !ok {
+			return nil, fmt.Errorf("unsupported IN list: %s", sqlparser.String(expr.Right))
+		}
+		// x IN (...) is true on a match, NULL if x or any element is NULL
+		// without one, and false otherwise
+		found := falsy
+		if left.value == nil && len(tuple) > 0 {
+			found = unknown
+		}
+		for _, element := range tuple {
+			right, err := evaluateOperand(element, in)
+			if err != nil {
+				return nil, err
+			}
+			switch truthOf(compareOperands(left, right, func(c int) bool { return c == 0 })) {
+			case truthy:
+				found = truthy
+			case unknown:
+				if found == falsy {
+					found = unknown
+				}
+			}
+			if found == truthy {
+				break
+			}
+		}
+		if expr.Operator == sqlparser.NotInStr {
+			found = found.not()
+		}
+		return found.value(), nil
+	}
+
+	right, err := evaluateOperand(expr.Right, in)
+	if err != nil {
+		return nil, err
+	}
+	switch expr.Operator {
+	case sqlparser.EqualStr:
+		return compareOperands(left, right, func(c int) bool { return c == 0 }), nil
+	case sqlparser.NotEqualStr:
+		return compareOperands(left, right, func(c int) bool { return c != 0 }), nil
+	case sqlparser.LessThanStr:
+		return compareOperands(left, right, func(c int) bool { return c < 0 }), nil
+	case sqlparser.LessEqualStr:
+		return compareOperands(left, right, func(c int) bool { return c <= 0 }), nil
+	case sqlparser.GreaterThanStr:
+		return compareOperands(left, right, func(c int) bool { return c > 0 }), nil
+	case sqlparser.GreaterEqualStr:
+		return compareOperands(left, right, func(c int) bool { return c >= 0 }), nil
+	case sqlparser.NullSafeEqualStr:
+		a, b := comparisonAffinity(left, right)
+		if a == nil || b == nil {
+			return boolValue(a == nil && b == nil), nil
+		}
+		return boolValue(db.CompareValues(a, b, comparisonCollation(left, right)) == 0), nil
+	case sqlparser.LikeStr, sqlparser.NotLikeStr:
+		if left.value == nil || right.value == nil {
+			return nil, nil
+		}
+		matched := likeMatch(textValue(right.value), textValue(left.value), in.functions.unicodeCase())
+		if expr.Operator == sqlparser.NotLikeStr {
+			matched = !matched
+		}
+		return boolValue(matched), nil
+	}
+	return nil, fmt.Errorf("unsupported operator: %s", expr.Operator)
+}
+
+func evaluateCase(expr *sqlparser.CaseExpr, in scope) (any, error) {
+	var subject operand
+	if expr.Expr != nil {
+		var err error
+		if subject, err = evaluateOperand(expr.Expr, in); err != nil {
+			return nil, err
+		}
+	}
+	for _, when := range expr.Whens {
+		var matched bool
+		if expr.Expr != nil {
+			candidate, err := evaluateOperand(when.Cond, in)
+			if err != nil {
+				return nil, err
+			}
+			matched = truthOf(compareOperands(subject, candidate, func(c int) bool { return c == 0 })) == truthy
+		} else {
+			condition, err := evaluateTruth(when.Cond, in)
+			if err != nil {
+				return nil, err
+			}
+			matched = condition == truthy
+		}
+		if matched {
+			return evaluate(when.Val, in)
+		}
+	}
+	if expr.Else != nil {
+		return evaluate(expr.Else, in)
+	}
+	return nil, nil
+}
+
+func evaluateFunction(expr *sqlparser.FuncExpr, in scope) (any, error) {
+	args := make([]any, len(expr.Exprs))
+	for i, argument := range expr.Exprs {
+		aliased, ok := argument.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, fmt.Errorf("unsupported function: %s", sqlparser.String(expr))
+		}
+		value, err := evaluate(aliased.Expr, in)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+
+	name := expr.Name.Lowered()
+	arity := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("wrong number of arguments to function %s()", name)
+		}
+		return nil
+	}
+
+	switch name {
+	case "coalesce", "ifnull":
+		if len(args) < 2 || name == "ifnull" && len(args) != 2 {
+			return nil, fmt.Errorf("wrong number of arguments to function %s()", name)
+		}
+		for _, value := range args {
+			if value != nil {
+				return value, nil
+			}
+		}
+		return nil, nil
+	case "nullif":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		if args[0] != nil && args[1] != nil && db.CompareValues(args[0], args[1], db.Binary) == 0 {
+			return nil, nil
+		}
+		return args[0], nil
+	case "random":
+		if err := arity(0); err != nil {
+			return nil, err
+		}
+		return int64(in.functions.source().Uint64()), nil
+	case "randomblob":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		return in.functions.randomBlob(integerValue(args[0]))
+	case "typeof":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		switch args[0].(type) {
+		case nil:
+			return "null", nil
+		case int64:
+			return "integer", nil
+		case float64:
+			return "real", nil
+		case string:
+			return "text", nil
+		}
+		return "blob", nil
+	}
+
+	if err := arity(1); err != nil {
+		return nil, err
+	}
+	if args[0] == nil {
+		return nil, nil
+	}
+	switch name {
+	case "length":
+		if blob, ok := args[0].([]byte); ok {
+			return int64(len(blob)), nil
+		}
+		return int64(utf8.RuneCountInString(textValue(args[0]))), nil
+	case "lower":
+		if in.functions.unicodeCase() {
+			return unicodeLower(textValue(args[0])), nil
+		}
+		return asciiCase(textValue(args[0]), 'A', 'Z', 'a'-'A'), nil
+	case "upper":
+		if in.functions.unicodeCase() {
+			return unicodeUpper(textValue(args[0])), nil
+		}
+		return asciiCase(textValue(args[0]), 'a', 'z', 'A'-'a'), nil
+	case "abs":
+		// Anything but an integer is read as a real
+		switch number := args[0].(type) {
+		case int64:
+			if number == math.MinInt64 {
+				return nil, fmt.Errorf("integer overflow")
+			}
+			if number < 0 {
+				return -number, nil
+			}
+			return number, nil
+		case float64:
+			return math.Abs(number), nil
+		}
+		return math.Abs(realValue(numericValue(args[0]))), nil
+	}
+	return nil, fmt.Errorf("no such function: %s", name)
+}
+
+// asciiCase shifts the case of ASCII letters only, as SQLite's lower and
+// upper do without ICU.
+func asciiCase(text string, from, to byte, shift int) string {
+	out := []byte(text)
+	for i, c := range out {
+		if c >= from && c <= to {
+			out[i] = byte(int(c) + shift)
+		}
+	}
+	return string(out)
+}
+
+// arithmetic applies a binary operator to two non-NULL values. Integer
+// results that overflow become reals, and division or remainder by zero is
+// NULL, as in SQLite.
+func arithmetic(operator string, left, right any) (any, error) {
+	switch operator {
+	case sqlparser.BitAndStr:
+		return integerValue(left) & integerValue(right), nil
+	case sqlparser.BitOrStr:
+		return integerValue(left) | integerValue(right), nil
+	case sqlparser.ShiftLeftStr, sqlparser.ShiftRightStr:
+		// A negative shift goes the other way, as in SQLite
+		leftward, shift := operator == sqlparser.ShiftLeftStr, integerValue(right)
+		if shift < 0 {
+			leftward, shift = !leftward, -max(shift, -64)
+		}
+		value := integerValue(left)
+		switch {
+		case shift >= 64 && (leftward || value >= 0):
+			return int64(0), nil
+		case shift >= 64:
+			return int64(-1), nil
+		case leftward:
+			return value << shift, nil
+		}
+		return value >> shift, nil
+	}
+
+	a, b := numericValue(left), numericValue(right)
+	x, xInteger := a.(int64)
+	y, yInteger := b.(int64)
+	if xInteger && yInteger {
+		switch operator {
+		case sqlparser.PlusStr:
+			if sum := x + y; (sum > x) == (y > 0) {
+				return sum, nil
+			}
+		case sqlparser.MinusStr:
+			if difference := x - y; (difference < x) == (y > 0) {
+				return difference, nil
+			}
+		case sqlparser.MultStr:
+			if x == 0 || y == 0 {
+				return int64(0), nil
+			}
+			if product := x * y; product/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64) {
+				return product, nil
+			}
+		case sqlparser.DivStr, sqlparser.IntDivStr:
+			if y == 0 {
+				return nil, nil
+			}
+			if !(x == math.MinInt64 && y == -1) {
+				return x / y, nil
+			}
+		case sqlparser.ModStr:
+			if y == 0 {
+				return nil, nil
+			}
+			if y == -1 {
+				return int64(0), nil
+			}
+			return x % y, nil
+		default:
+			return nil, fmt.Errorf("unsupported operator: %s", operator)
+		}
+	}
+
+	p, q := realValue(a), realValue(b)
+	var result float64
+	switch operator {
+	case sqlparser.PlusStr:
+		result = p + q
+	case sqlparser.MinusStr:
+		result = p - q
+	case sqlparser.MultStr:
+		result = p * q
+	case sqlparser.DivStr, sqlparser.IntDivStr:
+		if q == 0 {
+			return nil, nil
+		}
+		result = p / q
+	case sqlparser.ModStr:
+		// SQLite takes the remainder of the operands' integer values
+		dividend, divisor := integerValue(a), integerValue(b)
+		switch divisor {
+		case 0:
+			return nil, nil
+		case -1:
+			divisor = 1
+		}
+		return float64(dividend % divisor), nil
+	default:
+		return nil, fmt.Errorf("unsupported operator: %s", operator)
+	}
+	// Infinities that cancel out are NULL
+	if math.IsNaN(result) {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// isLiteral reports whether an expression, inside any parentheses, is the
+// integer literal text.
+func isLiteral(expr sqlparser.Expr, text string) bool {
+	for {
+		paren, ok := expr.(*sqlparser.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.Expr
+	}
+	literal, ok := expr.(*sqlparser.SQLVal)
+	return ok && literal.Type == sqlparser.IntVal && string(literal.Val) == text
+}
+
+// negate returns the negative of a value read as a number. The negative
+// of the smallest integer does not fit in one, so it is a real.
+func negate(value any) any {
+	switch number := numericValue(value).(type) {
+	case int64:
+		if number == math.MinInt64 {
+			return -float64(number)
+		}
+		return -number
+	case float64:
+		return -number
+	}
+	return nil
+}
+
+// numericValue converts a value to the number it reads as in arithmetic:
+// text and blobs become their longest numeric prefix, or 0.
+func numericValue(value any) any {
+	switch value := value.(type) {
+	case int64, float64:
+		return value
+	case string:
+		return numericPrefix(value)
+	case []byte:
+		return numericPrefix(string(value))
+	}
+	return int64(0)
+}
+
+func numericPrefix(text string) any {
+	text = strings.TrimLeft(text, sqlSpaces)
+	end := 0
+	if end < len(text) && (text[end] == '+' || text[end] == '-') {
+		end++
+	}
+	digits := end
+	for end < len(text) && text[end] >= '0' && text[end] <= '9' {
+		end++
+	}
+	integerEnd := end
+	if end < len(text) && text[end] == '.' {
+		for end++; end < len(text) && text[end] >= '0' && text[end] <= '9'; end++ {
+		}
+	}
+	if end > digits && end < len(text) && (text[end] == 'e' || text[end] == 'E') {
+		exponent := end + 1
+		if exponent < len(text) && (text[exponent] == '+' || text[exponent] == '-') {
+			exponent++
+		}
+		if exponent < len(text) && text[exponent] >= '0' && text[exponent] <= '9' {
+			for end = exponent; end < len(text) && text[end] >= '0' && text[end] <= '9'; end++ {
+			}
+		}
+	}
+
+	if end == integerEnd {
+		if integer, err := strconv.ParseInt(text[:end], 10, 64); err == nil {
+			return integer
+		}
+	}
+	if real, ok := parseReal(text[:end]); ok {
+		return real
+	}
+	return int64(0)
+}
+
+// integerValue converts a value to the integer it reads as in bitwise
+// operators and the functions taking one: a real is truncated towards zero,
+// or past either end of the integers taken as that end, as in SQLite.
+func integerValue(value any) int64 {
+	switch number := numericValue(value).(type) {
+	case int64:
+		return number
+	case float64:
+		switch {
+		case math.IsNaN(number):
+			return 0
+		case number <= math.MinInt64:
+			return math.MinInt64
+		case number >= math.MaxInt64:
+			return math.MaxInt64
+		}
+		return int64(number)
+	}
+	return 0
+}
+
+func realValue(number any) float64 {
+	if integer, ok := number.(int64); ok {
+		return float64(integer)
+	}
+	return number.(float64)
+}
+
+// textValue renders a value as the text SQLite converts it to.
+func textValue(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case float64:
+		// Fifteen significant digits, as SQLite converts reals to text
+		return db.FormatReal(value, 15)
+	}
+	return ""
+}
+
+// likeMatch implements LIKE without an ESCAPE clause: % matches any run of
+// characters, _ matches one, and ASCII letters match either case, or with
+// unicode set every letter its simple case folding pairs it with. Letters
+// are compared one at a time, as ICU's LIKE compares them, so that ß does
+// not match ss and _ always matches one character.
+func likeMatch(pattern, text string, unicode bool) bool {
+	for len(pattern) > 0 {
+		p, size := utf8.DecodeRuneInString(pattern)
+		pattern = pattern[size:]
+		switch p {
+		case '%':
+			for {
+				if likeMatch(pattern, text, unicode) {
+					return true
+				}
+				if text == "" {
+					return false
+				}
+				_, size := utf8.DecodeRuneInString(text)
+				text = text[size:]
+			}
+		case '_':
+			if text == "" {
+				return false
+			}
+			_, size := utf8.DecodeRuneInString(text)
+			text = text[size:]
+		default:
+			if text == "" {
+				return false
+			}
+			c, size := utf8.DecodeRuneInString(text)
+			if c != p && !((unicode || c < utf8.RuneSelf && p < utf8.RuneSelf) && strings.EqualFold(string(c), string(p))) {
+				return false
+			}
+			text = text[size:]
+		}
+	}
+	return text == ""
+}
@@ -0,0 +1,206 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+func TestEvaluate(t *testing.T) {
+	// n is an INTEGER column holding 5, s a TEXT column holding '10', and
+	// z a column with no affinity holding NULL, and c a TEXT column
+	// collated NOCASE holding 'Abc'
+	columns := func(name string) (operand, error) {
+		switch name {
+		case "n":
+			return columnOperand(int64(5), ColumnSchema{Affinity: AffinityInteger}), nil
+		case "s":
+			return columnOperand("10", ColumnSchema{Affinity: AffinityText}), nil
+		case "z":
+			return columnOperand(nil, ColumnSchema{Affinity: AffinityBlob}), nil
+		case "c":
+			return columnOperand("Abc", ColumnSchema{Affinity: AffinityText, Collation: "NOCASE"}), nil
+		}
+		return operand{}, fmt.Errorf("no such column: %s", name)
+	}
+
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{"1 + 2 * 3", int64(7)},
+		{"7 / 2", int64(3)},
+		{"7 / 2.0", 3.5},
+		{"7 % 0", nil},
+		{"9223372036854775807 + 1", 9223372036854775808.0},
+		{"-n", int64(-5)},
+		{"'3abc' + 1", int64(4)},
+		// Leading spaces are SQLite's ASCII ones, and a real too large for
+		// a float64 is infinite
+		{"'\v\f 3abc' + 1", int64(4)},
+		{"' \u00a03' + 1", int64(1)},
+		{"'1e400x' * 1", math.Inf(1)},
+		{"'-9223372036854775809' - 0", -9223372036854775808.0},
+		{"n > 0 and n < 10", int64(1)},
+		{"z > 0", nil},
+		{"z > 0 or n = 5", int64(1)},
+		{"z > 0 and n = 4", int64(0)},
+		{"not z", nil},
+		// The INTEGER column's affinity turns '5' into 5
+		{"n = '5'", int64(1)},
+		// The TEXT column's affinity turns 9 into '9', which sorts after '10'
+		{"s > 9", int64(0)},
+		// Text sorts after numbers when neither side has an affinity
+		{"'abc' > 0", int64(1)},
+		{"n between 1 and 5", int64(1)},
+		{"n not between 1 and 4", int64(1)},
+		{"n IN (1, 5)", int64(1)},
+		{"n IN (1, NULL)", nil},
+		{"n NOT IN (1, 2)", int64(1)},
+		{"z IS NULL", int64(1)},
+		{"n IS NOT NULL", int64(1)},
+		{"s LIKE '1_'", int64(1)},
+		{"'Hello' LIKE 'h%O'", int64(1)},
+		{"length(s)", int64(2)},
+		{"length(n * 100)", int64(3)},
+		{"upper('abc')", "ABC"},
+		{"typeof(2.0)", "real"},
+		{"coalesce(z, s)", "10"},
+		{"abs(-4)", int64(4)},
+		{"CASE WHEN n > 3 THEN 'big' ELSE 'small' END", "big"},
+		{"CASE n WHEN 1 THEN 'one' END", nil},
+		// The column's collation applies on either side, and an explicit
+		// COLLATE overrides it
+		{"c = 'ABC'", int64(1)},
+		{"'aBC' = c", int64(1)},
+		{"c = 'ABC ' COLLATE rtrim", int64(0)},
+		{"'abc  ' = 'ABC' COLLATE rtrim", int64(0)},
+		{"'abc  ' = 'abc' COLLATE rtrim", int64(1)},
+		{"'abc' < 'ABD' COLLATE nocase", int64(1)},
+		{"c IN ('x', 'ABC')", int64(1)},
+		{"nullif(c, 'abc')", "Abc"},
+	}
+	for _, test := range tests {
+		expr, err := parseExpression(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		got, err := evaluate(expr, scope{column: columns})
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %#v, %v; want %#v", test.expr, got, err, test.want)
+		}
+	}
+
+	expr, _ := parseExpression("missing + 1")
+	if _, err := evaluate(expr, scope{column: columns}); err == nil || err.Error() != "no such column: missing" {
+		t.Errorf("unknown column error %v", err)
+	}
+}
+
+// TestComparisonsFollowCompareValues checks every comparison operator
+// orders values of each storage class as db.CompareValues does, with the
+// collation taken from the column.
+func TestComparisonsFollowCompareValues(t *testing.T) {
+	values := []any{int64(-1), 0.5, int64(1 << 53), float64(1 << 53), "", "a", "A", "a ", "b", []byte{}, []byte("a"), []byte{0xff}}
+	want := map[string]func(int) bool{
+		"a < b":                           func(c int) bool { return c < 0 },
+		"a <= b":                          func(c int) bool { return c <= 0 },
+		"a = b":                           func(c int) bool { return c == 0 },
+		"a <> b":                          func(c int) bool { return c != 0 },
+		"a >= b":                          func(c int) bool { return c >= 0 },
+		"a > b":                           func(c int) bool { return c > 0 },
+		"a <=> b":                         func(c int) bool { return c == 0 },
+		"a IN (b)":                        func(c int) bool { return c == 0 },
+		"a BETWEEN b AND b":               func(c int) bool { return c == 0 },
+		"CASE a WHEN b THEN 1 ELSE 0 END": func(c int) bool { return c == 0 },
+	}
+	for _, collation := range []db.Collation{db.Binary, db.NoCase, db.RTrim} {
+		for _, a := range values {
+			for _, b := range values {
+				// Neither column has an affinity, so values compare as
+				// they are, and a's collation orders text
+				columns := func(name string) (operand, error) {
+					if name == "a" {
+						return columnOperand(a, ColumnSchema{Affinity: AffinityBlob, Collation: collation.String()}), nil
+					}
+					return columnOperand(b, ColumnSchema{Affinity: AffinityBlob}), nil
+				}
+				order := db.CompareValues(a, b, collation)
+				for text, test := range want {
+					expr, err := parseExpression(text)
+					if err != nil {
+						t.Fatalf("%s: %v", text, err)
+					}
+					got, err := evaluate(expr, scope{column: columns})
+					if err != nil {
+						t.Fatalf("%s: %v", text, err)
+					}
+					if got != boolValue(test(order)) {
+						t.Errorf("%s with a = %#v, b = %#v under %s: %v, CompareValues %d", text, a, b, collation, got, order)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestArithmeticMatchesSQLite evaluates every operator on integers at and
+// around the ends of the int64 range, reals and text, and compares the
+// type and value of each result with sqlite3's: integer results that
+// overflow become reals, and division or remainder by zero is NULL.
+func TestArithmeticMatchesSQLite(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	operands := []string{
+		"9223372036854775807", "-9223372036854775808", "(-9223372036854775807 - 1)", "9223372036854775808",
+		"4611686018427387904", "-3037000500", "-1", "0", "1", "64", "-64", "3.5", "-0.0", "1e308", "'-1'", "'x'", "NULL",
+	}
+	var exprs []string
+	for _, a := range operands {
+		for _, b := range operands {
+			for _, operator := range []string{"+", "-", "*", "/", "%", "<<", ">>", "&", "|"} {
+				exprs = append(exprs, a+" "+operator+" "+b)
+			}
+		}
+		exprs = append(exprs, "-("+a+")", "~("+a+")")
+		if a != "-9223372036854775808" && a != "(-9223372036854775807 - 1)" {
+			exprs = append(exprs, "abs("+a+")")
+		}
+	}
+	exprs = append(exprs, "1e308 * 10 - 1e308 * 10", "(1e308 * 10) / (1e308 * 10)", "- 9223372036854775808", "-((9223372036854775808))", "9223372036854775807 + 1 - 1")
+
+	database, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	queries := make([]string, len(exprs))
+	for i, expr := range exprs {
+		queries[i] = fmt.Sprintf("SELECT typeof(%s), %[1]s", expr)
+	}
+	// The queries are too long an argument, so sqlite3 reads them
+	command := exec.Command(sqlite3, ":memory:")
+	command.Stdin = strings.NewReader(strings.Join(queries, ";\n"))
+	output, err := command.CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3: %v\n%s", err, output)
+	}
+	want := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	if len(want) != len(exprs) {
+		t.Fatalf("sqlite3 returned %d rows for %d expressions", len(want), len(exprs))
+	}
+	for i, query := range queries {
+		rows := queryRows(t, database, query)
+		if got := fmt.Sprintf("%s|%s", rows[0][0], textValue(rows[0][1])); got != want[i] {
+			t.Errorf("%s = %s, sqlite3 %s", exprs[i], got, want[i])
+		}
+	}
+}
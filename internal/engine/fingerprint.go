@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// sqlKeywords are SQLite's keywords, which NormalizeSQL lowercases.
+var sqlKeywords = func() map[string]bool {
+	keywords := make(map[string]bool)
+	for _, keyword := range strings.Fields(`
+		ABORT ACTION ADD AFTER ALL ALTER ALWAYS ANALYZE AND AS ASC ATTACH
+		AUTOINCREMENT BEFORE BEGIN BETWEEN BY CASCADE CASE CAST CHECK COLLATE
+		COLUMN COMMIT CONFLICT CONSTRAINT CREATE CROSS CURRENT CURRENT_DATE
+		CURRENT_TIME CURRENT_TIMESTAMP DATABASE DEFAULT DEFERRABLE DEFERRED
+		DELETE DESC DETACH DISTINCT DO DROP EACH ELSE END ESCAPE EXCEPT
+		EXCLUDE EXCLUSIVE EXISTS EXPLAIN FAIL FILTER FIRST FOLLOWING FOR
+		FOREIGN FROM FULL GENERATED GLOB GROUP GROUPS HAVING IF IGNORE
+		IMMEDIATE IN INDEX INDEXED INITIALLY INNER INSERT INSTEAD INTERSECT
+		INTO IS ISNULL JOIN KEY LAST LEFT LIKE LIMIT MATCH MATERIALIZED
+		NATURAL NO NOT NOTHING NOTNULL NULL NULLS OF OFFSET ON OR ORDER OTHERS
+		OUTER OVER PARTITION PLAN PRAGMA PRECEDING PRIMARY QUERY RAISE RANGE
+		RECURSIVE REFERENCES REGEXP REINDEX RELEASE RENAME REPLACE RESTRICT
+		RETURNING RIGHT ROLLBACK ROW ROWS SAVEPOINT SELECT SET TABLE TEMP
+		TEMPORARY THEN TIES TO TRANSACTION TRIGGER UNBOUNDED UNION UNIQUE
+		UPDATE USING VACUUM VALUES VIEW VIRTUAL WHEN WHERE WINDOW WITH WITHOUT`) {
+		keywords[keyword] = true
+	}
+	return keywords
+}()
+
+// operators are the operators of two characters, which scanSQL reads as
+// two tokens.
+var operators = map[string]bool{"!=": true, "<>": true, "<=": true, ">=": true, "==": true, "||": true, "<<": true, ">>": true, "->": true}
+
+// NormalizeSQL returns the shape of a statement, the same for statements
+// that differ only in their literals, in the case of their keywords, or in
+// spacing and comments: every string, blob and number literal becomes ?,
+// keywords are lowercased, and tokens are spaced one way, as in
+//
+//	select name from customers where id = ? and tag in (?, ?)
+//
+// Identifiers keep their case, and lists of literals keep their length.
+func NormalizeSQL(sql string) string {
+	return canonicalSQL(sql, true)
+}
+
+// Fingerprint returns a hash of a statement's normalized form, as 16 hex
+// digits, which statements of the same shape share.
+func Fingerprint(sql string) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(NormalizeSQL(sql)))
+	return fmt.Sprintf("%016x", hash.Sum64())
+}
+
+// canonicalSQL respaces a statement's tokens one way, without comments or a
+// trailing semicolon. With shape set, literals become ? and keywords are
+// lowercased, as NormalizeSQL returns it; otherwise both are kept as
+// written.
+func canonicalSQL(sql string, shape bool) string {
+	text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(sql), ";"))
+	type word struct {
+		text    string
+		literal bool
+		// name is set for an identifier that is not a keyword, which a
+		// parenthesis follows without a space as in a call
+		name bool
+	}
+	var words []word
+	tokens := scanSQL(text)
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		spelled := text[token.start:token.end]
+		adjacent := i+1 < len(tokens) && tokens[i+1].start == token.end
+		var previous *word
+		if len(words) > 0 {
+			previous = &words[len(words)-1]
+		}
+		switch {
+		// A blob literal, X'...', reads as a name and then a string
+		case strings.EqualFold(spelled, "x") && adjacent && text[tokens[i+1].start] == '\'':
+			i++
+			words = append(words, word{text: text[token.start:tokens[i].end], literal: true})
+		case spelled == "-" || spelled == "+":
+			// A sign before a number is part of the literal, unless it is
+			// subtracting or adding to what came before
+			unary := previous == nil || !previous.literal && !previous.name && previous.text != ")"
+			if unary && adjacent && isDigit(text[tokens[i+1].start]) {
+				i++
+				words = append(words, word{text: text[token.start:tokens[i].end], literal: true})
+				continue
+			}
+			words = append(words, word{text: spelled})
+		case text[token.start] == '\'' || isDigit(text[token.start]):
+			words = append(words, word{text: spelled, literal: true})
+		case token.name != "" && !token.quoted && sqlKeywords[strings.ToUpper(token.name)]:
+			if shape {
+				spelled = strings.ToLower(spelled)
+			}
+			words = append(words, word{text: spelled})
+		case token.name != "":
+			words = append(words, word{text: spelled, name: true})
+		case previous != nil && !previous.literal && !previous.name && tokens[i-1].end == token.start && operators[previous.text+spelled]:
+			previous.text += spelled
+		default:
+			words = append(words, word{text: spelled})
+		}
+	}
+
+	var normalized strings.Builder
+	for i, w := range words {
+		if shape && w.literal {
+			w.text = "?"
+		}
+		if i > 0 {
+			previous := words[i-1]
+			switch {
+			case w.text == ")" || w.text == "," || w.text == "." || w.text == ";":
+			case previous.text == "(" || previous.text == ".":
+			case w.text == "(" && previous.name:
+			default:
+				normalized.WriteByte(' ')
+			}
+		}
+		normalized.WriteString(w.text)
+	}
+	return normalized.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
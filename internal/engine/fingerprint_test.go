@@ -0,0 +1,45 @@
+package engine
+
+import "testing"
+
+func TestNormalizeSQL(t *testing.T) {
+	for sql, want := range map[string]string{
+		"SELECT name FROM customers WHERE id = 1":                    "select name from customers where id = ?",
+		"select  Name\n  from customers -- by id\n where id=42;":     "select Name from customers where id = ?",
+		"SELECT count(*) FROM t WHERE a IN ('x', 'it''s', X'0aff')":  "select count(*) from t where a in (?, ?, ?)",
+		"SELECT * FROM t WHERE a >= -1.5e3 AND b!=+2 AND c <> 3":     "select * from t where a >= ? and b != ? and c <> ?",
+		"SELECT a - 1, (a)-1, a||'s' FROM t":                         "select a - ?, (a) - ?, a || ? from t",
+		"INSERT INTO main.t(a, b) VALUES (1, NULL)":                  "insert into main.t(a, b) values (?, null)",
+		`SELECT "Select", [From] FROM "t"`:                           `select "Select", [From] from "t"`,
+		"SELECT sum(x) OVER (PARTITION BY y ORDER BY z DESC) FROM t": "select sum(x) over (partition by y order by z desc) from t",
+	} {
+		if got := NormalizeSQL(sql); got != want {
+			t.Errorf("NormalizeSQL(%q)\n got %q\nwant %q", sql, got, want)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	same := []string{
+		"SELECT name FROM customers WHERE id = 1",
+		"select name from customers where id=2",
+		"SELECT name\nFROM customers\nWHERE id = -3; ",
+	}
+	for _, sql := range same[1:] {
+		if Fingerprint(sql) != Fingerprint(same[0]) {
+			t.Errorf("%q and %q fingerprint differently", sql, same[0])
+		}
+	}
+	if got := Fingerprint(same[0]); len(got) != 16 {
+		t.Errorf("fingerprint %q is not 16 hex digits", got)
+	}
+	for _, sql := range []string{
+		"SELECT name FROM customers WHERE tag = 1",
+		"SELECT Name FROM customers WHERE id = 1",
+		"SELECT name FROM customers WHERE id IN (1, 2)",
+	} {
+		if Fingerprint(sql) == Fingerprint(same[0]) {
+			t.Errorf("%q fingerprints as %q does", sql, same[0])
+		}
+	}
+}
@@ -0,0 +1,501 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// parentKey is a foreign key resolved against its parent table: the parent
+// columns it refers to, by position, paired with the child's columns.
+type parentKey struct {
+	foreignKey ForeignKey
+	// id numbers the key as PRAGMA foreign_key_list does, from the last
+	// declared
+	id       int
+	child    []int
+	parent   *TableSchema
+	position []int
+}
+
+// errForeignKeyMismatch reports a foreign key whose parent columns are not
+// a primary key or unique key of the parent, as SQLite words it.
+func errForeignKeyMismatch(child *TableSchema, foreignKey ForeignKey) error {
+	return fmt.Errorf("foreign key mismatch - %q referencing %q", child.Name, foreignKey.ParentTable)
+}
+
+// resolveForeignKeys resolves every foreign key of a table, in id order. A
+// key whose parent table does not exist resolves with a nil parent, which
+// every row with a non-NULL key violates.
+func (database *Database) resolveForeignKeys(child *TableSchema) ([]parentKey, error) {
+	keys := make([]parentKey, 0, len(child.ForeignKeys))
+	for id := range child.ForeignKeys {
+		key, err := database.resolveForeignKey(child, id)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// resolveForeignKey resolves the foreign key of a table with the given id.
+func (database *Database) resolveForeignKey(child *TableSchema, id int) (parentKey, error) {
+	foreignKey := child.ForeignKeys[len(child.ForeignKeys)-1-id]
+	key := parentKey{foreignKey: foreignKey, id: id}
+
+	for _, name := range foreignKey.Columns {
+		position, ok := child.ColumnIndex(name)
+		if !ok {
+			return key, fmt.Errorf("unknown column %q in foreign key definition", name)
+		}
+		key.child = append(key.child, position)
+	}
+
+	parent, err := database.TableSchema(foreignKey.ParentTable)
+	if errors.Is(err, ErrNoSuchTable) {
+		return key, nil
+	}
+	if err != nil {
+		return key, err
+	}
+
+	// The parent columns must be its primary key or another unique key
+	columns := foreignKey.ParentColumns
+	if len(columns) == 0 {
+		columns = parent.PrimaryKey
+	}
+	if len(columns) == 0 || len(columns) != len(key.child) || !isUniqueKey(parent, columns) {
+		return key, errForeignKeyMismatch(child, foreignKey)
+	}
+	for _, name := range columns {
+		position, ok := parent.ColumnIndex(name)
+		if !ok {
+			return key, errForeignKeyMismatch(child, foreignKey)
+		}
+		key.position = append(key.position, position)
+	}
+	key.parent = parent
+	return key, nil
+}
+
+// normalizedColumns lowercases and sorts column names, so that keys can be
+// compared regardless of case and order.
+func normalizedColumns(names []string) []string {
+	lowered := make([]string, len(names))
+	for i, name := range names {
+		lowered[i] = strings.ToLower(name)
+	}
+	slices.Sort(lowered)
+	return lowered
+}
+
+// isUniqueKey reports whether columns, in any order, are exactly one of the
+// table's unique keys.
+func isUniqueKey(table *TableSchema, columns []string) bool {
+	want := normalizedColumns(columns)
+	if len(want) == 1 && table.RowIDAlias >= 0 && want[0] == strings.ToLower(table.Columns[table.RowIDAlias].Name) {
+		return true
+	}
+	for _, key := range table.UniqueKeys {
+		if slices.Equal(normalizedColumns(key), want) {
+			return true
+		}
+	}
+	return false
+}
+
+// satisfied reports whether a child row's values for a foreign key refer to
+// an existing parent row. Keys with a NULL column are always satisfied.
+// Child values are compared with the parent column's affinity applied, so
+// '1' in the child matches 1 in an INTEGER parent key. The parent row is
+// found by rowid for a key aliasing it, and otherwise through the planner,
+// which probes an index on the parent key where there is one.
+func (database *Database) satisfied(key parentKey, child []any) (bool, error) {
+	values := make([]any, len(key.child))
+	for i, position := range key.child {
+		if child[position] == nil {
+			return true, nil
+		}
+		values[i] = child[position]
+	}
+	if key.parent == nil {
+		return false, nil
+	}
+
+	parent := key.parent
+	for i, position := range key.position {
+		values[i] = applyAffinity(values[i], parent.Columns[position].Affinity)
+	}
+
+	if len(key.position) == 1 && key.position[0] == parent.RowIDAlias {
+		rowID, ok := values[0].(int64)
+		if !ok {
+			return false, nil
+		}
+		row, err := database.file.SeekRowID(database.header, parent.RootPage, rowID)
+		return row != nil, err
+	}
+
+	lookup := &selectQuery{table: parent.Name, star: true, limit: 1}
+	for i, position := range key.position {
+		lookup.filters = append(lookup.filters, equalityFilter{column: parent.Columns[position].Name, values: []any{values[i]}})
+	}
+	resultSet, err := database.prepareSelect(lookup)
+	if err != nil {
+		return false, err
+	}
+	defer resultSet.Close()
+	found := resultSet.Next()
+	return found, resultSet.Err()
+}
+
+// foreignKeyViolations returns the ids of the foreign keys a child row
+// violates, in id order.
+func (database *Database) foreignKeyViolations(keys []parentKey, child []any) ([]int, error) {
+	var violated []int
+	for _, key := range keys {
+		ok, err := database.satisfied(key, child)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			violated = append(violated, key.id)
+		}
+	}
+	return violated, nil
+}
+
+// childKey is a foreign key of a child table, resolved against the parent
+// table it refers to.
+type childKey struct {
+	table *TableSchema
+	key   parentKey
+}
+
+// refersTo reports whether a table's declared foreign keys refer to parent,
+// reading its CREATE TABLE statement alone.
+func refersTo(object db.TableMetadata, parent *TableSchema) bool {
+	table, err := parseTableSchema(object)
+	return err != nil || slices.ContainsFunc(table.ForeignKeys, func(key ForeignKey) bool {
+		return strings.EqualFold(key.ParentTable, parent.Name)
+	})
+}
+
+// referringKeys returns the foreign keys, of every table, that refer to
+// rows of parent.
+func (database *Database) referringKeys(parent *TableSchema) ([]childKey, error) {
+	objects, err := database.SchemaObjects()
+	if err != nil {
+		return nil, err
+	}
+	var keys []childKey
+	for _, object := range objects {
+		if object.Type != "table" {
+			continue
+		}
+		table, err := database.TableSchema(object.Name)
+		if errors.Is(err, ErrWithoutRowID) && !refersTo(object, parent) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for id := range table.ForeignKeys {
+			if !strings.EqualFold(table.ForeignKeys[len(table.ForeignKeys)-1-id].ParentTable, parent.Name) {
+				continue
+			}
+			key, err := database.resolveForeignKey(table, id)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, childKey{table: table, key: key})
+		}
+	}
+	return keys, nil
+}
+
+// keyValues returns a row's values for the given columns, and whether any
+// of them is NULL.
+func keyValues(row []any, positions []int) ([]any, bool) {
+	values := make([]any, len(positions))
+	null := false
+	for i, position := range positions {
+		values[i] = row[position]
+		null = null || values[i] == nil
+	}
+	return values, null
+}
+
+// sameValues reports whether two keys hold the same values, with NULL the
+// same only as NULL, as IS compares them.
+func sameValues(key, other []any) bool {
+	return slices.EqualFunc(key, other, func(value, other any) bool {
+		return value == nil && other == nil || value != nil && other != nil && db.CompareValues(value, other, db.Binary) == 0
+	})
+}
+
+// referringRows returns the rowids of the child rows whose key refers to
+// the parent key values. As in satisfied, each child value is compared with
+// the parent column's affinity applied and by its collation. When the
+// child columns share the parent's affinity, their stored values already
+// have it, and the planner finds the rows through an index where there is
+// one; otherwise the child table is scanned.
+func (database *Database) referringRows(child childKey, values []any) ([]int64, error) {
+	table, parent := child.table, child.key.parent
+	lookup := &selectQuery{table: table.Name, star: true, limit: -1}
+	sameAffinity := true
+	for i, position := range child.key.child {
+		column := table.Columns[position]
+		sameAffinity = sameAffinity && column.Affinity == parent.Columns[child.key.position[i]].Affinity
+		lookup.filters = append(lookup.filters, equalityFilter{column: column.Name, values: []any{values[i]}, position: position, collation: parent.columnCollation(child.key.position[i])})
+	}
+
+	var rowIDs []int64
+	emit := func(row *db.Row) bool {
+		for i, position := range child.key.child {
+			value := applyAffinity(columnValue(row, table, position), parent.Columns[child.key.position[i]].Affinity)
+			if !valuesEqual(value, values[i], lookup.filters[i].collation) {
+				return true
+			}
+		}
+		rowIDs = append(rowIDs, row.RowID)
+		return true
+	}
+	var stats scanStats
+	var err error
+	switch queryPlan := planSelect(lookup, table); {
+	case !sameAffinity:
+		err = tableScan(database.file, database.header, table, nil, false, &stats, emit)
+	case queryPlan.seekRowID:
+		err = rowIDSeek(database.file, database.header, table, queryPlan, &stats, emit)
+	case queryPlan.index != nil:
+		err = indexScan(database.file, database.header, table, queryPlan, &stats, emit)
+	default:
+		err = tableScan(database.file, database.header, table, nil, false, &stats, emit)
+	}
+	return rowIDs, err
+}
+
+// pendingKey is a parent key a statement removed while child rows still
+// referred to it through a NO ACTION foreign key.
+type pendingKey struct {
+	child  childKey
+	values []any
+}
+
+// pendingKeys are checked once their statement is done, as SQLite checks
+// NO ACTION keys, so that a later row of the statement may delete or move
+// the rows referring to a key, or restore it.
+type pendingKeys []pendingKey
+
+// check fails with a FOREIGN KEY constraint error for the first pending key
+// that is still missing from its parent table while rows refer to it.
+func (pending pendingKeys) check(database *Database) error {
+	for _, removed := range pending {
+		row := make([]any, len(removed.child.table.Columns))
+		for i, position := range removed.child.key.child {
+			row[position] = removed.values[i]
+		}
+		restored, err := database.satisfied(removed.child.key, row)
+		if err != nil {
+			return err
+		}
+		if restored {
+			continue
+		}
+		rowIDs, err := database.referringRows(removed.child, removed.values)
+		if err != nil {
+			return err
+		}
+		if len(rowIDs) > 0 {
+			return &ConstraintError{Kind: "FOREIGN KEY"}
+		}
+	}
+	return nil
+}
+
+// parentChanged carries out the foreign key actions for a row of parent
+// that was deleted, when updated is nil, or rewritten as updated, when
+// foreign keys are enabled. Only keys that held no NULL, and whose values
+// changed, have an action to take on the rows referring to them: RESTRICT
+// fails at once, NO ACTION adds the key to pending, CASCADE deletes the
+// rows or moves them to the new key, and SET NULL and SET DEFAULT rewrite
+// their key columns.
+func (database *Database) parentChanged(pager *db.Pager, pending *pendingKeys, parent *TableSchema, existing, updated []any) error {
+	if !database.foreignKeys {
+		return nil
+	}
+	keys, err := database.referringKeys(parent)
+	if err != nil {
+		return err
+	}
+	for _, child := range keys {
+		values, null := keyValues(existing, child.key.position)
+		if null {
+			continue
+		}
+		action := child.key.foreignKey.OnDelete
+		var moved []any
+		if updated != nil {
+			moved, _ = keyValues(updated, child.key.position)
+			if sameValues(values, moved) {
+				continue
+			}
+			action = child.key.foreignKey.OnUpdate
+		}
+		rowIDs, err := database.referringRows(child, values)
+		if err != nil {
+			return err
+		}
+		if len(rowIDs) == 0 {
+			continue
+		}
+
+		switch action {
+		case "RESTRICT":
+			return &ConstraintError{Kind: "FOREIGN KEY"}
+		case "CASCADE", "SET NULL", "SET DEFAULT":
+		default:
+			*pending = append(*pending, pendingKey{child: child, values: values})
+			continue
+		}
+		table := child.table
+		for _, rowID := range rowIDs {
+			// A row an earlier cascade deleted has nothing left to do
+			stored, err := database.file.SeekRowID(database.header, table.RootPage, rowID)
+			if err != nil {
+				return err
+			}
+			if stored == nil {
+				continue
+			}
+			if action == "CASCADE" && updated == nil {
+				if err := database.removeRow(pager, pending, table, rowID); err != nil {
+					return err
+				}
+				database.rowChanged(OpDelete, table.Name, rowID)
+				continue
+			}
+			row, err := database.readRow(table, rowID)
+			if err != nil {
+				return err
+			}
+			rewritten := slices.Clone(row)
+			for i, position := range child.key.child {
+				column := table.Columns[position]
+				switch action {
+				case "CASCADE":
+					rewritten[position] = moved[i]
+				case "SET NULL":
+					rewritten[position] = nil
+				case "SET DEFAULT":
+					if rewritten[position], err = defaultValue(column, database.functionState()); err != nil {
+						return fmt.Errorf("default for %s.%s: %w", table.Name, column.Name, err)
+					}
+				}
+				rewritten[position] = applyAffinity(rewritten[position], column.Affinity)
+			}
+			newRowID := rowID
+			if table.RowIDAlias >= 0 {
+				var ok bool
+				if newRowID, ok = rewritten[table.RowIDAlias].(int64); !ok {
+					return fmt.Errorf("datatype mismatch")
+				}
+			}
+			if err := database.changeRow(pager, pending, table, rowID, row, newRowID, rewritten); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setForeignKeys handles PRAGMA foreign_keys = value. Like SQLite, it
+// accepts the usual spellings of a boolean and ignores anything else.
+func (database *Database) setForeignKeys(value string) {
+	switch strings.ToLower(value) {
+	case "1", "on", "true", "yes":
+		database.foreignKeys = true
+	case "0", "off", "false", "no":
+		database.foreignKeys = false
+	}
+}
+
+// foreignKeyCheck implements PRAGMA foreign_key_check: every row, of the
+// named table or of every table, whose foreign key refers to a missing
+// parent row. Rows are reported in rowid order, each with the violated
+// key's id.
+func (database *Database) foreignKeyCheck(tableName string) (*ResultSet, error) {
+	var tables []*TableSchema
+	if tableName != "" {
+		table, err := database.TableSchema(tableName)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	} else {
+		objects, err := database.SchemaObjects()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			if object.Type != "table" {
+				continue
+			}
+			table, err := database.TableSchema(object.Name)
+			if err != nil {
+				return nil, err
+			}
+			tables = append(tables, table)
+		}
+	}
+
+	// Mismatched keys are reported before any row is read
+	keys := make([][]parentKey, len(tables))
+	for i, table := range tables {
+		var err error
+		if keys[i], err = database.resolveForeignKeys(table); err != nil {
+			return nil, err
+		}
+	}
+
+	columns := []ResultColumn{{Name: "table"}, {Name: "rowid"}, {Name: "parent"}, {Name: "fkid"}}
+	return newResultSet(columns, func(yield func([]any, error) bool) {
+		for i, table := range tables {
+			if len(keys[i]) == 0 {
+				continue
+			}
+			positions, _ := projection(&selectQuery{star: true}, table)
+			var violation error
+			stopped := false
+			err := tableScan(database.file, database.header, table, nil, false, &scanStats{}, func(row *db.Row) bool {
+				violated, err := database.foreignKeyViolations(keys[i], project(row, table, positions))
+				if err != nil {
+					violation = err
+					return false
+				}
+				for _, id := range violated {
+					if !yield([]any{table.Name, row.RowID, keys[i][id].foreignKey.ParentTable, int64(id)}, nil) {
+						stopped = true
+						return false
+					}
+				}
+				return true
+			})
+			if stopped {
+				return
+			}
+			if err == nil {
+				err = violation
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+	}, nil), nil
+}
@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+const foreignKeyFixture = "../../app/testdata/conformance/foreign_key_check.db"
+
+func TestAutoindexesTakeConstraintColumns(t *testing.T) {
+	database := openDatabase(t, foreignKeyFixture)
+	tests := []struct {
+		table string
+		want  []IndexSchema
+	}{
+		// The INTEGER PRIMARY KEY needs no index, so UNIQUE (a, b) is the first
+		{"p", []IndexSchema{{Name: "sqlite_autoindex_p_1", RootPage: 3, Columns: []string{"a", "b"}, Collations: []db.Collation{db.Binary, db.Binary}, Unique: true}}},
+		{"q", []IndexSchema{{Name: "sqlite_autoindex_q_1", RootPage: 5, Columns: []string{"k"}, Collations: []db.Collation{db.Binary}, Unique: true}}},
+	}
+	for _, test := range tests {
+		table, err := database.TableSchema(test.table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(table.Indexes, test.want) {
+			t.Errorf("%s indexes = %+v, want %+v", test.table, table.Indexes, test.want)
+		}
+	}
+}
+
+func TestForeignKeyCheckProbesParentIndex(t *testing.T) {
+	database := openDatabase(t, foreignKeyFixture)
+	child, err := database.TableSchema("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := database.resolveForeignKeys(child)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		row  []any
+		want []int
+	}{
+		{[]any{int64(1), int64(1), "a", int64(1), "k1"}, nil},
+		// '1' matches the INTEGER PRIMARY KEY once affinity is applied
+		{[]any{int64(2), "1", "b", int64(2), "k1"}, nil},
+		{[]any{int64(3), int64(3), "a", int64(2), nil}, []int{0, 2}},
+		// A NULL in any key column satisfies the key
+		{[]any{int64(4), nil, nil, int64(5), "zz"}, []int{1}},
+	}
+	for _, test := range tests {
+		got, err := database.foreignKeyViolations(keys, test.row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("violations of %v = %v, want %v", test.row, got, test.want)
+		}
+	}
+}
+
+func TestForeignKeyCheckReportsMismatch(t *testing.T) {
+	database := testgen.New(testgen.Options{})
+	parent := database.CreateTable("parent", "CREATE TABLE parent (id integer primary key, name text)")
+	parent.Insert(1, nil, "one")
+	child := database.CreateTable("child", "CREATE TABLE child (name text REFERENCES parent (name))")
+	child.Insert(1, "one")
+
+	_, err := openDatabase(t, database.WriteTemp(t)).Query("PRAGMA foreign_key_check")
+	if err == nil || !strings.Contains(err.Error(), `foreign key mismatch - "child" referencing "parent"`) {
+		t.Fatalf("foreign_key_check on a non-unique parent key: %v", err)
+	}
+}
+
+func TestApplyAffinity(t *testing.T) {
+	tests := []struct {
+		value    any
+		affinity Affinity
+		want     any
+	}{
+		{"42", AffinityInteger, int64(42)},
+		{" 42 ", AffinityNumeric, int64(42)},
+		{"4.0", AffinityInteger, int64(4)},
+		{"4.5", AffinityNumeric, 4.5},
+		{"42", AffinityReal, 42.0},
+		{"42", AffinityText, "42"},
+		{"42", AffinityBlob, "42"},
+		{"inf", AffinityNumeric, "inf"},
+		{"0x10", AffinityInteger, "0x10"},
+		{"forty", AffinityInteger, "forty"},
+		{int64(7), AffinityText, "7"},
+		{2.0, AffinityText, "2.0"},
+		{2.0, AffinityInteger, int64(2)},
+		{2.5, AffinityInteger, 2.5},
+		{int64(7), AffinityReal, 7.0},
+		{int64(7), AffinityBlob, int64(7)},
+		{[]byte("7"), AffinityInteger, []byte("7")},
+	}
+	for _, test := range tests {
+		if got := applyAffinity(test.value, test.affinity); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("applyAffinity(%#v, %v) = %#v, want %#v", test.value, test.affinity, got, test.want)
+		}
+	}
+}
+
+func TestForeignKeyActionsMatchSQLite(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+
+	schema := func(onDelete, onUpdate string) []string {
+		return []string{
+			"PRAGMA foreign_keys = ON",
+			"CREATE TABLE p (id integer primary key, k text unique)",
+			"CREATE TABLE c (x, pid integer default 2 REFERENCES p ON DELETE " + onDelete + " ON UPDATE " + onUpdate + ", pk REFERENCES p (k))",
+			"INSERT INTO p VALUES (1, 'a'), (2, 'b'), (3, 'c')",
+			"INSERT INTO c VALUES (1, 1, NULL), (2, 1, 'b'), (3, 3, NULL), (4, NULL, 'c')",
+		}
+	}
+	tests := []struct {
+		onDelete, onUpdate string
+		statements         []string
+	}{
+		{"NO ACTION", "NO ACTION", []string{"DELETE FROM p WHERE id = 2"}},
+		{"NO ACTION", "NO ACTION", []string{"DELETE FROM p WHERE id = 1"}},
+		{"NO ACTION", "NO ACTION", []string{"UPDATE p SET id = 10 WHERE id = 3"}},
+		{"NO ACTION", "NO ACTION", []string{"UPDATE p SET k = 'z' WHERE id = 2"}},
+		// A key no longer referred to by the end of the statement is fine
+		{"NO ACTION", "NO ACTION", []string{"UPDATE p SET k = 'z' WHERE id = 1"}},
+		{"NO ACTION", "NO ACTION", []string{"INSERT OR REPLACE INTO p VALUES (1, 'z')"}},
+		{"RESTRICT", "RESTRICT", []string{"DELETE FROM p WHERE id = 1"}},
+		{"RESTRICT", "RESTRICT", []string{"UPDATE p SET id = id + 10 WHERE id = 3"}},
+		{"CASCADE", "CASCADE", []string{"DELETE FROM p WHERE id = 1"}},
+		{"CASCADE", "CASCADE", []string{"UPDATE p SET id = id + 10"}},
+		// The cascade deletes child 2, which referred to 'b' as well
+		{"CASCADE", "NO ACTION", []string{"DELETE FROM p WHERE id <> 2"}},
+		{"CASCADE", "NO ACTION", []string{"INSERT OR REPLACE INTO p VALUES (1, 'z')"}},
+		{"CASCADE", "CASCADE", []string{"INSERT INTO p VALUES (3, 'q') ON CONFLICT (id) DO UPDATE SET id = 30"}},
+		{"SET NULL", "SET NULL", []string{"DELETE FROM p WHERE id = 1", "UPDATE p SET id = 13 WHERE id = 3"}},
+		// SET DEFAULT breaks the key when the default has no parent
+		{"SET DEFAULT", "SET DEFAULT", []string{"DELETE FROM p WHERE id = 1", "DELETE FROM p WHERE id = 2"}},
+		{"SET DEFAULT", "SET DEFAULT", []string{"UPDATE p SET id = 10 WHERE id = 3", "DELETE FROM p WHERE id = 2"}},
+		// Child rows are checked when their own key changes
+		{"NO ACTION", "NO ACTION", []string{"UPDATE c SET x = x + 1", "UPDATE c SET pid = 5 WHERE x = 2"}},
+		{"NO ACTION", "NO ACTION", []string{"UPDATE c SET pk = 'c', pid = '2' WHERE x = 1"}},
+	}
+	for _, test := range tests {
+		runAgainstSQLite(t, sqlite3, append(schema(test.onDelete, test.onUpdate), test.statements...), "p", "c")
+	}
+
+	// A self-referencing NO ACTION key is checked once every row is deleted
+	runAgainstSQLite(t, sqlite3, []string{
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE s (id integer primary key, up REFERENCES s)",
+		"INSERT INTO s VALUES (1, NULL), (2, 1), (3, 2)",
+		"DELETE FROM s WHERE id > 1",
+		"INSERT INTO s VALUES (2, 1), (3, 2)",
+		"DELETE FROM s",
+	}, "s")
+	runAgainstSQLite(t, sqlite3, []string{
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE s (id integer primary key, up REFERENCES s ON DELETE CASCADE)",
+		"INSERT INTO s VALUES (1, NULL), (2, 1), (3, 2), (4, 1), (5, NULL)",
+		"DELETE FROM s WHERE id = 1",
+	}, "s")
+}
+
+func TestForeignKeyActionsNeedForeignKeysOn(t *testing.T) {
+	database, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	for _, statement := range []string{
+		"CREATE TABLE p (id integer primary key)",
+		"CREATE TABLE c (pid REFERENCES p ON DELETE CASCADE)",
+		"INSERT INTO p VALUES (1)",
+		"INSERT INTO c VALUES (1)",
+		"DELETE FROM p",
+	} {
+		if err := execute(t, database, statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	if rows := queryRows(t, database, "SELECT pid FROM c"); !reflect.DeepEqual(rows, [][]any{{int64(1)}}) {
+		t.Errorf("child rows after deleting the parent with foreign keys off = %v", rows)
+	}
+}
@@ -0,0 +1,592 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/xwb1989/sqlparser"
+)
+
+// ConstraintError reports a row a statement would have written in breach
+// of a table constraint, worded as SQLite words it: the kind of constraint
+// and what it covers, such as "NOT NULL constraint failed: t.a".
+type ConstraintError struct {
+	// Kind is NOT NULL, CHECK, UNIQUE or FOREIGN KEY
+	Kind string
+	// Detail names the columns or check, and is empty for foreign keys
+	Detail string
+}
+
+func (constraintError *ConstraintError) Error() string {
+	if constraintError.Detail == "" {
+		return constraintError.Kind + " constraint failed"
+	}
+	return constraintError.Kind + " constraint failed: " + constraintError.Detail
+}
+
+// insertStatement is an INSERT together with the SQLite clauses the SQL
+// parser does not understand: how a row that breaks a constraint is
+// resolved, and an upsert.
+type insertStatement struct {
+	*sqlparser.Insert
+	// resolution is the OR clause's ABORT, FAIL, IGNORE, REPLACE or
+	// ROLLBACK, lowercased, and "abort" when there is none
+	resolution string
+	upsert     *upsertClause
+}
+
+// conflictResolutions are the actions an INSERT OR clause may name.
+var conflictResolutions = []string{"abort", "fail", "ignore", "replace", "rollback"}
+
+// parseInsert recognises an INSERT or REPLACE statement, reporting false
+// for anything else.
+func parseInsert(query string) (*insertStatement, bool, error) {
+	text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	tokens := scanSQL(text)
+	if len(tokens) == 0 || !tokens[0].keyword("INSERT") && !tokens[0].keyword("REPLACE") {
+		return nil, false, nil
+	}
+
+	statement := &insertStatement{resolution: "abort"}
+	if tokens[0].keyword("REPLACE") {
+		statement.resolution = "replace"
+	} else if len(tokens) > 2 && tokens[1].keyword("OR") {
+		statement.resolution = strings.ToLower(tokens[2].name)
+		if tokens[2].quoted || !slices.Contains(conflictResolutions, statement.resolution) {
+			return nil, true, fmt.Errorf("parse query: unknown conflict resolution %q", text[tokens[2].start:tokens[2].end])
+		}
+		text = "INSERT" + text[tokens[2].end:]
+		tokens = scanSQL(text)
+	}
+
+	depth := 0
+	for i, token := range tokens {
+		switch text[token.start] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 && token.keyword("ON") && i+1 < len(tokens) && tokens[i+1].keyword("CONFLICT") {
+			upsert, err := parseUpsert(text[tokens[i+1].end:])
+			var syntaxError *SyntaxError
+			if errors.As(err, &syntaxError) {
+				syntaxError.within(text, tokens[i+1].end)
+			}
+			if err != nil {
+				return nil, true, fmt.Errorf("parse query: %w", err)
+			}
+			statement.upsert, text = upsert, text[:token.start]
+			break
+		}
+	}
+
+	stmt, err := parseSQL(text)
+	if err != nil {
+		return nil, true, fmt.Errorf("parse query: %w", err)
+	}
+	insert, ok := stmt.(*sqlparser.Insert)
+	if !ok {
+		return nil, true, fmt.Errorf("unsupported query type: %T", stmt)
+	}
+	if err := resolveSchemas(insert); err != nil {
+		return nil, true, err
+	}
+	if insert.Ignore != "" || len(insert.OnDup) > 0 {
+		return nil, true, fmt.Errorf("unsupported insert clause in %q", query)
+	}
+	statement.Insert = insert
+	return statement, true, nil
+}
+
+// isRowIDName reports whether a name refers to the rowid of a table that
+// declares no column of that name.
+func isRowIDName(name string) bool {
+	return strings.EqualFold(name, "rowid") || strings.EqualFold(name, "oid") || strings.EqualFold(name, "_rowid_")
+}
+
+// noColumns resolves column references where none are in scope.
+func noColumns(name string) (operand, error) {
+	return operand{}, fmt.Errorf("%w: %s", ErrNoSuchColumn, name)
+}
+
+// insert executes an INSERT of rows of VALUES or the rows of a SELECT.
+// Columns left out take their DEFAULT, and each rowid is the one supplied
+// for the INTEGER PRIMARY KEY or rowid, or one past the largest in the
+// table. The rows are written in one transaction, so a row that fails its
+// constraints leaves none of them written.
+func (database *Database) insert(statement *insertStatement) error {
+	tableName := statement.Table.Name.String()
+	if isSchemaTable(tableName) {
+		return fmt.Errorf("table %s may not be modified", tableName)
+	}
+	owner, table, err := database.lookupTable(statement.Table.Qualifier.String(), tableName)
+	if err != nil {
+		return err
+	}
+	if owner != database {
+		statement.Table = sqlparser.TableName{Name: statement.Table.Name}
+		return owner.insert(statement)
+	}
+
+	// targets holds each value's column position, with -1 for the rowid
+	targets := make([]int, 0, len(table.Columns))
+	if len(statement.Columns) == 0 {
+		for i := range table.Columns {
+			targets = append(targets, i)
+		}
+	}
+	for _, name := range statement.Columns {
+		position, ok := table.ColumnIndex(name.String())
+		if !ok && !isRowIDName(name.String()) {
+			return fmt.Errorf("table %s has no column named %s", table.Name, name.String())
+		}
+		targets = append(targets, position)
+	}
+
+	sources, width, err := database.insertSource(statement.Rows)
+	if err != nil {
+		return err
+	}
+	if width != len(targets) {
+		if len(statement.Columns) == 0 {
+			return fmt.Errorf("table %s has %d columns but %d values were supplied", table.Name, len(targets), width)
+		}
+		return fmt.Errorf("%d values for %d columns", width, len(targets))
+	}
+
+	if statement.upsert != nil {
+		if err := statement.upsert.validate(table); err != nil {
+			return err
+		}
+	}
+
+	return database.write(func(pager *db.Pager) error {
+		var pending pendingKeys
+		for _, values := range sources {
+			if err := database.insertRow(pager, &pending, statement, table, targets, values); err != nil {
+				return err
+			}
+		}
+		return pending.check(database)
+	})
+}
+
+// insertRow writes one row of an INSERT. A row breaking a NOT NULL, CHECK
+// or UNIQUE constraint is skipped under OR IGNORE; under OR REPLACE, a
+// NULL in a NOT NULL column takes the column's default and rows with the
+// same unique key are deleted first. An upsert clause handles the unique
+// keys it targets before either.
+func (database *Database) insertRow(pager *db.Pager, pending *pendingKeys, statement *insertStatement, table *TableSchema, targets []int, values []any) error {
+	row, rowIDValue, err := insertedRow(table, targets, values, database.functionState())
+	if err != nil {
+		return err
+	}
+	if statement.resolution == "replace" {
+		for i, column := range table.Columns {
+			if column.NotNull && row[i] == nil && i != table.RowIDAlias {
+				if row[i], err = defaultValue(column, database.functionState()); err != nil {
+					return fmt.Errorf("default for %s.%s: %w", table.Name, column.Name, err)
+				}
+				row[i] = applyAffinity(row[i], column.Affinity)
+			}
+		}
+	}
+	rowID, err := assignRowID(pager, table, rowIDValue)
+	if err != nil {
+		return err
+	}
+	if table.RowIDAlias >= 0 {
+		row[table.RowIDAlias] = rowID
+	}
+
+	if err := checkColumns(table, rowID, row); err != nil {
+		var constraintError *ConstraintError
+		if statement.resolution == "ignore" && errors.As(err, &constraintError) {
+			return nil
+		}
+		return err
+	}
+	for {
+		conflict, err := database.findConflict(table, rowID, row)
+		if err != nil {
+			return err
+		}
+		if conflict == nil {
+			break
+		}
+		if statement.upsert != nil && statement.upsert.handles(table, conflict.key) {
+			return database.applyUpsert(pager, pending, table, statement.upsert, conflict.rowID, rowID, row)
+		}
+		switch statement.resolution {
+		case "ignore":
+			return nil
+		case "replace":
+			if err := database.removeRow(pager, pending, table, conflict.rowID); err != nil {
+				return err
+			}
+			continue
+		}
+		return conflict.err(table)
+	}
+	if err := database.checkForeignKeys(table, row); err != nil {
+		return err
+	}
+	if err := writeRow(pager, table, rowID, row); err != nil {
+		return err
+	}
+	database.rowChanged(OpInsert, table.Name, rowID)
+	return nil
+}
+
+// insertSource evaluates the rows an INSERT writes, along with how many
+// values each has. A SELECT is run to completion first, so that rows it
+// reads from the table being inserted into are not affected by the insert.
+func (database *Database) insertSource(rows sqlparser.InsertRows) ([][]any, int, error) {
+	switch rows := rows.(type) {
+	case sqlparser.Values:
+		width := len(rows[0])
+		sources := make([][]any, len(rows))
+		for i, tuple := range rows {
+			if len(tuple) != width {
+				return nil, 0, fmt.Errorf("all VALUES must have the same number of terms")
+			}
+			sources[i] = make([]any, len(tuple))
+			for j, expr := range tuple {
+				value, err := evaluate(expr, database.scope(noColumns))
+				if err != nil {
+					return nil, 0, err
+				}
+				sources[i][j] = value
+			}
+		}
+		return sources, width, nil
+	case *sqlparser.Select:
+		if statement, ok, err := database.constantSelect(rows); ok {
+			if err != nil {
+				return nil, 0, err
+			}
+			sources, err := statement.evaluate(database.scope(noColumns))
+			return sources, len(statement.columns), err
+		}
+		parsed, err := parseSelect(sqlparser.String(rows))
+		if err != nil {
+			return nil, 0, err
+		}
+		resultSet, err := database.prepareSelect(parsed)
+		if err != nil {
+			return nil, 0, err
+		}
+		width := len(resultSet.Columns)
+		sources, err := resultSet.All()
+		if err != nil {
+			return nil, 0, err
+		}
+		return sources, width, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported insert source: %s", sqlparser.String(rows))
+}
+
+// insertedRow places one source row's values in their columns, filling
+// the rest with their defaults and applying each column's affinity. It
+// also returns the rowid value supplied, if any.
+func insertedRow(table *TableSchema, targets []int, values []any, functions *functions) ([]any, any, error) {
+	row := make([]any, len(table.Columns))
+	supplied := make([]bool, len(table.Columns))
+	var rowIDValue any
+	for i, value := range values {
+		if targets[i] < 0 {
+			rowIDValue = value
+			continue
+		}
+		// A column named twice takes its first value, as in SQLite
+		if !supplied[targets[i]] {
+			row[targets[i]], supplied[targets[i]] = value, true
+		}
+	}
+	for i, column := range table.Columns {
+		if !supplied[i] {
+			var err error
+			if row[i], err = defaultValue(column, functions); err != nil {
+				return nil, nil, fmt.Errorf("default for %s.%s: %w", table.Name, column.Name, err)
+			}
+		}
+		row[i] = applyAffinity(row[i], column.Affinity)
+	}
+	if table.RowIDAlias >= 0 && supplied[table.RowIDAlias] {
+		rowIDValue = row[table.RowIDAlias]
+	}
+	return row, rowIDValue, nil
+}
+
+// defaultValue evaluates a column's DEFAULT expression, or returns NULL for
+// a column without one, with the functions of the connection inserting the
+// row.
+func defaultValue(column ColumnSchema, functions *functions) (any, error) {
+	if column.Default == "" {
+		return nil, nil
+	}
+	expr, err := parseExpression(column.Default)
+	if err != nil {
+		return nil, err
+	}
+	return evaluate(expr, scope{column: noColumns, functions: functions})
+}
+
+// assignRowID picks the new row's rowid: the supplied value, which must be
+// an integer, or one past the table's largest rowid when none is given.
+func assignRowID(pager *db.Pager, table *TableSchema, supplied any) (int64, error) {
+	if supplied != nil {
+		rowID, ok := applyAffinity(supplied, AffinityInteger).(int64)
+		if !ok {
+			return 0, fmt.Errorf("datatype mismatch")
+		}
+		return rowID, nil
+	}
+
+	largest, err := pager.MaxRowID(table.RootPage)
+	if err != nil {
+		return 0, err
+	}
+	if largest == math.MaxInt64 {
+		return 0, fmt.Errorf("database or disk is full")
+	}
+	return largest + 1, nil
+}
+
+// rowColumns resolves column references against a row of table, named
+// alone or qualified by the table's name.
+func rowColumns(table *TableSchema, rowID int64, row []any) columnResolver {
+	return func(name string) (operand, error) {
+		column := name
+		if qualifier, rest, ok := strings.Cut(name, "."); ok && strings.EqualFold(qualifier, table.Name) {
+			column = rest
+		}
+		if position, ok := table.ColumnIndex(column); ok {
+			return columnOperand(row[position], table.Columns[position]), nil
+		}
+		if isRowIDName(column) {
+			return operand{value: rowID, affinity: AffinityInteger, collated: columnCollation}, nil
+		}
+		return operand{}, fmt.Errorf("%w: %s", ErrNoSuchColumn, name)
+	}
+}
+
+// checkColumns verifies a row's NOT NULL columns and then its CHECK
+// constraints, in declaration order.
+func checkColumns(table *TableSchema, rowID int64, row []any) error {
+	for i, column := range table.Columns {
+		if column.NotNull && row[i] == nil {
+			return &ConstraintError{Kind: "NOT NULL", Detail: table.Name + "." + column.Name}
+		}
+	}
+
+	resolve := rowColumns(table, rowID, row)
+	for _, check := range table.Checks {
+		expr, err := parseExpression(check.Expression)
+		if err != nil {
+			return fmt.Errorf("table %s: %w", table.Name, err)
+		}
+		value, err := evaluate(expr, scope{column: resolve})
+		if err != nil {
+			return err
+		}
+		// Only a false result fails: a NULL one passes
+		if truthOf(value) == falsy {
+			detail := check.Name
+			if detail == "" {
+				detail = check.Expression
+			}
+			return &ConstraintError{Kind: "CHECK", Detail: detail}
+		}
+	}
+	return nil
+}
+
+// checkForeignKeys verifies a row's foreign keys when they are enabled.
+func (database *Database) checkForeignKeys(table *TableSchema, row []any) error {
+	if !database.foreignKeys {
+		return nil
+	}
+	keys, err := database.resolveForeignKeys(table)
+	if err != nil {
+		return err
+	}
+	violated, err := database.foreignKeyViolations(keys, row)
+	if err != nil {
+		return err
+	}
+	if len(violated) > 0 {
+		return &ConstraintError{Kind: "FOREIGN KEY"}
+	}
+	return nil
+}
+
+// uniqueConflict is an existing row with the same value for a unique key
+// as a row being written.
+type uniqueConflict struct {
+	// key is the unique key's columns, or nil for the rowid
+	key   []string
+	rowID int64
+}
+
+func (conflict *uniqueConflict) err(table *TableSchema) error {
+	if conflict.key == nil {
+		name := "rowid"
+		if table.RowIDAlias >= 0 {
+			name = table.Columns[table.RowIDAlias].Name
+		}
+		return &ConstraintError{Kind: "UNIQUE", Detail: table.Name + "." + name}
+	}
+	names := make([]string, len(conflict.key))
+	for i, name := range conflict.key {
+		position, _ := table.ColumnIndex(name)
+		names[i] = table.Name + "." + table.Columns[position].Name
+	}
+	return &ConstraintError{Kind: "UNIQUE", Detail: strings.Join(names, ", ")}
+}
+
+// findConflict returns the first existing row a row would duplicate a
+// unique key of, checking the rowid first and then each unique key, or nil
+// when there is none.
+func (database *Database) findConflict(table *TableSchema, rowID int64, row []any) (*uniqueConflict, error) {
+	existing, err := database.file.SeekRowID(database.header, table.RootPage, rowID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return &uniqueConflict{rowID: rowID}, nil
+	}
+
+	for _, key := range table.UniqueKeys {
+		conflictingRowID, found, err := database.conflictingRow(table, key, row)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return &uniqueConflict{key: key, rowID: conflictingRowID}, nil
+		}
+	}
+	return nil, nil
+}
+
+// conflictingRow finds a row that already has the row's values for a
+// unique key. Keys with a NULL column never conflict, and the rowid alias
+// is checked by rowid instead.
+func (database *Database) conflictingRow(table *TableSchema, key []string, row []any) (int64, bool, error) {
+	lookup := &selectQuery{table: table.Name, star: true, limit: 1}
+	for _, name := range key {
+		position, ok := table.ColumnIndex(name)
+		if !ok {
+			return 0, false, fmt.Errorf("unknown column %q in unique key of %s", name, table.Name)
+		}
+		if position == table.RowIDAlias && len(key) == 1 {
+			return 0, false, nil
+		}
+		if row[position] == nil {
+			return 0, false, nil
+		}
+		column := table.Columns[position]
+		lookup.filters = append(lookup.filters, equalityFilter{column: column.Name, values: []any{row[position]}, position: position, collation: column.collation()})
+	}
+
+	var found *db.Row
+	emit := func(row *db.Row) bool {
+		found = row
+		return false
+	}
+	var stats scanStats
+	var err error
+	switch queryPlan := planSelect(lookup, table); {
+	case queryPlan.seekRowID:
+		err = rowIDSeek(database.file, database.header, table, queryPlan, &stats, emit)
+	case queryPlan.index != nil:
+		err = indexScan(database.file, database.header, table, queryPlan, &stats, emit)
+	default:
+		err = tableScan(database.file, database.header, table, queryPlan.residual, false, &stats, emit)
+	}
+	if err != nil || found == nil {
+		return 0, false, err
+	}
+	return found.RowID, true, nil
+}
+
+// readRow returns the values of the row with the given rowid.
+func (database *Database) readRow(table *TableSchema, rowID int64) ([]any, error) {
+	row, err := database.file.SeekRowID(database.header, table.RootPage, rowID)
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, fmt.Errorf("table %s: no row with rowid %d", table.Name, rowID)
+	}
+	values := make([]any, len(table.Columns))
+	for i := range values {
+		values[i] = columnValue(row, table, i)
+	}
+	return values, nil
+}
+
+// deleteRow removes a row from its table and its entries from every index
+// on the table.
+func (database *Database) deleteRow(pager *db.Pager, table *TableSchema, rowID int64) error {
+	row, err := database.readRow(table, rowID)
+	if err != nil {
+		return err
+	}
+	if err := pager.DeleteRow(table.RootPage, rowID); err != nil {
+		return fmt.Errorf("table %s: %w", table.Name, err)
+	}
+	for _, index := range table.Indexes {
+		key, err := indexKey(table, index, rowID, row)
+		if err != nil {
+			return err
+		}
+		if err := pager.DeleteIndexEntry(index.RootPage, key, index.Collations); err != nil {
+			return fmt.Errorf("index %s: %w", index.Name, err)
+		}
+	}
+	return nil
+}
+
+// indexKey builds a row's entry in an index on its table.
+func indexKey(table *TableSchema, index IndexSchema, rowID int64, row []any) (db.IndexKey, error) {
+	key := db.IndexKey{RowID: rowID}
+	for _, name := range index.Columns {
+		position, ok := table.ColumnIndex(name)
+		if !ok {
+			return key, fmt.Errorf("index %s: unsupported indexed expression %s", index.Name, name)
+		}
+		key.Values = append(key.Values, row[position])
+	}
+	return key, nil
+}
+
+// writeRow stores a row in its table and adds its entry to every index on
+// the table. The INTEGER PRIMARY KEY is stored as NULL, since the rowid
+// holds its value.
+func writeRow(pager *db.Pager, table *TableSchema, rowID int64, row []any) error {
+	record := make([]db.Value, len(row))
+	copy(record, row)
+	if table.RowIDAlias >= 0 {
+		record[table.RowIDAlias] = nil
+	}
+	if err := pager.InsertRow(table.RootPage, rowID, db.EncodeRecord(record)); err != nil {
+		return fmt.Errorf("table %s: %w", table.Name, err)
+	}
+
+	for _, index := range table.Indexes {
+		key, err := indexKey(table, index, rowID, row)
+		if err != nil {
+			return err
+		}
+		if err := pager.InsertIndexEntry(index.RootPage, key, index.Collations); err != nil {
+			return fmt.Errorf("index %s: %w", index.Name, err)
+		}
+	}
+	return nil
+}
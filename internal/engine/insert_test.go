@@ -0,0 +1,373 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+// constrainedDatabase generates a table with every kind of constraint
+// INSERT enforces, holding one row, along with the autoindexes SQLite
+// would create for its unique keys.
+func constrainedDatabase(t *testing.T) string {
+	t.Helper()
+
+	database := testgen.New(testgen.Options{PageSize: 512})
+	table := database.CreateTable("t", `CREATE TABLE t (id integer primary key, a int not null, b int check (b > 0),
+		c text unique, d, e, f integer default 5, constraint pos check (e >= 0), check ( d<>'x' ), unique (d, e))`)
+	table.Insert(1, nil, int64(1), int64(1), "x", int64(1), int64(1), int64(1))
+	database.CreateIndex("sqlite_autoindex_t_1", table, "", 3)
+	database.CreateIndex("sqlite_autoindex_t_2", table, "", 4, 5)
+	return database.WriteTemp(t)
+}
+
+func execute(t *testing.T, database *Database, query string) error {
+	t.Helper()
+
+	resultSet, err := database.Query(query)
+	if err != nil {
+		return err
+	}
+	return resultSet.Close()
+}
+
+func TestInsertEnforcesConstraints(t *testing.T) {
+	database := openDatabase(t, constrainedDatabase(t))
+
+	tests := []struct {
+		query, err string
+	}{
+		{"INSERT INTO t (b) VALUES (1)", "NOT NULL constraint failed: t.a"},
+		{"INSERT INTO t VALUES (NULL, 1, 0, 'y', 2, 1, 1)", "CHECK constraint failed: b > 0"},
+		{"INSERT INTO t VALUES (NULL, 1, 1, 'y', 2, -1, 1)", "CHECK constraint failed: pos"},
+		{"INSERT INTO t VALUES (NULL, 1, 1, 'y', 'x', 1, 1)", "CHECK constraint failed: d<>'x'"},
+		{"INSERT INTO t VALUES (1, 1, 1, 'y', 2, 1, 1)", "UNIQUE constraint failed: t.id"},
+		{"INSERT INTO t VALUES ('1', 1, 1, 'y', 2, 1, 1)", "UNIQUE constraint failed: t.id"},
+		{"INSERT INTO t VALUES (NULL, 1, 1, 'x', 2, 1, 1)", "UNIQUE constraint failed: t.c"},
+		{"INSERT INTO t VALUES (NULL, 1, 1, 'y', 1, 1, 1)", "UNIQUE constraint failed: t.d, t.e"},
+		{"INSERT INTO t VALUES (2.5, 1, 1, 'y', 2, 1, 1)", "datatype mismatch"},
+		{"INSERT INTO t VALUES (1)", "table t has 7 columns but 1 values were supplied"},
+		{"INSERT INTO t (a, b) VALUES (1)", "1 values for 2 columns"},
+		{"INSERT INTO t (zz) VALUES (1)", "table t has no column named zz"},
+		{"INSERT INTO nosuch VALUES (1)", "no such table: nosuch"},
+		{"INSERT INTO sqlite_master VALUES (1, 2, 3, 4, 5)", "table sqlite_master may not be modified"},
+	}
+	for _, test := range tests {
+		err := execute(t, database, test.query)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: error %v, want %q", test.query, err, test.err)
+		}
+	}
+
+	var constraintError *ConstraintError
+	if err := execute(t, database, "INSERT INTO t (b) VALUES (1)"); !errors.As(err, &constraintError) || constraintError.Kind != "NOT NULL" {
+		t.Errorf("NOT NULL failure is %#v, want a ConstraintError", err)
+	}
+	if rows := queryRows(t, database, "SELECT count(*) FROM t"); rows[0][0] != int64(1) {
+		t.Fatalf("rejected inserts left %v rows", rows[0][0])
+	}
+}
+
+func TestInsertAppliesDefaultsAndAffinity(t *testing.T) {
+	database := openDatabase(t, constrainedDatabase(t))
+
+	for _, query := range []string{
+		// A NULL in a CHECK expression or unique key passes
+		"INSERT INTO t (a, b, c) VALUES ('7', NULL, 3)",
+		"INSERT INTO t (a, c, d) VALUES (2.0, NULL, 1)",
+		"INSERT INTO t (a, c, d) VALUES (3, NULL, 1)",
+		"INSERT INTO t (rowid, a) VALUES (10, 'q')",
+		// 'abc' sorts after every number, so b > 0 holds
+		"INSERT INTO t (a, b) VALUES (4, 'abc')",
+	} {
+		if err := execute(t, database, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	got := queryRows(t, database, "SELECT id, a, b, c, d, f FROM t")
+	want := [][]any{
+		{int64(1), int64(1), int64(1), "x", int64(1), int64(1)},
+		{int64(2), int64(7), nil, "3", nil, int64(5)},
+		{int64(3), int64(2), nil, nil, int64(1), int64(5)},
+		{int64(4), int64(3), nil, nil, int64(1), int64(5)},
+		{int64(10), "q", nil, nil, nil, int64(5)},
+		{int64(11), int64(4), "abc", nil, nil, int64(5)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows after inserts\n got %v\nwant %v", got, want)
+	}
+	// The new rows are reachable through the autoindex
+	if rows := queryRows(t, database, "SELECT id FROM t WHERE c = '3'"); !reflect.DeepEqual(rows, [][]any{{int64(2)}}) {
+		t.Fatalf("index lookup of the new row = %v", rows)
+	}
+}
+
+// TestNumericAffinityMatchesSQLite writes text that does or does not look
+// like a number to columns of every affinity, and compares what is stored,
+// and which rows text finds in each column, with sqlite3.
+func TestNumericAffinityMatchesSQLite(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	texts := []string{
+		"12", " 12", "12 ", "\t12\n", "\v12\f", "\u00a012", "12\u00a0", "+12", "012", "12 3", "12abc", "0x10",
+		"1.5", ".5", "5.", ".", "-.5", "1e3", "1E+3", "1e-3", "1e", "1e+", "3.0", "-0", "-0.0", "inf", "nan", "1_000",
+		"9223372036854775807", "9223372036854775808", "-9223372036854775808", "-9223372036854775809",
+		"9223372036854775807.0", "1e18", "1e19", "1e400", "-1e400", "1e-400", "",
+	}
+	const schema = "CREATE TABLE t (k integer primary key, i INTEGER, r REAL, n NUMERIC, x TEXT, b BLOB, d)"
+	generated := testgen.New(testgen.Options{})
+	generated.CreateTable("t", schema)
+	path := generated.WriteTemp(t)
+	want := t.TempDir() + "/want.db"
+	database := openDatabase(t, path)
+	statements := []string{schema}
+	for k, text := range texts {
+		literal := "'" + strings.ReplaceAll(text, "'", "''") + "'"
+		query := fmt.Sprintf("INSERT INTO t VALUES (%d, %s, %[2]s, %[2]s, %[2]s, %[2]s, %[2]s)", k, literal)
+		if err := execute(t, database, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		statements = append(statements, query)
+	}
+	sqlite3Lines(t, sqlite3, want, strings.Join(statements, ";\n"))
+
+	stored := "SELECT k, typeof(i), quote(i), typeof(r), quote(r), typeof(n), quote(n), typeof(x), typeof(b), typeof(d) FROM t ORDER BY k"
+	got, wanted := sqlite3Lines(t, sqlite3, path, stored), sqlite3Lines(t, sqlite3, want, stored)
+	for i := range min(len(got), len(wanted)) {
+		if got[i] != wanted[i] {
+			t.Errorf("%q stored as %s, want %s", texts[i], got[i], wanted[i])
+		}
+	}
+	if len(got) != len(wanted) {
+		t.Fatalf("%d rows stored, want %d", len(got), len(wanted))
+	}
+
+	reference := openDatabase(t, want)
+	for _, column := range []string{"i", "r", "n", "x", "d"} {
+		for _, text := range texts {
+			query := fmt.Sprintf("SELECT k FROM t WHERE %s = '%s'", column, strings.ReplaceAll(text, "'", "''"))
+			var keys []string
+			for _, row := range queryRows(t, reference, query) {
+				keys = append(keys, fmt.Sprint(row[0]))
+			}
+			if want := sqlite3Lines(t, sqlite3, want, query); strings.Join(keys, "\n") != strings.Join(want, "\n") {
+				t.Errorf("%s found %v, want %v", query, keys, want)
+			}
+		}
+	}
+}
+
+func TestInsertChecksForeignKeysWhenEnabled(t *testing.T) {
+	generated := testgen.New(testgen.Options{})
+	parent := generated.CreateTable("p", "CREATE TABLE p (id integer primary key)")
+	parent.Insert(1, nil)
+	generated.CreateTable("c", "CREATE TABLE c (x REFERENCES p)")
+	database := openDatabase(t, generated.WriteTemp(t))
+
+	if err := execute(t, database, "INSERT INTO c VALUES (2)"); err != nil {
+		t.Fatalf("insert with foreign keys off: %v", err)
+	}
+	execute(t, database, "PRAGMA foreign_keys = ON")
+	if err := execute(t, database, "INSERT INTO c VALUES (3)"); err == nil || err.Error() != "FOREIGN KEY constraint failed" {
+		t.Fatalf("orphan insert error %v", err)
+	}
+	for _, query := range []string{"INSERT INTO c VALUES (1)", "INSERT INTO c VALUES (NULL)"} {
+		if err := execute(t, database, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	if rows := queryRows(t, database, "SELECT count(*) FROM c"); rows[0][0] != int64(3) {
+		t.Fatalf("%v rows in c, want 3", rows[0][0])
+	}
+}
+
+func TestInsertedRowsPassIntegrityCheck(t *testing.T) {
+	path := companiesDatabase(t, 50)
+	database := openDatabase(t, path)
+
+	for i := 51; i <= 600; i++ {
+		name := strings.Repeat(fmt.Sprintf("company %d ", i), i%9)
+		query := fmt.Sprintf("INSERT INTO companies (name, country, size) VALUES ('%s', 'country-%d', %d)", name, i%13, i%3)
+		if err := execute(t, database, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	rows := queryRows(t, database, "SELECT count(*) FROM companies WHERE country = 'country-12'")
+	if rows[0][0] != int64(43) {
+		t.Fatalf("%v companies in country-12, want 43", rows[0][0])
+	}
+	database.Close()
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		output, err := exec.Command(sqlite3, path, "PRAGMA integrity_check", "SELECT max(id), count(*) FROM companies").Output()
+		if err != nil || string(output) != "ok\n600|600\n" {
+			t.Fatalf("sqlite3 after inserts: %q %v", output, err)
+		}
+	}
+}
+
+func TestInsertMultipleRowsAtomically(t *testing.T) {
+	database := openDatabase(t, constrainedDatabase(t))
+
+	// The third row repeats the second's c, so none of them is written
+	err := execute(t, database, "INSERT INTO t (a, c) VALUES (2, 'p'), (3, 'q'), (4, 'q')")
+	if err == nil || err.Error() != "UNIQUE constraint failed: t.c" {
+		t.Fatalf("conflicting batch error %v", err)
+	}
+	if err := execute(t, database, "INSERT INTO t (a, c) VALUES (2, 'p'), (3)"); err == nil || err.Error() != "all VALUES must have the same number of terms" {
+		t.Fatalf("ragged VALUES error %v", err)
+	}
+	if rows := queryRows(t, database, "SELECT count(*) FROM t"); rows[0][0] != int64(1) {
+		t.Fatalf("rejected batches left %v rows", rows[0][0])
+	}
+
+	if err := execute(t, database, "INSERT INTO t (a, c) VALUES (2, 'p'), (3, 'q'), (4, 'r')"); err != nil {
+		t.Fatalf("batch insert: %v", err)
+	}
+	got := queryRows(t, database, "SELECT id, a, c FROM t")
+	want := [][]any{{int64(1), int64(1), "x"}, {int64(2), int64(2), "p"}, {int64(3), int64(3), "q"}, {int64(4), int64(4), "r"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows after batch insert\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestInsertSelect(t *testing.T) {
+	path := companiesDatabase(t, 50)
+	database := openDatabase(t, path)
+
+	// Copying a table into itself reads only the rows there beforehand
+	if err := execute(t, database, "INSERT INTO companies (name, country, size) SELECT name, country, size FROM companies"); err != nil {
+		t.Fatalf("insert from own table: %v", err)
+	}
+	if rows := queryRows(t, database, "SELECT count(*) FROM companies"); rows[0][0] != int64(100) {
+		t.Fatalf("%v companies after copying, want 100", rows[0][0])
+	}
+	query := "INSERT INTO companies (name, country) SELECT name, country FROM companies WHERE id = 1"
+	if err := execute(t, database, query); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	first := queryRows(t, database, "SELECT name, country FROM companies WHERE id = 1")
+	if rows := queryRows(t, database, "SELECT name, country, size FROM companies WHERE id = 101"); !reflect.DeepEqual(rows, [][]any{{first[0][0], first[0][1], nil}}) {
+		t.Fatalf("copied row %v, want %v", rows, first)
+	}
+	if err := execute(t, database, "INSERT INTO companies SELECT name FROM companies"); err == nil || err.Error() != "table companies has 4 columns but 1 values were supplied" {
+		t.Fatalf("narrow SELECT error %v", err)
+	}
+	database.Close()
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		output, err := exec.Command(sqlite3, path, "PRAGMA integrity_check", "SELECT count(*) FROM companies").Output()
+		if err != nil || string(output) != "ok\n101\n" {
+			t.Fatalf("sqlite3 after INSERT SELECT: %q %v", output, err)
+		}
+	}
+}
+
+func TestInsertIntoMemoryDatabase(t *testing.T) {
+	image := testgen.New(testgen.Options{PageSize: 512})
+	image.CreateIndex("idx_companies_country", image.CreateTable("companies", "CREATE TABLE companies (id integer primary key, name text, country text)"),
+		"CREATE INDEX idx_companies_country ON companies (country)", 2)
+	contents, err := image.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := append([]byte(nil), contents...)
+
+	database, err := OpenSerialized(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	for i := 1; i <= 400; i++ {
+		query := fmt.Sprintf("INSERT INTO companies (name, country) VALUES ('%s', 'country-%d')", strings.Repeat("x", i%50), i%7)
+		if err := execute(t, database, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	for _, query := range []string{"BEGIN", "INSERT INTO companies (name, country) VALUES ('gone', 'country-0')", "ROLLBACK"} {
+		if err := execute(t, database, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	if rows := queryRows(t, database, "SELECT count(*) FROM companies WHERE country = 'country-0'"); rows[0][0] != int64(57) {
+		t.Errorf("country-0 has %v companies, want 57", rows[0][0])
+	}
+	if rows := queryRows(t, database, "SELECT max(id) FROM companies"); rows[0][0] != int64(400) {
+		t.Errorf("max(id) = %v after the rollback, want 400", rows[0][0])
+	}
+	if !bytes.Equal(contents, original) {
+		t.Error("writes to the in-memory copy changed the image it was opened from")
+	}
+
+	empty, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	for query, want := range map[string][]any{
+		"PRAGMA journal_mode":     {"memory"},
+		"PRAGMA journal_mode=wal": {"memory"},
+		"PRAGMA database_list":    {int64(0), "main", ""},
+		"PRAGMA page_size":        {int64(4096)},
+	} {
+		if rows := queryRows(t, empty, query); len(rows) != 1 || !reflect.DeepEqual(rows[0], want) {
+			t.Errorf("%s = %v, want %v", query, rows, want)
+		}
+	}
+}
+
+func TestUpdateHook(t *testing.T) {
+	database, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	var changes []string
+	database.SetUpdateHook(func(op ChangeOp, table string, rowID int64) {
+		changes = append(changes, fmt.Sprintf("%s %s %d", op, table, rowID))
+	})
+
+	for _, statement := range []string{
+		"CREATE TABLE t (id integer primary key, a text unique, b integer)",
+		"INSERT INTO t (a, b) VALUES ('x', 1), ('y', 2)",
+		"INSERT OR IGNORE INTO t VALUES (3, 'x', 9)",
+		// The row REPLACE deletes is not reported, as in SQLite
+		"INSERT OR REPLACE INTO t VALUES (5, 'y', 3)",
+		"INSERT INTO t VALUES (6, 'x', 4) ON CONFLICT (a) DO UPDATE SET b = excluded.b, id = 10",
+		"INSERT INTO t VALUES (7, 'x', 1) ON CONFLICT DO NOTHING",
+		"CREATE TEMP TABLE u (v)",
+		"INSERT INTO u VALUES ('w')",
+		"ANALYZE",
+	} {
+		if err := execute(t, database, statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	// Rows are reported as they are written, before a later one fails
+	if err := execute(t, database, "INSERT INTO t VALUES (11, 'q', 0), (5, 'z', 0)"); err == nil {
+		t.Fatal("duplicate rowid inserted")
+	}
+	want := []string{"INSERT t 1", "INSERT t 2", "INSERT t 5", "UPDATE t 10", "INSERT u 1", "INSERT t 11"}
+	if !slices.Equal(changes, want) {
+		t.Errorf("changes reported\n got %q\nwant %q", changes, want)
+	}
+
+	database.SetUpdateHook(nil)
+	if err := execute(t, database, "INSERT INTO t (a) VALUES ('r')"); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != len(want) {
+		t.Errorf("removed hook reported %q", changes[len(want):])
+	}
+}
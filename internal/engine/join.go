@@ -0,0 +1,467 @@
+package engine
+
+import (
+	"fmt"
+	"iter"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/xwb1989/sqlparser"
+)
+
+// joinStatement is the one join this engine executes: an inner join of
+// two tables on one pair of columns, SELECT columns FROM a JOIN b ON
+// a.x = b.y, filtered like a single-table SELECT and optionally limited.
+type joinStatement struct {
+	sides [2]joinSide
+	// on are the joined columns, as written, of either side in either order
+	on   [2]qualifiedColumn
+	star bool
+	// columns are the selected columns, unless star is set
+	columns []qualifiedColumn
+	filters []equalityFilter
+	// limit is the maximum number of result rows, or -1 for no limit
+	limit int64
+}
+
+// joinSide is one table of a join, with the schema it was qualified with,
+// and the name the query refers to it by, its alias or else its own name.
+type joinSide struct {
+	schema, table, name string
+}
+
+// qualifiedColumn is a column as a query names it, with the table or alias
+// it was qualified with or "".
+type qualifiedColumn struct {
+	qualifier, name string
+}
+
+// parseJoin recognises a SELECT from a JOIN, reporting false for anything
+// else so that other SELECTs, and statements that do not parse, are left to
+// parseSelect.
+func parseJoin(query string) (*joinStatement, bool, error) {
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		return nil, false, nil
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok || len(sel.From) != 1 {
+		return nil, false, nil
+	}
+	join, ok := sel.From[0].(*sqlparser.JoinTableExpr)
+	if !ok {
+		return nil, false, nil
+	}
+	if err := resolveSchemas(sel); err != nil {
+		return nil, true, err
+	}
+
+	if join.Join != sqlparser.JoinStr || len(join.Condition.Using) > 0 {
+		return nil, true, fmt.Errorf("unsupported join: %s", sqlparser.String(join))
+	}
+	if len(sel.OrderBy) > 0 || len(sel.GroupBy) > 0 || sel.Having != nil || sel.Distinct != "" {
+		return nil, true, fmt.Errorf("unsupported select clause in %q", query)
+	}
+
+	statement := &joinStatement{limit: -1}
+	for i, expr := range []sqlparser.TableExpr{join.LeftExpr, join.RightExpr} {
+		aliased, ok := expr.(*sqlparser.AliasedTableExpr)
+		if !ok {
+			return nil, true, fmt.Errorf("unsupported join: %s", sqlparser.String(join))
+		}
+		table, ok := aliased.Expr.(sqlparser.TableName)
+		if !ok {
+			return nil, true, fmt.Errorf("unsupported join: %s", sqlparser.String(join))
+		}
+		statement.sides[i] = joinSide{schema: table.Qualifier.String(), table: table.Name.String(), name: table.Name.String()}
+		if !aliased.As.IsEmpty() {
+			statement.sides[i].name = aliased.As.String()
+		}
+	}
+	if strings.EqualFold(statement.sides[0].name, statement.sides[1].name) {
+		return nil, true, fmt.Errorf("ambiguous table name in join: %s", statement.sides[0].name)
+	}
+
+	on, ok := join.Condition.On.(*sqlparser.ComparisonExpr)
+	if !ok || on.Operator != sqlparser.EqualStr {
+		return nil, true, fmt.Errorf("unsupported join condition: %s", sqlparser.String(join.Condition.On))
+	}
+	for i, expr := range []sqlparser.Expr{on.Left, on.Right} {
+		column, ok := expr.(*sqlparser.ColName)
+		if !ok {
+			return nil, true, fmt.Errorf("unsupported join condition: %s", sqlparser.String(on))
+		}
+		statement.on[i] = qualifiedColumn{qualifier: column.Qualifier.Name.String(), name: column.Name.String()}
+	}
+
+	for _, expr := range sel.SelectExprs {
+		switch expr := expr.(type) {
+		case *sqlparser.StarExpr:
+			if !expr.TableName.IsEmpty() || len(sel.SelectExprs) > 1 {
+				return nil, true, fmt.Errorf("unsupported select expression: %s", sqlparser.String(expr))
+			}
+			statement.star = true
+		case *sqlparser.AliasedExpr:
+			column, ok := expr.Expr.(*sqlparser.ColName)
+			if !ok {
+				return nil, true, fmt.Errorf("unsupported select expression: %s", sqlparser.String(expr))
+			}
+			statement.columns = append(statement.columns, qualifiedColumn{qualifier: column.Qualifier.Name.String(), name: column.Name.String()})
+		default:
+			return nil, true, fmt.Errorf("unsupported select expression: %s", sqlparser.String(expr))
+		}
+	}
+
+	if sel.Where != nil {
+		if statement.filters, err = parseFilters(sel.Where.Expr); err != nil {
+			return nil, true, err
+		}
+	}
+	if sel.Limit != nil {
+		if sel.Limit.Offset != nil {
+			return nil, true, fmt.Errorf("LIMIT offsets are not supported")
+		}
+		if statement.limit, err = parseLimit(sel.Limit.Rowcount); err != nil {
+			return nil, true, err
+		}
+	}
+	return statement, true, nil
+}
+
+// joinColumn is a column resolved to the side of the join it is read from
+// and its record position there.
+type joinColumn struct {
+	side, position int
+}
+
+// resolve finds which side's table column belongs to, by its qualifier or
+// else by which table has a column of that name.
+func (statement *joinStatement) resolve(tables [2]*TableSchema, column qualifiedColumn) (joinColumn, error) {
+	found := joinColumn{side: -1}
+	for side, table := range tables {
+		if column.qualifier != "" && !strings.EqualFold(column.qualifier, statement.sides[side].name) {
+			continue
+		}
+		position, ok := table.ColumnIndex(column.name)
+		if !ok && refersToRowID(table, column.name) {
+			position, ok = table.RowIDAlias, true
+		}
+		if !ok {
+			continue
+		}
+		if found.side >= 0 {
+			return found, fmt.Errorf("ambiguous column name: %s", column.name)
+		}
+		found = joinColumn{side: side, position: position}
+	}
+	if found.side < 0 {
+		if column.qualifier != "" {
+			return found, fmt.Errorf("%w: %s.%s", ErrNoSuchColumn, column.qualifier, column.name)
+		}
+		return found, fmt.Errorf("%w: %s", ErrNoSuchColumn, column.name)
+	}
+	return found, nil
+}
+
+// mergeOrder returns the index whose order a merge join walks table in to
+// read its rows in order of the joined column, or nil when the column is
+// the rowid and the table itself is in that order. The index must order
+// the column's text by the column's own collating sequence, the one the
+// join compares it in.
+func mergeOrder(table *TableSchema, position int) (*IndexSchema, error) {
+	if position == table.RowIDAlias {
+		return nil, nil
+	}
+	column := table.Columns[position]
+	for i := range table.Indexes {
+		index := &table.Indexes[i]
+		if len(index.Columns) > 0 && strings.EqualFold(index.Columns[0], column.Name) && index.Collations[0] == column.collation() {
+			return index, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported join: no index on %s.%s to merge on", table.Name, table.Columns[position].Name)
+}
+
+// compileJoin plans a join as a sort-merge join, returning what runs it.
+// Each side is read in order of its joined column, through an index on it
+// or by rowid, and the two streams are merged, so only the rows of one side
+// sharing a key are held at once however large the tables are. A join on a
+// column with no index is not supported.
+func (database *Database) compileJoin(statement *joinStatement) (compiledQuery, error) {
+	// Each side is read from the database holding it, so a temporary
+	// table joins with the database's own
+	var owners [2]*Database
+	var tables [2]*TableSchema
+	for i, side := range statement.sides {
+		owner, table, err := database.lookupTable(side.schema, side.table)
+		if err != nil {
+			return nil, err
+		}
+		owners[i], tables[i] = owner, table
+	}
+
+	// keys are the positions of the joined columns, one on each side
+	var keys [2]int
+	var sides [2]bool
+	for _, column := range statement.on {
+		resolved, err := statement.resolve(tables, column)
+		if err != nil {
+			return nil, err
+		}
+		keys[resolved.side], sides[resolved.side] = resolved.position, true
+	}
+	if !sides[0] || !sides[1] {
+		return nil, fmt.Errorf("unsupported join condition: it must compare a column of each table")
+	}
+
+	// Both sides are read in the order of their column's collating
+	// sequence, so the join can only merge columns that share one
+	collation := tables[0].columnCollation(keys[0])
+	if tables[1].columnCollation(keys[1]) != collation {
+		return nil, fmt.Errorf("unsupported join: %s.%s and %s.%s collate differently", tables[0].Name, tables[0].Columns[keys[0]].Name, tables[1].Name, tables[1].Columns[keys[1]].Name)
+	}
+
+	// A masked key would join on the values stored, which queries may not
+	// see, so it is refused
+	var policies [2]*tablePolicy
+	for side, table := range tables {
+		policy, err := database.policyFor(table)
+		if err != nil {
+			return nil, err
+		}
+		if policy.masked(table, keys[side]) {
+			return nil, fmt.Errorf("unsupported join: %s.%s is masked", table.Name, table.Columns[keys[side]].Name)
+		}
+		policies[side] = policy
+	}
+
+	var orders [2]*IndexSchema
+	for side, table := range tables {
+		index, err := mergeOrder(table, keys[side])
+		if err != nil {
+			return nil, err
+		}
+		orders[side] = index
+	}
+
+	var filters [2][]equalityFilter
+	for _, filter := range statement.filters {
+		if filter.outer != nil {
+			return nil, fmt.Errorf("unsupported comparison: %s = %s", columnText(qualifiedColumn{qualifier: filter.qualifier, name: filter.column}), columnText(*filter.outer))
+		}
+		resolved, err := statement.resolve(tables, qualifiedColumn{qualifier: filter.qualifier, name: filter.column})
+		if err != nil {
+			return nil, err
+		}
+		filter.position = resolved.position
+		if !filter.collated {
+			filter.collation = tables[resolved.side].columnCollation(resolved.position)
+		}
+		filters[resolved.side] = append(filters[resolved.side], filter)
+	}
+
+	var selected []joinColumn
+	if statement.star {
+		for side, table := range tables {
+			for position := range table.Columns {
+				selected = append(selected, joinColumn{side: side, position: position})
+			}
+		}
+	}
+	for _, column := range statement.columns {
+		resolved, err := statement.resolve(tables, column)
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, resolved)
+	}
+	columns := make([]ResultColumn, len(selected))
+	for i, column := range selected {
+		table := tables[column.side]
+		origin := table.Columns[column.position]
+		columns[i] = ResultColumn{Name: origin.Name, DeclaredType: origin.DeclaredType, OriginTable: table.Name, OriginColumn: origin.Name}
+		if !statement.star {
+			columns[i].Name = statement.columns[i].name
+		}
+	}
+
+	orderName := func(side int) string {
+		if orders[side] == nil {
+			return "rowid"
+		}
+		return orders[side].Name
+	}
+	return func() *ResultSet {
+		database.logger.Debug("plan", "join", "merge", "left", tables[0].Name, "left order", orderName(0), "right", tables[1].Name, "right order", orderName(1))
+
+		var resultSet *ResultSet
+		rows := func(yield func([]any, error) bool) {
+			if statement.limit == 0 {
+				return
+			}
+			var sides [2]iter.Seq2[keyedRow, error]
+			for side, table := range tables {
+				sides[side] = keyOrder(owners[side].file, owners[side].header, table, policies[side], orders[side], keys[side], filters[side], &resultSet.stats)
+			}
+
+			emitted := int64(0)
+			for pair, err := range mergeJoin(sides[0], sides[1], collation) {
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				values := make([]any, len(selected))
+				for i, column := range selected {
+					values[i] = columnValue(pair[column.side], tables[column.side], column.position)
+				}
+				if !yield(values, nil) {
+					return
+				}
+				if emitted++; statement.limit >= 0 && emitted >= statement.limit {
+					return
+				}
+			}
+		}
+
+		resultSet = newResultSet(columns, rows, nil)
+		resultSet.stats.permissive, resultSet.stats.logger = database.permissive, database.logger
+		return resultSet
+	}, nil
+}
+
+// keyedRow is a row read for a merge join and the value of its joined
+// column.
+type keyedRow struct {
+	key any
+	row *db.Row
+}
+
+// keyOrder yields the rows of table matching filters in order of the
+// column at position, walking index when it is set and the table by rowid
+// otherwise. Rows whose column is NULL are skipped, since NULL equals
+// nothing. Rows are read through the table's policy, when it has one.
+func keyOrder(dbFile *db.DatabaseFile, header *db.DatabaseHeader, table *TableSchema, policy *tablePolicy, index *IndexSchema, position int, filters []equalityFilter, stats *scanStats) iter.Seq2[keyedRow, error] {
+	return func(yield func(keyedRow, error) bool) {
+		if index == nil {
+			scanFilters := filters
+			if policy.masksAny(table, filters) {
+				scanFilters = nil
+			}
+			var stopped bool
+			err := tableScan(dbFile, header, table, scanFilters, false, stats, func(row *db.Row) bool {
+				if row = policy.apply(table, row); row == nil || !matches(row, table, filters) {
+					return true
+				}
+				stopped = !yield(keyedRow{key: row.RowID, row: row}, nil)
+				return !stopped
+			})
+			if err != nil && !stopped {
+				yield(keyedRow{}, err)
+			}
+			return
+		}
+
+		cursor := dbFile.NewCursor(header, index.RootPage)
+		err := cursor.First()
+		for ; err == nil && cursor.Valid(); err = cursor.Next() {
+			entry, err := cursor.IndexCell()
+			if err != nil {
+				if stats.salvage(err) {
+					continue
+				}
+				yield(keyedRow{}, err)
+				return
+			}
+			key, err := entry.Key()
+			if err != nil {
+				yield(keyedRow{}, fmt.Errorf("index %s: %w", index.Name, err))
+				return
+			}
+			stats.indexKeys++
+			if len(key.Values) == 0 || key.Values[0] == nil {
+				continue
+			}
+
+			row, err := dbFile.SeekRowID(header, table.RootPage, key.RowID)
+			if err != nil {
+				if stats.salvage(err) {
+					continue
+				}
+				yield(keyedRow{}, err)
+				return
+			}
+			if row == nil {
+				yield(keyedRow{}, fmt.Errorf("index %s: rowid %d missing from table %s", index.Name, key.RowID, table.Name))
+				return
+			}
+			stats.rowsFetched++
+			if row = policy.apply(table, row); row == nil || !matches(row, table, filters) {
+				continue
+			}
+			if !yield(keyedRow{key: columnValue(row, table, position), row: row}, nil) {
+				return
+			}
+		}
+		if err != nil {
+			yield(keyedRow{}, err)
+		}
+	}
+}
+
+// mergeJoin pairs the rows of left and right with equal keys, given both in
+// key order, comparing text keys by collation. The right rows sharing a
+// key are buffered while the left rows with that key are paired with each
+// of them, and nothing else is held.
+func mergeJoin(left, right iter.Seq2[keyedRow, error], collation db.Collation) iter.Seq2[[2]*db.Row, error] {
+	return func(yield func([2]*db.Row, error) bool) {
+		nextLeft, stopLeft := iter.Pull2(left)
+		defer stopLeft()
+		nextRight, stopRight := iter.Pull2(right)
+		defer stopRight()
+
+		l, err, leftOK := nextLeft()
+		if err != nil {
+			yield([2]*db.Row{}, err)
+			return
+		}
+		r, err, rightOK := nextRight()
+		if err != nil {
+			yield([2]*db.Row{}, err)
+			return
+		}
+
+		var group []*db.Row
+		for leftOK && rightOK {
+			switch order := db.CompareValues(l.key, r.key, collation); {
+			case order < 0:
+				l, err, leftOK = nextLeft()
+			case order > 0:
+				r, err, rightOK = nextRight()
+			default:
+				key := r.key
+				group = group[:0]
+				for rightOK && err == nil && db.CompareValues(r.key, key, collation) == 0 {
+					group = append(group, r.row)
+					r, err, rightOK = nextRight()
+				}
+				if err != nil {
+					break
+				}
+				for leftOK && db.CompareValues(l.key, key, collation) == 0 {
+					for _, row := range group {
+						if !yield([2]*db.Row{l.row, row}, nil) {
+							return
+						}
+					}
+					if l, err, leftOK = nextLeft(); err != nil {
+						break
+					}
+				}
+			}
+			if err != nil {
+				yield([2]*db.Row{}, err)
+				return
+			}
+		}
+	}
+}
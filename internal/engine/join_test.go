@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+// ordersDatabase generates customers and their orders, each order's
+// customer indexed, with some orders for customers that do not exist or
+// for no customer, and tags indexed by name on both tables' sides.
+func ordersDatabase(t *testing.T) string {
+	t.Helper()
+
+	database := testgen.New(testgen.Options{PageSize: 512})
+	customers := database.CreateTable("customers", "CREATE TABLE customers (id integer primary key, name text, tag text)")
+	for i := int64(1); i <= 50; i++ {
+		customers.Insert(i, nil, fmt.Sprintf("customer %d", i), fmt.Sprintf("tag-%d", i%4))
+	}
+	orders := database.CreateTable("orders", "CREATE TABLE orders (id integer primary key, customer_id integer, amount integer, tag text)")
+	for i := int64(1); i <= 400; i++ {
+		var customer any = (i*7)%60 + 1
+		if i%50 == 0 {
+			customer = nil
+		}
+		orders.Insert(i, nil, customer, i*10, fmt.Sprintf("tag-%d", i%6))
+	}
+	database.CreateIndex("idx_orders_customer", orders, "CREATE INDEX idx_orders_customer ON orders (customer_id)", 1)
+	database.CreateIndex("idx_orders_tag", orders, "CREATE INDEX idx_orders_tag ON orders (tag)", 3)
+	database.CreateIndex("idx_customers_tag", customers, "CREATE INDEX idx_customers_tag ON customers (tag)", 2)
+	return database.WriteTemp(t)
+}
+
+// sortedLines renders rows as sqlite3's list mode does, sorted, since a
+// merge join returns rows in key order rather than SQLite's.
+func sortedLines(rows [][]any) []string {
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		fields := make([]string, len(row))
+		for j, value := range row {
+			fields[j] = fmt.Sprint(value)
+		}
+		lines[i] = strings.Join(fields, "|")
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+func TestMergeJoinMatchesSQLite(t *testing.T) {
+	path := ordersDatabase(t)
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+
+	for _, query := range []string{
+		"SELECT customers.name, orders.amount FROM orders JOIN customers ON orders.customer_id = customers.id",
+		"SELECT c.name, o.id FROM customers AS c JOIN orders o ON c.id = o.customer_id WHERE o.tag = 'tag-1'",
+		// Many rows on each side share each tag
+		"SELECT o.id, c.id FROM orders o JOIN customers c ON o.tag = c.tag WHERE c.id IN (3, 4, 9)",
+		"SELECT name, amount FROM customers JOIN orders ON customer_id = customers.id WHERE name = 'customer 8'",
+	} {
+		rows, err := runQuery(openDatabase(t, path), query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		output, err := exec.Command(sqlite3, path, query).Output()
+		if err != nil {
+			t.Fatalf("sqlite3 %s: %v", query, err)
+		}
+		want := strings.Split(strings.TrimSpace(string(output)), "\n")
+		slices.Sort(want)
+		if got := sortedLines(rows); !slices.Equal(got, want) || len(got) == 0 {
+			t.Errorf("%s: got %d rows %v, want %d %v", query, len(got), got, len(want), want)
+		}
+	}
+}
+
+func TestMergeJoinReadsEachRowOnce(t *testing.T) {
+	path := ordersDatabase(t)
+	rows, stats := runSelect(t, path, "SELECT orders.id, customers.name FROM orders JOIN customers ON orders.customer_id = customers.id")
+
+	// Orders for customers 51 to 60, or none, have no match
+	matched := 0
+	for i := 1; i <= 400; i++ {
+		if i%50 != 0 && (i*7)%60+1 <= 50 {
+			matched++
+		}
+	}
+	if len(rows) != matched {
+		t.Errorf("%d rows, want %d", len(rows), matched)
+	}
+	// Every customer and matched order is read once, past the eight NULL
+	// keys, and the merge ends at the first order after the last customer
+	if stats.rowsFetched != 50+matched+1 || stats.indexKeys != 8+matched+1 {
+		t.Errorf("fetched %d rows from %d index keys, want %d from %d", stats.rowsFetched, stats.indexKeys, 50+matched+1, 8+matched+1)
+	}
+
+	rows, stats = runSelect(t, path, "SELECT orders.id FROM orders JOIN customers ON orders.customer_id = customers.id LIMIT 3")
+	if len(rows) != 3 || stats.rowsFetched > 10 {
+		t.Errorf("LIMIT 3 returned %d rows after fetching %d", len(rows), stats.rowsFetched)
+	}
+}
+
+func TestJoinErrors(t *testing.T) {
+	database := openDatabase(t, ordersDatabase(t))
+	for query, want := range map[string]string{
+		"SELECT * FROM orders JOIN customers ON orders.amount = customers.id":      "unsupported join: no index on orders.amount to merge on",
+		"SELECT tag FROM orders JOIN customers ON orders.tag = customers.tag":      "ambiguous column name: tag",
+		"SELECT * FROM orders JOIN customers ON orders.nope = customers.id":        "no such column: orders.nope",
+		"SELECT * FROM orders JOIN customers ON orders.id = orders.customer_id":    "unsupported join condition: it must compare a column of each table",
+		"SELECT * FROM orders LEFT JOIN customers ON orders.id = customers.id":     "unsupported join: orders left join customers on orders.id = customers.id",
+		"SELECT * FROM orders JOIN nosuch ON orders.customer_id = nosuch.id":       "no such table: nosuch",
+		"SELECT * FROM orders o JOIN customers o ON o.customer_id = o.id":          "ambiguous table name in join: o",
+		"SELECT * FROM orders JOIN customers ON orders.customer_id < customers.id": "unsupported join condition: orders.customer_id < customers.id",
+	} {
+		if _, err := runQuery(database, query); err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %q", query, err, want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package engine
+
+// compiledQuery runs a statement whose tables have been resolved and whose
+// plan has been chosen, returning a fresh result set each time.
+type compiledQuery func() *ResultSet
+
+// maxCachedPlans bounds how many compiled statements a connection keeps.
+const maxCachedPlans = 64
+
+// planKey is the text a statement's compiled plan is cached under: the
+// statement respaced as NormalizeSQL spaces it, so that the same statement
+// spaced differently shares a plan. Literals and case are kept, since they
+// reach the results, as the values compared and as column names.
+func planKey(query string) string {
+	return canonicalSQL(query, false)
+}
+
+// cachedPlan returns the plan compiled for a statement earlier, if it is
+// still cached.
+func (database *Database) cachedPlan(key string) (compiledQuery, bool) {
+	run, ok := database.plans[key]
+	if ok {
+		database.counters.planCacheHits.Add(1)
+		database.logger.Debug("reusing plan", "sql", key)
+	}
+	return run, ok
+}
+
+// cachePlan keeps a compiled statement for when it is run again, making
+// room by dropping another when the cache is full.
+func (database *Database) cachePlan(key string, run compiledQuery) {
+	database.counters.planCacheMisses.Add(1)
+	if database.plans == nil {
+		database.plans = make(map[string]compiledQuery)
+	}
+	if len(database.plans) >= maxCachedPlans {
+		for other := range database.plans {
+			delete(database.plans, other)
+			break
+		}
+	}
+	database.plans[key] = run
+}
+
+// forgetSchemas drops the cached table schemas, after the schema cookie has
+// moved or a statement has changed the schema or its statistics, together
+// with every plan compiled against them. A temp schema's change drops the
+// plans of the database it belongs to, which are cached there.
+func (database *Database) forgetSchemas() {
+	database.schemas, database.plans = nil, nil
+	if database.parent != nil {
+		database.parent.plans = nil
+	}
+}
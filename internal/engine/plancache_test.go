@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"bytes"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanKey(t *testing.T) {
+	for query, want := range map[string]string{
+		"SELECT id FROM t":                       "SELECT id FROM t",
+		"  SELECT id\n\tFROM t ;":                "SELECT id FROM t",
+		"SELECT id -- the key\nFROM t":           "SELECT id FROM t",
+		"SELECT id FROM t WHERE x='a  b'":        "SELECT id FROM t WHERE x = 'a  b'",
+		"SELECT id FROM t WHERE x = 'a  b'":      "SELECT id FROM t WHERE x = 'a  b'",
+		"select ID from T":                       "select ID from T",
+		"SELECT \"two  words\" FROM t WHERE x=1": "SELECT \"two  words\" FROM t WHERE x = 1",
+	} {
+		if got := planKey(query); got != want {
+			t.Errorf("planKey(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestPlanCache(t *testing.T) {
+	path := ordersDatabase(t)
+	database := openDatabase(t, path)
+	var records bytes.Buffer
+	database.SetLogger(slog.New(slog.NewTextHandler(&records, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	// The same statement spaced differently is planned once
+	query := "SELECT name FROM customers WHERE tag = 'tag-1' LIMIT 2"
+	first := queryRows(t, database, query)
+	again := queryRows(t, database, "SELECT name\n  FROM customers WHERE tag = 'tag-1'\tLIMIT 2;")
+	if !reflect.DeepEqual(first, again) || len(first) != 2 {
+		t.Errorf("cached plan read %v, first run %v", again, first)
+	}
+	join := "SELECT c.name, o.amount FROM customers c JOIN orders o ON c.id = o.customer_id WHERE o.id = 7"
+	if rows := queryRows(t, database, join); !reflect.DeepEqual(rows, queryRows(t, database, join)) || len(rows) != 1 {
+		t.Errorf("join read %v", rows)
+	}
+	if reused := strings.Count(records.String(), "msg=\"reusing plan\""); reused != 2 {
+		t.Errorf("plans reused %d times, want 2:\n%s", reused, records.String())
+	}
+
+	// Subqueries see rows written since the last run
+	exists := "SELECT count(*) FROM customers WHERE NOT EXISTS (SELECT 1 FROM orders WHERE customers.id = customer_id AND tag = 'tag-x')"
+	if rows := queryRows(t, database, exists); rows[0][0] != int64(50) {
+		t.Fatalf("%s = %v", exists, rows)
+	}
+	if err := execute(t, database, "INSERT INTO orders (customer_id, amount, tag) VALUES (5, 1, 'tag-x')"); err != nil {
+		t.Fatal(err)
+	}
+	if rows := queryRows(t, database, exists); rows[0][0] != int64(49) {
+		t.Errorf("%s after an insert = %v", exists, rows)
+	}
+
+	// A schema change by this connection or another drops the plans
+	star := "SELECT * FROM customers WHERE id = 1"
+	queryRows(t, database, star)
+	if err := execute(t, database, "ALTER TABLE customers ADD COLUMN note text DEFAULT 'n'"); err != nil {
+		t.Fatal(err)
+	}
+	if rows := queryRows(t, database, star); !reflect.DeepEqual(rows, [][]any{{int64(1), "customer 1", "tag-1", "n"}}) {
+		t.Errorf("after ALTER TABLE: %v", rows)
+	}
+	other := openDatabase(t, path)
+	if err := execute(t, other, "ALTER TABLE customers ADD COLUMN rank integer DEFAULT 3"); err != nil {
+		t.Fatal(err)
+	}
+	if rows := queryRows(t, database, star); !reflect.DeepEqual(rows, [][]any{{int64(1), "customer 1", "tag-1", "n", int64(3)}}) {
+		t.Errorf("after another connection's ALTER TABLE: %v", rows)
+	}
+
+	// As does a temporary table hiding the one a plan reads
+	count := "SELECT count(*) FROM orders"
+	queryRows(t, database, count)
+	if err := execute(t, database, "CREATE TEMP TABLE orders (id integer primary key)"); err != nil {
+		t.Fatal(err)
+	}
+	if rows := queryRows(t, database, count); rows[0][0] != int64(0) {
+		t.Errorf("%s after hiding orders = %v", count, rows)
+	}
+}
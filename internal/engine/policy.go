@@ -0,0 +1,138 @@
+package engine
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// RowFilter reports whether queries may see a row of the table it is set
+// for, given its rowid and its values in the order of the table's columns,
+// before any are masked.
+type RowFilter func(rowID int64, values []any) bool
+
+// ColumnMask returns the value queries see in place of a column's value,
+// which may be NULL.
+type ColumnMask func(value any) any
+
+// tablePolicy is what SetRowFilter and SetColumnMask set for a table.
+type tablePolicy struct {
+	filter RowFilter
+	// masks are keyed by lowercased column name
+	masks map[string]ColumnMask
+}
+
+// SetRowFilter hides the rows of the named table that filter rejects from
+// every query, as if they were not there: scans, joins, subqueries,
+// aggregates such as COUNT(*) and MIN, RowCount and Sample. A nil filter
+// removes the table's. It is for services that expose a database whose
+// rows belong to several users to each of them; statements that write,
+// Serialize and Snapshot, and watchers see every row.
+func (database *Database) SetRowFilter(table string, filter RowFilter) {
+	policy := database.tablePolicy(table)
+	policy.filter = filter
+	database.setTablePolicy(table, policy)
+}
+
+// SetColumnMask has queries of the named table see the values of column
+// as mask returns them. The masked values are what WHERE compares, so a
+// filter on a masked column cannot tell its stored values apart, and such
+// a query never uses an index on it. A nil mask removes the column's. A
+// table's INTEGER PRIMARY KEY is its rowid, which cannot be masked:
+// queries of it fail while its mask is set. Statements that write see the
+// stored values, as SetRowFilter's rows.
+func (database *Database) SetColumnMask(table, column string, mask ColumnMask) {
+	policy := database.tablePolicy(table)
+	policy.masks = maps.Clone(policy.masks)
+	if policy.masks == nil {
+		policy.masks = make(map[string]ColumnMask)
+	}
+	if mask == nil {
+		delete(policy.masks, strings.ToLower(column))
+	} else {
+		policy.masks[strings.ToLower(column)] = mask
+	}
+	database.setTablePolicy(table, policy)
+}
+
+func (database *Database) tablePolicy(table string) tablePolicy {
+	if policy := database.policies[strings.ToLower(table)]; policy != nil {
+		return *policy
+	}
+	return tablePolicy{}
+}
+
+// setTablePolicy stores a table's policy, dropping the plans compiled
+// without it.
+func (database *Database) setTablePolicy(table string, policy tablePolicy) {
+	if database.policies == nil {
+		database.policies = make(map[string]*tablePolicy)
+	}
+	if policy.filter == nil && len(policy.masks) == 0 {
+		delete(database.policies, strings.ToLower(table))
+	} else {
+		database.policies[strings.ToLower(table)] = &policy
+	}
+	database.plans = nil
+}
+
+// policyFor returns the policy of a table queries read, or nil when they
+// see its rows as they are stored. A temporary table's is the one set on
+// the database whose temp schema holds it.
+func (database *Database) policyFor(table *TableSchema) (*tablePolicy, error) {
+	for database.parent != nil {
+		database = database.parent
+	}
+	policy := database.policies[strings.ToLower(table.Name)]
+	if policy == nil {
+		return nil, nil
+	}
+	if table.RowIDAlias >= 0 && policy.masks[strings.ToLower(table.Columns[table.RowIDAlias].Name)] != nil {
+		return nil, fmt.Errorf("cannot mask %s.%s: it is the table's rowid", table.Name, table.Columns[table.RowIDAlias].Name)
+	}
+	return policy, nil
+}
+
+// masked reports whether the policy masks the column at position.
+func (policy *tablePolicy) masked(table *TableSchema, position int) bool {
+	return policy != nil && position >= 0 && position < len(table.Columns) && policy.masks[strings.ToLower(table.Columns[position].Name)] != nil
+}
+
+// masksAny reports whether the policy masks a column filters compare.
+func (policy *tablePolicy) masksAny(table *TableSchema, filters []equalityFilter) bool {
+	for _, filter := range filters {
+		if policy.masked(table, filter.position) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns a row as the policy has queries see it: nil when the row
+// filter hides it, and otherwise with every column's value as columnValue
+// reads it, masked where a mask is set.
+func (policy *tablePolicy) apply(table *TableSchema, row *db.Row) *db.Row {
+	if policy == nil {
+		return row
+	}
+	values := make([]any, len(table.Columns))
+	for i := range values {
+		values[i] = columnValue(row, table, i)
+	}
+	if policy.filter != nil && !policy.filter(row.RowID, values) {
+		return nil
+	}
+	if len(policy.masks) == 0 {
+		return row
+	}
+	visible := &db.Row{RecordSize: row.RecordSize, RowID: row.RowID, RecordHeaderSize: row.RecordHeaderSize, Columns: make([]db.Column, len(values))}
+	for i, value := range values {
+		if mask := policy.masks[strings.ToLower(table.Columns[i].Name)]; mask != nil && i != table.RowIDAlias {
+			value = mask(value)
+		}
+		visible.Columns[i].DecodedValue = value
+	}
+	return visible
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+// tenantsDatabase writes accounts of three tenants, with an indexed secret
+// column a policy can mask, and their notes.
+func tenantsDatabase(t *testing.T) string {
+	t.Helper()
+
+	generated := testgen.New(testgen.Options{PageSize: 512})
+	accounts := generated.CreateTable("accounts", "CREATE TABLE accounts (id integer primary key, tenant integer, ssn text, balance integer)")
+	notes := generated.CreateTable("notes", "CREATE TABLE notes (id integer primary key, account integer, body text)")
+	for i := int64(1); i <= 90; i++ {
+		accounts.Insert(i, nil, i%3, fmt.Sprintf("ssn-%03d", i), i*10)
+		notes.Insert(i, nil, i, fmt.Sprintf("note %d", i))
+	}
+	generated.CreateIndex("accounts_ssn", accounts, "CREATE INDEX accounts_ssn ON accounts (ssn)", 2)
+	generated.CreateIndex("accounts_balance", accounts, "CREATE INDEX accounts_balance ON accounts (balance)", 3)
+	generated.CreateIndex("notes_account", notes, "CREATE INDEX notes_account ON notes (account)", 1)
+	return generated.WriteTemp(t)
+}
+
+func TestRowFilterHidesRows(t *testing.T) {
+	database := openDatabase(t, tenantsDatabase(t))
+	database.SetRowFilter("Accounts", func(rowID int64, values []any) bool {
+		return values[1] == int64(1) && values[0] == rowID
+	})
+
+	for _, tc := range []struct {
+		query string
+		want  [][]any
+	}{
+		{"SELECT count(*) FROM accounts", [][]any{{int64(30)}}},
+		{"SELECT max(balance) FROM accounts", [][]any{{int64(880)}}},
+		{"SELECT min(id) FROM accounts", [][]any{{int64(1)}}},
+		{"SELECT id FROM accounts WHERE ssn IN ('ssn-002', 'ssn-004')", [][]any{{int64(4)}}},
+		{"SELECT id FROM accounts WHERE id IN (1, 2, 3)", [][]any{{int64(1)}}},
+		{"SELECT id FROM accounts WHERE balance = 20", nil},
+		{"SELECT count(*) FROM notes WHERE EXISTS (SELECT 1 FROM accounts WHERE accounts.id = notes.account)", [][]any{{int64(30)}}},
+		{"SELECT notes.body FROM notes JOIN accounts ON notes.account = accounts.id WHERE accounts.id IN (1, 2)", [][]any{{"note 1"}}},
+	} {
+		if got := queryRows(t, database, tc.query); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.query, got, tc.want)
+		}
+	}
+
+	if joined := queryRows(t, database, "SELECT notes.id FROM notes JOIN accounts ON notes.account = accounts.id"); len(joined) != 30 {
+		t.Errorf("joined %d rows, want 30", len(joined))
+	}
+	if count, err := database.RowCount("accounts"); err != nil || count != 30 {
+		t.Errorf("RowCount = %d, %v; want 30", count, err)
+	}
+	resultSet, err := database.Sample("accounts", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sampled, err := resultSet.All()
+	if err != nil || len(sampled) != 30 {
+		t.Errorf("sampled %d rows, %v; want 30", len(sampled), err)
+	}
+
+	// Removing the filter shows every row again, past the cached plans
+	database.SetRowFilter("accounts", nil)
+	if got := queryRows(t, database, "SELECT count(*) FROM accounts"); !reflect.DeepEqual(got, [][]any{{int64(90)}}) {
+		t.Errorf("count without the filter: %v", got)
+	}
+}
+
+func TestColumnMaskRedactsValues(t *testing.T) {
+	path := tenantsDatabase(t)
+	database := openDatabase(t, path)
+	// The filter sees the values stored, before they are masked
+	database.SetRowFilter("accounts", func(rowID int64, values []any) bool {
+		return values[2] != "ssn-003"
+	})
+	database.SetColumnMask("accounts", "SSN", func(value any) any {
+		if value == nil {
+			return nil
+		}
+		return "***-" + value.(string)[len(value.(string))-1:]
+	})
+
+	rows, stats := runSelect(t, path, "SELECT ssn FROM accounts WHERE id = 12")
+	if stats.rowsFetched != 1 || rows[0][0] != "ssn-012" {
+		t.Fatalf("another handle sees %v", rows)
+	}
+	for _, tc := range []struct {
+		query string
+		want  [][]any
+	}{
+		{"SELECT ssn, balance FROM accounts WHERE id IN (1, 2)", [][]any{{"***-1", int64(10)}, {"***-2", int64(20)}}},
+		// WHERE compares the masked values, so the stored ones match nothing
+		{"SELECT id FROM accounts WHERE ssn = 'ssn-005'", nil},
+		{"SELECT count(*) FROM accounts WHERE ssn = '***-3'", [][]any{{int64(8)}}},
+		{"SELECT max(ssn) FROM accounts", [][]any{{"***-9"}}},
+		{"SELECT id FROM accounts WHERE ssn = '***-5' AND tenant = 1", [][]any{{int64(25)}, {int64(55)}, {int64(85)}}},
+		{"SELECT body FROM notes WHERE EXISTS (SELECT 1 FROM accounts WHERE accounts.id = notes.account AND ssn = 'ssn-004')", nil},
+	} {
+		if got := queryRows(t, database, tc.query); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.query, got, tc.want)
+		}
+	}
+
+	resultSet, err := database.Query("SELECT id FROM accounts WHERE ssn = '***-7'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resultSet.All(); err != nil {
+		t.Fatal(err)
+	}
+	if resultSet.stats.indexKeys != 0 {
+		t.Errorf("read %d keys of the masked column's index", resultSet.stats.indexKeys)
+	}
+
+	if _, err := database.Query("SELECT notes.id FROM notes JOIN accounts ON notes.body = accounts.ssn"); err == nil || !strings.Contains(err.Error(), "masked") {
+		t.Errorf("join on a masked column: %v", err)
+	}
+	database.SetColumnMask("accounts", "id", func(any) any { return int64(0) })
+	if _, err := database.Query("SELECT balance FROM accounts"); err == nil {
+		t.Error("expected an error masking the rowid")
+	}
+	database.SetColumnMask("accounts", "id", nil)
+	database.SetColumnMask("accounts", "ssn", nil)
+	if got := queryRows(t, database, "SELECT ssn FROM accounts WHERE id = 2"); !reflect.DeepEqual(got, [][]any{{"ssn-002"}}) {
+		t.Errorf("ssn without the mask: %v", got)
+	}
+}
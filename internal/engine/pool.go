@@ -0,0 +1,168 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// ErrPoolClosed is returned by Get once the pool is closed.
+var ErrPoolClosed = errors.New("pool closed")
+
+// Ping checks that the database can still be read: that its file can be
+// locked, and its header and schema read as of the last commit.
+func (database *Database) Ping() error {
+	if err := database.file.LockShared(); err != nil {
+		return err
+	}
+	defer database.file.UnlockShared()
+	if err := database.verifySchema(); err != nil {
+		return err
+	}
+	_, err := database.SchemaObjects()
+	return err
+}
+
+// Pool shares a database among goroutines by giving each its own handle
+// on the file, since a Database is used by one goroutine at a time. It
+// opens handles as they are asked for, up to a limit, and keeps those
+// returned for the next caller, checking each with Ping before handing it
+// out again.
+type Pool struct {
+	opener func() (*Database, error)
+	// slots holds a token for each handle in use, so that no more than
+	// its capacity are
+	slots chan struct{}
+
+	mu sync.Mutex
+	// handles are the handles open, idle or in use
+	handles map[*Database]bool
+	idle    []*Database
+	closed  bool
+	// retired totals the counters of the handles closed
+	retired Counters
+}
+
+// NewPool returns a pool of handles opened by open, such as a closure over
+// OpenReadOnly, of which at most maxOpen are in use at once; zero means
+// runtime.GOMAXPROCS(0). The first handle is opened at once, so that a file
+// that cannot be opened fails here.
+func NewPool(maxOpen int, open func() (*Database, error)) (*Pool, error) {
+	if maxOpen <= 0 {
+		maxOpen = runtime.GOMAXPROCS(0)
+	}
+	database, err := open()
+	if err != nil {
+		return nil, err
+	}
+	pool := &Pool{opener: open, slots: make(chan struct{}, maxOpen), handles: map[*Database]bool{database: true}}
+	pool.idle = append(pool.idle, database)
+	return pool, nil
+}
+
+// Get returns a handle for the caller's use alone, once one is free, which
+// it must give back with Put. A handle returned to the pool that no longer
+// passes Ping is closed and another opened in its place.
+func (pool *Pool) Get(ctx context.Context) (*Database, error) {
+	select {
+	case pool.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	database, err := pool.take()
+	if err != nil {
+		<-pool.slots
+		return nil, err
+	}
+	return database, nil
+}
+
+// take returns an idle handle that passes Ping, or else a new one.
+func (pool *Pool) take() (*Database, error) {
+	for {
+		pool.mu.Lock()
+		if pool.closed {
+			pool.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if len(pool.idle) == 0 {
+			pool.mu.Unlock()
+			break
+		}
+		database := pool.idle[len(pool.idle)-1]
+		pool.idle = pool.idle[:len(pool.idle)-1]
+		pool.mu.Unlock()
+
+		// A handle that waited on another process's lock is still sound
+		err := database.Ping()
+		if err == nil || errors.Is(err, db.ErrBusy) {
+			return database, nil
+		}
+		database.logger.Debug("closing unhealthy pooled handle", "error", err)
+		pool.retire(database)
+	}
+
+	database, err := pool.opener()
+	if err != nil {
+		return nil, err
+	}
+	pool.mu.Lock()
+	pool.handles[database] = true
+	pool.mu.Unlock()
+	return database, nil
+}
+
+// Put gives back a handle Get returned. A handle left in a transaction is
+// closed, rolling it back, rather than handed to the next caller.
+func (pool *Pool) Put(database *Database) {
+	defer func() { <-pool.slots }()
+	pool.mu.Lock()
+	if pool.closed || database.transaction != nil {
+		pool.mu.Unlock()
+		pool.retire(database)
+		return
+	}
+	pool.idle = append(pool.idle, database)
+	pool.mu.Unlock()
+}
+
+// retire closes a handle, keeping its counters in the pool's totals.
+func (pool *Pool) retire(database *Database) error {
+	counters := database.Counters()
+	err := database.Close()
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	delete(pool.handles, database)
+	pool.retired = pool.retired.add(counters)
+	return err
+}
+
+// Counters totals the counters of every handle the pool has opened.
+func (pool *Pool) Counters() Counters {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	total := pool.retired
+	for database := range pool.handles {
+		total = total.add(database.Counters())
+	}
+	return total
+}
+
+// Close closes the idle handles, and the handles in use as they are put
+// back. Get fails from now on.
+func (pool *Pool) Close() error {
+	pool.mu.Lock()
+	pool.closed = true
+	idle := pool.idle
+	pool.idle = nil
+	pool.mu.Unlock()
+
+	var errs []error
+	for _, database := range idle {
+		errs = append(errs, pool.retire(database))
+	}
+	return errors.Join(errs...)
+}
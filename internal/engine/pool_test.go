@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	path := ordersDatabase(t)
+	opened := 0
+	pool, err := NewPool(2, func() (*Database, error) {
+		opened++
+		return Open(path)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+
+	first, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second || opened != 2 {
+		t.Fatalf("two handles in use after %d opens", opened)
+	}
+
+	// No more than two are in use at once
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("third Get: %v", err)
+	}
+
+	queryRows(t, first, "SELECT name FROM customers WHERE id = 17")
+	pool.Put(first)
+	if again, err := pool.Get(context.Background()); err != nil || again != first {
+		t.Errorf("Get after Put returned another handle: %v", err)
+	} else {
+		first = again
+	}
+
+	// A handle left in a transaction, or that fails Ping, is not reused
+	queryRows(t, first, "BEGIN")
+	pool.Put(first)
+	next, err := pool.Get(context.Background())
+	if err != nil || next == first || opened != 3 {
+		t.Errorf("handle left in a transaction was reused: %v", err)
+	}
+	next.file.Close()
+	pool.Put(next)
+	if replaced, err := pool.Get(context.Background()); err != nil || replaced == next || opened != 4 {
+		t.Errorf("unhealthy handle was reused: %v", err)
+	} else {
+		pool.Put(replaced)
+	}
+	pool.Put(second)
+
+	// The counters of closed handles still count
+	if counters := pool.Counters(); counters.RowsReturned != 1 || counters.PagesRead == 0 {
+		t.Errorf("pool counters %+v", counters)
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Get(context.Background()); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Get from a closed pool: %v", err)
+	}
+}
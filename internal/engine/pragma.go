@@ -0,0 +1,322 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// pragmaStatement is a PRAGMA, which the SQL parser does not understand:
+// a name with an optional argument written as "= value" or "(value)".
+type pragmaStatement struct {
+	name     string
+	argument string
+}
+
+// parsePragma recognises a PRAGMA statement, reporting false for anything
+// else.
+func parsePragma(query string) (*pragmaStatement, bool, error) {
+	text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	keyword, rest, _ := strings.Cut(text, " ")
+	if !strings.EqualFold(keyword, "PRAGMA") {
+		return nil, false, nil
+	}
+
+	rest = strings.TrimSpace(rest)
+	name, argument := rest, ""
+	if before, after, ok := strings.Cut(rest, "="); ok {
+		name, argument = before, after
+	} else if before, after, ok := strings.Cut(rest, "("); ok {
+		inner, closed := strings.CutSuffix(strings.TrimSpace(after), ")")
+		if !closed {
+			return nil, true, fmt.Errorf("parse query: unterminated pragma argument in %q", query)
+		}
+		name, argument = before, inner
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, true, fmt.Errorf("parse query: missing pragma name in %q", query)
+	}
+	return &pragmaStatement{name: strings.ToLower(name), argument: strings.TrimSpace(argument)}, true, nil
+}
+
+// walCheckpointModes are the arguments PRAGMA wal_checkpoint accepts.
+var walCheckpointModes = []string{"", "passive", "full", "restart", "truncate"}
+
+// textEncodings names the header's text encoding values as PRAGMA encoding
+// reports them.
+var textEncodings = map[uint32]string{1: "UTF-8", 2: "UTF-16le", 3: "UTF-16be"}
+
+func (database *Database) pragma(statement *pragmaStatement) (*ResultSet, error) {
+	header := database.header
+	argument := unquotePragmaArgument(statement.argument)
+
+	switch statement.name {
+	case "wal_checkpoint":
+		return database.walCheckpoint(strings.ToLower(argument))
+	case "journal_mode":
+		if argument != "" {
+			if err := database.setJournalMode(strings.ToLower(argument)); err != nil {
+				return nil, err
+			}
+		}
+		mode := "delete"
+		switch {
+		case database.file.InMemory():
+			mode = "memory"
+		case database.file.WALMode():
+			mode = "wal"
+		}
+		return pragmaResult(statement.name, mode), nil
+	case "page_size", "encoding":
+		// Both are fixed once the database exists, and SQLite ignores
+		// attempts to set them
+		if argument != "" {
+			return pragmaResult(statement.name), nil
+		}
+		if statement.name == "page_size" {
+			return pragmaResult(statement.name, int64(header.PageSize)), nil
+		}
+		return pragmaResult(statement.name, textEncodings[header.TextEncoding]), nil
+	case "schema_version":
+		if argument != "" {
+			return nil, fmt.Errorf("setting schema_version is not supported")
+		}
+		return pragmaResult(statement.name, int64(int32(header.SchemaCookie))), nil
+	case "user_version":
+		if argument == "" {
+			return pragmaResult(statement.name, int64(int32(header.UserVersion))), nil
+		}
+		version, err := strconv.ParseInt(argument, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user_version: %s", statement.argument)
+		}
+		err = database.write(func(pager *db.Pager) error {
+			pager.Header().UserVersion = uint32(int32(version))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return pragmaResult(statement.name), nil
+	case "busy_timeout":
+		if argument != "" {
+			// As in SQLite, anything but a positive number of milliseconds
+			// turns waiting off
+			milliseconds, err := strconv.ParseInt(argument, 10, 64)
+			if err != nil || milliseconds < 0 {
+				milliseconds = 0
+			}
+			database.SetBusyTimeout(time.Duration(milliseconds) * time.Millisecond)
+		}
+		return pragmaResult("timeout", database.file.BusyTimeout.Milliseconds()), nil
+	case "database_list":
+		// The main database, and the temp schema once it has a table, since
+		// ATTACH is not supported
+		columns := []ResultColumn{{Name: "seq"}, {Name: "name"}, {Name: "file"}}
+		return newResultSet(columns, func(yield func([]any, error) bool) {
+			if yield([]any{int64(0), "main", database.Path()}, nil) && database.temp != nil {
+				yield([]any{int64(1), "temp", ""}, nil)
+			}
+		}, nil), nil
+	case "foreign_key_list":
+		return database.foreignKeyList(argument)
+	case "foreign_key_check":
+		return database.foreignKeyCheck(argument)
+	case "integrity_check":
+		return database.integrityCheck(argument)
+	case "foreign_keys":
+		if argument != "" {
+			database.setForeignKeys(argument)
+			return pragmaResult(statement.name), nil
+		}
+		enabled := int64(0)
+		if database.foreignKeys {
+			enabled = 1
+		}
+		return pragmaResult(statement.name, enabled), nil
+	}
+	return nil, fmt.Errorf("unsupported pragma: %s", statement.name)
+}
+
+// foreignKeyList lists a table's foreign keys as SQLite does: one row per
+// column pair, with keys numbered from the last declared, and no rows for a
+// table that does not exist. SQLite ignores MATCH clauses, so match is
+// always NONE.
+func (database *Database) foreignKeyList(tableName string) (*ResultSet, error) {
+	var foreignKeys []ForeignKey
+	if tableName != "" {
+		table, err := database.TableSchema(tableName)
+		if err != nil && !errors.Is(err, ErrNoSuchTable) {
+			return nil, err
+		}
+		if table != nil {
+			foreignKeys = table.ForeignKeys
+		}
+	}
+
+	var rows [][]any
+	for id := range foreignKeys {
+		foreignKey := foreignKeys[len(foreignKeys)-1-id]
+		for seq, column := range foreignKey.Columns {
+			var parentColumn any
+			if seq < len(foreignKey.ParentColumns) {
+				parentColumn = foreignKey.ParentColumns[seq]
+			}
+			rows = append(rows, []any{int64(id), int64(seq), foreignKey.ParentTable, column, parentColumn, foreignKey.OnUpdate, foreignKey.OnDelete, "NONE"})
+		}
+	}
+
+	columns := []ResultColumn{{Name: "id"}, {Name: "seq"}, {Name: "table"}, {Name: "from"}, {Name: "to"}, {Name: "on_update"}, {Name: "on_delete"}, {Name: "match"}}
+	return newResultSet(columns, func(yield func([]any, error) bool) {
+		for _, row := range rows {
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}, nil), nil
+}
+
+// integrityCheck implements PRAGMA integrity_check, over every table or
+// the one named: every page of each table's b-tree must parse, and each
+// index's keys must sort strictly in order, as db.VerifyIndexOrder
+// checks them. It reports one row per problem, up to the number given in
+// place of a table name or 100, or a single "ok".
+func (database *Database) integrityCheck(argument string) (*ResultSet, error) {
+	limit, err := strconv.Atoi(argument)
+	tableName := ""
+	if err != nil {
+		limit, tableName = 100, argument
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	var tables []*TableSchema
+	if tableName != "" {
+		table, err := database.TableSchema(tableName)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	} else {
+		objects, err := database.SchemaObjects()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			if object.Type != "table" {
+				continue
+			}
+			table, err := database.TableSchema(object.Name)
+			if err != nil {
+				return nil, err
+			}
+			tables = append(tables, table)
+		}
+	}
+
+	columns := []ResultColumn{{Name: "integrity_check"}}
+	return newResultSet(columns, func(yield func([]any, error) bool) {
+		var problems []string
+		for _, table := range tables {
+			if _, err := database.file.CountRows(database.header, table.RootPage); err != nil {
+				problems = append(problems, fmt.Sprintf("table %s: %v", table.Name, err))
+			}
+			for _, index := range table.Indexes {
+				if err := database.file.VerifyIndexOrder(database.header, index.RootPage, index.Collations); err != nil {
+					problems = append(problems, fmt.Sprintf("index %s: %v", index.Name, err))
+				}
+			}
+		}
+		if len(problems) == 0 {
+			problems = append(problems, "ok")
+		}
+		for _, problem := range problems[:min(len(problems), limit)] {
+			if !yield([]any{problem}, nil) {
+				return
+			}
+		}
+	}, nil), nil
+}
+
+// pragmaResult is the result of a pragma: one row holding value, if given,
+// in a column named after the pragma, or no rows at all.
+func pragmaResult(name string, value ...any) *ResultSet {
+	return newResultSet([]ResultColumn{{Name: name}}, func(yield func([]any, error) bool) {
+		if len(value) > 0 {
+			yield(value, nil)
+		}
+	}, nil)
+}
+
+// unquotePragmaArgument strips the quotes SQLite allows around a pragma's
+// argument.
+func unquotePragmaArgument(argument string) string {
+	if len(argument) >= 2 && (argument[0] == '\'' || argument[0] == '"') && argument[len(argument)-1] == argument[0] {
+		return argument[1 : len(argument)-1]
+	}
+	return argument
+}
+
+// setJournalMode switches between the rollback journal, which this engine
+// always deletes on commit, and the write-ahead log. Like SQLite, other mode
+// names leave the mode unchanged.
+func (database *Database) setJournalMode(mode string) error {
+	// An in-memory database keeps its journal in memory whatever is asked
+	if mode != "wal" && mode != "delete" || database.file.InMemory() {
+		return nil
+	}
+	if database.transaction != nil && (mode == "wal") != database.file.WALMode() {
+		if mode == "wal" {
+			return errors.New("cannot change into wal mode from within a transaction")
+		}
+		return errors.New("cannot change out of wal mode from within a transaction")
+	}
+	pager := db.NewPager(database.file, database.header)
+	if err := pager.SetWALMode(mode == "wal"); err != nil {
+		return fmt.Errorf("set journal mode: %w", err)
+	}
+	database.schemaObjects = nil
+	return nil
+}
+
+// walCheckpoint folds the write-ahead log back into the database file. As
+// the only connection there are no readers to wait for, so every mode
+// copies all frames and restarts the log; TRUNCATE also empties the log
+// file. The result matches SQLite's: a busy flag, the frames in the log
+// and the frames checkpointed, with -1 for both counts when the database
+// is not in WAL mode.
+func (database *Database) walCheckpoint(mode string) (*ResultSet, error) {
+	if !slices.Contains(walCheckpointModes, mode) {
+		return nil, fmt.Errorf("unsupported checkpoint mode: %s", mode)
+	}
+
+	row := []any{int64(0), int64(-1), int64(-1)}
+	if database.file.WALMode() && database.transaction != nil {
+		return nil, errors.New("database table is locked")
+	}
+	switch {
+	case database.file.WALMode() && mode == "truncate":
+		// The log is empty afterwards, which is what SQLite reports
+		if _, err := database.file.CheckpointTruncate(); err != nil {
+			return nil, fmt.Errorf("checkpoint: %w", err)
+		}
+		row[1], row[2] = int64(0), int64(0)
+	case database.file.WALMode():
+		frames, err := database.file.Checkpoint()
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint: %w", err)
+		}
+		row[1], row[2] = int64(frames), int64(frames)
+	}
+
+	columns := []ResultColumn{{Name: "busy"}, {Name: "log"}, {Name: "checkpointed"}}
+	return newResultSet(columns, func(yield func([]any, error) bool) { yield(row, nil) }, nil), nil
+}
@@ -0,0 +1,238 @@
+package engine
+
+import (
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestParsePragma(t *testing.T) {
+	tests := []struct {
+		query string
+		want  *pragmaStatement
+	}{
+		{"PRAGMA wal_checkpoint", &pragmaStatement{name: "wal_checkpoint"}},
+		{"pragma WAL_CHECKPOINT(TRUNCATE);", &pragmaStatement{name: "wal_checkpoint", argument: "TRUNCATE"}},
+		{"PRAGMA user_version = 7", &pragmaStatement{name: "user_version", argument: "7"}},
+	}
+	for _, test := range tests {
+		got, ok, err := parsePragma(test.query)
+		if !ok || err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parsePragma(%q) = %+v, %v, %v; want %+v", test.query, got, ok, err, test.want)
+		}
+	}
+
+	if _, ok, _ := parsePragma("SELECT 1"); ok {
+		t.Error("SELECT parsed as a pragma")
+	}
+	if _, ok, err := parsePragma("PRAGMA wal_checkpoint(FULL"); !ok || err == nil {
+		t.Error("unterminated argument accepted")
+	}
+}
+
+func TestWALCheckpointPragma(t *testing.T) {
+	database := testgen.New(testgen.Options{PageSize: 1024, WAL: true})
+	items := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text)")
+	for i := int64(1); i <= 10; i++ {
+		items.Insert(i, nil, "item")
+	}
+	path := database.WriteTemp(t)
+
+	// Commit one page to the write-ahead log
+	dbFile, header, err := db.OpenWritableDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pager := db.NewPager(dbFile, header)
+	pager.Header().UserVersion = 5
+	if err := pager.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	dbFile.Close()
+
+	handle := openDatabase(t, path)
+	checkpoint := func() [][]any { return queryRows(t, handle, "PRAGMA wal_checkpoint") }
+
+	if got, want := checkpoint(), [][]any{{int64(0), int64(1), int64(1)}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("checkpoint = %v, want %v", got, want)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.BigEndian.Uint32(contents[60:64]); got != 5 {
+		t.Fatalf("user version %d in the database file after checkpoint, want 5", got)
+	}
+	if got, want := checkpoint(), [][]any{{int64(0), int64(0), int64(0)}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("second checkpoint = %v, want %v", got, want)
+	}
+
+	rows, _ := runSelect(t, path, "SELECT COUNT(*) FROM items")
+	if !reflect.DeepEqual(rows, [][]any{{int64(10)}}) {
+		t.Fatalf("count after checkpoint = %v", rows)
+	}
+
+	queryRows(t, handle, "PRAGMA user_version = 6")
+	if got, want := queryRows(t, handle, "PRAGMA wal_checkpoint(TRUNCATE)"), [][]any{{int64(0), int64(0), int64(0)}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("truncating checkpoint = %v, want %v", got, want)
+	}
+	if info, err := os.Stat(path + "-wal"); err != nil || info.Size() != 0 {
+		t.Fatalf("log not truncated: %v", err)
+	}
+}
+
+func TestWALCheckpointPragmaOutsideWALMode(t *testing.T) {
+	rows := queryRows(t, openDatabase(t, companiesDatabase(t, 10)), "PRAGMA wal_checkpoint(PASSIVE)")
+	if want := [][]any{{int64(0), int64(-1), int64(-1)}}; !reflect.DeepEqual(rows, want) {
+		t.Fatalf("checkpoint = %v, want %v", rows, want)
+	}
+}
+
+// queryRows runs a statement against an open database and returns its rows.
+func queryRows(t *testing.T, database *Database, query string) [][]any {
+	t.Helper()
+
+	resultSet, err := database.Query(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	rows, err := resultSet.All()
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return rows
+}
+
+func TestHeaderPragmas(t *testing.T) {
+	database := openDatabase(t, companiesDatabase(t, 10))
+	tests := []struct {
+		query string
+		want  [][]any
+	}{
+		{"PRAGMA page_size", [][]any{{int64(512)}}},
+		{"PRAGMA encoding", [][]any{{"UTF-8"}}},
+		{"PRAGMA journal_mode", [][]any{{"delete"}}},
+		{"PRAGMA user_version", [][]any{{int64(0)}}},
+		// Fixed once the database exists
+		{"PRAGMA page_size = 4096", nil},
+		{"PRAGMA page_size", [][]any{{int64(512)}}},
+		{"PRAGMA encoding = 'UTF-16le'", nil},
+		{"PRAGMA encoding", [][]any{{"UTF-8"}}},
+		// Unknown journal modes leave the mode unchanged
+		{"PRAGMA journal_mode = bogus", [][]any{{"delete"}}},
+		{"PRAGMA database_list", [][]any{{int64(0), "main", database.Path()}}},
+	}
+	for _, test := range tests {
+		if got := queryRows(t, database, test.query); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %v, want %v", test.query, got, test.want)
+		}
+	}
+	if !filepath.IsAbs(database.Path()) || database.ReadOnly() {
+		t.Errorf("path %q, read-only %v", database.Path(), database.ReadOnly())
+	}
+}
+
+func TestSetUserVersion(t *testing.T) {
+	path := companiesDatabase(t, 10)
+	database := openDatabase(t, path)
+	if rows := queryRows(t, database, "PRAGMA user_version = -3"); len(rows) != 0 {
+		t.Fatalf("setting user_version returned %v", rows)
+	}
+	if got := queryRows(t, database, "PRAGMA user_version"); !reflect.DeepEqual(got, [][]any{{int64(-3)}}) {
+		t.Fatalf("user_version = %v after setting it", got)
+	}
+	if got := queryRows(t, openDatabase(t, path), "PRAGMA user_version"); !reflect.DeepEqual(got, [][]any{{int64(-3)}}) {
+		t.Fatalf("user_version = %v after reopening", got)
+	}
+	if _, err := database.Query("PRAGMA user_version = many"); err == nil {
+		t.Fatal("non-integer user_version accepted")
+	}
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		if output, err := exec.Command(sqlite3, path, "PRAGMA user_version").Output(); err != nil || string(output) != "-3\n" {
+			t.Fatalf("sqlite3 user_version: %q %v", output, err)
+		}
+	}
+}
+
+func TestSwitchJournalMode(t *testing.T) {
+	path := companiesDatabase(t, 10)
+	database := openDatabase(t, path)
+
+	if got := queryRows(t, database, "PRAGMA journal_mode = WAL"); !reflect.DeepEqual(got, [][]any{{"wal"}}) {
+		t.Fatalf("journal_mode = WAL returned %v", got)
+	}
+	// Writes now go to the log
+	queryRows(t, database, "PRAGMA user_version = 9")
+	if info, err := os.Stat(path + "-wal"); err != nil || info.Size() == 0 {
+		t.Fatalf("no log after a write in WAL mode: %v", err)
+	}
+	if got := queryRows(t, openDatabase(t, path), "PRAGMA journal_mode"); !reflect.DeepEqual(got, [][]any{{"wal"}}) {
+		t.Fatalf("journal_mode after reopening = %v", got)
+	}
+
+	if got := queryRows(t, database, "PRAGMA journal_mode = delete"); !reflect.DeepEqual(got, [][]any{{"delete"}}) {
+		t.Fatalf("journal_mode = delete returned %v", got)
+	}
+	if _, err := os.Stat(path + "-wal"); !os.IsNotExist(err) {
+		t.Fatalf("log left behind after leaving WAL mode: %v", err)
+	}
+	if got := queryRows(t, database, "PRAGMA user_version"); !reflect.DeepEqual(got, [][]any{{int64(9)}}) {
+		t.Fatalf("user_version = %v after leaving WAL mode", got)
+	}
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		output, err := exec.Command(sqlite3, path, "PRAGMA integrity_check", "PRAGMA journal_mode", "PRAGMA user_version").Output()
+		if err != nil || string(output) != "ok\ndelete\n9\n" {
+			t.Fatalf("sqlite3 after switching journal modes: %q %v", output, err)
+		}
+	}
+}
+
+func TestIntegrityCheckVerifiesIndexOrder(t *testing.T) {
+	generated := testgen.New(testgen.Options{PageSize: 1024})
+	table := generated.CreateTable("t", "CREATE TABLE t (id integer primary key, name text)")
+	for i, name := range []string{"delta", "alpha", "charlie", "bravo"} {
+		table.Insert(int64(i+1), nil, name)
+	}
+	generated.CreateIndex("t_name", table, "CREATE INDEX t_name ON t (name)", 1)
+	path := generated.WriteTemp(t)
+
+	database := openDatabase(t, path)
+	if got := queryRows(t, database, "PRAGMA integrity_check"); !reflect.DeepEqual(got, [][]any{{"ok"}}) {
+		t.Fatalf("integrity_check of a sound file = %v", got)
+	}
+	schema, err := database.TableSchema("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := schema.Indexes[0].RootPage
+	database.Close()
+
+	// Swapping the index leaf's first two cell pointers puts bravo before
+	// alpha
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pointers := data[(root-1)*1024+8:]
+	first, second := binary.BigEndian.Uint16(pointers[0:2]), binary.BigEndian.Uint16(pointers[2:4])
+	binary.BigEndian.PutUint16(pointers[0:2], second)
+	binary.BigEndian.PutUint16(pointers[2:4], first)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]any{{"index t_name: index rooted at 3, entry 1: key for rowid 2 out of order"}}
+	for _, query := range []string{"PRAGMA integrity_check", "PRAGMA integrity_check(t)", "PRAGMA integrity_check = 1"} {
+		if got := queryRows(t, openDatabase(t, path), query); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s on a misordered index = %v, want %v", query, got, want)
+		}
+	}
+}
@@ -2,53 +2,137 @@ package engine
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
 	"github.com/xwb1989/sqlparser"
 )
 
 func TableNameFromQuery(query string) (string, error) {
-	stmt, err := sqlparser.Parse(query)
+	stmt, err := parseSQL(query)
 	if err != nil {
 		return "", fmt.Errorf("parse query: %w", err)
 	}
 
+	if err := resolveSchemas(stmt); err != nil {
+		return "", err
+	}
 	switch stmt := stmt.(type) {
 	case *sqlparser.Select:
-		for _, expr := range stmt.From {
-			ate, ok := expr.(*sqlparser.AliasedTableExpr)
-			if !ok {
-				continue
-			}
+		table, _, err := selectTable(stmt)
+		return table.Name.String(), err
+	}
 
-			tbl, ok := ate.Expr.(sqlparser.TableName)
-			if !ok {
-				continue
-			}
+	return "", fmt.Errorf("unsupported query type: %T", stmt)
+}
+
+// mainSchema reports whether a schema qualifier names the database's own
+// schema, main, or is empty.
+func mainSchema(qualifier string) bool {
+	return qualifier == "" || strings.EqualFold(qualifier, "main")
+}
 
-			return tbl.Name.String(), nil
+// unqualifiedName strips the main schema from a table name written as
+// main.name, as statements read without the SQL parser take it. Names
+// qualified by another schema are kept whole, so that looking them up finds
+// no such table, as in SQLite.
+func unqualifiedName(name string) string {
+	if schema, rest, ok := strings.Cut(name, "."); ok && mainSchema(schema) {
+		return unquoteIdentifier(rest)
+	}
+	return name
+}
+
+// resolveSchemas checks the schemas a parsed statement's tables and columns
+// are qualified with. Tables keep a main or temp qualifier, lowercased, for
+// lookupTable to find them by, and a table of any other schema is reported
+// missing, since no databases are attached. Columns drop a main or temp
+// qualifier, so that main.t.c names column c of table t whichever schema
+// holds t.
+func resolveSchemas(stmt sqlparser.SQLNode) error {
+	schema := func(name sqlparser.TableName) (sqlparser.TableName, error) {
+		if name.Qualifier.IsEmpty() {
+			return name, nil
+		}
+		switch qualifier := strings.ToLower(name.Qualifier.String()); qualifier {
+		case "main", "temp":
+			return sqlparser.TableName{Name: name.Name, Qualifier: sqlparser.NewTableIdent(qualifier)}, nil
 		}
-		return "", fmt.Errorf("select query missing table")
+		return name, fmt.Errorf("%w: %s.%s", ErrNoSuchTable, name.Qualifier.String(), name.Name.String())
 	}
+	return sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		var err error
+		switch node := node.(type) {
+		case *sqlparser.AliasedTableExpr:
+			if name, ok := node.Expr.(sqlparser.TableName); ok {
+				node.Expr, err = schema(name)
+			}
+		case *sqlparser.Insert:
+			node.Table, err = schema(node.Table)
+		case *sqlparser.ColName:
+			if qualifier := node.Qualifier.Qualifier; !qualifier.IsEmpty() {
+				if !mainSchema(qualifier.String()) && !strings.EqualFold(qualifier.String(), "temp") {
+					return false, fmt.Errorf("%w: %s.%s.%s", ErrNoSuchColumn, qualifier.String(), node.Qualifier.Name.String(), node.Name.String())
+				}
+				node.Qualifier.Qualifier = sqlparser.NewTableIdent("")
+			}
+		}
+		return err == nil, err
+	}, stmt)
+}
 
-	return "", fmt.Errorf("unsupported query type: %T", stmt)
+// selectTable returns the table a SELECT reads, with the schema it was
+// qualified with, and the alias it gives it, if any.
+func selectTable(sel *sqlparser.Select) (sqlparser.TableName, string, error) {
+	for _, expr := range sel.From {
+		ate, ok := expr.(*sqlparser.AliasedTableExpr)
+		if !ok {
+			continue
+		}
+
+		tbl, ok := ate.Expr.(sqlparser.TableName)
+		if !ok {
+			continue
+		}
+
+		return tbl, ate.As.String(), nil
+	}
+	return sqlparser.TableName{}, "", fmt.Errorf("select query missing table")
 }
 
-func RowCount(path, tableName string) (uint16, error) {
-	_, schemaPage, err := db.LoadPage(path, 1)
-	if err != nil {
+// RowCount counts the rows of a table without decoding any of them, unless
+// it has a row filter, which must see each row to hide it.
+func (database *Database) RowCount(tableName string) (int64, error) {
+	if err := database.file.LockShared(); err != nil {
 		return 0, err
 	}
-
-	rootPageNum, err := db.RootPageLookup(tableName, schemaPage)
+	defer database.file.UnlockShared()
+	if err := database.verifySchema(); err != nil {
+		return 0, err
+	}
+	if policy := database.policies[strings.ToLower(tableName)]; policy != nil && policy.filter != nil {
+		_, table, err := database.lookupTable("", tableName)
+		if err != nil {
+			return 0, err
+		}
+		var count int64
+		err = tableScan(database.file, database.header, table, nil, false, &scanStats{}, func(row *db.Row) bool {
+			if policy.apply(table, row) != nil {
+				count++
+			}
+			return true
+		})
+		return count, err
+	}
+	objects, err := database.SchemaObjects()
 	if err != nil {
 		return 0, err
 	}
 
-	_, rootPage, err := db.LoadPage(path, rootPageNum)
+	rootPageNum, err := db.RootPageLookup(tableName, objects)
 	if err != nil {
 		return 0, err
 	}
 
-	return rootPage.CellCount, nil
+	return database.file.CountRows(database.header, rootPageNum)
 }
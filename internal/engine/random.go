@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"errors"
+	"math/rand/v2"
+)
+
+// maxBlobLength is SQLite's default cap on the length of a string or blob.
+const maxBlobLength = 1_000_000_000
+
+// functions holds the connection's settings and state its functions read:
+// the source random() and randomblob() draw from, made on first use, and
+// whether case is Unicode's, which SetUnicode sets.
+type functions struct {
+	random *rand.Rand
+	// unicode has upper, lower and LIKE fold the case of every letter, as
+	// SQLite built with ICU does, rather than ASCII letters only
+	unicode bool
+}
+
+// SetRandomSeed seeds the source random() and randomblob() draw from, so
+// that the same statements return the same values each time the database
+// is opened with the same seed. Unseeded, the source is seeded at random.
+func (database *Database) SetRandomSeed(seed int64) {
+	database.functionState().random = rand.New(rand.NewPCG(uint64(seed), 0))
+}
+
+// functionState returns the connection's function state, which the temp
+// schema shares with the database it belongs to.
+func (database *Database) functionState() *functions {
+	for database.parent != nil {
+		database = database.parent
+	}
+	return &database.functions
+}
+
+// scope returns a scope resolving columns with column and calling the
+// connection's functions.
+func (database *Database) scope(column columnResolver) scope {
+	return scope{column: column, functions: database.functionState()}
+}
+
+// source returns the source to draw from: the connection's, or without one
+// a source seeded at random.
+func (state *functions) source() *rand.Rand {
+	if state == nil {
+		return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	if state.random == nil {
+		state.random = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	return state.random
+}
+
+// randomBlob returns n random bytes, or one when n is less than one, as
+// SQLite's randomblob does.
+func (state *functions) randomBlob(n int64) ([]byte, error) {
+	if n > maxBlobLength {
+		return nil, errors.New("string or blob too big")
+	}
+	blob := make([]byte, max(n, 1))
+	source := state.source()
+	for i := 0; i < len(blob); i += 8 {
+		word := source.Uint64()
+		for j := i; j < min(i+8, len(blob)); j++ {
+			blob[j] = byte(word)
+			word >>= 8
+		}
+	}
+	return blob, nil
+}
@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestRandomSeedRepeatsValues(t *testing.T) {
+	generated := testgen.New(testgen.Options{})
+	generated.CreateTable("tokens", "CREATE TABLE tokens (id integer primary key, token blob DEFAULT (randomblob(8)), n integer)")
+	path := generated.WriteTemp(t)
+
+	draw := func(database *Database) [][]any {
+		t.Helper()
+		rows := queryRows(t, database, "SELECT random(), randomblob(3), typeof(random())")
+		if err := execute(t, database, "INSERT INTO tokens (n) VALUES (random())"); err != nil {
+			t.Fatal(err)
+		}
+		return append(rows, queryRows(t, database, "SELECT token, n FROM tokens ORDER BY id DESC LIMIT 1")...)
+	}
+	first := openDatabase(t, path)
+	first.SetRandomSeed(7)
+	want := draw(first)
+	if want[0][2] != "integer" || len(want[0][1].([]byte)) != 3 || len(want[1][0].([]byte)) != 8 {
+		t.Fatalf("drew %v", want)
+	}
+
+	second := openDatabase(t, path)
+	second.SetRandomSeed(7)
+	if got := draw(second); !reflect.DeepEqual(got, want) {
+		t.Errorf("same seed drew %v, want %v", got, want)
+	}
+	second.SetRandomSeed(8)
+	if got := draw(second); reflect.DeepEqual(got[0], want[0]) {
+		t.Errorf("another seed drew the same %v", got[0])
+	}
+}
+
+func TestRandomBlobLength(t *testing.T) {
+	database := openDatabase(t, testgen.New(testgen.Options{}).WriteTemp(t))
+	got := queryRows(t, database, "SELECT length(randomblob(16)), length(randomblob(0)), length(randomblob(-3)), length(randomblob(NULL)), length(randomblob('5'))")
+	if want := [][]any{{int64(16), int64(1), int64(1), int64(1), int64(5)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, query := range []string{"SELECT random(1)", "SELECT randomblob()", "SELECT randomblob(2000000000)"} {
+		if _, err := database.Query(query); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrStatementNotAllowed is returned for statements the read-only SQL mode
+// rejects.
+var ErrStatementNotAllowed = errors.New("statement not allowed in read-only SQL mode")
+
+// writingPragmas are the pragmas that write to the file when given a value.
+// wal_checkpoint writes even without one.
+var writingPragmas = map[string]bool{"journal_mode": true, "schema_version": true, "user_version": true}
+
+// checkReadOnlySQL accepts SELECT, VALUES, EXPLAIN, and PRAGMAs that read or change
+// a setting of the connection alone, and rejects everything else from its
+// leading keyword, before it is parsed any further. Statements that open
+// with a comment are rejected too, rather than looking past it.
+func checkReadOnlySQL(query string) error {
+	text := strings.TrimSpace(query)
+	end := strings.IndexFunc(text, func(r rune) bool { return !unicode.IsLetter(r) })
+	if end < 0 {
+		end = len(text)
+	}
+	keyword := strings.ToUpper(text[:end])
+
+	switch keyword {
+	case "SELECT", "VALUES", "EXPLAIN":
+		return nil
+	case "PRAGMA":
+		statement, _, err := parsePragma(query)
+		if err != nil {
+			return err
+		}
+		if statement.name == "wal_checkpoint" || (writingPragmas[statement.name] && statement.argument != "") {
+			return fmt.Errorf("%w: PRAGMA %s", ErrStatementNotAllowed, statement.name)
+		}
+		return nil
+	case "":
+		return fmt.Errorf("%w: %q", ErrStatementNotAllowed, query)
+	}
+	return fmt.Errorf("%w: %s", ErrStatementNotAllowed, keyword)
+}
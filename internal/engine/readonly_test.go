@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReadOnlySQLRejectsWrites(t *testing.T) {
+	database := openDatabase(t, companiesDatabase(t, 10))
+	database.SetReadOnlySQL(true)
+
+	for _, query := range []string{
+		"SELECT count(*) FROM companies",
+		"  select name from companies where id = 1",
+		"PRAGMA user_version",
+		"PRAGMA journal_mode",
+		"PRAGMA foreign_keys = on",
+		"PRAGMA busy_timeout = 10",
+		"PRAGMA foreign_key_check(companies)",
+	} {
+		if err := execute(t, database, query); err != nil {
+			t.Errorf("%s: %v", query, err)
+		}
+	}
+
+	for _, query := range []string{
+		"INSERT INTO companies (name) VALUES ('x')",
+		"insert into companies (name) values ('x')",
+		"UPDATE companies SET name = 'x'",
+		"DELETE FROM companies",
+		"BEGIN",
+		"SAVEPOINT s",
+		"ALTER TABLE companies RENAME TO firms",
+		"ANALYZE",
+		"PRAGMA user_version = 3",
+		"PRAGMA journal_mode = wal",
+		"PRAGMA wal_checkpoint",
+		"/* SELECT */ INSERT INTO companies (name) VALUES ('x')",
+		"WITH x AS (SELECT 1) SELECT * FROM x",
+		"",
+	} {
+		if err := execute(t, database, query); !errors.Is(err, ErrStatementNotAllowed) {
+			t.Errorf("%q: got %v, want ErrStatementNotAllowed", query, err)
+		}
+	}
+
+	database.SetReadOnlySQL(false)
+	if rows := queryRows(t, database, "PRAGMA user_version"); !reflect.DeepEqual(rows, [][]any{{int64(0)}}) {
+		t.Fatalf("user_version changed: %v", rows)
+	}
+	if rows := queryRows(t, database, "SELECT count(*) FROM companies"); !reflect.DeepEqual(rows, [][]any{{int64(10)}}) {
+		t.Fatalf("rows changed: %v", rows)
+	}
+}
@@ -0,0 +1,160 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// ResultColumn describes one column of a result set.
+type ResultColumn struct {
+	Name string
+	// DeclaredType is the type from the table's CREATE TABLE statement, or
+	// empty for computed columns
+	DeclaredType string
+	// OriginTable is the table the column is read from, or empty for
+	// computed columns
+	OriginTable string
+	// OriginColumn is the name the table declares the column by, or empty
+	// for computed columns
+	OriginColumn string
+}
+
+// ErrResultTooLarge is returned when a query's results pass the database's
+// Limits.
+var ErrResultTooLarge = errors.New("result too large")
+
+// Limits caps the results of each query, for callers running SQL they do
+// not trust. Zero means no limit.
+type Limits struct {
+	// MaxRows is the most rows a query may return
+	MaxRows int64
+	// MaxResultBytes is the most bytes of values a query may return,
+	// counting eight for each number and the length of each string or blob
+	MaxResultBytes int64
+}
+
+// ResultSet streams the rows of a query. Rows are produced on demand by Next,
+// so the database stays open until Close is called.
+type ResultSet struct {
+	Columns []ResultColumn
+
+	next  func() ([]any, error, bool)
+	stop  func()
+	close func() error
+	row   []any
+	err   error
+	stats scanStats
+	// limits caps the rows returned so far, counted by rows and bytes
+	limits Limits
+	rows   int64
+	bytes  int64
+	// counters, when set, are the database's totals the rows are added to
+	counters *counters
+}
+
+func newResultSet(columns []ResultColumn, rows iter.Seq2[[]any, error], closer func() error) *ResultSet {
+	next, stop := iter.Pull2(rows)
+	return &ResultSet{Columns: columns, next: next, stop: stop, close: closer}
+}
+
+// Next advances to the next row, returning false when the rows are
+// exhausted or an error occurred.
+func (resultSet *ResultSet) Next() bool {
+	if resultSet.err != nil || resultSet.next == nil {
+		return false
+	}
+
+	row, err, ok := resultSet.next()
+	if !ok {
+		resultSet.row = nil
+		return false
+	}
+	if err == nil {
+		err = resultSet.count(row)
+	}
+	if err != nil {
+		resultSet.err, resultSet.row = err, nil
+		return false
+	}
+
+	resultSet.row = row
+	return true
+}
+
+// count adds a row to the totals checked against the limits, and to the
+// database's.
+func (resultSet *ResultSet) count(row []any) error {
+	var size int64
+	for _, value := range row {
+		switch value := value.(type) {
+		case int64, float64:
+			size += 8
+		case string:
+			size += int64(len(value))
+		case []byte:
+			size += int64(len(value))
+		}
+	}
+	resultSet.rows++
+	resultSet.bytes += size
+	if limit := resultSet.limits.MaxRows; limit > 0 && resultSet.rows > limit {
+		return fmt.Errorf("%w: more than %d rows", ErrResultTooLarge, limit)
+	}
+	if limit := resultSet.limits.MaxResultBytes; limit > 0 && resultSet.bytes > limit {
+		return fmt.Errorf("%w: more than %d bytes", ErrResultTooLarge, limit)
+	}
+	if resultSet.counters != nil {
+		resultSet.counters.rowsReturned.Add(1)
+		resultSet.counters.bytesReturned.Add(size)
+	}
+	return nil
+}
+
+// Row returns the current row's values.
+func (resultSet *ResultSet) Row() []any {
+	return resultSet.row
+}
+
+// Warnings returns the corrupt cells a permissive query has skipped so
+// far, each with the page and cell it was found at.
+func (resultSet *ResultSet) Warnings() []*db.CorruptionError {
+	return resultSet.stats.skipped
+}
+
+// Err returns the error, if any, that stopped iteration.
+func (resultSet *ResultSet) Err() error {
+	return resultSet.err
+}
+
+// Close stops the query and releases the database file.
+func (resultSet *ResultSet) Close() error {
+	if resultSet.stop != nil {
+		resultSet.stop()
+		resultSet.stop = nil
+	}
+	resultSet.next = nil
+
+	if resultSet.close == nil {
+		return nil
+	}
+	closer := resultSet.close
+	resultSet.close = nil
+	return closer()
+}
+
+// All drains the result set into a slice and closes it.
+func (resultSet *ResultSet) All() ([][]any, error) {
+	var rows [][]any
+	for resultSet.Next() {
+		rows = append(rows, resultSet.Row())
+	}
+
+	err := resultSet.Err()
+	if closeErr := resultSet.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("close result set: %w", closeErr)
+	}
+	return rows, err
+}
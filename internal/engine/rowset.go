@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"iter"
+	"slices"
+)
+
+// rowSet is a set of rowids held as sorted, disjoint runs of consecutive
+// rowids, in the spirit of SQLite's RowSet object. Index probes find rowids
+// in clusters, since rows inserted together sit next to each other, so runs
+// keep the set small, and merging or intersecting two sets is a single pass
+// over their runs rather than a hash lookup per rowid.
+type rowSet struct {
+	runs []rowRun
+}
+
+// rowRun is the rowids first through last, inclusive.
+type rowRun struct {
+	first, last int64
+}
+
+// newRowSet returns the set of rowIDs, which it sorts in place.
+func newRowSet(rowIDs []int64) *rowSet {
+	slices.Sort(rowIDs)
+	set := &rowSet{}
+	for _, rowID := range rowIDs {
+		set.extend(rowRun{rowID, rowID})
+	}
+	return set
+}
+
+// extend adds run, which must start no earlier than the set's last run,
+// joining it to that run when they overlap or touch.
+func (set *rowSet) extend(run rowRun) {
+	if n := len(set.runs); n > 0 {
+		last := &set.runs[n-1]
+		// Written so that neither side overflows at the ends of int64
+		if run.first <= last.last || run.first-1 == last.last {
+			last.last = max(last.last, run.last)
+			return
+		}
+	}
+	set.runs = append(set.runs, run)
+}
+
+// len returns how many rowids the set holds.
+func (set *rowSet) len() int {
+	n := 0
+	for _, run := range set.runs {
+		n += int(run.last-run.first) + 1
+	}
+	return n
+}
+
+// contains reports whether rowID is in the set.
+func (set *rowSet) contains(rowID int64) bool {
+	low, high := 0, len(set.runs)
+	for low < high {
+		middle := int(uint(low+high) >> 1)
+		if set.runs[middle].last < rowID {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	return low < len(set.runs) && set.runs[low].first <= rowID
+}
+
+// union returns the rowids in either set.
+func (set *rowSet) union(other *rowSet) *rowSet {
+	merged := &rowSet{runs: make([]rowRun, 0, len(set.runs)+len(other.runs))}
+	i, j := 0, 0
+	for i < len(set.runs) || j < len(other.runs) {
+		if j == len(other.runs) || i < len(set.runs) && set.runs[i].first <= other.runs[j].first {
+			merged.extend(set.runs[i])
+			i++
+		} else {
+			merged.extend(other.runs[j])
+			j++
+		}
+	}
+	return merged
+}
+
+// intersect returns the rowids in both sets.
+func (set *rowSet) intersect(other *rowSet) *rowSet {
+	common := &rowSet{}
+	i, j := 0, 0
+	for i < len(set.runs) && j < len(other.runs) {
+		a, b := set.runs[i], other.runs[j]
+		if first, last := max(a.first, b.first), min(a.last, b.last); first <= last {
+			common.runs = append(common.runs, rowRun{first, last})
+		}
+		if a.last < b.last {
+			i++
+		} else {
+			j++
+		}
+	}
+	return common
+}
+
+// all yields the set's rowids in ascending order, or descending.
+func (set *rowSet) all(descending bool) iter.Seq[int64] {
+	return func(yield func(int64) bool) {
+		if descending {
+			for i := len(set.runs) - 1; i >= 0; i-- {
+				run := set.runs[i]
+				for rowID := run.last; ; rowID-- {
+					if !yield(rowID) {
+						return
+					}
+					if rowID == run.first {
+						break
+					}
+				}
+			}
+			return
+		}
+		for _, run := range set.runs {
+			for rowID := run.first; ; rowID++ {
+				if !yield(rowID) {
+					return
+				}
+				if rowID == run.last {
+					break
+				}
+			}
+		}
+	}
+}
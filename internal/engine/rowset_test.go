@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+func TestRowSet(t *testing.T) {
+	set := newRowSet([]int64{9, 3, 4, 5, 3, 12, 10, 11, math.MaxInt64, math.MinInt64, math.MaxInt64})
+	if want := []rowRun{{math.MinInt64, math.MinInt64}, {3, 5}, {9, 12}, {math.MaxInt64, math.MaxInt64}}; !slices.Equal(set.runs, want) {
+		t.Fatalf("runs = %v, want %v", set.runs, want)
+	}
+	if set.len() != 9 {
+		t.Errorf("len = %d, want 9", set.len())
+	}
+	for rowID, want := range map[int64]bool{2: false, 3: true, 5: true, 6: false, 12: true, 13: false, math.MaxInt64: true, math.MinInt64: true} {
+		if set.contains(rowID) != want {
+			t.Errorf("contains(%d) = %v", rowID, !want)
+		}
+	}
+	if got, want := slices.Collect(set.all(true))[:4], []int64{math.MaxInt64, 12, 11, 10}; !slices.Equal(got, want) {
+		t.Errorf("descending = %v, want %v", got, want)
+	}
+
+	other := newRowSet([]int64{1, 2, 5, 6, 7, 11, 20})
+	if got, want := slices.Collect(set.intersect(other).all(false)), []int64{5, 11}; !slices.Equal(got, want) {
+		t.Errorf("intersect = %v, want %v", got, want)
+	}
+	union := set.union(other)
+	if want := []rowRun{{math.MinInt64, math.MinInt64}, {1, 7}, {9, 12}, {20, 20}, {math.MaxInt64, math.MaxInt64}}; !slices.Equal(union.runs, want) {
+		t.Errorf("union runs = %v, want %v", union.runs, want)
+	}
+	if empty := newRowSet(nil); empty.len() != 0 || empty.contains(0) || len(slices.Collect(empty.all(false))) != 0 {
+		t.Error("empty set is not empty")
+	}
+}
+
+// benchmarkProbes returns the rowids found by probes probes of an index,
+// each ascending as an index keeps the entries for one key, and clustered
+// in runs of ten as rows inserted together are.
+func benchmarkProbes(probes, perProbe int, seed uint64) [][]int64 {
+	random := rand.New(rand.NewPCG(seed, 0))
+	found := make([][]int64, probes)
+	for i := range found {
+		rowID := int64(0)
+		for j := 0; j < perProbe; j++ {
+			if j%10 == 0 {
+				rowID += 1 + random.Int64N(100)
+			}
+			found[i] = append(found[i], rowID)
+			rowID++
+		}
+	}
+	return found
+}
+
+// mergedProbes builds the set the probes find, as indexMatches does.
+func mergedProbes(found [][]int64) *rowSet {
+	set := newRowSet(nil)
+	for _, rowIDs := range found {
+		set = set.union(newRowSet(slices.Clone(rowIDs)))
+	}
+	return set
+}
+
+const rowSetProbes, rowSetProbeSize = 10, 10_000
+
+func BenchmarkRowSetIntersect(b *testing.B) {
+	left, right := benchmarkProbes(rowSetProbes, rowSetProbeSize, 1), benchmarkProbes(rowSetProbes, rowSetProbeSize, 2)
+	for b.Loop() {
+		common := mergedProbes(left).intersect(mergedProbes(right))
+		for range common.all(false) {
+		}
+	}
+}
+
+// BenchmarkMapIntersect does the work of BenchmarkRowSetIntersect with a
+// map, sorting the result since rows are visited in rowid order.
+func BenchmarkMapIntersect(b *testing.B) {
+	left, right := benchmarkProbes(rowSetProbes, rowSetProbeSize, 1), benchmarkProbes(rowSetProbes, rowSetProbeSize, 2)
+	for b.Loop() {
+		seen := make(map[int64]struct{})
+		for _, rowIDs := range left {
+			for _, rowID := range rowIDs {
+				seen[rowID] = struct{}{}
+			}
+		}
+		var common []int64
+		for _, rowIDs := range right {
+			for _, rowID := range rowIDs {
+				if _, ok := seen[rowID]; ok {
+					common = append(common, rowID)
+					delete(seen, rowID)
+				}
+			}
+		}
+		slices.Sort(common)
+	}
+}
+
+func BenchmarkRowSetContains(b *testing.B) {
+	rowIDs := benchmarkProbes(1, rowSetProbeSize, 1)[0]
+	set := newRowSet(slices.Clone(rowIDs))
+	found := 0
+	for b.Loop() {
+		for _, rowID := range rowIDs[:1000] {
+			if set.contains(rowID + 1) {
+				found++
+			}
+		}
+	}
+	if found == 0 {
+		b.Fatal("no rowid found")
+	}
+}
+
+func BenchmarkMapContains(b *testing.B) {
+	rowIDs := benchmarkProbes(1, rowSetProbeSize, 1)[0]
+	set := make(map[int64]struct{}, len(rowIDs))
+	for _, rowID := range rowIDs {
+		set[rowID] = struct{}{}
+	}
+	found := 0
+	for b.Loop() {
+		for _, rowID := range rowIDs[:1000] {
+			if _, ok := set[rowID+1]; ok {
+				found++
+			}
+		}
+	}
+	if found == 0 {
+		b.Fatal("no rowid found")
+	}
+}
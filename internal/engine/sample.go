@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// sampleProbes is how many random rowids Sample probes for each row it
+// returns before settling for the rows it has.
+const sampleProbes = 8
+
+// sampledRow is a row Sample has picked, with the rowid it sorts by.
+type sampledRow struct {
+	rowID  int64
+	values []any
+}
+
+// Sample returns n rows of a table picked at random, in rowid order, or
+// every row when it has no more than n. Rather than scan the table it seeks
+// the b-tree to random rowids between the smallest and the largest and
+// takes the row at or after each, so a preview of a huge table reads a few
+// pages per row. Rows are close to uniformly likely when rowids are dense,
+// as they are unless many rows have been deleted: a row after a gap is
+// picked more often, in proportion to the gap. When gaps leave the probes
+// short of n distinct rows, the table is scanned instead and n of its rows
+// kept by reservoir sampling, each as likely as any other.
+func (database *Database) Sample(tableName string, n int) (*ResultSet, error) {
+	if n < 0 {
+		return nil, errors.New("sample size must not be negative")
+	}
+	if err := database.file.LockShared(); err != nil {
+		return nil, err
+	}
+	defer database.file.UnlockShared()
+	if err := database.verifySchema(); err != nil {
+		return nil, err
+	}
+	owner, table, err := database.lookupTable("", tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := database.policyFor(table)
+	if err != nil {
+		return nil, err
+	}
+
+	// A table of no more than n rows is returned whole, which reading its
+	// first n+1 rows tells. Rows its policy hides are passed over.
+	cursor := owner.file.NewCursor(owner.header, table.RootPage)
+	var rows []sampledRow
+	read := func() (*sampledRow, error) {
+		row, err := cursor.Row()
+		if err != nil {
+			return nil, err
+		}
+		if row = policy.apply(table, row); row == nil {
+			return nil, nil
+		}
+		values := make([]any, len(table.Columns))
+		for i := range table.Columns {
+			values[i] = columnValue(row, table, i)
+		}
+		return &sampledRow{row.RowID, values}, nil
+	}
+	take := func() error {
+		row, err := read()
+		if row != nil {
+			rows = append(rows, *row)
+		}
+		return err
+	}
+	if err := cursor.First(); err != nil {
+		return nil, err
+	}
+	for cursor.Valid() && len(rows) <= n {
+		if err := take(); err != nil {
+			return nil, err
+		}
+		if err := cursor.Next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rows) > n {
+		smallest := rows[0].rowID
+		if err := cursor.Last(); err != nil {
+			return nil, err
+		}
+		largest, err := cursor.RowID()
+		if err != nil {
+			return nil, err
+		}
+
+		rows = rows[:0]
+		seen := make(map[int64]bool, n)
+		span := uint64(largest - smallest)
+		for probe := 0; len(rows) < n && probe < n*sampleProbes; probe++ {
+			offset := rand.Uint64()
+			if span != math.MaxUint64 {
+				offset = rand.Uint64N(span + 1)
+			}
+			if err := cursor.SeekRowID(smallest + int64(offset)); err != nil {
+				return nil, err
+			}
+			rowID, err := cursor.RowID()
+			if err != nil {
+				return nil, err
+			}
+			if seen[rowID] {
+				continue
+			}
+			seen[rowID] = true
+			if err := take(); err != nil {
+				return nil, err
+			}
+		}
+		if len(rows) < n {
+			if rows, err = reservoirSample(cursor, read, n); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	slices.SortFunc(rows, func(a, b sampledRow) int { return cmp.Compare(a.rowID, b.rowID) })
+	columns := make([]ResultColumn, len(table.Columns))
+	for i, column := range table.Columns {
+		columns[i] = ResultColumn{Name: column.Name, DeclaredType: column.DeclaredType, OriginTable: table.Name, OriginColumn: column.Name}
+	}
+	return newResultSet(columns, func(yield func([]any, error) bool) {
+		for _, row := range rows {
+			if !yield(row.values, nil) {
+				return
+			}
+		}
+	}, nil), nil
+}
+
+// reservoirSample scans a table from its first row and keeps n of the
+// rows read returns, each as likely to be kept as any other.
+func reservoirSample(cursor *db.Cursor, read func() (*sampledRow, error), n int) ([]sampledRow, error) {
+	rows := make([]sampledRow, 0, n)
+	seen := 0
+	if err := cursor.First(); err != nil {
+		return nil, err
+	}
+	for cursor.Valid() {
+		row, err := read()
+		if err != nil {
+			return nil, err
+		}
+		if row != nil {
+			// The row replaces a kept one with probability n/seen
+			seen++
+			if len(rows) < n {
+				rows = append(rows, *row)
+			} else if kept := rand.IntN(seen); kept < n {
+				rows[kept] = *row
+			}
+		}
+		if err := cursor.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
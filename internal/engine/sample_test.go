@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestSample(t *testing.T) {
+	database := openDatabase(t, ordersDatabase(t))
+	sample := func(table string, n int) [][]any {
+		t.Helper()
+		resultSet, err := database.Sample(table, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := resultSet.All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+
+	// Distinct rows of the table, in rowid order
+	rows := sample("orders", 25)
+	if len(rows) != 25 {
+		t.Fatalf("sampled %d rows, want 25", len(rows))
+	}
+	for i, row := range rows {
+		if i > 0 && row[0].(int64) <= rows[i-1][0].(int64) {
+			t.Errorf("row %d has id %v after %v", i, row[0], rows[i-1][0])
+		}
+		want := queryRows(t, database, fmt.Sprintf("SELECT * FROM orders WHERE id = %d", row[0]))
+		if !reflect.DeepEqual([][]any{row}, want) {
+			t.Errorf("sampled %v, table has %v", row, want)
+		}
+	}
+
+	// A table no bigger than the sample is returned whole
+	if rows := sample("customers", 50); !reflect.DeepEqual(rows, queryRows(t, database, "SELECT * FROM customers")) {
+		t.Errorf("sample of every customer: %v", rows)
+	}
+	if rows := sample("customers", 0); len(rows) != 0 {
+		t.Errorf("empty sample: %v", rows)
+	}
+	if _, err := database.Sample("missing", 5); !errors.Is(err, ErrNoSuchTable) {
+		t.Errorf("sample of a missing table: %v", err)
+	}
+}
+
+// TestSampleAfterRowIDGap samples a table whose dense rowids are followed
+// by one far past them, where nearly every rowid probed is in the gap.
+func TestSampleAfterRowIDGap(t *testing.T) {
+	generated := testgen.New(testgen.Options{PageSize: 512})
+	gapped := generated.CreateTable("gapped", "CREATE TABLE gapped (id integer primary key, name text)")
+	for i := int64(1); i <= 3000; i++ {
+		gapped.Insert(i, nil, fmt.Sprintf("row %d", i))
+	}
+	gapped.Insert(9000000000, nil, "far")
+	database := openDatabase(t, generated.WriteTemp(t))
+
+	for _, n := range []int{1, 5, 100} {
+		resultSet, err := database.Sample("gapped", n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := resultSet.All()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != n {
+			t.Fatalf("sampled %d rows, want %d", len(rows), n)
+		}
+		for i, row := range rows {
+			if i > 0 && row[0].(int64) <= rows[i-1][0].(int64) {
+				t.Errorf("row %d has id %v after %v", i, row[0], rows[i-1][0])
+			}
+		}
+	}
+}
@@ -0,0 +1,801 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// TableSchema describes a rowid table as declared by its CREATE TABLE
+// statement, along with the indexes defined on it.
+type TableSchema struct {
+	Name     string
+	RootPage uint32
+	Columns  []ColumnSchema
+	// RowIDAlias is the position of the INTEGER PRIMARY KEY column, or -1
+	RowIDAlias int
+	// PrimaryKey names the primary key columns in key order, and is empty
+	// when the table declares none
+	PrimaryKey []string
+	// UniqueKeys are the column sets no two rows may share: the primary
+	// key and UNIQUE constraints in declaration order, then unique indexes
+	UniqueKeys  [][]string
+	Indexes     []IndexSchema
+	ForeignKeys []ForeignKey
+	// Checks are the CHECK constraints, on columns and on the table, in
+	// declaration order
+	Checks []Check
+	// defaults holds each column's constant DEFAULT value, which rows
+	// written before ALTER TABLE added the column read back as
+	defaults []any
+}
+
+type ColumnSchema struct {
+	Name         string
+	DeclaredType string
+	Affinity     Affinity
+	PrimaryKey   bool
+	NotNull      bool
+	Unique       bool
+	// Default is the DEFAULT expression as written, or empty if there is none
+	Default string
+	// Collation is the declared collating sequence, or empty for BINARY
+	Collation string
+}
+
+// ForeignKey is a REFERENCES clause, from either a column constraint or a
+// table-level FOREIGN KEY constraint, in declaration order.
+type ForeignKey struct {
+	// Columns are the child table's columns, paired with ParentColumns
+	Columns     []string
+	ParentTable string
+	// ParentColumns are the referenced columns, or empty when the clause
+	// refers to the parent's primary key
+	ParentColumns []string
+	// OnUpdate and OnDelete are the declared actions, NO ACTION by default
+	OnUpdate string
+	OnDelete string
+}
+
+// Check is a CHECK constraint: its expression as written, and its name when
+// declared with CONSTRAINT name, which SQLite reports in its place.
+type Check struct {
+	Name       string
+	Expression string
+}
+
+type IndexSchema struct {
+	Name     string
+	RootPage uint32
+	// Columns are the indexed column names in key order
+	Columns []string
+	// Collations order the text of each of Columns: the collating sequence
+	// the index names for it, or else the column's own. Sequences SQLite
+	// does not build in are taken as BINARY. A column kept in descending
+	// order has db.Descending set as well.
+	Collations []db.Collation
+	Unique     bool
+	// Stats is the index's sqlite_stat1 entry, once ANALYZE has run: its
+	// number of entries, then the average number sharing each prefix of
+	// the key
+	Stats []int64
+}
+
+// ErrNoSuchTable is returned when a statement names a table the schema does
+// not define.
+var ErrNoSuchTable = errors.New("no such table")
+
+// ErrWithoutRowID is returned for a WITHOUT ROWID table, whose rows are
+// stored in an index b-tree the engine does not read or write.
+var ErrWithoutRowID = errors.New("WITHOUT ROWID tables are not supported")
+
+// ErrNoSuchColumn is returned when a statement names a column its table
+// does not define.
+var ErrNoSuchColumn = errors.New("no such column")
+
+// ColumnIndex returns the record position of the named column, matching
+// names case-insensitively as SQLite does.
+func (table *TableSchema) ColumnIndex(name string) (int, bool) {
+	for i, column := range table.Columns {
+		if strings.EqualFold(column.Name, name) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Affinity is a column's preferred storage class, derived from its declared
+// type.
+type Affinity int
+
+const (
+	AffinityBlob Affinity = iota
+	AffinityText
+	AffinityNumeric
+	AffinityInteger
+	AffinityReal
+)
+
+// affinityOf applies SQLite's affinity rules to a declared type, in order.
+// Columns with REAL affinity may store integral values as integers on disk,
+// which must be read back as reals.
+func affinityOf(declared string) Affinity {
+	declared = strings.ToUpper(declared)
+	switch {
+	case strings.Contains(declared, "INT"):
+		return AffinityInteger
+	case strings.Contains(declared, "CHAR"), strings.Contains(declared, "CLOB"), strings.Contains(declared, "TEXT"):
+		return AffinityText
+	case declared == "" || strings.Contains(declared, "BLOB"):
+		return AffinityBlob
+	case strings.Contains(declared, "REAL"), strings.Contains(declared, "FLOA"), strings.Contains(declared, "DOUB"):
+		return AffinityReal
+	}
+	return AffinityNumeric
+}
+
+// applyAffinity converts a value as SQLite does before comparing it with,
+// or storing it in, a column of the given affinity: in a column with
+// numeric affinity, text that reads as a number in full, with nothing
+// around it but ASCII spaces, becomes that number, and
+// reals with no fractional part become integers unless the affinity is
+// REAL, which turns integers into reals instead. Numbers stored in a TEXT
+// column become text. Blobs and NULL are never converted.
+func applyAffinity(value any, affinity Affinity) any {
+	switch number := value.(type) {
+	case int64:
+		switch affinity {
+		case AffinityText:
+			return textValue(number)
+		case AffinityReal:
+			return float64(number)
+		}
+		return value
+	case float64:
+		switch affinity {
+		case AffinityText:
+			return textValue(number)
+		case AffinityInteger, AffinityNumeric:
+			if realIsInteger(number) {
+				return int64(number)
+			}
+		}
+		return value
+	}
+	text, ok := value.(string)
+	if !ok || affinity == AffinityBlob || affinity == AffinityText {
+		return value
+	}
+
+	text = strings.Trim(text, sqlSpaces)
+	if integer, err := strconv.ParseInt(text, 10, 64); err == nil {
+		if affinity == AffinityReal {
+			return float64(integer)
+		}
+		return integer
+	}
+	// ParseFloat also accepts spellings SQLite does not, such as "inf" and
+	// hexadecimal floats
+	if strings.IndexFunc(text, func(r rune) bool { return !strings.ContainsRune("0123456789+-.eE", r) }) >= 0 {
+		return value
+	}
+	real, ok := parseReal(text)
+	if !ok {
+		return value
+	}
+	// Reals with no fractional part are stored as integers where they fit
+	if affinity != AffinityReal && realIsInteger(real) {
+		return int64(real)
+	}
+	return real
+}
+
+// sqlSpaces are the characters SQLite skips around a number in text.
+const sqlSpaces = " \t\n\v\f\r"
+
+// parseReal parses text that ParseFloat reads as a decimal real, where a
+// magnitude too large for a float64 is an infinity, as in SQLite.
+func parseReal(text string) (float64, bool) {
+	real, err := strconv.ParseFloat(text, 64)
+	return real, err == nil || errors.Is(err, strconv.ErrRange)
+}
+
+// realIsInteger reports whether a real has no fractional part and lies
+// strictly between the smallest and largest integers, so that SQLite's
+// numeric affinities hold it as an integer.
+func realIsInteger(real float64) bool {
+	return real == float64(int64(real)) && real > -9223372036854775808 && real < 9223372036854775808
+}
+
+// schemaTableSQL is the definition of the schema table itself, which the
+// file does not record. It is stored in the b-tree rooted at page 1.
+const schemaTableSQL = "CREATE TABLE sqlite_schema (type text, name text, tbl_name text, rootpage int, sql text)"
+
+// isSchemaTable reports whether a table name refers to the schema table,
+// under its current name or its legacy sqlite_master alias.
+func isSchemaTable(tableName string) bool {
+	return strings.EqualFold(tableName, "sqlite_schema") || strings.EqualFold(tableName, "sqlite_master")
+}
+
+// TableSchema returns the schema of the named table. Schemas are parsed on
+// first use and cached for the life of the handle.
+func (database *Database) TableSchema(tableName string) (*TableSchema, error) {
+	key := strings.ToLower(tableName)
+	if isSchemaTable(tableName) {
+		key = "sqlite_schema"
+	}
+	if table, ok := database.schemas[key]; ok {
+		return table, nil
+	}
+
+	objects, err := database.SchemaObjects()
+	if err != nil {
+		return nil, err
+	}
+	table, err := loadTableSchema(objects, tableName, database.header.SchemaFormat)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.loadStatistics(objects, table); err != nil {
+		return nil, err
+	}
+
+	if database.schemas == nil {
+		database.schemas = make(map[string]*TableSchema)
+	}
+	database.schemas[key] = table
+	return table, nil
+}
+
+// loadTableSchema parses the named table and its indexes from the schema of
+// a database in schemaFormat, which decides whether their DESC columns are
+// stored in descending order.
+func loadTableSchema(objects []db.TableMetadata, tableName string, schemaFormat uint32) (*TableSchema, error) {
+	if isSchemaTable(tableName) {
+		return parseTableSchema(db.TableMetadata{Type: "table", Name: "sqlite_schema", TableName: "sqlite_schema", RootPage: 1, SQL: schemaTableSQL})
+	}
+
+	var table *TableSchema
+	var err error
+	for _, object := range objects {
+		if object.Type == "table" && strings.EqualFold(object.Name, tableName) {
+			if withoutRowID(object.SQL) {
+				return nil, fmt.Errorf("%w: %s", ErrWithoutRowID, object.Name)
+			}
+			if table, err = parseTableSchema(object); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if table == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchTable, tableName)
+	}
+
+	autoindexKeys := table.autoindexKeys()
+
+	for _, object := range objects {
+		if object.Type != "index" || !strings.EqualFold(object.TableName, table.Name) {
+			continue
+		}
+
+		if object.SQL == "" {
+			suffix, ok := strings.CutPrefix(strings.ToLower(object.Name), "sqlite_autoindex_"+strings.ToLower(table.Name)+"_")
+			if n, err := strconv.Atoi(suffix); ok && err == nil && n >= 1 && n <= len(autoindexKeys) {
+				key := autoindexKeys[n-1]
+				table.Indexes = append(table.Indexes, IndexSchema{Name: object.Name, RootPage: object.RootPage, Columns: key, Collations: table.keyCollations(key, nil), Unique: true})
+			}
+			continue
+		}
+
+		columns, err := indexColumns(object.SQL)
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", object.Name, err)
+		}
+		words := strings.Fields(strings.ToUpper(object.SQL))
+		unique := len(words) >= 2 && words[1] == "UNIQUE"
+		collations := table.keyCollations(columns, indexCollations(object.SQL))
+		// Formats before 4 parse DESC but build the index ascending
+		if schemaFormat == 0 || schemaFormat >= 4 {
+			for i, descending := range indexDirections(object.SQL) {
+				if descending && i < len(collations) {
+					collations[i] |= db.Descending
+				}
+			}
+		}
+		table.Indexes = append(table.Indexes, IndexSchema{Name: object.Name, RootPage: object.RootPage, Columns: columns, Collations: collations, Unique: unique})
+		if unique {
+			table.UniqueKeys = append(table.UniqueKeys, columns)
+		}
+	}
+
+	return table, nil
+}
+
+// autoindexKeys returns the keys SQLite creates an index named
+// sqlite_autoindex_<table>_<N> for, with no SQL, the Nth for the Nth key
+// that needs one: every unique key the table declares except a primary key
+// aliasing the rowid.
+func (table *TableSchema) autoindexKeys() [][]string {
+	var keys [][]string
+	for _, key := range table.UniqueKeys {
+		if len(key) == 1 && table.RowIDAlias >= 0 && strings.EqualFold(key[0], table.Columns[table.RowIDAlias].Name) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func parseTableSchema(object db.TableMetadata) (*TableSchema, error) {
+	definitions, err := parenthesizedList(object.SQL)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %w", object.Name, err)
+	}
+
+	table := &TableSchema{Name: object.Name, RootPage: object.RootPage, RowIDAlias: -1}
+
+	for _, definition := range definitions {
+		tokens := definitionTokens(definition)
+		// A named table constraint is known by what follows its name
+		var constraintName string
+		if len(tokens) >= 2 && strings.EqualFold(tokens[0], "CONSTRAINT") {
+			constraintName = unquoteIdentifier(tokens[1])
+			tokens = tokens[2:]
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(tokens[0]) {
+		case "CHECK":
+			if len(tokens) >= 2 {
+				table.Checks = append(table.Checks, Check{Name: constraintName, Expression: checkExpression(tokens[1])})
+			}
+			continue
+		case "PRIMARY", "UNIQUE":
+			columns := identifierList(definition)
+			if strings.EqualFold(tokens[0], "PRIMARY") {
+				table.PrimaryKey = columns
+			}
+			table.UniqueKeys = append(table.UniqueKeys, columns)
+			continue
+		case "FOREIGN":
+			// FOREIGN KEY (columns) REFERENCES ...
+			if len(tokens) >= 4 && strings.EqualFold(tokens[3], "REFERENCES") {
+				foreignKey, _ := parseReferences(tokens[4:])
+				foreignKey.Columns = identifierList(tokens[2])
+				table.ForeignKeys = append(table.ForeignKeys, foreignKey)
+			}
+			continue
+		}
+
+		name, rest := splitIdentifier(definition)
+		column, foreignKeys, checks := parseColumnDefinition(name, rest)
+		table.Columns = append(table.Columns, column)
+		table.ForeignKeys = append(table.ForeignKeys, foreignKeys...)
+		table.Checks = append(table.Checks, checks...)
+		if column.PrimaryKey {
+			table.PrimaryKey = []string{column.Name}
+			table.UniqueKeys = append(table.UniqueKeys, table.PrimaryKey)
+		}
+		if column.Unique {
+			table.UniqueKeys = append(table.UniqueKeys, []string{column.Name})
+		}
+	}
+
+	table.defaults = make([]any, len(table.Columns))
+	for i, column := range table.Columns {
+		if value, err := defaultValue(column, nil); err == nil {
+			table.defaults[i] = applyAffinity(value, column.Affinity)
+		}
+	}
+
+	// A table-level PRIMARY KEY marks its columns as the key
+	for _, name := range table.PrimaryKey {
+		if position, ok := table.ColumnIndex(name); ok {
+			table.Columns[position].PrimaryKey = true
+		}
+	}
+
+	// A primary key on exactly one column declared INTEGER aliases the rowid
+	var keyColumns []int
+	for i, column := range table.Columns {
+		if column.PrimaryKey {
+			keyColumns = append(keyColumns, i)
+		}
+	}
+	if len(keyColumns) == 1 && strings.EqualFold(table.Columns[keyColumns[0]].DeclaredType, "INTEGER") {
+		table.RowIDAlias = keyColumns[0]
+	}
+
+	return table, nil
+}
+
+// parseColumnDefinition reads the declared type and the column constraints
+// the engine cares about from what follows a column's name, including any
+// REFERENCES clauses and CHECK constraints.
+func parseColumnDefinition(name, rest string) (ColumnSchema, []ForeignKey, []Check) {
+	column := ColumnSchema{Name: name}
+	var foreignKeys []ForeignKey
+	var checks []Check
+	// constraintName names the constraint that follows CONSTRAINT name
+	var constraintName string
+	tokens := definitionTokens(rest)
+
+	var typeWords []string
+	i := 0
+	for ; i < len(tokens) && !isConstraintKeyword(tokens[i]); i++ {
+		// A parenthesized size such as VARCHAR(20) belongs to the type
+		if strings.HasPrefix(tokens[i], "(") && len(typeWords) > 0 {
+			typeWords[len(typeWords)-1] += tokens[i]
+			continue
+		}
+		typeWords = append(typeWords, tokens[i])
+	}
+	column.DeclaredType = strings.Join(typeWords, " ")
+	column.Affinity = affinityOf(column.DeclaredType)
+
+	for ; i < len(tokens); i++ {
+		next := func() string {
+			if i+1 < len(tokens) {
+				i++
+				return tokens[i]
+			}
+			return ""
+		}
+
+		keyword := strings.ToUpper(tokens[i])
+		switch keyword {
+		case "CONSTRAINT":
+			constraintName = unquoteIdentifier(next())
+		case "CHECK":
+			checks = append(checks, Check{Name: constraintName, Expression: checkExpression(next())})
+		case "PRIMARY":
+			column.PrimaryKey = true
+		case "UNIQUE":
+			column.Unique = true
+		case "NOT":
+			if strings.EqualFold(next(), "NULL") {
+				column.NotNull = true
+			}
+		case "DEFAULT":
+			value := next()
+			// A signed number is two tokens
+			if (value == "-" || value == "+") && i+1 < len(tokens) {
+				value += next()
+			}
+			column.Default = value
+		case "COLLATE":
+			column.Collation = strings.ToUpper(unquoteIdentifier(next()))
+		case "REFERENCES":
+			foreignKey, consumed := parseReferences(tokens[i+1:])
+			foreignKey.Columns = []string{name}
+			foreignKeys = append(foreignKeys, foreignKey)
+			i += consumed
+		}
+		if keyword != "CONSTRAINT" {
+			constraintName = ""
+		}
+	}
+
+	return column, foreignKeys, checks
+}
+
+// checkExpression returns the expression of a CHECK constraint from its
+// parenthesized group, as SQLite quotes it when the check fails.
+func checkExpression(group string) string {
+	group = strings.TrimSpace(group)
+	if strings.HasPrefix(group, "(") && strings.HasSuffix(group, ")") {
+		group = group[1 : len(group)-1]
+	}
+	return strings.TrimSpace(group)
+}
+
+// parseReferences reads the clause following REFERENCES: the parent table,
+// its optional column list, and any ON DELETE, ON UPDATE, MATCH and
+// DEFERRABLE clauses. It returns the number of tokens consumed.
+func parseReferences(tokens []string) (ForeignKey, int) {
+	foreignKey := ForeignKey{OnUpdate: "NO ACTION", OnDelete: "NO ACTION"}
+	if len(tokens) == 0 {
+		return foreignKey, 0
+	}
+	foreignKey.ParentTable = unquoteIdentifier(tokens[0])
+	i := 1
+	if i < len(tokens) && strings.HasPrefix(tokens[i], "(") {
+		foreignKey.ParentColumns = identifierList(tokens[i])
+		i++
+	}
+
+	word := func(offset int) string {
+		if i+offset < len(tokens) {
+			return strings.ToUpper(tokens[i+offset])
+		}
+		return ""
+	}
+	for i < len(tokens) {
+		switch word(0) {
+		case "ON":
+			action, width := word(2), 1
+			if action == "SET" || action == "NO" {
+				action, width = action+" "+word(3), 2
+			}
+			if word(1) == "DELETE" {
+				foreignKey.OnDelete = action
+			} else {
+				foreignKey.OnUpdate = action
+			}
+			i += 2 + width
+		case "MATCH", "INITIALLY":
+			i += 2
+		case "DEFERRABLE":
+			i++
+		case "NOT":
+			// NOT DEFERRABLE, as opposed to the NOT NULL column constraint
+			if word(1) != "DEFERRABLE" {
+				return foreignKey, i
+			}
+			i += 2
+		default:
+			return foreignKey, i
+		}
+	}
+	return foreignKey, i
+}
+
+// identifierList returns the names in a parenthesized column list such as
+// "(a, b)", without quotes or any COLLATE and sort order terms.
+func identifierList(group string) []string {
+	names, _ := indexColumns(group)
+	return names
+}
+
+func isConstraintKeyword(token string) bool {
+	switch strings.ToUpper(token) {
+	case "CONSTRAINT", "PRIMARY", "NOT", "NULL", "UNIQUE", "CHECK", "DEFAULT", "COLLATE", "REFERENCES", "GENERATED", "AS":
+		return true
+	}
+	return false
+}
+
+// definitionTokens splits a column definition into words, quoted strings and
+// identifiers, and parenthesized groups, each kept whole.
+func definitionTokens(definition string) []string {
+	var tokens []string
+	for i := 0; i < len(definition); {
+		c := definition[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			closer := c
+			if c == '[' {
+				closer = ']'
+			}
+			for i++; i < len(definition); i++ {
+				if definition[i] == closer {
+					// A doubled quote is an escaped quote, not the end
+					if closer != ']' && i+1 < len(definition) && definition[i+1] == closer {
+						i++
+						continue
+					}
+					i++
+					break
+				}
+			}
+		case c == '(':
+			depth := 0
+			for ; i < len(definition); i++ {
+				if definition[i] == '(' {
+					depth++
+				} else if definition[i] == ')' {
+					depth--
+					if depth == 0 {
+						i++
+						break
+					}
+				}
+			}
+		case c == '-' || c == '+':
+			i++
+		default:
+			for i < len(definition) && !strings.ContainsRune(" \t\n\r('\"`[", rune(definition[i])) {
+				i++
+			}
+		}
+		tokens = append(tokens, definition[start:min(i, len(definition))])
+	}
+	return tokens
+}
+
+// unquoteIdentifier strips the quotes from a quoted identifier.
+func unquoteIdentifier(name string) string {
+	if len(name) >= 2 {
+		switch name[0] {
+		case '"', '`', '\'':
+			if name[len(name)-1] == name[0] {
+				return name[1 : len(name)-1]
+			}
+		case '[':
+			if name[len(name)-1] == ']' {
+				return name[1 : len(name)-1]
+			}
+		}
+	}
+	return name
+}
+
+// indexColumns returns the column names of a CREATE INDEX statement, in key
+// order, without their sort direction or collation.
+func indexColumns(sql string) ([]string, error) {
+	terms, err := parenthesizedList(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(terms))
+	for _, term := range terms {
+		name, _ := splitIdentifier(term)
+		columns = append(columns, name)
+	}
+	return columns, nil
+}
+
+// indexCollations returns the collating sequence each term of a CREATE
+// INDEX statement names with COLLATE, or "" for a term naming none.
+func indexCollations(sql string) []string {
+	terms, _ := parenthesizedList(sql)
+	collations := make([]string, len(terms))
+	for i, term := range terms {
+		_, rest := splitIdentifier(term)
+		tokens := definitionTokens(rest)
+		for j := 0; j+1 < len(tokens); j++ {
+			if strings.EqualFold(tokens[j], "COLLATE") {
+				collations[i] = unquoteIdentifier(tokens[j+1])
+			}
+		}
+	}
+	return collations
+}
+
+// indexDirections reports which terms of a CREATE INDEX statement are
+// ordered DESC.
+func indexDirections(sql string) []bool {
+	terms, _ := parenthesizedList(sql)
+	directions := make([]bool, len(terms))
+	for i, term := range terms {
+		_, rest := splitIdentifier(term)
+		tokens := definitionTokens(rest)
+		directions[i] = len(tokens) > 0 && strings.EqualFold(tokens[len(tokens)-1], "DESC")
+	}
+	return directions
+}
+
+// keyCollations returns the collating sequence of each column of a key:
+// the one named for it, if any, or else the column's declared one.
+func (table *TableSchema) keyCollations(columns, named []string) []db.Collation {
+	collations := make([]db.Collation, len(columns))
+	for i, name := range columns {
+		if i < len(named) && named[i] != "" {
+			collations[i] = collation(named[i])
+		} else if position, ok := table.ColumnIndex(name); ok {
+			collations[i] = table.Columns[position].collation()
+		}
+	}
+	return collations
+}
+
+// collation returns the built-in collating sequence a name stands for,
+// and BINARY for any other, such as one an application registers with
+// SQLite, whose ordering cannot be known here.
+func collation(name string) db.Collation {
+	collation, _ := db.ParseCollation(name)
+	return collation
+}
+
+// collation returns the collating sequence that orders the column's text.
+func (column ColumnSchema) collation() db.Collation {
+	return collation(column.Collation)
+}
+
+// columnCollation returns the collating sequence of the column at position,
+// which is BINARY for the rowid.
+func (table *TableSchema) columnCollation(position int) db.Collation {
+	if position < 0 || position >= len(table.Columns) || position == table.RowIDAlias {
+		return db.Binary
+	}
+	return table.Columns[position].collation()
+}
+
+// parenthesizedList splits the text inside the first parenthesized group of
+// sql on its top-level commas.
+func parenthesizedList(sql string) ([]string, error) {
+	start := strings.IndexByte(sql, '(')
+	if start < 0 {
+		return nil, fmt.Errorf("missing column list in %q", sql)
+	}
+
+	var items []string
+	depth := 0
+	var quote byte
+	itemStart := start + 1
+
+	for i := start; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return append(items, strings.TrimSpace(sql[itemStart:i])), nil
+			}
+		case c == ',' && depth == 1:
+			items = append(items, strings.TrimSpace(sql[itemStart:i]))
+			itemStart = i + 1
+		}
+	}
+
+	return nil, fmt.Errorf("unterminated column list in %q", sql)
+}
+
+// withoutRowID reports whether a CREATE TABLE statement's options, after
+// its column list, include WITHOUT ROWID.
+func withoutRowID(sql string) bool {
+	tokens := scanSQL(sql)
+	depth := 0
+	for i, token := range tokens {
+		switch sql[token.start] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				for j := i + 1; j+1 < len(tokens); j++ {
+					if tokens[j].keyword("WITHOUT") && tokens[j+1].keyword("ROWID") {
+						return true
+					}
+				}
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// splitIdentifier splits a leading, possibly quoted, identifier from the
+// rest of a definition.
+func splitIdentifier(definition string) (string, string) {
+	definition = strings.TrimSpace(definition)
+	if definition == "" {
+		return "", ""
+	}
+
+	closers := map[byte]byte{'"': '"', '`': '`', '[': ']'}
+	if closer, ok := closers[definition[0]]; ok {
+		if end := strings.IndexByte(definition[1:], closer); end >= 0 {
+			return definition[1 : end+1], definition[end+2:]
+		}
+	}
+
+	end := strings.IndexFunc(definition, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '('
+	})
+	if end < 0 {
+		return definition, ""
+	}
+	return definition[:end], definition[end:]
+}
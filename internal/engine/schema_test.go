@@ -0,0 +1,246 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestParseTableSchemaColumns(t *testing.T) {
+	sql := `CREATE TABLE "order items" (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		"sku code" varchar(20) NOT NULL COLLATE nocase,
+		price numeric(10, 2) DEFAULT -1.5,
+		note TEXT DEFAULT 'it''s (none)' CHECK (length(note) < 100),
+		weight double precision,
+		payload,
+		CONSTRAINT fk FOREIGN KEY (sku) REFERENCES skus (code)
+	)`
+
+	table, err := parseTableSchema(db.TableMetadata{Type: "table", Name: "order items", RootPage: 7, SQL: sql})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ColumnSchema{
+		{Name: "id", DeclaredType: "INTEGER", Affinity: AffinityInteger, PrimaryKey: true},
+		{Name: "sku code", DeclaredType: "varchar(20)", Affinity: AffinityText, NotNull: true, Collation: "NOCASE"},
+		{Name: "price", DeclaredType: "numeric(10, 2)", Affinity: AffinityNumeric, Default: "-1.5"},
+		{Name: "note", DeclaredType: "TEXT", Affinity: AffinityText, Default: "'it''s (none)'"},
+		{Name: "weight", DeclaredType: "double precision", Affinity: AffinityReal},
+		{Name: "payload", Affinity: AffinityBlob},
+	}
+	if !reflect.DeepEqual(table.Columns, want) {
+		t.Fatalf("unexpected columns\n got %+v\nwant %+v", table.Columns, want)
+	}
+	if table.RowIDAlias != 0 || table.RootPage != 7 {
+		t.Fatalf("unexpected rowid alias %d or root page %d", table.RowIDAlias, table.RootPage)
+	}
+	if position, ok := table.ColumnIndex("SKU CODE"); !ok || position != 1 {
+		t.Fatalf("ColumnIndex(SKU CODE) = %d, %v", position, ok)
+	}
+}
+
+func TestParseTableSchemaForeignKeys(t *testing.T) {
+	sql := `CREATE TABLE child (
+		id INTEGER PRIMARY KEY,
+		parent_id integer NOT NULL REFERENCES "parent" (id) ON DELETE CASCADE ON UPDATE SET NULL,
+		code text CONSTRAINT fk_code REFERENCES codes DEFERRABLE INITIALLY DEFERRED NOT NULL,
+		a, b,
+		CONSTRAINT fk_pair FOREIGN KEY (a, "b") REFERENCES pairs (x, y) MATCH FULL ON DELETE NO ACTION ON UPDATE RESTRICT
+	)`
+
+	table, err := parseTableSchema(db.TableMetadata{Type: "table", Name: "child", SQL: sql})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ForeignKey{
+		{Columns: []string{"parent_id"}, ParentTable: "parent", ParentColumns: []string{"id"}, OnUpdate: "SET NULL", OnDelete: "CASCADE"},
+		{Columns: []string{"code"}, ParentTable: "codes", OnUpdate: "NO ACTION", OnDelete: "NO ACTION"},
+		{Columns: []string{"a", "b"}, ParentTable: "pairs", ParentColumns: []string{"x", "y"}, OnUpdate: "RESTRICT", OnDelete: "NO ACTION"},
+	}
+	if !reflect.DeepEqual(table.ForeignKeys, want) {
+		t.Fatalf("unexpected foreign keys\n got %+v\nwant %+v", table.ForeignKeys, want)
+	}
+	// Constraints after a REFERENCES clause still apply to the column
+	if !table.Columns[1].NotNull || !table.Columns[2].NotNull {
+		t.Fatalf("NOT NULL lost after REFERENCES: %+v", table.Columns)
+	}
+	if len(table.Columns) != 5 {
+		t.Fatalf("%d columns, want 5", len(table.Columns))
+	}
+}
+
+func TestParseTableSchemaRowIDAlias(t *testing.T) {
+	tests := []struct {
+		sql   string
+		alias int
+	}{
+		{"CREATE TABLE t (a text, b integer, PRIMARY KEY (b))", 1},
+		{"CREATE TABLE t (a int primary key, b text)", -1},
+		{"CREATE TABLE t (a integer, b integer, PRIMARY KEY (a, b))", -1},
+		{"CREATE TABLE t (a text, b text)", -1},
+	}
+
+	for _, tt := range tests {
+		table, err := parseTableSchema(db.TableMetadata{Type: "table", Name: "t", SQL: tt.sql})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.sql, err)
+		}
+		if table.RowIDAlias != tt.alias {
+			t.Errorf("%s: rowid alias %d, want %d", tt.sql, table.RowIDAlias, tt.alias)
+		}
+	}
+}
+
+func TestDatabaseCachesTableSchemas(t *testing.T) {
+	database := openDatabase(t, companiesDatabase(t, 10))
+
+	first, err := database.TableSchema("companies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := database.TableSchema("COMPANIES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatal("schema parsed twice for the same table")
+	}
+	if len(first.Indexes) != 1 || !reflect.DeepEqual(first.Indexes[0].Columns, []string{"country"}) {
+		t.Fatalf("unexpected indexes: %+v", first.Indexes)
+	}
+}
+
+func TestWithoutRowIDTables(t *testing.T) {
+	for sql, want := range map[string]bool{
+		"CREATE TABLE kv (k text primary key, v) WITHOUT ROWID":         true,
+		"CREATE TABLE kv (k text primary key, v) strict, without rowid": true,
+		"CREATE TABLE kv (k text primary key, \"without\" rowid)":       false,
+		"CREATE TABLE kv (k text primary key, v)":                       false,
+	} {
+		if got := withoutRowID(sql); got != want {
+			t.Errorf("withoutRowID(%q) = %v", sql, got)
+		}
+	}
+
+	generated := testgen.New(testgen.Options{})
+	generated.CreateTable("kv", "CREATE TABLE kv (k text primary key, v) WITHOUT ROWID")
+	items := generated.CreateTable("items", "CREATE TABLE items (id integer primary key, name text)")
+	items.Insert(1, nil, "one")
+	database := openDatabase(t, generated.WriteTemp(t))
+
+	for _, query := range []string{"SELECT * FROM kv", "SELECT count(*) FROM kv", "INSERT INTO kv VALUES ('a', 1)", "DELETE FROM kv"} {
+		if _, err := runQuery(database, query); !errors.Is(err, ErrWithoutRowID) || err.Error() != "WITHOUT ROWID tables are not supported: kv" {
+			t.Errorf("%s: error %v", query, err)
+		}
+	}
+	// Other tables are unaffected, even when foreign keys need every
+	// table's keys checked
+	if err := execute(t, database, "PRAGMA foreign_keys = on"); err != nil {
+		t.Fatal(err)
+	}
+	if err := execute(t, database, "DELETE FROM items WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if rows := queryRows(t, database, "SELECT count(*) FROM items"); !reflect.DeepEqual(rows, [][]any{{int64(0)}}) {
+		t.Errorf("items after the delete: %v", rows)
+	}
+}
+
+func TestSchemaSpanningSeveralPages(t *testing.T) {
+	const tables = 200
+	generated := testgen.New(testgen.Options{PageSize: 512})
+	for i := range tables {
+		table := generated.CreateTable(fmt.Sprintf("t%03d", i), fmt.Sprintf("CREATE TABLE t%03d (a integer, b text)", i))
+		table.Insert(1, int64(i), fmt.Sprintf("row of t%03d", i))
+	}
+	database := openDatabase(t, generated.WriteTemp(t))
+
+	// Page 1 is an interior page, so its cells only point at the schema rows
+	page, err := database.file.NewPage(database.header, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.PageType != db.InteriorTable {
+		t.Fatalf("schema root is page type %d, want an interior table page", page.PageType)
+	}
+
+	objects, err := database.SchemaObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != tables {
+		t.Fatalf("read %d schema objects, want %d", len(objects), tables)
+	}
+
+	rows := queryRows(t, database, "SELECT a, b FROM t199")
+	if !reflect.DeepEqual(rows, [][]any{{int64(199), "row of t199"}}) {
+		t.Fatalf("unexpected rows from the last table: %v", rows)
+	}
+	if count, err := database.RowCount("t000"); err != nil || count != 1 {
+		t.Fatalf("row count of t000: %d, %v", count, err)
+	}
+}
+
+func TestParseTableSchemaChecks(t *testing.T) {
+	sql := `CREATE TABLE t (
+		a int CHECK (a > 0) NOT NULL,
+		b text CONSTRAINT short CHECK(length(b) < 5) UNIQUE,
+		CHECK ( a <> b ),
+		CONSTRAINT "ordered" CHECK (a < 10)
+	)`
+	table, err := parseTableSchema(db.TableMetadata{Type: "table", Name: "t", SQL: sql})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Check{
+		{Expression: "a > 0"},
+		{Name: "short", Expression: "length(b) < 5"},
+		{Expression: "a <> b"},
+		{Name: "ordered", Expression: "a < 10"},
+	}
+	if !reflect.DeepEqual(table.Checks, want) {
+		t.Fatalf("unexpected checks\n got %+v\nwant %+v", table.Checks, want)
+	}
+	if !table.Columns[0].NotNull || !table.Columns[1].Unique {
+		t.Fatalf("constraints after CHECK lost: %+v", table.Columns)
+	}
+}
+
+func TestSchemaChangesFromAnotherConnection(t *testing.T) {
+	path := upsertDatabase(t)
+	reader, writer := openDatabase(t, path), openDatabase(t, path)
+
+	if rows := queryRows(t, reader, "SELECT a, b FROM t WHERE id = 1"); !reflect.DeepEqual(rows, [][]any{{"x", int64(1)}}) {
+		t.Fatalf("rows before the change = %v", rows)
+	}
+	for _, query := range []string{
+		"ALTER TABLE t RENAME COLUMN b TO renamed",
+		"ALTER TABLE t ADD COLUMN added DEFAULT 'new'",
+		"INSERT INTO t VALUES (3, 'z', 3, 1, 'three')",
+	} {
+		if err := execute(t, writer, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	// The reader re-reads the schema rather than using its cached one
+	got := queryRows(t, reader, "SELECT id, renamed, added FROM t")
+	want := [][]any{{int64(1), int64(1), "new"}, {int64(2), int64(2), "new"}, {int64(3), int64(3), "three"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows after the change\n got %v\nwant %v", got, want)
+	}
+	if err := execute(t, reader, "SELECT b FROM t"); err == nil {
+		t.Fatal("renamed column still selectable")
+	}
+	if rows := queryRows(t, reader, "PRAGMA schema_version"); rows[0][0] != int64(3) {
+		t.Fatalf("schema_version %v, want 3", rows[0][0])
+	}
+}
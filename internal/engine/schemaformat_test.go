@@ -0,0 +1,74 @@
+package engine
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestSchemaFormat(t *testing.T) {
+	// Before format 4 a DESC index is stored ascending
+	generated := testgen.New(testgen.Options{PageSize: 512, SchemaFormat: 1})
+	items := generated.CreateTable("items", "CREATE TABLE items (id integer primary key, name text, flag integer)")
+	for i := 1; i <= 300; i++ {
+		items.Insert(int64(i), nil, fmt.Sprintf("name-%03d", i), int64(i%2))
+	}
+	generated.CreateIndex("idx_items_name", items, "CREATE INDEX idx_items_name ON items (name DESC)", 1)
+	legacy := generated.WriteTemp(t)
+
+	rows, stats := runSelect(t, legacy, "SELECT id FROM items WHERE name = 'name-123'")
+	if !reflect.DeepEqual(rows, [][]any{{int64(123)}}) || stats.indexKeys == 0 {
+		t.Errorf("format 1 lookup: %v, %d index keys read", rows, stats.indexKeys)
+	}
+	database := openDatabase(t, legacy)
+	if err := execute(t, database, "INSERT INTO items (name, flag) VALUES ('name-000', 1)"); err != nil {
+		t.Fatal(err)
+	}
+	if rows := queryRows(t, database, "SELECT id, flag FROM items WHERE name = 'name-000'"); !reflect.DeepEqual(rows, [][]any{{int64(301), int64(1)}}) {
+		t.Errorf("format 1 lookup of an inserted row: %v", rows)
+	}
+
+	future := testgen.New(testgen.Options{SchemaFormat: 5})
+	future.CreateTable("items", "CREATE TABLE items (id integer primary key)")
+	if _, err := Open(future.WriteTemp(t)); err == nil || !strings.Contains(err.Error(), "unsupported schema format 5") {
+		t.Errorf("opening a format 5 database: %v", err)
+	}
+
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return
+	}
+	if lines := sqlite3Lines(t, sqlite3, legacy, "PRAGMA integrity_check"); !reflect.DeepEqual(lines, []string{"ok"}) {
+		t.Errorf("format 1 integrity_check after an insert: %v", lines)
+	}
+
+	// Format 4 stores it descending, as sqlite3 writes it
+	path := filepath.Join(t.TempDir(), "desc.db")
+	sqlite3Lines(t, sqlite3, path, strings.Join([]string{
+		"PRAGMA page_size = 512",
+		"CREATE TABLE items (id integer primary key, name text COLLATE nocase)",
+		"CREATE INDEX idx_items_name ON items (name DESC)",
+		"WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 300) INSERT INTO items SELECT i, printf('name-%03d', i) FROM n",
+	}, "; "))
+	rows, stats = runSelect(t, path, "SELECT id FROM items WHERE name = 'NAME-123'")
+	if !reflect.DeepEqual(rows, [][]any{{int64(123)}}) || stats.indexKeys == 0 {
+		t.Errorf("format 4 DESC lookup: %v, %d index keys read", rows, stats.indexKeys)
+	}
+	database = openDatabase(t, path)
+	for _, name := range []string{"name-000", "name-150b", "name-999"} {
+		if err := execute(t, database, "INSERT INTO items (name) VALUES ('"+name+"')"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lines := sqlite3Lines(t, sqlite3, path, "PRAGMA integrity_check"); !reflect.DeepEqual(lines, []string{"ok"}) {
+		t.Errorf("format 4 integrity_check after inserts: %v", lines)
+	}
+	if lines := sqlite3Lines(t, sqlite3, path, "SELECT name FROM items INDEXED BY idx_items_name WHERE name > 'name-298' ORDER BY name DESC"); !reflect.DeepEqual(lines, []string{"name-999", "name-300", "name-299"}) {
+		t.Errorf("sqlite3 reading the index after inserts: %v", lines)
+	}
+}
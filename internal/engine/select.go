@@ -0,0 +1,1463 @@
+package engine
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/xwb1989/sqlparser"
+)
+
+// selectQuery is the subset of SELECT this engine executes: a projection,
+// or COUNT(*) and MIN or MAX of columns, over one table, filtered by ANDed
+// column = literal terms, each of which may be an IN list or ORed
+// equalities on one column, and by EXISTS subqueries, and optionally
+// ordered by rowid.
+type selectQuery struct {
+	// schema is the schema the table was qualified with, main or temp, or
+	// "" when it was not
+	schema, table string
+	// alias is the name the table was given with AS, or ""
+	alias   string
+	star    bool
+	columns []string
+	// scalars holds the subquery selected in place of each column, keyed
+	// by its place in columns
+	scalars map[int]*subquery
+	// windows holds the window function selected in place of each column,
+	// keyed by its place in columns
+	windows map[int]*windowCall
+	count   bool
+	// extreme is "min" or "max" when the query selects that of extremeOf
+	extreme   string
+	extremeOf string
+	// aggregates holds the COUNT(*), MIN and MAX calls of a select list
+	// of two or more of them and nothing else, which one scan answers
+	aggregates []aggregateCall
+	// orderBy is the column the query orders by, which must name the
+	// rowid, or "" for no ORDER BY
+	orderBy    string
+	descending bool
+	filters    []equalityFilter
+	// exists are the EXISTS and NOT EXISTS terms of the WHERE clause
+	exists []existsTerm
+	// limit is the maximum number of result rows, or -1 for no limit
+	limit int64
+}
+
+// aggregateCall is one aggregate of a select list of several: COUNT(*),
+// whose column is "", or MIN or MAX of a column.
+type aggregateCall struct {
+	function, column string
+	// position is the column's, resolved against the table schema when
+	// the query is prepared, or -1 for a rowid name no column takes
+	position int
+}
+
+// equalityFilter matches rows whose column equals any of its values: one
+// for column = literal, and several for an IN list or ORed equalities.
+type equalityFilter struct {
+	column string
+	// qualifier is the table or alias the column was written with, as in
+	// t.a, or "" when it was not qualified
+	qualifier string
+	values    []any
+	// outer is set for a filter comparing two columns, which a subquery
+	// may use to refer to its outer query's row. Which of the two is the
+	// subquery's is settled when it is prepared, and values are filled in
+	// from the outer row each time it runs.
+	outer *qualifiedColumn
+	// position is the column's record position, and collation the
+	// collating sequence comparing its text with values, resolved against
+	// the table schema when the query is prepared
+	position  int
+	collation db.Collation
+	// collated is set when a COLLATE in the comparison names the
+	// collation, which then overrides the column's
+	collated bool
+}
+
+func parseSelect(query string) (*selectQuery, error) {
+	original := query
+	query, windows, err := extractWindows(query)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := parseSQL(query)
+	var syntaxError *SyntaxError
+	if errors.As(err, &syntaxError) && windows != nil {
+		syntaxError.SQL, syntaxError.Offset = original, windowOffset(query, windows, syntaxError.Offset)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse query: %w", err)
+	}
+	if err := resolveSchemas(stmt); err != nil {
+		return nil, err
+	}
+
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		return nil, fmt.Errorf("unsupported query type: %T", stmt)
+	}
+	parsed, err := parseSelectStatement(sel, query)
+	if err != nil || windows == nil {
+		return parsed, err
+	}
+	return parsed, parsed.placeWindows(sel, windows)
+}
+
+// parseSelectStatement reads a parsed SELECT, which query is the text of,
+// for error messages.
+func parseSelectStatement(sel *sqlparser.Select, query string) (*selectQuery, error) {
+	if len(sel.OrderBy) > 1 || len(sel.GroupBy) > 0 || sel.Having != nil || sel.Distinct != "" {
+		return nil, fmt.Errorf("unsupported select clause in %q", query)
+	}
+
+	parsed := &selectQuery{limit: -1}
+	table, alias, err := selectTable(sel)
+	if err != nil {
+		return nil, err
+	}
+	parsed.schema, parsed.table, parsed.alias = table.Qualifier.String(), table.Name.String(), alias
+
+	if len(sel.OrderBy) == 1 {
+		column, ok := sel.OrderBy[0].Expr.(*sqlparser.ColName)
+		if !ok {
+			return nil, fmt.Errorf("unsupported ORDER BY %s", sqlparser.String(sel.OrderBy[0].Expr))
+		}
+		parsed.orderBy = column.Name.String()
+		parsed.descending = sel.OrderBy[0].Direction == sqlparser.DescScr
+	}
+
+	var aggregates []aggregateCall
+	for _, expr := range sel.SelectExprs {
+		switch expr := expr.(type) {
+		case *sqlparser.StarExpr:
+			parsed.star = true
+		case *sqlparser.AliasedExpr:
+			switch inner := expr.Expr.(type) {
+			case *sqlparser.ColName:
+				parsed.columns = append(parsed.columns, inner.Name.String())
+			case *sqlparser.Subquery:
+				scalar, err := parseSubquery(inner, false)
+				if err != nil {
+					return nil, err
+				}
+				if parsed.scalars == nil {
+					parsed.scalars = make(map[int]*subquery)
+				}
+				parsed.scalars[len(parsed.columns)] = scalar
+				name := sqlparser.String(inner)
+				if !expr.As.IsEmpty() {
+					name = expr.As.String()
+				}
+				parsed.columns = append(parsed.columns, name)
+			case *sqlparser.FuncExpr:
+				if column, ok := extremeColumn(inner); ok {
+					aggregates = append(aggregates, aggregateCall{function: inner.Name.Lowered(), column: column})
+					continue
+				}
+				if !isCountStar(inner) {
+					return nil, fmt.Errorf("unsupported function: %s", sqlparser.String(inner))
+				}
+				aggregates = append(aggregates, aggregateCall{function: "count"})
+			default:
+				return nil, fmt.Errorf("unsupported select expression: %s", sqlparser.String(expr))
+			}
+		default:
+			return nil, fmt.Errorf("unsupported select expression: %s", sqlparser.String(expr))
+		}
+	}
+	if parsed.star && len(parsed.scalars) > 0 {
+		return nil, fmt.Errorf("subqueries cannot be combined with *")
+	}
+	switch {
+	case len(aggregates) == 0:
+	case len(aggregates) < len(sel.SelectExprs) && aggregates[0].function == "count":
+		return nil, fmt.Errorf("COUNT(*) cannot be combined with other columns")
+	case len(aggregates) < len(sel.SelectExprs):
+		return nil, fmt.Errorf("%s() cannot be combined with other columns", strings.ToUpper(aggregates[0].function))
+	case len(aggregates) > 1:
+		parsed.aggregates = aggregates
+	case aggregates[0].function == "count":
+		parsed.count = true
+	default:
+		parsed.extreme, parsed.extremeOf = aggregates[0].function, aggregates[0].column
+	}
+
+	if sel.Where != nil {
+		for _, term := range conjuncts(sel.Where.Expr) {
+			if exists, ok, err := parseExists(term); ok {
+				if err != nil {
+					return nil, err
+				}
+				parsed.exists = append(parsed.exists, exists)
+				continue
+			}
+			filters, err := parseFilters(term)
+			if err != nil {
+				return nil, err
+			}
+			parsed.filters = append(parsed.filters, filters...)
+		}
+	}
+
+	if sel.Limit != nil {
+		if sel.Limit.Offset != nil {
+			return nil, fmt.Errorf("LIMIT offsets are not supported")
+		}
+		if parsed.limit, err = parseLimit(sel.Limit.Rowcount); err != nil {
+			return nil, err
+		}
+	}
+
+	return parsed, nil
+}
+
+// extremeColumn returns the column of a single-argument MIN or MAX call.
+func extremeColumn(fn *sqlparser.FuncExpr) (string, bool) {
+	if (!fn.Name.EqualString("min") && !fn.Name.EqualString("max")) || len(fn.Exprs) != 1 || fn.Distinct {
+		return "", false
+	}
+	expr, ok := fn.Exprs[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return "", false
+	}
+	column, ok := expr.Expr.(*sqlparser.ColName)
+	if !ok {
+		return "", false
+	}
+	return column.Name.String(), true
+}
+
+func isCountStar(fn *sqlparser.FuncExpr) bool {
+	if !fn.Name.EqualString("count") || len(fn.Exprs) != 1 {
+		return false
+	}
+	_, ok := fn.Exprs[0].(*sqlparser.StarExpr)
+	return ok
+}
+
+func parseFilters(expr sqlparser.Expr) ([]equalityFilter, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		left, err := parseFilters(expr.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := parseFilters(expr.Right)
+		if err != nil {
+			return nil, err
+		}
+		return append(left, right...), nil
+	case *sqlparser.ParenExpr:
+		return parseFilters(expr.Expr)
+	case *sqlparser.ComparisonExpr:
+		if expr.Operator == sqlparser.InStr {
+			return parseInList(expr)
+		}
+		if expr.Operator != sqlparser.EqualStr {
+			return nil, fmt.Errorf("unsupported operator: %s", expr.Operator)
+		}
+
+		// A COLLATE on either side decides how the two compare
+		var filter equalityFilter
+		column, literal := expr.Left, expr.Right
+		for _, side := range []*sqlparser.Expr{&column, &literal} {
+			collate, ok := (*side).(*sqlparser.CollateExpr)
+			if !ok {
+				continue
+			}
+			if !filter.collated {
+				if filter.collation, ok = db.ParseCollation(collate.Charset); !ok {
+					return nil, fmt.Errorf("no such collation sequence: %s", collate.Charset)
+				}
+				filter.collated = true
+			}
+			*side = collate.Expr
+		}
+		if _, ok := column.(*sqlparser.ColName); !ok {
+			column, literal = literal, column
+		}
+		colName, ok := column.(*sqlparser.ColName)
+		if !ok {
+			return nil, fmt.Errorf("unsupported comparison: %s", sqlparser.String(expr))
+		}
+		filter.column, filter.qualifier = colName.Name.String(), colName.Qualifier.Name.String()
+		if other, ok := literal.(*sqlparser.ColName); ok {
+			filter.outer = &qualifiedColumn{qualifier: other.Qualifier.Name.String(), name: other.Name.String()}
+			return []equalityFilter{filter}, nil
+		}
+		value, err := literalValue(literal)
+		if err != nil {
+			return nil, err
+		}
+		filter.values = []any{value}
+		return []equalityFilter{filter}, nil
+	case *sqlparser.OrExpr:
+		// Only ORs of equalities on one column, which are lists of values
+		left, err := parseFilters(expr.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := parseFilters(expr.Right)
+		if err != nil {
+			return nil, err
+		}
+		if len(left) != 1 || len(right) != 1 || !strings.EqualFold(left[0].column, right[0].column) || !strings.EqualFold(left[0].qualifier, right[0].qualifier) || left[0].outer != nil || right[0].outer != nil || left[0].collated != right[0].collated || left[0].collation != right[0].collation {
+			return nil, fmt.Errorf("unsupported WHERE clause: %s", sqlparser.String(expr))
+		}
+		left[0].values = append(left[0].values, right[0].values...)
+		return left, nil
+	}
+
+	return nil, fmt.Errorf("unsupported WHERE clause: %s", sqlparser.String(expr))
+}
+
+// parseInList parses column IN (literal, ...) as a filter matching any of
+// the literals.
+func parseInList(expr *sqlparser.ComparisonExpr) ([]equalityFilter, error) {
+	colName, ok := expr.Left.(*sqlparser.ColName)
+	list, isTuple := expr.Right.(sqlparser.ValTuple)
+	if !ok || !isTuple {
+		return nil, fmt.Errorf("unsupported comparison: %s", sqlparser.String(expr))
+	}
+
+	filter := equalityFilter{column: colName.Name.String(), qualifier: colName.Qualifier.Name.String()}
+	for _, item := range list {
+		value, err := literalValue(item)
+		if err != nil {
+			return nil, err
+		}
+		filter.values = append(filter.values, value)
+	}
+	return []equalityFilter{filter}, nil
+}
+
+// matches reports whether a column value equals any of the filter's values.
+func (filter equalityFilter) matches(value any) bool {
+	for _, literal := range filter.values {
+		if valuesEqual(value, literal, filter.collation) {
+			return true
+		}
+	}
+	return false
+}
+
+func literalValue(expr sqlparser.Expr) (any, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.NullVal:
+		return nil, nil
+	case *sqlparser.SQLVal:
+		switch expr.Type {
+		case sqlparser.StrVal:
+			return string(expr.Val), nil
+		case sqlparser.IntVal:
+			integer, err := strconv.ParseInt(string(expr.Val), 10, 64)
+			if errors.Is(err, strconv.ErrRange) {
+				// Integers too large for 64 bits are reals, as in SQLite
+				return realLiteral(expr.Val)
+			}
+			return integer, err
+		case sqlparser.FloatVal:
+			return realLiteral(expr.Val)
+		case sqlparser.HexNum:
+			// Up to 16 hexadecimal digits, read as a 64-bit two's complement
+			// integer, so 0xFFFFFFFFFFFFFFFF is -1
+			integer, err := strconv.ParseUint(string(expr.Val[2:]), 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("hex literal too big: %s", expr.Val)
+			}
+			return int64(integer), nil
+		case sqlparser.HexVal:
+			blob, err := expr.HexDecode()
+			if err != nil {
+				return nil, fmt.Errorf("malformed blob literal: %s", sqlparser.String(expr))
+			}
+			return blob, nil
+		}
+	case *sqlparser.ParenExpr:
+		return literalValue(expr.Expr)
+	case *sqlparser.UnaryExpr:
+		// The parser folds a minus sign into an integer, but not into a
+		// real or hexadecimal integer
+		if expr.Operator != sqlparser.UMinusStr && expr.Operator != sqlparser.UPlusStr {
+			break
+		}
+		value, err := literalValue(expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		switch value.(type) {
+		case int64, float64:
+			if expr.Operator == sqlparser.UMinusStr {
+				return negate(value), nil
+			}
+			return value, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported literal: %s", sqlparser.String(expr))
+}
+
+// realLiteral reads a real, which is infinite when too large for a
+// float64, as in SQLite.
+func realLiteral(text []byte) (any, error) {
+	real, err := strconv.ParseFloat(string(text), 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return real, nil
+	}
+	return real, err
+}
+
+func parseLimit(expr sqlparser.Expr) (int64, error) {
+	value, err := literalValue(expr)
+	if err != nil {
+		return 0, err
+	}
+	limit, ok := value.(int64)
+	if !ok {
+		return 0, fmt.Errorf("LIMIT must be an integer")
+	}
+	// Negative limits mean no limit, as in SQLite
+	if limit < 0 {
+		return -1, nil
+	}
+	return limit, nil
+}
+
+// valuesEqual compares a decoded column value against a literal, text by
+// the column's collation. NULL equals nothing, and values of different
+// storage classes never compare equal.
+func valuesEqual(columnValue, literal any, collation db.Collation) bool {
+	return columnValue != nil && literal != nil && db.CompareValues(columnValue, literal, collation) == 0
+}
+
+// plan describes how a query finds its rows: a full table scan, a rowid
+// seek, or an index scan over the entries matching one equality filter and
+// perhaps a second.
+type plan struct {
+	index *IndexSchema
+	// seekRowID answers lookup, a filter on the rowid alias, by descending
+	// the table to the row for each of its values
+	seekRowID bool
+	// lookup is the filter answered by the index or rowid seek
+	lookup equalityFilter
+	// intersect is a second index, on the column intersectLookup tests,
+	// whose matches are intersected with index's before any row is fetched
+	intersect       *IndexSchema
+	intersectLookup equalityFilter
+	// residual filters must still be checked on each fetched row
+	residual []equalityFilter
+	// indexLimit stops the index scan after this many keys, or -1
+	indexLimit int64
+	// descending visits rows from the largest rowid down
+	descending bool
+}
+
+// planSelect picks the index whose leading column a filter tests and which
+// is expected to match the fewest rows, preferring earlier filters and
+// indexes on a tie.
+func planSelect(query *selectQuery, table *TableSchema) plan {
+	chosen := plan{residual: query.filters, indexLimit: -1}
+
+	// Each integer rowid matches at most one row, which no index beats
+	for i, filter := range query.filters {
+		if integerValues(filter.values) && refersToRowID(table, filter.column) {
+			chosen = plan{seekRowID: true, lookup: filter, indexLimit: -1}
+			chosen.residual = append(append([]equalityFilter(nil), query.filters[:i]...), query.filters[i+1:]...)
+			break
+		}
+	}
+
+	best := int64(-1)
+	for i, filter := range query.filters {
+		for j := 0; j < len(table.Indexes) && !chosen.seekRowID; j++ {
+			index := &table.Indexes[j]
+			if !index.answers(filter) {
+				continue
+			}
+			// Each value of an IN list or OR is a probe of its own
+			if estimate := estimatedMatches(index) * int64(len(filter.values)); best < 0 || estimate < best {
+				best = estimate
+				chosen = plan{index: index, lookup: filter, indexLimit: -1}
+				chosen.residual = append(append([]equalityFilter(nil), query.filters[:i]...), query.filters[i+1:]...)
+			}
+		}
+	}
+	// When the chosen index may match several rows, the matches of an index
+	// on another filter's column can rule some out with no row fetched
+	if chosen.index != nil && best > 1 {
+		chosen.intersectWith(table)
+	}
+	// Without anything left to filter, every index match of a single probe
+	// is a result row, so the scan can stop once LIMIT is reached
+	if chosen.index != nil && chosen.intersect == nil && len(chosen.residual) == 0 && len(query.exists) == 0 && len(query.windows) == 0 && len(chosen.lookup.values) == 1 && !query.count {
+		chosen.indexLimit = query.limit
+	}
+	// MAX(rowid) is the first row found scanning backward. The index only
+	// finds the last keys once it has read them all, so a descending index
+	// scan is never cut short.
+	chosen.descending = query.descending || query.extreme == "max"
+	if chosen.descending {
+		chosen.indexLimit = -1
+	}
+
+	return chosen
+}
+
+// intersectWith moves the residual filter answered by the most selective
+// index other than the chosen one into the intersection, when there is one.
+func (chosen *plan) intersectWith(table *TableSchema) {
+	best, found := int64(-1), -1
+	for i, filter := range chosen.residual {
+		if strings.EqualFold(filter.column, chosen.lookup.column) {
+			continue
+		}
+		for j := range table.Indexes {
+			index := &table.Indexes[j]
+			if index == chosen.index || !index.answers(filter) {
+				continue
+			}
+			if estimate := estimatedMatches(index) * int64(len(filter.values)); best < 0 || estimate < best {
+				best, found = estimate, i
+				chosen.intersect = index
+			}
+		}
+	}
+	if found >= 0 {
+		chosen.intersectLookup = chosen.residual[found]
+		chosen.residual = append(append([]equalityFilter(nil), chosen.residual[:found]...), chosen.residual[found+1:]...)
+	}
+}
+
+// answers reports whether the index can find the rows an equality filter
+// matches: its leading column must be the filter's, with text compared the
+// way the filter compares it, in either direction.
+func (index *IndexSchema) answers(filter equalityFilter) bool {
+	return len(index.Columns) > 0 && strings.EqualFold(index.Columns[0], filter.column) && index.Collations[0].Ascending() == filter.collation
+}
+
+// integerValues reports whether values are all integers, as rowids are.
+func integerValues(values []any) bool {
+	for _, value := range values {
+		if _, ok := value.(int64); !ok {
+			return false
+		}
+	}
+	return len(values) > 0
+}
+
+// estimatedMatches is how many rows an index is expected to hold for one
+// value of its leading column: the figure ANALYZE recorded, or else one
+// for a unique single-column index and ten otherwise, as SQLite assumes.
+func estimatedMatches(index *IndexSchema) int64 {
+	switch {
+	case len(index.Stats) > 1:
+		return index.Stats[1]
+	case index.Unique && len(index.Columns) == 1:
+		return 1
+	}
+	return 10
+}
+
+// scanStats counts the work a query does, for tests and diagnostics.
+type scanStats struct {
+	indexKeys   int
+	rowsFetched int
+	// rowsRejected counts rows abandoned partway through decoding
+	rowsRejected int
+	// permissive skips the rows and index entries whose cells cannot be
+	// decoded, keeping the corruption found in skipped and logging it
+	permissive bool
+	skipped    []*db.CorruptionError
+	logger     *slog.Logger
+}
+
+// salvage reports whether a permissive query can carry on past err, which
+// it can when err is the corruption of a single cell: the cell is skipped,
+// and a warning logged with its page and cell.
+func (stats *scanStats) salvage(err error) bool {
+	var corruption *db.CorruptionError
+	if !stats.permissive || !errors.As(err, &corruption) || corruption.Cell < 0 {
+		return false
+	}
+	stats.skipped = append(stats.skipped, corruption)
+	if stats.logger != nil {
+		stats.logger.Warn("skipped unreadable cell", "page", corruption.Page, "cell", corruption.Cell, "reason", corruption.Reason)
+	}
+	return true
+}
+
+// Query runs a SELECT and returns its result set, which the caller must
+// close before closing the database. Other statements, such as INSERT or
+// SAVEPOINT, are executed immediately and return an empty result set.
+//
+// The statement holds a shared lock on the file until its result set is
+// closed, so no other process commits while it reads. A statement outside
+// a transaction that finds the database locked is retried from the start,
+// waiting between attempts as the busy timeout allows.
+func (database *Database) Query(query string) (*ResultSet, error) {
+	for retry := 0; ; retry++ {
+		resultSet, err := database.lockedQuery(query)
+		if !errors.Is(err, db.ErrBusy) || database.transaction != nil || !database.file.BusyWait(retry) {
+			return resultSet, err
+		}
+		database.logger.Debug("database locked, retrying", "retry", retry+1)
+	}
+}
+
+// lockedQuery runs a statement under a shared lock, which closing its
+// result set releases.
+func (database *Database) lockedQuery(query string) (*ResultSet, error) {
+	if err := database.file.LockShared(); err != nil {
+		return nil, err
+	}
+	resultSet, err := database.query(query)
+	if err != nil {
+		database.file.UnlockShared()
+		return nil, err
+	}
+	resultSet.limits = database.limits
+	resultSet.counters = &database.counters
+	closer := resultSet.close
+	resultSet.close = func() error {
+		database.file.UnlockShared()
+		if closer != nil {
+			return closer()
+		}
+		return nil
+	}
+	return resultSet, nil
+}
+
+func (database *Database) query(query string) (*ResultSet, error) {
+	if database.readOnlySQL {
+		if err := checkReadOnlySQL(query); err != nil {
+			return nil, err
+		}
+	}
+	if err := database.verifySchema(); err != nil {
+		return nil, err
+	}
+	key := planKey(query)
+	if run, ok := database.cachedPlan(key); ok {
+		return run(), nil
+	}
+	if statement, ok, err := parsePragma(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		return database.pragma(statement)
+	}
+	if statement, ok, err := parseTransaction(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		if err := database.executeTransaction(statement); err != nil {
+			return nil, err
+		}
+		return newResultSet(nil, func(yield func([]any, error) bool) {}, nil), nil
+	}
+	if statement, ok, err := parseAlter(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		if err := database.alter(statement); err != nil {
+			return nil, err
+		}
+		return newResultSet(nil, func(yield func([]any, error) bool) {}, nil), nil
+	}
+	if statement, ok, err := parseAnalyze(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		if err := database.analyze(statement); err != nil {
+			return nil, err
+		}
+		return newResultSet(nil, func(yield func([]any, error) bool) {}, nil), nil
+	}
+	if statement, ok, err := parseCreateTable(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		if err := database.createTable(statement); err != nil {
+			return nil, err
+		}
+		return newResultSet(nil, func(yield func([]any, error) bool) {}, nil), nil
+	}
+	if statement, ok, err := parseInsert(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		if err := database.insert(statement); err != nil {
+			return nil, err
+		}
+		return newResultSet(nil, func(yield func([]any, error) bool) {}, nil), nil
+	}
+	if statement, ok, err := parseDelete(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		if err := database.delete(statement); err != nil {
+			return nil, err
+		}
+		return newResultSet(nil, func(yield func([]any, error) bool) {}, nil), nil
+	}
+	if statement, ok, err := parseUpdate(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		if err := database.update(statement); err != nil {
+			return nil, err
+		}
+		return newResultSet(nil, func(yield func([]any, error) bool) {}, nil), nil
+	}
+
+	if statement, ok, err := parseValues(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		return selectConstants(statement, database.scope(noColumns))
+	}
+	if statement, ok, err := database.parseConstantSelect(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		return selectConstants(statement, database.scope(noColumns))
+	}
+	if statement, ok, err := parseJoin(query); ok {
+		if err != nil {
+			return nil, err
+		}
+		run, err := database.compileJoin(statement)
+		if err != nil {
+			return nil, err
+		}
+		database.cachePlan(key, run)
+		return run(), nil
+	}
+
+	parsed, err := parseSelect(query)
+	if err != nil {
+		return nil, err
+	}
+	run, err := database.compileSelect(parsed)
+	if err != nil {
+		return nil, err
+	}
+	// A window call's column is named by its text as written, which
+	// statements sharing a plan key may space differently
+	if len(parsed.windows) == 0 {
+		database.cachePlan(key, run)
+	}
+	return run(), nil
+}
+
+func (database *Database) prepareSelect(parsed *selectQuery) (*ResultSet, error) {
+	run, err := database.compileSelect(parsed)
+	if err != nil {
+		return nil, err
+	}
+	return run(), nil
+}
+
+// compileSelect resolves a SELECT against its table's schema and plans it,
+// returning what runs it.
+func (database *Database) compileSelect(parsed *selectQuery) (compiledQuery, error) {
+	owner, table, err := database.lookupTable(parsed.schema, parsed.table)
+	if err != nil {
+		return nil, err
+	}
+	dbFile, header := owner.file, owner.header
+
+	positions, err := projection(parsed, table)
+	if err != nil {
+		return nil, err
+	}
+	for i, filter := range parsed.filters {
+		if filter.outer != nil {
+			return nil, fmt.Errorf("unsupported comparison: %s = %s", columnText(qualifiedColumn{qualifier: filter.qualifier, name: filter.column}), columnText(*filter.outer))
+		}
+		// A qualifier must name the table, by its alias if it has one, as
+		// an alias hides the table's own name
+		if name := cmp.Or(parsed.alias, table.Name); filter.qualifier != "" && !strings.EqualFold(filter.qualifier, name) {
+			return nil, fmt.Errorf("%w: %s.%s", ErrNoSuchColumn, filter.qualifier, filter.column)
+		}
+		position, ok := table.ColumnIndex(filter.column)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNoSuchColumn, filter.column)
+		}
+		parsed.filters[i].position = position
+		if !filter.collated {
+			parsed.filters[i].collation = table.Columns[position].collation()
+		}
+		// Literals take the column's affinity for the comparison, as in
+		// SQLite, so that '10' matches an integer column's 10
+		values := make([]any, len(filter.values))
+		for j, value := range filter.values {
+			_, values[j] = comparisonAffinity(operand{affinity: table.Columns[position].Affinity}, operand{value: value})
+		}
+		parsed.filters[i].values = values
+	}
+	if parsed.orderBy != "" && !refersToRowID(table, parsed.orderBy) {
+		return nil, fmt.Errorf("ORDER BY is only supported on the rowid, not %s", parsed.orderBy)
+	}
+	if _, ok := table.ColumnIndex(parsed.extremeOf); parsed.extreme != "" && !ok && !isRowIDName(parsed.extremeOf) {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchColumn, parsed.extremeOf)
+	}
+	for i, call := range parsed.aggregates {
+		parsed.aggregates[i].position = -1
+		if call.column == "" {
+			continue
+		}
+		position, ok := table.ColumnIndex(call.column)
+		if !ok && !isRowIDName(call.column) {
+			return nil, fmt.Errorf("%w: %s", ErrNoSuchColumn, call.column)
+		}
+		if ok {
+			parsed.aggregates[i].position = position
+		}
+	}
+	for _, term := range parsed.exists {
+		if err := term.subquery.prepare(database, table, parsed.alias); err != nil {
+			return nil, err
+		}
+	}
+	for _, scalar := range parsed.scalars {
+		if err := scalar.prepare(database, table, parsed.alias); err != nil {
+			return nil, err
+		}
+	}
+	for _, call := range parsed.windows {
+		if err := call.prepare(table); err != nil {
+			return nil, err
+		}
+	}
+
+	var columns []ResultColumn
+	switch {
+	case parsed.count:
+		columns = []ResultColumn{{Name: "count(*)"}}
+	case parsed.extreme != "":
+		columns = []ResultColumn{{Name: parsed.extreme + "(" + parsed.extremeOf + ")"}}
+	case len(parsed.aggregates) > 0:
+		for _, call := range parsed.aggregates {
+			name := "count(*)"
+			if call.column != "" {
+				name = call.function + "(" + call.column + ")"
+			}
+			columns = append(columns, ResultColumn{Name: name})
+		}
+	default:
+		for i, position := range positions {
+			if position < 0 {
+				columns = append(columns, ResultColumn{Name: parsed.columns[i]})
+				continue
+			}
+			name := table.Columns[position].Name
+			if !parsed.star {
+				name = parsed.columns[i]
+			}
+			columns = append(columns, ResultColumn{Name: name, DeclaredType: table.Columns[position].DeclaredType, OriginTable: table.Name, OriginColumn: table.Columns[position].Name})
+		}
+	}
+
+	policy, err := database.policyFor(table)
+	if err != nil {
+		return nil, err
+	}
+	// Filters on masked columns compare the masked values, which only
+	// reading every row can tell apart
+	planned := parsed
+	if policy.masksAny(table, parsed.filters) {
+		unfiltered := *parsed
+		unfiltered.filters = nil
+		planned = &unfiltered
+	}
+	queryPlan := planSelect(planned, table)
+	return func() *ResultSet {
+		// Subqueries' results are remembered for one run only, since the
+		// data they read may change before the next
+		for _, term := range parsed.exists {
+			term.subquery.results = make(map[string][]any)
+		}
+		for _, scalar := range parsed.scalars {
+			scalar.results = make(map[string][]any)
+		}
+		switch {
+		case queryPlan.seekRowID:
+			database.logger.Debug("plan", "table", table.Name, "rowids", len(queryPlan.lookup.values))
+		case queryPlan.index != nil:
+			if queryPlan.intersect != nil {
+				database.logger.Debug("plan", "table", table.Name, "index", queryPlan.index.Name, "intersect", queryPlan.intersect.Name, "descending", queryPlan.descending)
+				break
+			}
+			database.logger.Debug("plan", "table", table.Name, "index", queryPlan.index.Name, "descending", queryPlan.descending)
+		default:
+			database.logger.Debug("plan", "table", table.Name, "scan", "full", "descending", queryPlan.descending)
+		}
+		var resultSet *ResultSet
+		rows := func(yield func([]any, error) bool) {
+			if parsed.limit == 0 {
+				return
+			}
+
+			// Plain COUNT(*) never needs to decode a record
+			if parsed.count && len(parsed.filters) == 0 && len(parsed.exists) == 0 && policy == nil {
+				count, err := dbFile.CountRows(header, table.RootPage)
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				yield([]any{count}, nil)
+				return
+			}
+
+			scan := func(emit func(*db.Row) bool) error {
+				if queryPlan.seekRowID {
+					return rowIDSeek(dbFile, header, table, queryPlan, &resultSet.stats, emit)
+				}
+				if queryPlan.index != nil {
+					return indexScan(dbFile, header, table, queryPlan, &resultSet.stats, emit)
+				}
+				return tableScan(dbFile, header, table, queryPlan.residual, queryPlan.descending, &resultSet.stats, emit)
+			}
+			if policy != nil {
+				scanRows := scan
+				scan = func(emit func(*db.Row) bool) error {
+					return scanRows(func(row *db.Row) bool {
+						row = policy.apply(table, row)
+						return row == nil || !matches(row, table, parsed.filters) || emit(row)
+					})
+				}
+			}
+			if len(parsed.exists) > 0 {
+				scanRows := scan
+				scan = func(emit func(*db.Row) bool) error {
+					var failed error
+					err := scanRows(func(row *db.Row) bool {
+						hold, err := database.existsHold(parsed.exists, row, table, &resultSet.stats)
+						if err != nil {
+							failed = err
+							return false
+						}
+						return !hold || emit(row)
+					})
+					if failed != nil {
+						return failed
+					}
+					return err
+				}
+			}
+
+			if parsed.extreme != "" {
+				extreme, err := findExtreme(dbFile, header, table, parsed, policy == nil, &resultSet.stats, scan)
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				yield([]any{extreme}, nil)
+				return
+			}
+			if len(parsed.aggregates) > 0 {
+				values, err := aggregateScan(table, parsed.aggregates, scan)
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				yield(values, nil)
+				return
+			}
+
+			output := func(row *db.Row) ([]any, error) {
+				values := project(row, table, positions)
+				for i, scalar := range parsed.scalars {
+					result, err := scalar.run(database, row, table, &resultSet.stats)
+					if err != nil {
+						return nil, err
+					}
+					if result != nil {
+						values[i] = result[0]
+					}
+				}
+				return values, nil
+			}
+
+			if len(parsed.windows) > 0 {
+				windowed, err := windowScan(parsed, table, scan, output)
+				if err != nil {
+					yield(nil, err)
+					return
+				}
+				for i, values := range windowed {
+					if parsed.limit >= 0 && int64(i) >= parsed.limit || !yield(values, nil) {
+						return
+					}
+				}
+				return
+			}
+
+			var count, emitted int64
+			stopped := false
+			var failed error
+			emit := func(row *db.Row) bool {
+				if parsed.count {
+					count++
+					return true
+				}
+				values, err := output(row)
+				if err != nil {
+					failed = err
+					return false
+				}
+				emitted++
+				if !yield(values, nil) {
+					stopped = true
+					return false
+				}
+				return parsed.limit < 0 || emitted < parsed.limit
+			}
+
+			err := scan(emit)
+			switch {
+			case stopped:
+			case failed != nil:
+				yield(nil, failed)
+			case err != nil:
+				yield(nil, err)
+			case parsed.count:
+				yield([]any{count}, nil)
+			}
+		}
+
+		resultSet = newResultSet(columns, rows, nil)
+		resultSet.stats.permissive, resultSet.stats.logger = database.permissive, database.logger
+		return resultSet
+	}, nil
+}
+
+// findExtreme answers MIN or MAX. The rowid's are the first row scanned in
+// either direction, and an unfiltered indexed column's are at either end of
+// the index, past the NULLs that sort first; any other column takes a scan
+// of every matching row. Unless stored is set, which it is when the table
+// has no row filter or masks, only scan sees the rows as queries may.
+func findExtreme(dbFile *db.DatabaseFile, header *db.DatabaseHeader, table *TableSchema, query *selectQuery, stored bool, stats *scanStats, scan func(func(*db.Row) bool) error) (any, error) {
+	if refersToRowID(table, query.extremeOf) {
+		var extreme any
+		err := scan(func(row *db.Row) bool {
+			extreme = row.RowID
+			return false
+		})
+		return extreme, err
+	}
+
+	position, _ := table.ColumnIndex(query.extremeOf)
+	collation := table.Columns[position].collation()
+	for i := range table.Indexes {
+		index := &table.Indexes[i]
+		if !stored || len(query.filters) > 0 || len(query.exists) > 0 || len(index.Columns) == 0 || !strings.EqualFold(index.Columns[0], query.extremeOf) || index.Collations[0] != collation {
+			continue
+		}
+
+		cursor := dbFile.NewCursor(header, index.RootPage)
+		var err error
+		if query.extreme == "max" {
+			err = cursor.Last()
+		} else {
+			// Every number sorts after -Inf, and every non-NULL value
+			// after every number
+			err = cursor.SeekIndex([]any{math.Inf(-1)}, index.Collations)
+		}
+		if err != nil || !cursor.Valid() {
+			return nil, err
+		}
+		entry, err := cursor.IndexCell()
+		if err != nil {
+			return nil, err
+		}
+		stats.indexKeys++
+		key, err := entry.Key()
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", index.Name, err)
+		}
+		if len(key.Values) == 0 {
+			return nil, fmt.Errorf("index %s: entry has no key", index.Name)
+		}
+		if integer, ok := key.Values[0].(int64); ok && table.Columns[position].Affinity == AffinityReal {
+			return float64(integer), nil
+		}
+		return key.Values[0], nil
+	}
+
+	// Without filters every row is read, which the column's values alone
+	// answer, decoded a page at a time. Rows written before the column was
+	// added hold its default, which the row path supplies.
+	if stored && len(query.filters) == 0 && len(query.exists) == 0 && !stats.permissive && (position >= len(table.defaults) || table.defaults[position] == nil) {
+		extreme, err := columnExtreme(dbFile, header, table, position, query.extreme == "max", stats)
+		if integer, ok := extreme.(int64); ok && table.Columns[position].Affinity == AffinityReal {
+			return float64(integer), err
+		}
+		return extreme, err
+	}
+
+	var extreme any
+	err := scan(func(row *db.Row) bool {
+		value := columnValue(row, table, position)
+		if value == nil {
+			return true
+		}
+		// SQLite keeps the first of the values that tie, which for MAX,
+		// whose rows are scanned from the last, is the last one seen
+		comparison := db.CompareValues(value, extreme, collation)
+		if extreme == nil || (query.extreme == "max" && comparison >= 0) || (query.extreme == "min" && comparison < 0) {
+			extreme = value
+		}
+		return true
+	})
+	return extreme, err
+}
+
+// aggregateScan answers a select list of several aggregates in one scan of
+// the rows the query matches. Of the values that tie for MIN or MAX, the
+// first scanned is kept, as SQLite keeps the one with the lowest rowid.
+func aggregateScan(table *TableSchema, aggregates []aggregateCall, scan func(func(*db.Row) bool) error) ([]any, error) {
+	values := make([]any, len(aggregates))
+	var count int64
+	err := scan(func(row *db.Row) bool {
+		count++
+		for i, call := range aggregates {
+			if call.function == "count" {
+				continue
+			}
+			var value any = row.RowID
+			if call.position >= 0 {
+				value = columnValue(row, table, call.position)
+			}
+			if value == nil {
+				continue
+			}
+			var collation db.Collation
+			if call.position >= 0 {
+				collation = table.Columns[call.position].collation()
+			}
+			comparison := db.CompareValues(value, values[i], collation)
+			if values[i] == nil || call.function == "max" && comparison > 0 || call.function == "min" && comparison < 0 {
+				values[i] = value
+			}
+		}
+		return true
+	})
+	for i, call := range aggregates {
+		if call.function == "count" {
+			values[i] = count
+		}
+	}
+	return values, err
+}
+
+// columnExtreme scans the table's column at position a leaf page at a
+// time, finding each page's MIN or MAX in the column's typed values before
+// comparing it with those of the pages before. Of the values that tie, the
+// one with the lowest rowid is kept, as the row path keeps it.
+func columnExtreme(dbFile *db.DatabaseFile, header *db.DatabaseHeader, table *TableSchema, position int, findMax bool, stats *scanStats) (any, error) {
+	collation := table.Columns[position].collation()
+	better := func(comparison int) bool {
+		return findMax && comparison > 0 || !findMax && comparison < 0
+	}
+	var extreme any
+	err := dbFile.ScanColumns(header, table.RootPage, []int{position}, func(batch *db.Batch) error {
+		stats.rowsFetched += batch.Len
+		vector := batch.Columns[0]
+		best := -1
+		for i := range vector.Len {
+			if vector.Null(i) {
+				continue
+			}
+			if best < 0 {
+				best = i
+				continue
+			}
+			var comparison int
+			switch vector.Type {
+			case db.IntegerValues:
+				comparison = cmp.Compare(vector.Integers[i], vector.Integers[best])
+			case db.RealValues:
+				comparison = cmp.Compare(vector.Reals[i], vector.Reals[best])
+			case db.TextValues:
+				comparison = collation.CompareText(vector.Texts[i], vector.Texts[best])
+			case db.BlobValues:
+				comparison = bytes.Compare(vector.Blobs[i], vector.Blobs[best])
+			default:
+				comparison = db.CompareValues(vector.Values[i], vector.Values[best], collation)
+			}
+			if better(comparison) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			if value := vector.Value(best); extreme == nil || better(db.CompareValues(value, extreme, collation)) {
+				extreme = value
+			}
+		}
+		return nil
+	})
+	return extreme, err
+}
+
+// refersToRowID reports whether name is the table's rowid: its INTEGER
+// PRIMARY KEY, or one of the rowid's own names if no column takes it.
+func refersToRowID(table *TableSchema, name string) bool {
+	if position, ok := table.ColumnIndex(name); ok {
+		return position == table.RowIDAlias
+	}
+	return isRowIDName(name)
+}
+
+func projection(query *selectQuery, table *TableSchema) ([]int, error) {
+	if query.star {
+		positions := make([]int, len(table.Columns))
+		for i := range positions {
+			positions[i] = i
+		}
+		return positions, nil
+	}
+
+	positions := make([]int, 0, len(query.columns))
+	for i, name := range query.columns {
+		// A subquery's or window's column is filled in once it has run
+		_, scalar := query.scalars[i]
+		if _, window := query.windows[i]; scalar || window {
+			positions = append(positions, -1)
+			continue
+		}
+		position, ok := table.ColumnIndex(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNoSuchColumn, name)
+		}
+		positions = append(positions, position)
+	}
+	return positions, nil
+}
+
+// columnValue returns a row's value for the column at position, reading the
+// rowid for an INTEGER PRIMARY KEY and the column's default for columns
+// added after the row was written.
+func columnValue(row *db.Row, table *TableSchema, position int) any {
+	if position == table.RowIDAlias {
+		return row.RowID
+	}
+	if position >= len(row.Columns) {
+		if position < len(table.defaults) {
+			return table.defaults[position]
+		}
+		return nil
+	}
+	value := row.Columns[position].DecodedValue
+	if integer, ok := value.(int64); ok && table.Columns[position].Affinity == AffinityReal {
+		return float64(integer)
+	}
+	return value
+}
+
+func project(row *db.Row, table *TableSchema, positions []int) []any {
+	values := make([]any, len(positions))
+	for i, position := range positions {
+		if position < 0 {
+			continue
+		}
+		values[i] = columnValue(row, table, position)
+	}
+	return values
+}
+
+func matches(row *db.Row, table *TableSchema, filters []equalityFilter) bool {
+	for _, filter := range filters {
+		if !filter.matches(columnValue(row, table, filter.position)) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordPredicate checks filters on record columns while the record is being
+// decoded, so rows failing a filter on an early column are abandoned before
+// the rest of the record is read. Filters on the rowid alias are left for
+// matches, since the record stores NULL in that column.
+func recordPredicate(table *TableSchema, filters []equalityFilter) db.ColumnPredicate {
+	byPosition := make(map[int][]equalityFilter)
+	for _, filter := range filters {
+		if filter.position != table.RowIDAlias {
+			byPosition[filter.position] = append(byPosition[filter.position], filter)
+		}
+	}
+	if len(byPosition) == 0 {
+		return nil
+	}
+
+	return func(column int, value any) bool {
+		for _, filter := range byPosition[column] {
+			if !filter.matches(value) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// tableScan emits the rows matching filters in rowid order, or in reverse
+// when descending.
+func tableScan(dbFile *db.DatabaseFile, header *db.DatabaseHeader, table *TableSchema, filters []equalityFilter, descending bool, stats *scanStats, emit func(*db.Row) bool) error {
+	cursor := dbFile.NewCursor(header, table.RootPage)
+	start, advance := cursor.First, cursor.Next
+	if descending {
+		start, advance = cursor.Last, cursor.Prev
+	}
+	if err := start(); err != nil {
+		return err
+	}
+
+	predicate := recordPredicate(table, filters)
+	for cursor.Valid() {
+		row, err := cursor.RowIf(predicate)
+		if err != nil && !stats.salvage(err) {
+			return err
+		}
+
+		switch {
+		case err != nil:
+			// Skipped as unreadable
+		case row == nil:
+			stats.rowsRejected++
+		default:
+			stats.rowsFetched++
+			if matches(row, table, filters) && !emit(row) {
+				return nil
+			}
+		}
+		if err := advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowIDSeek fetches the rows whose rowids the plan's lookup names, in
+// rowid order and each once however often it is named, skipping those that
+// do not exist or fail the residual filters.
+func rowIDSeek(dbFile *db.DatabaseFile, header *db.DatabaseHeader, table *TableSchema, queryPlan plan, stats *scanStats, emit func(*db.Row) bool) error {
+	var rowIDs []int64
+	for _, value := range queryPlan.lookup.values {
+		rowIDs = append(rowIDs, value.(int64))
+	}
+
+	for rowID := range newRowSet(rowIDs).all(queryPlan.descending) {
+		row, err := dbFile.SeekRowID(header, table.RootPage, rowID)
+		if err != nil && !stats.salvage(err) {
+			return err
+		}
+		if row == nil {
+			continue
+		}
+		stats.rowsFetched++
+		if matches(row, table, queryPlan.residual) && !emit(row) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// indexProbe returns the rowids of the entries of index whose leading
+// column equals value, stopping after limit of them unless it is negative.
+func indexProbe(dbFile *db.DatabaseFile, header *db.DatabaseHeader, index *IndexSchema, value any, limit int64, stats *scanStats) ([]int64, error) {
+	cursor := dbFile.NewCursor(header, index.RootPage)
+	if err := cursor.SeekIndex([]any{value}, index.Collations); err != nil {
+		return nil, err
+	}
+
+	var rowIDs []int64
+	for cursor.Valid() {
+		if limit >= 0 && int64(len(rowIDs)) >= limit {
+			break
+		}
+
+		entry, err := cursor.IndexCell()
+		if err != nil {
+			if !stats.salvage(err) {
+				return nil, err
+			}
+			if err := cursor.Next(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		key, err := entry.Key()
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", index.Name, err)
+		}
+		if len(key.Values) == 0 || !valuesEqual(key.Values[0], value, index.Collations[0]) {
+			break
+		}
+		stats.indexKeys++
+		rowIDs = append(rowIDs, key.RowID)
+
+		if err := cursor.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return rowIDs, nil
+}
+
+// indexMatches returns the rowids of the entries of index matching lookup.
+// Each of its values is a probe of its own, finding rowids in ascending
+// order, and the probes' sets are merged, so each row is visited once and
+// in rowid order.
+func indexMatches(dbFile *db.DatabaseFile, header *db.DatabaseHeader, index *IndexSchema, lookup equalityFilter, limit int64, stats *scanStats) (*rowSet, error) {
+	set := newRowSet(nil)
+	for _, value := range lookup.values {
+		found, err := indexProbe(dbFile, header, index, value, limit, stats)
+		if err != nil {
+			return nil, err
+		}
+		set = set.union(newRowSet(found))
+	}
+	return set, nil
+}
+
+func indexScan(dbFile *db.DatabaseFile, header *db.DatabaseHeader, table *TableSchema, queryPlan plan, stats *scanStats, emit func(*db.Row) bool) error {
+	rowIDs, err := indexMatches(dbFile, header, queryPlan.index, queryPlan.lookup, queryPlan.indexLimit, stats)
+	if err != nil {
+		return err
+	}
+	if queryPlan.intersect != nil {
+		others, err := indexMatches(dbFile, header, queryPlan.intersect, queryPlan.intersectLookup, -1, stats)
+		if err != nil {
+			return err
+		}
+		rowIDs = rowIDs.intersect(others)
+	}
+
+	for rowID := range rowIDs.all(queryPlan.descending) {
+		row, err := dbFile.SeekRowID(header, table.RootPage, rowID)
+		if err != nil {
+			if stats.salvage(err) {
+				continue
+			}
+			return err
+		}
+		if row == nil {
+			return fmt.Errorf("index %s: rowid %d missing from table %s", queryPlan.index.Name, rowID, table.Name)
+		}
+		stats.rowsFetched++
+
+		if matches(row, table, queryPlan.residual) && !emit(row) {
+			return nil
+		}
+	}
+	return nil
+}
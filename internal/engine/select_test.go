@@ -0,0 +1,977 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+// companiesDatabase generates a multi-page table with an index on country,
+// where every seventh company is in eritrea.
+func companiesDatabase(t *testing.T, rows int) string {
+	t.Helper()
+
+	database := testgen.New(testgen.Options{PageSize: 512})
+	companies := database.CreateTable("companies", "CREATE TABLE companies (id integer primary key autoincrement, name text, country text, size integer)")
+	for i := 1; i <= rows; i++ {
+		country := fmt.Sprintf("country-%d", i%10)
+		if i%7 == 0 {
+			country = "eritrea"
+		}
+		companies.Insert(int64(i), nil, fmt.Sprintf("company %d", i), country, int64(i%3))
+	}
+	database.CreateIndex("idx_companies_country", companies, "CREATE INDEX idx_companies_country on companies (country)", 2)
+	return database.WriteTemp(t)
+}
+
+func openDatabase(t *testing.T, path string) *Database {
+	t.Helper()
+
+	database, err := Open(path)
+	if err != nil {
+		t.Fatalf("opening %s: %v", path, err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func runSelect(t *testing.T, path, query string) ([][]any, scanStats) {
+	t.Helper()
+
+	resultSet, err := openDatabase(t, path).Query(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	rows, err := resultSet.All()
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return rows, resultSet.stats
+}
+
+func TestSelectUsesIndexForEquality(t *testing.T) {
+	path := companiesDatabase(t, 2000)
+
+	rows, stats := runSelect(t, path, "SELECT id, name FROM companies WHERE country = 'eritrea'")
+
+	if len(rows) != 2000/7 {
+		t.Fatalf("unexpected row count: got %d, want %d", len(rows), 2000/7)
+	}
+	if !reflect.DeepEqual(rows[0], []any{int64(7), "company 7"}) {
+		t.Fatalf("unexpected first row: %v", rows[0])
+	}
+	if stats.rowsFetched != len(rows) {
+		t.Fatalf("index scan fetched %d rows for %d results", stats.rowsFetched, len(rows))
+	}
+}
+
+func TestSelectPushesLimitIntoIndexScan(t *testing.T) {
+	path := companiesDatabase(t, 2000)
+
+	rows, stats := runSelect(t, path, "SELECT id FROM companies WHERE country = 'eritrea' LIMIT 3")
+
+	if !reflect.DeepEqual(rows, [][]any{{int64(7)}, {int64(14)}, {int64(21)}}) {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+	if stats.indexKeys != 3 || stats.rowsFetched != 3 {
+		t.Fatalf("limit not pushed down: read %d index keys, fetched %d rows", stats.indexKeys, stats.rowsFetched)
+	}
+}
+
+func TestSelectKeepsFullIndexScanWithResidualFilter(t *testing.T) {
+	path := companiesDatabase(t, 2000)
+
+	rows, stats := runSelect(t, path, "SELECT id FROM companies WHERE country = 'eritrea' AND size = 0 LIMIT 2")
+
+	if !reflect.DeepEqual(rows, [][]any{{int64(21)}, {int64(42)}}) {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+	if stats.indexKeys != 2000/7 {
+		t.Fatalf("residual filter should disable the pushdown: read %d index keys", stats.indexKeys)
+	}
+}
+
+func TestSelectFullScanWithoutIndex(t *testing.T) {
+	path := companiesDatabase(t, 2000)
+
+	rows, stats := runSelect(t, path, "SELECT count(*) FROM companies WHERE size = 1")
+
+	if !reflect.DeepEqual(rows, [][]any{{int64(667)}}) {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+	if stats.indexKeys != 0 {
+		t.Fatalf("expected a full table scan, read %d index keys", stats.indexKeys)
+	}
+	if stats.rowsFetched != 667 || stats.rowsRejected != 2000-667 {
+		t.Fatalf("expected non-matching rows to be rejected while decoding: fetched %d, rejected %d", stats.rowsFetched, stats.rowsRejected)
+	}
+}
+
+func TestSelectOrderByRowIDDescendingScansBackward(t *testing.T) {
+	path := companiesDatabase(t, 2000)
+
+	rows, stats := runSelect(t, path, "SELECT id, name FROM companies ORDER BY id DESC LIMIT 3")
+	want := [][]any{{int64(2000), "company 2000"}, {int64(1999), "company 1999"}, {int64(1998), "company 1998"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+	if stats.rowsFetched != 3 {
+		t.Fatalf("expected the scan to stop after 3 rows, fetched %d", stats.rowsFetched)
+	}
+
+	rows, _ = runSelect(t, path, "SELECT id FROM companies WHERE country = 'eritrea' ORDER BY rowid DESC LIMIT 2")
+	if !reflect.DeepEqual(rows, [][]any{{int64(1995)}, {int64(1988)}}) {
+		t.Fatalf("unexpected rows from the index: %v", rows)
+	}
+
+	rows, _ = runSelect(t, path, "SELECT id FROM companies ORDER BY _rowid_ ASC LIMIT 2")
+	if !reflect.DeepEqual(rows, [][]any{{int64(1)}, {int64(2)}}) {
+		t.Fatalf("unexpected ascending rows: %v", rows)
+	}
+}
+
+func TestSelectMinMaxRowID(t *testing.T) {
+	path := companiesDatabase(t, 2000)
+
+	for _, tc := range []struct {
+		query   string
+		want    any
+		fetched int
+	}{
+		{"SELECT max(id) FROM companies", int64(2000), 1},
+		{"SELECT MIN(rowid) FROM companies", int64(1), 1},
+		{"SELECT max(id) FROM companies WHERE size = 0", int64(1998), 1},
+		{"SELECT max(id) FROM companies WHERE country = 'eritrea'", int64(1995), 1},
+		{"SELECT max(id) FROM companies WHERE size = 7", nil, 0},
+	} {
+		rows, stats := runSelect(t, path, tc.query)
+		if !reflect.DeepEqual(rows, [][]any{{tc.want}}) {
+			t.Errorf("%s: got %v, want %v", tc.query, rows, tc.want)
+		}
+		if stats.rowsFetched != tc.fetched {
+			t.Errorf("%s: fetched %d rows, want %d", tc.query, stats.rowsFetched, tc.fetched)
+		}
+	}
+
+	database := openDatabase(t, path)
+	for _, query := range []string{
+		"SELECT id FROM companies ORDER BY name",
+		"SELECT max(missing) FROM companies",
+		"SELECT max(id), name FROM companies",
+		"SELECT min(id), count(*), name FROM companies",
+		"SELECT min(id), max(missing) FROM companies",
+	} {
+		if _, err := database.Query(query); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
+
+func TestSelectMinMaxReadsIndexEnds(t *testing.T) {
+	generated := testgen.New(testgen.Options{PageSize: 512})
+	table := generated.CreateTable("t", "CREATE TABLE t (id integer primary key, v integer, r real, w)")
+	for i := 1; i <= 1000; i++ {
+		var v any
+		if i%10 != 0 {
+			v = int64(i%500 - 200)
+		}
+		table.Insert(int64(i), nil, v, int64(i%13), int64(i%17))
+	}
+	generated.CreateIndex("iv", table, "CREATE INDEX iv ON t (v)", 1)
+	generated.CreateIndex("ir", table, "CREATE INDEX ir ON t (r)", 2)
+	path := generated.WriteTemp(t)
+
+	for _, tc := range []struct {
+		query     string
+		want      any
+		indexKeys int
+	}{
+		// NULLs sort first in the index but are not the minimum
+		{"SELECT min(v) FROM t", int64(-199), 1},
+		{"SELECT max(v) FROM t", int64(299), 1},
+		{"SELECT max(r) FROM t", float64(12), 1},
+		{"SELECT min(w) FROM t", int64(0), 0},
+		{"SELECT max(w) FROM t", int64(16), 0},
+		{"SELECT max(v) FROM t WHERE w = 3", int64(296), 0},
+	} {
+		rows, stats := runSelect(t, path, tc.query)
+		if !reflect.DeepEqual(rows, [][]any{{tc.want}}) {
+			t.Errorf("%s: got %v, want %v", tc.query, rows, tc.want)
+		}
+		if stats.indexKeys != tc.indexKeys {
+			t.Errorf("%s: read %d index keys, want %d", tc.query, stats.indexKeys, tc.indexKeys)
+		}
+		if tc.indexKeys > 0 && stats.rowsFetched != 0 {
+			t.Errorf("%s: fetched %d rows from the table", tc.query, stats.rowsFetched)
+		}
+	}
+}
+
+func TestSelectMinMaxScansColumnsAsSQLite(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	generated := testgen.New(testgen.Options{PageSize: 512})
+	table := generated.CreateTable("t", "CREATE TABLE t (id integer primary key, n integer, r real, s text COLLATE NOCASE, x)")
+	for i := int64(1); i <= 600; i++ {
+		var n, x any = i%91 - 45, nil
+		if i%9 == 0 {
+			n = nil
+		}
+		// Pages of integers alone, and of integers among reals, text and
+		// blobs, whose ties are the same number as an integer and a real
+		switch {
+		case i < 200:
+			x = i % 37
+		case i%4 == 0:
+			x = float64(i % 37)
+		case i%4 == 1:
+			x = fmt.Sprintf("x%d", i%37)
+		case i%4 == 2:
+			x = []byte{byte(i % 37)}
+		default:
+			x = i % 37
+		}
+		s := []string{"apple", "Apple", "BANANA", "banana", "cherry"}[i%5]
+		table.Insert(i, nil, n, i%13, s, x)
+	}
+	path := generated.WriteTemp(t)
+
+	describe := func(value any) string {
+		switch value := value.(type) {
+		case int64:
+			return fmt.Sprintf("integer %d", value)
+		case float64:
+			return "real " + db.FormatReal(value, 15)
+		case string:
+			return "text " + value
+		case []byte:
+			return fmt.Sprintf("blob %X", value)
+		}
+		return "null"
+	}
+	// sqlite3Describe selects what describe makes of each of the values
+	// a query selects
+	sqlite3Describe := func(selected []string, from string) []string {
+		var described []string
+		for i := range selected {
+			described = append(described, fmt.Sprintf("typeof(m%d) || CASE typeof(m%[1]d) WHEN 'null' THEN '' WHEN 'blob' THEN ' ' || hex(m%[1]d) ELSE ' ' || m%[1]d END", i))
+			selected[i] += fmt.Sprintf(" AS m%d", i)
+		}
+		query := fmt.Sprintf("SELECT %s FROM (SELECT %s FROM %s)", strings.Join(described, ", "), strings.Join(selected, ", "), from)
+		return strings.Split(sqlite3Lines(t, sqlite3, path, query)[0], "|")
+	}
+
+	for _, column := range []string{"n", "r", "s", "x"} {
+		for _, extreme := range []string{"min", "max"} {
+			query := fmt.Sprintf("SELECT %s(%s) FROM t", extreme, column)
+			rows, stats := runSelect(t, path, query)
+			if stats.rowsFetched != 600 {
+				t.Errorf("%s: fetched %d rows, want 600", query, stats.rowsFetched)
+			}
+			got := describe(rows[0][0])
+			want := sqlite3Describe([]string{fmt.Sprintf("%s(%s)", extreme, column)}, "t")[0]
+			if got != want {
+				t.Errorf("%s: got %s, sqlite3 %s", query, got, want)
+			}
+		}
+	}
+
+	// Several aggregates are answered in one scan
+	for _, from := range []string{"t", "t WHERE r = 3", "t WHERE r = 99"} {
+		selected := []string{"min(n)", "max(n)", "count(*)", "max(s)", "min(s)", "max(x)", "min(rowid)", "max(id)"}
+		query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selected, ", "), from)
+		rows, _ := runSelect(t, path, query)
+		var got []string
+		for _, value := range rows[0] {
+			got = append(got, describe(value))
+		}
+		if want := sqlite3Describe(selected, from); !slices.Equal(got, want) {
+			t.Errorf("%s:\n got %q\nwant %q", query, got, want)
+		}
+	}
+}
+
+func TestLimitsAbortLargeResults(t *testing.T) {
+	database := openDatabase(t, companiesDatabase(t, 100))
+
+	for _, tc := range []struct {
+		limits Limits
+		query  string
+		rows   int
+	}{
+		{Limits{MaxRows: 10}, "SELECT id FROM companies", 10},
+		{Limits{MaxRows: 10}, "SELECT id FROM companies LIMIT 10", -1},
+		// Each row is an eight-byte id and a name of 9 to 11 bytes
+		{Limits{MaxResultBytes: 100}, "SELECT id, name FROM companies", 5},
+		{Limits{MaxRows: 1, MaxResultBytes: 8}, "SELECT count(*) FROM companies", -1},
+	} {
+		database.SetLimits(tc.limits)
+		resultSet, err := database.Query(tc.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		rows, err := resultSet.All()
+		if tc.rows < 0 {
+			if err != nil {
+				t.Errorf("%s under %+v: %v", tc.query, tc.limits, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrResultTooLarge) {
+			t.Errorf("%s under %+v: got error %v, want ErrResultTooLarge", tc.query, tc.limits, err)
+		}
+		if len(rows) != tc.rows {
+			t.Errorf("%s under %+v: returned %d rows before failing, want %d", tc.query, tc.limits, len(rows), tc.rows)
+		}
+	}
+}
+
+func TestProgressHandlerInterruptsQueries(t *testing.T) {
+	database := openDatabase(t, companiesDatabase(t, 2000))
+
+	calls := 0
+	database.SetProgressHandler(2, func() bool {
+		calls++
+		return false
+	})
+	if rows := queryRows(t, database, "SELECT name FROM companies"); len(rows) != 2000 {
+		t.Fatalf("unexpected row count: %d", len(rows))
+	}
+	if calls < 10 {
+		t.Fatalf("handler called %d times over a multi-page scan", calls)
+	}
+
+	// A handler can give statements a deadline
+	deadline := time.Now()
+	database.SetProgressHandler(1, func() bool { return time.Now().After(deadline) })
+	_, err := runQuery(database, "SELECT name FROM companies")
+	if !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("got %v, want ErrInterrupted", err)
+	}
+
+	database.SetProgressHandler(0, nil)
+	if rows := queryRows(t, database, "SELECT count(*) FROM companies WHERE size = 1"); !reflect.DeepEqual(rows, [][]any{{int64(667)}}) {
+		t.Fatalf("unexpected rows after removing the handler: %v", rows)
+	}
+}
+
+func TestLoggerRecordsPlansAndPageReads(t *testing.T) {
+	database := openDatabase(t, companiesDatabase(t, 100))
+	var records bytes.Buffer
+	database.SetLogger(slog.New(slog.NewTextHandler(&records, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	queryRows(t, database, "SELECT id FROM companies WHERE country = 'eritrea'")
+	queryRows(t, database, "SELECT id FROM companies ORDER BY id DESC")
+	for _, want := range []string{
+		"msg=plan table=companies index=idx_companies_country descending=false",
+		"msg=plan table=companies scan=full descending=true",
+		`msg="read page" page=1`,
+	} {
+		if !strings.Contains(records.String(), want) {
+			t.Errorf("log is missing %q:\n%s", want, records.String())
+		}
+	}
+
+	database.SetLogger(nil)
+	records.Reset()
+	queryRows(t, database, "SELECT id FROM companies")
+	if records.Len() != 0 {
+		t.Fatalf("logged after the logger was removed:\n%s", records.String())
+	}
+}
+
+// TestPageReadsStayWithinTheTreesNeeded counts the pages statements read,
+// through the progress handler, so a change that makes a lookup scan the
+// file, or a count read pages outside its table, fails here rather than
+// only on huge files.
+func TestPageReadsStayWithinTheTreesNeeded(t *testing.T) {
+	generated := testgen.New(testgen.Options{PageSize: 512})
+	big := generated.CreateTable("big", "CREATE TABLE big (id integer primary key, name text)")
+	for i := 1; i <= 5000; i++ {
+		big.Insert(int64(i), nil, fmt.Sprintf("name %d", i))
+	}
+	generated.CreateIndex("idx_big_name", big, "CREATE INDEX idx_big_name ON big (name)", 1)
+	small := generated.CreateTable("small", "CREATE TABLE small (id integer primary key, name text)")
+	small.Insert(1, nil, "only")
+	database := openDatabase(t, generated.WriteTemp(t))
+
+	reads := 0
+	database.SetProgressHandler(1, func() bool {
+		reads++
+		return false
+	})
+	pageReads := func(run func()) int {
+		reads = 0
+		run()
+		return reads
+	}
+
+	// .dbinfo and .tables need the header and the schema table alone
+	if n := pageReads(func() {
+		if _, err := database.SchemaObjects(); err != nil {
+			t.Fatal(err)
+		}
+	}); n != 1 {
+		t.Errorf("reading a one-page schema read %d pages", n)
+	}
+
+	stats, err := database.DBStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bigPages := stats[1].Pages
+	// The big table's b-tree is three levels deep at this page size
+	const depth = 3
+
+	tests := []struct {
+		query string
+		most  int
+	}{
+		{"SELECT count(*) FROM small", 1},
+		// A count reads each of the table's pages, and again only the first
+		// child of a page whose children are interior
+		{"SELECT count(*) FROM big", bigPages + depth},
+		{"SELECT name FROM big WHERE id = 4000", depth},
+		{"SELECT name FROM big WHERE id = 4000 AND name = 'name 4000'", depth},
+		{"SELECT max(id) FROM big", depth},
+		{"SELECT name FROM big LIMIT 1", depth},
+		// Down the index to the key, then down the table to its row
+		{"SELECT id FROM big WHERE name = 'name 4000'", 2 * depth},
+	}
+	for _, test := range tests {
+		if n := pageReads(func() { queryRows(t, database, test.query) }); n > test.most {
+			t.Errorf("%s read %d pages, want at most %d", test.query, n, test.most)
+		}
+	}
+}
+
+func TestSelectProbesIndexForEachValueOfOrAndIn(t *testing.T) {
+	path := companiesDatabase(t, 200)
+	// Rows 7, 14, ... are eritrea, and country-3 the other rows ending in 3
+	eritrea, country3 := 200/7, 0
+	for i := 1; i <= 200; i++ {
+		if i%10 == 3 && i%7 != 0 {
+			country3++
+		}
+	}
+
+	tests := []struct {
+		query string
+		rows  int
+		keys  int
+	}{
+		{"SELECT id FROM companies WHERE country = 'eritrea' OR country = 'country-3'", eritrea + country3, eritrea + country3},
+		{"SELECT id FROM companies WHERE country IN ('eritrea', 'country-3')", eritrea + country3, eritrea + country3},
+		// Repeated values find the same rows, which are returned once
+		{"SELECT id FROM companies WHERE country IN ('eritrea', 'eritrea')", eritrea, 2 * eritrea},
+		{"SELECT id FROM companies WHERE (country = 'eritrea' OR country = 'nowhere') AND size = 0", 9, eritrea},
+		{"SELECT count(*) FROM companies WHERE country IN ('eritrea', 'country-3')", 1, eritrea + country3},
+	}
+	for _, test := range tests {
+		rows, stats := runSelect(t, path, test.query)
+		if len(rows) != test.rows || stats.indexKeys != test.keys {
+			t.Errorf("%s: %d rows from %d index keys, want %d from %d", test.query, len(rows), stats.indexKeys, test.rows, test.keys)
+		}
+		if strings.Contains(test.query, "count") {
+			continue
+		}
+		rowIDs := make([]int64, len(rows))
+		for i, row := range rows {
+			rowIDs[i] = row[0].(int64)
+		}
+		if !slices.IsSorted(rowIDs) {
+			t.Errorf("%s: rows out of rowid order: %v", test.query, rowIDs)
+		}
+	}
+
+	rows, _ := runSelect(t, path, "SELECT count(*) FROM companies WHERE country IN ('eritrea', 'country-3')")
+	if !reflect.DeepEqual(rows, [][]any{{int64(eritrea + country3)}}) {
+		t.Errorf("count over an IN list = %v, want %d", rows, eritrea+country3)
+	}
+	rows, stats := runSelect(t, path, "SELECT name FROM companies WHERE id IN (5, 150, 5, 999) ORDER BY id DESC")
+	if !reflect.DeepEqual(rows, [][]any{{"company 150"}, {"company 5"}}) || stats.rowsFetched != 2 {
+		t.Errorf("rowid IN list = %v after fetching %d rows", rows, stats.rowsFetched)
+	}
+	if _, err := runQuery(openDatabase(t, path), "SELECT id FROM companies WHERE country = 'eritrea' OR size = 1"); err == nil {
+		t.Error("expected an error for an OR across columns")
+	}
+}
+
+func TestSelectIntersectsTwoIndexes(t *testing.T) {
+	generated := testgen.New(testgen.Options{PageSize: 512})
+	table := generated.CreateTable("t", "CREATE TABLE t (id integer primary key, a, b)")
+	for i := int64(1); i <= 1000; i++ {
+		table.Insert(i, nil, i%10, i%7)
+	}
+	generated.CreateIndex("ia", table, "CREATE INDEX ia ON t (a)", 1)
+	generated.CreateIndex("ib", table, "CREATE INDEX ib ON t (b)", 2)
+	path := generated.WriteTemp(t)
+
+	// Rows 23, 93, ... 933 have a = 3 and b = 2
+	var want []int64
+	for i := int64(23); i <= 1000; i += 70 {
+		want = append(want, i)
+	}
+	rows, stats := runSelect(t, path, "SELECT id FROM t WHERE a = 3 AND b = 2")
+	got := make([]int64, len(rows))
+	for i, row := range rows {
+		got[i] = row[0].(int64)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("rows = %v, want %v", got, want)
+	}
+	if stats.rowsFetched != len(want) || stats.indexKeys != 100+143 {
+		t.Errorf("fetched %d rows from %d index keys, want %d from %d", stats.rowsFetched, stats.indexKeys, len(want), 100+143)
+	}
+
+	rows, stats = runSelect(t, path, "SELECT id FROM t WHERE b IN (2, 4) AND a = 3 ORDER BY id DESC LIMIT 2")
+	if !reflect.DeepEqual(rows, [][]any{{int64(963)}, {int64(933)}}) || stats.rowsFetched != 2 {
+		t.Errorf("descending intersection = %v after fetching %d rows", rows, stats.rowsFetched)
+	}
+}
+
+func TestSelectSeeksRowIDEquality(t *testing.T) {
+	path := companiesDatabase(t, 200)
+	tests := []struct {
+		query string
+		want  [][]any
+	}{
+		{"SELECT name FROM companies WHERE id = 150", [][]any{{"company 150"}}},
+		{"SELECT name FROM companies WHERE id = 150 AND size = 0", [][]any{{"company 150"}}},
+		{"SELECT name FROM companies WHERE id = 150 AND size = 1", nil},
+		{"SELECT name FROM companies WHERE id = 999", nil},
+		{"SELECT count(*) FROM companies WHERE id = 7", [][]any{{int64(1)}}},
+	}
+	for _, test := range tests {
+		rows, stats := runSelect(t, path, test.query)
+		if !reflect.DeepEqual(rows, test.want) {
+			t.Errorf("%s = %v, want %v", test.query, rows, test.want)
+		}
+		if stats.rowsFetched > 1 {
+			t.Errorf("%s fetched %d rows, want at most one", test.query, stats.rowsFetched)
+		}
+	}
+}
+
+// runQuery runs a query and collects its rows, returning the first error,
+// whether it came from preparing the query or reading its rows.
+func runQuery(database *Database, query string) ([][]any, error) {
+	resultSet, err := database.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	return resultSet.All()
+}
+
+func TestQueryDescribesResultColumns(t *testing.T) {
+	path := companiesDatabase(t, 10)
+
+	tests := []struct {
+		query   string
+		columns []ResultColumn
+	}{
+		{
+			"SELECT Name, id FROM companies",
+			[]ResultColumn{{"Name", "text", "companies", "name"}, {"id", "integer", "companies", "id"}},
+		},
+		{
+			"SELECT * FROM companies LIMIT 1",
+			[]ResultColumn{{"id", "integer", "companies", "id"}, {"name", "text", "companies", "name"}, {"country", "text", "companies", "country"}, {"size", "integer", "companies", "size"}},
+		},
+		{
+			"SELECT COUNT(*) FROM companies",
+			[]ResultColumn{{Name: "count(*)"}},
+		},
+	}
+
+	for _, tt := range tests {
+		resultSet, err := openDatabase(t, path).Query(tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if !reflect.DeepEqual(resultSet.Columns, tt.columns) {
+			t.Errorf("%s: unexpected columns\n got %+v\nwant %+v", tt.query, resultSet.Columns, tt.columns)
+		}
+		if err := resultSet.Close(); err != nil {
+			t.Fatalf("%s: closing: %v", tt.query, err)
+		}
+	}
+}
+
+func TestResultSetStreamsRowsOnDemand(t *testing.T) {
+	path := companiesDatabase(t, 2000)
+
+	resultSet, err := openDatabase(t, path).Query("SELECT id FROM companies")
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer resultSet.Close()
+
+	for i := int64(1); i <= 3; i++ {
+		if !resultSet.Next() {
+			t.Fatalf("row %d missing: %v", i, resultSet.Err())
+		}
+		if id := resultSet.Row()[0]; id != i {
+			t.Fatalf("unexpected id: got %v, want %d", id, i)
+		}
+	}
+
+	if resultSet.stats.rowsFetched != 3 {
+		t.Fatalf("expected rows to be read lazily, fetched %d", resultSet.stats.rowsFetched)
+	}
+}
+
+func TestSelectAppliesRealAffinity(t *testing.T) {
+	database := testgen.New(testgen.Options{})
+	readings := database.CreateTable("readings", "CREATE TABLE readings (id integer primary key, value real, count int)")
+	// SQLite stores integral reals as integers; the column type restores them
+	readings.Insert(1, nil, int64(3), int64(3))
+	readings.Insert(2, nil, 2.5, int64(4))
+	path := database.WriteTemp(t)
+
+	rows, _ := runSelect(t, path, "SELECT value, count FROM readings WHERE value = 3")
+
+	if !reflect.DeepEqual(rows, [][]any{{3.0, int64(3)}}) {
+		t.Fatalf("unexpected rows: %#v", rows)
+	}
+}
+
+func TestSelectFiltersOnBlobLiteral(t *testing.T) {
+	database := testgen.New(testgen.Options{})
+	files := database.CreateTable("files", "CREATE TABLE files (id integer primary key, digest blob)")
+	files.Insert(1, nil, []byte{0xde, 0xad})
+	files.Insert(2, nil, []byte{0xde, 0xad, 0xbe, 0xef})
+	files.Insert(3, nil, "\xde\xad\xbe\xef")
+	path := database.WriteTemp(t)
+
+	rows, _ := runSelect(t, path, "SELECT id, digest FROM files WHERE digest = x'DEADBEEF'")
+
+	// The text value with the same bytes is a different storage class
+	if !reflect.DeepEqual(rows, [][]any{{int64(2), []byte{0xde, 0xad, 0xbe, 0xef}}}) {
+		t.Fatalf("unexpected rows: %#v", rows)
+	}
+
+	resultSet, err := openDatabase(t, path).Query("SELECT id FROM files WHERE digest = x'ABC'")
+	if err == nil {
+		resultSet.Close()
+		t.Fatal("expected an error for an odd-length blob literal")
+	}
+}
+
+func TestSelectComparesNumericLiterals(t *testing.T) {
+	database := testgen.New(testgen.Options{})
+	numbers := database.CreateTable("numbers", "CREATE TABLE numbers (id integer primary key, i int, r real, t text, b)")
+	numbers.Insert(1, nil, int64(1)<<32, 1e-3, "10", int64(1)<<53+1)
+	numbers.Insert(2, nil, int64(math.MaxInt64), 1.5e15, "255", float64(int64(1)<<53))
+	numbers.Insert(3, nil, int64(math.MinInt64), -5.5, "x", "9007199254740993")
+	numbers.Insert(4, nil, int64(255), 0.5, "-5", int64(-1))
+	numbers.Insert(5, nil, int64(-1), 1e20, "1e3", int64(255))
+	database.CreateIndex("idx_numbers_i", numbers, "CREATE INDEX idx_numbers_i ON numbers (i)", 1)
+	path := database.WriteTemp(t)
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+
+	for _, where := range []string{
+		"i = 4294967296", "i = 9223372036854775807", "i = -9223372036854775808", "i = 9223372036854775808",
+		"i = 0xFF", "i = 0xFFFFFFFFFFFFFFFF", "i IN (0x100000000, -0x1)", "i = '255'", "i = 255.0",
+		"r = 1e-3", "r = 1.5E15", "r = -5.5", "r = 5e-1", "r = 1e20", "r = .5",
+		// Beyond 2^53 an integer is compared exactly with a real
+		"b = 9007199254740993", "b = 9007199254740992", "b = 9007199254740992.0", "b = 0xff",
+		"t = 10", "t = -5", "t = 255 OR t = 1e3",
+	} {
+		query := "SELECT id FROM numbers WHERE " + where
+		rows, err := runQuery(openDatabase(t, path), query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		output, err := exec.Command(sqlite3, path, query).Output()
+		if err != nil {
+			t.Fatalf("sqlite3 %s: %v", query, err)
+		}
+		want := strings.Fields(string(output))
+		slices.Sort(want)
+		if got := sortedLines(rows); !slices.Equal(got, want) {
+			t.Errorf("%s: got %v, want %v", query, got, want)
+		}
+	}
+
+	rows, err := runQuery(openDatabase(t, path), "SELECT 0xFF, -0x10, 1e3, 9223372036854775808, -9223372036854775808, -2.5e-3, 0xFFFFFFFFFFFFFFFF, -(7)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]any{{int64(255), int64(-16), 1000.0, 9223372036854775808.0, int64(math.MinInt64), -0.0025, int64(-1), int64(-7)}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("literals evaluate to %#v, want %#v", rows, want)
+	}
+	if _, err := runQuery(openDatabase(t, path), "SELECT id FROM numbers WHERE i = 0x10000000000000000"); err == nil || err.Error() != "hex literal too big: 0x10000000000000000" {
+		t.Errorf("error %v for a 17-digit hex literal", err)
+	}
+}
+
+func TestDatabaseOpenBlobByColumnName(t *testing.T) {
+	content := bytes.Repeat([]byte("attachment "), 1000)
+	database := testgen.New(testgen.Options{PageSize: 1024})
+	files := database.CreateTable("files", "CREATE TABLE files (id integer primary key, Content blob)")
+	files.Insert(9, nil, content)
+
+	handle := openDatabase(t, database.WriteTemp(t))
+
+	blob, err := handle.OpenBlob("FILES", "content", 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("read %d bytes that differ from the %d inserted", len(got), len(content))
+	}
+
+	if _, err := handle.OpenBlob("files", "id", 9); err == nil {
+		t.Fatal("expected an error opening the rowid alias")
+	}
+	if _, err := handle.OpenBlob("files", "missing", 9); err == nil {
+		t.Fatal("expected an error for an unknown column")
+	}
+}
+
+func TestSelectFromSchemaTable(t *testing.T) {
+	database := testgen.New(testgen.Options{PageSize: 512})
+	for i := range 40 {
+		database.CreateTable(fmt.Sprintf("table_%02d", i), fmt.Sprintf("CREATE TABLE table_%02d (id integer primary key, note text)", i))
+	}
+	path := database.WriteTemp(t)
+
+	for _, name := range []string{"sqlite_schema", "sqlite_master", "SQLITE_MASTER"} {
+		rows, _ := runSelect(t, path, "SELECT name, rootpage FROM "+name+" WHERE type = 'table' AND tbl_name = 'table_37'")
+		if len(rows) != 1 || rows[0][0] != "table_37" {
+			t.Fatalf("%s: unexpected rows: %v", name, rows)
+		}
+	}
+
+	// Forty definitions do not fit on one 512-byte page, so this also scans
+	// an interior schema root
+	rows, _ := runSelect(t, path, "SELECT COUNT(*) FROM sqlite_schema")
+	if !reflect.DeepEqual(rows, [][]any{{int64(40)}}) {
+		t.Fatalf("unexpected count: %v", rows)
+	}
+}
+
+func TestDatabaseHandleServesSeveralStatements(t *testing.T) {
+	database := openDatabase(t, companiesDatabase(t, 500))
+
+	first, err := database.Query("SELECT name FROM companies WHERE id = 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second statement may run while the first is still open
+	count, err := database.RowCount("companies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 500 {
+		t.Fatalf("unexpected row count: %d", count)
+	}
+	rows, err := first.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, [][]any{{"company 10"}}) {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+
+	stats, err := database.DBStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 3 || stats[1].Name != "companies" || stats[1].Cells < 500 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+// damagedDatabase generates a table of ten rows with an index on name and
+// corrupts the record of the row with rowid 4, whose name's serial type is
+// made to claim more text than the record holds.
+func damagedDatabase(t *testing.T) string {
+	t.Helper()
+
+	database := testgen.New(testgen.Options{})
+	items := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text)")
+	for i := int64(1); i <= 10; i++ {
+		items.Insert(i, nil, fmt.Sprintf("item-%d", i))
+	}
+	database.CreateIndex("items_name", items, "CREATE INDEX items_name ON items (name)", 1)
+	path := database.WriteTemp(t)
+
+	dbFile, header, err := db.OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := dbFile.ReadSchema(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPage, err := db.RootPageLookup("items", objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := dbFile.NewPage(header, rootPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbFile.Close()
+
+	// The cell holds its record size, rowid, header size and the NULL
+	// standing for the alias before name's serial type
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	offset := int64(rootPage-1)*int64(header.PageSize) + int64(page.CellAddresses[3]) + 4
+	if _, err := file.WriteAt([]byte{127}, offset); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPermissiveSkipsUnreadableRows(t *testing.T) {
+	path := damagedDatabase(t)
+
+	var corruption *db.CorruptionError
+	if _, err := runQuery(openDatabase(t, path), "SELECT id FROM items"); !errors.As(err, &corruption) {
+		t.Fatalf("strict scan: error %v, want a corruption error", err)
+	}
+
+	database := openDatabase(t, path)
+	database.SetPermissive(true)
+	var records bytes.Buffer
+	database.SetLogger(slog.New(slog.NewTextHandler(&records, nil)))
+
+	for query, want := range map[string][]int64{
+		"SELECT id FROM items": {1, 2, 3, 5, 6, 7, 8, 9, 10},
+		"SELECT id FROM items WHERE name IN ('item-3', 'item-4')": {3},
+		"SELECT id FROM items WHERE id IN (4, 5)":                 {5},
+	} {
+		resultSet, err := database.Query(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		var got []int64
+		for resultSet.Next() {
+			got = append(got, resultSet.Row()[0].(int64))
+		}
+		if err := resultSet.Err(); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		warnings := resultSet.Warnings()
+		resultSet.Close()
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: rows %v, want %v", query, got, want)
+		}
+		if len(warnings) != 1 || warnings[0].Cell != 3 {
+			t.Errorf("%s: warnings %v, want one for cell 3", query, warnings)
+		}
+	}
+	if !strings.Contains(records.String(), `level=WARN msg="skipped unreadable cell"`) || !strings.Contains(records.String(), "cell=3") {
+		t.Errorf("no warning logged:\n%s", records.String())
+	}
+
+	if count, err := runQuery(database, "SELECT count(*) FROM items WHERE name = 'item-5'"); err != nil || count[0][0] != int64(1) {
+		t.Errorf("count past the damage: %v, %v", count, err)
+	}
+}
+
+func TestSchemaQualifiedNames(t *testing.T) {
+	path := ordersDatabase(t)
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+
+	for _, query := range []string{
+		"SELECT id, name FROM main.customers WHERE id = 3",
+		"SELECT main.customers.name FROM MAIN.customers WHERE main.customers.tag = 'tag-1'",
+		"SELECT c.name, o.id FROM main.customers c JOIN main.orders o ON c.id = o.customer_id WHERE c.id = 8",
+		"SELECT count(*) FROM main.orders",
+		"SELECT name FROM main.sqlite_schema WHERE type = 'table'",
+	} {
+		rows, err := runQuery(openDatabase(t, path), query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		output, err := exec.Command(sqlite3, path, query).Output()
+		if err != nil {
+			t.Fatalf("sqlite3 %s: %v", query, err)
+		}
+		want := strings.Split(strings.TrimSpace(string(output)), "\n")
+		slices.Sort(want)
+		if got := sortedLines(rows); !slices.Equal(got, want) {
+			t.Errorf("%s: got %v, want %v", query, got, want)
+		}
+	}
+
+	database := openDatabase(t, path)
+	for query, want := range map[string]string{
+		"SELECT * FROM temp.customers":                                   "no such table: temp.customers",
+		"SELECT * FROM aux.customers":                                    "no such table: aux.customers",
+		"SELECT aux.customers.id FROM customers":                         "no such column: aux.customers.id",
+		"SELECT c.id FROM customers c JOIN temp.orders o ON c.id = o.id": "no such table: temp.orders",
+		"INSERT INTO temp.customers (name) VALUES ('x')":                 "no such table: temp.customers",
+	} {
+		if _, err := runQuery(database, query); err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %q", query, err, want)
+		}
+	}
+
+	if err := execute(t, database, "INSERT INTO main.customers (name, tag) VALUES ('qualified', 'tag-q')"); err != nil {
+		t.Fatal(err)
+	}
+	if rows, err := runQuery(database, "SELECT name FROM customers WHERE tag = 'tag-q'"); err != nil || len(rows) != 1 {
+		t.Errorf("insert into main.customers wrote %v, %v", rows, err)
+	}
+}
+
+func TestSelectFiltersNameTheirTable(t *testing.T) {
+	database := openDatabase(t, ordersDatabase(t))
+
+	for _, query := range []string{
+		"SELECT name FROM customers WHERE customers.id = 3",
+		"SELECT name FROM customers WHERE CUSTOMERS.id IN (3, 4)",
+		"SELECT name FROM customers c WHERE C.id = 3",
+	} {
+		if rows, err := runQuery(database, query); err != nil || len(rows) == 0 {
+			t.Errorf("%s: %v, %v", query, rows, err)
+		}
+	}
+
+	for query, want := range map[string]string{
+		"SELECT name FROM customers WHERE zz.id = 3":                                     "no such column: zz.id",
+		"SELECT name FROM customers WHERE zz.id IN (3, 4)":                               "no such column: zz.id",
+		"SELECT name FROM customers WHERE zz.id = 3 OR zz.id = 4":                        "no such column: zz.id",
+		"SELECT name FROM customers c WHERE customers.id = 3":                            "no such column: customers.id",
+		"SELECT name FROM customers WHERE EXISTS (SELECT 1 FROM orders WHERE zz.id = 1)": "no such column: zz.id",
+	} {
+		if _, err := runQuery(database, query); !errors.Is(err, ErrNoSuchColumn) || err.Error() != want {
+			t.Errorf("%s: error %v, want %q", query, err, want)
+		}
+	}
+}
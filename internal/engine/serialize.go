@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// Serialize returns the database as the bytes of a database file, as
+// sqlite3_serialize does for the main schema: every page as of the last
+// commit, including those still in the write-ahead log, in a copy that
+// opens on its own in rollback journal mode. Temporary tables are not
+// included. It cannot be called inside a transaction, whose changes would
+// be left out.
+func (database *Database) Serialize() ([]byte, error) {
+	if database.transaction != nil {
+		return nil, errors.New("cannot serialize within a transaction")
+	}
+	if err := database.file.LockShared(); err != nil {
+		return nil, err
+	}
+	defer database.file.UnlockShared()
+	if err := database.verifySchema(); err != nil {
+		return nil, err
+	}
+	return database.file.Serialize(database.header)
+}
+
+// OpenSerialized opens a copy of a serialized database, such as one
+// Serialize returned or the bytes of a database file, in memory, as
+// sqlite3_deserialize does. Writes change only the copy, and are gone once
+// the database is closed unless it is serialized again.
+func OpenSerialized(contents []byte) (*Database, error) {
+	dbFile, header, err := db.OpenMemoryDatabase(contents)
+	if err != nil {
+		return nil, err
+	}
+	return newDatabase(dbFile, header), nil
+}
+
+// backupStepPages is how many pages BackupTo copies under each read lock.
+var backupStepPages uint32 = 100
+
+// BackupTo copies the database to a new file at path, replacing any file
+// there once the copy is complete, as SQLite's online backup does. Pages are
+// copied a step at a time, each under a read lock that is released between
+// steps so that other connections can still commit; when one does, the
+// change counter moves and the copy starts over, so the file written is
+// always a consistent snapshot. A step that finds the database locked waits
+// as the busy timeout allows. Like Serialize, it cannot be called inside a
+// transaction, and temporary tables are not copied.
+func (database *Database) BackupTo(path string) error {
+	if database.transaction != nil {
+		return errors.New("cannot back up within a transaction")
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.backup")
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	var snapshot db.DatabaseHeader
+	retry := 0
+	for next := uint32(1); next == 1 || next <= snapshot.PageCount; {
+		contents, header, err := database.backupStep(next)
+		if errors.Is(err, db.ErrBusy) && database.file.BusyWait(retry) {
+			retry++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		retry = 0
+		if next == 1 {
+			snapshot = header
+		} else if header.ChangeCounter != snapshot.ChangeCounter || header.PageCount != snapshot.PageCount {
+			database.logger.Debug("database changed during backup, restarting", "path", path, "page", next)
+			if err := file.Truncate(0); err != nil {
+				return fmt.Errorf("backup: %w", err)
+			}
+			next = 1
+			continue
+		}
+		if _, err := file.WriteAt(contents, int64(next-1)*int64(header.PageSize)); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+		next += uint32(len(contents) / int(header.PageSize))
+		database.logger.Debug("backup", "path", path, "copied", next-1, "pages", snapshot.PageCount)
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	return nil
+}
+
+// backupStep reads up to backupStepPages pages from next on under a read
+// lock, returning them with the header they were read under. Nothing is
+// read when the page count shows there are no pages left.
+func (database *Database) backupStep(next uint32) ([]byte, db.DatabaseHeader, error) {
+	if err := database.file.LockShared(); err != nil {
+		return nil, db.DatabaseHeader{}, err
+	}
+	defer database.file.UnlockShared()
+	if err := database.verifySchema(); err != nil {
+		return nil, db.DatabaseHeader{}, err
+	}
+	header := *database.header
+	if next > header.PageCount {
+		return nil, header, nil
+	}
+	contents, err := database.file.ReadPages(&header, next, min(backupStepPages, header.PageCount-next+1))
+	if err != nil {
+		return nil, header, fmt.Errorf("backup: %w", err)
+	}
+	if next == 1 {
+		db.DetachLog(contents)
+	}
+	return contents, header, nil
+}
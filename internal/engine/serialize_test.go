@@ -0,0 +1,244 @@
+package engine
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSerializeRoundTrip(t *testing.T) {
+	database := openDatabase(t, ordersDatabase(t))
+	for _, statement := range []string{
+		"PRAGMA journal_mode=wal",
+		"INSERT INTO customers (name, tag) VALUES ('logged', 'tag-w')",
+	} {
+		if err := execute(t, database, statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	contents, err := database.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The row still in the write-ahead log is part of the copy
+	copied, err := OpenSerialized(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	query := "SELECT id, name FROM customers WHERE tag = 'tag-w'"
+	if got, want := queryRows(t, copied, query), queryRows(t, database, query); !reflect.DeepEqual(got, want) || len(got) != 1 {
+		t.Errorf("copy read %v, original %v", got, want)
+	}
+	if rows := queryRows(t, copied, "PRAGMA journal_mode"); rows[0][0] != "memory" {
+		t.Errorf("copy journal mode %v", rows[0][0])
+	}
+
+	// Writes to the copy leave the original alone, and serialize again
+	if err := execute(t, copied, "INSERT INTO customers (name, tag) VALUES ('copied', 'tag-c')"); err != nil {
+		t.Fatal(err)
+	}
+	if rows := queryRows(t, database, "SELECT count(*) FROM customers"); rows[0][0] != int64(51) {
+		t.Errorf("original has %v customers after writing the copy", rows[0][0])
+	}
+	again, err := copied.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		path := filepath.Join(t.TempDir(), "serialized.db")
+		if err := os.WriteFile(path, again, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if got := sqlite3Lines(t, sqlite3, path, "PRAGMA integrity_check; PRAGMA journal_mode; SELECT name FROM customers WHERE id > 50"); !slices.Equal(got, []string{"ok", "delete", "logged", "copied"}) {
+			t.Errorf("sqlite3 read the serialized copy as %q", got)
+		}
+	}
+
+	if err := execute(t, database, "BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Serialize(); err == nil || err.Error() != "cannot serialize within a transaction" {
+		t.Errorf("serializing inside a transaction: error %v", err)
+	}
+}
+
+func TestOpenSerializedRejectsBadImages(t *testing.T) {
+	for name, contents := range map[string][]byte{
+		"empty":     nil,
+		"truncated": []byte("SQLite format 3\x00"),
+	} {
+		if database, err := OpenSerialized(contents); err == nil {
+			database.Close()
+			t.Errorf("%s image opened", name)
+		}
+	}
+}
+
+func TestBackupTo(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	database := openDatabase(t, ordersDatabase(t))
+	for _, statement := range []string{
+		"PRAGMA journal_mode=wal",
+		"INSERT INTO customers (name, tag) VALUES ('logged', 'tag-w')",
+	} {
+		if err := execute(t, database, statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	// The backup replaces what is there, and includes the logged row
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := os.WriteFile(path, []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.BackupTo(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := sqlite3Lines(t, sqlite3, path, "PRAGMA integrity_check; PRAGMA journal_mode; SELECT count(*), max(name) FROM customers; SELECT sum(amount) FROM orders"); !slices.Equal(got, []string{"ok", "delete", "51|logged", "802000"}) {
+		t.Errorf("sqlite3 read the backup as %q", got)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("backup left %d files behind", len(entries))
+	}
+
+	memory, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer memory.Close()
+	for _, statement := range []string{
+		"CREATE TABLE notes (id integer primary key, body text unique)",
+		"INSERT INTO notes (body) VALUES ('first'), ('second')",
+	} {
+		if err := execute(t, memory, statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	if err := memory.BackupTo(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := sqlite3Lines(t, sqlite3, path, "PRAGMA integrity_check; SELECT body FROM notes"); !slices.Equal(got, []string{"ok", "first", "second"}) {
+		t.Errorf("sqlite3 read the in-memory backup as %q", got)
+	}
+
+	if err := execute(t, database, "BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.BackupTo(path); err == nil || err.Error() != "cannot back up within a transaction" {
+		t.Errorf("backing up inside a transaction: error %v", err)
+	}
+}
+
+// TestBackupToRestarts commits from another handle once a page at a time
+// backup is under way, and checks the backup starts over and holds the row
+// the commit added.
+func TestBackupToRestarts(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+	defer func(pages uint32) { backupStepPages = pages }(backupStepPages)
+	backupStepPages = 1
+
+	source := ordersDatabase(t)
+	database, writer := openDatabase(t, source), openDatabase(t, source)
+	var records bytes.Buffer
+	committed := false
+	database.SetLogger(slog.New(slog.NewTextHandler(writerFunc(func(p []byte) (int, error) {
+		if !committed && bytes.Contains(p, []byte("copied=3 ")) {
+			committed = true
+			if err := execute(t, writer, "INSERT INTO customers (name, tag) VALUES ('written', 'tag-w')"); err != nil {
+				t.Error(err)
+			}
+		}
+		return records.Write(p)
+	}), &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := database.BackupTo(path); err != nil {
+		t.Fatal(err)
+	}
+	if restarts := strings.Count(records.String(), "restarting"); restarts != 1 {
+		t.Errorf("backup restarted %d times\n%s", restarts, records.String())
+	}
+	if got := sqlite3Lines(t, sqlite3, path, "PRAGMA integrity_check; SELECT count(*) FROM customers; SELECT name FROM customers WHERE tag = 'tag-w'"); !slices.Equal(got, []string{"ok", "51", "written"}) {
+		t.Errorf("sqlite3 read the backup as %q", got)
+	}
+}
+
+// writerFunc is an io.Writer calling a function.
+type writerFunc func([]byte) (int, error)
+
+func (write writerFunc) Write(p []byte) (int, error) {
+	return write(p)
+}
+
+func TestRawPage(t *testing.T) {
+	database := openDatabase(t, ordersDatabase(t))
+	for _, statement := range []string{
+		"PRAGMA journal_mode=wal",
+		"INSERT INTO customers (name, tag) VALUES ('logged', 'tag-w')",
+	} {
+		if err := execute(t, database, statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	// The pages are read through the log, with page 1 left saying so
+	contents, err := database.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []byte
+	count := database.Header().PageCount
+	for pageNumber := uint32(1); pageNumber <= count; pageNumber++ {
+		data, err := database.RawPage(pageNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, data...)
+	}
+	if pages[18] != 2 || pages[19] != 2 {
+		t.Errorf("page 1 file format versions %d, %d, want WAL's", pages[18], pages[19])
+	}
+	pages[18], pages[19] = 1, 1
+	if !bytes.Equal(pages, contents) {
+		t.Error("raw pages differ from the serialized database")
+	}
+	for _, pageNumber := range []uint32{0, count + 1} {
+		if _, err := database.RawPage(pageNumber); err == nil {
+			t.Errorf("page %d of %d read", pageNumber, count)
+		}
+	}
+
+	// Inside a transaction, its own writes show
+	if err := execute(t, database, "BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	if err := execute(t, database, "INSERT INTO customers (name, tag) VALUES ('uncommitted', 'tag-u')"); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for pageNumber := uint32(1); pageNumber <= database.Header().PageCount; pageNumber++ {
+		data, err := database.RawPage(pageNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = found || bytes.Contains(data, []byte("uncommitted"))
+	}
+	if !found {
+		t.Error("raw pages read inside a transaction miss its writes")
+	}
+}
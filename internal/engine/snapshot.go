@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"errors"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// Snapshot pins the handle, in WAL mode, to the newest commit, which it
+// returns, so that every statement from now on reads the database as it is
+// now however many transactions other processes commit meanwhile, as a read
+// transaction held open in SQLite does: a long analytical query, or several
+// queries, see one consistent state. Once a checkpoint has copied the log
+// those statements read through into the database file they fail with
+// db.ErrSnapshotStale, and a write commits only while no other process has
+// committed since the snapshot, failing with db.ErrBusySnapshot otherwise.
+// Refresh moves the pin to the newest commit and ReleaseSnapshot removes
+// it. A handle already pinned keeps its snapshot.
+func (database *Database) Snapshot() (db.WALSnapshot, error) {
+	if database.transaction != nil {
+		return db.WALSnapshot{}, errors.New("cannot take a snapshot within a transaction")
+	}
+	if database.file.Pinned() {
+		return database.file.WALSnapshot(), nil
+	}
+	if err := database.file.LockShared(); err != nil {
+		return db.WALSnapshot{}, err
+	}
+	defer database.file.UnlockShared()
+	if err := database.verifySchema(); err != nil {
+		return db.WALSnapshot{}, err
+	}
+	return database.file.Pin()
+}
+
+// Refresh brings the handle up to the newest commit, moving its snapshot
+// there when it is pinned to one. An unpinned handle refreshes before each
+// statement in any case.
+func (database *Database) Refresh() error {
+	if database.transaction != nil {
+		return errors.New("cannot refresh within a transaction")
+	}
+	pinned := database.file.Pinned()
+	database.file.Unpin()
+	if err := database.file.LockShared(); err != nil {
+		return err
+	}
+	defer database.file.UnlockShared()
+	err := database.verifySchema()
+	if pinned {
+		if _, pinErr := database.file.Pin(); err == nil {
+			err = pinErr
+		}
+	}
+	return err
+}
+
+// ReleaseSnapshot unpins the handle from its snapshot, if it has one, so
+// that each statement reads the newest commit again.
+func (database *Database) ReleaseSnapshot() {
+	database.file.Unpin()
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+func TestSnapshot(t *testing.T) {
+	path := ordersDatabase(t)
+	writer := openDatabase(t, path)
+	if _, err := writer.Snapshot(); err == nil {
+		t.Error("Snapshot outside WAL mode succeeded")
+	}
+	queryRows(t, writer, "PRAGMA journal_mode = WAL")
+	queryRows(t, writer, "PRAGMA user_version = 1")
+
+	reader := openDatabase(t, path)
+	snapshot, err := reader.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Frames == 0 || snapshot.PageCount != reader.Header().PageCount {
+		t.Errorf("snapshot %+v, page count %d", snapshot, reader.Header().PageCount)
+	}
+	count := func(database *Database) any {
+		t.Helper()
+		rows, err := runQuery(database, "SELECT count(*) FROM orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows[0][0]
+	}
+	insert := "INSERT INTO orders (customer_id, amount, tag) VALUES (1, 1, 'tag-1')"
+
+	// Commits after the snapshot are not seen until it is refreshed
+	if err := execute(t, writer, insert); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(reader); got != int64(400) {
+		t.Errorf("pinned reader counts %v orders, want 400", got)
+	}
+	if got := count(openDatabase(t, path)); got != int64(401) {
+		t.Errorf("unpinned reader counts %v orders, want 401", got)
+	}
+	if err := reader.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(reader); got != int64(401) {
+		t.Errorf("after Refresh the reader counts %v orders, want 401", got)
+	}
+
+	// Writing to a snapshot needs it to be the newest commit
+	if err := execute(t, writer, insert); err != nil {
+		t.Fatal(err)
+	}
+	if err := execute(t, reader, insert); !errors.Is(err, db.ErrBusySnapshot) {
+		t.Errorf("write to an old snapshot: %v", err)
+	}
+	if err := reader.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if err := execute(t, reader, insert); err != nil {
+		t.Fatalf("write to the newest snapshot: %v", err)
+	}
+	if got := count(reader); got != int64(403) {
+		t.Errorf("after its own write the reader counts %v orders, want 403", got)
+	}
+
+	// A checkpoint takes the snapshot's frames away
+	if _, err := runQuery(reader, "PRAGMA wal_checkpoint"); err == nil {
+		t.Error("checkpoint from a pinned handle succeeded")
+	}
+	queryRows(t, writer, "PRAGMA wal_checkpoint")
+	if _, err := runQuery(reader, "SELECT count(*) FROM orders"); !errors.Is(err, db.ErrSnapshotStale) {
+		t.Errorf("read after a checkpoint: %v", err)
+	}
+	reader.ReleaseSnapshot()
+	if got := count(reader); got != int64(403) {
+		t.Errorf("after ReleaseSnapshot the reader counts %v orders, want 403", got)
+	}
+}
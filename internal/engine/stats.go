@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"sync/atomic"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// Counters are running totals of the work a database's statements have
+// done since it was opened, for monitoring.
+type Counters struct {
+	// PagesRead counts the b-tree pages statements read
+	PagesRead int64
+	// PlanCacheHits counts the SELECTs run from a plan compiled before,
+	// and PlanCacheMisses those compiled to be cached
+	PlanCacheHits, PlanCacheMisses int64
+	// RowsReturned and BytesReturned total the rows of result sets, and
+	// their values counted as Limits counts them
+	RowsReturned, BytesReturned int64
+}
+
+// add returns the sums of two sets of counters.
+func (c Counters) add(other Counters) Counters {
+	return Counters{
+		PagesRead:       c.PagesRead + other.PagesRead,
+		PlanCacheHits:   c.PlanCacheHits + other.PlanCacheHits,
+		PlanCacheMisses: c.PlanCacheMisses + other.PlanCacheMisses,
+		RowsReturned:    c.RowsReturned + other.RowsReturned,
+		BytesReturned:   c.BytesReturned + other.BytesReturned,
+	}
+}
+
+// counters are the totals Counters reports other than pages read, which
+// the file counts. They are atomic so that they can be read while a
+// statement runs.
+type counters struct {
+	planCacheHits, planCacheMisses atomic.Int64
+	rowsReturned, bytesReturned    atomic.Int64
+}
+
+// Counters returns the running totals of the work statements have done. It
+// is safe to call while another goroutine runs a statement.
+func (database *Database) Counters() Counters {
+	return Counters{
+		PagesRead:       database.file.PagesRead(),
+		PlanCacheHits:   database.counters.planCacheHits.Load(),
+		PlanCacheMisses: database.counters.planCacheMisses.Load(),
+		RowsReturned:    database.counters.rowsReturned.Load(),
+		BytesReturned:   database.counters.bytesReturned.Load(),
+	}
+}
+
+type BTreeStats struct {
+	Name      string
+	Pages     int
+	Cells     int
+	FreeBytes int
+}
+
+// DBStat summarizes page usage of every b-tree in the database, starting with
+// the schema table itself.
+func (database *Database) DBStat() ([]BTreeStats, error) {
+	if err := database.file.LockShared(); err != nil {
+		return nil, err
+	}
+	defer database.file.UnlockShared()
+	if err := database.verifySchema(); err != nil {
+		return nil, err
+	}
+	dbFile, header := database.file, database.header
+	objects, err := database.SchemaObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	trees := []db.TableMetadata{{Name: "sqlite_schema", RootPage: 1}}
+	for _, object := range objects {
+		if object.RootPage != 0 {
+			trees = append(trees, object)
+		}
+	}
+
+	stats := make([]BTreeStats, 0, len(trees))
+	for _, tree := range trees {
+		treeStats := BTreeStats{Name: tree.Name}
+		err := dbFile.WalkBTree(header, tree.RootPage, func(_ uint32, page *db.Page) error {
+			treeStats.Pages++
+			treeStats.Cells += int(page.CellCount)
+			treeStats.FreeBytes += page.FreeBytes()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, treeStats)
+	}
+
+	return stats, nil
+}
+
+// RawPage returns the bytes of page pageNumber, as sqlite_dbpage returns
+// them, for tools that inspect the file format. Outside a transaction this
+// is the page as of the last commit, read through the write-ahead log in
+// WAL mode; inside one it includes the pages the transaction has written,
+// though page 1's header fields are only brought up to date on commit.
+func (database *Database) RawPage(pageNumber uint32) ([]byte, error) {
+	if tx := database.transaction; tx != nil {
+		data, err := tx.pager.Read(pageNumber)
+		if err != nil {
+			return nil, err
+		}
+		return slices.Clone(data), nil
+	}
+	if err := database.file.LockShared(); err != nil {
+		return nil, err
+	}
+	defer database.file.UnlockShared()
+	if err := database.verifySchema(); err != nil {
+		return nil, err
+	}
+	if pageNumber == 0 || pageNumber > database.header.PageCount {
+		return nil, fmt.Errorf("page %d out of range (page count %d)", pageNumber, database.header.PageCount)
+	}
+	return database.file.ReadPages(database.header, pageNumber, 1)
+}
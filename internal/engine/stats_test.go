@@ -0,0 +1,32 @@
+package engine
+
+import "testing"
+
+func TestCounters(t *testing.T) {
+	database := openDatabase(t, ordersDatabase(t))
+	before := database.Counters()
+	for range 2 {
+		queryRows(t, database, "SELECT name FROM customers WHERE id = 17")
+	}
+	queryRows(t, database, "SELECT amount, tag FROM orders WHERE id = 233")
+
+	counters := database.Counters()
+	if pages := counters.PagesRead - before.PagesRead; pages == 0 {
+		t.Error("no pages read")
+	}
+	if counters.PlanCacheHits != 1 || counters.PlanCacheMisses != 2 {
+		t.Errorf("plan cache hits %d, misses %d; want 1 and 2", counters.PlanCacheHits, counters.PlanCacheMisses)
+	}
+	if counters.RowsReturned != 3 {
+		t.Errorf("%d rows returned, want 3", counters.RowsReturned)
+	}
+
+	// A row past the limits is not returned
+	database.SetLimits(Limits{MaxRows: 1})
+	if _, err := runQuery(database, "SELECT id FROM orders LIMIT 3"); err == nil {
+		t.Error("query past the row limit succeeded")
+	}
+	if rows := database.Counters().RowsReturned - counters.RowsReturned; rows != 1 {
+		t.Errorf("%d rows returned past the limit, want 1", rows)
+	}
+}
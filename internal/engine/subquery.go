@@ -0,0 +1,225 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/xwb1989/sqlparser"
+)
+
+// subquery is a SELECT nested in another's WHERE clause or select list. It
+// may be correlated with the outer query's row through filters comparing
+// one of its columns with an outer column, and is then run again for each
+// outer row with those filters' values taken from the row, so that an
+// index on the compared column answers each run. Results are cached by the
+// outer values, since many outer rows often share them.
+type subquery struct {
+	query *selectQuery
+	// exists is set for an EXISTS subquery, whose rows are never read
+	exists bool
+	// correlated are the places in query.filters of the correlated
+	// filters, and outer the outer column position each takes its value
+	// from
+	correlated []int
+	outer      []int
+	// results holds the first row found for each set of outer values, or
+	// nil when there was none
+	results map[string][]any
+}
+
+// existsTerm is an EXISTS, or with negated a NOT EXISTS, term of a WHERE
+// clause.
+type existsTerm struct {
+	subquery *subquery
+	negated  bool
+}
+
+// conjuncts splits a WHERE clause into its ANDed terms.
+func conjuncts(expr sqlparser.Expr) []sqlparser.Expr {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		return append(conjuncts(expr.Left), conjuncts(expr.Right)...)
+	case *sqlparser.ParenExpr:
+		if _, ok := expr.Expr.(*sqlparser.AndExpr); ok {
+			return conjuncts(expr.Expr)
+		}
+	}
+	return []sqlparser.Expr{expr}
+}
+
+// parseExists recognises an EXISTS or NOT EXISTS term, reporting false for
+// anything else.
+func parseExists(expr sqlparser.Expr) (existsTerm, bool, error) {
+	negated := false
+	if not, ok := expr.(*sqlparser.NotExpr); ok {
+		expr, negated = not.Expr, true
+	}
+	for {
+		paren, ok := expr.(*sqlparser.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.Expr
+	}
+	exists, ok := expr.(*sqlparser.ExistsExpr)
+	if !ok {
+		return existsTerm{}, false, nil
+	}
+	sub, err := parseSubquery(exists.Subquery, true)
+	return existsTerm{subquery: sub, negated: negated}, true, err
+}
+
+// parseSubquery reads a nested SELECT. The select list of an EXISTS
+// subquery is ignored, as SQLite ignores it, so SELECT 1 and the like are
+// taken.
+func parseSubquery(expr *sqlparser.Subquery, exists bool) (*subquery, error) {
+	sel, ok := expr.Select.(*sqlparser.Select)
+	if !ok {
+		return nil, fmt.Errorf("unsupported subquery: %s", sqlparser.String(expr))
+	}
+	if exists {
+		copied := *sel
+		copied.SelectExprs = sqlparser.SelectExprs{&sqlparser.StarExpr{}}
+		sel = &copied
+	}
+	query, err := parseSelectStatement(sel, sqlparser.String(expr))
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		query.limit = 1
+	}
+	return &subquery{query: query, exists: exists}, nil
+}
+
+// prepare checks the subquery against its outer query's table and settles
+// which column of each correlated filter is the subquery's own, swapping
+// the two when the outer column was written first.
+func (sub *subquery) prepare(database *Database, outer *TableSchema, outerAlias string) error {
+	_, inner, err := database.lookupTable(sub.query.schema, sub.query.table)
+	if err != nil {
+		return err
+	}
+	if !sub.exists && !sub.query.count && sub.query.extreme == "" {
+		if width := len(sub.query.columns); sub.query.star && len(inner.Columns) != 1 || !sub.query.star && width != 1 {
+			if sub.query.star {
+				width = len(inner.Columns)
+			}
+			if len(sub.query.aggregates) > 0 {
+				width = len(sub.query.aggregates)
+			}
+			return fmt.Errorf("sub-select returns %d columns - expected 1", width)
+		}
+	}
+
+	// belongs reports whether the column is table's, named either way, and
+	// its position there
+	belongs := func(table *TableSchema, alias string, column qualifiedColumn) (int, bool) {
+		if column.qualifier != "" && !strings.EqualFold(column.qualifier, table.Name) && !strings.EqualFold(column.qualifier, alias) {
+			return 0, false
+		}
+		if position, ok := table.ColumnIndex(column.name); ok {
+			return position, true
+		}
+		if refersToRowID(table, column.name) {
+			return table.RowIDAlias, true
+		}
+		return 0, false
+	}
+
+	sub.correlated, sub.outer = nil, nil
+	for i := range sub.query.filters {
+		filter := &sub.query.filters[i]
+		if filter.outer == nil {
+			continue
+		}
+		written, other := qualifiedColumn{qualifier: filter.qualifier, name: filter.column}, *filter.outer
+		_, writtenInner := belongs(inner, sub.query.alias, written)
+		_, otherInner := belongs(inner, sub.query.alias, other)
+		if !writtenInner {
+			written, other = other, written
+		}
+		// A name both tables have is the subquery's own, so two of its
+		// columns are being compared, which only the outer query's
+		// filters could do
+		position, ok := belongs(outer, outerAlias, other)
+		if writtenInner == otherInner || !ok {
+			return fmt.Errorf("unsupported comparison: %s = %s", columnText(written), columnText(other))
+		}
+		filter.column, filter.qualifier, *filter.outer = written.name, written.qualifier, other
+		sub.correlated = append(sub.correlated, i)
+		sub.outer = append(sub.outer, position)
+	}
+	sub.results = make(map[string][]any)
+	return nil
+}
+
+// columnText renders a column as a query names it.
+func columnText(column qualifiedColumn) string {
+	if column.qualifier == "" {
+		return column.name
+	}
+	return column.qualifier + "." + column.name
+}
+
+// run returns the first row the subquery finds for the outer row, or nil
+// when it finds none, adding the work it does to stats.
+func (sub *subquery) run(database *Database, row *db.Row, table *TableSchema, stats *scanStats) ([]any, error) {
+	values := make([]any, len(sub.outer))
+	var key strings.Builder
+	for i, position := range sub.outer {
+		values[i] = columnValue(row, table, position)
+		fmt.Fprintf(&key, "%T:%v\x00", values[i], values[i])
+	}
+	if result, ok := sub.results[key.String()]; ok {
+		return result, nil
+	}
+
+	// A fresh copy, since preparing a query resolves its filters in place
+	query := *sub.query
+	query.filters = append([]equalityFilter(nil), sub.query.filters...)
+	for i, filter := range sub.correlated {
+		query.filters[filter].outer = nil
+		query.filters[filter].values = []any{values[i]}
+		// NULL equals nothing, so no row compares equal to it
+		if values[i] == nil {
+			sub.results[key.String()] = nil
+			return nil, nil
+		}
+	}
+	resultSet, err := database.prepareSelect(&query)
+	if err != nil {
+		return nil, err
+	}
+	defer resultSet.Close()
+
+	var result []any
+	if resultSet.Next() {
+		result = resultSet.Row()
+	}
+	if err := resultSet.Err(); err != nil {
+		return nil, err
+	}
+	stats.indexKeys += resultSet.stats.indexKeys
+	stats.rowsFetched += resultSet.stats.rowsFetched
+	stats.rowsRejected += resultSet.stats.rowsRejected
+	stats.skipped = append(stats.skipped, resultSet.stats.skipped...)
+	sub.results[key.String()] = result
+	return result, nil
+}
+
+// existsHold reports whether every EXISTS term holds for a row, and NOT
+// EXISTS term fails to.
+func (database *Database) existsHold(terms []existsTerm, row *db.Row, table *TableSchema, stats *scanStats) (bool, error) {
+	for _, term := range terms {
+		result, err := term.subquery.run(database, row, table, stats)
+		if err != nil {
+			return false, err
+		}
+		if (result != nil) == term.negated {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestSubqueriesMatchSQLite(t *testing.T) {
+	path := ordersDatabase(t)
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+
+	for _, query := range []string{
+		"SELECT name FROM customers WHERE EXISTS (SELECT 1 FROM orders WHERE orders.customer_id = customers.id)",
+		"SELECT name FROM customers WHERE NOT EXISTS (SELECT 1 FROM orders WHERE customers.id = customer_id AND tag IN ('tag-1', 'tag-2'))",
+		"SELECT id FROM customers c WHERE tag = 'tag-2' AND NOT EXISTS (SELECT * FROM orders o WHERE o.customer_id = c.id AND o.tag = 'tag-1')",
+		"SELECT name, (SELECT count(*) FROM orders WHERE customer_id = customers.id) FROM customers WHERE tag = 'tag-3'",
+		"SELECT name, (SELECT max(amount) FROM orders WHERE orders.customer_id = customers.id) AS largest FROM customers LIMIT 5",
+		// Orders of missing customers, or none, select NULL
+		"SELECT id, (SELECT name FROM customers WHERE customers.id = orders.customer_id) FROM orders WHERE amount IN (100, 500, 560)",
+		"SELECT count(*) FROM orders WHERE EXISTS (SELECT id FROM customers WHERE id = orders.customer_id AND tag = 'tag-0')",
+		"SELECT name FROM customers WHERE EXISTS (SELECT 1 FROM orders WHERE tag = 'tag-5')",
+	} {
+		rows, err := runQuery(openDatabase(t, path), query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		var got strings.Builder
+		for _, row := range rows {
+			fields := make([]string, len(row))
+			for i, value := range row {
+				if value != nil {
+					fields[i] = fmt.Sprint(value)
+				}
+			}
+			fmt.Fprintln(&got, strings.Join(fields, "|"))
+		}
+		want, err := exec.Command(sqlite3, path, query).Output()
+		if err != nil {
+			t.Fatalf("sqlite3 %s: %v", query, err)
+		}
+		if got.String() != string(want) || len(rows) == 0 {
+			t.Errorf("%s:\ngot  %q\nwant %q", query, got.String(), want)
+		}
+	}
+}
+
+func TestCorrelatedSubqueryProbesIndex(t *testing.T) {
+	path := ordersDatabase(t)
+
+	// Every customer has orders, and each EXISTS stops at the first one
+	// found in the index
+	rows, stats := runSelect(t, path, "SELECT id FROM customers WHERE EXISTS (SELECT 1 FROM orders WHERE orders.customer_id = customers.id)")
+	if len(rows) != 50 || stats.rowsFetched != 50+50 {
+		t.Errorf("%d customers after fetching %d rows, want 50 after 100", len(rows), stats.rowsFetched)
+	}
+
+	// Runs for repeated outer values are answered from the cache
+	_, stats = runSelect(t, path, "SELECT (SELECT name FROM customers WHERE id = orders.customer_id) FROM orders")
+	if stats.rowsFetched != 400+50 {
+		t.Errorf("fetched %d rows, want 450", stats.rowsFetched)
+	}
+}
+
+func TestSubqueryErrors(t *testing.T) {
+	database := openDatabase(t, ordersDatabase(t))
+	for query, want := range map[string]string{
+		"SELECT name FROM customers WHERE EXISTS (SELECT 1 FROM nosuch WHERE nosuch.id = customers.id)":     "no such table: nosuch",
+		"SELECT (SELECT * FROM orders WHERE customer_id = customers.id) FROM customers":                     "sub-select returns 4 columns - expected 1",
+		"SELECT name FROM customers WHERE EXISTS (SELECT 1 FROM orders WHERE orders.id = orders.amount)":    "unsupported comparison: orders.id = orders.amount",
+		"SELECT name FROM customers WHERE EXISTS (SELECT 1 FROM orders WHERE orders.id = nowhere.id)":       "unsupported comparison: orders.id = nowhere.id",
+		"SELECT name FROM customers WHERE id = tag":                                                         "unsupported comparison: id = tag",
+		"SELECT *, (SELECT amount FROM orders WHERE customer_id = customers.id) FROM customers":             "subqueries cannot be combined with *",
+		"SELECT name FROM customers WHERE EXISTS (SELECT 1 FROM orders WHERE customer_id = customers.nope)": "unsupported comparison: customer_id = customers.nope",
+	} {
+		if _, err := runQuery(database, query); err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %q", query, err, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// SyntaxError reports SQL that failed to parse and where: the token the
+// parser could not accept, or the end of the statement when it stopped
+// there wanting more. Its message names the line and column and shows the
+// line with a caret under the token.
+type SyntaxError struct {
+	// SQL is the statement as it was parsed
+	SQL string
+	// Offset is the byte offset in SQL of the token, or len(SQL) at the end
+	Offset int
+	// Near is the token's text, and empty when the input was incomplete
+	Near string
+}
+
+// Position returns the line and column of the token, both counted from 1,
+// the column in characters.
+func (syntaxError *SyntaxError) Position() (line, column int) {
+	before := syntaxError.SQL[:syntaxError.Offset]
+	start := strings.LastIndexByte(before, '\n') + 1
+	return strings.Count(before, "\n") + 1, utf8.RuneCountInString(before[start:]) + 1
+}
+
+func (syntaxError *SyntaxError) Error() string {
+	line, column := syntaxError.Position()
+	var message strings.Builder
+	if syntaxError.Near == "" {
+		fmt.Fprintf(&message, "incomplete input at line %d, column %d", line, column)
+	} else {
+		fmt.Fprintf(&message, "near %q: syntax error at line %d, column %d", syntaxError.Near, line, column)
+	}
+
+	// The line, and under it a caret marking the token, with any tabs
+	// before it kept so that the caret lines up
+	start := strings.LastIndexByte(syntaxError.SQL[:syntaxError.Offset], '\n') + 1
+	end := strings.IndexByte(syntaxError.SQL[start:], '\n')
+	if end < 0 {
+		end = len(syntaxError.SQL) - start
+	}
+	text := strings.TrimRight(syntaxError.SQL[start:start+end], "\r")
+	message.WriteString("\n  " + text + "\n  ")
+	for _, r := range syntaxError.SQL[start:syntaxError.Offset] {
+		if r == '\t' {
+			message.WriteByte('\t')
+		} else {
+			message.WriteByte(' ')
+		}
+	}
+	message.WriteByte('^')
+	if width := utf8.RuneCountInString(syntaxError.Near); width > 1 {
+		message.WriteString(strings.Repeat("~", min(width, utf8.RuneCountInString(text[syntaxError.Offset-start:]))-1))
+	}
+	return message.String()
+}
+
+// within moves the error from a fragment to the statement it came from,
+// which holds the fragment at offset.
+func (syntaxError *SyntaxError) within(sql string, offset int) {
+	syntaxError.SQL, syntaxError.Offset = sql, syntaxError.Offset+offset
+}
+
+// parserPosition matches the SQL parser's syntax errors, which give the
+// position the tokenizer had read to, counted from 1, and the last token
+// when it had a value.
+var parserPosition = regexp.MustCompile(`^syntax error at position (\d+)(?: near '.*')?$`)
+
+// parseSQL parses a statement with the SQL parser, returning a
+// *SyntaxError when the statement does not parse.
+func parseSQL(sql string) (sqlparser.Statement, error) {
+	return parseSQLAfter("", sql)
+}
+
+// parseSQLAfter parses sql as the end of a statement whose start is
+// prefix, for fragments the parser only reads as part of a whole one. A
+// syntax error is reported in sql alone.
+func parseSQLAfter(prefix, sql string) (sqlparser.Statement, error) {
+	stmt, err := sqlparser.Parse(prefix + sql)
+	if err == nil {
+		return stmt, nil
+	}
+	match := parserPosition.FindStringSubmatch(err.Error())
+	if match == nil {
+		return nil, err
+	}
+	position, _ := strconv.Atoi(match[1])
+
+	// The tokenizer reads a character ahead, so the position counts the
+	// character after the token it stopped at; the token is the last one
+	// that starts before that character
+	lookahead := min(max(position-1-len(prefix), 0), len(sql))
+	syntaxError := &SyntaxError{SQL: sql, Offset: len(sql)}
+	if strings.TrimSpace(sql[lookahead:]) == "" && !strings.Contains(match[0], " near ") {
+		return nil, syntaxError
+	}
+	for _, token := range scanSQL(sql) {
+		if token.start >= lookahead && syntaxError.Near != "" {
+			break
+		}
+		syntaxError.Offset, syntaxError.Near = token.start, sql[token.start:token.end]
+	}
+	if syntaxError.Near == "" {
+		syntaxError.Offset = lookahead
+	}
+	return nil, syntaxError
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSyntaxErrors(t *testing.T) {
+	database := openDatabase(t, ordersDatabase(t))
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{
+			"SELECT name FROM customers WHER tag = 'x'",
+			"parse query: near \"tag\": syntax error at line 1, column 33\n" +
+				"  SELECT name FROM customers WHER tag = 'x'\n" +
+				"                                  ^~~",
+		},
+		{
+			"SELECT name,\n\tid,\nFROM customers",
+			"parse query: near \"FROM\": syntax error at line 3, column 1\n" +
+				"  FROM customers\n" +
+				"  ^~~~",
+		},
+		{
+			"SELECT name FROM\n\tcustomers WHERE\tid = = 1",
+			"parse query: near \"=\": syntax error at line 2, column 23\n" +
+				"  \tcustomers WHERE\tid = = 1\n" +
+				"  \t               \t     ^",
+		},
+		{
+			"SELECT name FROM customers WHERE (id = 1",
+			"parse query: incomplete input at line 1, column 41\n" +
+				"  SELECT name FROM customers WHERE (id = 1\n" +
+				"                                          ^",
+		},
+		{
+			"SELECT name FROM customers WHERE tag = 'open",
+			"parse query: near \"'open\": syntax error at line 1, column 40\n" +
+				"  SELECT name FROM customers WHERE tag = 'open\n" +
+				"                                         ^~~~~",
+		},
+		// Positions are in the statement as written, not in the text the
+		// parser was given for window calls, VALUES and upserts
+		{
+			"SELECT name, row_number() OVER (ORDER BY id) FROM customers WHERE id = = 1",
+			"parse query: near \"=\": syntax error at line 1, column 72\n" +
+				"  SELECT name, row_number() OVER (ORDER BY id) FROM customers WHERE id = = 1\n" +
+				"                                                                         ^",
+		},
+		{
+			"VALUES (1, 2), (3 4)",
+			"parse query: near \"4\": syntax error at line 1, column 19\n" +
+				"  VALUES (1, 2), (3 4)\n" +
+				"                    ^",
+		},
+		{
+			"INSERT INTO customers (id, name) VALUES (1, 'x') ON CONFLICT (id) DO UPDATE SET name = = 'y'",
+			"parse query: near \"=\": syntax error at line 1, column 88\n" +
+				"  INSERT INTO customers (id, name) VALUES (1, 'x') ON CONFLICT (id) DO UPDATE SET name = = 'y'\n" +
+				"                                                                                         ^",
+		},
+	} {
+		_, err := runQuery(database, tt.query)
+		var syntaxError *SyntaxError
+		if !errors.As(err, &syntaxError) || err.Error() != tt.want {
+			t.Errorf("%q: error\n%v\nwant\n%s", tt.query, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// transactionStatement is a statement that controls a transaction, which
+// the SQL parser does not understand: BEGIN, COMMIT, ROLLBACK, SAVEPOINT,
+// RELEASE or ROLLBACK TO, with the savepoint it names.
+type transactionStatement struct {
+	// verb is begin, commit, rollback, savepoint, release or rollback to
+	verb      string
+	savepoint string
+}
+
+// parseTransaction recognises a transaction control statement, reporting
+// false for anything else. END is COMMIT, and BEGIN's DEFERRED, IMMEDIATE
+// and EXCLUSIVE all start the same transaction, there being no other
+// connection to lock out.
+func parseTransaction(query string) (*transactionStatement, bool, error) {
+	text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	tokens := definitionTokens(text)
+	if len(tokens) == 0 {
+		return nil, false, nil
+	}
+
+	verb := strings.ToLower(tokens[0])
+	rest := tokens[1:]
+	skip := func(word string) {
+		if len(rest) > 0 && strings.EqualFold(rest[0], word) {
+			rest = rest[1:]
+		}
+	}
+	switch verb {
+	case "begin":
+		if len(rest) > 0 && slices.Contains([]string{"deferred", "immediate", "exclusive"}, strings.ToLower(rest[0])) {
+			rest = rest[1:]
+		}
+		skip("TRANSACTION")
+	case "commit", "end":
+		verb = "commit"
+		skip("TRANSACTION")
+	case "savepoint":
+	case "release":
+		skip("SAVEPOINT")
+	case "rollback":
+		skip("TRANSACTION")
+		if len(rest) > 0 && strings.EqualFold(rest[0], "TO") {
+			verb, rest = "rollback to", rest[1:]
+			skip("SAVEPOINT")
+		}
+	default:
+		return nil, false, nil
+	}
+
+	switch {
+	case verb == "begin" || verb == "commit" || verb == "rollback":
+		if len(rest) == 0 {
+			return &transactionStatement{verb: verb}, true, nil
+		}
+	case len(rest) == 1:
+		return &transactionStatement{verb: verb, savepoint: unquoteIdentifier(rest[0])}, true, nil
+	}
+	return nil, true, fmt.Errorf("parse query: malformed %s statement %q", strings.ToUpper(verb), query)
+}
+
+// savepoint is a named point in the open transaction that ROLLBACK TO
+// returns to.
+type savepoint struct {
+	name     string
+	snapshot db.Snapshot
+}
+
+// transaction is a write transaction left open across statements. Its
+// pager holds every change until the transaction commits.
+type transaction struct {
+	pager      *db.Pager
+	savepoints []savepoint
+	// explicit is set for a transaction started by BEGIN, which only
+	// COMMIT or ROLLBACK ends
+	explicit bool
+}
+
+// executeTransaction runs a transaction control statement. BEGIN starts a
+// transaction that lasts until COMMIT or ROLLBACK; SAVEPOINT outside a
+// transaction starts one, which releasing that savepoint also commits.
+// ROLLBACK TO undoes everything since the savepoint, which stays open;
+// RELEASE forgets the savepoint and every later one.
+func (database *Database) executeTransaction(statement *transactionStatement) error {
+	tx := database.transaction
+	switch statement.verb {
+	case "begin":
+		if tx != nil {
+			return errors.New("cannot start a transaction within a transaction")
+		}
+		return database.beginTransaction(true)
+	case "commit":
+		if tx == nil {
+			return errors.New("cannot commit - no transaction is active")
+		}
+		return database.commitTransaction()
+	case "rollback":
+		if tx == nil {
+			return errors.New("cannot rollback - no transaction is active")
+		}
+		database.rollbackTransaction()
+		return nil
+	case "savepoint":
+		if tx == nil {
+			if err := database.beginTransaction(false); err != nil {
+				return err
+			}
+			tx = database.transaction
+		}
+		tx.savepoints = append(tx.savepoints, savepoint{name: statement.savepoint, snapshot: tx.pager.Snapshot()})
+		return nil
+	}
+
+	position := -1
+	if tx != nil {
+		for i := len(tx.savepoints) - 1; i >= 0; i-- {
+			if strings.EqualFold(tx.savepoints[i].name, statement.savepoint) {
+				position = i
+				break
+			}
+		}
+	}
+	if position < 0 {
+		return fmt.Errorf("no such savepoint: %s", statement.savepoint)
+	}
+
+	if statement.verb == "rollback to" {
+		tx.pager.Restore(tx.savepoints[position].snapshot)
+		tx.savepoints = tx.savepoints[:position+1]
+		database.schemaObjects = nil
+		database.forgetSchemas()
+		return nil
+	}
+
+	tx.savepoints = tx.savepoints[:position]
+	if len(tx.savepoints) > 0 || tx.explicit {
+		return nil
+	}
+	return database.commitTransaction()
+}
+
+// beginTransaction opens a transaction, which holds a shared lock until it
+// ends so that no other process commits underneath it.
+func (database *Database) beginTransaction(explicit bool) error {
+	if err := database.file.LockShared(); err != nil {
+		return err
+	}
+	database.transaction = &transaction{pager: db.NewPager(database.file, database.header), explicit: explicit}
+	return nil
+}
+
+// commitTransaction writes the open transaction's changes to the file,
+// rolling them back if that fails. A commit that finds the database locked
+// leaves the transaction open, as SQLite does, so it can be retried.
+func (database *Database) commitTransaction() error {
+	tx := database.transaction
+	if err := tx.pager.Commit(); errors.Is(err, db.ErrBusy) {
+		return err
+	} else if err != nil {
+		database.rollbackTransaction()
+		return fmt.Errorf("commit: %w", err)
+	}
+	database.transaction = nil
+	database.file.UnlockShared()
+	return nil
+}
+
+// rollbackTransaction discards the open transaction's changes.
+func (database *Database) rollbackTransaction() {
+	database.transaction.pager.Rollback()
+	database.transaction = nil
+	database.file.UnlockShared()
+	database.schemaObjects = nil
+	database.forgetSchemas()
+}
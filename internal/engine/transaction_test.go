@@ -0,0 +1,223 @@
+package engine
+
+import (
+	"fmt"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSavepoints(t *testing.T) {
+	path := upsertDatabase(t)
+	database := openDatabase(t, path)
+	sqlite3, _ := exec.LookPath("sqlite3")
+
+	for _, query := range []string{
+		"SAVEPOINT outer",
+		"INSERT INTO t VALUES (3, 'p', 1, 1)",
+		"SAVEPOINT inner",
+		"INSERT INTO t VALUES (4, 'q', 1, 1)",
+		"ROLLBACK TO inner",
+		// The savepoint survives ROLLBACK TO, so it can be returned to again
+		"INSERT INTO t VALUES (5, 'r', 1, 1)",
+		"ROLLBACK TRANSACTION TO SAVEPOINT Inner",
+		"INSERT INTO t VALUES (6, 's', 1, 1)",
+		"RELEASE inner",
+	} {
+		if err := execute(t, database, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	// A failing statement undoes only its own changes
+	if err := execute(t, database, "INSERT INTO t VALUES (7, 't', 1, 1), (8, 'p', 1, 1)"); err == nil || err.Error() != "UNIQUE constraint failed: t.a" {
+		t.Fatalf("conflicting insert in a savepoint: %v", err)
+	}
+	got := queryRows(t, database, "SELECT id FROM t")
+	if want := [][]any{{int64(1)}, {int64(2)}, {int64(3)}, {int64(6)}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows inside the transaction\n got %v\nwant %v", got, want)
+	}
+
+	// Nothing reaches the file until the outermost savepoint is released
+	if sqlite3 != "" {
+		output, err := exec.Command(sqlite3, path, "SELECT count(*) FROM t").Output()
+		if err != nil || string(output) != "2\n" {
+			t.Fatalf("sqlite3 during the transaction: %q %v", output, err)
+		}
+	}
+	if err := execute(t, database, "RELEASE SAVEPOINT outer"); err != nil {
+		t.Fatal(err)
+	}
+	database.Close()
+
+	if sqlite3 != "" {
+		output, err := exec.Command(sqlite3, path, "PRAGMA integrity_check", "SELECT group_concat(id) FROM (SELECT id FROM t ORDER BY id)").Output()
+		if err != nil || string(output) != "ok\n1,2,3,6\n" {
+			t.Fatalf("sqlite3 after RELEASE: %q %v", output, err)
+		}
+	}
+}
+
+func TestSavepointErrors(t *testing.T) {
+	database := openDatabase(t, upsertDatabase(t))
+
+	tests := []struct {
+		query, err string
+	}{
+		{"RELEASE a", "no such savepoint: a"},
+		{"ROLLBACK TO a", "no such savepoint: a"},
+		{"SAVEPOINT", `parse query: malformed SAVEPOINT statement "SAVEPOINT"`},
+	}
+	for _, test := range tests {
+		err := execute(t, database, test.query)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: error %v, want %q", test.query, err, test.err)
+		}
+	}
+
+	if err := execute(t, database, "SAVEPOINT a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := execute(t, database, "RELEASE b"); err == nil || err.Error() != "no such savepoint: b" {
+		t.Fatalf("RELEASE of an unknown savepoint: %v", err)
+	}
+	if err := execute(t, database, "PRAGMA journal_mode = wal"); err == nil || err.Error() != "cannot change into wal mode from within a transaction" {
+		t.Fatalf("journal mode change in a transaction: %v", err)
+	}
+	// Closing with a transaction open discards it
+	if err := execute(t, database, "INSERT INTO t VALUES (3, 'p', 1, 1)"); err != nil {
+		t.Fatal(err)
+	}
+	database.Close()
+}
+
+func TestBeginCommitRollback(t *testing.T) {
+	path := upsertDatabase(t)
+	database := openDatabase(t, path)
+
+	for _, query := range []string{
+		"BEGIN",
+		"INSERT INTO t VALUES (3, 'p', 1, 1)",
+		"ROLLBACK",
+		"BEGIN IMMEDIATE TRANSACTION",
+		"INSERT INTO t VALUES (4, 'q', 1, 1)",
+		"SAVEPOINT a",
+		"INSERT INTO t VALUES (5, 'r', 1, 1)",
+		// Releasing the last savepoint leaves a transaction BEGIN started open
+		"RELEASE a",
+		"INSERT INTO t VALUES (6, 's', 1, 1)",
+		"COMMIT",
+		"SAVEPOINT b",
+		"INSERT INTO t VALUES (7, 't', 1, 1)",
+		"ROLLBACK TRANSACTION",
+		"SAVEPOINT c",
+		"INSERT INTO t VALUES (8, 'u', 1, 1)",
+		"END",
+	} {
+		if err := execute(t, database, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	tests := []struct {
+		query, err string
+	}{
+		{"COMMIT", "cannot commit - no transaction is active"},
+		{"ROLLBACK", "cannot rollback - no transaction is active"},
+		{"BEGIN WORK", `parse query: malformed BEGIN statement "BEGIN WORK"`},
+	}
+	for _, test := range tests {
+		err := execute(t, database, test.query)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: error %v, want %q", test.query, err, test.err)
+		}
+	}
+	if err := execute(t, database, "BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	if err := execute(t, database, "BEGIN"); err == nil || err.Error() != "cannot start a transaction within a transaction" {
+		t.Fatalf("nested BEGIN: %v", err)
+	}
+	database.Close()
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		output, err := exec.Command(sqlite3, path, "PRAGMA integrity_check", "SELECT group_concat(id) FROM (SELECT id FROM t ORDER BY id)").Output()
+		if err != nil || string(output) != "ok\n1,2,4,5,6,8\n" {
+			t.Fatalf("sqlite3 after transactions: %q %v", output, err)
+		}
+	}
+}
+
+// holdLock runs sqlite3 statements against path in the background, sleeping
+// after the first so that it holds the lock that takes while the test
+// continues, and returns once the lock is held.
+func holdLock(t *testing.T, sqlite3, path, first string, rest ...string) *exec.Cmd {
+	t.Helper()
+
+	args := append([]string{path, ".timeout 5000", first, ".shell sleep 0.3"}, rest...)
+	cmd := exec.Command(sqlite3, args...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cmd.Wait() })
+
+	// Wait until a write from this process is refused
+	probe := openDatabase(t, path)
+	defer probe.Close()
+	for attempt := range 200 {
+		if err := execute(t, probe, fmt.Sprintf("PRAGMA user_version = %d", attempt+1)); err != nil && err.Error() == "database is locked" {
+			return cmd
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("sqlite3 never took its lock")
+	return nil
+}
+
+func TestBusyTimeout(t *testing.T) {
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found in PATH")
+	}
+	path := upsertDatabase(t)
+	database := openDatabase(t, path)
+
+	// Without a timeout, a locked database fails at once
+	locker := holdLock(t, sqlite3, path, "BEGIN EXCLUSIVE", "COMMIT")
+	if err := execute(t, database, "SELECT id FROM t"); err == nil || err.Error() != "database is locked" {
+		t.Fatalf("read during an exclusive lock: %v", err)
+	}
+	if err := locker.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// With one, the statement waits for the writer and sees its change
+	if rows := queryRows(t, database, "PRAGMA busy_timeout = 5000"); !reflect.DeepEqual(rows, [][]any{{int64(5000)}}) {
+		t.Fatalf("busy_timeout = %v", rows)
+	}
+	writer := holdLock(t, sqlite3, path, "BEGIN IMMEDIATE", "INSERT INTO t VALUES (3, 'p', 1, 1)", "COMMIT")
+	if err := execute(t, database, "INSERT INTO t VALUES (4, 'q', 1, 1)"); err != nil {
+		t.Fatalf("insert while sqlite3 writes: %v", err)
+	}
+	if err := writer.Wait(); err != nil {
+		t.Fatalf("sqlite3 commit: %v", err)
+	}
+	if rows := queryRows(t, database, "SELECT id FROM t"); !reflect.DeepEqual(rows, [][]any{{int64(1)}, {int64(2)}, {int64(3)}, {int64(4)}}) {
+		t.Fatalf("rows after both writers = %v", rows)
+	}
+
+	// An open result set keeps other processes from committing
+	resultSet, err := database.Query("SELECT id FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output, err := exec.Command(sqlite3, path, "INSERT INTO t VALUES (5, 'r', 1, 1)").CombinedOutput(); err == nil {
+		t.Fatalf("sqlite3 wrote during a read: %q", output)
+	}
+	resultSet.Close()
+	database.Close()
+	if output, err := exec.Command(sqlite3, path, "PRAGMA integrity_check", "INSERT INTO t VALUES (5, 'r', 1, 1)", "SELECT count(*) FROM t").CombinedOutput(); err != nil || string(output) != "ok\n5\n" {
+		t.Fatalf("sqlite3 after the read: %q %v", output, err)
+	}
+}
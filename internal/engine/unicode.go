@@ -0,0 +1,31 @@
+package engine
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// SetUnicode turns on or off Unicode case. While on, upper() and lower()
+// apply Unicode's full case mappings, so that upper('straße') is 'STRASSE'
+// and a final sigma lowers to ς, and LIKE matches a letter with any its
+// simple case folding pairs it with, so that 'ΟΔΟΣ' LIKE 'οδος' holds;
+// off, as by default in SQLite, only ASCII letters have a case. Collations, NOCASE among
+// them, are unchanged.
+func (database *Database) SetUnicode(on bool) {
+	database.functionState().unicode = on
+}
+
+// unicodeCase reports whether upper, lower and LIKE use Unicode case.
+func (state *functions) unicodeCase() bool {
+	return state != nil && state.unicode
+}
+
+// unicodeUpper and unicodeLower map text to one case with the rules of no
+// particular language. A Caser keeps state, so each call makes its own.
+func unicodeUpper(text string) string {
+	return cases.Upper(language.Und).String(text)
+}
+
+func unicodeLower(text string) string {
+	return cases.Lower(language.Und).String(text)
+}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestUnicodeCase(t *testing.T) {
+	database := openDatabase(t, testgen.New(testgen.Options{}).WriteTemp(t))
+	query := "SELECT upper('café ωμέγα'), lower('ÉCOLE Ж'), 'ÉCOLE' LIKE 'éc%', 'Ωμέγα' LIKE 'ωΜΈΓΑ', 'ab' LIKE 'A_', 'Ab' = 'ab'"
+	if got, want := queryRows(t, database, query), [][]any{{"CAFé ωμέγα", "École Ж", int64(0), int64(0), int64(1), int64(0)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ASCII case: got %v, want %v", got, want)
+	}
+	database.SetUnicode(true)
+	if got, want := queryRows(t, database, query), [][]any{{"CAFÉ ΩΜΈΓΑ", "école ж", int64(1), int64(1), int64(1), int64(0)}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unicode case: got %v, want %v", got, want)
+	}
+
+	// ß maps to two letters, and a sigma ending a word lowers to ς. LIKE
+	// compares a character at a time, so _ matches ß and ﬁ, which fold to
+	// two letters, as one character, and ß does not match ss
+	for query, want := range map[string]any{
+		"SELECT upper('straße')":             "STRASSE",
+		"SELECT lower('STRASSE')":            "strasse",
+		"SELECT lower('ΟΔΟΣ ΣΑΣ')":           "οδος σας",
+		"SELECT 'Straße' LIKE 'STRA%E'":      int64(1),
+		"SELECT 'οδος' LIKE 'ΟΔΟΣ'":          int64(1),
+		"SELECT 'ΟΔΟΣ' LIKE 'οδοσ'":          int64(1),
+		"SELECT 'ß' LIKE '_'":                int64(1),
+		"SELECT 'ß' LIKE '__'":               int64(0),
+		"SELECT 'ﬁ' LIKE '_'":                int64(1),
+		"SELECT 'ﬁ' LIKE '__'":               int64(0),
+		"SELECT 'Straße' LIKE 'STRA__E'":     int64(0),
+		"SELECT 'STRASSE' LIKE 'straße'":     int64(0),
+		"SELECT 'Straße' NOT LIKE 'strasse'": int64(1),
+	} {
+		if got := queryRows(t, database, query); !reflect.DeepEqual(got, [][]any{{want}}) {
+			t.Errorf("%s: got %v, want %v", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/xwb1989/sqlparser"
+)
+
+// parseUpdate recognises an UPDATE statement, reporting false for anything
+// else.
+func parseUpdate(query string) (*sqlparser.Update, bool, error) {
+	text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	tokens := scanSQL(text)
+	if len(tokens) == 0 || !tokens[0].keyword("UPDATE") {
+		return nil, false, nil
+	}
+	stmt, err := parseSQL(text)
+	if err != nil {
+		return nil, true, fmt.Errorf("parse query: %w", err)
+	}
+	statement, ok := stmt.(*sqlparser.Update)
+	if !ok {
+		return nil, true, fmt.Errorf("unsupported query type: %T", stmt)
+	}
+	if err := resolveSchemas(statement); err != nil {
+		return nil, true, err
+	}
+	if len(statement.OrderBy) > 0 || statement.Limit != nil {
+		return nil, true, fmt.Errorf("unsupported update clause in %q", query)
+	}
+	return statement, true, nil
+}
+
+// update executes an UPDATE of the rows its WHERE clause holds for, or of
+// every row without one. Each row's assignments are evaluated against the
+// row as it was, and the row is rewritten as changeRow does.
+func (database *Database) update(statement *sqlparser.Update) error {
+	name, err := targetTable(statement.TableExprs)
+	if err != nil {
+		return err
+	}
+	if isSchemaTable(name.Name.String()) {
+		return fmt.Errorf("table %s may not be modified", name.Name.String())
+	}
+	owner, table, err := database.lookupTable(name.Qualifier.String(), name.Name.String())
+	if err != nil {
+		return err
+	}
+	if owner != database {
+		statement.TableExprs = sqlparser.TableExprs{&sqlparser.AliasedTableExpr{Expr: sqlparser.TableName{Name: name.Name}}}
+		return owner.update(statement)
+	}
+	for _, assignment := range statement.Exprs {
+		if _, ok := table.ColumnIndex(assignment.Name.Name.String()); !ok && !isRowIDName(assignment.Name.Name.String()) {
+			return fmt.Errorf("%w: %s", ErrNoSuchColumn, assignment.Name.Name.String())
+		}
+		if err := resolveColumns(assignment.Expr, table); err != nil {
+			return err
+		}
+	}
+	if statement.Where != nil {
+		if err := resolveColumns(statement.Where.Expr, table); err != nil {
+			return err
+		}
+	}
+
+	rowIDs, err := database.matchingRows(table, statement.Where)
+	if err != nil {
+		return err
+	}
+	return database.write(func(pager *db.Pager) error {
+		var pending pendingKeys
+		for _, rowID := range rowIDs {
+			// A row an earlier row's cascade deleted is not updated
+			stored, err := database.file.SeekRowID(database.header, table.RootPage, rowID)
+			if err != nil {
+				return err
+			}
+			if stored == nil {
+				continue
+			}
+			existing, err := database.readRow(table, rowID)
+			if err != nil {
+				return err
+			}
+			updatedRowID, updated, err := database.assign(table, statement.Exprs, rowID, existing, rowColumns(table, rowID, existing))
+			if err != nil {
+				return err
+			}
+			if err := database.changeRow(pager, &pending, table, rowID, existing, updatedRowID, updated); err != nil {
+				return err
+			}
+		}
+		return pending.check(database)
+	})
+}
+
+// assign evaluates an UPDATE's or upsert's assignments, resolving columns
+// through resolve, and returns the row they make of an existing one, along
+// with its rowid, which an assignment to the rowid or its alias changes.
+func (database *Database) assign(table *TableSchema, assignments sqlparser.UpdateExprs, rowID int64, existing []any, resolve columnResolver) (int64, []any, error) {
+	updated := slices.Clone(existing)
+	var newRowID any = rowID
+	for _, assignment := range assignments {
+		value, err := evaluate(assignment.Expr, database.scope(resolve))
+		if err != nil {
+			return 0, nil, err
+		}
+		position, ok := table.ColumnIndex(assignment.Name.Name.String())
+		if !ok {
+			newRowID = value
+			continue
+		}
+		updated[position] = applyAffinity(value, table.Columns[position].Affinity)
+		if position == table.RowIDAlias {
+			newRowID = updated[position]
+		}
+	}
+	updatedRowID, ok := applyAffinity(newRowID, AffinityInteger).(int64)
+	if !ok {
+		return 0, nil, fmt.Errorf("datatype mismatch")
+	}
+	if table.RowIDAlias >= 0 {
+		updated[table.RowIDAlias] = updatedRowID
+	}
+	return updatedRowID, updated, nil
+}
+
+// changeRow rewrites the row at rowID as updated, at updatedRowID. The old
+// row and its index entries are removed before the new row is checked
+// against the table's constraints, so that it does not conflict with
+// itself, and once it is written the actions of the foreign keys referring
+// to its old values are carried out.
+func (database *Database) changeRow(pager *db.Pager, pending *pendingKeys, table *TableSchema, rowID int64, existing []any, updatedRowID int64, updated []any) error {
+	if err := database.deleteRow(pager, table, rowID); err != nil {
+		return err
+	}
+	if err := checkColumns(table, updatedRowID, updated); err != nil {
+		return err
+	}
+	conflict, err := database.findConflict(table, updatedRowID, updated)
+	if err != nil {
+		return err
+	}
+	if conflict != nil {
+		return conflict.err(table)
+	}
+	if err := database.checkChangedForeignKeys(table, existing, updated); err != nil {
+		return err
+	}
+	if err := writeRow(pager, table, updatedRowID, updated); err != nil {
+		return err
+	}
+	database.rowChanged(OpUpdate, table.Name, updatedRowID)
+	return database.parentChanged(pager, pending, table, existing, updated)
+}
+
+// checkChangedForeignKeys verifies the foreign keys of an updated row whose
+// columns the update changed, when foreign keys are enabled. As in SQLite,
+// a key left as it was is not checked, even if the row already broke it.
+func (database *Database) checkChangedForeignKeys(table *TableSchema, existing, updated []any) error {
+	if !database.foreignKeys {
+		return nil
+	}
+	keys, err := database.resolveForeignKeys(table)
+	if err != nil {
+		return err
+	}
+	changed := keys[:0]
+	for _, key := range keys {
+		before, _ := keyValues(existing, key.child)
+		after, _ := keyValues(updated, key.child)
+		if !sameValues(before, after) {
+			changed = append(changed, key)
+		}
+	}
+	violated, err := database.foreignKeyViolations(changed, updated)
+	if err != nil {
+		return err
+	}
+	if len(violated) > 0 {
+		return &ConstraintError{Kind: "FOREIGN KEY"}
+	}
+	return nil
+}
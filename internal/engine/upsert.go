@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/xwb1989/sqlparser"
+)
+
+// upsertClause is an INSERT's ON CONFLICT clause: what to do instead of
+// failing when a row would duplicate a unique key.
+type upsertClause struct {
+	// target is the unique key the clause handles, or empty for any
+	target []string
+	// update holds DO UPDATE's assignments, and is nil for DO NOTHING
+	update sqlparser.UpdateExprs
+	// where limits DO UPDATE to the existing rows it holds for
+	where sqlparser.Expr
+}
+
+// parseUpsert parses what follows ON CONFLICT: an optional conflict target,
+// then DO NOTHING or DO UPDATE SET with an optional WHERE.
+func parseUpsert(text string) (*upsertClause, error) {
+	tokens := scanSQL(text)
+	clause := &upsertClause{}
+	i := 0
+	if len(tokens) > 0 && text[tokens[0].start] == '(' {
+		for i = 1; i < len(tokens) && text[tokens[i].start] != ')'; i++ {
+			// Only the first word of each term names a column; COLLATE and
+			// a sort order may follow it
+			separator := text[tokens[i-1].start]
+			if tokens[i].name != "" && (separator == '(' || separator == ',') {
+				clause.target = append(clause.target, tokens[i].name)
+			}
+		}
+		i++
+		if len(clause.target) == 0 {
+			return nil, fmt.Errorf("empty ON CONFLICT target")
+		}
+	}
+	if i < len(tokens) && tokens[i].keyword("WHERE") {
+		return nil, fmt.Errorf("ON CONFLICT targets on partial indexes are not supported")
+	}
+	if i+1 >= len(tokens) || !tokens[i].keyword("DO") {
+		return nil, fmt.Errorf("malformed ON CONFLICT clause %q", strings.TrimSpace(text))
+	}
+
+	switch {
+	case tokens[i+1].keyword("NOTHING") && i+2 == len(tokens):
+		return clause, nil
+	case tokens[i+1].keyword("UPDATE"):
+		// The assignments parse as an UPDATE of a placeholder table
+		stmt, err := parseSQLAfter("UPDATE excluded ", text[tokens[i+1].end:])
+		var syntaxError *SyntaxError
+		if errors.As(err, &syntaxError) {
+			syntaxError.within(text, tokens[i+1].end)
+		}
+		if err != nil {
+			return nil, err
+		}
+		update, ok := stmt.(*sqlparser.Update)
+		if !ok || len(update.OrderBy) > 0 || update.Limit != nil {
+			return nil, fmt.Errorf("malformed ON CONFLICT clause %q", strings.TrimSpace(text))
+		}
+		clause.update = update.Exprs
+		if update.Where != nil {
+			clause.where = update.Where.Expr
+		}
+		return clause, nil
+	}
+	return nil, fmt.Errorf("malformed ON CONFLICT clause %q", strings.TrimSpace(text))
+}
+
+// validate checks that the clause's target is one of the table's unique
+// keys and that it assigns only the table's columns, as SQLite does when
+// it prepares the statement.
+func (clause *upsertClause) validate(table *TableSchema) error {
+	for _, name := range clause.target {
+		if _, ok := table.ColumnIndex(name); !ok {
+			return fmt.Errorf("%w: %s", ErrNoSuchColumn, name)
+		}
+	}
+	if len(clause.target) > 0 && !isUniqueKey(table, clause.target) {
+		return errors.New("ON CONFLICT clause does not match any PRIMARY KEY or UNIQUE constraint")
+	}
+	for _, assignment := range clause.update {
+		name := assignment.Name.Name.String()
+		if _, ok := table.ColumnIndex(name); !ok && !isRowIDName(name) {
+			return fmt.Errorf("%w: %s", ErrNoSuchColumn, name)
+		}
+	}
+	return nil
+}
+
+// handles reports whether the clause resolves a conflict on key, where a
+// nil key is the rowid.
+func (clause *upsertClause) handles(table *TableSchema, key []string) bool {
+	if len(clause.target) == 0 {
+		return true
+	}
+	if key == nil {
+		if table.RowIDAlias < 0 {
+			return false
+		}
+		key = []string{table.Columns[table.RowIDAlias].Name}
+	}
+	return slices.Equal(normalizedColumns(clause.target), normalizedColumns(key))
+}
+
+// applyUpsert carries out the clause for a proposed row that conflicts
+// with the row at rowID. DO UPDATE evaluates its assignments and WHERE
+// against the existing row, with the proposed one as "excluded", and
+// writes the result as an UPDATE would.
+func (database *Database) applyUpsert(pager *db.Pager, pending *pendingKeys, table *TableSchema, clause *upsertClause, rowID int64, proposedRowID int64, proposed []any) error {
+	if clause.update == nil {
+		return nil
+	}
+	existing, err := database.readRow(table, rowID)
+	if err != nil {
+		return err
+	}
+	current, excluded := rowColumns(table, rowID, existing), rowColumns(table, proposedRowID, proposed)
+	resolve := func(name string) (operand, error) {
+		if qualifier, column, ok := strings.Cut(name, "."); ok && strings.EqualFold(qualifier, "excluded") {
+			return excluded(column)
+		}
+		return current(name)
+	}
+	if clause.where != nil {
+		holds, err := evaluateTruth(clause.where, database.scope(resolve))
+		if err != nil || holds != truthy {
+			return err
+		}
+	}
+
+	updatedRowID, updated, err := database.assign(table, clause.update, rowID, existing, resolve)
+	if err != nil {
+		return err
+	}
+	return database.changeRow(pager, pending, table, rowID, existing, updatedRowID, updated)
+}
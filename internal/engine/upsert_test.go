@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+// upsertDatabase generates a table with a rowid alias and a unique column,
+// holding rows (1, 'x', 1) and (2, 'y', 2).
+func upsertDatabase(t *testing.T) string {
+	t.Helper()
+
+	database := testgen.New(testgen.Options{})
+	table := database.CreateTable("t", "CREATE TABLE t (id integer primary key, a unique, b not null default 9, c check (c > 0))")
+	table.Insert(1, nil, "x", int64(1), int64(1))
+	table.Insert(2, nil, "y", int64(2), int64(1))
+	database.CreateIndex("sqlite_autoindex_t_1", table, "", 1)
+	return database.WriteTemp(t)
+}
+
+func TestInsertConflictResolution(t *testing.T) {
+	path := upsertDatabase(t)
+	database := openDatabase(t, path)
+
+	for _, query := range []string{
+		// The row with a = 'x' is deleted, and b's NULL becomes its default
+		"INSERT OR REPLACE INTO t VALUES (3, 'x', NULL, 1)",
+		"INSERT OR IGNORE INTO t VALUES (4, 'q', 1, 0)",
+		"INSERT OR IGNORE INTO t VALUES (5, 'q', NULL, 1)",
+		"INSERT OR IGNORE INTO t VALUES (2, 'q', 1, 1)",
+		"REPLACE INTO t (id, a, b, c) VALUES (2, 'z', 4, 1)",
+	} {
+		if err := execute(t, database, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	if err := execute(t, database, "INSERT OR REPLACE INTO t VALUES (6, 'w', 1, 0)"); err == nil || err.Error() != "CHECK constraint failed: c > 0" {
+		t.Fatalf("REPLACE of a failing CHECK: %v", err)
+	}
+
+	got := queryRows(t, database, "SELECT id, a, b FROM t")
+	want := [][]any{{int64(2), "z", int64(4)}, {int64(3), "x", int64(9)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows after conflicts\n got %v\nwant %v", got, want)
+	}
+	database.Close()
+
+	if sqlite3, err := exec.LookPath("sqlite3"); err == nil {
+		output, err := exec.Command(sqlite3, path, "PRAGMA integrity_check", "SELECT id FROM t WHERE a = 'x'").Output()
+		if err != nil || string(output) != "ok\n3\n" {
+			t.Fatalf("sqlite3 after conflicts: %q %v", output, err)
+		}
+	}
+}
+
+func TestInsertOnConflict(t *testing.T) {
+	database := openDatabase(t, upsertDatabase(t))
+
+	for _, query := range []string{
+		"INSERT INTO t VALUES (3, 'x', 5, 1) ON CONFLICT (a) DO UPDATE SET b = excluded.b + b, id = 10",
+		"INSERT INTO t VALUES (7, 'y', 5, 1) ON CONFLICT (a) DO UPDATE SET b = 3 WHERE b > 100",
+		// The second row conflicts with the first, which it updates
+		"INSERT INTO t VALUES (8, 'z', 5, 1), (9, 'z', 6, 1) ON CONFLICT (a) DO UPDATE SET b = excluded.b",
+		"INSERT INTO t VALUES (2, 'q', 1, 1) ON CONFLICT (id) DO UPDATE SET b = t.b + 1",
+		"INSERT INTO t VALUES (2, 'q', 1, 1) ON CONFLICT DO NOTHING",
+	} {
+		if err := execute(t, database, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	got := queryRows(t, database, "SELECT id, a, b FROM t")
+	want := [][]any{{int64(2), "y", int64(3)}, {int64(8), "z", int64(6)}, {int64(10), "x", int64(6)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows after upserts\n got %v\nwant %v", got, want)
+	}
+	if rows := queryRows(t, database, "SELECT id FROM t WHERE a = 'x'"); !reflect.DeepEqual(rows, [][]any{{int64(10)}}) {
+		t.Fatalf("index lookup of the updated row = %v", rows)
+	}
+
+	tests := []struct {
+		query, err string
+	}{
+		// Conflicts on another key are not handled by the clause
+		{"INSERT INTO t VALUES (2, 'new', 1, 1) ON CONFLICT (a) DO NOTHING", "UNIQUE constraint failed: t.id"},
+		{"INSERT INTO t VALUES (11, 'x', 1, 1) ON CONFLICT (a) DO UPDATE SET a = 'y'", "UNIQUE constraint failed: t.a"},
+		{"INSERT INTO t VALUES (11, 'x', 1, 1) ON CONFLICT (b) DO NOTHING", "ON CONFLICT clause does not match any PRIMARY KEY or UNIQUE constraint"},
+		{"INSERT INTO t VALUES (11, 'x', 1, 1) ON CONFLICT (zz) DO NOTHING", "no such column: zz"},
+	}
+	for _, test := range tests {
+		err := execute(t, database, test.query)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: error %v, want %q", test.query, err, test.err)
+		}
+	}
+}
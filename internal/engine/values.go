@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// constantRows is a SELECT that names no table or a VALUES statement: rows
+// of expressions evaluated without reading any table.
+type constantRows struct {
+	columns []string
+	rows    [][]sqlparser.Expr
+	// where keeps the rows, all or none of them, when it holds
+	where sqlparser.Expr
+	limit int64
+}
+
+// parseValues recognises a VALUES statement, reporting false for anything
+// else. The parser only reads VALUES as the rows of an INSERT, so it is
+// read as one. Columns are named column1, column2 and so on, as in SQLite.
+func parseValues(query string) (*constantRows, bool, error) {
+	text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	tokens := scanSQL(text)
+	if len(tokens) == 0 || !tokens[0].keyword("VALUES") {
+		return nil, false, nil
+	}
+
+	stmt, err := parseSQLAfter("INSERT INTO values_statement ", text)
+	if err != nil {
+		return nil, true, fmt.Errorf("parse query: %w", err)
+	}
+	insert, ok := stmt.(*sqlparser.Insert)
+	if !ok {
+		return nil, true, fmt.Errorf("parse query: %s", text)
+	}
+	values, ok := insert.Rows.(sqlparser.Values)
+	if !ok || insert.OnDup != nil {
+		return nil, true, fmt.Errorf("parse query: %s", text)
+	}
+
+	statement := &constantRows{limit: -1}
+	for i := range values[0] {
+		statement.columns = append(statement.columns, fmt.Sprintf("column%d", i+1))
+	}
+	for _, tuple := range values {
+		if len(tuple) != len(values[0]) {
+			return nil, true, fmt.Errorf("all VALUES must have the same number of terms")
+		}
+		statement.rows = append(statement.rows, tuple)
+	}
+	return statement, true, nil
+}
+
+// parseConstantSelect recognises a SELECT that names no table, reporting
+// false for anything else, including SELECTs the parser cannot read, which
+// are left to parseSelect to report.
+func (database *Database) parseConstantSelect(query string) (*constantRows, bool, error) {
+	tokens := scanSQL(query)
+	if len(tokens) == 0 || !tokens[0].keyword("SELECT") {
+		return nil, false, nil
+	}
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		return nil, false, nil
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		return nil, false, nil
+	}
+	statement, ok, err := database.constantSelect(sel)
+	if !ok || err != nil {
+		return statement, ok, err
+	}
+
+	// Columns without an alias are named by their text as written, as in
+	// SQLite, rather than as the parser renders it
+	if texts := selectListText(query, tokens); len(texts) == len(sel.SelectExprs) {
+		for i, expr := range sel.SelectExprs {
+			if expr.(*sqlparser.AliasedExpr).As.IsEmpty() {
+				statement.columns[i] = texts[i]
+			}
+		}
+	}
+	return statement, true, nil
+}
+
+// selectListText splits the text of a SELECT's select list at its commas,
+// ending it at the first clause that follows.
+func selectListText(query string, tokens []sqlToken) []string {
+	var texts []string
+	first, depth := 1, 0
+	for i := 1; i <= len(tokens); i++ {
+		if i < len(tokens) {
+			token := tokens[i]
+			switch query[token.start] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			clause := token.keyword("FROM") || token.keyword("WHERE") || token.keyword("GROUP") || token.keyword("ORDER") || token.keyword("LIMIT")
+			if depth > 0 || query[token.start] != ',' && !clause {
+				continue
+			}
+		}
+		if first < i {
+			texts = append(texts, query[tokens[first].start:tokens[i-1].end])
+		}
+		if i == len(tokens) || query[tokens[i].start] != ',' {
+			break
+		}
+		first = i + 1
+	}
+	return texts
+}
+
+// constantSelect reads a SELECT that names no table. The parser takes a
+// SELECT without FROM to read MySQL's dual, so one reading dual is taken to
+// name no table unless the database has a table of that name.
+func (database *Database) constantSelect(sel *sqlparser.Select) (*constantRows, bool, error) {
+	if len(sel.From) != 1 {
+		return nil, false, nil
+	}
+	ate, ok := sel.From[0].(*sqlparser.AliasedTableExpr)
+	if !ok || !ate.As.IsEmpty() {
+		return nil, false, nil
+	}
+	name, ok := ate.Expr.(sqlparser.TableName)
+	if !ok || !name.Qualifier.IsEmpty() || !strings.EqualFold(name.Name.String(), "dual") {
+		return nil, false, nil
+	}
+	if _, err := database.TableSchema(name.Name.String()); err == nil {
+		return nil, false, nil
+	}
+
+	if len(sel.GroupBy) > 0 || sel.Having != nil {
+		return nil, true, fmt.Errorf("unsupported select clause in %q", sqlparser.String(sel))
+	}
+	statement := &constantRows{limit: -1}
+	row := make([]sqlparser.Expr, 0, len(sel.SelectExprs))
+	for _, expr := range sel.SelectExprs {
+		aliased, ok := expr.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, true, fmt.Errorf("no tables specified")
+		}
+		column := sqlparser.String(aliased.Expr)
+		if !aliased.As.IsEmpty() {
+			column = aliased.As.String()
+		}
+		statement.columns = append(statement.columns, column)
+		row = append(row, aliased.Expr)
+	}
+	statement.rows = [][]sqlparser.Expr{row}
+	if sel.Where != nil {
+		statement.where = sel.Where.Expr
+	}
+	if sel.Limit != nil {
+		if sel.Limit.Offset != nil {
+			return nil, true, fmt.Errorf("LIMIT offsets are not supported")
+		}
+		limit, err := parseLimit(sel.Limit.Rowcount)
+		if err != nil {
+			return nil, true, err
+		}
+		statement.limit = limit
+	}
+	return statement, true, nil
+}
+
+// evaluate computes the rows in a scope without columns, or none when the
+// WHERE clause fails to hold.
+func (statement *constantRows) evaluate(in scope) ([][]any, error) {
+	if statement.where != nil {
+		holds, err := evaluateTruth(statement.where, in)
+		if err != nil {
+			return nil, err
+		}
+		if holds != truthy {
+			return nil, nil
+		}
+	}
+	rows := statement.rows
+	if statement.limit >= 0 && int64(len(rows)) > statement.limit {
+		rows = rows[:statement.limit]
+	}
+	values := make([][]any, len(rows))
+	for i, row := range rows {
+		values[i] = make([]any, len(row))
+		for j, expr := range row {
+			value, err := evaluate(expr, in)
+			if err != nil {
+				return nil, err
+			}
+			values[i][j] = value
+		}
+	}
+	return values, nil
+}
+
+// selectConstants returns the rows of a SELECT naming no table or of a
+// VALUES statement. Each expression is evaluated before the first row is
+// returned, so that an error in any row is reported before any are read.
+func selectConstants(statement *constantRows, in scope) (*ResultSet, error) {
+	values, err := statement.evaluate(in)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]ResultColumn, len(statement.columns))
+	for i, name := range statement.columns {
+		columns[i] = ResultColumn{Name: name}
+	}
+	return newResultSet(columns, func(yield func([]any, error) bool) {
+		for _, row := range values {
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}, nil), nil
+}
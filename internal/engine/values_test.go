@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConstantRowsMatchSQLite(t *testing.T) {
+	path := ordersDatabase(t)
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+
+	for _, query := range []string{
+		"SELECT 1+1, 'hello'",
+		"SELECT 7 / 2, 7.0 / 2, -3 % 2, 'ab', NULL",
+		"SELECT 1 WHERE 2 > 1",
+		"SELECT 1 WHERE NULL",
+		"SELECT 'x' LIMIT 0",
+		"VALUES (1, 'a'), (2, 'b'), (3, NULL)",
+		"VALUES (1 + 2 * 3)",
+	} {
+		rows, err := runQuery(openDatabase(t, path), query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		var got strings.Builder
+		for _, row := range rows {
+			fields := make([]string, len(row))
+			for i, value := range row {
+				if value != nil {
+					fields[i] = fmt.Sprint(value)
+				}
+			}
+			fmt.Fprintln(&got, strings.Join(fields, "|"))
+		}
+		want, err := exec.Command(sqlite3, path, query).Output()
+		if err != nil {
+			t.Fatalf("sqlite3 %s: %v", query, err)
+		}
+		if got.String() != string(want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", query, got.String(), want)
+		}
+	}
+}
+
+func TestConstantRowsColumns(t *testing.T) {
+	database := openDatabase(t, ordersDatabase(t))
+	for query, want := range map[string][]ResultColumn{
+		"SELECT 1 AS one, 'hello'":            {{Name: "one"}, {Name: "'hello'"}},
+		"SELECT 1+1, abs( -2 ), (3)  WHERE 1": {{Name: "1+1"}, {Name: "abs( -2 )"}, {Name: "(3)"}},
+		"VALUES (1, 2), (3, 4)":               {{Name: "column1"}, {Name: "column2"}},
+	} {
+		resultSet, err := database.Query(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if !reflect.DeepEqual(resultSet.Columns, want) {
+			t.Errorf("%s: columns %v, want %v", query, resultSet.Columns, want)
+		}
+		resultSet.Close()
+	}
+}
+
+func TestInsertSelectWithoutTable(t *testing.T) {
+	database := openDatabase(t, ordersDatabase(t))
+	if err := execute(t, database, "INSERT INTO customers (name, tag) SELECT 'new customer', upper('tag-9')"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := runQuery(database, "SELECT id, name, tag FROM customers WHERE tag = 'TAG-9'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]any{{int64(51), "new customer", "TAG-9"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("inserted %v, want %v", rows, want)
+	}
+}
+
+func TestConstantRowsErrors(t *testing.T) {
+	database := openDatabase(t, ordersDatabase(t))
+	for query, want := range map[string]string{
+		"SELECT *":              "no tables specified",
+		"SELECT id":             "no such column: id",
+		"VALUES (1, 2), (3)":    "all VALUES must have the same number of terms",
+		"SELECT 1 LIMIT 1, 2":   "LIMIT offsets are not supported",
+		"SELECT 1 WHERE nope":   "no such column: nope",
+		"SELECT 1 LIMIT 'many'": "LIMIT must be an integer",
+	} {
+		if _, err := runQuery(database, query); err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %q", query, err, want)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package engine
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// ChangeEvent is a row change a watcher found committed: a row inserted,
+// updated or deleted in a table, by this connection or another process.
+type ChangeEvent struct {
+	Op    ChangeOp
+	Table string
+	RowID int64
+	// Columns names the values of Row, the row as the change left it,
+	// which is nil for OpDelete
+	Columns []string
+	Row     []any
+}
+
+// Watcher tails the database for committed row changes, turning the reader
+// into a change data capture source. Each poll compares the tables that
+// commits since the last one wrote with the rows the watcher last saw. In
+// WAL mode the pages new frames hold tell which tables those are; outside
+// it, or once a checkpoint has written the database file, every table is
+// compared, since WAL mode leaves the change counter as it was. Rowid tables are watched; the schema and statistics tables and
+// WITHOUT ROWID tables are not.
+type Watcher struct {
+	database *Database
+	// counter, position and modified are the change counter, write-ahead
+	// log position and file modification time as of the last poll
+	counter  uint32
+	position db.WALPosition
+	modified time.Time
+	// tables holds each watched table's state by lowercased name
+	tables map[string]*watchedTable
+	// owners maps the pages of every b-tree the watcher knows of to the
+	// lowercased name of the table a write to them changes, which is empty
+	// for the schema table's pages
+	owners map[uint32]string
+}
+
+// watchedTable is a table as of the last poll: its schema, the pages of its
+// b-tree and indexes, and a hash of each row's record by rowid.
+type watchedTable struct {
+	schema *TableSchema
+	pages  []uint32
+	rows   map[int64]uint64
+}
+
+// Watch starts watching the database for committed row changes, from its
+// contents now. It must be called outside a transaction, as Poll must.
+func (database *Database) Watch() (*Watcher, error) {
+	watcher := &Watcher{database: database, tables: make(map[string]*watchedTable)}
+	if _, err := watcher.poll(true); err != nil {
+		return nil, err
+	}
+	return watcher, nil
+}
+
+// Poll returns the row changes committed since the last poll, table by
+// table in rowid order. A row changed several times between polls is
+// reported once, as it is now, and one inserted and deleted between them
+// not at all. Rows of a table created since are reported as inserted; those
+// of a table dropped since are not reported.
+func (watcher *Watcher) Poll() ([]ChangeEvent, error) {
+	return watcher.poll(false)
+}
+
+// poll compares the tables changed since the last poll with their rows then,
+// or with the first set takes their rows without reporting them.
+func (watcher *Watcher) poll(first bool) ([]ChangeEvent, error) {
+	database := watcher.database
+	if database.transaction != nil {
+		return nil, errors.New("cannot watch within a transaction")
+	}
+	if err := database.file.LockShared(); err != nil {
+		return nil, err
+	}
+	defer database.file.UnlockShared()
+	if err := database.verifySchema(); err != nil {
+		return nil, err
+	}
+
+	position, modified := database.file.WALPosition(), database.modified()
+	if !first && database.header.ChangeCounter == watcher.counter && position == watcher.position && modified.Equal(watcher.modified) {
+		return nil, nil
+	}
+	dirty, all := watcher.dirtyTables()
+	all = all || !modified.Equal(watcher.modified)
+	objects, err := database.SchemaObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	var events []ChangeEvent
+	watched := make(map[string]*watchedTable)
+	for _, object := range objects {
+		if !isRowIDTable(object) {
+			continue
+		}
+		key := strings.ToLower(object.Name)
+		schema, err := database.TableSchema(object.Name)
+		if err != nil {
+			return nil, err
+		}
+		previous := watcher.tables[key]
+		if previous != nil && previous.schema == schema && !all && !dirty[key] {
+			watched[key] = previous
+			continue
+		}
+
+		database.logger.Debug("watch comparing table", "table", object.Name)
+		current, changes, err := database.compareTable(schema, previous, !first)
+		if err != nil {
+			return nil, fmt.Errorf("watch %s: %w", object.Name, err)
+		}
+		watched[key] = current
+		events = append(events, changes...)
+	}
+
+	owners := make(map[uint32]string)
+	err = database.file.WalkBTree(database.header, 1, func(pageNumber uint32, _ *db.Page) error {
+		owners[pageNumber] = ""
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for key, table := range watched {
+		for _, pageNumber := range table.pages {
+			owners[pageNumber] = key
+		}
+	}
+	watcher.tables, watcher.owners = watched, owners
+	watcher.counter, watcher.position, watcher.modified = database.header.ChangeCounter, position, modified
+	return events, nil
+}
+
+// dirtyTables returns the tables that the frames committed to the
+// write-ahead log since the last poll wrote to, or all set when every table
+// must be compared: outside WAL mode, after the log starts over, and when a
+// frame holds a page of no b-tree the watcher knows, such as an overflow
+// page or one newly added to a tree.
+func (watcher *Watcher) dirtyTables() (dirty map[string]bool, all bool) {
+	pages, ok := watcher.database.file.PagesWrittenSince(watcher.position)
+	if !ok {
+		return nil, true
+	}
+	dirty = make(map[string]bool)
+	for _, pageNumber := range pages {
+		owner, known := watcher.owners[pageNumber]
+		if !known {
+			return nil, true
+		}
+		if owner != "" {
+			dirty[owner] = true
+		}
+	}
+	return dirty, false
+}
+
+// modified returns when the database file was last written, or the zero
+// time for an in-memory database.
+func (database *Database) modified() time.Time {
+	path := database.Path()
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// isRowIDTable reports whether a schema object is an ordinary rowid table,
+// one a watcher reports changes to and CopyTo copies, rather than an
+// internal table or a WITHOUT ROWID one.
+func isRowIDTable(object db.TableMetadata) bool {
+	return object.Type == "table" && object.RootPage != 0 &&
+		!strings.HasPrefix(strings.ToLower(object.Name), "sqlite_") &&
+		!strings.Contains(strings.ToUpper(object.SQL), "WITHOUT ROWID")
+}
+
+// compareTable reads every row of a table and returns its state now, along
+// with the changes from its state as of previous, which is nil for a table
+// the watcher has not seen, when report is set.
+func (database *Database) compareTable(table *TableSchema, previous *watchedTable, report bool) (*watchedTable, []ChangeEvent, error) {
+	current := &watchedTable{schema: table, rows: make(map[int64]uint64)}
+	for _, rootPage := range append([]uint32{table.RootPage}, indexRootPages(table)...) {
+		err := database.file.WalkBTree(database.header, rootPage, func(pageNumber uint32, _ *db.Page) error {
+			current.pages = append(current.pages, pageNumber)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var events []ChangeEvent
+	columns := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		columns[i] = column.Name
+	}
+	cursor := database.file.NewCursor(database.header, table.RootPage)
+	if err := cursor.First(); err != nil {
+		return nil, nil, err
+	}
+	for cursor.Valid() {
+		row, err := cursor.Row()
+		if err != nil {
+			return nil, nil, err
+		}
+		hash := recordHash(row)
+		current.rows[row.RowID] = hash
+
+		op := OpInsert
+		if previous != nil {
+			if before, ok := previous.rows[row.RowID]; ok {
+				op = OpUpdate
+				if before == hash {
+					op = 0
+				}
+			}
+		}
+		if report && op != 0 {
+			values := make([]any, len(table.Columns))
+			for i := range values {
+				values[i] = columnValue(row, table, i)
+			}
+			events = append(events, ChangeEvent{Op: op, Table: table.Name, RowID: row.RowID, Columns: columns, Row: values})
+		}
+		if err := cursor.Next(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if report && previous != nil {
+		for rowID := range previous.rows {
+			if _, ok := current.rows[rowID]; !ok {
+				events = append(events, ChangeEvent{Op: OpDelete, Table: table.Name, RowID: rowID})
+			}
+		}
+	}
+	slices.SortFunc(events, func(a, b ChangeEvent) int {
+		return cmp.Compare(a.RowID, b.RowID)
+	})
+	return current, events, nil
+}
+
+// indexRootPages returns the root pages of a table's indexes, whose pages a
+// change to its rows writes as well.
+func indexRootPages(table *TableSchema) []uint32 {
+	var pages []uint32
+	for _, index := range table.Indexes {
+		if index.RootPage != 0 {
+			pages = append(pages, index.RootPage)
+		}
+	}
+	return pages
+}
+
+// recordHash hashes a row's record, its values and their serial types,
+// which tells a changed row from one left as it was.
+func recordHash(row *db.Row) uint64 {
+	hash := fnv.New64a()
+	for _, column := range row.Columns {
+		fmt.Fprintf(hash, "%d:%v;", column.SerialType, column.DecodedValue)
+	}
+	return hash.Sum64()
+}
@@ -0,0 +1,89 @@
+package engine
+
+import (
+	"bytes"
+	"log/slog"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	path := ordersDatabase(t)
+	writer := openDatabase(t, path)
+	queryRows(t, writer, "PRAGMA journal_mode = WAL")
+	// A first commit starts the log the watcher then tails
+	queryRows(t, writer, "PRAGMA user_version = 1")
+	database := openDatabase(t, path)
+	var records bytes.Buffer
+	database.SetLogger(slog.New(slog.NewTextHandler(&records, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	watcher, err := database.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll := func() []ChangeEvent {
+		t.Helper()
+		records.Reset()
+		events, err := watcher.Poll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return events
+	}
+	if events := poll(); events != nil {
+		t.Fatalf("events before any commit: %v", events)
+	}
+
+	// Only the table whose pages the new frames hold is compared
+	if err := execute(t, writer, "INSERT INTO customers VALUES (2, 'renamed', 'tag-2') ON CONFLICT (id) DO UPDATE SET name = excluded.name"); err != nil {
+		t.Fatal(err)
+	}
+	columns := []string{"id", "name", "tag"}
+	want := []ChangeEvent{{Op: OpUpdate, Table: "customers", RowID: 2, Columns: columns, Row: []any{int64(2), "renamed", "tag-2"}}}
+	if events := poll(); !reflect.DeepEqual(events, want) {
+		t.Errorf("after an upsert: %v, want %v", events, want)
+	}
+	if compared := strings.Count(records.String(), "watch comparing table"); compared != 1 || !strings.Contains(records.String(), "table=customers") {
+		t.Errorf("compared %d tables:\n%s", compared, records.String())
+	}
+
+	// Rows written between polls are reported once, as they are now
+	for _, query := range []string{
+		"INSERT INTO customers VALUES (51, 'new', 'tag-3')",
+		"INSERT INTO customers VALUES (51, 'newer', 'tag-3') ON CONFLICT (id) DO UPDATE SET name = excluded.name",
+	} {
+		if err := execute(t, writer, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want = []ChangeEvent{{Op: OpInsert, Table: "customers", RowID: 51, Columns: columns, Row: []any{int64(51), "newer", "tag-3"}}}
+	if events := poll(); !reflect.DeepEqual(events, want) {
+		t.Errorf("after an insert and an upsert: %v, want %v", events, want)
+	}
+	if events := poll(); events != nil {
+		t.Errorf("events with nothing committed since: %v", events)
+	}
+
+	// Deletes by other processes, and the rows of tables created since
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return
+	}
+	sqlite3Lines(t, sqlite3, path, "DELETE FROM orders WHERE id IN (3, 9); CREATE TABLE notes (body text); INSERT INTO notes VALUES ('hi')")
+	want = []ChangeEvent{
+		{Op: OpDelete, Table: "orders", RowID: 3},
+		{Op: OpDelete, Table: "orders", RowID: 9},
+		{Op: OpInsert, Table: "notes", RowID: 1, Columns: []string{"body"}, Row: []any{"hi"}},
+	}
+	if events := poll(); !reflect.DeepEqual(events, want) {
+		t.Errorf("after sqlite3 wrote: %v, want %v", events, want)
+	}
+
+	if err := execute(t, database, "BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := watcher.Poll(); err == nil || err.Error() != "cannot watch within a transaction" {
+		t.Errorf("Poll within a transaction: %v", err)
+	}
+}
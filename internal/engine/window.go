@@ -0,0 +1,554 @@
+package engine
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/xwb1989/sqlparser"
+)
+
+// windowCall is a window function in a select list: ROW_NUMBER(), RANK(),
+// or SUM or COUNT OVER a window of the rows sharing its PARTITION BY
+// columns, in the order of its ORDER BY columns. The SQL parser knows no
+// OVER clause, so calls are cut out of the query before it is parsed and
+// their places taken by placeholder columns.
+type windowCall struct {
+	// text is the call as written, which names its result column
+	text string
+	// function is the lowercased function name
+	function string
+	// argument is the column SUM or COUNT reads, or "" for COUNT(*)
+	argument    string
+	partitionBy []string
+	orderBy     []windowOrder
+	// positions resolved when the query is prepared
+	argumentPosition  int
+	partitionPosition []int
+	// partitionCollations order the text of the partition columns
+	partitionCollations []db.Collation
+}
+
+// windowOrder is one ORDER BY term of a window.
+type windowOrder struct {
+	column     string
+	descending bool
+	position   int
+	collation  db.Collation
+}
+
+// windowPlaceholder is the prefix of the column names standing in for
+// window calls while the rest of the query is parsed.
+const windowPlaceholder = "__window_"
+
+// extractWindows cuts each window function call out of query, returning
+// the query with placeholder columns in their places and the calls by
+// placeholder name.
+func extractWindows(query string) (string, map[string]*windowCall, error) {
+	tokens := scanSQL(query)
+	var rewritten strings.Builder
+	var windows map[string]*windowCall
+	copied := 0
+	for i, token := range tokens {
+		if !token.keyword("OVER") {
+			continue
+		}
+		// The call ends just before OVER: name ( arguments )
+		closing := i - 1
+		if closing < 0 || query[tokens[closing].start:tokens[closing].end] != ")" {
+			return "", nil, fmt.Errorf("parse query: OVER must follow a function call")
+		}
+		opening := matchingParen(query, tokens, closing, -1)
+		if opening < 1 || tokens[opening-1].name == "" || tokens[opening-1].start < copied {
+			return "", nil, fmt.Errorf("parse query: OVER must follow a function call")
+		}
+		if i+1 >= len(tokens) || query[tokens[i+1].start:tokens[i+1].end] != "(" {
+			return "", nil, fmt.Errorf("parse query: named windows are not supported")
+		}
+		end := matchingParen(query, tokens, i+1, 1)
+		if end < 0 {
+			return "", nil, fmt.Errorf("parse query: unterminated window definition")
+		}
+
+		start := tokens[opening-1].start
+		call, err := parseWindowCall(query, tokens[opening-1:end+1])
+		if err != nil {
+			return "", nil, err
+		}
+		call.text = query[start:tokens[end].end]
+		if windows == nil {
+			windows = make(map[string]*windowCall)
+		}
+		name := fmt.Sprintf("%s%d", windowPlaceholder, len(windows))
+		windows[name] = call
+		rewritten.WriteString(query[copied:start])
+		rewritten.WriteString(name)
+		copied = tokens[end].end
+	}
+	rewritten.WriteString(query[copied:])
+	return rewritten.String(), windows, nil
+}
+
+// windowOffset maps an offset in a query extractWindows rewrote back to the
+// query as written, where each placeholder has its call's text instead.
+// An offset within a placeholder maps to the start of the call.
+func windowOffset(rewritten string, windows map[string]*windowCall, offset int) int {
+	shift, from := 0, 0
+	for i := range len(windows) {
+		name := fmt.Sprintf("%s%d", windowPlaceholder, i)
+		at := strings.Index(rewritten[from:], name)
+		if at < 0 || from+at >= offset {
+			break
+		}
+		at += from
+		if offset < at+len(name) {
+			return at + shift
+		}
+		shift += len(windows[name].text) - len(name)
+		from = at + len(name)
+	}
+	return offset + shift
+}
+
+// matchingParen returns the index of the token closing, or when step is -1
+// opening, the parenthesis at tokens[from], or -1.
+func matchingParen(query string, tokens []sqlToken, from, step int) int {
+	depth := 0
+	for i := from; i >= 0 && i < len(tokens); i += step {
+		switch query[tokens[i].start:tokens[i].end] {
+		case "(":
+			depth += step
+		case ")":
+			depth -= step
+		}
+		if depth == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseWindowCall reads the tokens of name ( arguments ) OVER ( ... ).
+func parseWindowCall(query string, tokens []sqlToken) (*windowCall, error) {
+	text := func(token sqlToken) string { return query[token.start:token.end] }
+	call := &windowCall{function: strings.ToLower(tokens[0].name)}
+	closing := matchingParen(query, tokens, 1, 1)
+	arguments := tokens[2:closing]
+	spec := tokens[closing+3 : len(tokens)-1]
+
+	switch call.function {
+	case "row_number", "rank":
+		if len(arguments) != 0 {
+			return nil, fmt.Errorf("wrong number of arguments to function %s()", call.function)
+		}
+	case "sum", "count":
+		switch {
+		case call.function == "count" && len(arguments) == 1 && text(arguments[0]) == "*":
+		case len(arguments) == 1 && arguments[0].name != "":
+			call.argument = arguments[0].name
+		case len(arguments) == 3 && text(arguments[1]) == "." && arguments[2].name != "":
+			call.argument = arguments[2].name
+		default:
+			return nil, fmt.Errorf("unsupported window function argument: %s", query[tokens[0].start:tokens[closing].end])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported window function: %s", tokens[0].name)
+	}
+
+	// columns reads a comma-separated list of possibly qualified columns,
+	// each of which may be followed by ASC or DESC
+	columns := func(terms []sqlToken) ([]windowOrder, error) {
+		var list []windowOrder
+		for len(terms) > 0 {
+			if len(terms) >= 3 && text(terms[1]) == "." {
+				terms = terms[2:]
+			}
+			if terms[0].name == "" {
+				return nil, fmt.Errorf("unsupported window definition: %s", text(terms[0]))
+			}
+			order := windowOrder{column: terms[0].name}
+			terms = terms[1:]
+			if len(terms) > 0 && (terms[0].keyword("ASC") || terms[0].keyword("DESC")) {
+				order.descending = terms[0].keyword("DESC")
+				terms = terms[1:]
+			}
+			list = append(list, order)
+			if len(terms) > 0 {
+				if text(terms[0]) != "," || len(terms) == 1 {
+					return nil, fmt.Errorf("unsupported window definition: %s", text(terms[0]))
+				}
+				terms = terms[1:]
+			}
+		}
+		return list, nil
+	}
+
+	partition, order := -1, len(spec)
+	for i := 0; i+1 < len(spec); i++ {
+		switch {
+		case spec[i].keyword("PARTITION") && spec[i+1].keyword("BY") && partition < 0 && order == len(spec):
+			partition = i + 2
+		case spec[i].keyword("ORDER") && spec[i+1].keyword("BY") && order == len(spec):
+			order = i
+		}
+	}
+	if partition < 0 && order > 0 || partition > 2 {
+		return nil, fmt.Errorf("unsupported window definition: %s", query[spec[0].start:spec[len(spec)-1].end])
+	}
+	if partition >= 0 {
+		terms, err := columns(spec[partition:order])
+		if err != nil {
+			return nil, err
+		}
+		for _, term := range terms {
+			if term.descending {
+				return nil, fmt.Errorf("unsupported window definition: PARTITION BY %s DESC", term.column)
+			}
+			call.partitionBy = append(call.partitionBy, term.column)
+		}
+	}
+	if order < len(spec) {
+		terms, err := columns(spec[order+2:])
+		if err != nil {
+			return nil, err
+		}
+		call.orderBy = terms
+	}
+	return call, nil
+}
+
+// sameWindow reports whether two calls' windows partition and order rows
+// alike, so that one sort serves both.
+func sameWindow(a, b *windowCall) bool {
+	return slices.EqualFunc(a.partitionBy, b.partitionBy, strings.EqualFold) &&
+		slices.EqualFunc(a.orderBy, b.orderBy, func(x, y windowOrder) bool {
+			return strings.EqualFold(x.column, y.column) && x.descending == y.descending
+		})
+}
+
+// prepare resolves the columns a window call reads.
+func (call *windowCall) prepare(table *TableSchema) error {
+	resolve := func(name string) (int, error) {
+		if position, ok := table.ColumnIndex(name); ok {
+			return position, nil
+		}
+		if refersToRowID(table, name) {
+			return table.RowIDAlias, nil
+		}
+		return 0, fmt.Errorf("%w: %s", ErrNoSuchColumn, name)
+	}
+
+	var err error
+	if call.argument != "" {
+		if call.argumentPosition, err = resolve(call.argument); err != nil {
+			return err
+		}
+	}
+	call.partitionPosition = call.partitionPosition[:0]
+	call.partitionCollations = call.partitionCollations[:0]
+	for _, column := range call.partitionBy {
+		position, err := resolve(column)
+		if err != nil {
+			return err
+		}
+		call.partitionPosition = append(call.partitionPosition, position)
+		call.partitionCollations = append(call.partitionCollations, table.columnCollation(position))
+	}
+	for i := range call.orderBy {
+		if call.orderBy[i].position, err = resolve(call.orderBy[i].column); err != nil {
+			return err
+		}
+		call.orderBy[i].collation = table.columnCollation(call.orderBy[i].position)
+	}
+	return nil
+}
+
+// windowGroup is the calls of a select list that share a window, by place
+// in the select list, with the first of them standing for the window.
+type windowGroup struct {
+	spec   *windowCall
+	places []int
+}
+
+// groupWindows groups the window calls by window, in the order their first
+// calls appear in the select list.
+func groupWindows(windows map[int]*windowCall) []windowGroup {
+	places := slices.Sorted(maps.Keys(windows))
+	var groups []windowGroup
+	for _, place := range places {
+		call := windows[place]
+		i := slices.IndexFunc(groups, func(group windowGroup) bool { return sameWindow(group.spec, call) })
+		if i < 0 {
+			i = len(groups)
+			groups = append(groups, windowGroup{spec: call})
+		}
+		groups[i].places = append(groups[i].places, place)
+	}
+	return groups
+}
+
+// windowRow is a result row held until its window values are known,
+// together with the values its windows sort it by and aggregate.
+type windowRow struct {
+	rowID  int64
+	values []any
+	// partition and order hold the row's keys for each window group
+	partition [][]any
+	order     [][]any
+	// arguments holds each window call's argument, by place in the
+	// select list
+	arguments map[int]any
+}
+
+// windowRows buffers a result row for the window operator.
+func windowRows(groups []windowGroup, windows map[int]*windowCall, row *db.Row, table *TableSchema, values []any) windowRow {
+	buffered := windowRow{
+		values:    values,
+		partition: make([][]any, len(groups)),
+		order:     make([][]any, len(groups)),
+		arguments: make(map[int]any, len(windows)),
+	}
+	for i, group := range groups {
+		for _, position := range group.spec.partitionPosition {
+			buffered.partition[i] = append(buffered.partition[i], columnValue(row, table, position))
+		}
+		for _, order := range group.spec.orderBy {
+			buffered.order[i] = append(buffered.order[i], columnValue(row, table, order.position))
+		}
+	}
+	for place, call := range windows {
+		if call.argument != "" {
+			buffered.arguments[place] = columnValue(row, table, call.argumentPosition)
+		}
+	}
+	return buffered
+}
+
+// applyWindows fills in each call's value, sorting rows by each window
+// group's partition and order in turn, so that rows are left in the last
+// group's order. RANK numbers rows that tie on the order from the first of
+// them, and SUM and COUNT cover the partition's rows up to the current row
+// and those tying with it, as SQLite's default frame does, or the whole
+// partition when the window has no ORDER BY.
+func applyWindows(groups []windowGroup, windows map[int]*windowCall, rows []windowRow) error {
+	for g, group := range groups {
+		if err := applyWindowGroup(g, group, windows, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyWindowGroup sorts rows by the g'th window group's keys and fills in
+// the values of its calls.
+func applyWindowGroup(g int, group windowGroup, windows map[int]*windowCall, rows []windowRow) error {
+	spec := group.spec
+	// compareKeys compares partition keys when orders is nil and order
+	// keys otherwise
+	compareKeys := func(a, b []any, orders []windowOrder) int {
+		for i := range a {
+			var collation db.Collation
+			if orders != nil {
+				collation = orders[i].collation
+			} else {
+				collation = spec.partitionCollations[i]
+			}
+			order := db.CompareValues(a[i], b[i], collation)
+			if orders != nil && orders[i].descending {
+				order = -order
+			}
+			if order != 0 {
+				return order
+			}
+		}
+		return 0
+	}
+	slices.SortStableFunc(rows, func(a, b windowRow) int {
+		if order := compareKeys(a.partition[g], b.partition[g], nil); order != 0 {
+			return order
+		}
+		return compareKeys(a.order[g], b.order[g], spec.orderBy)
+	})
+
+	for start := 0; start < len(rows); {
+		end := start + 1
+		for end < len(rows) && compareKeys(rows[start].partition[g], rows[end].partition[g], nil) == 0 {
+			end++
+		}
+		partition := rows[start:end]
+
+		for _, place := range group.places {
+			call := windows[place]
+			var rank int64
+			var total windowSum
+			for i := 0; i < len(partition); {
+				// Peers tie on the order, and all of them have no ORDER BY
+				peers := i + 1
+				for peers < len(partition) && (call.orderBy == nil || compareKeys(partition[i].order[g], partition[peers].order[g], spec.orderBy) == 0) {
+					peers++
+				}
+				if call.function != "row_number" && call.function != "rank" {
+					for _, row := range partition[i:peers] {
+						if err := total.add(call, row.arguments[place]); err != nil {
+							return err
+						}
+					}
+				}
+				rank = int64(i) + 1
+				for j := i; j < peers; j++ {
+					switch call.function {
+					case "row_number":
+						partition[j].values[place] = int64(j) + 1
+					case "rank":
+						partition[j].values[place] = rank
+					default:
+						partition[j].values[place] = total.value(call)
+					}
+				}
+				i = peers
+			}
+		}
+		start = end
+	}
+	return nil
+}
+
+// windowSum accumulates SUM or COUNT over a window's frame.
+type windowSum struct {
+	count   int64
+	integer int64
+	real    float64
+	// isReal is set once a real has been summed, which makes the sum real
+	isReal bool
+}
+
+func (sum *windowSum) add(call *windowCall, value any) error {
+	if call.argument != "" && value == nil {
+		return nil
+	}
+	sum.count++
+	if call.function != "sum" {
+		return nil
+	}
+	switch value := value.(type) {
+	case int64:
+		// Once the sum is real, integers are added to it as reals, which
+		// cannot overflow
+		if sum.isReal {
+			sum.real += float64(value)
+			return nil
+		}
+		total := sum.integer + value
+		if (total > sum.integer) != (value > 0) {
+			return fmt.Errorf("integer overflow")
+		}
+		sum.integer = total
+	default:
+		// Text and blobs count as their numeric prefix and make the sum
+		// real, as any non-integer does
+		sum.real += realValue(numericValue(value))
+		sum.isReal = true
+	}
+	return nil
+}
+
+func (sum *windowSum) value(call *windowCall) any {
+	switch {
+	case call.function == "count":
+		return sum.count
+	case sum.count == 0:
+		return nil
+	case sum.isReal:
+		return sum.real + float64(sum.integer)
+	}
+	return sum.integer
+}
+
+// placeWindows puts the window calls whose placeholders sel selects in
+// their places in the query, naming each column by its alias or the call's
+// text.
+func (parsed *selectQuery) placeWindows(sel *sqlparser.Select, windows map[string]*windowCall) error {
+	if parsed.star || parsed.count || parsed.extreme != "" || len(parsed.aggregates) > 0 {
+		return fmt.Errorf("window functions can only be combined with columns")
+	}
+	for _, filter := range parsed.filters {
+		if strings.HasPrefix(filter.column, windowPlaceholder) || filter.outer != nil && strings.HasPrefix(filter.outer.name, windowPlaceholder) {
+			return fmt.Errorf("misuse of window function")
+		}
+	}
+
+	for i, expr := range sel.SelectExprs {
+		aliased, ok := expr.(*sqlparser.AliasedExpr)
+		if !ok {
+			continue
+		}
+		column, ok := aliased.Expr.(*sqlparser.ColName)
+		if !ok {
+			continue
+		}
+		call, ok := windows[column.Name.String()]
+		if !ok {
+			continue
+		}
+		if parsed.windows == nil {
+			parsed.windows = make(map[int]*windowCall)
+		}
+		// Every select expression is a column here, so i is its place
+		parsed.windows[i] = call
+		parsed.columns[i] = call.text
+		if !aliased.As.IsEmpty() {
+			parsed.columns[i] = aliased.As.String()
+		}
+		delete(windows, column.Name.String())
+	}
+	if len(windows) > 0 {
+		return fmt.Errorf("misuse of window function")
+	}
+	return nil
+}
+
+// windowScan reads every row the query selects, with output computing its
+// values, and returns them with their window values filled in, in the order
+// of the last window, or in rowid order when the query orders by rowid.
+func windowScan(parsed *selectQuery, table *TableSchema, scan func(func(*db.Row) bool) error, output func(*db.Row) ([]any, error)) ([][]any, error) {
+	groups := groupWindows(parsed.windows)
+	var rows []windowRow
+	var failed error
+	err := scan(func(row *db.Row) bool {
+		values, err := output(row)
+		if err != nil {
+			failed = err
+			return false
+		}
+		buffered := windowRows(groups, parsed.windows, row, table, values)
+		buffered.rowID = row.RowID
+		rows = append(rows, buffered)
+		return true
+	})
+	if failed != nil {
+		return nil, failed
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := applyWindows(groups, parsed.windows, rows); err != nil {
+		return nil, err
+	}
+
+	if parsed.orderBy != "" {
+		slices.SortFunc(rows, func(a, b windowRow) int {
+			if parsed.descending {
+				return cmp.Compare(b.rowID, a.rowID)
+			}
+			return cmp.Compare(a.rowID, b.rowID)
+		})
+	}
+	values := make([][]any, len(rows))
+	for i, row := range rows {
+		values[i] = row.values
+	}
+	return values, nil
+}
@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func TestWindowFunctionsMatchSQLite(t *testing.T) {
+	path := companiesDatabase(t, 120)
+	sqlite3, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not installed")
+	}
+
+	// Rows that tie on a window's order are returned in an order SQLite
+	// leaves open, so those queries select only what the ties share
+	for _, query := range []string{
+		"SELECT name, row_number() OVER (PARTITION BY country ORDER BY id) FROM companies",
+		"SELECT size, rank() OVER (ORDER BY size DESC) FROM companies WHERE country = 'eritrea'",
+		"SELECT country, size, sum(size) OVER (PARTITION BY country ORDER BY size) AS running, count(*) OVER (PARTITION BY country ORDER BY size) FROM companies WHERE country IN ('eritrea', 'country-3')",
+		"SELECT size, count(*) OVER (PARTITION BY size), sum(id) OVER (PARTITION BY size) FROM companies",
+		"SELECT id, row_number() OVER (ORDER BY country DESC, id) FROM companies ORDER BY id DESC LIMIT 10",
+		"SELECT count(name) OVER (), id FROM companies WHERE size = 2 LIMIT 3",
+		// Each window is sorted in its own pass
+		"SELECT id, rank() OVER (ORDER BY size), row_number() OVER (PARTITION BY country ORDER BY id DESC), rank() OVER (ORDER BY size), sum(size) OVER (PARTITION BY country) FROM companies ORDER BY id",
+	} {
+		rows, err := runQuery(openDatabase(t, path), query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		var got strings.Builder
+		for _, row := range rows {
+			fields := make([]string, len(row))
+			for i, value := range row {
+				fields[i] = fmt.Sprint(value)
+			}
+			fmt.Fprintln(&got, strings.Join(fields, "|"))
+		}
+		want, err := exec.Command(sqlite3, path, query).Output()
+		if err != nil {
+			t.Fatalf("sqlite3 %s: %v", query, err)
+		}
+		if got.String() != string(want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", query, got.String(), want)
+		}
+	}
+}
+
+func TestWindowSumOverflow(t *testing.T) {
+	generated := testgen.New(testgen.Options{})
+	reals := generated.CreateTable("reals", "CREATE TABLE reals (id integer primary key, x)")
+	integers := generated.CreateTable("integers", "CREATE TABLE integers (id integer primary key, x)")
+	for i, x := range []any{1.5, int64(math.MaxInt64), int64(1)} {
+		reals.Insert(int64(i+1), nil, x)
+	}
+	for i, x := range []int64{math.MaxInt64, 1, -1} {
+		integers.Insert(int64(i+1), nil, x)
+	}
+	database := openDatabase(t, generated.WriteTemp(t))
+
+	// Integers summed after a real are added as reals, as in SQLite, and
+	// only a sum of integers alone overflows
+	want := [][]any{{1.5}, {float64(math.MaxInt64) + 1.5}, {float64(math.MaxInt64) + 2.5}}
+	if got := queryRows(t, database, "SELECT sum(x) OVER (ORDER BY id) FROM reals"); !reflect.DeepEqual(got, want) {
+		t.Errorf("running sum %v, want %v", got, want)
+	}
+	if _, err := runQuery(database, "SELECT sum(x) OVER (ORDER BY id) FROM integers"); err == nil || err.Error() != "integer overflow" {
+		t.Errorf("sum of integers past the largest: %v", err)
+	}
+}
+
+func TestWindowFunctionColumns(t *testing.T) {
+	database := openDatabase(t, companiesDatabase(t, 10))
+	resultSet, err := database.Query("SELECT name, row_number() OVER (ORDER BY size) AS n, RANK() over (order by size) FROM companies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resultSet.Close()
+	var names []string
+	for _, column := range resultSet.Columns {
+		names = append(names, column.Name)
+	}
+	if got := strings.Join(names, ", "); got != "name, n, RANK() over (order by size)" {
+		t.Errorf("columns %s", got)
+	}
+
+	for query, want := range map[string]string{
+		"SELECT avg(size) OVER () FROM companies":                                                       "unsupported window function: avg",
+		"SELECT row_number() OVER w FROM companies":                                                     "parse query: named windows are not supported",
+		"SELECT * , rank() OVER (ORDER BY size) FROM companies":                                         "window functions can only be combined with columns",
+		"SELECT name FROM companies WHERE size = rank() OVER (ORDER BY size)":                           "misuse of window function",
+		"SELECT row_number() OVER (ORDER BY nope) FROM companies":                                       "no such column: nope",
+		"SELECT sum(size) OVER (ORDER BY size ROWS BETWEEN 1 PRECEDING AND CURRENT ROW) FROM companies": "unsupported window definition: ROWS",
+	} {
+		if _, err := runQuery(database, query); err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %q", query, err, want)
+		}
+	}
+}
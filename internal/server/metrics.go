@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the query latency
+// histogram's buckets. They start well below Prometheus's defaults, since
+// most queries read a few pages.
+var durationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics are the server's own totals: the queries it has answered, by the
+// code of their outcome, and how long they took.
+type metrics struct {
+	mu sync.Mutex
+	// queries counts the queries answered by code, OK or an error report's
+	queries map[string]int64
+	// buckets count the queries that took no longer than each of
+	// durationBuckets, and seconds totals how long all of them took
+	buckets []int64
+	count   int64
+	seconds float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{queries: make(map[string]int64), buckets: make([]int64, len(durationBuckets))}
+}
+
+// observe records a query answered with code after duration.
+func (m *metrics) observe(code string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries[code]++
+	seconds := duration.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+	m.count++
+	m.seconds += seconds
+}
+
+// writeTo writes the metrics, and the database's counters, in the
+// Prometheus text exposition format.
+func (m *metrics) writeTo(w io.Writer, counters engine.Counters) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header(w, "sqlite_queries_total", "counter", "Queries answered, by the code of their outcome: OK or an error report's.")
+	codes := make([]string, 0, len(m.queries))
+	for code := range m.queries {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "sqlite_queries_total{code=%q} %d\n", code, m.queries[code])
+	}
+
+	header(w, "sqlite_query_duration_seconds", "histogram", "How long queries took to answer, waiting for the database included.")
+	for i, bound := range durationBuckets {
+		fmt.Fprintf(w, "sqlite_query_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), m.buckets[i])
+	}
+	fmt.Fprintf(w, "sqlite_query_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(w, "sqlite_query_duration_seconds_sum %s\n", strconv.FormatFloat(m.seconds, 'g', -1, 64))
+	fmt.Fprintf(w, "sqlite_query_duration_seconds_count %d\n", m.count)
+
+	for _, counter := range []struct {
+		name, help string
+		value      int64
+	}{
+		{"sqlite_pages_read_total", "B-tree pages read.", counters.PagesRead},
+		{"sqlite_plan_cache_hits_total", "SELECTs run from a cached plan.", counters.PlanCacheHits},
+		{"sqlite_plan_cache_misses_total", "SELECTs compiled to be cached.", counters.PlanCacheMisses},
+		{"sqlite_rows_returned_total", "Rows of results returned.", counters.RowsReturned},
+		{"sqlite_bytes_returned_total", "Bytes of values returned, eight for each number.", counters.BytesReturned},
+	} {
+		header(w, counter.name, "counter", counter.help)
+		fmt.Fprintf(w, "%s %d\n", counter.name, counter.value)
+	}
+
+	ratio := 0.0
+	if lookups := counters.PlanCacheHits + counters.PlanCacheMisses; lookups > 0 {
+		ratio = float64(counters.PlanCacheHits) / float64(lookups)
+	}
+	header(w, "sqlite_plan_cache_hit_ratio", "gauge", "The share of cacheable SELECTs run from a cached plan.")
+	fmt.Fprintf(w, "sqlite_plan_cache_hit_ratio %s\n", strconv.FormatFloat(ratio, 'g', -1, 64))
+}
+
+// header writes the HELP and TYPE lines of a metric.
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (server *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	server.metrics.writeTo(w, server.pool.Counters())
+}
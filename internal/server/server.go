@@ -0,0 +1,233 @@
+// Package server serves a database's read engine over HTTP, for running
+// the tool as a sidecar that other programs query without linking it.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/arrow"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/cli"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
+)
+
+// maxRequestBytes caps the body of a request, which holds one statement.
+const maxRequestBytes = 1 << 20
+
+// progressPages is how many pages a query reads between checks of its
+// deadline.
+const progressPages = 64
+
+// Options are the limits every request runs under. A request may ask for
+// tighter ones, never looser.
+type Options struct {
+	// Limits caps each query's results; zero fields mean no limit
+	Limits engine.Limits
+	// Timeout is the longest a query may run, counting the wait for a
+	// handle; zero means no limit
+	Timeout time.Duration
+	// Logger logs each request, or nothing when nil
+	Logger *slog.Logger
+}
+
+// Request is the body of a POST /query sent as application/json. Any other
+// body is taken as the statement alone.
+type Request struct {
+	SQL string `json:"sql"`
+	// MaxRows and TimeoutMS tighten the server's limits for this request
+	MaxRows   int64 `json:"max_rows,omitempty"`
+	TimeoutMS int64 `json:"timeout_ms,omitempty"`
+}
+
+// Response is the body of a successful POST /query. Integers and reals are
+// JSON numbers, blobs are base64 strings, and infinite reals are 9e999 and
+// -9e999, as SQLite's json() writes them.
+type Response struct {
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// Server answers POST /query with the results of the statement it holds,
+// as a Response, or as an Arrow IPC stream when the request accepts
+// arrow.MediaType, or else with a cli.ErrorReport and an error status. Only
+// statements that read run, whatever the database was opened with. Each
+// runs on a handle of its own from a pool, so as many run at once as the
+// pool has handles. GET /metrics reports the queries answered and the engine's counters for
+// Prometheus.
+type Server struct {
+	pool    *engine.Pool
+	options Options
+	logger  *slog.Logger
+	metrics *metrics
+	mux     *http.ServeMux
+}
+
+// New returns a Server querying the databases of pool, which it takes over
+// the read-only SQL mode, limits and progress handler of.
+func New(pool *engine.Pool, options Options) *Server {
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	server := &Server{pool: pool, options: options, logger: logger, metrics: newMetrics(), mux: http.NewServeMux()}
+	server.mux.HandleFunc("POST /query", server.query)
+	server.mux.HandleFunc("GET /metrics", server.serveMetrics)
+	return server
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mux.ServeHTTP(w, r)
+}
+
+func (server *Server) query(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	request, err := readRequest(r)
+	if err != nil {
+		server.metrics.observe("BAD_REQUEST", time.Since(start))
+		writeJSON(w, http.StatusBadRequest, cli.ErrorReport{Code: "BAD_REQUEST", Message: err.Error(), Context: map[string]any{}})
+		return
+	}
+	limits := server.options.Limits
+	limits.MaxRows = tighter(limits.MaxRows, request.MaxRows)
+	timeout := time.Duration(tighter(int64(server.options.Timeout), request.TimeoutMS*int64(time.Millisecond)))
+
+	ctx := r.Context()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	response, err := server.run(ctx, request.SQL, limits)
+	if err != nil {
+		if ctx.Err() != nil && (errors.Is(err, engine.ErrInterrupted) || errors.Is(err, ctx.Err())) {
+			err = fmt.Errorf("%w: query timed out after %v", engine.ErrInterrupted, time.Since(start).Round(time.Millisecond))
+		}
+		report := cli.NewErrorReport(err)
+		server.metrics.observe(report.Code, time.Since(start))
+		server.logger.Info("query failed", "sql", request.SQL, "code", report.Code, "duration", time.Since(start))
+		writeJSON(w, status(report.Code), report)
+		return
+	}
+	server.metrics.observe("OK", time.Since(start))
+	server.logger.Info("query", "sql", request.SQL, "rows", len(response.Rows), "duration", time.Since(start))
+	if accepts(r, arrow.MediaType) {
+		w.Header().Set("Content-Type", arrow.MediaType)
+		if err := arrow.WriteResults(w, response.Columns, response.Rows); err != nil {
+			server.logger.Warn("writing Arrow results failed", "sql", request.SQL, "error", err)
+		}
+		return
+	}
+	for _, row := range response.Rows {
+		for j, value := range row {
+			if value, ok := value.(float64); ok && math.IsInf(value, 1) {
+				row[j] = json.Number("9e999")
+			} else if ok && math.IsInf(value, -1) {
+				row[j] = json.Number("-9e999")
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// accepts reports whether the request's Accept header lists mediaType.
+func accepts(r *http.Request, mediaType string) bool {
+	for _, header := range r.Header.Values("Accept") {
+		for _, accepted := range strings.Split(header, ",") {
+			if parsed, _, err := mime.ParseMediaType(accepted); err == nil && parsed == mediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// run runs a statement once a handle is free, reading every row before it
+// returns.
+func (server *Server) run(ctx context.Context, sql string, limits engine.Limits) (*Response, error) {
+	database, err := server.pool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer server.pool.Put(database)
+
+	database.SetReadOnlySQL(true)
+	database.SetLimits(limits)
+	database.SetProgressHandler(progressPages, func() bool { return ctx.Err() != nil })
+	defer database.SetProgressHandler(0, nil)
+
+	resultSet, err := database.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := resultSet.All()
+	if err != nil {
+		return nil, err
+	}
+	response := &Response{Columns: make([]string, len(resultSet.Columns)), Rows: rows}
+	for i, column := range resultSet.Columns {
+		response.Columns[i] = column.Name
+	}
+	return response, nil
+}
+
+// readRequest reads the statement and the limits it asks for from the
+// body of a request.
+func readRequest(r *http.Request) (Request, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxRequestBytes))
+	if err != nil {
+		return Request{}, fmt.Errorf("read request: %w", err)
+	}
+	var request Request
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		if err := json.Unmarshal(body, &request); err != nil {
+			return Request{}, fmt.Errorf("parse request: %w", err)
+		}
+	} else {
+		request.SQL = string(body)
+	}
+	if request.SQL == "" {
+		return Request{}, errors.New("no SQL in the request")
+	}
+	if request.MaxRows < 0 || request.TimeoutMS < 0 {
+		return Request{}, errors.New("max_rows and timeout_ms must not be negative")
+	}
+	return request, nil
+}
+
+// tighter returns the tighter of two limits, where zero means none.
+func tighter(limit, requested int64) int64 {
+	if limit == 0 || (requested > 0 && requested < limit) {
+		return requested
+	}
+	return limit
+}
+
+// status is the HTTP status of a failure with an error report's code.
+func status(code string) int {
+	switch code {
+	case "SYNTAX", "TABLE_NOT_FOUND", "COLUMN_NOT_FOUND", "STATEMENT_NOT_ALLOWED", "READ_ONLY":
+		return http.StatusBadRequest
+	case "RESULT_TOO_LARGE":
+		return http.StatusRequestEntityTooLarge
+	case "INTERRUPTED":
+		return http.StatusGatewayTimeout
+	case "BUSY":
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
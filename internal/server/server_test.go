@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/arrow"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/cli"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func newTestPool(t *testing.T, maxOpen int) *engine.Pool {
+	t.Helper()
+	database := testgen.New(testgen.Options{PageSize: 512})
+	items := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text, price real)")
+	for i := int64(1); i <= 500; i++ {
+		items.Insert(i, nil, fmt.Sprintf("item-%03d", i), float64(i)/4)
+	}
+	path := database.WriteTemp(t)
+	pool, err := engine.NewPool(maxOpen, func() (*engine.Database, error) { return engine.Open(path) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
+
+func newTestServer(t *testing.T, options Options) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(New(newTestPool(t, 0), options))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// post sends body to /query and decodes the response into a Response or,
+// on failure, a cli.ErrorReport.
+func post(t *testing.T, server *httptest.Server, contentType, body string) (int, Response, cli.ErrorReport) {
+	t.Helper()
+	response, err := http.Post(server.URL+"/query", contentType, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var result Response
+	var report cli.ErrorReport
+	target := any(&result)
+	if response.StatusCode != http.StatusOK {
+		target = &report
+	}
+	if err := json.NewDecoder(response.Body).Decode(target); err != nil {
+		t.Fatal(err)
+	}
+	return response.StatusCode, result, report
+}
+
+func TestQuery(t *testing.T) {
+	server := newTestServer(t, Options{Limits: engine.Limits{MaxRows: 100}})
+
+	status, result, _ := post(t, server, "text/plain", "SELECT id, name, price FROM items WHERE id = 42")
+	want := Response{Columns: []string{"id", "name", "price"}, Rows: [][]any{{42.0, "item-042", 10.5}}}
+	if status != http.StatusOK || !reflect.DeepEqual(result, want) {
+		t.Errorf("got %d %v, want %v", status, result, want)
+	}
+	status, result, _ = post(t, server, "application/json", `{"sql": "SELECT name FROM items WHERE id IN (1, 2, 3)"}`)
+	if status != http.StatusOK || len(result.Rows) != 3 || result.Rows[2][0] != "item-003" {
+		t.Errorf("JSON request: %d %v", status, result)
+	}
+
+	for _, test := range []struct {
+		contentType, body string
+		status            int
+		code              string
+	}{
+		{"text/plain", "INSERT INTO items (name) VALUES ('x')", http.StatusBadRequest, "STATEMENT_NOT_ALLOWED"},
+		{"text/plain", "SELECT * FROM missing", http.StatusBadRequest, "TABLE_NOT_FOUND"},
+		{"text/plain", "", http.StatusBadRequest, "BAD_REQUEST"},
+		{"application/json", `{"sql": `, http.StatusBadRequest, "BAD_REQUEST"},
+		// The server's limit holds, and a request may tighten it
+		{"text/plain", "SELECT id FROM items", http.StatusRequestEntityTooLarge, "RESULT_TOO_LARGE"},
+		{"application/json", `{"sql": "SELECT id FROM items LIMIT 20", "max_rows": 10}`, http.StatusRequestEntityTooLarge, "RESULT_TOO_LARGE"},
+		{"application/json", `{"sql": "SELECT id FROM items", "max_rows": 1000}`, http.StatusRequestEntityTooLarge, "RESULT_TOO_LARGE"},
+	} {
+		status, _, report := post(t, server, test.contentType, test.body)
+		if status != test.status || report.Code != test.code {
+			t.Errorf("%s: got %d %s (%s), want %d %s", test.body, status, report.Code, report.Message, test.status, test.code)
+		}
+	}
+
+	if response, err := http.Get(server.URL + "/query"); err != nil || response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /query: %v %v", response.Status, err)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	pool := newTestPool(t, 1)
+	server := httptest.NewServer(New(pool, Options{Timeout: time.Minute}))
+	t.Cleanup(server.Close)
+
+	// A request that cannot have a handle before its deadline fails
+	held, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, _, report := post(t, server, "application/json", `{"sql": "SELECT count(*) FROM items", "timeout_ms": 20}`)
+	if status != http.StatusGatewayTimeout || report.Code != "INTERRUPTED" {
+		t.Errorf("got %d %s: %s", status, report.Code, report.Message)
+	}
+	pool.Put(held)
+
+	// The next request runs without the last one's deadline
+	if status, result, report := post(t, server, "text/plain", "SELECT count(*) FROM items"); status != http.StatusOK || result.Rows[0][0] != 500.0 {
+		t.Errorf("after a timeout: %d %v %s", status, result, report.Message)
+	}
+}
+
+func TestTighter(t *testing.T) {
+	for _, test := range []struct{ limit, requested, want int64 }{
+		{0, 0, 0}, {0, 5, 5}, {10, 0, 10}, {10, 5, 5}, {10, 50, 10},
+	} {
+		if got := tighter(test.limit, test.requested); got != test.want {
+			t.Errorf("tighter(%d, %d) = %d, want %d", test.limit, test.requested, got, test.want)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	server := newTestServer(t, Options{})
+	post(t, server, "text/plain", "SELECT name FROM items WHERE id = 7")
+	post(t, server, "text/plain", "SELECT name FROM items WHERE id = 7")
+	post(t, server, "text/plain", "SELECT * FROM missing")
+
+	response, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(body), "\n")
+	for _, want := range []string{
+		`sqlite_queries_total{code="OK"} 2`,
+		`sqlite_queries_total{code="TABLE_NOT_FOUND"} 1`,
+		`sqlite_query_duration_seconds_bucket{le="+Inf"} 3`,
+		`sqlite_query_duration_seconds_count 3`,
+		`sqlite_plan_cache_hits_total 1`,
+		`sqlite_plan_cache_misses_total 1`,
+		`sqlite_plan_cache_hit_ratio 0.5`,
+		`sqlite_rows_returned_total 2`,
+		`sqlite_bytes_returned_total 16`,
+		`# TYPE sqlite_query_duration_seconds histogram`,
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("metrics lack %q:\n%s", want, body)
+		}
+	}
+	for _, line := range lines {
+		if value, ok := strings.CutPrefix(line, "sqlite_pages_read_total "); ok && (value == "0" || value == "") {
+			t.Errorf("no pages read: %s", line)
+		}
+	}
+}
+
+func TestConcurrentQueries(t *testing.T) {
+	pool := newTestPool(t, 4)
+	server := httptest.NewServer(New(pool, Options{}))
+	t.Cleanup(server.Close)
+
+	var group sync.WaitGroup
+	for i := range 20 {
+		group.Go(func() {
+			query := fmt.Sprintf("SELECT name FROM items WHERE id = %d", i+1)
+			response, err := http.Post(server.URL+"/query", "text/plain", strings.NewReader(query))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer response.Body.Close()
+			var result Response
+			if err := json.NewDecoder(response.Body).Decode(&result); err != nil || len(result.Rows) != 1 || result.Rows[0][0] != fmt.Sprintf("item-%03d", i+1) {
+				t.Errorf("%s: %v %v", query, result, err)
+			}
+		})
+	}
+	group.Wait()
+	if rows := pool.Counters().RowsReturned; rows != 20 {
+		t.Errorf("%d rows returned, want 20", rows)
+	}
+}
+
+func TestQueryArrow(t *testing.T) {
+	server := newTestServer(t, Options{})
+	request, err := http.NewRequest("POST", server.URL+"/query", strings.NewReader("SELECT id, name FROM items LIMIT 3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Accept", "application/json;q=0.5, "+arrow.MediaType)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Header.Get("Content-Type"); response.StatusCode != http.StatusOK || got != arrow.MediaType {
+		t.Fatalf("got %d %s", response.StatusCode, got)
+	}
+	// A stream opens with a message's continuation marker and ends with an
+	// empty one, and names the columns in its schema
+	if !bytes.HasPrefix(body, []byte{0xff, 0xff, 0xff, 0xff}) || !bytes.HasSuffix(body, []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}) {
+		t.Errorf("not an Arrow stream: % x", body)
+	}
+	if !bytes.Contains(body, []byte("name\x00")) || !bytes.Contains(body, []byte("item-003")) {
+		t.Errorf("stream lacks the columns' names or values: %q", body)
+	}
+
+	// Failures are still reported as JSON
+	request, _ = http.NewRequest("POST", server.URL+"/query", strings.NewReader("SELECT * FROM missing"))
+	request.Header.Set("Accept", arrow.MediaType)
+	response, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest || response.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("failure as %d %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+}
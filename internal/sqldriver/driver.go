@@ -0,0 +1,241 @@
+// Package sqldriver registers the engine with database/sql as "sqlite".
+// The data source name is the path of the database file, and each
+// connection opens its own handle on it; ":memory:" gives each connection
+// its own empty in-memory database. database/sql pools the connections, so
+// SetMaxOpenConns caps how many statements run at once, and each
+// connection is checked with engine.Database.Ping as it goes back to the
+// pool. Statements take no bound parameters; transactions map to BEGIN,
+// COMMIT and ROLLBACK. Rows.ColumnTypes describes columns read from a table
+// by their declared type and NOT NULL constraint.
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
+)
+
+func init() {
+	sql.Register("sqlite", Driver{})
+}
+
+// Driver opens connections to database files.
+type Driver struct{}
+
+// Open opens the database at path.
+func (Driver) Open(path string) (driver.Conn, error) {
+	database, err := engine.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{database: database}, nil
+}
+
+// conn is one open database handle. database/sql never uses a connection
+// from two goroutines at once, so it needs no locking of its own.
+type conn struct {
+	database *engine.Database
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+// IsValid reports whether the connection may be reused: whether its file
+// can still be read, though another process may hold a lock on it.
+func (c *conn) IsValid() bool {
+	err := c.database.Ping()
+	return err == nil || errors.Is(err, db.ErrBusy)
+}
+
+// Close closes the database, rolling back any open transaction.
+func (c *conn) Close() error {
+	return c.database.Close()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx starts a transaction. Every transaction is serializable, as each
+// sees only its own changes, and read-only ones are not enforced.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	level := sql.IsolationLevel(opts.Isolation)
+	if level != sql.LevelDefault && level != sql.LevelSerializable {
+		return nil, errors.New("sqldriver: unsupported isolation level " + level.String())
+	}
+	if err := c.exec("BEGIN"); err != nil {
+		return nil, err
+	}
+	return &tx{conn: c}, nil
+}
+
+// exec runs a statement and discards any rows it returns.
+func (c *conn) exec(query string) error {
+	resultSet, err := c.database.Query(query)
+	if err != nil {
+		return err
+	}
+	for resultSet.Next() {
+	}
+	if err := resultSet.Err(); err != nil {
+		resultSet.Close()
+		return err
+	}
+	return resultSet.Close()
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	return t.conn.exec("COMMIT")
+}
+
+func (t *tx) Rollback() error {
+	return t.conn.exec("ROLLBACK")
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+// NumInput reports no parameters, so database/sql rejects calls that pass
+// arguments.
+func (s *stmt) NumInput() int {
+	return 0
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	if err := s.conn.exec(s.query); err != nil {
+		return nil, err
+	}
+	return result{}, nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	resultSet, err := s.conn.database.Query(s.query)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{resultSet: resultSet, database: s.conn.database}, nil
+}
+
+// result is the result of a statement. The engine does not count the rows
+// a statement changes, so neither figure is available.
+type result struct{}
+
+func (result) LastInsertId() (int64, error) {
+	return 0, errors.New("sqldriver: LastInsertId is not supported")
+}
+
+func (result) RowsAffected() (int64, error) {
+	return 0, errors.New("sqldriver: RowsAffected is not supported")
+}
+
+// rows streams a result set. Its values are already int64, float64,
+// string, []byte or nil, which database/sql accepts as they are.
+type rows struct {
+	resultSet *engine.ResultSet
+	// database describes the tables columns are read from
+	database *engine.Database
+}
+
+func (r *rows) Columns() []string {
+	names := make([]string, len(r.resultSet.Columns))
+	for i, column := range r.resultSet.Columns {
+		names[i] = column.Name
+	}
+	return names
+}
+
+func (r *rows) Close() error {
+	return r.resultSet.Close()
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if !r.resultSet.Next() {
+		if err := r.resultSet.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for i, value := range r.resultSet.Row() {
+		dest[i] = value
+	}
+	return nil
+}
+
+// ColumnTypeDatabaseTypeName returns a column's declared type, uppercased
+// and without any size, as in VARCHAR for varchar(20), or "" for computed
+// columns and columns declared without a type.
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	declared, _, _ := strings.Cut(r.resultSet.Columns[index].DeclaredType, "(")
+	return strings.ToUpper(strings.TrimSpace(declared))
+}
+
+// origin returns the schema of the table column a result column is read
+// from and whether it never holds NULL, or false for computed columns.
+func (r *rows) origin(index int) (column engine.ColumnSchema, notNull, ok bool) {
+	result := r.resultSet.Columns[index]
+	if result.OriginTable == "" {
+		return column, false, false
+	}
+	table, err := r.database.TableSchema(result.OriginTable)
+	if err != nil {
+		return column, false, false
+	}
+	position, found := table.ColumnIndex(result.OriginColumn)
+	if !found {
+		return column, false, false
+	}
+	column = table.Columns[position]
+	return column, position == table.RowIDAlias || column.NotNull, true
+}
+
+// ColumnTypeNullable reports whether a column read from a table may hold
+// NULL: it may not when declared NOT NULL or when it is the INTEGER
+// PRIMARY KEY. Computed columns report ok false.
+func (r *rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	_, notNull, found := r.origin(index)
+	return found && !notNull, found
+}
+
+// ColumnTypeScanType returns the type a column's values scan into, from the
+// affinity of its declared type: int64, float64 or string, or their
+// sql.Null forms for a column that may hold NULL, and []byte for BLOB.
+// SQLite lets any column hold any value, so columns with NUMERIC affinity
+// or no declared type, and computed columns, scan into any.
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	column, notNull, found := r.origin(index)
+	if !found || column.DeclaredType == "" {
+		return reflect.TypeFor[any]()
+	}
+	if notNull {
+		return scanTypes[column.Affinity][1]
+	}
+	return scanTypes[column.Affinity][0]
+}
+
+// scanTypes holds each affinity's scan type, for a column that may hold NULL
+// and one that may not.
+var scanTypes = map[engine.Affinity][2]reflect.Type{
+	engine.AffinityInteger: {reflect.TypeFor[sql.NullInt64](), reflect.TypeFor[int64]()},
+	engine.AffinityReal:    {reflect.TypeFor[sql.NullFloat64](), reflect.TypeFor[float64]()},
+	engine.AffinityText:    {reflect.TypeFor[sql.NullString](), reflect.TypeFor[string]()},
+	engine.AffinityBlob:    {reflect.TypeFor[[]byte](), reflect.TypeFor[[]byte]()},
+	engine.AffinityNumeric: {reflect.TypeFor[any](), reflect.TypeFor[any]()},
+}
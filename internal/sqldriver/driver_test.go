@@ -0,0 +1,178 @@
+package sqldriver
+
+import (
+	"database/sql"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	database := testgen.New(testgen.Options{})
+	table := database.CreateTable("t", "CREATE TABLE t (id integer primary key, name text)")
+	table.Insert(1, nil, "one")
+	path := database.WriteTemp(t)
+
+	sqlDB, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return sqlDB
+}
+
+func names(t *testing.T, sqlDB *sql.DB) []string {
+	t.Helper()
+
+	rows, err := sqlDB.Query("SELECT name FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestTransactions(t *testing.T) {
+	sqlDB := openDB(t)
+
+	tx, err := sqlDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO t VALUES (2, 'two')"); err != nil {
+		t.Fatal(err)
+	}
+	// The failed insert leaves the transaction's earlier writes in place
+	if _, err := tx.Exec("INSERT INTO t VALUES (2, 'again')"); err == nil || err.Error() != "UNIQUE constraint failed: t.id" {
+		t.Fatalf("conflicting insert: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(t, sqlDB); !reflect.DeepEqual(got, []string{"one"}) {
+		t.Fatalf("rows after rollback = %v", got)
+	}
+
+	tx, err = sqlDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"INSERT INTO t VALUES (2, 'two')", "INSERT INTO t VALUES (3, 'three')"} {
+		if _, err := tx.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(t, sqlDB), []string{"one", "two", "three"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows after commit = %v, want %v", got, want)
+	}
+}
+
+func TestExecWithArguments(t *testing.T) {
+	sqlDB := openDB(t)
+	if _, err := sqlDB.Exec("INSERT INTO t VALUES (?, ?)", 2, "two"); err == nil {
+		t.Fatal("bound parameters accepted")
+	}
+	if _, err := sqlDB.BeginTx(t.Context(), &sql.TxOptions{Isolation: sql.LevelReadUncommitted}); err == nil {
+		t.Fatal("read uncommitted transaction started")
+	}
+}
+
+func TestConcurrentConnections(t *testing.T) {
+	sqlDB := openDB(t)
+	sqlDB.SetMaxOpenConns(4)
+
+	var group sync.WaitGroup
+	for range 16 {
+		group.Go(func() {
+			rows, err := sqlDB.Query("SELECT name FROM t")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer rows.Close()
+			for rows.Next() {
+			}
+			if err := rows.Err(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	group.Wait()
+	if open := sqlDB.Stats().OpenConnections; open > 4 || open == 0 {
+		t.Errorf("%d connections open, want 1 to 4", open)
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	database := testgen.New(testgen.Options{})
+	table := database.CreateTable("items", "CREATE TABLE items (id integer primary key, sku varchar(20) not null, price real, photo blob, note, qty numeric)")
+	table.Insert(1, nil, "a-1", 1.5, []byte{1}, "n", int64(3))
+	sqlDB, err := sql.Open("sqlite", database.WriteTemp(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	rows, err := sqlDB.Query("SELECT * FROM items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type described struct {
+		name, databaseType string
+		scanType           reflect.Type
+		nullable, ok       bool
+	}
+	want := []described{
+		{"id", "INTEGER", reflect.TypeFor[int64](), false, true},
+		{"sku", "VARCHAR", reflect.TypeFor[string](), false, true},
+		{"price", "REAL", reflect.TypeFor[sql.NullFloat64](), true, true},
+		{"photo", "BLOB", reflect.TypeFor[[]byte](), true, true},
+		{"note", "", reflect.TypeFor[any](), true, true},
+		{"qty", "NUMERIC", reflect.TypeFor[any](), true, true},
+	}
+	var got []described
+	for _, columnType := range columnTypes {
+		nullable, ok := columnType.Nullable()
+		got = append(got, described{columnType.Name(), columnType.DatabaseTypeName(), columnType.ScanType(), nullable, ok})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("column types\n got %v\nwant %v", got, want)
+	}
+
+	counted, err := sqlDB.Query("SELECT count(*) FROM items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer counted.Close()
+	columnTypes, err = counted.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := columnTypes[0].Nullable(); ok || columnTypes[0].DatabaseTypeName() != "" || columnTypes[0].ScanType() != reflect.TypeFor[any]() {
+		t.Errorf("computed column described as %q %v", columnTypes[0].DatabaseTypeName(), columnTypes[0].ScanType())
+	}
+}
@@ -0,0 +1,309 @@
+package testgen
+
+import (
+	"encoding/binary"
+)
+
+const (
+	interiorIndexPage = 2
+	interiorTablePage = 5
+	leafIndexPage     = 10
+	leafTablePage     = 13
+)
+
+// builder lays out b-tree and overflow pages. pages[i] holds page i+1; page
+// 1 is reserved for the schema table root.
+type builder struct {
+	pageSize   int
+	usableSize int
+	pages      [][]byte
+}
+
+func (b *builder) allocate() uint32 {
+	b.pages = append(b.pages, nil)
+	return uint32(len(b.pages))
+}
+
+func (b *builder) maxLocal(pageType int) int {
+	if pageType == leafTablePage {
+		return b.usableSize - 35
+	}
+	return (b.usableSize-12)*64/255 - 23
+}
+
+func (b *builder) localSize(pageType int, payloadSize int) int {
+	maxLocal := b.maxLocal(pageType)
+	if payloadSize <= maxLocal {
+		return payloadSize
+	}
+
+	minLocal := (b.usableSize-12)*32/255 - 23
+	local := minLocal + (payloadSize-minLocal)%(b.usableSize-4)
+	if local > maxLocal {
+		return minLocal
+	}
+	return local
+}
+
+// payloadCell appends payload to prefix, spilling whatever does not fit on a
+// page of pageType onto a freshly written overflow chain.
+func (b *builder) payloadCell(pageType int, prefix, payload []byte) []byte {
+	local := b.localSize(pageType, len(payload))
+	cell := append(append([]byte(nil), prefix...), payload[:local]...)
+	if local == len(payload) {
+		return cell
+	}
+
+	rest := payload[local:]
+	first := b.allocate()
+	for pageNumber := first; len(rest) > 0; {
+		page := make([]byte, b.pageSize)
+		n := copy(page[4:b.usableSize], rest)
+		rest = rest[n:]
+
+		var next uint32
+		if len(rest) > 0 {
+			next = b.allocate()
+		}
+		binary.BigEndian.PutUint32(page[0:4], next)
+		b.pages[pageNumber-1] = page
+		pageNumber = next
+	}
+
+	return binary.BigEndian.AppendUint32(cell, first)
+}
+
+func headerLen(pageType int) int {
+	if pageType == interiorIndexPage || pageType == interiorTablePage {
+		return 12
+	}
+	return 8
+}
+
+func fits(capacity, pageType int, cells [][]byte) bool {
+	used := headerLen(pageType)
+	for _, cell := range cells {
+		used += 2 + len(cell)
+	}
+	return used <= capacity
+}
+
+// writePage lays out a b-tree page with cells packed against the end of the
+// usable area in reverse order, as SQLite itself writes freshly built pages.
+func (b *builder) writePage(pageNumber uint32, pageType int, cells [][]byte, rightPointer uint32) {
+	page := make([]byte, b.pageSize)
+	if b.pages[pageNumber-1] != nil {
+		copy(page, b.pages[pageNumber-1])
+	}
+
+	offset := 0
+	if pageNumber == 1 {
+		offset = databaseHeaderSize
+	}
+
+	header := page[offset:]
+	header[0] = byte(pageType)
+	binary.BigEndian.PutUint16(header[3:5], uint16(len(cells)))
+	if headerLen(pageType) == 12 {
+		binary.BigEndian.PutUint32(header[8:12], rightPointer)
+	}
+
+	pointer := offset + headerLen(pageType)
+	contentStart := b.usableSize
+	for _, cell := range cells {
+		contentStart -= len(cell)
+		copy(page[contentStart:], cell)
+		binary.BigEndian.PutUint16(page[pointer:], uint16(contentStart))
+		pointer += 2
+	}
+	binary.BigEndian.PutUint16(header[5:7], uint16(contentStart))
+
+	b.pages[pageNumber-1] = page
+}
+
+// placePage assigns a page number: the fixed root for the last remaining
+// page of a tree, or a fresh page otherwise.
+func (b *builder) placePage(isRoot bool, root uint32) uint32 {
+	if isRoot && root != 0 {
+		return root
+	}
+	return b.allocate()
+}
+
+type tableRow struct {
+	rowID  int64
+	record []byte
+}
+
+type tableChild struct {
+	page   uint32
+	maxKey int64
+}
+
+// buildTable bulk loads rows, which must be sorted by rowid, into a table
+// b-tree and returns its root page. A zero root allocates a fresh page.
+func (b *builder) buildTable(rows []tableRow, root uint32, capacity int) uint32 {
+	var leaves [][][]byte
+	var maxKeys []int64
+	var cells [][]byte
+	var lastKey int64
+
+	for _, row := range rows {
+		prefix := appendVarint(nil, uint64(len(row.record)))
+		prefix = appendVarint(prefix, uint64(row.rowID))
+		cell := b.payloadCell(leafTablePage, prefix, row.record)
+
+		if len(cells) > 0 && !fits(capacity, leafTablePage, append(cells, cell)) {
+			leaves = append(leaves, cells)
+			maxKeys = append(maxKeys, lastKey)
+			cells = nil
+		}
+		cells = append(cells, cell)
+		lastKey = row.rowID
+	}
+	leaves = append(leaves, cells)
+	maxKeys = append(maxKeys, lastKey)
+
+	children := make([]tableChild, 0, len(leaves))
+	for i, leaf := range leaves {
+		pageNumber := b.placePage(len(leaves) == 1, root)
+		b.writePage(pageNumber, leafTablePage, leaf, 0)
+		children = append(children, tableChild{page: pageNumber, maxKey: maxKeys[i]})
+	}
+
+	for len(children) > 1 {
+		children = b.tableInteriorLevel(children, root, capacity)
+	}
+	return children[0].page
+}
+
+func (b *builder) tableInteriorLevel(children []tableChild, root uint32, capacity int) []tableChild {
+	var groups [][]tableChild
+	var group []tableChild
+
+	dividers := func(group []tableChild) [][]byte {
+		cells := make([][]byte, 0, len(group))
+		for _, child := range group[:len(group)-1] {
+			cell := binary.BigEndian.AppendUint32(nil, child.page)
+			cells = append(cells, appendVarint(cell, uint64(child.maxKey)))
+		}
+		return cells
+	}
+
+	for _, child := range children {
+		if len(group) > 1 && !fits(capacity, interiorTablePage, dividers(append(group, child))) {
+			groups = append(groups, group)
+			group = nil
+		}
+		group = append(group, child)
+	}
+
+	// Every interior page needs at least one divider cell
+	if len(group) == 1 && len(groups) > 0 {
+		previous := groups[len(groups)-1]
+		groups[len(groups)-1] = previous[:len(previous)-1]
+		group = append([]tableChild{previous[len(previous)-1]}, group...)
+	}
+	groups = append(groups, group)
+
+	parents := make([]tableChild, 0, len(groups))
+	for _, group := range groups {
+		pageNumber := b.placePage(len(groups) == 1, root)
+		b.writePage(pageNumber, interiorTablePage, dividers(group), group[len(group)-1].page)
+		parents = append(parents, tableChild{page: pageNumber, maxKey: group[len(group)-1].maxKey})
+	}
+	return parents
+}
+
+// buildIndex bulk loads sorted index records into an index b-tree. Unlike
+// table trees, each key lives on exactly one page, so the key separating two
+// pages moves up into their parent.
+func (b *builder) buildIndex(records [][]byte, root uint32, capacity int) uint32 {
+	cells := make([][]byte, 0, len(records))
+	for _, record := range records {
+		cells = append(cells, b.payloadCell(leafIndexPage, appendVarint(nil, uint64(len(record))), record))
+	}
+
+	var leaves [][][]byte
+	var separators [][]byte
+	var leaf [][]byte
+
+	for i := 0; i < len(cells); i++ {
+		if len(leaf) == 0 || fits(capacity, leafIndexPage, append(leaf, cells[i])) {
+			leaf = append(leaf, cells[i])
+			continue
+		}
+
+		// The separator must have at least one key to its right
+		if i == len(cells)-1 {
+			separators = append(separators, leaf[len(leaf)-1])
+			leaves = append(leaves, leaf[:len(leaf)-1])
+			leaf = [][]byte{cells[i]}
+			continue
+		}
+
+		separators = append(separators, cells[i])
+		leaves = append(leaves, leaf)
+		leaf = nil
+	}
+	leaves = append(leaves, leaf)
+
+	children := make([]uint32, 0, len(leaves))
+	for _, leaf := range leaves {
+		pageNumber := b.placePage(len(leaves) == 1, root)
+		b.writePage(pageNumber, leafIndexPage, leaf, 0)
+		children = append(children, pageNumber)
+	}
+
+	for len(children) > 1 {
+		children, separators = b.indexInteriorLevel(children, separators, root, capacity)
+	}
+	return children[0]
+}
+
+func (b *builder) indexInteriorLevel(children []uint32, separators [][]byte, root uint32, capacity int) ([]uint32, [][]byte) {
+	type group struct {
+		cells [][]byte
+		right uint32
+	}
+
+	var groups []group
+	var upSeparators [][]byte
+	var current group
+	current.right = children[0]
+
+	for i, separator := range separators {
+		cell := append(binary.BigEndian.AppendUint32(nil, current.right), separator...)
+		if len(current.cells) == 0 || fits(capacity, interiorIndexPage, append(current.cells, cell)) {
+			current.cells = append(current.cells, cell)
+			current.right = children[i+1]
+			continue
+		}
+
+		groups = append(groups, current)
+		upSeparators = append(upSeparators, separator)
+		current = group{right: children[i+1]}
+	}
+
+	// Every interior page needs at least one cell: borrow the previous page's last one
+	if len(current.cells) == 0 && len(groups) > 0 {
+		previous := &groups[len(groups)-1]
+		last := previous.cells[len(previous.cells)-1]
+		previous.cells = previous.cells[:len(previous.cells)-1]
+
+		borrowed := binary.BigEndian.AppendUint32(nil, previous.right)
+		borrowed = append(borrowed, upSeparators[len(upSeparators)-1]...)
+		current.cells = [][]byte{borrowed}
+		previous.right = binary.BigEndian.Uint32(last[0:4])
+		upSeparators[len(upSeparators)-1] = last[4:]
+	}
+	groups = append(groups, current)
+
+	parents := make([]uint32, 0, len(groups))
+	for _, g := range groups {
+		pageNumber := b.placePage(len(groups) == 1, root)
+		b.writePage(pageNumber, interiorIndexPage, g.cells, g.right)
+		parents = append(parents, pageNumber)
+	}
+	return parents, upSeparators
+}
@@ -0,0 +1,200 @@
+package testgen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// appendVarint appends value in SQLite's big-endian varint encoding, where
+// the ninth byte, if present, contributes all 8 bits.
+func appendVarint(buf []byte, value uint64) []byte {
+	if value > 0x00ffffffffffffff {
+		var encoded [9]byte
+		encoded[8] = byte(value)
+		value >>= 8
+		for i := 7; i >= 0; i-- {
+			encoded[i] = byte(value&0x7f) | 0x80
+			value >>= 7
+		}
+		return append(buf, encoded[:]...)
+	}
+
+	var encoded [9]byte
+	n := 0
+	for {
+		encoded[n] = byte(value & 0x7f)
+		n++
+		value >>= 7
+		if value == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		b := encoded[i]
+		if i > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+	}
+	return buf
+}
+
+func varintLen(value uint64) int {
+	return len(appendVarint(nil, value))
+}
+
+// serialType picks the smallest serial type able to hold value along with
+// the big-endian bytes stored in the record body.
+func serialType(value any) (uint64, []byte, error) {
+	switch value := value.(type) {
+	case nil:
+		return 0, nil, nil
+	case int:
+		return serialType(int64(value))
+	case int64:
+		switch {
+		case value == 0:
+			return 8, nil, nil
+		case value == 1:
+			return 9, nil, nil
+		case value >= math.MinInt8 && value <= math.MaxInt8:
+			return 1, []byte{byte(value)}, nil
+		case value >= math.MinInt16 && value <= math.MaxInt16:
+			return 2, binary.BigEndian.AppendUint16(nil, uint16(value)), nil
+		case value >= -1<<23 && value < 1<<23:
+			return 3, binary.BigEndian.AppendUint32(nil, uint32(value))[1:], nil
+		case value >= math.MinInt32 && value <= math.MaxInt32:
+			return 4, binary.BigEndian.AppendUint32(nil, uint32(value)), nil
+		case value >= -1<<47 && value < 1<<47:
+			return 5, binary.BigEndian.AppendUint64(nil, uint64(value))[2:], nil
+		default:
+			return 6, binary.BigEndian.AppendUint64(nil, uint64(value)), nil
+		}
+	case float64:
+		return 7, binary.BigEndian.AppendUint64(nil, math.Float64bits(value)), nil
+	case string:
+		return uint64(len(value))*2 + 13, []byte(value), nil
+	case []byte:
+		return uint64(len(value))*2 + 12, value, nil
+	default:
+		return 0, nil, fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// EncodeRecord encodes values in the SQLite record format. Values may be nil,
+// int, int64, float64, string, or []byte.
+func EncodeRecord(values ...any) ([]byte, error) {
+	var types []uint64
+	var body []byte
+	headerBodySize := 0
+
+	for _, value := range values {
+		serial, raw, err := serialType(value)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, serial)
+		headerBodySize += varintLen(serial)
+		body = append(body, raw...)
+	}
+
+	// The header size counts its own varint, which may grow the size itself
+	headerSize := headerBodySize + 1
+	for varintLen(uint64(headerSize)) != headerSize-headerBodySize {
+		headerSize = headerBodySize + varintLen(uint64(headerSize))
+	}
+
+	record := appendVarint(make([]byte, 0, headerSize+len(body)), uint64(headerSize))
+	for _, serial := range types {
+		record = appendVarint(record, serial)
+	}
+	return append(record, body...), nil
+}
+
+// storageClass orders values the way SQLite sorts them: NULL, then numbers,
+// then text, then blobs.
+func storageClass(value any) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case int, int64, float64:
+		return 1
+	case string:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func numeric(value any) float64 {
+	switch value := value.(type) {
+	case int:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case float64:
+		return value
+	}
+	return 0
+}
+
+// compareValues compares two values using SQLite's cross-type ordering and
+// the BINARY collation for text. It is kept apart from db.CompareValues,
+// which the fixtures built here are used to test, and which could not be
+// imported without a cycle through db's own tests.
+func compareValues(a, b any) int {
+	if ca, cb := storageClass(a), storageClass(b); ca != cb {
+		return ca - cb
+	}
+
+	switch a := a.(type) {
+	case nil:
+		return 0
+	case string:
+		return bytes.Compare([]byte(a), []byte(b.(string)))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	}
+
+	ia, aInt := asInt64(a)
+	ib, bInt := asInt64(b)
+	if aInt && bInt {
+		switch {
+		case ia < ib:
+			return -1
+		case ia > ib:
+			return 1
+		}
+		return 0
+	}
+
+	fa, fb := numeric(a), numeric(b)
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	return 0
+}
+
+func asInt64(value any) (int64, bool) {
+	switch value := value.(type) {
+	case int:
+		return int64(value), true
+	case int64:
+		return value, true
+	}
+	return 0, false
+}
+
+func compareKeys(a, b []any) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
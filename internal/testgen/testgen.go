@@ -0,0 +1,246 @@
+// Package testgen builds SQLite database files from Go so tests can cover
+// multi-page b-trees, indexes, overflow chains, and header variants without
+// checking in binary fixtures.
+package testgen
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const databaseHeaderSize = 100
+
+// sqliteVersion is recorded as the library version that last wrote the file.
+const sqliteVersion = 3046000
+
+type Options struct {
+	// PageSize must be a power of two between 512 and 65536; zero means 4096.
+	PageSize int
+	// ReservedBytes is the unused space at the end of every page.
+	ReservedBytes int
+	// WAL marks the file as being in WAL journal mode.
+	WAL bool
+	// UserVersion is stored in the header's user version field.
+	UserVersion uint32
+	// SchemaFormat is stored in the header's schema format field; zero
+	// means 4. Indexes are built ascending whatever it is.
+	SchemaFormat uint32
+}
+
+type Database struct {
+	options Options
+	tables  []*Table
+	indexes []*Index
+}
+
+type Table struct {
+	Name string
+	SQL  string
+	rows map[int64][]any
+}
+
+type Index struct {
+	Name    string
+	Table   *Table
+	SQL     string
+	columns []int
+}
+
+func New(options Options) *Database {
+	if options.PageSize == 0 {
+		options.PageSize = 4096
+	}
+	return &Database{options: options}
+}
+
+// CreateTable adds a rowid table. The SQL is stored in sqlite_schema verbatim
+// and should match the values later inserted.
+func (database *Database) CreateTable(name, sql string) *Table {
+	table := &Table{Name: name, SQL: sql, rows: make(map[int64][]any)}
+	database.tables = append(database.tables, table)
+	return table
+}
+
+// CreateIndex adds an index on the given zero-based column positions of
+// table, populated from the table's rows when the file is built.
+func (database *Database) CreateIndex(name string, table *Table, sql string, columns ...int) *Index {
+	index := &Index{Name: name, Table: table, SQL: sql, columns: columns}
+	database.indexes = append(database.indexes, index)
+	return index
+}
+
+// Insert stores a row under rowid, replacing any previous row with that
+// rowid. Columns aliasing the rowid (INTEGER PRIMARY KEY) should be nil, as
+// SQLite stores them.
+func (table *Table) Insert(rowID int64, values ...any) {
+	table.rows[rowID] = values
+}
+
+// Bytes lays out the database file.
+func (database *Database) Bytes() ([]byte, error) {
+	pageSize := database.options.PageSize
+	if pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid page size %d", pageSize)
+	}
+
+	b := &builder{pageSize: pageSize, usableSize: pageSize - database.options.ReservedBytes}
+	if b.usableSize < 480 {
+		return nil, fmt.Errorf("usable size %d below minimum 480", b.usableSize)
+	}
+	b.allocate()
+
+	var schema []tableRow
+	addSchemaRow := func(objectType, name, tableName string, rootPage uint32, sql string) error {
+		record, err := EncodeRecord(objectType, name, tableName, int64(rootPage), sql)
+		if err != nil {
+			return err
+		}
+		schema = append(schema, tableRow{rowID: int64(len(schema) + 1), record: record})
+		return nil
+	}
+
+	capacity := b.usableSize
+	for _, table := range database.tables {
+		rows, err := table.records()
+		if err != nil {
+			return nil, fmt.Errorf("table %s: %w", table.Name, err)
+		}
+		root := b.buildTable(rows, 0, capacity)
+		if err := addSchemaRow("table", table.Name, table.Name, root, table.SQL); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, index := range database.indexes {
+		records, err := index.records()
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", index.Name, err)
+		}
+		root := b.buildIndex(records, 0, capacity)
+		if err := addSchemaRow("index", index.Name, index.Table.Name, root, index.SQL); err != nil {
+			return nil, err
+		}
+	}
+
+	// Every schema page is sized as if it were page 1, which loses the first
+	// bytes to the database header, so any of them can become the root
+	b.buildTable(schema, 1, b.usableSize-databaseHeaderSize)
+
+	file := make([]byte, 0, len(b.pages)*pageSize)
+	for _, page := range b.pages {
+		file = append(file, page...)
+	}
+	database.writeHeader(file[:databaseHeaderSize], uint32(len(b.pages)))
+	return file, nil
+}
+
+func (database *Database) writeHeader(header []byte, pageCount uint32) {
+	copy(header, "SQLite format 3\x00")
+
+	pageSize := database.options.PageSize
+	if pageSize == 65536 {
+		pageSize = 1
+	}
+	binary.BigEndian.PutUint16(header[16:18], uint16(pageSize))
+
+	fileFormat := byte(1)
+	if database.options.WAL {
+		fileFormat = 2
+	}
+	header[18] = fileFormat
+	header[19] = fileFormat
+	header[20] = byte(database.options.ReservedBytes)
+	header[21] = 64
+	header[22] = 32
+	header[23] = 32
+
+	const changeCounter = 1
+	binary.BigEndian.PutUint32(header[24:28], changeCounter)
+	binary.BigEndian.PutUint32(header[28:32], pageCount)
+	binary.BigEndian.PutUint32(header[40:44], 1)
+	schemaFormat := database.options.SchemaFormat
+	if schemaFormat == 0 {
+		schemaFormat = 4
+	}
+	binary.BigEndian.PutUint32(header[44:48], schemaFormat)
+	binary.BigEndian.PutUint32(header[56:60], 1)
+	binary.BigEndian.PutUint32(header[60:64], database.options.UserVersion)
+	binary.BigEndian.PutUint32(header[92:96], changeCounter)
+	binary.BigEndian.PutUint32(header[96:100], sqliteVersion)
+}
+
+// Write saves the database file at path.
+func (database *Database) Write(path string) error {
+	contents, err := database.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, contents, 0o644)
+}
+
+// WriteTemp saves the database in a test-scoped temporary directory and
+// returns its path, failing the test on error.
+func (database *Database) WriteTemp(tb testing.TB) string {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "testgen.db")
+	if err := database.Write(path); err != nil {
+		tb.Fatalf("writing generated database: %v", err)
+	}
+	return path
+}
+
+func (table *Table) sortedRowIDs() []int64 {
+	rowIDs := make([]int64, 0, len(table.rows))
+	for rowID := range table.rows {
+		rowIDs = append(rowIDs, rowID)
+	}
+	slices.Sort(rowIDs)
+	return rowIDs
+}
+
+func (table *Table) records() ([]tableRow, error) {
+	rows := make([]tableRow, 0, len(table.rows))
+	for _, rowID := range table.sortedRowIDs() {
+		record, err := EncodeRecord(table.rows[rowID]...)
+		if err != nil {
+			return nil, fmt.Errorf("rowid %d: %w", rowID, err)
+		}
+		rows = append(rows, tableRow{rowID: rowID, record: record})
+	}
+	return rows, nil
+}
+
+// records returns the index keys, each suffixed with its rowid, in key order.
+func (index *Index) records() ([][]byte, error) {
+	var keys [][]any
+	for _, rowID := range index.Table.sortedRowIDs() {
+		values := index.Table.rows[rowID]
+
+		key := make([]any, 0, len(index.columns)+1)
+		for _, column := range index.columns {
+			var value any
+			if column < len(values) {
+				value = values[column]
+			}
+			key = append(key, value)
+		}
+		keys = append(keys, append(key, rowID))
+	}
+
+	slices.SortStableFunc(keys, compareKeys)
+
+	records := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		record, err := EncodeRecord(key...)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,149 @@
+package testgen
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+// sqlite3 runs a query through the system sqlite3, skipping the test when it
+// is not installed.
+func sqlite3(t *testing.T, path, query string) string {
+	t.Helper()
+
+	binary, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 not found in PATH")
+	}
+
+	output, err := exec.Command(binary, path, query).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3 %q: %v\n%s", query, err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func fruitDatabase(options Options, rows int, blobSize int) *Database {
+	database := New(options)
+	fruits := database.CreateTable("fruits", "CREATE TABLE fruits (id integer primary key, name text, weight real, photo blob)")
+	for i := 1; i <= rows; i++ {
+		var photo []byte
+		if blobSize > 0 {
+			photo = bytes.Repeat([]byte{byte(i)}, blobSize)
+		}
+		fruits.Insert(int64(i), nil, fmt.Sprintf("fruit-%05d", rows-i), float64(i)/4, photo)
+	}
+	database.CreateIndex("idx_fruits_name", fruits, "CREATE INDEX idx_fruits_name on fruits (name)", 1)
+	return database
+}
+
+func TestGeneratedDatabasesPassIntegrityCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		database *Database
+	}{
+		{"empty", New(Options{})},
+		{"single leaf", fruitDatabase(Options{}, 10, 0)},
+		{"multi-level", fruitDatabase(Options{PageSize: 512}, 5000, 0)},
+		{"overflow", fruitDatabase(Options{PageSize: 1024}, 50, 5000)},
+		{"reserved bytes", fruitDatabase(Options{PageSize: 1024, ReservedBytes: 32}, 500, 700)},
+		{"large pages", fruitDatabase(Options{PageSize: 65536}, 2000, 100)},
+		{"wal", fruitDatabase(Options{WAL: true}, 100, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.database.WriteTemp(t)
+			if result := sqlite3(t, path, "PRAGMA integrity_check"); result != "ok" {
+				t.Fatalf("integrity check failed:\n%s", result)
+			}
+		})
+	}
+}
+
+func TestGeneratedRowsReadBackThroughSQLite3(t *testing.T) {
+	const rows = 3000
+	path := fruitDatabase(Options{PageSize: 512}, rows, 0).WriteTemp(t)
+
+	if count := sqlite3(t, path, "SELECT count(*) FROM fruits"); count != fmt.Sprint(rows) {
+		t.Fatalf("unexpected row count: got %s, want %d", count, rows)
+	}
+
+	// The index is ordered opposite to rowid, so this exercises an index seek
+	if rowID := sqlite3(t, path, "SELECT id FROM fruits INDEXED BY idx_fruits_name WHERE name = 'fruit-00000'"); rowID != fmt.Sprint(rows) {
+		t.Fatalf("unexpected rowid from index lookup: got %s, want %d", rowID, rows)
+	}
+
+	if mode := sqlite3(t, fruitDatabase(Options{WAL: true}, 1, 0).WriteTemp(t), "PRAGMA journal_mode"); mode != "wal" {
+		t.Fatalf("unexpected journal mode: got %s, want wal", mode)
+	}
+}
+
+func TestManySchemaObjectsSpillPastPageOne(t *testing.T) {
+	database := New(Options{PageSize: 512})
+	for i := range 200 {
+		table := database.CreateTable(fmt.Sprintf("t%03d", i), fmt.Sprintf("CREATE TABLE t%03d (a integer, b text)", i))
+		table.Insert(1, int64(i), "x")
+	}
+	path := database.WriteTemp(t)
+
+	dbFile, header, err := db.OpenDatabaseFile(path)
+	if err != nil {
+		t.Fatalf("opening generated database: %v", err)
+	}
+	defer dbFile.Close()
+
+	schemaPage, err := dbFile.NewPage(header, 1)
+	if err != nil {
+		t.Fatalf("reading page 1: %v", err)
+	}
+	if schemaPage.PageType != db.InteriorTable {
+		t.Fatalf("unexpected schema root type: got %d, want %d", schemaPage.PageType, db.InteriorTable)
+	}
+
+	if count := sqlite3(t, path, "SELECT count(*) FROM sqlite_schema"); count != "200" {
+		t.Fatalf("unexpected schema object count: got %s, want 200", count)
+	}
+}
+
+func TestGeneratedLeafRowsDecode(t *testing.T) {
+	database := New(Options{})
+	apples := database.CreateTable("apples", "CREATE TABLE apples (id integer primary key, name text, color text)")
+	apples.Insert(1, nil, "Granny Smith", "Light Green")
+	apples.Insert(1<<40, nil, "Fuji", "Red")
+	apples.Insert(-7, nil, "Honeycrisp", "Blush Red")
+
+	dbFile, header, err := db.OpenDatabaseFile(database.WriteTemp(t))
+	if err != nil {
+		t.Fatalf("opening generated database: %v", err)
+	}
+	defer dbFile.Close()
+
+	page, err := dbFile.NewPage(header, 2)
+	if err != nil {
+		t.Fatalf("reading table page: %v", err)
+	}
+
+	rows, err := db.ReadAllRows(page)
+	if err != nil {
+		t.Fatalf("reading rows: %v", err)
+	}
+
+	expectedRowIDs := []int64{-7, 1, 1 << 40}
+	expectedNames := []string{"Honeycrisp", "Granny Smith", "Fuji"}
+	if len(rows) != len(expectedRowIDs) {
+		t.Fatalf("unexpected row count: got %d, want %d", len(rows), len(expectedRowIDs))
+	}
+	for i, row := range rows {
+		if row.RowID != expectedRowIDs[i] {
+			t.Fatalf("row %d unexpected rowid: got %d, want %d", i, row.RowID, expectedRowIDs[i])
+		}
+		if name := row.Columns[1].DecodedValue.(string); name != expectedNames[i] {
+			t.Fatalf("row %d unexpected name: got %q, want %q", i, name, expectedNames[i])
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Package sqlitefile reads SQLite database files at the level of the file
+// format: the header, b-tree pages, the schema table and the rows and index
+// entries of each b-tree, walked with a Cursor. It is the reader the SQL
+// engine is built on, without the engine, so a program that only needs to
+// read files does not build the SQL parser or anything else the engine
+// uses; nothing here imports more than the standard library.
+//
+// Files are opened read-only. A rollback journal left by an interrupted
+// transaction is not replayed, but a write-ahead log is read; to see what
+// other processes commit meanwhile, take LockShared around a set of reads
+// and call Refresh and then NewDatabaseHeader after it, as the engine does
+// before each statement.
+package sqlitefile
+
+import (
+	"io"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/db"
+)
+
+type (
+	// File is an open database file. Its methods read pages, the schema
+	// and b-trees: ReadSchema, NewPage, NewCursor, SeekRowID and CountRows
+	File = db.DatabaseFile
+	// Header is the database header, the first 100 bytes of the file
+	Header = db.DatabaseHeader
+	// Page is a b-tree page as read from the file
+	Page = db.Page
+	// Cursor walks the entries of a table or index b-tree in key order
+	Cursor = db.Cursor
+	// Row is a table b-tree entry: its rowid and its record's columns
+	Row = db.Row
+	// Column is one decoded value of a record, with its serial type
+	Column = db.Column
+	// IndexCell is an index b-tree entry
+	IndexCell = db.IndexCell
+	// SchemaObject is a row of the schema table: a table, index, view or
+	// trigger, its root page and the SQL that created it
+	SchemaObject = db.TableMetadata
+	// Value is a decoded value: nil, int64, float64, string or []byte
+	Value = db.Value
+	// Codec decodes the pages of an encrypted file as they are read
+	Codec = db.Codec
+	// CorruptionError reports a page that does not follow the file format
+	CorruptionError = db.CorruptionError
+)
+
+// Open opens the database at path for reading and reads its header.
+func Open(path string) (*File, *Header, error) {
+	return db.OpenDatabaseFile(path)
+}
+
+// OpenReader opens the database whose size bytes reader holds, for reading,
+// where there is no file to open, as with GOOS=js. name only names it.
+func OpenReader(reader io.ReaderAt, size int64, name string) (*File, *Header, error) {
+	return db.OpenReader(reader, size, name)
+}
+
+// OpenEncrypted opens the encrypted database at path for reading, decoding
+// its pages with codec.
+func OpenEncrypted(path string, codec Codec) (*File, *Header, error) {
+	return db.OpenEncryptedDatabaseFile(path, codec)
+}
+
+// NewSQLCipherCodec returns a Codec for files SQLCipher encrypted with
+// passphrase and the defaults of its major version 3 or 4; 0 means 4.
+func NewSQLCipherCodec(passphrase string, version int) (Codec, error) {
+	return db.NewSQLCipherCodec(passphrase, version)
+}
+
+// RootPage returns the root page of the named table's b-tree among the
+// schema objects ReadSchema returns.
+func RootPage(objects []SchemaObject, table string) (uint32, error) {
+	return db.RootPageLookup(table, objects)
+}
@@ -0,0 +1,139 @@
+package sqlitefile_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/testgen"
+	"github.com/codecrafters-io/sqlite-starter-go/sqlitefile"
+)
+
+func TestReadRows(t *testing.T) {
+	database := testgen.New(testgen.Options{PageSize: 512})
+	items := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text)")
+	for i := int64(1); i <= 100; i++ {
+		items.Insert(i, nil, fmt.Sprintf("item-%03d", i))
+	}
+	file, header, err := sqlitefile.Open(database.WriteTemp(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	objects, err := file.ReadSchema(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := sqlitefile.RootPage(objects, "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := file.NewCursor(header, root)
+	var names []string
+	for err = cursor.First(); err == nil && cursor.Valid(); err = cursor.Next() {
+		row, err := cursor.Row()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, row.Columns[1].DecodedValue.(string))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 100 || names[0] != "item-001" || names[99] != "item-100" {
+		t.Errorf("read %d names: %v ... %v", len(names), names[:1], names[len(names)-1:])
+	}
+}
+
+func TestOpenReader(t *testing.T) {
+	database := testgen.New(testgen.Options{PageSize: 1024})
+	items := database.CreateTable("items", "CREATE TABLE items (id integer primary key, name text)")
+	for i := int64(1); i <= 300; i++ {
+		items.Insert(i, nil, fmt.Sprintf("item-%03d", i))
+	}
+	contents, err := database.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, header, err := sqlitefile.OpenReader(bytes.NewReader(contents), int64(len(contents)), "upload.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	objects, err := file.ReadSchema(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := sqlitefile.RootPage(objects, "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, err := file.CountRows(header, root); err != nil || count != 300 {
+		t.Errorf("counted %d rows: %v", count, err)
+	}
+	cursor := file.NewCursor(header, root)
+	if err := cursor.SeekRowID(275); err != nil {
+		t.Fatal(err)
+	}
+	row, err := cursor.Row()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := row.Columns[1].DecodedValue; name != "item-275" {
+		t.Errorf("row 275 has name %v", name)
+	}
+	if _, err := file.WriteAt([]byte{0}, 0); err == nil {
+		t.Error("wrote to a database opened from a reader")
+	}
+
+	// A reader holding less than the header is no database
+	if _, _, err := sqlitefile.OpenReader(bytes.NewReader(contents[:50]), 50, "short.db"); err == nil {
+		t.Error("opened a truncated header")
+	}
+}
+
+func TestBuildsForWebAssembly(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not in PATH")
+	}
+	if testing.Short() {
+		t.Skip("cross-compiling is slow")
+	}
+	for _, target := range []struct{ goos, packages string }{
+		{"js", ". ../internal/engine ../wasm"},
+		{"wasip1", ". ../internal/engine"},
+	} {
+		command := exec.Command(goTool, append([]string{"build"}, strings.Fields(target.packages)...)...)
+		command.Env = append(os.Environ(), "GOOS="+target.goos, "GOARCH=wasm")
+		if output, err := command.CombinedOutput(); err != nil {
+			t.Errorf("GOOS=%s build: %v\n%s", target.goos, err, output)
+		}
+	}
+}
+
+func TestDoesNotBuildTheSQLParser(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not in PATH")
+	}
+	output, err := exec.Command(goTool, "list", "-deps", ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("go list: %v\n%s", err, output)
+	}
+	for _, dependency := range strings.Fields(string(output)) {
+		// Standard library paths have no dot in their first element
+		host, _, _ := strings.Cut(dependency, "/")
+		if strings.Contains(host, ".") && !strings.HasPrefix(dependency, "github.com/codecrafters-io/sqlite-starter-go/") {
+			t.Errorf("sqlitefile depends on %s", dependency)
+		}
+		if strings.HasSuffix(dependency, "/internal/engine") {
+			t.Errorf("sqlitefile depends on the engine")
+		}
+	}
+}
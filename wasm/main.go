@@ -0,0 +1,145 @@
+//go:build js && wasm
+
+// Command wasm runs the engine in a browser, on database files the user
+// picks, read where they lie in JavaScript memory rather than copied into
+// Go's. Build it, then serve it with the demo page beside it:
+//
+//	GOOS=js GOARCH=wasm go build -o wasm/sqlite.wasm ./wasm
+//	cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" wasm/
+//	python3 -m http.server -d wasm
+//
+// It defines three functions on the global object. sqliteOpen(bytes, name)
+// opens the database in a Uint8Array, read-only, and returns a handle;
+// sqliteQuery(handle, sql) runs a statement and returns {columns, rows}, or
+// {error} when it fails; sqliteClose(handle) closes the database.
+package main
+
+import (
+	"io"
+	"math"
+	"syscall/js"
+
+	"github.com/codecrafters-io/sqlite-starter-go/internal/engine"
+)
+
+// uint8ArrayReader reads a JavaScript Uint8Array, copying only the bytes
+// each read asks for.
+type uint8ArrayReader struct {
+	array js.Value
+	size  int64
+}
+
+func (reader uint8ArrayReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= reader.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), reader.size)
+	n := js.CopyBytesToGo(p, reader.array.Call("subarray", off, end))
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+var (
+	databases = make(map[int]*engine.Database)
+	handles   int
+)
+
+func main() {
+	js.Global().Set("sqliteOpen", js.FuncOf(open))
+	js.Global().Set("sqliteQuery", js.FuncOf(query))
+	js.Global().Set("sqliteClose", js.FuncOf(closeDatabase))
+	select {}
+}
+
+// open opens the database in args[0], a Uint8Array, named args[1].
+func open(this js.Value, args []js.Value) any {
+	if len(args) < 1 || args[0].Type() != js.TypeObject {
+		return failure("usage: sqliteOpen(bytes, name)")
+	}
+	name := "upload.db"
+	if len(args) > 1 && args[1].Type() == js.TypeString {
+		name = args[1].String()
+	}
+	reader := uint8ArrayReader{array: args[0], size: int64(args[0].Get("length").Int())}
+	database, err := engine.OpenReader(reader, reader.size, name)
+	if err != nil {
+		return failure(err.Error())
+	}
+	handles++
+	databases[handles] = database
+	return handles
+}
+
+// query runs the statement args[1] on the database with handle args[0].
+func query(this js.Value, args []js.Value) any {
+	if len(args) < 2 {
+		return failure("usage: sqliteQuery(handle, sql)")
+	}
+	database, ok := databases[args[0].Int()]
+	if !ok {
+		return failure("no such database handle")
+	}
+	resultSet, err := database.Query(args[1].String())
+	if err != nil {
+		return failure(err.Error())
+	}
+	rows, err := resultSet.All()
+	if err != nil {
+		return failure(err.Error())
+	}
+
+	columns := make([]any, len(resultSet.Columns))
+	for i, column := range resultSet.Columns {
+		columns[i] = column.Name
+	}
+	values := make([]any, len(rows))
+	for i, row := range rows {
+		converted := make([]any, len(row))
+		for j, value := range row {
+			converted[j] = jsValue(value)
+		}
+		values[i] = converted
+	}
+	return map[string]any{"columns": columns, "rows": values}
+}
+
+// closeDatabase closes the database with handle args[0].
+func closeDatabase(this js.Value, args []js.Value) any {
+	if len(args) < 1 {
+		return nil
+	}
+	if database, ok := databases[args[0].Int()]; ok {
+		database.Close()
+		delete(databases, args[0].Int())
+	}
+	return nil
+}
+
+// jsValue converts a column value for JavaScript: integers past the range
+// a double holds exactly become strings, and blobs Uint8Arrays.
+func jsValue(value any) any {
+	switch value := value.(type) {
+	case int64:
+		if value > 1<<53 || value < -(1<<53) {
+			return js.ValueOf(value).Call("toString")
+		}
+		return float64(value)
+	case float64:
+		if math.IsInf(value, 0) || math.IsNaN(value) {
+			return js.ValueOf(value)
+		}
+		return value
+	case []byte:
+		array := js.Global().Get("Uint8Array").New(len(value))
+		js.CopyBytesToJS(array, value)
+		return array
+	}
+	return value
+}
+
+// failure is what a function returns when it fails.
+func failure(message string) any {
+	return map[string]any{"error": message}
+}